@@ -17,6 +17,9 @@ limitations under the License.
 package v1alpha1
 
 import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -30,22 +33,258 @@ type DriverUpgradePolicySpec struct {
 	// +optional
 	// +kubebuilder:default:=false
 	AutoUpgrade bool `json:"autoUpgrade,omitempty"`
+	// Paused stops the upgrade without deleting the policy, e.g. during an incident: no node changes upgrade state
+	// and no node is cordoned, drained or uncordoned by the upgrade passes. The drains already in progress are
+	// completed. The upgrade continues where it stopped once Paused is unset.
+	// +optional
+	// +kubebuilder:default:=false
+	Paused bool `json:"paused,omitempty"`
 	// MaxParallelUpgrades indicates how many nodes can be upgraded in parallel
 	// 0 means no limit, all nodes will be upgraded in parallel
 	// +optional
 	// +kubebuilder:default:=1
 	// +kubebuilder:validation:Minimum:=0
 	MaxParallelUpgrades int `json:"maxParallelUpgrades,omitempty"`
+	// MaxParallelUpgradesPerZone indicates how many nodes of an availability zone, given by the
+	// topology.kubernetes.io/zone node label, can be upgraded in parallel, on top of MaxParallelUpgrades.
+	// The nodes without the zone label are counted as one zone.
+	// 0 means no limit per zone
+	// +optional
+	// +kubebuilder:default:=0
+	// +kubebuilder:validation:Minimum:=0
+	MaxParallelUpgradesPerZone int `json:"maxParallelUpgradesPerZone,omitempty"`
+	// AdaptiveParallelism enables progressive ramp-up of the parallel upgrades: the upgrade starts on a single node,
+	// the count of parallel upgrades is doubled after each batch of nodes completes the upgrade without failures,
+	// up to MaxParallelUpgrades, and is halved when an upgrade fails
+	// +optional
+	// +kubebuilder:default:=false
+	AdaptiveParallelism bool `json:"adaptiveParallelism,omitempty"`
 	// MaxUnavailable is the maximum number of nodes with the driver installed, that can be unavailable during the upgrade.
 	// Value can be an absolute number (ex: 5) or a percentage of total nodes at the start of upgrade (ex: 10%).
 	// Absolute number is calculated from percentage by rounding up.
 	// By default, a fixed value of 25% is used.
 	// +optional
 	// +kubebuilder:default:="25%"
-	MaxUnavailable    *intstr.IntOrString    `json:"maxUnavailable,omitempty"`
-	PodDeletion       *PodDeletionSpec       `json:"podDeletion,omitempty"`
-	WaitForCompletion *WaitForCompletionSpec `json:"waitForCompletion,omitempty"`
-	DrainSpec         *DrainSpec             `json:"drain,omitempty"`
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+	// ClusterMaxUnavailable is the maximum number of nodes in the cluster, with or without the driver installed,
+	// that can be unavailable, i.e. cordoned or not Ready for any reason, when a node upgrade is started.
+	// Unlike MaxUnavailable, it also counts the nodes unavailable for reasons unrelated to the driver upgrade.
+	// Value can be an absolute number (ex: 5) or a percentage of all the nodes in the cluster (ex: 10%).
+	// Absolute number is calculated from percentage by rounding up.
+	// If not set, the unavailable nodes of the cluster don't limit the upgrade.
+	// +optional
+	ClusterMaxUnavailable *intstr.IntOrString    `json:"clusterMaxUnavailable,omitempty"`
+	PodDeletion           *PodDeletionSpec       `json:"podDeletion,omitempty"`
+	WaitForCompletion     *WaitForCompletionSpec `json:"waitForCompletion,omitempty"`
+	DrainSpec             *DrainSpec             `json:"drain,omitempty"`
+	// NodeReadyTimeoutSecond specifies the length of time in seconds to wait for the node to become Ready
+	// after the driver pod restart, e.g. when the node is rebooted, before the node is moved to the upgrade-failed
+	// state, zero means infinite
+	// +optional
+	// +kubebuilder:default:=0
+	// +kubebuilder:validation:Minimum:=0
+	NodeReadyTimeoutSecond int `json:"nodeReadyTimeoutSeconds,omitempty"`
+	// UncordonFailedNodes controls whether the nodes in the upgrade-failed state are uncordoned to restore their
+	// capacity. By default they are left cordoned, which is the safe choice when the driver is broken.
+	// Nodes which were unschedulable at the beginning of the upgrade are always left cordoned.
+	// +optional
+	// +kubebuilder:default:=false
+	UncordonFailedNodes bool `json:"uncordonFailedNodes,omitempty"`
+	// NodeStateTimeoutSeconds specifies, per upgrade state, the length of time in seconds a node can stay in the
+	// state before it is moved to the upgrade-failed state, e.g. {"wait-for-jobs-required": 3600}.
+	// States without a timeout or with a zero timeout are not limited. The upgrade-required, upgrade-done
+	// and upgrade-failed states can't be limited.
+	// +optional
+	NodeStateTimeoutSeconds map[string]int `json:"nodeStateTimeoutSeconds,omitempty"`
+	// Retry enables the automatic retry of the node upgrades which failed. The failed nodes are moved back to
+	// the upgrade-required state after a backoff, until the attempts are exhausted. If not set, the nodes stay
+	// in the upgrade-failed state until the driver pod recovers.
+	// +optional
+	Retry *RetrySpec `json:"retry,omitempty"`
+	// Downgrade describes the handling of the driver downgrades, e.g. a rollback of the driver DaemonSet.
+	// If not set, downgrades are handled like upgrades.
+	// +optional
+	Downgrade *DowngradeSpec `json:"downgrade,omitempty"`
+	// Schedule restricts the start of node upgrades to maintenance windows.
+	// Nodes are not moved out of the upgrade-required state outside the windows, the upgrades already started
+	// are completed. If not set, upgrades can start at any time.
+	// +optional
+	Schedule *UpgradeScheduleSpec `json:"schedule,omitempty"`
+	// BlackoutPeriods are the date ranges in which no node upgrade is started, e.g. an end-of-quarter freeze.
+	// They take precedence over the maintenance windows of Schedule, the upgrades already started are completed.
+	// +optional
+	BlackoutPeriods []BlackoutPeriodSpec `json:"blackoutPeriods,omitempty"`
+	// HelperWorkloads describes the scheduling of the helper workloads run on the nodes being upgraded,
+	// e.g. validation pods, so that they can run on tainted or pressured nodes
+	// +optional
+	HelperWorkloads *HelperWorkloadSpec `json:"helperWorkloads,omitempty"`
+	// PDBAwareSelection enables the selection of the nodes to upgrade by the PodDisruptionBudgets of their pods:
+	// nodes whose drain is allowed by the budgets are started before the nodes whose drain would be blocked.
+	// If not set, the nodes are started in order.
+	// +optional
+	PDBAwareSelection *PDBAwareSelectionSpec `json:"pdbAwareSelection,omitempty"`
+	// NodeHealthSelection skips the nodes with the given conditions or taints when the nodes to upgrade are
+	// selected, as the drain of such nodes tends to wedge. If not set, the nodes are started regardless of their
+	// conditions and taints.
+	// +optional
+	NodeHealthSelection *NodeHealthSelectionSpec `json:"nodeHealthSelection,omitempty"`
+	// Canary enables the canary phase of the upgrade: a few canary nodes are upgraded first, the other nodes
+	// are upgraded only once the new driver pods of the canary nodes stayed ready for the soak period
+	// +optional
+	Canary *CanarySpec `json:"canary,omitempty"`
+	// Validation describes the validation Job run on every upgraded node in the validation-required state,
+	// e.g. a GPU smoke test, before the node is uncordoned. Setting it enables the validation-required state.
+	// +optional
+	Validation *ValidationSpec `json:"validation,omitempty"`
+}
+
+// ValidationFailurePolicy describes how a failed validation of an upgraded node is handled
+// +kubebuilder:validation:Enum=Fail;Ignore
+type ValidationFailurePolicy string
+
+const (
+	// ValidationFailurePolicyFail moves the node to the upgrade-failed state
+	ValidationFailurePolicyFail ValidationFailurePolicy = "Fail"
+	// ValidationFailurePolicyIgnore reports the failure with an event and completes the upgrade of the node
+	ValidationFailurePolicyIgnore ValidationFailurePolicy = "Ignore"
+)
+
+// ValidationSpec describes the validation of the upgraded nodes by Jobs run on the nodes
+type ValidationSpec struct {
+	// JobTemplate is the template of the validation Job run on every node under validation. The pods of the Job
+	// are pinned to the node. The namespace of the template must be set.
+	// +optional
+	JobTemplate *batchv1.JobTemplateSpec `json:"jobTemplate,omitempty"`
+	// TimeoutSecond specifies the length of time in seconds the validators may take on a node before
+	// the validation is considered failed, zero means 600 seconds
+	// +optional
+	// +kubebuilder:default:=600
+	// +kubebuilder:validation:Minimum:=0
+	TimeoutSecond int `json:"timeoutSeconds,omitempty"`
+	// FailurePolicy is the handling of a failed or timed out validation
+	// +optional
+	// +kubebuilder:default:=Fail
+	FailurePolicy ValidationFailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// CanarySpec describes the canary phase of the upgrade
+type CanarySpec struct {
+	// NodeSelector is the label selector of the nodes the canary nodes are chosen from, all the nodes if empty
+	// +optional
+	NodeSelector string `json:"nodeSelector,omitempty"`
+	// Count is the count of canary nodes, the nodes matching NodeSelector are chosen in the order of their names
+	// +kubebuilder:validation:Minimum:=1
+	Count int `json:"count"`
+	// SoakSeconds specifies the length of time in seconds the driver pods of all the canary nodes must stay ready
+	// after their upgrade before the upgrade of the other nodes starts
+	// +optional
+	// +kubebuilder:default:=0
+	// +kubebuilder:validation:Minimum:=0
+	SoakSeconds int `json:"soakSeconds,omitempty"`
+}
+
+// PDBAwareSelectionSpec describes the selection of the nodes to upgrade by the PodDisruptionBudgets of their pods
+type PDBAwareSelectionSpec struct {
+	// DeferBlockedNodes indicates if the nodes whose drain would be blocked by a PodDisruptionBudget wait
+	// in the upgrade-required state until the budget allows the drain, instead of being started after the other
+	// nodes
+	// +optional
+	// +kubebuilder:default:=false
+	DeferBlockedNodes bool `json:"deferBlockedNodes,omitempty"`
+}
+
+// NodeHealthSelectionSpec describes the nodes skipped by the selection of the nodes to upgrade.
+// If both Conditions and TaintKeys are empty, the nodes with the DiskPressure or MemoryPressure condition are skipped.
+type NodeHealthSelectionSpec struct {
+	// Conditions are the types of the node conditions which skip the node while their status is True
+	// +optional
+	Conditions []corev1.NodeConditionType `json:"conditions,omitempty"`
+	// TaintKeys are the keys of the node taints which skip the node, whatever their value and effect
+	// +optional
+	TaintKeys []string `json:"taintKeys,omitempty"`
+}
+
+// HelperWorkloadSpec describes the scheduling of the helper workloads run on the nodes being upgraded
+type HelperWorkloadSpec struct {
+	// PriorityClassName is the priority class of the helper pods
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// Tolerations are added to the tolerations of the helper pods
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// NodeSelector is merged into the node selector of the helper pods
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Resources are set as the resource requests and limits of all the containers of the helper pods
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// RetrySpec describes the automatic retry of the failed node upgrades
+type RetrySpec struct {
+	// MaxAttempts specifies how many times the upgrade of a failed node is retried, zero disables the retries
+	// +optional
+	// +kubebuilder:default:=0
+	// +kubebuilder:validation:Minimum:=0
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// BackoffSeconds specifies the length of time in seconds a failed node waits before its first retry.
+	// The backoff doubles with every following attempt.
+	// +optional
+	// +kubebuilder:default:=300
+	// +kubebuilder:validation:Minimum:=0
+	BackoffSeconds int `json:"backoffSeconds,omitempty"`
+	// MaxBackoffSeconds caps the backoff between the attempts, zero means the backoff is not capped
+	// +optional
+	// +kubebuilder:default:=3600
+	// +kubebuilder:validation:Minimum:=0
+	MaxBackoffSeconds int `json:"maxBackoffSeconds,omitempty"`
+}
+
+// DowngradeSpec describes the handling of the driver downgrades. The upgrade of a node is a downgrade when
+// the DaemonSet revision the node moves to was created before the revision of its driver pod,
+// i.e. the DaemonSet was rolled back to an earlier template.
+type DowngradeSpec struct {
+	// SkipDrain indicates if the nodes are not drained when their driver is downgraded
+	// +optional
+	// +kubebuilder:default:=false
+	SkipDrain bool `json:"skipDrain,omitempty"`
+	// RequireApproval indicates if the downgrade of a node waits in the upgrade-required state until the node
+	// is annotated with the downgrade approval annotation
+	// +optional
+	// +kubebuilder:default:=false
+	RequireApproval bool `json:"requireApproval,omitempty"`
+}
+
+// BlackoutPeriodSpec describes a date range in which no node upgrade is started
+type BlackoutPeriodSpec struct {
+	// Name identifies the blackout period in events and upgrade status reports
+	Name string `json:"name"`
+	// StartDate is the first day of the blackout period, in the YYYY-MM-DD format
+	// +kubebuilder:validation:Pattern=`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`
+	StartDate string `json:"startDate"`
+	// EndDate is the last day of the blackout period, in the YYYY-MM-DD format. The blackout period ends
+	// at the end of this day.
+	// +kubebuilder:validation:Pattern=`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`
+	EndDate string `json:"endDate"`
+	// TimeZone is the IANA time zone name the dates are evaluated in, e.g. "America/New_York"
+	// +optional
+	// +kubebuilder:default:="UTC"
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// UpgradeScheduleSpec describes the maintenance windows in which node upgrades can start
+type UpgradeScheduleSpec struct {
+	// Cron is the standard five fields cron expression (minute, hour, day of month, month, day of week)
+	// of the start times of the maintenance windows, e.g. "0 22 * * 1-5" for 22:00 on weekdays.
+	// The fields accept numbers, ranges, lists and steps.
+	Cron string `json:"cron"`
+	// TimeZone is the IANA time zone name the cron expression is evaluated in, e.g. "Europe/Berlin"
+	// +optional
+	// +kubebuilder:default:="UTC"
+	TimeZone string `json:"timeZone,omitempty"`
+	// DurationSecond specifies the length of every maintenance window in seconds
+	// +kubebuilder:validation:Minimum:=60
+	DurationSecond int `json:"durationSeconds"`
 }
 
 // WaitForCompletionSpec describes the configuration for waiting on job completions
@@ -55,14 +294,40 @@ type WaitForCompletionSpec struct {
 	// https://kubernetes.io/docs/concepts/overview/working-with-objects/labels/#label-selectors
 	// +optional
 	PodSelector string `json:"podSelector,omitempty"`
+	// JobSelector specifies a label selector for the Jobs to wait for completion instead of the pods. The node is
+	// ready once every selected Job with pods on the node succeeded or was deleted. PodSelector is ignored when
+	// JobSelector is set.
+	// +optional
+	JobSelector string `json:"jobSelector,omitempty"`
 	// TimeoutSecond specifies the length of time in seconds to wait before giving up on pod termination, zero means
 	// infinite
 	// +optional
 	// +kubebuilder:default:=0
 	// +kubebuilder:validation:Minimum:=0
 	TimeoutSecond int `json:"timeoutSeconds,omitempty"`
+	// TimeoutAction specifies what happens to the node once the timeout is exceeded: Proceed moves it on to
+	// the pod deletion, Fail moves it to the upgrade-failed state and Wait keeps it waiting for the pods to complete
+	// +optional
+	// +kubebuilder:default:=Proceed
+	TimeoutAction WaitForCompletionTimeoutAction `json:"timeoutAction,omitempty"`
 }
 
+// WaitForCompletionTimeoutAction describes what happens to a node whose workload pods didn't complete
+// within the wait for completion timeout
+// +kubebuilder:validation:Enum=Proceed;Fail;Wait
+type WaitForCompletionTimeoutAction string
+
+const (
+	// WaitForCompletionTimeoutActionProceed moves the node on to the pod deletion, the running pods are deleted
+	// or evicted by the next states. This is the default.
+	WaitForCompletionTimeoutActionProceed WaitForCompletionTimeoutAction = "Proceed"
+	// WaitForCompletionTimeoutActionFail moves the node to the upgrade-failed state
+	WaitForCompletionTimeoutActionFail WaitForCompletionTimeoutAction = "Fail"
+	// WaitForCompletionTimeoutActionWait keeps the node waiting for the pods to complete, a warning event is
+	// emitted when the timeout is exceeded
+	WaitForCompletionTimeoutActionWait WaitForCompletionTimeoutAction = "Wait"
+)
+
 // PodDeletionSpec describes configuration for deletion of pods using special resources during automatic upgrade
 type PodDeletionSpec struct {
 	// Force indicates if force deletion is allowed
@@ -80,8 +345,59 @@ type PodDeletionSpec struct {
 	// +optional
 	// +kubebuilder:default:=false
 	DeleteEmptyDir bool `json:"deleteEmptyDir,omitempty"`
+	// Namespaces restricts the pod deletion to the pods in these namespaces, pods in all namespaces are deleted
+	// if empty
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ExcludeNamespaces specifies the namespaces pods are not deleted from
+	// +optional
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	// OwnerKinds restricts the pod deletion to the pods controlled by these kinds, e.g. Deployment or StatefulSet.
+	// Deployment matches the pods of the ReplicaSets of Deployments. Pods of any owner, and pods without owner,
+	// are deleted if empty.
+	// +optional
+	OwnerKinds []string `json:"ownerKinds,omitempty"`
+	// ExcludeOwnerKinds specifies the kinds of the controllers whose pods are not deleted, e.g. DaemonSet
+	// +optional
+	ExcludeOwnerKinds []string `json:"excludeOwnerKinds,omitempty"`
+	// SkipLocalStorage indicates if pods using emptyDir (local data that will be deleted when the pod is deleted)
+	// are left on the node instead of deleted. DeleteEmptyDir has no effect when set.
+	// +optional
+	// +kubebuilder:default:=false
+	SkipLocalStorage bool `json:"skipLocalStorage,omitempty"`
+	// Mode specifies if the pods are evicted, respecting their PodDisruptionBudgets, or deleted
+	// +optional
+	// +kubebuilder:default:=Evict
+	Mode PodDeletionMode `json:"mode,omitempty"`
+	// GracePeriodSeconds overrides the termination grace period of the pods, 0 deletes the pods immediately.
+	// The termination grace period of every pod is used if not set.
+	// +optional
+	// +kubebuilder:validation:Minimum:=0
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds,omitempty"`
+	// DryRun indicates if the eviction or deletion of the pods is only submitted as a server-side dry run,
+	// the pods are left running and the node moves on to the driver pod restart
+	// +optional
+	// +kubebuilder:default:=false
+	DryRun bool `json:"dryRun,omitempty"`
+	// PropagationPolicy specifies how the dependents of the pods are deleted, the default of the API server
+	// is used if not set
+	// +optional
+	// +kubebuilder:validation:Enum=Orphan;Background;Foreground
+	PropagationPolicy metav1.DeletionPropagation `json:"propagationPolicy,omitempty"`
 }
 
+// PodDeletionMode describes how the workload pods are removed from the node by the pod deletion
+// +kubebuilder:validation:Enum=Evict;Delete
+type PodDeletionMode string
+
+const (
+	// PodDeletionModeEvict evicts the pods with the Eviction API, respecting their PodDisruptionBudgets.
+	// This is the default.
+	PodDeletionModeEvict PodDeletionMode = "Evict"
+	// PodDeletionModeDelete deletes the pods, ignoring their PodDisruptionBudgets
+	PodDeletionModeDelete PodDeletionMode = "Delete"
+)
+
 // DrainSpec describes configuration for node drain during automatic upgrade
 type DrainSpec struct {
 	// Enable indicates if node draining is allowed during upgrade
@@ -107,6 +423,99 @@ type DrainSpec struct {
 	// +optional
 	// +kubebuilder:default:=false
 	DeleteEmptyDir bool `json:"deleteEmptyDir,omitempty"`
+	// StuckFinalizers describes how pods stuck terminating because of finalizers are handled during the drain.
+	// If not set, such pods are reported and waited for.
+	// +optional
+	StuckFinalizers *StuckFinalizerSpec `json:"stuckFinalizers,omitempty"`
+	// StaticPodPolicy describes how static pods on the node, selected by PodSelector, are handled during the drain.
+	// Static pods are managed by the kubelet and can't be evicted.
+	// +optional
+	// +kubebuilder:default:=Skip
+	StaticPodPolicy StaticPodPolicy `json:"staticPodPolicy,omitempty"`
+	// EvictionFallback describes the deletion of pods whose eviction stays blocked, e.g. by a PodDisruptionBudget
+	// which never allows a disruption. If not set, blocked evictions are retried up to the drain timeout.
+	// +optional
+	EvictionFallback *EvictionFallbackSpec `json:"evictionFallback,omitempty"`
+	// SingleReplicaPolicy is the handling of the nodes whose drain would evict the only ready replica of
+	// a Deployment or a StatefulSet, i.e. cause an outage of the workload. If not set, such nodes are drained.
+	// +optional
+	SingleReplicaPolicy SingleReplicaPolicy `json:"singleReplicaPolicy,omitempty"`
+	// PriorityThreshold specifies the pod priority at or above which pods are never evicted during the drain,
+	// e.g. 2000000000 for the pods of the system-cluster-critical priority class. Pods without priority have
+	// priority zero. If not set, pods are evicted regardless of their priority.
+	// +optional
+	PriorityThreshold *int32 `json:"priorityThreshold,omitempty"`
+}
+
+// SingleReplicaPolicy is the handling of the nodes whose drain would evict the only ready replica of a workload
+// +kubebuilder:validation:Enum=Warn;WaitForScaleUp;RequireApproval
+type SingleReplicaPolicy string
+
+const (
+	// SingleReplicaPolicyWarn reports the workloads with an event on the node, the drain continues
+	SingleReplicaPolicyWarn SingleReplicaPolicy = "Warn"
+	// SingleReplicaPolicyWaitForScaleUp holds the drain of the node until the workloads have another ready replica,
+	// e.g. scaled up by the workload owner. The node is cordoned, the new replicas run on other nodes.
+	SingleReplicaPolicyWaitForScaleUp SingleReplicaPolicy = "WaitForScaleUp"
+	// SingleReplicaPolicyRequireApproval holds the drain of the node until the node is annotated with the drain
+	// approval annotation
+	SingleReplicaPolicyRequireApproval SingleReplicaPolicy = "RequireApproval"
+)
+
+// EvictionFallbackSpec describes the direct deletion of pods whose eviction is blocked during the drain
+type EvictionFallbackSpec struct {
+	// DeleteAfterSeconds specifies the length of time in seconds the eviction of a pod can stay blocked
+	// before the pod is deleted without eviction, bypassing its PodDisruptionBudgets
+	// +kubebuilder:validation:Minimum:=1
+	DeleteAfterSeconds int `json:"deleteAfterSeconds"`
+	// GracePeriodSeconds overrides the termination grace period of the deleted pods, if not set the grace period
+	// of the pod is honored
+	// +optional
+	// +kubebuilder:validation:Minimum:=0
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds,omitempty"`
+}
+
+// StaticPodPolicy is the handling of static pods during the drain
+// +kubebuilder:validation:Enum=Skip;Fail
+type StaticPodPolicy string
+
+const (
+	// StaticPodPolicySkip leaves the static pods running and reports them, the drain continues
+	StaticPodPolicySkip StaticPodPolicy = "Skip"
+	// StaticPodPolicyFail fails the drain if a static pod is selected, the node is moved to the upgrade-failed state.
+	// It is meant for PodSelectors matching the pods known to use the driver.
+	StaticPodPolicyFail StaticPodPolicy = "Fail"
+)
+
+// StuckFinalizerAction is the action taken for a pod stuck terminating because of finalizers
+// +kubebuilder:validation:Enum=Wait;Fail;RemoveFinalizers
+type StuckFinalizerAction string
+
+const (
+	// StuckFinalizerActionWait reports the stuck pod and keeps waiting for it, up to the drain timeout
+	StuckFinalizerActionWait StuckFinalizerAction = "Wait"
+	// StuckFinalizerActionFail reports the stuck pod and fails the drain, the node is moved to the upgrade-failed state
+	StuckFinalizerActionFail StuckFinalizerAction = "Fail"
+	// StuckFinalizerActionRemoveFinalizers removes the allowed finalizers from the stuck pod.
+	// Pods stuck because of other finalizers are reported and waited for.
+	StuckFinalizerActionRemoveFinalizers StuckFinalizerAction = "RemoveFinalizers"
+)
+
+// StuckFinalizerSpec describes the handling of pods stuck terminating because of finalizers during the drain
+type StuckFinalizerSpec struct {
+	// Action is the action taken for a stuck pod
+	// +optional
+	// +kubebuilder:default:=Wait
+	Action StuckFinalizerAction `json:"action,omitempty"`
+	// TimeoutSecond specifies the length of time in seconds a pod can stay terminating past its grace period
+	// before it is considered stuck
+	// +optional
+	// +kubebuilder:default:=300
+	// +kubebuilder:validation:Minimum:=0
+	TimeoutSecond int `json:"timeoutSeconds,omitempty"`
+	// AllowedFinalizers are the finalizers which can be removed from stuck pods by the RemoveFinalizers action
+	// +optional
+	AllowedFinalizers []string `json:"allowedFinalizers,omitempty"`
 }
 
 // GetObjectKind return ObjectKind
@@ -0,0 +1,62 @@
+/*
+Copyright 2022 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DriverUpgradeConditionProgressing is True while the driver of some nodes is not upgraded yet
+	DriverUpgradeConditionProgressing = "UpgradeProgressing"
+	// DriverUpgradeConditionDegraded is True while the upgrade of some nodes failed
+	DriverUpgradeConditionDegraded = "UpgradeDegraded"
+	// DriverUpgradeConditionPaused is True while the upgrade is paused by the upgrade policy
+	DriverUpgradeConditionPaused = "UpgradePaused"
+)
+
+// DriverUpgradeStatus describes the progress of the driver upgrade, to be embedded in the status
+// of the custom resource of the operator
+// +kubebuilder:object:generate=true
+type DriverUpgradeStatus struct {
+	// Conditions are the DriverUpgradeCondition* conditions of the upgrade
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// TotalNodes is the count of nodes managed for driver upgrades
+	TotalNodes int `json:"totalNodes"`
+	// NodesByState is the count of nodes in each upgrade state, the unknown state is reported as "unknown"
+	// +optional
+	NodesByState map[string]int `json:"nodesByState,omitempty"`
+	// UpgradedNodes is the count of nodes in the upgrade-done state
+	UpgradedNodes int `json:"upgradedNodes"`
+	// UpgradesInProgress is the count of nodes whose upgrade started and is neither done nor failed
+	UpgradesInProgress int `json:"upgradesInProgress"`
+	// FailedNodes is the count of nodes in the upgrade-failed state
+	FailedNodes int `json:"failedNodes"`
+	// RemainingNodes is the estimated count of nodes left to upgrade, i.e. the nodes not in the upgrade-done state.
+	// The nodes in the unknown state are counted, even if their driver turns out to be up to date.
+	RemainingNodes int `json:"remainingNodes"`
+	// StartTime is the time the current or last rollout started, i.e. the time a node left the upgrade-done state
+	// after all the nodes were done
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is the time the last rollout completed, it is not set while a rollout is in progress
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
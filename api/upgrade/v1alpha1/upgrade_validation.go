@@ -0,0 +1,243 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Validate checks the selector syntax, the timeouts and the conflicting options of the upgrade policy,
+// so that an invalid policy can be rejected at admission instead of failing in the middle of an upgrade.
+// The returned error aggregates all the invalid fields.
+func (obj *DriverUpgradePolicySpec) Validate() error {
+	return obj.ValidateFields(nil).ToAggregate()
+}
+
+// ValidateFields returns the invalid fields of the upgrade policy, relative to fldPath,
+// e.g. the path of the upgrade policy in the custom resource embedding it
+func (obj *DriverUpgradePolicySpec) ValidateFields(fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if obj == nil {
+		return errs
+	}
+	errs = append(errs, validateNonNegative(obj.MaxParallelUpgrades, fldPath.Child("maxParallelUpgrades"))...)
+	errs = append(errs, validateNonNegative(obj.MaxParallelUpgradesPerZone,
+		fldPath.Child("maxParallelUpgradesPerZone"))...)
+	errs = append(errs, validateIntOrPercent(obj.MaxUnavailable, fldPath.Child("maxUnavailable"))...)
+	errs = append(errs, validateIntOrPercent(obj.ClusterMaxUnavailable, fldPath.Child("clusterMaxUnavailable"))...)
+	errs = append(errs, validateNonNegative(obj.NodeReadyTimeoutSecond, fldPath.Child("nodeReadyTimeoutSeconds"))...)
+	for state, timeout := range obj.NodeStateTimeoutSeconds {
+		errs = append(errs, validateNonNegative(timeout, fldPath.Child("nodeStateTimeoutSeconds").Key(state))...)
+	}
+	if obj.Retry != nil {
+		retryPath := fldPath.Child("retry")
+		errs = append(errs, validateNonNegative(obj.Retry.MaxAttempts, retryPath.Child("maxAttempts"))...)
+		errs = append(errs, validateNonNegative(obj.Retry.BackoffSeconds, retryPath.Child("backoffSeconds"))...)
+		errs = append(errs, validateNonNegative(obj.Retry.MaxBackoffSeconds, retryPath.Child("maxBackoffSeconds"))...)
+	}
+	errs = append(errs, obj.PodDeletion.ValidateFields(fldPath.Child("podDeletion"))...)
+	errs = append(errs, obj.WaitForCompletion.ValidateFields(fldPath.Child("waitForCompletion"))...)
+	errs = append(errs, obj.DrainSpec.ValidateFields(fldPath.Child("drain"))...)
+	if obj.Schedule != nil && obj.Schedule.DurationSecond < 60 {
+		errs = append(errs, field.Invalid(fldPath.Child("schedule", "durationSeconds"), obj.Schedule.DurationSecond,
+			"must be at least 60"))
+	}
+	if canary := obj.Canary; canary != nil {
+		canaryPath := fldPath.Child("canary")
+		errs = append(errs, validatePodSelector(canary.NodeSelector, canaryPath.Child("nodeSelector"))...)
+		if canary.Count < 1 {
+			errs = append(errs, field.Invalid(canaryPath.Child("count"), canary.Count, "must be greater than or equal to 1"))
+		}
+		errs = append(errs, validateNonNegative(canary.SoakSeconds, canaryPath.Child("soakSeconds"))...)
+	}
+	errs = append(errs, obj.Validation.ValidateFields(fldPath.Child("validation"))...)
+	names := make(map[string]bool, len(obj.BlackoutPeriods))
+	for i, period := range obj.BlackoutPeriods {
+		if names[period.Name] {
+			errs = append(errs, field.Duplicate(fldPath.Child("blackoutPeriods").Index(i).Child("name"), period.Name))
+		}
+		names[period.Name] = true
+	}
+	return errs
+}
+
+// Validate checks the timeout of the pod deletion spec
+func (obj *PodDeletionSpec) Validate() error {
+	return obj.ValidateFields(nil).ToAggregate()
+}
+
+// ValidateFields returns the invalid fields of the pod deletion spec, relative to fldPath
+func (obj *PodDeletionSpec) ValidateFields(fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if obj == nil {
+		return errs
+	}
+	errs = append(errs, validateNonNegative(obj.TimeoutSecond, fldPath.Child("timeoutSeconds"))...)
+	if obj.GracePeriodSeconds != nil && *obj.GracePeriodSeconds < 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("gracePeriodSeconds"), *obj.GracePeriodSeconds,
+			"must be greater than or equal to 0"))
+	}
+	switch obj.Mode {
+	case "", PodDeletionModeEvict, PodDeletionModeDelete:
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("mode"), obj.Mode,
+			[]PodDeletionMode{PodDeletionModeEvict, PodDeletionModeDelete}))
+	}
+	switch obj.PropagationPolicy {
+	case "", metav1.DeletePropagationOrphan, metav1.DeletePropagationBackground, metav1.DeletePropagationForeground:
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("propagationPolicy"), obj.PropagationPolicy,
+			[]metav1.DeletionPropagation{metav1.DeletePropagationOrphan, metav1.DeletePropagationBackground,
+				metav1.DeletePropagationForeground}))
+	}
+	return errs
+}
+
+// ValidateFields returns the invalid fields of the wait for completion spec, relative to fldPath
+func (obj *WaitForCompletionSpec) ValidateFields(fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if obj == nil {
+		return errs
+	}
+	errs = append(errs, validatePodSelector(obj.PodSelector, fldPath.Child("podSelector"))...)
+	errs = append(errs, validatePodSelector(obj.JobSelector, fldPath.Child("jobSelector"))...)
+	errs = append(errs, validateNonNegative(obj.TimeoutSecond, fldPath.Child("timeoutSeconds"))...)
+	switch obj.TimeoutAction {
+	case "", WaitForCompletionTimeoutActionProceed, WaitForCompletionTimeoutActionFail,
+		WaitForCompletionTimeoutActionWait:
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("timeoutAction"), obj.TimeoutAction,
+			[]WaitForCompletionTimeoutAction{WaitForCompletionTimeoutActionProceed, WaitForCompletionTimeoutActionFail,
+				WaitForCompletionTimeoutActionWait}))
+	}
+	return errs
+}
+
+// ValidateFields returns the invalid fields of the validation spec, relative to fldPath
+func (obj *ValidationSpec) ValidateFields(fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if obj == nil {
+		return errs
+	}
+	if obj.JobTemplate != nil && obj.JobTemplate.Namespace == "" {
+		errs = append(errs, field.Required(fldPath.Child("jobTemplate", "metadata", "namespace"),
+			"the namespace of the validation Job must be set"))
+	}
+	errs = append(errs, validateNonNegative(obj.TimeoutSecond, fldPath.Child("timeoutSeconds"))...)
+	switch obj.FailurePolicy {
+	case "", ValidationFailurePolicyFail, ValidationFailurePolicyIgnore:
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("failurePolicy"), obj.FailurePolicy,
+			[]ValidationFailurePolicy{ValidationFailurePolicyFail, ValidationFailurePolicyIgnore}))
+	}
+	return errs
+}
+
+// Validate checks the selector syntax, the timeouts and the conflicting options of the drain spec
+func (obj *DrainSpec) Validate() error {
+	return obj.ValidateFields(nil).ToAggregate()
+}
+
+// ValidateFields returns the invalid fields of the drain spec, relative to fldPath
+func (obj *DrainSpec) ValidateFields(fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if obj == nil {
+		return errs
+	}
+	errs = append(errs, validatePodSelector(obj.PodSelector, fldPath.Child("podSelector"))...)
+	errs = append(errs, validateNonNegative(obj.TimeoutSecond, fldPath.Child("timeoutSeconds"))...)
+	switch obj.StaticPodPolicy {
+	case "", StaticPodPolicySkip, StaticPodPolicyFail:
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("staticPodPolicy"), obj.StaticPodPolicy,
+			[]StaticPodPolicy{StaticPodPolicySkip, StaticPodPolicyFail}))
+	}
+	switch obj.SingleReplicaPolicy {
+	case "", SingleReplicaPolicyWarn, SingleReplicaPolicyWaitForScaleUp, SingleReplicaPolicyRequireApproval:
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("singleReplicaPolicy"), obj.SingleReplicaPolicy,
+			[]SingleReplicaPolicy{SingleReplicaPolicyWarn, SingleReplicaPolicyWaitForScaleUp,
+				SingleReplicaPolicyRequireApproval}))
+	}
+	if fallback := obj.EvictionFallback; fallback != nil {
+		fallbackPath := fldPath.Child("evictionFallback")
+		if fallback.DeleteAfterSeconds < 1 {
+			errs = append(errs, field.Invalid(fallbackPath.Child("deleteAfterSeconds"), fallback.DeleteAfterSeconds,
+				"must be greater than or equal to 1"))
+		}
+		if fallback.GracePeriodSeconds != nil && *fallback.GracePeriodSeconds < 0 {
+			errs = append(errs, field.Invalid(fallbackPath.Child("gracePeriodSeconds"), *fallback.GracePeriodSeconds,
+				"must be greater than or equal to 0"))
+		}
+	}
+	if obj.StuckFinalizers == nil {
+		return errs
+	}
+	stuckPath := fldPath.Child("stuckFinalizers")
+	stuck := obj.StuckFinalizers
+	errs = append(errs, validateNonNegative(stuck.TimeoutSecond, stuckPath.Child("timeoutSeconds"))...)
+	switch stuck.Action {
+	case "", StuckFinalizerActionWait, StuckFinalizerActionFail:
+		if len(stuck.AllowedFinalizers) > 0 {
+			errs = append(errs, field.Forbidden(stuckPath.Child("allowedFinalizers"),
+				"may only be set with the RemoveFinalizers action"))
+		}
+	case StuckFinalizerActionRemoveFinalizers:
+		if len(stuck.AllowedFinalizers) == 0 {
+			errs = append(errs, field.Required(stuckPath.Child("allowedFinalizers"),
+				"is required by the RemoveFinalizers action"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(stuckPath.Child("action"), stuck.Action, []StuckFinalizerAction{
+			StuckFinalizerActionWait, StuckFinalizerActionFail, StuckFinalizerActionRemoveFinalizers}))
+	}
+	return errs
+}
+
+// validateNonNegative returns an error if value is negative
+func validateNonNegative(value int, fldPath *field.Path) field.ErrorList {
+	if value < 0 {
+		return field.ErrorList{field.Invalid(fldPath, value, "must be greater than or equal to 0")}
+	}
+	return nil
+}
+
+// validateIntOrPercent returns an error if value is neither a non-negative number nor a non-negative percentage
+func validateIntOrPercent(value *intstr.IntOrString, fldPath *field.Path) field.ErrorList {
+	if value == nil {
+		return nil
+	}
+	scaled, err := intstr.GetScaledValueFromIntOrPercent(value, 100, true)
+	if err != nil {
+		return field.ErrorList{field.Invalid(fldPath, value.String(), err.Error())}
+	}
+	if scaled < 0 {
+		return field.ErrorList{field.Invalid(fldPath, value.String(), "must be greater than or equal to 0")}
+	}
+	return nil
+}
+
+// validatePodSelector returns an error if selector is not a valid label selector
+func validatePodSelector(selector string, fldPath *field.Path) field.ErrorList {
+	if _, err := labels.Parse(selector); err != nil {
+		return field.ErrorList{field.Invalid(fldPath, selector, err.Error())}
+	}
+	return nil
+}
@@ -21,6 +21,9 @@ limitations under the License.
 package v1alpha1
 
 import (
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -33,10 +36,15 @@ func (in *DriverUpgradePolicySpec) DeepCopyInto(out *DriverUpgradePolicySpec) {
 		*out = new(intstr.IntOrString)
 		**out = **in
 	}
+	if in.ClusterMaxUnavailable != nil {
+		in, out := &in.ClusterMaxUnavailable, &out.ClusterMaxUnavailable
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
 	if in.PodDeletion != nil {
 		in, out := &in.PodDeletion, &out.PodDeletion
 		*out = new(PodDeletionSpec)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.WaitForCompletion != nil {
 		in, out := &in.WaitForCompletion, &out.WaitForCompletion
@@ -46,8 +54,60 @@ func (in *DriverUpgradePolicySpec) DeepCopyInto(out *DriverUpgradePolicySpec) {
 	if in.DrainSpec != nil {
 		in, out := &in.DrainSpec, &out.DrainSpec
 		*out = new(DrainSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeStateTimeoutSeconds != nil {
+		in, out := &in.NodeStateTimeoutSeconds, &out.NodeStateTimeoutSeconds
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Retry != nil {
+		in, out := &in.Retry, &out.Retry
+		*out = new(RetrySpec)
+		**out = **in
+	}
+	if in.Downgrade != nil {
+		in, out := &in.Downgrade, &out.Downgrade
+		*out = new(DowngradeSpec)
+		**out = **in
+	}
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(UpgradeScheduleSpec)
 		**out = **in
 	}
+	if in.BlackoutPeriods != nil {
+		in, out := &in.BlackoutPeriods, &out.BlackoutPeriods
+		*out = make([]BlackoutPeriodSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.HelperWorkloads != nil {
+		in, out := &in.HelperWorkloads, &out.HelperWorkloads
+		*out = new(HelperWorkloadSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PDBAwareSelection != nil {
+		in, out := &in.PDBAwareSelection, &out.PDBAwareSelection
+		*out = new(PDBAwareSelectionSpec)
+		**out = **in
+	}
+	if in.NodeHealthSelection != nil {
+		in, out := &in.NodeHealthSelection, &out.NodeHealthSelection
+		*out = new(NodeHealthSelectionSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Canary != nil {
+		in, out := &in.Canary, &out.Canary
+		*out = new(CanarySpec)
+		**out = **in
+	}
+	if in.Validation != nil {
+		in, out := &in.Validation, &out.Validation
+		*out = new(ValidationSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriverUpgradePolicySpec.
@@ -67,3 +127,259 @@ func (in *DriverUpgradePolicySpec) DeepCopyObject() runtime.Object {
 	}
 	return nil
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DrainSpec) DeepCopyInto(out *DrainSpec) {
+	*out = *in
+	if in.StuckFinalizers != nil {
+		in, out := &in.StuckFinalizers, &out.StuckFinalizers
+		*out = new(StuckFinalizerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.EvictionFallback != nil {
+		in, out := &in.EvictionFallback, &out.EvictionFallback
+		*out = new(EvictionFallbackSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PriorityThreshold != nil {
+		in, out := &in.PriorityThreshold, &out.PriorityThreshold
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DrainSpec.
+func (in *DrainSpec) DeepCopy() *DrainSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DrainSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodDeletionSpec) DeepCopyInto(out *PodDeletionSpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludeNamespaces != nil {
+		in, out := &in.ExcludeNamespaces, &out.ExcludeNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.OwnerKinds != nil {
+		in, out := &in.OwnerKinds, &out.OwnerKinds
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludeOwnerKinds != nil {
+		in, out := &in.ExcludeOwnerKinds, &out.ExcludeOwnerKinds
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.GracePeriodSeconds != nil {
+		in, out := &in.GracePeriodSeconds, &out.GracePeriodSeconds
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodDeletionSpec.
+func (in *PodDeletionSpec) DeepCopy() *PodDeletionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PodDeletionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StuckFinalizerSpec) DeepCopyInto(out *StuckFinalizerSpec) {
+	*out = *in
+	if in.AllowedFinalizers != nil {
+		in, out := &in.AllowedFinalizers, &out.AllowedFinalizers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StuckFinalizerSpec.
+func (in *StuckFinalizerSpec) DeepCopy() *StuckFinalizerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(StuckFinalizerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EvictionFallbackSpec) DeepCopyInto(out *EvictionFallbackSpec) {
+	*out = *in
+	if in.GracePeriodSeconds != nil {
+		in, out := &in.GracePeriodSeconds, &out.GracePeriodSeconds
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EvictionFallbackSpec.
+func (in *EvictionFallbackSpec) DeepCopy() *EvictionFallbackSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EvictionFallbackSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PDBAwareSelectionSpec) DeepCopyInto(out *PDBAwareSelectionSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PDBAwareSelectionSpec.
+func (in *PDBAwareSelectionSpec) DeepCopy() *PDBAwareSelectionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PDBAwareSelectionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeHealthSelectionSpec) DeepCopyInto(out *NodeHealthSelectionSpec) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.NodeConditionType, len(*in))
+		copy(*out, *in)
+	}
+	if in.TaintKeys != nil {
+		in, out := &in.TaintKeys, &out.TaintKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeHealthSelectionSpec.
+func (in *NodeHealthSelectionSpec) DeepCopy() *NodeHealthSelectionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeHealthSelectionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanarySpec) DeepCopyInto(out *CanarySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanarySpec.
+func (in *CanarySpec) DeepCopy() *CanarySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CanarySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HelperWorkloadSpec) DeepCopyInto(out *HelperWorkloadSpec) {
+	*out = *in
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = new(v1.ResourceRequirements)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HelperWorkloadSpec.
+func (in *HelperWorkloadSpec) DeepCopy() *HelperWorkloadSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HelperWorkloadSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ValidationSpec) DeepCopyInto(out *ValidationSpec) {
+	*out = *in
+	if in.JobTemplate != nil {
+		in, out := &in.JobTemplate, &out.JobTemplate
+		*out = new(batchv1.JobTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ValidationSpec.
+func (in *ValidationSpec) DeepCopy() *ValidationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ValidationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DriverUpgradeStatus) DeepCopyInto(out *DriverUpgradeStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NodesByState != nil {
+		in, out := &in.NodesByState, &out.NodesByState
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DriverUpgradeStatus.
+func (in *DriverUpgradeStatus) DeepCopy() *DriverUpgradeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DriverUpgradeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
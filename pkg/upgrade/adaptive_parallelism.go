@@ -0,0 +1,112 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// adaptiveParallelism keeps the state of the progressive ramp-up of parallel upgrades
+type adaptiveParallelism struct {
+	// limit is the current count of parallel upgrades
+	limit int
+	// batch contains the names of the nodes upgraded under the current limit
+	batch map[string]bool
+	// failed contains the names of the failed nodes which were already accounted for
+	failed map[string]bool
+}
+
+// newAdaptiveParallelism creates an adaptiveParallelism starting with a single parallel upgrade
+func newAdaptiveParallelism() *adaptiveParallelism {
+	return &adaptiveParallelism{limit: 1, batch: make(map[string]bool), failed: make(map[string]bool)}
+}
+
+// update adds the nodes on which upgrade is in progress to the current batch and adjusts the limit:
+// the limit is halved if a node of the batch failed the upgrade, and doubled up to maxLimit if all the nodes
+// of the batch completed the upgrade. Returns true if the limit has changed.
+func (a *adaptiveParallelism) update(currentState *ClusterUpgradeState, maxLimit int) bool {
+	nodeStates := make(map[string]string)
+	for state, states := range currentState.NodeStates {
+		for _, nodeState := range states {
+			nodeName := nodeState.Node.Name
+			nodeStates[nodeName] = state
+			switch state {
+			case UpgradeStateUnknown, UpgradeStateDone, UpgradeStateUpgradeRequired, UpgradeStateDaemonSetMissing,
+				UpgradeStateBlockedBySkew:
+				continue
+			case UpgradeStateFailed:
+				if a.failed[nodeName] {
+					continue
+				}
+			}
+			a.batch[nodeName] = true
+		}
+	}
+	for nodeName := range a.failed {
+		if nodeStates[nodeName] != UpgradeStateFailed {
+			delete(a.failed, nodeName)
+		}
+	}
+
+	previousLimit := a.limit
+	done := 0
+	for nodeName := range a.batch {
+		switch nodeStates[nodeName] {
+		case UpgradeStateFailed:
+			a.failed[nodeName] = true
+			a.limit = max(a.limit/2, 1)
+			a.batch = make(map[string]bool)
+			return a.limit != previousLimit
+		case UpgradeStateDone:
+			done++
+		case UpgradeStateUnknown, UpgradeStateUpgradeRequired, UpgradeStateDaemonSetMissing, UpgradeStateBlockedBySkew:
+			// the upgrade of the node was reset, it doesn't count for the batch
+			delete(a.batch, nodeName)
+		}
+	}
+	if done > 0 && done == len(a.batch) {
+		a.limit = min(a.limit*2, maxLimit)
+		a.batch = make(map[string]bool)
+	}
+	a.limit = min(a.limit, maxLimit)
+	return a.limit != previousLimit
+}
+
+// getMaxParallelUpgrades returns the count of parallel upgrades to apply in the current ApplyState call.
+// It is the MaxParallelUpgrades of the policy, unless adaptive parallelism is enabled.
+func (m *ClusterUpgradeStateManagerImpl) getMaxParallelUpgrades(ctx context.Context, currentState *ClusterUpgradeState,
+	upgradePolicy *v1alpha1.DriverUpgradePolicySpec) int {
+	if !upgradePolicy.AdaptiveParallelism {
+		m.adaptiveParallelism = nil
+		return upgradePolicy.MaxParallelUpgrades
+	}
+	maxLimit := upgradePolicy.MaxParallelUpgrades
+	if maxLimit == 0 {
+		maxLimit = max(m.GetTotalManagedNodes(ctx, currentState), 1)
+	}
+	if m.adaptiveParallelism == nil {
+		m.adaptiveParallelism = newAdaptiveParallelism()
+	}
+	if m.adaptiveParallelism.update(currentState, maxLimit) {
+		m.Log.V(consts.LogLevelInfo).Info("Adjusted count of parallel upgrades",
+			"parallel upgrades", m.adaptiveParallelism.limit, "max parallel upgrades", maxLimit)
+	}
+	return m.adaptiveParallelism.limit
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// apiWarningCode is the code of the API server warnings, see RFC 7234
+const apiWarningCode = 299
+
+// apiWarningLogger is a rest.WarningHandler which logs the API server warnings with the library logger
+type apiWarningLogger struct {
+	log logr.Logger
+}
+
+// HandleWarningHeader implements rest.WarningHandler
+func (h apiWarningLogger) HandleWarningHeader(code int, _ string, text string) {
+	if code != apiWarningCode || text == "" {
+		return
+	}
+	h.log.V(consts.LogLevelWarning).Info("Kubernetes API server warning", "warning", text)
+}
+
+// nodeAPIWarningHandler is a rest.WarningHandler which logs the API server warnings received while processing a node
+// and records them as events of the node
+type nodeAPIWarningHandler struct {
+	log           logr.Logger
+	eventRecorder record.EventRecorder
+	node          *corev1.Node
+}
+
+// HandleWarningHeader implements rest.WarningHandler
+func (h *nodeAPIWarningHandler) HandleWarningHeader(code int, _ string, text string) {
+	if code != apiWarningCode || text == "" {
+		return
+	}
+	h.log.V(consts.LogLevelWarning).Info("Kubernetes API server warning", "node", h.node.Name, "warning", text)
+	logEventf(h.eventRecorder, h.node, corev1.EventTypeWarning, GetEventReason(),
+		"Kubernetes API server warning: %s", text)
+}
+
+// nodeClientFactory creates clients which attribute the API server warnings to the node being processed.
+// The clients share the HTTP client, so creating them is cheap.
+type nodeClientFactory struct {
+	config        *rest.Config
+	httpClient    *http.Client
+	log           logr.Logger
+	eventRecorder record.EventRecorder
+}
+
+// newNodeClientFactory creates a nodeClientFactory for the given config
+func newNodeClientFactory(config *rest.Config, log logr.Logger,
+	eventRecorder record.EventRecorder) (*nodeClientFactory, error) {
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP client: %v", err)
+	}
+	return &nodeClientFactory{config: config, httpClient: httpClient, log: log, eventRecorder: eventRecorder}, nil
+}
+
+// clientFor returns a client which reports the API server warnings as events of the node.
+// defaultClient is returned if the factory is nil or the client can't be created.
+func (f *nodeClientFactory) clientFor(node *corev1.Node, defaultClient kubernetes.Interface) kubernetes.Interface {
+	if f == nil {
+		return defaultClient
+	}
+	config := rest.CopyConfig(f.config)
+	config.WarningHandler = &nodeAPIWarningHandler{log: f.log, eventRecorder: f.eventRecorder, node: node}
+	nodeClient, err := kubernetes.NewForConfigAndClient(config, f.httpClient)
+	if err != nil {
+		f.log.V(consts.LogLevelError).Error(err, "Failed to create k8s interface for the node", "node", node.Name)
+		return defaultClient
+	}
+	return nodeClient
+}
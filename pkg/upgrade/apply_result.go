@@ -0,0 +1,250 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+)
+
+// UpgradeAction is an action scheduled for a node by an ApplyState pass
+type UpgradeAction string
+
+const (
+	// UpgradeActionCordon is recorded when the node is cordoned
+	UpgradeActionCordon UpgradeAction = "Cordon"
+	// UpgradeActionWaitForJobs is recorded when the check on the completion of the node pods is scheduled
+	UpgradeActionWaitForJobs UpgradeAction = "WaitForJobs"
+	// UpgradeActionPodDeletion is recorded when the deletion of the node workload pods is scheduled
+	UpgradeActionPodDeletion UpgradeAction = "PodDeletion"
+	// UpgradeActionDrain is recorded when the node drain is scheduled
+	UpgradeActionDrain UpgradeAction = "Drain"
+	// UpgradeActionPodRestart is recorded when the restart of the node driver pod is scheduled
+	UpgradeActionPodRestart UpgradeAction = "PodRestart"
+	// UpgradeActionReboot is recorded when the reboot of the node is requested
+	UpgradeActionReboot UpgradeAction = "Reboot"
+	// UpgradeActionNodeMaintenance is recorded when the maintenance of the node is requested with
+	// a NodeMaintenance resource
+	UpgradeActionNodeMaintenance UpgradeAction = "NodeMaintenance"
+	// UpgradeActionUncordon is recorded when the node is uncordoned at the end of the upgrade
+	UpgradeActionUncordon UpgradeAction = "Uncordon"
+)
+
+// SkippedNodeReasonSkipLabel is the reason of the nodes skipped because they are marked for skipping upgrades
+const SkippedNodeReasonSkipLabel = "SkipLabel"
+
+// NodeStateTransition is an upgrade state change of a node made by an ApplyState pass
+type NodeStateTransition struct {
+	// Node is the name of the node
+	Node string
+	// From is the upgrade state of the node at the beginning of the pass
+	From string
+	// To is the upgrade state of the node at the end of the pass
+	To string
+}
+
+// SkippedNode is a node which stayed in its upgrade state during an ApplyState pass for a known reason
+type SkippedNode struct {
+	// Node is the name of the node
+	Node string
+	// State is the upgrade state of the node
+	State string
+	// Reason is the upgrade state reason of the node, e.g. WaitingForSlot, or SkippedNodeReasonSkipLabel
+	Reason string
+}
+
+// ScheduledAction is an action scheduled for a node by an ApplyState pass
+type ScheduledAction struct {
+	// Node is the name of the node
+	Node string
+	// Action is the scheduled action
+	Action UpgradeAction
+}
+
+// ApplyResult is the outcome of an ApplyState pass, e.g. to populate the status conditions of the operator
+// custom resource
+type ApplyResult struct {
+	// Transitions are the upgrade state changes of the nodes, sorted by node name
+	Transitions []NodeStateTransition
+	// NodesInState is the count of nodes in each upgrade state at the end of the pass
+	NodesInState map[string]int
+	// SkippedNodes are the nodes which stayed in their upgrade state for a known reason, sorted by node name
+	SkippedNodes []SkippedNode
+	// ScheduledActions are the actions scheduled for the nodes
+	ScheduledActions []ScheduledAction
+	// OverBudget is the count of upgrades in progress beyond maxParallelUpgrades at the end of the pass
+	OverBudget int
+	// DrainResults are the outcomes of the node drains which finished since the previous pass, sorted by node name.
+	// They are only reported if the DrainManager implements DrainResultsProvider.
+	DrainResults []DrainResult
+	// PhaseErrors are the errors of the upgrade phases with their class, including the errors which didn't stop
+	// the pass, see APIErrorClass
+	PhaseErrors []PhaseError
+	// RequeueAfter is the recommended delay before the next pass, from what the nodes wait for, e.g. the drain
+	// timeout or the opening of the next maintenance window. It is 0 if no node waits for anything, the next pass
+	// is then only needed when the cluster changes.
+	RequeueAfter time.Duration
+	// RequeueReason is what the recommended requeue waits for, the earliest wait of the nodes
+	RequeueReason RequeueReason
+	// PhaseStats are the duration and the API requests of the phases which ran, in the order they ran
+	PhaseStats []PhaseStat
+	// APICalls is the count of requests sent to the API server during the pass, see PhaseStat.APICalls
+	APICalls int64
+	// Paused indicates that the upgrade is paused by the upgrade policy, no node changed upgrade state.
+	// RequeueAfter is 0 while paused, the next pass is needed when the policy changes.
+	Paused bool
+}
+
+// applyResultRecorder records the actions scheduled during an ApplyStateWithResult pass
+type applyResultRecorder struct {
+	mutex       sync.Mutex
+	recording   bool
+	actions     []ScheduledAction
+	overBudget  int
+	phaseErrors []PhaseError
+	phaseStats  []PhaseStat
+}
+
+// start starts recording the actions of a pass
+func (r *applyResultRecorder) start() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.recording = true
+	r.actions = nil
+	r.overBudget = 0
+	r.phaseErrors = nil
+	r.phaseStats = nil
+}
+
+// stop stops recording and returns the actions, the over budget upgrades, the phase errors and the phase stats
+// recorded since start
+func (r *applyResultRecorder) stop() ([]ScheduledAction, int, []PhaseError, []PhaseStat) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	actions, phaseErrors, phaseStats := r.actions, r.phaseErrors, r.phaseStats
+	r.recording = false
+	r.actions = nil
+	r.phaseErrors = nil
+	r.phaseStats = nil
+	return actions, r.overBudget, phaseErrors, phaseStats
+}
+
+// recordPhaseStat records the stat of a phase of a pass, if a pass is being recorded
+func (r *applyResultRecorder) recordPhaseStat(stat PhaseStat) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.recording {
+		r.phaseStats = append(r.phaseStats, stat)
+	}
+}
+
+// recordPhaseErrors records the errors of the phases of a pass, if a pass is being recorded
+func (r *applyResultRecorder) recordPhaseErrors(phaseErrors []PhaseError) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.recording {
+		r.phaseErrors = append(r.phaseErrors, phaseErrors...)
+	}
+}
+
+// setOverBudget records the count of upgrades in progress beyond maxParallelUpgrades, if a pass is being recorded
+func (r *applyResultRecorder) setOverBudget(overBudget int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.recording {
+		r.overBudget = overBudget
+	}
+}
+
+// record records the action for the nodes, if a pass is being recorded
+func (r *applyResultRecorder) record(action UpgradeAction, nodes ...*corev1.Node) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.recording {
+		return
+	}
+	for _, node := range nodes {
+		r.actions = append(r.actions, ScheduledAction{Node: node.Name, Action: action})
+	}
+}
+
+// recordPods records the action for the nodes of the pods, if a pass is being recorded
+func (r *applyResultRecorder) recordPods(action UpgradeAction, pods []*corev1.Pod) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.recording {
+		return
+	}
+	for _, pod := range pods {
+		r.actions = append(r.actions, ScheduledAction{Node: pod.Spec.NodeName, Action: action})
+	}
+}
+
+// ApplyStateWithResult processes the cluster upgrade state like ApplyState and returns the outcome of the pass.
+// The result is returned even if the pass fails, with the changes made until the failure.
+func (m *ClusterUpgradeStateManagerImpl) ApplyStateWithResult(ctx context.Context,
+	currentState *ClusterUpgradeState, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) (*ApplyResult, error) {
+	m.applyResultRecorder.start()
+	apiCalls := m.apiCalls.count()
+	err := m.applyState(ctx, currentState, upgradePolicy, "")
+	apiCalls = m.apiCalls.count() - apiCalls
+	actions, overBudget, phaseErrors, phaseStats := m.applyResultRecorder.stop()
+	if currentState == nil {
+		return nil, err
+	}
+
+	result := &ApplyResult{NodesInState: make(map[string]int), ScheduledActions: actions, OverBudget: overBudget,
+		PhaseErrors: phaseErrors, PhaseStats: phaseStats, APICalls: apiCalls}
+	if provider, ok := m.DrainManager.(DrainResultsProvider); ok {
+		result.DrainResults = provider.TakeDrainResults()
+	}
+	for passState, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			node := nodeState.Node
+			state := m.getNodeUpgradeState(node)
+			result.NodesInState[state]++
+			if state != passState {
+				result.Transitions = append(result.Transitions,
+					NodeStateTransition{Node: node.Name, From: passState, To: state})
+				continue
+			}
+			reason := m.keys.GetNodeUpgradeStateReason(node)
+			if state == UpgradeStateUpgradeRequired && m.skipNodeUpgrade(node) {
+				reason = SkippedNodeReasonSkipLabel
+			}
+			if reason != "" {
+				result.SkippedNodes = append(result.SkippedNodes, SkippedNode{Node: node.Name, State: state, Reason: reason})
+			}
+		}
+	}
+	if upgradePolicy != nil {
+		result.Paused = upgradePolicy.AutoUpgrade && upgradePolicy.Paused
+		if !result.Paused {
+			result.RequeueAfter, result.RequeueReason = m.getRequeueHint(currentState, upgradePolicy, time.Now())
+		}
+	}
+	transitions, skippedNodes := result.Transitions, result.SkippedNodes
+	sort.Slice(transitions, func(i, j int) bool { return transitions[i].Node < transitions[j].Node })
+	sort.Slice(skippedNodes, func(i, j int) bool { return skippedNodes[i].Node < skippedNodes[j].Node })
+	return result, err
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// blackoutDateLayout is the layout of the dates of a blackout period
+const blackoutDateLayout = "2006-01-02"
+
+// blackoutPeriod is a parsed v1alpha1.BlackoutPeriodSpec, it lasts from start until before end
+type blackoutPeriod struct {
+	name       string
+	start, end time.Time
+}
+
+// parseBlackoutPeriod parses and validates the blackout period
+func parseBlackoutPeriod(spec *v1alpha1.BlackoutPeriodSpec) (*blackoutPeriod, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("blackout period name must not be empty")
+	}
+	location := time.UTC
+	if spec.TimeZone != "" {
+		var err error
+		location, err = time.LoadLocation(spec.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q of blackout period %q: %v", spec.TimeZone, spec.Name, err)
+		}
+	}
+	start, err := time.ParseInLocation(blackoutDateLayout, spec.StartDate, location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date of blackout period %q: %v", spec.Name, err)
+	}
+	lastDay, err := time.ParseInLocation(blackoutDateLayout, spec.EndDate, location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end date of blackout period %q: %v", spec.Name, err)
+	}
+	if lastDay.Before(start) {
+		return nil, fmt.Errorf("end date of blackout period %q is before its start date", spec.Name)
+	}
+	// the end date is included, AddDate keeps the period aligned to midnight across DST changes
+	return &blackoutPeriod{name: spec.Name, start: start, end: lastDay.AddDate(0, 0, 1)}, nil
+}
+
+// contains returns true if now is within the blackout period
+func (p *blackoutPeriod) contains(now time.Time) bool {
+	return !now.Before(p.start) && now.Before(p.end)
+}
+
+// validateBlackoutPeriods checks that the blackout periods of the upgrade policy are valid
+func validateBlackoutPeriods(upgradePolicy *v1alpha1.DriverUpgradePolicySpec) error {
+	for i := range upgradePolicy.BlackoutPeriods {
+		if _, err := parseBlackoutPeriod(&upgradePolicy.BlackoutPeriods[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getActiveBlackoutPeriods returns the names of the blackout periods of the upgrade policy active at the given time
+func getActiveBlackoutPeriods(upgradePolicy *v1alpha1.DriverUpgradePolicySpec, now time.Time) ([]string, error) {
+	var active []string
+	for i := range upgradePolicy.BlackoutPeriods {
+		period, err := parseBlackoutPeriod(&upgradePolicy.BlackoutPeriods[i])
+		if err != nil {
+			return nil, err
+		}
+		if period.contains(now) {
+			active = append(active, period.name)
+		}
+	}
+	return active, nil
+}
+
+// waitForBlackoutPeriod keeps the UpgradeStateUpgradeRequired nodes in their state until the active blackout
+// periods are over and sets the reason of their state
+func (m *ClusterUpgradeStateManagerImpl) waitForBlackoutPeriod(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, activeBlackoutPeriods []string) error {
+	m.Log.V(consts.LogLevelInfo).Info("In blackout period, node upgrades are not started",
+		"blackout periods", activeBlackoutPeriods)
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateUpgradeRequired] {
+		err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, nodeState.Node,
+			UpgradeStateReasonInBlackoutPeriod)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(
+				err, "Failed to set node upgrade state reason", "node", nodeState.Node.Name)
+			return err
+		}
+	}
+	return nil
+}
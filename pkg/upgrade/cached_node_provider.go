@@ -0,0 +1,287 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// CachedNodeUpgradeStateProvider implements the NodeUpgradeStateProvider interface with the nodes of a shared
+// informer, e.g. from a SharedInformerFactory, so that the nodes are not read from the API server on every
+// reconcile. The nodes written by the provider are kept until the informer observed the write, so that the node
+// got from the provider always has the up-to-date upgrade state without waiting for the informer after a change.
+type CachedNodeUpgradeStateProvider struct {
+	K8sClient     client.Client
+	Log           logr.Logger
+	nodeLister    corev1listers.NodeLister
+	nodeMutex     KeyedMutex
+	eventRecorder record.EventRecorder
+	// stateStorage stores the upgrade state of the nodes, see WithStateStorage
+	stateStorage NodeUpgradeStateStorage
+
+	writtenNodesMutex sync.Mutex
+	// writtenNodes are the nodes written by the provider which the informer hasn't observed yet, by name
+	writtenNodes map[string]*corev1.Node
+	// keys returns the keys of the node labels and annotations, see WithKeyPrefix
+	keys UpgradeKeys
+	// timelines records the state changes written by the provider, set by the upgrade state manager
+	timelines *nodeUpgradeTimelineStore
+	// podLister, if set, lists the driver and workload pods for the upgrade state manager, see WithPodLister
+	podLister corev1listers.PodLister
+	// limits are the concurrency and the rate limit of the node writes, see WithConcurrency and WithRateLimit
+	limits nodeWriteLimits
+}
+
+// NewCachedNodeUpgradeStateProvider creates a CachedNodeUpgradeStateProvider reading the nodes with the lister of
+// a shared node informer, which has to be started and synced by the caller, and writing them with the client
+func NewCachedNodeUpgradeStateProvider(k8sClient client.Client, nodeLister corev1listers.NodeLister,
+	log logr.Logger, eventRecorder record.EventRecorder) NodeUpgradeStateProvider {
+	return &CachedNodeUpgradeStateProvider{
+		K8sClient:     k8sClient,
+		Log:           log,
+		nodeLister:    nodeLister,
+		nodeMutex:     KeyedMutex{},
+		eventRecorder: eventRecorder,
+		stateStorage:  LabelStateStorage{},
+		writtenNodes:  make(map[string]*corev1.Node),
+		limits:        nodeWriteLimits{concurrency: 1},
+	}
+}
+
+// WithStateStorage sets the storage of the node upgrade states written by the provider, LabelStateStorage by default.
+// The upgrade state manager sets the storage of its provider, see WithNodeUpgradeStateStorage.
+func (p *CachedNodeUpgradeStateProvider) WithStateStorage(
+	storage NodeUpgradeStateStorage) *CachedNodeUpgradeStateProvider {
+	p.stateStorage = bindStateStorage(storage, p.keys)
+	return p
+}
+
+// WithPodLister sets the lister of a shared pod informer, started and synced by the caller, which the upgrade state
+// manager lists the driver pods with in BuildState, and its pod manager the workload pods of the nodes, instead of
+// reading them from the API server. It has to be set before the provider is passed to WithNodeUpgradeStateProvider.
+func (p *CachedNodeUpgradeStateProvider) WithPodLister(
+	podLister corev1listers.PodLister) *CachedNodeUpgradeStateProvider {
+	p.podLister = podLister
+	return p
+}
+
+// WithKeyPrefix sets the prefix of the node labels and annotations written by the provider, DefaultKeyPrefix by
+// default. The upgrade state manager sets the prefix of its provider, see ClusterUpgradeStateManager.WithKeyPrefix.
+func (p *CachedNodeUpgradeStateProvider) WithKeyPrefix(prefix string) *CachedNodeUpgradeStateProvider {
+	p.keys = NewUpgradeKeys(prefix)
+	p.stateStorage = bindStateStorage(p.stateStorage, p.keys)
+	return p
+}
+
+// GetNode returns a copy of the node from the informer, or the node written by the provider if the informer
+// hasn't observed the write yet
+func (p *CachedNodeUpgradeStateProvider) GetNode(_ context.Context, nodeName string) (*corev1.Node, error) {
+	defer p.nodeMutex.Lock(nodeName)()
+
+	node, err := p.nodeLister.Get(nodeName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			p.forgetWrittenNode(nodeName)
+		}
+		return nil, err
+	}
+	return p.getLatestNode(node).DeepCopy(), nil
+}
+
+// ChangeNodeUpgradeState updates the upgrade state of a given corev1.Node object with a given value in the node
+// upgrade state storage, see WithStateStorage. The written node is returned by GetNode until the informer
+// observes the write.
+func (p *CachedNodeUpgradeStateProvider) ChangeNodeUpgradeState(
+	ctx context.Context, node *corev1.Node, newNodeState string) error {
+	p.Log.V(consts.LogLevelInfo).Info("Updating node upgrade state",
+		"node", node.Name,
+		"new state", newNodeState)
+
+	defer p.nodeMutex.Lock(node.Name)()
+
+	err := setNodeUpgradeState(ctx, p.K8sClient, p.stateStorage, p.timelines, node, newNodeState,
+		p.limits.waitForRateLimit)
+	if err != nil {
+		p.Log.V(consts.LogLevelError).Error(err, "Failed to patch node state label on a node object",
+			"node", node.Name,
+			"state", newNodeState)
+		logEventf(p.eventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+			"Failed to update node state label to %s, %s", newNodeState, err.Error())
+		return err
+	}
+	p.rememberWrittenNode(node)
+
+	p.Log.V(consts.LogLevelInfo).Info("Successfully changed node upgrade state label",
+		"node", node.Name,
+		"new state", newNodeState)
+	logEventf(p.eventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+		"Successfully updated node state label to %s", newNodeState)
+	return nil
+}
+
+// ChangeNodeUpgradeAnnotation patches a given corev1.Node object and updates an annotation with a given value.
+// The written node is returned by GetNode until the informer observes the write.
+func (p *CachedNodeUpgradeStateProvider) ChangeNodeUpgradeAnnotation(
+	ctx context.Context, node *corev1.Node, key string, value string) error {
+	p.Log.V(consts.LogLevelInfo).Info("Updating node upgrade annotation",
+		"node", node.Name,
+		"annotationKey", key,
+		"annotationValue", value)
+
+	defer p.nodeMutex.Lock(node.Name)()
+
+	patchString := []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q: %q}}}`, key, value))
+	if value == nullString {
+		patchString = []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q: null}}}`, key))
+	}
+	err := p.limits.waitForRateLimit(ctx)
+	if err == nil {
+		err = p.K8sClient.Patch(ctx, node, client.RawPatch(types.MergePatchType, patchString))
+	}
+	if err != nil {
+		p.Log.V(consts.LogLevelError).Error(err, "Failed to patch node state annotation on a node object",
+			"node", node.Name,
+			"annotationKey", key,
+			"annotationValue", value)
+		logEventf(p.eventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+			"Failed to update node annotation %s=%s: %s", key, value, err.Error())
+		return err
+	}
+	p.rememberWrittenNode(node)
+
+	p.Log.V(consts.LogLevelInfo).Info("Successfully changed node upgrade state annotation",
+		"node", node.Name,
+		"annotationKey", key,
+		"annotationValue", value)
+	logEventf(p.eventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+		"Successfully updated node annotation to %s=%s", key, value)
+	return nil
+}
+
+// rememberWrittenNode keeps a copy of the node written by the provider until the informer observes the write
+func (p *CachedNodeUpgradeStateProvider) rememberWrittenNode(node *corev1.Node) {
+	p.writtenNodesMutex.Lock()
+	defer p.writtenNodesMutex.Unlock()
+	p.writtenNodes[node.Name] = node.DeepCopy()
+}
+
+// forgetWrittenNode drops the node written by the provider, e.g. once the node is deleted
+func (p *CachedNodeUpgradeStateProvider) forgetWrittenNode(nodeName string) {
+	p.writtenNodesMutex.Lock()
+	defer p.writtenNodesMutex.Unlock()
+	delete(p.writtenNodes, nodeName)
+}
+
+// getLatestNode returns the node written by the provider if the informer hasn't observed the write yet, the node
+// of the informer otherwise
+func (p *CachedNodeUpgradeStateProvider) getLatestNode(cachedNode *corev1.Node) *corev1.Node {
+	p.writtenNodesMutex.Lock()
+	defer p.writtenNodesMutex.Unlock()
+	writtenNode, ok := p.writtenNodes[cachedNode.Name]
+	if !ok {
+		return cachedNode
+	}
+	if !isResourceVersionOlder(cachedNode.ResourceVersion, writtenNode.ResourceVersion) {
+		delete(p.writtenNodes, cachedNode.Name)
+		return cachedNode
+	}
+	return writtenNode
+}
+
+// listCachedPods returns copies of the pods of the lister in the namespace, all namespaces if it is empty, which
+// match the selector and, unless nodeName is empty, are scheduled on the node
+func listCachedPods(podLister corev1listers.PodLister, namespace string, selector labels.Selector,
+	nodeName string) ([]corev1.Pod, error) {
+	cachedPods, err := podLister.Pods(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]corev1.Pod, 0, len(cachedPods))
+	for _, pod := range cachedPods {
+		if nodeName != "" && pod.Spec.NodeName != nodeName {
+			continue
+		}
+		pods = append(pods, *pod.DeepCopy())
+	}
+	return pods, nil
+}
+
+// isResourceVersionOlder returns true if the resource version is older than the other one. Resource versions are
+// opaque, but the ones of the API server backed by etcd increase with every write. Resource versions which aren't
+// numbers are not compared, the informer is trusted then.
+func isResourceVersionOlder(resourceVersion, otherResourceVersion string) bool {
+	version, err := strconv.ParseUint(resourceVersion, 10, 64)
+	if err != nil {
+		return false
+	}
+	otherVersion, err := strconv.ParseUint(otherResourceVersion, 10, 64)
+	if err != nil {
+		return false
+	}
+	return version < otherVersion
+}
+
+// WithNodeUpgradeStateProvider provides an option to replace the NodeUpgradeStateProvider of the upgrade state
+// manager and of its managers, e.g. by a CachedNodeUpgradeStateProvider. Managers set by the caller which are not
+// the ones of the library keep their provider. The provider is wrapped to move the nodes to the custom states,
+// see WithCustomState. The pod lister of a CachedNodeUpgradeStateProvider, see WithPodLister, is used to list
+// the driver pods and the workload pods of the nodes.
+func (m *ClusterUpgradeStateManagerImpl) WithNodeUpgradeStateProvider(
+	provider NodeUpgradeStateProvider) ClusterUpgradeStateManager {
+	if len(m.customStates) > 0 {
+		provider = &customStateProvider{
+			NodeUpgradeStateProvider: unwrapNodeUpgradeStateProvider(provider),
+			redirect:                 m.getCustomStateRedirect,
+			getState:                 m.getNodeUpgradeState,
+		}
+	}
+	m.NodeUpgradeStateProvider = provider
+	setProviderTimelines(provider, m.timelines)
+	m.podLister = nil
+	if cachedProvider, ok := unwrapNodeUpgradeStateProvider(provider).(*CachedNodeUpgradeStateProvider); ok {
+		m.podLister = cachedProvider.podLister
+	}
+	if drainManager, ok := m.DrainManager.(*DrainManagerImpl); ok {
+		drainManager.nodeUpgradeStateProvider = provider
+	}
+	if podManager, ok := m.PodManager.(*PodManagerImpl); ok {
+		podManager.nodeUpgradeStateProvider = provider
+		podManager.podLister = m.podLister
+	}
+	if validationManager, ok := m.ValidationManager.(*ValidationManagerImpl); ok {
+		validationManager.nodeUpgradeStateProvider = provider
+	}
+	if safeDriverLoadManager, ok := m.SafeDriverLoadManager.(*SafeDriverLoadManagerImpl); ok {
+		safeDriverLoadManager.nodeUpgradeStateProvider = provider
+	}
+	m.propagateKeys()
+	return m
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// getCanaryNodes returns the canary nodes of the cluster state by name: the first canary.Count nodes matching
+// the canary node selector in the order of their names. The nodes marked for skipping upgrades are never canary
+// nodes, as they would hold the upgrade forever.
+func (m *ClusterUpgradeStateManagerImpl) getCanaryNodes(currentState *ClusterUpgradeState,
+	canary *v1alpha1.CanarySpec) (map[string]*NodeUpgradeState, error) {
+	selector, err := labels.Parse(canary.NodeSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid canary node selector: %v", err)
+	}
+	var candidates []*NodeUpgradeState
+	for _, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			node := nodeState.Node
+			if selector.Matches(labels.Set(node.Labels)) && !m.skipNodeUpgrade(node) {
+				candidates = append(candidates, nodeState)
+			}
+		}
+	}
+	slices.SortFunc(candidates, func(a, b *NodeUpgradeState) int {
+		return strings.Compare(a.Node.Name, b.Node.Name)
+	})
+	canaryNodes := make(map[string]*NodeUpgradeState, canary.Count)
+	for _, nodeState := range candidates[:min(canary.Count, len(candidates))] {
+		canaryNodes[nodeState.Node.Name] = nodeState
+	}
+	return canaryNodes, nil
+}
+
+// processCanaryNodes holds the upgrade of the nodes of the upgrade-required state which are not canary nodes
+// until the canary phase is over, i.e. all the canary nodes are done and their driver pods stayed ready for
+// the soak period. The canary nodes are taken from fullState, the complete cluster state, so that they don't
+// depend on the nodes processed by the pass. The soak period of a canary node starts once it is done with
+// a ready driver pod, and starts over if the driver pod is not ready anymore.
+// The returned cluster state only contains the canary nodes in the upgrade-required state during the canary phase,
+// the other nodes wait with the WaitingForCanary reason.
+func (m *ClusterUpgradeStateManagerImpl) processCanaryNodes(ctx context.Context, fullState *ClusterUpgradeState,
+	currentClusterState *ClusterUpgradeState, canary *v1alpha1.CanarySpec) (*ClusterUpgradeState, error) {
+	if canary == nil {
+		return currentClusterState, nil
+	}
+	m.Log.V(consts.LogLevelInfo).Info("ProcessCanaryNodes")
+	canaryNodes, err := m.getCanaryNodes(fullState, canary)
+	if err != nil {
+		return nil, err
+	}
+
+	annotationKey := m.keys.GetUpgradeCanarySoakStartTimeAnnotationKey()
+	now := time.Now().Unix()
+	canaryPhaseOver := true
+	for _, nodeState := range canaryNodes {
+		node := nodeState.Node
+		state := m.getNodeUpgradeState(node)
+		if state == UpgradeStateFailed {
+			m.Log.V(consts.LogLevelWarning).Info("Canary node upgrade failed, the upgrade of the other nodes is held",
+				"node", node.Name)
+		}
+		healthy := false
+		if state == UpgradeStateDone && nodeState.DriverPod != nil {
+			healthy, err = m.isDriverPodInSync(ctx, nodeState)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, soaking := node.Annotations[annotationKey]
+		if !healthy {
+			canaryPhaseOver = false
+			if soaking {
+				// the soak period starts over once the node is done again with a ready driver pod
+				err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, nullString)
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if !soaking {
+			m.Log.V(consts.LogLevelInfo).Info("Canary node upgraded, starting the soak period", "node", node.Name,
+				"soakSeconds", canary.SoakSeconds)
+			err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey,
+				strconv.FormatInt(now, 10))
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Failed to add annotation to track canary soak period",
+					"node", node.Name, "annotation", annotationKey)
+				return nil, err
+			}
+			canaryPhaseOver = canaryPhaseOver && canary.SoakSeconds == 0
+			continue
+		}
+		startTime, err := strconv.ParseInt(node.Annotations[annotationKey], 10, 64)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to convert start time to track canary soak period",
+				"node", node.Name)
+			return nil, err
+		}
+		if now < startTime+int64(canary.SoakSeconds) {
+			canaryPhaseOver = false
+		}
+	}
+	if canaryPhaseOver {
+		return currentClusterState, nil
+	}
+
+	m.Log.V(consts.LogLevelInfo).Info("Canary phase in progress, only the canary nodes are upgraded",
+		"canary nodes", len(canaryNodes))
+	canaryState := NewClusterUpgradeState()
+	for state, states := range currentClusterState.NodeStates {
+		canaryState.NodeStates[state] = states
+	}
+	canaryState.NodeStates[UpgradeStateUpgradeRequired] = nil
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateUpgradeRequired] {
+		if _, ok := canaryNodes[nodeState.Node.Name]; ok {
+			canaryState.NodeStates[UpgradeStateUpgradeRequired] = append(
+				canaryState.NodeStates[UpgradeStateUpgradeRequired], nodeState)
+			continue
+		}
+		err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, nodeState.Node,
+			UpgradeStateReasonWaitingForCanary)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(
+				err, "Failed to set node upgrade state reason", "node", nodeState.Node.Name)
+			return nil, err
+		}
+	}
+	return &canaryState, nil
+}
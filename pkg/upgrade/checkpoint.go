@@ -0,0 +1,113 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// applyStateCheckpoints keeps the name of the last node processed by ApplyState for every upgrade state,
+// so that the next ApplyState call resumes after it. The checkpoints of a node pool are kept under the
+// checkpointKey of the pool and the state.
+type applyStateCheckpoints struct {
+	mutex             sync.Mutex
+	lastProcessedNode map[string]string
+}
+
+// WithMaxNodesPerPass provides an option to limit the count of nodes processed per upgrade state in a single
+// ApplyState call. The remaining nodes are processed by the following calls, starting after the last processed node.
+// This bounds the duration of a single ApplyState call on very large clusters. 0 means no limit.
+func (m *ClusterUpgradeStateManagerImpl) WithMaxNodesPerPass(maxNodes int) ClusterUpgradeStateManager {
+	if maxNodes < 0 {
+		m.Log.V(consts.LogLevelWarning).Info("Ignoring negative max nodes per pass", "maxNodes", maxNodes)
+		return m
+	}
+	m.maxNodesPerPass = maxNodes
+	m.checkpoints = &applyStateCheckpoints{lastProcessedNode: make(map[string]string)}
+	return m
+}
+
+// checkpointKey returns the key of the checkpoint of the state in the node pool, pool is empty for the whole cluster
+func checkpointKey(pool, state string) string {
+	if pool == "" {
+		return state
+	}
+	return pool + "/" + state
+}
+
+// getApplyStateWindow returns the part of currentState which should be processed by the current ApplyState call
+// and the checkpoints to store once the processing completes successfully.
+// Nodes of every upgrade state are ordered by name, and at most maxNodesPerPass of them are taken, starting after
+// the checkpointed node and wrapping around.
+func (m *ClusterUpgradeStateManagerImpl) getApplyStateWindow(
+	currentState *ClusterUpgradeState, pool string) (*ClusterUpgradeState, map[string]string) {
+	if m.maxNodesPerPass == 0 || m.checkpoints == nil {
+		return currentState, nil
+	}
+	m.checkpoints.mutex.Lock()
+	defer m.checkpoints.mutex.Unlock()
+
+	window := NewClusterUpgradeState()
+	nextCheckpoints := make(map[string]string)
+	for state, nodeStates := range currentState.NodeStates {
+		if len(nodeStates) <= m.maxNodesPerPass {
+			window.NodeStates[state] = nodeStates
+			continue
+		}
+		sorted := make([]*NodeUpgradeState, len(nodeStates))
+		copy(sorted, nodeStates)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Node.Name < sorted[j].Node.Name })
+
+		lastProcessedNode := m.checkpoints.lastProcessedNode[checkpointKey(pool, state)]
+		start := sort.Search(len(sorted), func(i int) bool { return sorted[i].Node.Name > lastProcessedNode })
+		windowNodeStates := make([]*NodeUpgradeState, 0, m.maxNodesPerPass)
+		for i := 0; i < m.maxNodesPerPass; i++ {
+			windowNodeStates = append(windowNodeStates, sorted[(start+i)%len(sorted)])
+		}
+		window.NodeStates[state] = windowNodeStates
+		nextCheckpoints[checkpointKey(pool, state)] = windowNodeStates[len(windowNodeStates)-1].Node.Name
+		m.Log.V(consts.LogLevelDebug).Info("Processing part of the nodes in the state", "pool", pool, "state", state,
+			"nodes", len(windowNodeStates), "total", len(sorted), "after", lastProcessedNode)
+	}
+	return &window, nextCheckpoints
+}
+
+// commitApplyStateCheckpoints stores the checkpoints of the node pool returned by getApplyStateWindow.
+// Checkpoints of states which were processed completely are removed.
+func (m *ClusterUpgradeStateManagerImpl) commitApplyStateCheckpoints(nextCheckpoints map[string]string, pool string) {
+	if m.checkpoints == nil {
+		return
+	}
+	m.checkpoints.mutex.Lock()
+	defer m.checkpoints.mutex.Unlock()
+	if pool == "" {
+		m.checkpoints.lastProcessedNode = nextCheckpoints
+		return
+	}
+	for key := range m.checkpoints.lastProcessedNode {
+		if strings.HasPrefix(key, checkpointKey(pool, "")) {
+			delete(m.checkpoints.lastProcessedNode, key)
+		}
+	}
+	for key, nodeName := range nextCheckpoints {
+		m.checkpoints.lastProcessedNode[key] = nodeName
+	}
+}
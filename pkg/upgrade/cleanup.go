@@ -0,0 +1,292 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// getLibraryOwnedLabelKeys returns the node label keys owned by the upgrade library
+func (k UpgradeKeys) getLibraryOwnedLabelKeys() []string {
+	return []string{k.GetUpgradeStateLabelKey(), k.GetUpgradeStateStoredLabelKey()}
+}
+
+// getLibraryOwnedAnnotationKeys returns the node annotation keys owned by the upgrade library
+func (k UpgradeKeys) getLibraryOwnedAnnotationKeys() []string {
+	return []string{
+		k.GetUpgradeStateAnnotationKey(),
+		k.GetUpgradeInitialStateAnnotationKey(),
+		k.GetWaitForPodCompletionStartTimeAnnotationKey(),
+		k.GetWaitForPodCompletionRunningPodsAnnotationKey(),
+		k.GetValidationStartTimeAnnotationKey(),
+		k.GetNodeReadyWaitStartTimeAnnotationKey(),
+		k.GetUncordonGateStartTimeAnnotationKey(),
+		k.GetValidatorsStartTimeAnnotationKey(),
+		k.GetRebootBootIDAnnotationKey(),
+		k.GetRebootStartTimeAnnotationKey(),
+		k.GetUpgradeStateStartTimeAnnotationKey(),
+		k.GetUpgradeRetryAttemptsAnnotationKey(),
+		k.GetUpgradeRetryStartTimeAnnotationKey(),
+		k.GetUpgradeDowngradeAnnotationKey(),
+		k.GetUpgradeDowngradeApprovedAnnotationKey(),
+		k.GetUpgradeDrainApprovedAnnotationKey(),
+		k.GetUpgradeDrainStatusAnnotationKey(),
+		k.GetUpgradeDrainLeaseAnnotationKey(),
+		k.GetUpgradeCanarySoakStartTimeAnnotationKey(),
+		k.GetUpgradeRequestedAnnotationKey(),
+		k.GetUpgradeStateReasonAnnotationKey(),
+		k.GetUpgradeManualUncordonAnnotationKey(),
+		k.GetUpgradeFailedNodeCordonAnnotationKey(),
+		k.GetUpgradeImpactAnnotationKey(),
+		k.GetUpgradeHistoryAnnotationKey(),
+	}
+}
+
+// isNodeCordonedByUpgrade returns true if the node is unschedulable because of the driver upgrade,
+// i.e. the node is past the cordon stage of the upgrade and was schedulable at the beginning of the upgrade
+func (m *ClusterUpgradeStateManagerImpl) isNodeCordonedByUpgrade(node *corev1.Node) bool {
+	if !isNodeUnschedulable(node) {
+		return false
+	}
+	if _, ok := node.Annotations[m.keys.GetUpgradeInitialStateAnnotationKey()]; ok {
+		return false
+	}
+	switch m.getNodeUpgradeState(node) {
+	case UpgradeStateWaitForJobsRequired, UpgradeStatePodDeletionRequired, UpgradeStateDrainRequired,
+		UpgradeStateRebootRequired, UpgradeStatePodRestartRequired, UpgradeStateValidationRequired,
+		UpgradeStateUncordonRequired,
+		UpgradeStateFailed:
+		return true
+	}
+	return false
+}
+
+// CleanupUpgradeState removes all the labels, annotations and taints owned by the upgrade library from
+// the cluster nodes.
+// It is meant to be called when the operator is uninstalled or the driver is decommissioned.
+// If uncordon is true, nodes which were left cordoned by an unfinished upgrade are uncordoned.
+func (m *ClusterUpgradeStateManagerImpl) CleanupUpgradeState(ctx context.Context, uncordon bool) error {
+	m.Log.V(consts.LogLevelInfo).Info("Cleaning up node upgrade state", "uncordon", uncordon)
+
+	nodeList := &corev1.NodeList{}
+	err := m.K8sClient.List(ctx, nodeList)
+	if err != nil {
+		return fmt.Errorf("error getting node list: %v", err)
+	}
+
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if uncordon && m.isNodeCordonedByUpgrade(node) {
+			m.Log.V(consts.LogLevelInfo).Info("Uncordoning node left cordoned by the upgrade", "node", node.Name)
+			err = m.CordonManager.Uncordon(ctx, node)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Node uncordon failed", "node", node.Name)
+				return err
+			}
+		}
+		err = m.removeLibraryOwnedKeys(ctx, node, false)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to cleanup node upgrade state", "node", node.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// CleanupOrphanedState removes the upgrade artifacts of the nodes which no longer run the driver, e.g. after
+// the driver DaemonSet was deleted in the middle of the upgrade or the driver was removed from a node pool.
+// The driver pods and DaemonSets are selected by namespace and driverLabels, like in BuildState. For every node
+// with upgrade labels, annotations or taint which has no driver pod and is not targeted by a driver DaemonSet:
+//   - the validation resources of the node are deleted: the validation Jobs of the validators and of upgradePolicy,
+//     which may be nil, and the validation pod of the ValidationManagerImpl, as well as the reboot resources and
+//     the NodeMaintenance of the node
+//   - the upgrade of a node in the middle of it is aborted, see AbortNodeUpgrades: its drain is cancelled
+//     and it is uncordoned if it was cordoned by the upgrade
+//   - the labels, annotations and taint owned by the library are removed, except the upgrade history
+//
+// Unlike ApplyState, which parks the nodes which lost their driver DaemonSet in the daemonset-missing state
+// until the driver comes back, it is meant to be called when the driver is known to be gone from the nodes,
+// e.g. periodically or when a driver DaemonSet is deleted.
+func (m *ClusterUpgradeStateManagerImpl) CleanupOrphanedState(ctx context.Context, namespace string,
+	driverLabels map[string]string, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) error {
+	m.Log.V(consts.LogLevelInfo).Info("Cleaning up upgrade state of the nodes no longer running the driver")
+
+	selector := labels.SelectorFromSet(driverLabels)
+	daemonSets, err := m.getDriverDaemonSets(ctx, namespace, selector)
+	if err != nil {
+		return err
+	}
+	podList := &corev1.PodList{}
+	err = m.K8sClient.List(ctx, podList, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: selector})
+	if err != nil {
+		return fmt.Errorf("error getting driver pod list: %v", err)
+	}
+	driverNodes := make(map[string]bool)
+	for i := range podList.Items {
+		if nodeName := podList.Items[i].Spec.NodeName; nodeName != "" {
+			driverNodes[nodeName] = true
+		}
+	}
+
+	nodeList := &corev1.NodeList{}
+	err = m.K8sClient.List(ctx, nodeList)
+	if err != nil {
+		return fmt.Errorf("error getting node list: %v", err)
+	}
+	var validationSpec *v1alpha1.ValidationSpec
+	if upgradePolicy != nil {
+		validationSpec = upgradePolicy.Validation
+	}
+	validators := m.getValidators(validationSpec)
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if driverNodes[node.Name] || isNodeTargetedByDaemonSets(node, daemonSets) || !m.keys.hasLibraryOwnedKeys(node) {
+			continue
+		}
+		err = m.cleanupOrphanedNode(ctx, node, validators)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to cleanup upgrade state of the orphaned node",
+				"node", node.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanupOrphanedNode removes the upgrade artifacts of the node which no longer runs the driver
+func (m *ClusterUpgradeStateManagerImpl) cleanupOrphanedNode(ctx context.Context, node *corev1.Node,
+	validators []Validator) error {
+	state := m.getNodeUpgradeState(node)
+	m.Log.V(consts.LogLevelInfo).Info("Node no longer runs the driver, cleaning up its upgrade state",
+		"node", node.Name, "state", state)
+
+	for _, validator := range validators {
+		if err := validator.Cleanup(ctx, node); err != nil {
+			return err
+		}
+	}
+	if validationManager, ok := m.ValidationManager.(*ValidationManagerImpl); ok {
+		if err := validationManager.cleanupValidationPod(ctx, node); err != nil {
+			return err
+		}
+	}
+	if m.nodeReboot != nil {
+		if err := m.nodeReboot.rebooter.Cleanup(ctx, node); err != nil {
+			return fmt.Errorf("failed to clean up the reboot of node %s: %v", node.Name, err)
+		}
+	}
+	if m.nodeMaintenance != nil {
+		if err := m.deleteNodeMaintenance(ctx, node); err != nil {
+			return err
+		}
+	}
+
+	switch state {
+	case UpgradeStateUnknown, UpgradeStateDone:
+		logEvent(m.EventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+			"Node no longer runs the driver, upgrade state cleaned up")
+		return m.removeLibraryOwnedKeys(ctx, node, true)
+	}
+	// the upgrade was abandoned in the middle, e.g. the driver DaemonSet was deleted
+	return m.AbortNodeUpgrades(ctx, node.Name)
+}
+
+// hasLibraryOwnedKeys returns true if the node has any of the labels, annotations or the upgrade state taint
+// owned by the upgrade library, the upgrade history aside
+func (k UpgradeKeys) hasLibraryOwnedKeys(node *corev1.Node) bool {
+	for _, key := range k.getLibraryOwnedLabelKeys() {
+		if _, ok := node.Labels[key]; ok {
+			return true
+		}
+	}
+	for _, key := range k.getLibraryOwnedAnnotationKeys() {
+		if _, ok := node.Annotations[key]; ok && key != k.GetUpgradeHistoryAnnotationKey() {
+			return true
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == k.GetUpgradeStateTaintKey() {
+			return true
+		}
+	}
+	return false
+}
+
+// removeLibraryOwnedKeys removes the labels, annotations and the upgrade state taint owned by the upgrade library
+// from the node with a single patch, except the upgrade history annotation if keepHistory is set.
+// The upgrade claim of the manager is released as well.
+// The node is not patched if it has none of them.
+func (m *ClusterUpgradeStateManagerImpl) removeLibraryOwnedKeys(ctx context.Context, node *corev1.Node,
+	keepHistory bool) error {
+	labelsToRemove := make(map[string]interface{})
+	for _, key := range m.keys.getLibraryOwnedLabelKeys() {
+		if _, ok := node.Labels[key]; ok {
+			labelsToRemove[key] = nil
+		}
+	}
+	annotationsToRemove := make(map[string]interface{})
+	for _, key := range m.keys.getLibraryOwnedAnnotationKeys() {
+		if keepHistory && key == m.keys.GetUpgradeHistoryAnnotationKey() {
+			continue
+		}
+		if _, ok := node.Annotations[key]; ok {
+			annotationsToRemove[key] = nil
+		}
+	}
+	// the claim annotation is shared with the other operators, only the claim of the manager is removed
+	if m.isNodeClaimedByManager(node) {
+		annotationsToRemove[NodeUpgradeClaimAnnotationKey] = nil
+	}
+	taints := make([]corev1.Taint, 0, len(node.Spec.Taints))
+	for _, taint := range node.Spec.Taints {
+		if taint.Key != m.keys.GetUpgradeStateTaintKey() {
+			taints = append(taints, taint)
+		}
+	}
+	removeTaint := len(taints) != len(node.Spec.Taints)
+	if len(labelsToRemove) == 0 && len(annotationsToRemove) == 0 && !removeTaint {
+		return nil
+	}
+
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labelsToRemove,
+			"annotations": annotationsToRemove,
+		},
+	}
+	if removeTaint {
+		// a merge patch replaces the whole list of taints
+		patch["spec"] = map[string]interface{}{"taints": taints}
+	}
+	patchString, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	m.Log.V(consts.LogLevelDebug).Info("Removing upgrade labels and annotations from node",
+		"node", node.Name, "patch", string(patchString))
+	return m.K8sClient.Patch(ctx, node, client.RawPatch(types.MergePatchType, patchString))
+}
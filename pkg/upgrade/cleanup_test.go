@@ -0,0 +1,159 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade_test
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade/mocks"
+)
+
+var _ = Describe("CleanupUpgradeState", func() {
+	var ctx context.Context
+	var id string
+	var stateManager *upgrade.ClusterUpgradeStateManagerImpl
+	var cleanupCordonManager *mocks.CordonManager
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		id = randSeq(5)
+		stateManagerInterface, err := upgrade.NewClusterUpgradeStateManager(log, k8sConfig, eventRecorder)
+		Expect(err).NotTo(HaveOccurred())
+		stateManager, _ = stateManagerInterface.(*upgrade.ClusterUpgradeStateManagerImpl)
+		cleanupCordonManager = &mocks.CordonManager{}
+		cleanupCordonManager.On("Uncordon", mock.Anything, mock.Anything).Return(nil)
+		stateManager.CordonManager = cleanupCordonManager
+	})
+
+	It("should remove upgrade labels and annotations and uncordon nodes cordoned by the upgrade", func() {
+		cordonedNode := NewNode(fmt.Sprintf("cordoned-node-%s", id)).
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			Unschedulable(true).
+			Create()
+		initiallyCordonedNode := NewNode(fmt.Sprintf("initially-cordoned-node-%s", id)).
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			WithAnnotations(map[string]string{
+				upgrade.GetUpgradeInitialStateAnnotationKey(): "true",
+				upgrade.GetUpgradeStateReasonAnnotationKey():  upgrade.UpgradeStateReasonDrainBlockedByPDB,
+				"foo": "bar",
+			}).
+			Unschedulable(true).
+			Create()
+
+		Expect(stateManager.CleanupUpgradeState(ctx, true)).To(Succeed())
+
+		cleanedNode := getNode(cordonedNode.Name)
+		Expect(cleanedNode.Labels).NotTo(HaveKey(upgrade.GetUpgradeStateLabelKey()))
+		Expect(cleanedNode.Labels).To(HaveKey("dummy-key"))
+
+		cleanedNode = getNode(initiallyCordonedNode.Name)
+		Expect(cleanedNode.Labels).NotTo(HaveKey(upgrade.GetUpgradeStateLabelKey()))
+		Expect(cleanedNode.Annotations).NotTo(HaveKey(upgrade.GetUpgradeInitialStateAnnotationKey()))
+		Expect(cleanedNode.Annotations).NotTo(HaveKey(upgrade.GetUpgradeStateReasonAnnotationKey()))
+		Expect(cleanedNode.Annotations).To(HaveKeyWithValue("foo", "bar"))
+
+		cleanupCordonManager.AssertNumberOfCalls(GinkgoT(), "Uncordon", 1)
+	})
+
+	It("should not uncordon nodes if not requested", func() {
+		_ = NewNode(fmt.Sprintf("cordoned-node-%s", id)).
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			Unschedulable(true).
+			Create()
+
+		Expect(stateManager.CleanupUpgradeState(ctx, false)).To(Succeed())
+		cleanupCordonManager.AssertNotCalled(GinkgoT(), "Uncordon", mock.Anything, mock.Anything)
+	})
+
+	It("should remove the upgrade state taint", func() {
+		node := NewNode(fmt.Sprintf("tainted-node-%s", id)).Create()
+		otherTaint := corev1.Taint{Key: "example.com/dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}
+		node.Spec.Taints = []corev1.Taint{otherTaint, {
+			Key:    upgrade.GetUpgradeStateTaintKey(),
+			Value:  upgrade.UpgradeStateDrainRequired,
+			Effect: corev1.TaintEffectNoSchedule,
+		}}
+		Expect(k8sClient.Update(ctx, node)).To(Succeed())
+
+		Expect(stateManager.CleanupUpgradeState(ctx, false)).To(Succeed())
+		Expect(getNode(node.Name).Spec.Taints).To(ConsistOf(otherTaint))
+	})
+})
+
+var _ = Describe("AbortNodeUpgrades", func() {
+	var ctx context.Context
+	var id string
+	var stateManager *upgrade.ClusterUpgradeStateManagerImpl
+	var abortCordonManager *mocks.CordonManager
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		id = randSeq(5)
+		stateManagerInterface, err := upgrade.NewClusterUpgradeStateManager(log, k8sConfig, eventRecorder)
+		Expect(err).NotTo(HaveOccurred())
+		stateManager, _ = stateManagerInterface.(*upgrade.ClusterUpgradeStateManagerImpl)
+		abortCordonManager = &mocks.CordonManager{}
+		abortCordonManager.On("Uncordon", mock.Anything, mock.Anything).Return(nil)
+		stateManager.CordonManager = abortCordonManager
+	})
+
+	It("should uncordon the aborted nodes and remove their upgrade state", func() {
+		restartingNode := NewNode(fmt.Sprintf("restarting-node-%s", id)).
+			WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).
+			WithAnnotations(map[string]string{
+				upgrade.GetUpgradeRequestedAnnotationKey(): "true",
+				"foo": "bar",
+			}).
+			Unschedulable(true).
+			Create()
+		initiallyCordonedNode := NewNode(fmt.Sprintf("initially-cordoned-node-%s", id)).
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			WithAnnotations(map[string]string{upgrade.GetUpgradeInitialStateAnnotationKey(): "true"}).
+			Unschedulable(true).
+			Create()
+		otherNode := NewNode(fmt.Sprintf("other-node-%s", id)).
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			Unschedulable(true).
+			Create()
+
+		Expect(stateManager.AbortNodeUpgrades(ctx, restartingNode.Name, initiallyCordonedNode.Name)).To(Succeed())
+
+		abortedNode := getNode(restartingNode.Name)
+		Expect(abortedNode.Labels).NotTo(HaveKey(upgrade.GetUpgradeStateLabelKey()))
+		Expect(abortedNode.Annotations).NotTo(HaveKey(upgrade.GetUpgradeRequestedAnnotationKey()))
+		Expect(abortedNode.Annotations).To(HaveKeyWithValue("foo", "bar"))
+		abortedNode = getNode(initiallyCordonedNode.Name)
+		Expect(abortedNode.Labels).NotTo(HaveKey(upgrade.GetUpgradeStateLabelKey()))
+		Expect(abortedNode.Annotations).NotTo(HaveKey(upgrade.GetUpgradeInitialStateAnnotationKey()))
+		Expect(getNodeUpgradeState(getNode(otherNode.Name))).To(Equal(upgrade.UpgradeStateDrainRequired))
+
+		abortCordonManager.AssertNumberOfCalls(GinkgoT(), "Uncordon", 1)
+		abortCordonManager.AssertCalled(GinkgoT(), "Uncordon", mock.Anything,
+			mock.MatchedBy(func(node *corev1.Node) bool { return node.Name == restartingNode.Name }))
+	})
+
+	It("should fail if a node doesn't exist", func() {
+		Expect(stateManager.AbortNodeUpgrades(ctx, fmt.Sprintf("missing-node-%s", id))).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,146 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// maxReportedUnschedulablePods is the maximum count of unschedulable pods named by the node condition health gate
+const maxReportedUnschedulablePods = 5
+
+// ClusterHealthGate checks the health of the cluster on every ApplyState pass. While the cluster is unhealthy,
+// no node upgrade is started, the upgrades already started are completed.
+type ClusterHealthGate interface {
+	// CheckClusterHealth returns nil if node upgrades can be started and an error describing how the health of
+	// the cluster is degraded otherwise
+	CheckClusterHealth(ctx context.Context) error
+}
+
+// nodeConditionHealthGate is the ClusterHealthGate based on the node conditions, see NewNodeConditionHealthGate
+type nodeConditionHealthGate struct {
+	k8sClient          client.Client
+	maxNotReadyNodes   int
+	criticalNamespaces []string
+}
+
+// NewNodeConditionHealthGate returns a ClusterHealthGate reporting the cluster unhealthy if more than
+// maxNotReadyNodes nodes of the cluster are not Ready, or if pods of the critical namespaces can't be scheduled
+func NewNodeConditionHealthGate(k8sClient client.Client, maxNotReadyNodes int,
+	criticalNamespaces ...string) ClusterHealthGate {
+	return &nodeConditionHealthGate{
+		k8sClient:          k8sClient,
+		maxNotReadyNodes:   max(maxNotReadyNodes, 0),
+		criticalNamespaces: criticalNamespaces,
+	}
+}
+
+// CheckClusterHealth implements ClusterHealthGate
+func (g *nodeConditionHealthGate) CheckClusterHealth(ctx context.Context) error {
+	nodeList := &corev1.NodeList{}
+	if err := g.k8sClient.List(ctx, nodeList); err != nil {
+		return fmt.Errorf("failed to list cluster nodes: %v", err)
+	}
+	notReadyNodes := 0
+	for i := range nodeList.Items {
+		if !isNodeReady(&nodeList.Items[i]) {
+			notReadyNodes++
+		}
+	}
+	if notReadyNodes > g.maxNotReadyNodes {
+		return fmt.Errorf("%d nodes are not Ready, at most %d are allowed", notReadyNodes, g.maxNotReadyNodes)
+	}
+
+	for _, namespace := range g.criticalNamespaces {
+		podList := &corev1.PodList{}
+		if err := g.k8sClient.List(ctx, podList, client.InNamespace(namespace)); err != nil {
+			return fmt.Errorf("failed to list pods of namespace %s: %v", namespace, err)
+		}
+		var unschedulablePods []string
+		for i := range podList.Items {
+			if isPodUnschedulable(&podList.Items[i]) {
+				unschedulablePods = append(unschedulablePods, podList.Items[i].Name)
+			}
+		}
+		if len(unschedulablePods) > 0 {
+			return fmt.Errorf("%d pods of critical namespace %s are unschedulable: %v", len(unschedulablePods),
+				namespace, unschedulablePods[:min(len(unschedulablePods), maxReportedUnschedulablePods)])
+		}
+	}
+	return nil
+}
+
+// isPodUnschedulable returns true if the scheduler failed to find a node for the pending pod
+func isPodUnschedulable(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodPending || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse &&
+			condition.Reason == corev1.PodReasonUnschedulable {
+			return true
+		}
+	}
+	return false
+}
+
+// WithClusterHealthGate provides an option to check the health of the cluster on every ApplyState pass and hold
+// the nodes in the upgrade-required state while the cluster is unhealthy, see NewNodeConditionHealthGate
+func (m *ClusterUpgradeStateManagerImpl) WithClusterHealthGate(gate ClusterHealthGate) ClusterUpgradeStateManager {
+	m.clusterHealthGate = gate
+	return m
+}
+
+// checkClusterHealth returns nil if no cluster health gate is set or the cluster is healthy. A failure of the
+// check itself is reported as degraded health, so that no upgrade is started while the health is unknown.
+func (m *ClusterUpgradeStateManagerImpl) checkClusterHealth(ctx context.Context) error {
+	if m.clusterHealthGate == nil {
+		return nil
+	}
+	err := m.clusterHealthGate.CheckClusterHealth(ctx)
+	if err != nil {
+		m.Log.V(consts.LogLevelWarning).Info("Cluster health is degraded, node upgrades are not started",
+			"reason", err.Error())
+	}
+	return err
+}
+
+// waitForClusterHealth keeps the UpgradeStateUpgradeRequired nodes in their state until the cluster is healthy
+// again and sets the reason of their state. An event is recorded on the nodes which were not held yet.
+func (m *ClusterUpgradeStateManagerImpl) waitForClusterHealth(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, healthErr error) error {
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateUpgradeRequired] {
+		node := nodeState.Node
+		if m.keys.GetNodeUpgradeStateReason(node) != UpgradeStateReasonClusterUnhealthy {
+			logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+				"Node upgrade is not started, cluster health is degraded: %v", healthErr)
+		}
+		err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonClusterUnhealthy)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(
+				err, "Failed to set node upgrade state reason", "node", node.Name)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// getClusterUpgradesAvailable returns the count of node upgrades which can be started without exceeding
+// the clusterMaxUnavailable budget of the upgrade policy, or math.MaxInt if the policy has no such budget.
+// All the nodes of the cluster are counted, a node is unavailable if it is cordoned, not Ready, or about to be
+// cordoned for the driver upgrade.
+func (m *ClusterUpgradeStateManagerImpl) getClusterUpgradesAvailable(ctx context.Context,
+	upgradePolicy *v1alpha1.DriverUpgradePolicySpec) (int, error) {
+	if upgradePolicy.ClusterMaxUnavailable == nil {
+		return math.MaxInt, nil
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := m.K8sClient.List(ctx, nodeList); err != nil {
+		return 0, fmt.Errorf("failed to list cluster nodes: %v", err)
+	}
+	clusterMaxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(
+		upgradePolicy.ClusterMaxUnavailable, len(nodeList.Items), true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute clusterMaxUnavailable from the cluster nodes: %v", err)
+	}
+
+	unavailableNodes := 0
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		if m.isNodeUnschedulable(node) || !m.isNodeConditionReady(node) ||
+			m.getNodeUpgradeState(node) == UpgradeStateCordonRequired {
+			unavailableNodes++
+		}
+	}
+	m.Log.V(consts.LogLevelDebug).Info("Cluster unavailable nodes",
+		"unavailable nodes", unavailableNodes, "cluster nodes", len(nodeList.Items),
+		"maximum cluster nodes that can be unavailable", clusterMaxUnavailable)
+	return max(clusterMaxUnavailable-unavailableNodes, 0), nil
+}
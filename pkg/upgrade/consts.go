@@ -17,10 +17,30 @@ limitations under the License.
 package upgrade
 
 const (
+	// DefaultKeyPrefix is the default prefix of the node label, annotation and taint keys, see WithKeyPrefix
+	DefaultKeyPrefix = "nvidia.com"
 	// UpgradeStateLabelKeyFmt is the format of the node label key indicating driver upgrade states
 	UpgradeStateLabelKeyFmt = "nvidia.com/%s-driver-upgrade-state"
+	// UpgradeStateAnnotationKeyFmt is the format of the node annotation key indicating driver upgrade states,
+	// used instead of the state label by AnnotationStateStorage
+	UpgradeStateAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-state"
+	// UpgradeStateTaintKeyFmt is the format of the node taint key indicating driver upgrade states,
+	// used by TaintStateStorage
+	UpgradeStateTaintKeyFmt = "nvidia.com/%s-driver-upgrade-state"
+	// UpgradeStateStoredLabelKeyFmt is the format of the node label key set by AnnotationStateStorage and
+	// TaintStateStorage on the nodes having an upgrade state, so that the nodes can be listed by label
+	UpgradeStateStoredLabelKeyFmt = "nvidia.com/%s-driver-upgrade-state-stored"
+	// UpgradeImpactAnnotationKeyFmt is the format of the node annotation key containing the expected impact
+	// of the driver upgrade of the node
+	UpgradeImpactAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-impact"
+	// UpgradeHistoryAnnotationKeyFmt is the format of the node annotation key containing the last driver upgrades
+	// of the node
+	UpgradeHistoryAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-history"
 	// UpgradeSkipNodeLabelKeyFmt is the format of the node label boolean key indicating to skip driver upgrade
 	UpgradeSkipNodeLabelKeyFmt = "nvidia.com/%s-driver-upgrade.skip"
+	// UpgradeNodeWeightLabelKeyFmt is the format of the node label key indicating how many upgrade slots the node
+	// consumes when it is upgraded. Nodes without the label consume one slot.
+	UpgradeNodeWeightLabelKeyFmt = "nvidia.com/%s-driver-upgrade.weight"
 	// UpgradeWaitForSafeDriverLoadAnnotationKeyFmt is the format of the node annotation key indicating that
 	// the driver is waiting for safe load. Meaning node should be cordoned and workloads should be removed from the
 	// node before the driver can continue to load.
@@ -32,13 +52,89 @@ const (
 	// for waiting on pod completions
 	//nolint: lll
 	UpgradeWaitForPodCompletionStartTimeAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-wait-for-pod-completion-start-time"
+	// UpgradeWaitForPodCompletionRunningPodsAnnotationKeyFmt is the format of the node annotation containing the count
+	// of the workload pods still running on the node while waiting on pod completions
+	//nolint: lll
+	UpgradeWaitForPodCompletionRunningPodsAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-wait-for-pod-completion-running-pods"
 	// UpgradeValidationStartTimeAnnotationKeyFmt is the format of the node annotation indicating start time for
 	// validation-required state
 	UpgradeValidationStartTimeAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-validation-start-time"
+	// UpgradeNodeReadyWaitStartTimeAnnotationKeyFmt is the format of the node annotation indicating start time
+	// for waiting on the node to become Ready after the driver pod restart
+	UpgradeNodeReadyWaitStartTimeAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-node-ready-wait-start-time"
+	// UpgradeUncordonGateStartTimeAnnotationKeyFmt is the format of the node annotation indicating the time
+	// the uncordon checks of the node started failing
+	UpgradeUncordonGateStartTimeAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-uncordon-gate-start-time"
+	// UpgradeValidatorsStartTimeAnnotationKeyFmt is the format of the node annotation indicating start time for
+	// the validators of the validation-required state
+	UpgradeValidatorsStartTimeAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-validators-start-time"
+	// UpgradeRebootBootIDAnnotationKeyFmt is the format of the node annotation containing the boot ID of the node
+	// when its reboot was requested, kept until the upgrade is done so that the node is rebooted once per upgrade
+	UpgradeRebootBootIDAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-reboot-boot-id"
+	// UpgradeRebootStartTimeAnnotationKeyFmt is the format of the node annotation indicating start time for
+	// waiting on the node reboot
+	UpgradeRebootStartTimeAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-reboot-start-time"
+	// UpgradeStateStartTimeAnnotationKeyFmt is the format of the node annotation indicating the upgrade state
+	// the node is in and the time it entered the state, used to enforce the upgrade state timeouts
+	UpgradeStateStartTimeAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-state-start-time"
+	// UpgradeRetryAttemptsAnnotationKeyFmt is the format of the node annotation key containing the count of
+	// the retries of the failed node upgrade
+	UpgradeRetryAttemptsAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-retry-attempts"
+	// UpgradeRetryStartTimeAnnotationKeyFmt is the format of the node annotation indicating start time
+	// for the backoff of a failed node before its upgrade is retried
+	UpgradeRetryStartTimeAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-retry-start-time"
+	// UpgradeDowngradeAnnotationKeyFmt is the format of the node annotation key indicating that the upgrade
+	// of the node is a driver downgrade
+	UpgradeDowngradeAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.downgrade"
+	// UpgradeDowngradeApprovedAnnotationKeyFmt is the format of the node annotation key set by the admin to approve
+	// the driver downgrade of the node
+	UpgradeDowngradeApprovedAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.downgrade-approved"
+	// UpgradeDrainStatusAnnotationKeyFmt is the format of the node annotation key containing the progress
+	// of the drain of the node, see DrainStatus
+	UpgradeDrainStatusAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.drain-status"
+	// UpgradeDrainApprovedAnnotationKeyFmt is the format of the node annotation key set by the admin to approve
+	// the drain of a node evicting the only ready replica of a workload
+	UpgradeDrainApprovedAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.drain-approved"
+	// UpgradeDrainTimeoutAnnotationKeyFmt is the format of the node annotation key set by the admin to override
+	// the drain timeout of the upgrade policy for the node, e.g. "600s"
+	UpgradeDrainTimeoutAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.drain-timeout"
+	// UpgradeDrainForceAnnotationKeyFmt is the format of the node annotation key set by the admin to override
+	// the force option of the drain of the node, "true" or "false"
+	UpgradeDrainForceAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.drain-force"
+	// UpgradeDrainDeleteEmptyDirAnnotationKeyFmt is the format of the node annotation key set by the admin to override
+	// the deletion of the pods using emptyDir volumes during the drain of the node, "true" or "false"
+	UpgradeDrainDeleteEmptyDirAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.drain-delete-emptydir"
+	// UpgradeDrainLeaseAnnotationKeyFmt is the format of the node annotation key containing the lease of the manager
+	// draining the node
+	UpgradeDrainLeaseAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.drain-lease"
+	// UpgradeCanarySoakStartTimeAnnotationKeyFmt is the format of the node annotation indicating the time
+	// the soak period of a canary node started, i.e. its upgrade was done with a ready driver pod
+	UpgradeCanarySoakStartTimeAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.canary-soak-start-time"
+	// UpgradeNodeHookLabelKeyFmt is the format of the label key set on the node hook pods, containing the hook
+	UpgradeNodeHookLabelKeyFmt = "nvidia.com/%s-driver-upgrade.node-hook"
 	// UpgradeRequestedAnnotationKeyFmt is the format of the node label key indicating driver upgrade was requested
 	// (used for orphaned pods)
 	// Setting this label will trigger setting upgrade state to upgrade-required
 	UpgradeRequestedAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-requested"
+	// UpgradeStateReasonAnnotationKeyFmt is the format of the node annotation key containing a machine-readable reason
+	// explaining why the node is in its current upgrade state. The annotation is removed on every state change.
+	UpgradeStateReasonAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade-state-reason"
+	// UpgradeManualUncordonAnnotationKeyFmt is the format of the node annotation key indicating that the node was
+	// manually uncordoned during the upgrade and the change was adopted by the upgrade library
+	UpgradeManualUncordonAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.manually-uncordoned"
+	// UpgradeFailedNodeCordonAnnotationKeyFmt is the format of the node annotation key recording whether the node
+	// in the upgrade-failed state was left cordoned or uncordoned, according to the upgrade policy
+	UpgradeFailedNodeCordonAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.failed-node-cordon"
+	// UpgradeSlotsAvailableAnnotationKeyFmt is the format of the status ConfigMap annotation key containing the count
+	// of node upgrades which can be started
+	UpgradeSlotsAvailableAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.slots-available"
+	// UpgradeNextEligibleNodesAnnotationKeyFmt is the format of the status ConfigMap annotation key containing
+	// the comma separated names of the nodes which are upgraded next
+	UpgradeNextEligibleNodesAnnotationKeyFmt = "nvidia.com/%s-driver-upgrade.next-eligible-nodes"
+	// NodeUpgradeClaimAnnotationKey is the node annotation key containing the claim of the operator upgrading
+	// the node. It is shared by all the drivers and key prefixes, so that the operators upgrading different drivers
+	// on the same nodes see the claims of each other
+	NodeUpgradeClaimAnnotationKey = "nvidia.com/driver-upgrade-claim"
 	// UpgradeStateUnknown Node has this state when the upgrade flow is disabled or the node hasn't been processed yet
 	UpgradeStateUnknown = ""
 	// UpgradeStateUpgradeRequired is set when the driver pod on the node is not up-to-date and required upgrade
@@ -53,6 +149,9 @@ const (
 	// UpgradeStateDrainRequired is set when the node is required to be scheduled for drain. After the drain the state
 	// is changed either to UpgradeStatePodRestartRequired or UpgradeStateFailed
 	UpgradeStateDrainRequired = "drain-required"
+	// UpgradeStateRebootRequired is set when the node must be rebooted before the driver pod restart,
+	// see WithNodeReboot. After the reboot the state is changed back to UpgradeStatePodRestartRequired.
+	UpgradeStateRebootRequired = "reboot-required"
 	// UpgradeStatePodRestartRequired is set when the driver pod on the node is scheduled for restart
 	// or when unblock of the driver loading is required (safe driver load)
 	UpgradeStatePodRestartRequired = "pod-restart-required"
@@ -65,6 +164,88 @@ const (
 	UpgradeStateDone = "upgrade-done"
 	// UpgradeStateFailed is set when there are any failures during the driver upgrade
 	UpgradeStateFailed = "upgrade-failed"
+	// UpgradeStateDaemonSetMissing is set when the driver DaemonSet managing the node disappeared during the upgrade.
+	// The upgrade of the node is aborted and the node is uncordoned if it was cordoned by the upgrade.
+	UpgradeStateDaemonSetMissing = "daemonset-missing"
+	// UpgradeStateBlockedBySkew is set when the upgrade of the node driver from its current version to the target
+	// version is not supported, see WithVersionCompatibilityChecker. The node is re-evaluated once it is supported.
+	UpgradeStateBlockedBySkew = "blocked-by-skew"
+)
+
+const (
+	// UpgradeStateReasonWaitingForSlot is set when the node requires upgrade but the upgrade can't be started
+	// because the maxParallelUpgrades, maxUnavailable or clusterMaxUnavailable limits are reached
+	UpgradeStateReasonWaitingForSlot = "WaitingForSlot"
+	// UpgradeStateReasonWaitingForZoneSlot is set when the node requires upgrade but the upgrade can't be started
+	// because the maxParallelUpgradesPerZone limit is reached in the zone of the node
+	UpgradeStateReasonWaitingForZoneSlot = "WaitingForZoneSlot"
+	// UpgradeStateReasonDrainBlockedByPDB is set when the node drain is blocked by a PodDisruptionBudget
+	UpgradeStateReasonDrainBlockedByPDB = "DrainBlockedByPDB"
+	// UpgradeStateReasonWaitingForPDB is set when the node requires upgrade but its drain would be blocked
+	// by a PodDisruptionBudget, and the PDB aware selection of the upgrade policy defers such nodes
+	UpgradeStateReasonWaitingForPDB = "WaitingForPDB"
+	// UpgradeStateReasonWaitingForScaleUp is set when the node drain would evict the only ready replica
+	// of a workload and waits for the workload to have another ready replica
+	UpgradeStateReasonWaitingForScaleUp = "WaitingForScaleUp"
+	// UpgradeStateReasonDrainApprovalRequired is set when the node drain would evict the only ready replica
+	// of a workload and waits for the drain approval annotation
+	UpgradeStateReasonDrainApprovalRequired = "DrainApprovalRequired"
+	// UpgradeStateReasonWaitingForCanary is set when the node requires upgrade but the upgrade can't be started
+	// until the canary nodes are upgraded and their soak period is over
+	UpgradeStateReasonWaitingForCanary = "WaitingForCanary"
+	// UpgradeStateReasonInMaintenanceWindowWait is set when the node upgrade is waiting for a maintenance window
+	UpgradeStateReasonInMaintenanceWindowWait = "InMaintenanceWindowWait"
+	// UpgradeStateReasonInBlackoutPeriod is set when the node upgrade is waiting for a blackout period to end
+	UpgradeStateReasonInBlackoutPeriod = "InBlackoutPeriod"
+	// UpgradeStateReasonClusterUnhealthy is set when the node upgrade is waiting for the cluster health gate
+	// to report the cluster healthy again
+	UpgradeStateReasonClusterUnhealthy = "ClusterUnhealthy"
+	// UpgradeStateReasonNodeUnhealthy is set when the node requires upgrade but the upgrade isn't started because
+	// the node has a condition or a taint of the node health selection of the upgrade policy
+	UpgradeStateReasonNodeUnhealthy = "NodeUnhealthy"
+	// UpgradeStateReasonDriverPodPending is set when the replacement driver pod of the node stayed Pending for
+	// longer than the pending timeout of the OnDelete rollout and the node was moved to the upgrade-failed state
+	UpgradeStateReasonDriverPodPending = "DriverPodPending"
+	// UpgradeStateReasonOverBudget is set when the node is about to be cordoned, but is held back because more
+	// upgrades are in progress than maxParallelUpgrades allows
+	UpgradeStateReasonOverBudget = "OverBudget"
+	// UpgradeStateReasonRetryBackoff is set when the node upgrade failed and waits before it is retried
+	UpgradeStateReasonRetryBackoff = "RetryBackoff"
+	// UpgradeStateReasonWaitingForNodeReady is set when the driver pod was restarted, but the node is not Ready
+	UpgradeStateReasonWaitingForNodeReady = "WaitingForNodeReady"
+	// UpgradeStateReasonHealthProbeFailed is set when the driver pod of a failed node is in sync,
+	// but a driver health probe doesn't pass
+	UpgradeStateReasonHealthProbeFailed = "HealthProbeFailed"
+	// UpgradeStateReasonStateTimeout is set when the node stayed in an upgrade state longer than the timeout
+	// of the state and was moved to the upgrade-failed state
+	UpgradeStateReasonStateTimeout = "StateTimeout"
+	// UpgradeStateReasonDowngradeApprovalRequired is set when the upgrade of the node is a driver downgrade
+	// waiting for the downgrade approval annotation
+	UpgradeStateReasonDowngradeApprovalRequired = "DowngradeApprovalRequired"
+	// UpgradeStateReasonNodeHookFailed is set when a node hook pod failed and the node was moved to the
+	// upgrade-failed state
+	UpgradeStateReasonNodeHookFailed = "NodeHookFailed"
+	// UpgradeStateReasonOrphanedDriverPod is set when the driver pod of the node has no driver DaemonSet and
+	// the node was moved to the upgrade-failed state by the orphaned pod policy
+	UpgradeStateReasonOrphanedDriverPod = "OrphanedDriverPod"
+	// UpgradeStateReasonUncordonCheckFailed is set when a check of the uncordon gate doesn't pass, the node
+	// stays cordoned in the uncordon-required state, or was moved to the upgrade-failed state after the timeout
+	UpgradeStateReasonUncordonCheckFailed = "UncordonCheckFailed"
+	// UpgradeStateReasonValidationFailed is set when a validator failed or timed out and the node was moved to
+	// the upgrade-failed state by the validation failure policy
+	UpgradeStateReasonValidationFailed = "ValidationFailed"
+	// UpgradeStateReasonWaitingForReboot is set when the reboot of the node was requested and the node
+	// didn't come back Ready yet
+	UpgradeStateReasonWaitingForReboot = "WaitingForReboot"
+	// UpgradeStateReasonRebootTimeout is set when the node didn't come back Ready within the reboot timeout
+	// and was moved to the upgrade-failed state
+	UpgradeStateReasonRebootTimeout = "RebootTimeout"
+	// UpgradeStateReasonWaitingForNodeMaintenance is set when the maintenance of the node was requested with
+	// a NodeMaintenance resource which isn't ready yet
+	UpgradeStateReasonWaitingForNodeMaintenance = "WaitingForNodeMaintenance"
+	// UpgradeStateReasonWaitForCompletionTimeout is set when the workload pods of the node didn't complete within
+	// the wait for completion timeout and the timeout action fails the node or keeps it waiting
+	UpgradeStateReasonWaitForCompletionTimeout = "WaitForCompletionTimeout"
 )
 
 const (
@@ -0,0 +1,104 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// getDaemonSetScope returns the upgrade scope of the node, the namespace and name of its driver DaemonSet,
+// or the default node pool name for the nodes of orphaned driver pods
+func getDaemonSetScope(nodeState *NodeUpgradeState) string {
+	if nodeState.DriverDaemonSet == nil {
+		return defaultNodePool
+	}
+	return nodeState.DriverDaemonSet.Namespace + "/" + nodeState.DriverDaemonSet.Name
+}
+
+// ApplyStateForDaemonSets processes the complete cluster upgrade state like ApplyState, separately for the nodes
+// of every driver DaemonSet, e.g. the DaemonSets of different driver versions covering disjoint node pools.
+// Every DaemonSet has its own upgrade limits, e.g. MaxParallelUpgrades and MaxUnavailable, and its own upgrade
+// sessions, see WithUpgradeSessionHooks, so that the rollout of a DaemonSet isn't serialized behind the rollout
+// of another one. The nodes covered by several DaemonSets are processed with the DaemonSet of their primary driver,
+// as BuildState merges their drivers in one node state, a node with node states of several DaemonSets is rejected.
+// A failure of a DaemonSet doesn't prevent the processing of the other DaemonSets, the errors of all the DaemonSets
+// are returned.
+func (m *ClusterUpgradeStateManagerImpl) ApplyStateForDaemonSets(ctx context.Context,
+	currentState *ClusterUpgradeState, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) error {
+	if currentState == nil {
+		return fmt.Errorf("currentState should not be empty")
+	}
+
+	scopeStates := make(map[string]*ClusterUpgradeState)
+	nodeScopes := make(map[string]string)
+	for state, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			scope := getDaemonSetScope(nodeState)
+			if other, ok := nodeScopes[nodeState.Node.Name]; ok && other != scope {
+				return fmt.Errorf("node %s is covered by driver daemonsets %s and %s, "+
+					"the daemonsets must cover disjoint nodes", nodeState.Node.Name, other, scope)
+			}
+			nodeScopes[nodeState.Node.Name] = scope
+			scopeState, ok := scopeStates[scope]
+			if !ok {
+				newState := NewClusterUpgradeState()
+				scopeState = &newState
+				scopeStates[scope] = scopeState
+			}
+			scopeState.NodeStates[state] = append(scopeState.NodeStates[state], nodeState)
+		}
+	}
+	scopes := make([]string, 0, len(scopeStates))
+	for scope := range scopeStates {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	// The status and the metrics are updated once for all the DaemonSets
+	m.upgradeCapacity.reset()
+	defer func() {
+		m.stateMetrics.update(currentState, m.stateStorage)
+		for _, scope := range scopes {
+			m.updateUpgradeSession(ctx, scopeStates[scope], scope)
+		}
+		// the session of a DaemonSet which has no nodes anymore, e.g. deleted, is completed as superseded
+		for _, session := range m.GetUpgradeSessions() {
+			if _, ok := scopeStates[session.Scope]; !ok && session.Scope != "" {
+				emptyState := NewClusterUpgradeState()
+				m.updateUpgradeSession(ctx, &emptyState, session.Scope)
+			}
+		}
+		if statusErr := m.updateStatusConfigMap(ctx, currentState); statusErr != nil {
+			m.Log.V(consts.LogLevelError).Error(statusErr, "Failed to update upgrade status ConfigMap")
+		}
+	}()
+	var errs []error
+	for _, scope := range scopes {
+		if err := m.applyState(ctx, scopeStates[scope], upgradePolicy, scope); err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to apply state of the driver daemonset",
+				"daemonset", scope)
+			errs = append(errs, fmt.Errorf("driver daemonset %s: %v", scope, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+)
+
+// DisruptionReport describes the worst-case simultaneous disruption that a driver upgrade rollout could cause
+// in the cluster for a given upgrade policy
+type DisruptionReport struct {
+	// TotalNodes is the total count of nodes managed for driver upgrades
+	TotalNodes int
+	// MaxUnavailable is the maxUnavailable value of the policy, scaled to the total count of nodes
+	MaxUnavailable int
+	// MaxDisruptedNodes is the maximum count of nodes that can be unavailable at the same time during the rollout
+	MaxDisruptedNodes int
+	// TotalZones is the count of distinct zones the managed nodes belong to, the nodes without
+	// the topology.kubernetes.io/zone label don't belong to any zone
+	TotalZones int
+	// MaxDisruptedZones is the maximum count of zones that can have unavailable nodes at the same time
+	MaxDisruptedZones int
+	// CapacityResource is the name of the node resource used to compute the disrupted capacity
+	CapacityResource corev1.ResourceName
+	// TotalCapacity is the allocatable amount of CapacityResource on all managed nodes
+	TotalCapacity int64
+	// MaxDisruptedCapacity is the maximum allocatable amount of CapacityResource that can be unavailable at the
+	// same time, computed by taking the nodes with the largest capacity first
+	MaxDisruptedCapacity int64
+	// MaxDisruptedCapacityFraction is MaxDisruptedCapacity relative to TotalCapacity, in the range [0, 1]
+	MaxDisruptedCapacityFraction float64
+}
+
+// SimulateDisruption computes the worst-case simultaneous disruption the upgrade rollout described by upgradePolicy
+// could cause on the nodes in currentState. Nodes which are already unavailable (cordoned or not ready) are counted
+// as disrupted. The upgrades in parallel are limited per zone by maxParallelUpgradesPerZone like in ApplyState,
+// the nodes without zone label being limited together. capacityResource is the node resource (e.g. nvidia.com/gpu)
+// used to compute the disrupted capacity, it can be left empty if capacity is not of interest.
+// The function does not modify the cluster, so it can be used to validate policy settings before AutoUpgrade is
+// enabled.
+func SimulateDisruption(currentState *ClusterUpgradeState, upgradePolicy *v1alpha1.DriverUpgradePolicySpec,
+	capacityResource corev1.ResourceName) (*DisruptionReport, error) {
+	if currentState == nil {
+		return nil, fmt.Errorf("currentState should not be empty")
+	}
+	if upgradePolicy == nil {
+		return nil, fmt.Errorf("upgradePolicy should not be empty")
+	}
+
+	nodes := make([]*corev1.Node, 0)
+	for _, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			nodes = append(nodes, nodeState.Node)
+		}
+	}
+
+	report := &DisruptionReport{TotalNodes: len(nodes), CapacityResource: capacityResource}
+
+	report.MaxUnavailable = report.TotalNodes
+	if upgradePolicy.MaxUnavailable != nil {
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(
+			upgradePolicy.MaxUnavailable, report.TotalNodes, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute maxUnavailable from the total nodes: %v", err)
+		}
+		report.MaxUnavailable = maxUnavailable
+	}
+
+	maxParallelUpgrades := upgradePolicy.MaxParallelUpgrades
+	if maxParallelUpgrades == 0 || maxParallelUpgrades > report.TotalNodes {
+		maxParallelUpgrades = report.TotalNodes
+	}
+	report.MaxDisruptedNodes = maxParallelUpgrades
+	if report.MaxDisruptedNodes > report.MaxUnavailable {
+		report.MaxDisruptedNodes = report.MaxUnavailable
+	}
+
+	maxPerZone := upgradePolicy.MaxParallelUpgradesPerZone
+	zoneNodes := make(map[string]int)
+	for _, node := range nodes {
+		zoneNodes[GetNodeZone(node)]++
+	}
+	if maxPerZone > 0 {
+		zonesLimit := 0
+		for _, count := range zoneNodes {
+			zonesLimit += min(count, maxPerZone)
+		}
+		report.MaxDisruptedNodes = min(report.MaxDisruptedNodes, zonesLimit)
+	}
+
+	// nodes which are already unavailable are disrupted regardless of the policy
+	alreadyUnavailable := 0
+	for _, node := range nodes {
+		if isNodeUnschedulable(node) || !isNodeReady(node) {
+			alreadyUnavailable++
+		}
+	}
+	if alreadyUnavailable > report.MaxDisruptedNodes {
+		report.MaxDisruptedNodes = alreadyUnavailable
+	}
+
+	for zone := range zoneNodes {
+		if zone != "" {
+			report.TotalZones++
+		}
+	}
+	report.MaxDisruptedZones = report.MaxDisruptedNodes
+	if report.MaxDisruptedZones > report.TotalZones {
+		report.MaxDisruptedZones = report.TotalZones
+	}
+
+	if capacityResource != "" {
+		capacities := make([]nodeCapacity, 0, len(nodes))
+		for _, node := range nodes {
+			capacity := node.Status.Allocatable[capacityResource]
+			capacities = append(capacities, nodeCapacity{zone: GetNodeZone(node), capacity: capacity.Value()})
+			report.TotalCapacity += capacity.Value()
+		}
+		// worst case is when the nodes with the largest capacity are disrupted together, within the limit per zone
+		sort.SliceStable(capacities, func(i, j int) bool { return capacities[i].capacity > capacities[j].capacity })
+		zoneDisrupted := make(map[string]int)
+		disrupted := 0
+		for _, node := range capacities {
+			if disrupted == report.MaxDisruptedNodes {
+				break
+			}
+			if maxPerZone > 0 && zoneDisrupted[node.zone] == maxPerZone {
+				continue
+			}
+			zoneDisrupted[node.zone]++
+			disrupted++
+			report.MaxDisruptedCapacity += node.capacity
+		}
+		if report.TotalCapacity > 0 {
+			report.MaxDisruptedCapacityFraction = float64(report.MaxDisruptedCapacity) / float64(report.TotalCapacity)
+		}
+	}
+
+	return report, nil
+}
+
+// nodeCapacity is the allocatable amount of the capacity resource of a node in a zone
+type nodeCapacity struct {
+	zone     string
+	capacity int64
+}
+
+// isNodeReady returns true if the node Ready condition is not set to anything but True
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status != corev1.ConditionTrue {
+			return false
+		}
+	}
+	return true
+}
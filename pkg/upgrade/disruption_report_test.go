@@ -0,0 +1,134 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade/upgradetest"
+)
+
+var _ = Describe("SimulateDisruption", func() {
+	const gpuResource = corev1.ResourceName("nvidia.com/gpu")
+
+	newNodeState := func(name, zone string, gpus string) *upgrade.NodeUpgradeState {
+		node := NewNode(name).Node
+		node.Labels[corev1.LabelTopologyZone] = zone
+		node.Status.Allocatable = corev1.ResourceList{gpuResource: resource.MustParse(gpus)}
+		return &upgrade.NodeUpgradeState{Node: node}
+	}
+
+	var clusterState upgrade.ClusterUpgradeState
+
+	BeforeEach(func() {
+		clusterState = upgrade.NewClusterUpgradeState()
+		clusterState.NodeStates[upgrade.UpgradeStateDone] = []*upgrade.NodeUpgradeState{
+			newNodeState("node-1", "zone-a", "8"),
+			newNodeState("node-2", "zone-a", "4"),
+			newNodeState("node-3", "zone-b", "2"),
+			newNodeState("node-4", "zone-c", "2"),
+		}
+	})
+
+	It("should fail on nil input", func() {
+		_, err := upgrade.SimulateDisruption(nil, &v1alpha1.DriverUpgradePolicySpec{}, gpuResource)
+		Expect(err).To(HaveOccurred())
+		_, err = upgrade.SimulateDisruption(&clusterState, nil, gpuResource)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should bound disruption by maxParallelUpgrades", func() {
+		policy := &v1alpha1.DriverUpgradePolicySpec{MaxParallelUpgrades: 2}
+		report, err := upgrade.SimulateDisruption(&clusterState, policy, gpuResource)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.TotalNodes).To(Equal(4))
+		Expect(report.MaxDisruptedNodes).To(Equal(2))
+		Expect(report.TotalZones).To(Equal(3))
+		Expect(report.MaxDisruptedZones).To(Equal(2))
+		Expect(report.TotalCapacity).To(Equal(int64(16)))
+		Expect(report.MaxDisruptedCapacity).To(Equal(int64(12)))
+		Expect(report.MaxDisruptedCapacityFraction).To(BeNumerically("~", 0.75))
+	})
+
+	It("should bound disruption by maxUnavailable", func() {
+		maxUnavailable := intstr.FromString("25%")
+		policy := &v1alpha1.DriverUpgradePolicySpec{MaxParallelUpgrades: 0, MaxUnavailable: &maxUnavailable}
+		report, err := upgrade.SimulateDisruption(&clusterState, policy, gpuResource)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.MaxUnavailable).To(Equal(1))
+		Expect(report.MaxDisruptedNodes).To(Equal(1))
+		Expect(report.MaxDisruptedCapacity).To(Equal(int64(8)))
+	})
+
+	It("should count already unavailable nodes as disrupted", func() {
+		for _, nodeState := range clusterState.NodeStates[upgrade.UpgradeStateDone][:3] {
+			nodeState.Node.Spec.Unschedulable = true
+		}
+		policy := &v1alpha1.DriverUpgradePolicySpec{MaxParallelUpgrades: 1}
+		report, err := upgrade.SimulateDisruption(&clusterState, policy, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.MaxDisruptedNodes).To(Equal(3))
+		Expect(report.TotalCapacity).To(BeZero())
+	})
+
+	It("should bound disruption by maxParallelUpgradesPerZone", func() {
+		policy := &v1alpha1.DriverUpgradePolicySpec{MaxParallelUpgrades: 4, MaxParallelUpgradesPerZone: 1}
+		report, err := upgrade.SimulateDisruption(&clusterState, policy, gpuResource)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.MaxDisruptedNodes).To(Equal(3))
+		Expect(report.MaxDisruptedZones).To(Equal(3))
+		// only one of the nodes of zone-a is disrupted
+		Expect(report.MaxDisruptedCapacity).To(Equal(int64(12)))
+	})
+
+	It("should not count the nodes without zone label as a zone", func() {
+		for _, name := range []string{"node-5", "node-6"} {
+			nodeState := newNodeState(name, "", "1")
+			delete(nodeState.Node.Labels, corev1.LabelTopologyZone)
+			clusterState.NodeStates[upgrade.UpgradeStateDone] = append(
+				clusterState.NodeStates[upgrade.UpgradeStateDone], nodeState)
+		}
+		policy := &v1alpha1.DriverUpgradePolicySpec{MaxParallelUpgrades: 6, MaxParallelUpgradesPerZone: 1}
+		report, err := upgrade.SimulateDisruption(&clusterState, policy, gpuResource)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.TotalZones).To(Equal(3))
+		Expect(report.MaxDisruptedZones).To(Equal(3))
+		// the nodes without zone label are limited together
+		Expect(report.MaxDisruptedNodes).To(Equal(4))
+	})
+
+	It("should bound disruption of a three zone cluster", func() {
+		cluster := upgradetest.ThreeZoneCluster()
+		maxUnavailable := intstr.FromString("5%")
+		policy := &v1alpha1.DriverUpgradePolicySpec{MaxParallelUpgrades: 10, MaxUnavailable: &maxUnavailable}
+		report, err := upgrade.SimulateDisruption(cluster.UpgradeState(), policy, upgradetest.GPUResource)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.TotalNodes).To(Equal(upgradetest.ZoneCount * upgradetest.NodesPerZone))
+		Expect(report.MaxUnavailable).To(Equal(15))
+		Expect(report.MaxDisruptedNodes).To(Equal(10))
+		Expect(report.TotalZones).To(Equal(upgradetest.ZoneCount))
+		Expect(report.MaxDisruptedZones).To(Equal(upgradetest.ZoneCount))
+		Expect(report.TotalCapacity).To(Equal(int64(2400)))
+		Expect(report.MaxDisruptedCapacity).To(Equal(int64(80)))
+	})
+})
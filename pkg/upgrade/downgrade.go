@@ -0,0 +1,190 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// IsNodeDowngrade returns true if the upgrade of the node was detected as a driver downgrade
+func (k UpgradeKeys) IsNodeDowngrade(node *corev1.Node) bool {
+	return node.Annotations[k.GetUpgradeDowngradeAnnotationKey()] == trueString
+}
+
+// IsNodeDowngrade returns UpgradeKeys.IsNodeDowngrade with the default key prefix
+func IsNodeDowngrade(node *corev1.Node) bool {
+	return UpgradeKeys{}.IsNodeDowngrade(node)
+}
+
+// isNodeDowngradeApproved returns true if the admin approved the driver downgrade of the node
+func (k UpgradeKeys) isNodeDowngradeApproved(node *corev1.Node) bool {
+	return node.Annotations[k.GetUpgradeDowngradeApprovedAnnotationKey()] == trueString
+}
+
+// isDriverPodDowngrade returns true if the DaemonSet revision the driver pod is upgraded to was created before
+// the revision of the pod. The DaemonSet controller reuses the revision of a template it had before, so that
+// a rollback moves the pods to an older revision. The revisions are taken from the given list of the DaemonSet
+// revisions. The upgrade is not a downgrade if the revision of the pod was already garbage collected.
+func isDriverPodDowngrade(nodeState *NodeUpgradeState, revisions []appsv1.ControllerRevision) bool {
+	if nodeState.DriverDaemonSet == nil || len(revisions) == 0 {
+		return false
+	}
+	podRevisionName := fmt.Sprintf("%s-%s", nodeState.DriverDaemonSet.Name,
+		nodeState.DriverPod.Labels[PodControllerRevisionHashLabelKey])
+	var podRevision, currentRevision *appsv1.ControllerRevision
+	for i := range revisions {
+		if revisions[i].Name == podRevisionName {
+			podRevision = &revisions[i]
+		}
+		if currentRevision == nil || revisions[i].Revision > currentRevision.Revision {
+			currentRevision = &revisions[i]
+		}
+	}
+	if podRevision == nil || podRevision.Name == currentRevision.Name {
+		return false
+	}
+	return currentRevision.CreationTimestamp.Before(&podRevision.CreationTimestamp)
+}
+
+// getDaemonSetRevisions returns the controller revisions of the DaemonSet
+func (m *ClusterUpgradeStateManagerImpl) getDaemonSetRevisions(ctx context.Context,
+	daemonSet *appsv1.DaemonSet) ([]appsv1.ControllerRevision, error) {
+	listOptions := meta_v1.ListOptions{LabelSelector: labels.SelectorFromSet(daemonSet.Spec.Selector.MatchLabels).String()}
+	revisionList, err := m.K8sInterface.AppsV1().ControllerRevisions(daemonSet.Namespace).List(ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error getting controller revision list for daemonset %s: %v", daemonSet.Name, err)
+	}
+	revisions := make([]appsv1.ControllerRevision, 0, len(revisionList.Items))
+	for _, revision := range revisionList.Items {
+		if strings.HasPrefix(revision.Name, daemonSet.Name) {
+			revisions = append(revisions, revision)
+		}
+	}
+	return revisions, nil
+}
+
+// processDowngrades detects the driver downgrades of the nodes in the upgrade-required state and records them
+// on the nodes with the downgrade annotation, so that the later upgrade states can handle them. If the downgrade
+// policy requires an approval, the returned cluster state doesn't contain the downgrades which are not approved,
+// they wait in the upgrade-required state with the DowngradeApprovalRequired reason.
+func (m *ClusterUpgradeStateManagerImpl) processDowngrades(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, downgrade *v1alpha1.DowngradeSpec) (*ClusterUpgradeState, error) {
+	if downgrade == nil {
+		return currentClusterState, nil
+	}
+	m.Log.V(consts.LogLevelInfo).Info("ProcessDowngrades")
+
+	annotationKey := m.keys.GetUpgradeDowngradeAnnotationKey()
+	revisionsByDaemonSet := make(map[string][]appsv1.ControllerRevision)
+	nodeStates := currentClusterState.NodeStates[UpgradeStateUpgradeRequired]
+	approved := make([]*NodeUpgradeState, 0, len(nodeStates))
+	for _, nodeState := range nodeStates {
+		node := nodeState.Node
+		isDowngrade := false
+		if nodeState.DriverDaemonSet != nil {
+			dsKey := nodeState.DriverDaemonSet.Namespace + "/" + nodeState.DriverDaemonSet.Name
+			revisions, ok := revisionsByDaemonSet[dsKey]
+			if !ok {
+				var err error
+				revisions, err = m.getDaemonSetRevisions(ctx, nodeState.DriverDaemonSet)
+				if err != nil {
+					m.Log.V(consts.LogLevelError).Error(err, "Failed to get driver daemonset revisions",
+						"daemonset", dsKey)
+					return nil, err
+				}
+				revisionsByDaemonSet[dsKey] = revisions
+			}
+			isDowngrade = isDriverPodDowngrade(nodeState, revisions)
+		}
+
+		if isDowngrade != m.keys.IsNodeDowngrade(node) {
+			value := nullString
+			if isDowngrade {
+				m.Log.V(consts.LogLevelInfo).Info("Driver downgrade detected", "node", node.Name)
+				logEventf(m.EventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+					"Driver downgrade detected, the driver daemonset was rolled back")
+				value = trueString
+			}
+			err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, value)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Failed to update node downgrade annotation",
+					"node", node.Name, "annotation", annotationKey)
+				return nil, err
+			}
+		}
+
+		if isDowngrade && downgrade.RequireApproval && !m.keys.isNodeDowngradeApproved(node) {
+			m.Log.V(consts.LogLevelDebug).Info("Driver downgrade waits for approval", "node", node.Name)
+			err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node,
+				UpgradeStateReasonDowngradeApprovalRequired)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Failed to set node upgrade state reason", "node", node.Name)
+				return nil, err
+			}
+			continue
+		}
+		approved = append(approved, nodeState)
+	}
+
+	approvedState := NewClusterUpgradeState()
+	for state, states := range currentClusterState.NodeStates {
+		approvedState.NodeStates[state] = states
+	}
+	approvedState.NodeStates[UpgradeStateUpgradeRequired] = approved
+	return &approvedState, nil
+}
+
+// skipDowngradeDrain moves the downgraded nodes of the drain-required state to the pod-restart-required state
+// if the downgrade policy skips the drain, and returns the state without them
+func (m *ClusterUpgradeStateManagerImpl) skipDowngradeDrain(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, downgrade *v1alpha1.DowngradeSpec) (*ClusterUpgradeState, error) {
+	if downgrade == nil || !downgrade.SkipDrain {
+		return currentClusterState, nil
+	}
+	nodeStates := currentClusterState.NodeStates[UpgradeStateDrainRequired]
+	toDrain := make([]*NodeUpgradeState, 0, len(nodeStates))
+	for _, nodeState := range nodeStates {
+		if !m.keys.IsNodeDowngrade(nodeState.Node) {
+			toDrain = append(toDrain, nodeState)
+			continue
+		}
+		m.Log.V(consts.LogLevelInfo).Info("Driver downgrade, skipping drain", "node", nodeState.Node.Name)
+		err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, nodeState.Node, UpgradeStatePodRestartRequired)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(
+				err, "Failed to change node upgrade state", "state", UpgradeStatePodRestartRequired)
+			return nil, err
+		}
+	}
+
+	drainState := NewClusterUpgradeState()
+	for state, states := range currentClusterState.NodeStates {
+		drainState.NodeStates[state] = states
+	}
+	drainState.NodeStates[UpgradeStateDrainRequired] = toDrain
+	return &drainState, nil
+}
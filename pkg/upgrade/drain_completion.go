@@ -0,0 +1,56 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// DrainCompletionHandler is notified with the outcome of the drain of a node as soon as the drain finishes.
+// A drained node is already in the pod-restart-required state, a node whose drain failed in the upgrade-failed
+// state. The handler is called from the goroutine of the drain and must not block, e.g. it triggers a reconcile
+// of the operator so that the driver pod of the node is restarted without waiting for the next resync.
+type DrainCompletionHandler func(result DrainResult)
+
+// WithDrainCompletionHandler sets the handler notified when the drain of a node finishes, including the drains
+// cancelled before they started. It should be called before the first drain is scheduled.
+func (m *DrainManagerImpl) WithDrainCompletionHandler(handler DrainCompletionHandler) *DrainManagerImpl {
+	m.drainCompletionHandler = handler
+	return m
+}
+
+// notifyDrainCompletion calls the drain completion handler, if any, with the outcome of the drain
+func (m *DrainManagerImpl) notifyDrainCompletion(result DrainResult) {
+	if m.drainCompletionHandler != nil {
+		m.drainCompletionHandler(result)
+	}
+}
+
+// WithDrainCompletionHandler provides an option to be notified as soon as the drain of a node finishes, e.g. to
+// trigger the next ApplyState pass immediately instead of waiting for the next reconcile,
+// see DrainManagerImpl.WithDrainCompletionHandler
+func (m *ClusterUpgradeStateManagerImpl) WithDrainCompletionHandler(
+	handler DrainCompletionHandler) ClusterUpgradeStateManager {
+	drainManager, ok := m.DrainManager.(*DrainManagerImpl)
+	if !ok {
+		m.Log.V(consts.LogLevelWarning).Info(
+			"Cannot set the drain completion handler, the drain manager is not a DrainManagerImpl")
+		return m
+	}
+	drainManager.WithDrainCompletionHandler(handler)
+	return m
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// DrainLease is the lease of the manager draining a node, kept in the drain lease annotation of the node
+// while the drain runs and renewed periodically, so that the other managers, e.g. a new leader started while
+// the drain runs, don't drain the node again until the lease expires
+type DrainLease = NodeLease
+
+// GetDrainLease returns the drain lease of the node, false if the node has no valid drain lease annotation
+func (k UpgradeKeys) GetDrainLease(node *corev1.Node) (DrainLease, bool) {
+	lease := DrainLease{}
+	if !getNodeLeaseAnnotation(node, k.GetUpgradeDrainLeaseAnnotationKey(), &lease) {
+		return DrainLease{}, false
+	}
+	return lease, true
+}
+
+// GetDrainLease returns UpgradeKeys.GetDrainLease with the default key prefix
+func GetDrainLease(node *corev1.Node) (DrainLease, bool) {
+	return UpgradeKeys{}.GetDrainLease(node)
+}
+
+// drainLeaseConfig is the configuration of the drain leases of a DrainManagerImpl
+type drainLeaseConfig struct {
+	// holderIdentity is the identity of the drain manager in the leases
+	holderIdentity string
+	// duration is the duration of the leases, they are renewed every third of it
+	duration time.Duration
+}
+
+// defaultDrainLeaseHolderIdentity returns an identity unique to the process, made of the host name, i.e. the pod
+// name of the operator, and a random suffix, as client-go leader election does
+func defaultDrainLeaseHolderIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s_%s", hostname, uuid.NewUUID())
+}
+
+// WithDrainLease enables the drain leases: while a node is drained, the lease of the drain manager is kept
+// in the drain lease annotation of the node and renewed every third of the lease duration. A node with a lease
+// of another holder which hasn't expired yet is not drained again, the drain of a node whose lease expired,
+// e.g. because the operator pod draining it was restarted, is resumed, only the remaining pods being evicted.
+// The leases of the holder identity are considered stale if the drain manager doesn't drain the node itself,
+// so that a restarted operator with a stable identity resumes its drains immediately. A random identity unique
+// to the process is used if holderIdentity is empty. Zero or a negative duration disables the leases.
+// It should be called before the first drain is scheduled.
+func (m *DrainManagerImpl) WithDrainLease(holderIdentity string, duration time.Duration) *DrainManagerImpl {
+	m.drainLease = nil
+	if duration <= 0 {
+		return m
+	}
+	if holderIdentity == "" {
+		holderIdentity = defaultDrainLeaseHolderIdentity()
+	}
+	m.drainLease = &drainLeaseConfig{holderIdentity: holderIdentity, duration: duration}
+	return m
+}
+
+// WithDrainLease provides an option to keep a lease of the drain manager on the nodes it drains, so that
+// a manager started while a drain runs, e.g. the new leader after the operator pod was restarted, doesn't drain
+// the node again until the lease expires, see DrainManagerImpl.WithDrainLease
+func (m *ClusterUpgradeStateManagerImpl) WithDrainLease(holderIdentity string,
+	leaseDuration time.Duration) ClusterUpgradeStateManager {
+	drainManager, ok := m.DrainManager.(*DrainManagerImpl)
+	if !ok {
+		m.Log.V(consts.LogLevelWarning).Info("Cannot enable the drain leases, the drain manager is not a DrainManagerImpl")
+		return m
+	}
+	drainManager.WithDrainLease(holderIdentity, leaseDuration)
+	return m
+}
+
+// getDrainLeaseHolder returns the holder of the drain lease of the node if the lease is held by another
+// drain manager and hasn't expired
+func (m *DrainManagerImpl) getDrainLeaseHolder(node *corev1.Node) (string, bool) {
+	if m.drainLease == nil {
+		return "", false
+	}
+	lease, ok := m.keys.GetDrainLease(node)
+	if !ok || lease.HolderIdentity == m.drainLease.holderIdentity || lease.Expired(time.Now()) {
+		return "", false
+	}
+	return lease.HolderIdentity, true
+}
+
+// errDrainLeaseLost is the cause of the cancellation of a drain whose lease was taken over by another drain manager
+var errDrainLeaseLost = errors.New("drain lease lost")
+
+// acquireDrainLease writes the lease of the drain manager on the node and renews it until the returned function
+// is called, which releases the lease. The drain of a node whose previous lease expired is resumed. An error is
+// returned if the lease can't be written, e.g. if another drain manager acquired it meanwhile, and the node must not
+// be drained. The returned context is cancelled with errDrainLeaseLost if a renewal finds the lease of another
+// drain manager, so that only one of them evicts the pods of the node.
+func (m *DrainManagerImpl) acquireDrainLease(ctx context.Context, node *corev1.Node) (context.Context, func(), error) {
+	if m.drainLease == nil {
+		return ctx, func() {}, nil
+	}
+	// the lease is written on a copy, the node object is owned by the drain
+	leaseNode := node.DeepCopy()
+	previous, resumed := m.keys.GetDrainLease(leaseNode)
+	now := meta_v1.Now()
+	lease := DrainLease{
+		HolderIdentity:       m.drainLease.holderIdentity,
+		AcquireTime:          now,
+		RenewTime:            now,
+		LeaseDurationSeconds: int32(m.drainLease.duration.Seconds()),
+	}
+	if err := m.writeDrainLease(ctx, leaseNode, &lease); err != nil {
+		return nil, nil, err
+	}
+	if resumed {
+		m.log.V(consts.LogLevelInfo).Info("Resuming the drain of the node", "node", node.Name,
+			"previousHolder", previous.HolderIdentity, "startedAt", previous.AcquireTime)
+		logEventf(m.eventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+			"Resuming the drain of the node started by %s", previous.HolderIdentity)
+	}
+
+	leaseCtx, cancelLease := context.WithCancelCause(ctx)
+	renewCtx, stopRenew := context.WithCancel(leaseCtx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(m.drainLease.duration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+				lease.RenewTime = meta_v1.Now()
+				err := m.writeDrainLease(renewCtx, leaseNode, &lease)
+				if errors.Is(err, errDrainLeaseLost) {
+					m.log.V(consts.LogLevelWarning).Info("Drain lease of the node was taken over, stopping the drain",
+						"node", node.Name, "error", err)
+					cancelLease(err)
+					return
+				}
+			}
+		}
+	}()
+	return leaseCtx, func() {
+		stopRenew()
+		<-renewed
+		cancelLease(nil)
+		// the lease is released even if the drain was cancelled
+		_ = m.writeDrainLease(context.WithoutCancel(ctx), leaseNode, nil)
+	}, nil
+}
+
+// writeDrainLease sets the drain lease annotation of the node, or removes it if lease is nil, see patchDrainLease.
+// No event is recorded, as the lease is renewed periodically. An error wrapping errDrainLeaseLost is returned
+// if the lease is held by another drain manager, other failed writes are logged as well.
+func (m *DrainManagerImpl) writeDrainLease(ctx context.Context, node *corev1.Node, lease *DrainLease) error {
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		return m.patchDrainLease(ctx, node, lease)
+	})
+	if err != nil && ctx.Err() == nil && !errors.Is(err, errDrainLeaseLost) {
+		m.log.V(consts.LogLevelError).Error(err, "Failed to update the drain lease of the node", "node", node.Name)
+	}
+	return err
+}
+
+// patchDrainLease writes the drain lease annotation of the node with an optimistic lock, so that a lease taken
+// over by another drain manager meanwhile is not overwritten. The node is reloaded if the write conflicts.
+func (m *DrainManagerImpl) patchDrainLease(ctx context.Context, node *corev1.Node, lease *DrainLease) error {
+	key := m.keys.GetUpgradeDrainLeaseAnnotationKey()
+	if current, ok := m.keys.GetDrainLease(node); ok && current.HolderIdentity != m.drainLease.holderIdentity &&
+		!current.Expired(time.Now()) {
+		if lease == nil {
+			// the lease was taken over, there is nothing to release
+			return nil
+		}
+		return fmt.Errorf("%w, it is held by %s", errDrainLeaseLost, current.HolderIdentity)
+	}
+	updated := node.DeepCopy()
+	if lease == nil {
+		if _, ok := updated.Annotations[key]; !ok {
+			return nil
+		}
+		delete(updated.Annotations, key)
+	} else {
+		data, err := json.Marshal(lease)
+		if err != nil {
+			return err
+		}
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[key] = string(data)
+	}
+	patch, err := client.MergeFromWithOptions(node, client.MergeFromWithOptimisticLock{}).Data(updated)
+	if err != nil {
+		return err
+	}
+	patched, err := m.k8sInterface.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, patch,
+		meta_v1.PatchOptions{})
+	if apierrors.IsConflict(err) {
+		latest, getErr := m.k8sInterface.CoreV1().Nodes().Get(ctx, node.Name, meta_v1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		*node = *latest
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	*node = *patched
+	return nil
+}
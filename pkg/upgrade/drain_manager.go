@@ -17,6 +17,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -31,17 +33,84 @@ import (
 
 // DrainConfiguration contains the drain specification and the list of nodes to schedule drain on
 type DrainConfiguration struct {
-	Spec  *v1alpha1.DrainSpec
-	Nodes []*corev1.Node
+	Spec            *v1alpha1.DrainSpec
+	Nodes           []*corev1.Node
+	FailedPodPolicy FailedPodPolicy
+	// ProtectedNamespaces are the namespaces pods are never evicted from
+	ProtectedNamespaces []string
 }
 
 // DrainManagerImpl implements DrainManager interface and can perform nodes drain based on received DrainConfiguration
 type DrainManagerImpl struct {
 	k8sInterface             kubernetes.Interface
 	drainingNodes            *StringSet
+	workers                  *workerPoolTracker
 	nodeUpgradeStateProvider NodeUpgradeStateProvider
 	log                      logr.Logger
 	eventRecorder            record.EventRecorder
+	// nodeClients, if set, creates clients which report the API server warnings received during the drain
+	// as events of the node
+	nodeClients *nodeClientFactory
+	// stateMetrics, if set, records the drain durations
+	stateMetrics *stateMetrics
+	// workerSlots, if set, limits the count of nodes drained concurrently to its capacity
+	workerSlots chan struct{}
+	// results are the outcomes of the node drains not taken with TakeDrainResults yet
+	results *drainResults
+	// drainStatuses is the progress of the nodes being drained
+	drainStatuses *drainStatusTracker
+	// drainCancels are the scheduled drains, cancelled by CancelNodeDrain
+	drainCancels *drainCancelTracker
+	// drainLease, if set, keeps the lease of the drain manager on the nodes it drains, see WithDrainLease
+	drainLease *drainLeaseConfig
+	// drainCompletionHandler, if set, is notified when the drain of a node finishes,
+	// see WithDrainCompletionHandler
+	drainCompletionHandler DrainCompletionHandler
+	keys                   UpgradeKeys
+}
+
+// DrainResult is the outcome of the drain of a node
+type DrainResult struct {
+	// Node is the name of the node
+	Node string
+	// Err is the error the drain failed with, nil if the node was drained. A drain cancelled before it started
+	// leaves the node in the drain-required state.
+	Err error
+	// Duration is the time spent on cordoning and draining the node
+	Duration time.Duration
+}
+
+// DrainResultsProvider is implemented by drain managers which report the outcome of the node drains
+type DrainResultsProvider interface {
+	// TakeDrainResults returns the outcomes of the node drains which finished since the previous call,
+	// sorted by node name
+	TakeDrainResults() []DrainResult
+}
+
+// drainResults collects the outcomes of the node drains, only the latest one of every node is kept
+type drainResults struct {
+	mutex   sync.Mutex
+	results map[string]DrainResult
+}
+
+// add records the outcome of the drain of a node
+func (r *drainResults) add(result DrainResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.results[result.Node] = result
+}
+
+// take returns the recorded outcomes sorted by node name and clears them
+func (r *drainResults) take() []DrainResult {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	results := make([]DrainResult, 0, len(r.results))
+	for _, result := range r.results {
+		results = append(results, result)
+	}
+	r.results = make(map[string]DrainResult)
+	sort.Slice(results, func(i, j int) bool { return results[i].Node < results[j].Node })
+	return results
 }
 
 // DrainManager is an interface that allows to schedule nodes drain based on DrainSpec
@@ -51,7 +120,9 @@ type DrainManager interface {
 
 // ScheduleNodesDrain receives DrainConfiguration and schedules drain for each node in the list.
 // When the node gets scheduled, it's marked as being drained and therefore will not be scheduled for drain twice
-// if the initial drain didn't complete yet.
+// if the initial drain didn't complete yet. With WithDrainLease, the nodes drained by another drain manager
+// are not scheduled either. Every node is drained in its own goroutine, limited by WithMaxWorkers,
+// and the outcome of the drain is reported by TakeDrainResults.
 // During the drain the node is cordoned first, and then pods on the node are evicted.
 // If the drain is successful, the node moves to UpgradeStatePodRestartRequiredstate,
 // otherwise it moves to UpgradeStateFailed state.
@@ -73,6 +144,7 @@ func (m *DrainManagerImpl) ScheduleNodesDrain(ctx context.Context, drainConfig *
 		return nil
 	}
 
+	workerSlots := m.workerSlots
 	drainHelper := &drain.Helper{
 		Ctx:    ctx,
 		Client: m.k8sInterface,
@@ -83,6 +155,11 @@ func (m *DrainManagerImpl) ScheduleNodesDrain(ctx context.Context, drainConfig *
 		GracePeriodSeconds:  -1,
 		Timeout:             time.Duration(drainSpec.TimeoutSecond) * time.Second,
 		PodSelector:         drainSpec.PodSelector,
+		AdditionalFilters: []drain.PodFilter{
+			terminalPodFilter(drainConfig.FailedPodPolicy),
+			protectedNamespaceFilter(drainConfig.ProtectedNamespaces),
+			priorityThresholdFilter(drainSpec.PriorityThreshold),
+		},
 		OnPodDeletedOrEvicted: func(pod *corev1.Pod, usingEviction bool) {
 			verbStr := "Deleted"
 			if usingEviction {
@@ -98,37 +175,58 @@ func (m *DrainManagerImpl) ScheduleNodesDrain(ctx context.Context, drainConfig *
 		// We need to shadow the loop variable or initialize some other one with its value
 		// to avoid concurrency issues when launching goroutines.
 		// If a loop variable is used as it is, all/most goroutines, spawned inside this loop,
-		// will use the 'node' value of the last item in drainConfig.Nodes
-		node := node
+		// will use the 'node' value of the last item in drainConfig.Nodes.
+		// The drain updates its own copy of the node, the node of the caller is not changed concurrently.
+		node := node.DeepCopy()
+		if holder, held := m.getDrainLeaseHolder(node); held {
+			m.log.V(consts.LogLevelInfo).Info("Node is being drained by another drain manager, skipping",
+				"node", node.Name, "holder", holder)
+			continue
+		}
 		if !m.drainingNodes.Has(node.Name) {
 			m.log.V(consts.LogLevelInfo).Info("Schedule drain for node", "node", node.Name)
+			nodeDrainSpec, err := m.keys.getNodeDrainSpec(node, drainSpec)
+			if err != nil {
+				m.log.V(consts.LogLevelWarning).Info("Ignoring invalid drain annotations of the node",
+					"node", node.Name, "error", err)
+				logEventf(m.eventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+					"Ignoring invalid drain annotations of the node: %v", err)
+			}
 			logEvent(m.eventRecorder, node, corev1.EventTypeNormal, GetEventReason(), "Scheduling drain of the node")
 
 			m.drainingNodes.Add(node.Name)
+			m.workers.enqueue(node.Name)
+			nodeCtx := m.drainCancels.start(ctx, node.Name)
 			go func() {
+				var result DrainResult
+				// deferred first, so that the handler is notified once the drain is no longer tracked
+				defer func() { m.notifyDrainCompletion(result) }()
+				defer m.drainCancels.done(node.Name)
 				defer m.drainingNodes.Remove(node.Name)
-				err := drain.RunCordonOrUncordon(drainHelper, node, true)
-				if err != nil {
-					m.log.V(consts.LogLevelError).Error(err, "Failed to cordon node", "node", node.Name)
-					_ = m.nodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
-					logEventf(m.eventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
-						"Failed to cordon the node, %s", err.Error())
+				defer m.workers.done(node.Name)
+				if !acquireWorkerSlot(nodeCtx, workerSlots) {
+					// the node stays in the drain-required state and is scheduled again by the next pass
+					m.log.V(consts.LogLevelInfo).Info("Drain was cancelled before it started", "node", node.Name)
+					result = DrainResult{Node: node.Name, Err: fmt.Errorf("drain cancelled: %v", context.Cause(nodeCtx))}
+					m.results.add(result)
 					return
 				}
-				m.log.V(consts.LogLevelInfo).Info("Cordoned the node", "node", node.Name)
-
-				err = drain.RunNodeDrain(drainHelper, node.Name)
+				defer releaseWorkerSlot(workerSlots)
+				m.workers.start(node.Name)
+				leaseCtx, releaseLease, err := m.acquireDrainLease(nodeCtx, node)
 				if err != nil {
-					m.log.V(consts.LogLevelError).Error(err, "Failed to drain node", "node", node.Name)
-					_ = m.nodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
-					logEventf(m.eventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
-						"Failed to drain the node, %s", err.Error())
+					// the node stays in the drain-required state, it is drained by the holder of the lease
+					m.log.V(consts.LogLevelInfo).Info("Drain lease of the node can't be acquired, skipping the drain",
+						"node", node.Name, "error", err)
+					result = DrainResult{Node: node.Name, Err: fmt.Errorf("failed to acquire the drain lease: %v", err)}
+					m.results.add(result)
 					return
 				}
-				m.log.V(consts.LogLevelInfo).Info("Drained the node", "node", node.Name)
-				logEvent(m.eventRecorder, node, corev1.EventTypeNormal, GetEventReason(), "Successfully drained the node")
-
-				_ = m.nodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStatePodRestartRequired)
+				defer releaseLease()
+				start := time.Now()
+				err = m.drainNode(leaseCtx, drainHelper, node, nodeDrainSpec)
+				result = DrainResult{Node: node.Name, Err: err, Duration: time.Since(start)}
+				m.results.add(result)
 			}()
 		} else {
 			m.log.V(consts.LogLevelInfo).Info("Node is already being drained, skipping", "node", node.Name)
@@ -137,6 +235,147 @@ func (m *DrainManagerImpl) ScheduleNodesDrain(ctx context.Context, drainConfig *
 	return nil
 }
 
+// drainNode cordons and drains the node with a dedicated copy of the drain helper, configured with the drain spec
+// of the node, and moves it to the pod-restart-required state, or to the upgrade-failed state if the drain fails.
+// The progress of the drain is reported by GetDrainStatus while the pods are evicted.
+func (m *DrainManagerImpl) drainNode(ctx context.Context, drainHelper *drain.Helper, node *corev1.Node,
+	drainSpec *v1alpha1.DrainSpec) error {
+	// use a dedicated copy of the drain helper to attribute drain errors and API warnings to the node
+	nodeDrainHelper := *drainHelper
+	nodeDrainHelper.Client = m.nodeClients.clientFor(node, nodeDrainHelper.Client)
+	// the drain spec of the node may override the options of the upgrade policy, see getNodeDrainSpec
+	nodeDrainHelper.Force = drainSpec.Force
+	nodeDrainHelper.DeleteEmptyDirData = drainSpec.DeleteEmptyDir
+	nodeDrainHelper.Timeout = time.Duration(drainSpec.TimeoutSecond) * time.Second
+	nodeDrainHelper.ErrOut = &drainErrorRecorder{out: nodeDrainHelper.ErrOut, nodeName: node.Name,
+		tracker: m.drainStatuses}
+	var fallback *evictionFallback
+	if drainSpec.EvictionFallback != nil {
+		// pods whose eviction stays blocked are deleted directly
+		fallback = &evictionFallback{ctx: ctx, client: nodeDrainHelper.Client, node: node,
+			spec: drainSpec.EvictionFallback, log: m.log, eventRecorder: m.eventRecorder,
+			blockedSince: make(map[string]time.Time)}
+	}
+	var reportBlocked sync.Once
+	nodeDrainHelper.Client = &blockedEvictionClient{Interface: nodeDrainHelper.Client,
+		onBlocked: func(namespace, name string) {
+			reportBlocked.Do(func() {
+				m.log.V(consts.LogLevelInfo).Info("Node drain is blocked by a PodDisruptionBudget", "node", node.Name)
+				_ = m.keys.setNodeUpgradeStateReason(ctx, m.nodeUpgradeStateProvider, node,
+					UpgradeStateReasonDrainBlockedByPDB)
+			})
+			if fallback != nil {
+				fallback.onEvictionBlocked(namespace, name)
+			}
+		}}
+	onPodDeletedOrEvicted := nodeDrainHelper.OnPodDeletedOrEvicted
+	nodeDrainHelper.OnPodDeletedOrEvicted = func(pod *corev1.Pod, usingEviction bool) {
+		m.drainStatuses.evicted(node.Name)
+		if onPodDeletedOrEvicted != nil {
+			onPodDeletedOrEvicted(pod, usingEviction)
+		}
+	}
+	err := drain.RunCordonOrUncordon(&nodeDrainHelper, node, true)
+	if err != nil {
+		return m.failDrain(ctx, node, "Failed to cordon the node", err)
+	}
+	m.log.V(consts.LogLevelInfo).Info("Cordoned the node", "node", node.Name)
+
+	err = m.checkStaticPods(ctx, nodeDrainHelper.Client, node, drainSpec)
+	if err != nil {
+		return m.failDrain(ctx, node, "Failed to drain the node", err)
+	}
+
+	// pods stuck terminating because of finalizers are handled while the node is drained
+	drainCtx, cancelDrain := context.WithCancel(ctx)
+	defer cancelDrain()
+	nodeDrainHelper.Ctx = drainCtx
+	go m.watchStuckFinalizers(drainCtx, nodeDrainHelper.Client, node, getStuckFinalizerSpec(drainSpec), cancelDrain)
+
+	podsToDrain := 0
+	if podList, errs := nodeDrainHelper.GetPodsForDeletion(node.Name); len(errs) == 0 {
+		podsToDrain = len(podList.Pods())
+	}
+	m.drainStatuses.start(node.Name, podsToDrain)
+	defer m.drainStatuses.done(node.Name)
+
+	drainStart := time.Now()
+	err = drain.RunNodeDrain(&nodeDrainHelper, node.Name)
+	cancelDrain()
+	m.stateMetrics.observeDrain(drainStart, err)
+	if err != nil {
+		return m.failDrain(ctx, node, "Failed to drain the node", err)
+	}
+	m.log.V(consts.LogLevelInfo).Info("Drained the node", "node", node.Name)
+	logEvent(m.eventRecorder, node, corev1.EventTypeNormal, GetEventReason(), "Successfully drained the node")
+
+	_ = m.nodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStatePodRestartRequired)
+	return nil
+}
+
+// failDrain moves the node to the upgrade-failed state after its drain failed with err and returns err.
+// An aborted or interrupted drain leaves the node in its current state.
+func (m *DrainManagerImpl) failDrain(ctx context.Context, node *corev1.Node, msg string, err error) error {
+	if isDrainAborted(ctx) {
+		m.log.V(consts.LogLevelInfo).Info("Node drain aborted", "node", node.Name)
+		return fmt.Errorf("%v: %v", errDrainAborted, err)
+	}
+	if isInterrupted(ctx) {
+		m.reportInterruptedDrain(ctx, node, err)
+		return fmt.Errorf("drain %v: %v", errInterrupted, err)
+	}
+	m.log.V(consts.LogLevelError).Error(err, msg, "node", node.Name)
+	_ = m.nodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
+	logEventf(m.eventRecorder, node, corev1.EventTypeWarning, GetEventReason(), "%s, %s", msg, err.Error())
+	return err
+}
+
+// acquireWorkerSlot waits for a free slot of the drain workers, it returns false if the context is cancelled first.
+// A nil slots channel doesn't limit the workers.
+func acquireWorkerSlot(ctx context.Context, slots chan struct{}) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// releaseWorkerSlot frees a slot acquired with acquireWorkerSlot
+func releaseWorkerSlot(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
+
+// GetWorkerPoolStats returns the state of the workers draining nodes
+func (m *DrainManagerImpl) GetWorkerPoolStats() WorkerPoolStats {
+	return m.workers.stats()
+}
+
+// TakeDrainResults returns the outcomes of the node drains which finished since the previous call,
+// sorted by node name
+func (m *DrainManagerImpl) TakeDrainResults() []DrainResult {
+	return m.results.take()
+}
+
+// WithMaxWorkers limits the count of nodes drained concurrently, the other scheduled nodes wait in the queue
+// of the worker pool until a worker is free or the context of ScheduleNodesDrain is cancelled.
+// Zero or a negative count removes the limit. It should be called before the first drain is scheduled.
+func (m *DrainManagerImpl) WithMaxWorkers(workers int) *DrainManagerImpl {
+	m.workerSlots = nil
+	if workers > 0 {
+		m.workerSlots = make(chan struct{}, workers)
+	}
+	return m
+}
+
 // NewDrainManager creates a DrainManager
 func NewDrainManager(
 	k8sInterface kubernetes.Interface,
@@ -147,6 +386,10 @@ func NewDrainManager(
 		k8sInterface:             k8sInterface,
 		log:                      log,
 		drainingNodes:            NewStringSet(),
+		workers:                  newWorkerPoolTracker(),
+		results:                  &drainResults{results: make(map[string]DrainResult)},
+		drainStatuses:            newDrainStatusTracker(),
+		drainCancels:             newDrainCancelTracker(),
 		nodeUpgradeStateProvider: nodeUpgradeStateProvider,
 		eventRecorder:            eventRecorder,
 	}
@@ -18,12 +18,17 @@ package upgrade_test
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 
 	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
 	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
@@ -90,6 +95,83 @@ var _ = Describe("DrainManager tests", func() {
 		Expect(err).To(Succeed())
 		Expect(observedNode3.Spec.Unschedulable).To(BeTrue())
 	})
+	It("DrainManager should notify the drain completion handler once the node is drained", func() {
+		ctx := context.TODO()
+
+		node := createNode("completion-node")
+
+		completions := make(chan upgrade.DrainResult, 1)
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder).
+			WithDrainCompletionHandler(func(result upgrade.DrainResult) { completions <- result })
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:         true,
+			TimeoutSecond:  1,
+			DeleteEmptyDir: true,
+		}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		var result upgrade.DrainResult
+		Eventually(completions).WithTimeout(5 * time.Second).Should(Receive(&result))
+		Expect(result.Node).To(Equal(node.Name))
+		Expect(result.Err).NotTo(HaveOccurred())
+		// the node is in its next state when the handler is notified
+		Expect(getNodeUpgradeState(getNode(node.Name))).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+	})
+	It("DrainManager should limit the drain workers and report the drain results", func() {
+		ctx := context.TODO()
+
+		node1 := createNode("node1")
+		node2 := createNode("node2")
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder).
+			WithMaxWorkers(1)
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:         true,
+			TimeoutSecond:  1,
+			DeleteEmptyDir: true,
+		}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node1, node2}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		var results []upgrade.DrainResult
+		Eventually(func() []upgrade.DrainResult {
+			results = append(results, drainManager.TakeDrainResults()...)
+			return results
+		}).WithTimeout(5 * time.Second).Should(HaveLen(2))
+		Expect(results[0].Node).To(Equal(node1.Name))
+		Expect(results[0].Err).NotTo(HaveOccurred())
+		Expect(results[1].Node).To(Equal(node2.Name))
+		Expect(results[1].Err).NotTo(HaveOccurred())
+		Expect(drainManager.TakeDrainResults()).To(BeEmpty())
+		_, draining := drainManager.GetDrainStatus(node1.Name)
+		Expect(draining).To(BeFalse())
+		Expect(getNode(node1.Name).Spec.Unschedulable).To(BeTrue())
+		Expect(getNode(node2.Name).Spec.Unschedulable).To(BeTrue())
+	})
+	It("DrainManager should not drain nodes waiting for a worker once the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+
+		node := createNode("node")
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder).
+			WithMaxWorkers(1)
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:         true,
+			TimeoutSecond:  1,
+			DeleteEmptyDir: true,
+		}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		Eventually(drainManager.TakeDrainResults).WithTimeout(5 * time.Second).Should(ConsistOf(
+			HaveField("Err", MatchError(ContainSubstring("drain cancelled")))))
+		Expect(drainManager.GetWorkerPoolStats().QueueDepth).To(BeZero())
+		observedNode := getNode(node.Name)
+		Expect(observedNode.Spec.Unschedulable).To(BeFalse())
+		Expect(upgrade.LabelStateStorage{}.GetState(observedNode)).NotTo(Equal(upgrade.UpgradeStateFailed))
+	})
 	It("DrainManager should not fail on empty node list", func() {
 		ctx := context.TODO()
 
@@ -161,4 +243,449 @@ var _ = Describe("DrainManager tests", func() {
 		Expect(err).To(Succeed())
 		Expect(observedNode.Spec.Unschedulable).To(BeFalse())
 	})
+	It("DrainManager should remove allowed finalizers of pods stuck terminating", func() {
+		ctx := context.TODO()
+
+		node := createNode("stuck-finalizer-node")
+		namespace := createNamespace("stuck-finalizer-" + randSeq(5))
+		pod := NewPod("stuck-pod", namespace.Name, node.Name).Pod
+		pod.Finalizers = []string{"example.com/protect"}
+		deletionTimestamp := metav1.NewTime(time.Now().Add(-time.Minute))
+		pod.DeletionTimestamp = &deletionTimestamp
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+		if pod.DeletionTimestamp == nil {
+			// the API server ignores the deletion timestamp on create, mark the pod as terminating
+			Expect(k8sClient.Delete(ctx, pod)).To(Succeed())
+		}
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder)
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:        true,
+			Force:         true,
+			TimeoutSecond: 3,
+			StuckFinalizers: &v1alpha1.StuckFinalizerSpec{
+				Action:            v1alpha1.StuckFinalizerActionRemoveFinalizers,
+				AllowedFinalizers: []string{"example.com/protect"},
+			},
+		}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		Eventually(func() []string {
+			observedPod := &corev1.Pod{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, observedPod)
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			Expect(err).To(Succeed())
+			return observedPod.Finalizers
+		}).WithTimeout(5 * time.Second).Should(BeEmpty())
+	})
+	It("DrainManager should skip static pods by default", func() {
+		ctx := context.TODO()
+
+		node := createNode("static-pod-skip-node")
+		createStaticPod(ctx, node)
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder)
+		drainSpec := &v1alpha1.DrainSpec{Enable: true, TimeoutSecond: 1}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		Eventually(func() string {
+			return getNodeUpgradeState(getNode(node.Name))
+		}).WithTimeout(3 * time.Second).Should(Equal(upgrade.UpgradeStatePodRestartRequired))
+	})
+	It("DrainManager should fail the drain of nodes with static pods if the policy requires it", func() {
+		ctx := context.TODO()
+
+		node := createNode("static-pod-fail-node")
+		createStaticPod(ctx, node)
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder)
+		drainSpec := &v1alpha1.DrainSpec{Enable: true, TimeoutSecond: 1, StaticPodPolicy: v1alpha1.StaticPodPolicyFail}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		Eventually(func() string {
+			return getNodeUpgradeState(getNode(node.Name))
+		}).WithTimeout(3 * time.Second).Should(Equal(upgrade.UpgradeStateFailed))
+	})
+	It("DrainManager should not evict pods at or above the priority threshold", func() {
+		ctx := context.TODO()
+
+		node := createNode("priority-threshold-node")
+		namespace := createNamespace("priority-threshold-" + randSeq(5))
+		priority := int32(1000000)
+		priorityClass := &schedulingv1.PriorityClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "critical-" + randSeq(5)},
+			Value:      priority,
+		}
+		Expect(k8sClient.Create(ctx, priorityClass)).To(Succeed())
+		createdObjects = append(createdObjects, priorityClass)
+		pod := NewPod("critical-pod", namespace.Name, node.Name).Pod
+		pod.Spec.PriorityClassName = priorityClass.Name
+		pod.Spec.Priority = &priority
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder)
+		drainSpec := &v1alpha1.DrainSpec{Enable: true, Force: true, TimeoutSecond: 1, PriorityThreshold: &priority}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		Eventually(func() string {
+			return getNodeUpgradeState(getNode(node.Name))
+		}).WithTimeout(3 * time.Second).Should(Equal(upgrade.UpgradeStatePodRestartRequired))
+		observedPod := &corev1.Pod{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, observedPod)).To(Succeed())
+		Expect(observedPod.DeletionTimestamp).To(BeNil())
+	})
+	It("DrainManager should delete pods whose eviction stays blocked by a PodDisruptionBudget", func() {
+		ctx := context.TODO()
+
+		node := createNode("eviction-fallback-node")
+		namespace := createNamespace("eviction-fallback-" + randSeq(5))
+		pod := NewPod("blocked-pod", namespace.Name, node.Name).Pod
+		pod.Labels = map[string]string{"app": "blocked"}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+		createBlockingPDB(namespace.Name, pod.Labels)
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder)
+		gracePeriod := int64(0)
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:           true,
+			Force:            true,
+			TimeoutSecond:    30,
+			EvictionFallback: &v1alpha1.EvictionFallbackSpec{DeleteAfterSeconds: 1, GracePeriodSeconds: &gracePeriod},
+		}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		// the eviction is retried every 5 seconds, the pod is deleted on the second blocked eviction
+		Eventually(func() string {
+			return getNodeUpgradeState(getNode(node.Name))
+		}).WithTimeout(20 * time.Second).Should(Equal(upgrade.UpgradeStatePodRestartRequired))
+		err = k8sClient.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+	It("DrainManager should apply the drain overrides of the node annotations", func() {
+		ctx := context.TODO()
+
+		node := NewNode("drain-override-node").WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			WithAnnotations(map[string]string{
+				upgrade.GetUpgradeDrainTimeoutAnnotationKey(): "1s",
+				upgrade.GetUpgradeDrainForceAnnotationKey():   "maybe",
+			}).Create()
+		namespace := createNamespace("drain-override-" + randSeq(5))
+		pod := NewPod("blocked-pod", namespace.Name, node.Name).Pod
+		pod.Labels = map[string]string{"app": "blocked"}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+		createBlockingPDB(namespace.Name, pod.Labels)
+
+		recorder := record.NewFakeRecorder(10)
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, recorder)
+		// the drain timeout of the node overrides the timeout of the policy, the invalid force annotation is ignored
+		drainSpec := &v1alpha1.DrainSpec{Enable: true, Force: true, TimeoutSecond: 300}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+		Expect(recorder.Events).To(Receive(ContainSubstring("Ignoring invalid drain annotations of the node")))
+
+		Eventually(func() string {
+			return getNodeUpgradeState(getNode(node.Name))
+		}).WithTimeout(10 * time.Second).Should(Equal(upgrade.UpgradeStateFailed))
+		Expect(drainManager.TakeDrainResults()).To(ConsistOf(HaveField("Err", HaveOccurred())))
+	})
+	It("DrainManager should leave the node in its upgrade state when its drain is cancelled", func() {
+		ctx := context.TODO()
+
+		node := NewNode("cancelled-drain-node").WithUpgradeState(upgrade.UpgradeStateDrainRequired).Create()
+		namespace := createNamespace("cancelled-drain-" + randSeq(5))
+		pod := NewPod("blocked-pod", namespace.Name, node.Name).Pod
+		pod.Labels = map[string]string{"app": "blocked"}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+		createBlockingPDB(namespace.Name, pod.Labels)
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder)
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:        true,
+			Force:         true,
+			TimeoutSecond: 300,
+		}
+		Expect(drainManager.CancelNodeDrain(ctx, node.Name)).To(BeFalse())
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+		Eventually(func() bool {
+			_, draining := drainManager.GetDrainStatus(node.Name)
+			return draining
+		}).WithTimeout(5 * time.Second).Should(BeTrue())
+		// the rejected eviction is reported in the upgrade state reason of the node
+		Eventually(func() string {
+			return upgrade.GetNodeUpgradeStateReason(getNode(node.Name))
+		}).WithTimeout(5 * time.Second).Should(Equal(upgrade.UpgradeStateReasonDrainBlockedByPDB))
+
+		Expect(drainManager.CancelNodeDrain(ctx, node.Name)).To(BeTrue())
+		Expect(drainManager.TakeDrainResults()).To(ConsistOf(
+			HaveField("Err", MatchError(ContainSubstring("drain aborted")))))
+		_, draining := drainManager.GetDrainStatus(node.Name)
+		Expect(draining).To(BeFalse())
+		Expect(getNodeUpgradeState(getNode(node.Name))).To(Equal(upgrade.UpgradeStateDrainRequired))
+	})
+	It("DrainManager should leave the node in its upgrade state and record the drain progress when interrupted", func() {
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+
+		node := NewNode("interrupted-drain-node").WithUpgradeState(upgrade.UpgradeStateDrainRequired).Create()
+		namespace := createNamespace("interrupted-drain-" + randSeq(5))
+		pod := NewPod("blocked-pod", namespace.Name, node.Name).Pod
+		pod.Labels = map[string]string{"app": "blocked"}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+		createBlockingPDB(namespace.Name, pod.Labels)
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder)
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:        true,
+			Force:         true,
+			TimeoutSecond: 300,
+		}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+		Eventually(func() bool {
+			_, draining := drainManager.GetDrainStatus(node.Name)
+			return draining
+		}).WithTimeout(5 * time.Second).Should(BeTrue())
+
+		// the operator shuts down
+		cancel()
+		var results []upgrade.DrainResult
+		Eventually(func() []upgrade.DrainResult {
+			results = append(results, drainManager.TakeDrainResults()...)
+			return results
+		}).WithTimeout(10 * time.Second).Should(ConsistOf(
+			HaveField("Err", MatchError(ContainSubstring("drain interrupted")))))
+		observedNode := getNode(node.Name)
+		Expect(getNodeUpgradeState(observedNode)).To(Equal(upgrade.UpgradeStateDrainRequired))
+		status := upgrade.DrainStatus{}
+		Expect(json.Unmarshal([]byte(observedNode.Annotations[upgrade.GetUpgradeDrainStatusAnnotationKey()]),
+			&status)).To(Succeed())
+		Expect(status.PodsRemaining).To(Equal(1))
+		Expect(status.LastError).To(ContainSubstring("drain interrupted"))
+	})
+	It("DrainManager should not drain a node with an unexpired drain lease of another holder", func() {
+		ctx := context.TODO()
+
+		lease, err := json.Marshal(upgrade.DrainLease{
+			HolderIdentity:       "previous-leader",
+			AcquireTime:          metav1.Now(),
+			RenewTime:            metav1.Now(),
+			LeaseDurationSeconds: 60,
+		})
+		Expect(err).To(Succeed())
+		node := NewNode("leased-node").
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			WithAnnotations(map[string]string{upgrade.GetUpgradeDrainLeaseAnnotationKey(): string(lease)}).
+			Create()
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder).
+			WithDrainLease("new-leader", time.Minute)
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:         true,
+			TimeoutSecond:  1,
+			DeleteEmptyDir: true,
+		}
+		err = drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		Consistently(func() bool {
+			return getNode(node.Name).Spec.Unschedulable
+		}).WithTimeout(time.Second).Should(BeFalse())
+		Expect(drainManager.TakeDrainResults()).To(BeEmpty())
+		Expect(getNode(node.Name).Annotations[upgrade.GetUpgradeDrainLeaseAnnotationKey()]).To(Equal(string(lease)))
+	})
+	It("DrainManager should resume the drain of a node whose drain lease expired and release the lease", func() {
+		ctx := context.TODO()
+
+		expiredLease, err := json.Marshal(upgrade.DrainLease{
+			HolderIdentity:       "previous-leader",
+			AcquireTime:          metav1.NewTime(time.Now().Add(-10 * time.Minute)),
+			RenewTime:            metav1.NewTime(time.Now().Add(-2 * time.Minute)),
+			LeaseDurationSeconds: 60,
+		})
+		Expect(err).To(Succeed())
+		node := NewNode("expired-lease-node").
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			WithAnnotations(map[string]string{upgrade.GetUpgradeDrainLeaseAnnotationKey(): string(expiredLease)}).
+			Create()
+		namespace := createNamespace("expired-lease-" + randSeq(5))
+		pod := NewPod("blocked-pod", namespace.Name, node.Name).Pod
+		pod.Labels = map[string]string{"app": "blocked"}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+		createBlockingPDB(namespace.Name, pod.Labels)
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder).
+			WithDrainLease("new-leader", time.Minute)
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:        true,
+			Force:         true,
+			TimeoutSecond: 300,
+		}
+		err = drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		Eventually(func() string {
+			lease, _ := upgrade.GetDrainLease(getNode(node.Name))
+			return lease.HolderIdentity
+		}).WithTimeout(5 * time.Second).Should(Equal("new-leader"))
+		Expect(getNode(node.Name).Spec.Unschedulable).To(BeTrue())
+
+		Expect(drainManager.CancelNodeDrain(ctx, node.Name)).To(BeTrue())
+		Expect(getNode(node.Name).Annotations).NotTo(HaveKey(upgrade.GetUpgradeDrainLeaseAnnotationKey()))
+	})
+	It("DrainManager should renew the drain lease while the node changes without recording events", func() {
+		ctx := context.TODO()
+
+		node := NewNode("renewed-lease-node").
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			Create()
+		namespace := createNamespace("renewed-lease-" + randSeq(5))
+		pod := NewPod("blocked-pod", namespace.Name, node.Name).Pod
+		pod.Labels = map[string]string{"app": "blocked"}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+		createBlockingPDB(namespace.Name, pod.Labels)
+
+		recorder := record.NewFakeRecorder(100)
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, recorder), log, recorder).
+			WithDrainLease("leader", 1500*time.Millisecond)
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:        true,
+			Force:         true,
+			TimeoutSecond: 300,
+		}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+
+		// the node is cordoned and its drain status is written while the lease is held
+		Eventually(func() bool {
+			lease, ok := upgrade.GetDrainLease(getNode(node.Name))
+			return ok && lease.RenewTime.After(lease.AcquireTime.Time)
+		}).WithTimeout(5 * time.Second).Should(BeTrue())
+		Expect(getNode(node.Name).Spec.Unschedulable).To(BeTrue())
+
+		Expect(drainManager.CancelNodeDrain(ctx, node.Name)).To(BeTrue())
+		Expect(getNode(node.Name).Annotations).NotTo(HaveKey(upgrade.GetUpgradeDrainLeaseAnnotationKey()))
+		for len(recorder.Events) > 0 {
+			Expect(<-recorder.Events).NotTo(ContainSubstring(upgrade.GetUpgradeDrainLeaseAnnotationKey()))
+		}
+	})
+	It("DrainManager should drain a node raced for by two drain managers only once", func() {
+		ctx := context.TODO()
+
+		node := NewNode("raced-lease-node").
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			Create()
+		namespace := createNamespace("raced-lease-" + randSeq(5))
+		pod := NewPod("blocked-pod", namespace.Name, node.Name).Pod
+		pod.Labels = map[string]string{"app": "blocked"}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+		createBlockingPDB(namespace.Name, pod.Labels)
+
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:        true,
+			Force:         true,
+			TimeoutSecond: 300,
+		}
+		drainManagers := map[string]*upgrade.DrainManagerImpl{}
+		for _, identity := range []string{"old-leader", "new-leader"} {
+			drainManagers[identity] = upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder).
+				WithDrainLease(identity, time.Minute)
+		}
+		// both managers got the node before any of them wrote its lease
+		for _, drainManager := range drainManagers {
+			err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node.DeepCopy()}, Spec: drainSpec})
+			Expect(err).To(Succeed())
+		}
+
+		var loser string
+		Eventually(func() string {
+			for identity, drainManager := range drainManagers {
+				for _, result := range drainManager.TakeDrainResults() {
+					Expect(result.Err).To(MatchError(ContainSubstring("drain lease")))
+					loser = identity
+				}
+			}
+			return loser
+		}).WithTimeout(5 * time.Second).ShouldNot(BeEmpty())
+		lease, ok := upgrade.GetDrainLease(getNode(node.Name))
+		Expect(ok).To(BeTrue())
+		Expect(lease.HolderIdentity).NotTo(Equal(loser))
+		Expect(drainManagers[loser].CancelNodeDrain(ctx, node.Name)).To(BeFalse())
+		Expect(drainManagers[lease.HolderIdentity].CancelNodeDrain(ctx, node.Name)).To(BeTrue())
+	})
+	It("DrainManager should stop the drain of a node whose drain lease was taken over", func() {
+		ctx := context.TODO()
+
+		node := NewNode("taken-over-lease-node").
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			Create()
+		namespace := createNamespace("taken-over-lease-" + randSeq(5))
+		pod := NewPod("blocked-pod", namespace.Name, node.Name).Pod
+		pod.Labels = map[string]string{"app": "blocked"}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+		createBlockingPDB(namespace.Name, pod.Labels)
+
+		drainManager := upgrade.NewDrainManager(k8sInterface, upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder), log, eventRecorder).
+			WithDrainLease("old-leader", 1500*time.Millisecond)
+		drainSpec := &v1alpha1.DrainSpec{
+			Enable:        true,
+			Force:         true,
+			TimeoutSecond: 300,
+		}
+		err := drainManager.ScheduleNodesDrain(ctx, &upgrade.DrainConfiguration{Nodes: []*corev1.Node{node}, Spec: drainSpec})
+		Expect(err).To(Succeed())
+		Eventually(func() bool {
+			_, ok := upgrade.GetDrainLease(getNode(node.Name))
+			return ok
+		}).WithTimeout(5 * time.Second).Should(BeTrue())
+
+		// another drain manager takes the lease over, e.g. after the renewals of the holder were delayed
+		lease, err := json.Marshal(upgrade.DrainLease{
+			HolderIdentity:       "new-leader",
+			AcquireTime:          metav1.Now(),
+			RenewTime:            metav1.Now(),
+			LeaseDurationSeconds: 60,
+		})
+		Expect(err).To(Succeed())
+		leasedNode := getNode(node.Name)
+		leasedNode.Annotations[upgrade.GetUpgradeDrainLeaseAnnotationKey()] = string(lease)
+		Expect(k8sClient.Update(ctx, leasedNode)).To(Succeed())
+
+		// the drain is interrupted once the eviction in progress returns
+		Eventually(drainManager.TakeDrainResults).WithTimeout(10 * time.Second).Should(ContainElement(
+			HaveField("Err", MatchError(ContainSubstring("drain interrupted")))))
+		observedNode := getNode(node.Name)
+		status := upgrade.DrainStatus{}
+		Expect(json.Unmarshal([]byte(observedNode.Annotations[upgrade.GetUpgradeDrainStatusAnnotationKey()]),
+			&status)).To(Succeed())
+		Expect(status.LastError).To(ContainSubstring("drain lease lost"))
+		Expect(observedNode.Annotations[upgrade.GetUpgradeDrainLeaseAnnotationKey()]).To(Equal(string(lease)))
+		Expect(getNodeUpgradeState(observedNode)).To(Equal(upgrade.UpgradeStateDrainRequired))
+	})
 })
+
+// createStaticPod creates the mirror pod of a static pod running on the node
+func createStaticPod(ctx context.Context, node *corev1.Node) {
+	namespace := createNamespace("static-pod-" + randSeq(5))
+	pod := NewPod("static-pod", namespace.Name, node.Name).Pod
+	pod.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: "mirror"}
+	Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+	createdObjects = append(createdObjects, pod)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+)
+
+// getNodeDrainSpec returns the drain spec of the node: the drain spec of the upgrade policy with the overrides
+// of the drain annotations of the node, e.g. a longer drain timeout for a node with storage-heavy workloads.
+// drainSpec is returned as is if the node has no drain annotations. The invalid annotations are ignored
+// and returned as an error.
+func (k UpgradeKeys) getNodeDrainSpec(node *corev1.Node, drainSpec *v1alpha1.DrainSpec) (*v1alpha1.DrainSpec, error) {
+	timeoutValue, hasTimeout := node.Annotations[k.GetUpgradeDrainTimeoutAnnotationKey()]
+	forceValue, hasForce := node.Annotations[k.GetUpgradeDrainForceAnnotationKey()]
+	deleteEmptyDirValue, hasDeleteEmptyDir := node.Annotations[k.GetUpgradeDrainDeleteEmptyDirAnnotationKey()]
+	if !hasTimeout && !hasForce && !hasDeleteEmptyDir {
+		return drainSpec, nil
+	}
+
+	nodeDrainSpec := drainSpec.DeepCopy()
+	var errs []error
+	if hasTimeout {
+		timeout, err := parseDrainTimeout(timeoutValue)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s annotation %q: %v",
+				k.GetUpgradeDrainTimeoutAnnotationKey(), timeoutValue, err))
+		} else {
+			nodeDrainSpec.TimeoutSecond = int(timeout.Seconds())
+		}
+	}
+	if hasForce {
+		force, err := strconv.ParseBool(forceValue)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s annotation %q: %v",
+				k.GetUpgradeDrainForceAnnotationKey(), forceValue, err))
+		} else {
+			nodeDrainSpec.Force = force
+		}
+	}
+	if hasDeleteEmptyDir {
+		deleteEmptyDir, err := strconv.ParseBool(deleteEmptyDirValue)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s annotation %q: %v",
+				k.GetUpgradeDrainDeleteEmptyDirAnnotationKey(), deleteEmptyDirValue, err))
+		} else {
+			nodeDrainSpec.DeleteEmptyDir = deleteEmptyDir
+		}
+	}
+	return nodeDrainSpec, errors.Join(errs...)
+}
+
+// parseDrainTimeout parses a drain timeout annotation, a duration, e.g. "10m", or a number of seconds.
+// Zero means infinite, like the TimeoutSecond field of the drain spec.
+func parseDrainTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, atoiErr := strconv.Atoi(value)
+		if atoiErr != nil {
+			return 0, err
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("negative drain timeout")
+	}
+	return timeout, nil
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// DrainStatus is the progress of the drain of a node
+type DrainStatus struct {
+	// PodsRemaining is the count of pods still to be evicted or deleted from the node
+	PodsRemaining int `json:"podsRemaining"`
+	// PodsEvicted is the count of pods evicted or deleted from the node
+	PodsEvicted int `json:"podsEvicted"`
+	// StartTime is the time the drain of the node started
+	StartTime meta_v1.Time `json:"startTime"`
+	// LastError is the last error reported by the drain, e.g. an eviction rejected due to a PodDisruptionBudget
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Elapsed returns the time since the drain of the node started
+func (s DrainStatus) Elapsed() time.Duration {
+	return time.Since(s.StartTime.Time)
+}
+
+// DrainStatusProvider is implemented by drain managers which report the progress of the node drains
+type DrainStatusProvider interface {
+	// GetDrainStatus returns the progress of the drain of the node, false if the node is not being drained
+	GetDrainStatus(nodeName string) (DrainStatus, bool)
+}
+
+// GetDrainStatus returns the progress of the drain of the node, false if the node is not being drained
+func (m *DrainManagerImpl) GetDrainStatus(nodeName string) (DrainStatus, bool) {
+	return m.drainStatuses.get(nodeName)
+}
+
+// drainStatusTracker keeps the progress of the nodes being drained
+type drainStatusTracker struct {
+	mutex    sync.Mutex
+	statuses map[string]*DrainStatus
+}
+
+// newDrainStatusTracker creates an empty drainStatusTracker
+func newDrainStatusTracker() *drainStatusTracker {
+	return &drainStatusTracker{statuses: make(map[string]*DrainStatus)}
+}
+
+// start starts tracking the drain of the node with the given count of pods to evict
+func (t *drainStatusTracker) start(nodeName string, pods int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.statuses[nodeName] = &DrainStatus{PodsRemaining: pods, StartTime: meta_v1.Now()}
+}
+
+// evicted records a pod evicted or deleted from the node
+func (t *drainStatusTracker) evicted(nodeName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if status, ok := t.statuses[nodeName]; ok {
+		status.PodsEvicted++
+		if status.PodsRemaining > 0 {
+			status.PodsRemaining--
+		}
+	}
+}
+
+// setError records the last error reported by the drain of the node
+func (t *drainStatusTracker) setError(nodeName, message string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if status, ok := t.statuses[nodeName]; ok {
+		status.LastError = message
+	}
+}
+
+// done stops tracking the drain of the node
+func (t *drainStatusTracker) done(nodeName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.statuses, nodeName)
+}
+
+// get returns a copy of the progress of the drain of the node
+func (t *drainStatusTracker) get(nodeName string) (DrainStatus, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	status, ok := t.statuses[nodeName]
+	if !ok {
+		return DrainStatus{}, false
+	}
+	return *status, true
+}
+
+// drainErrorRecorder is an io.Writer which forwards the drain helper error output and records its last line
+// as the last error of the drain of the node
+type drainErrorRecorder struct {
+	out      io.Writer
+	nodeName string
+	tracker  *drainStatusTracker
+}
+
+// Write implements io.Writer
+func (r *drainErrorRecorder) Write(p []byte) (int, error) {
+	if message := strings.TrimSpace(string(p)); message != "" {
+		r.tracker.setError(r.nodeName, message)
+	}
+	return r.out.Write(p)
+}
+
+// reportDrainStatus records the progress of the drain of the nodes in the drain status annotation, if the drain
+// manager implements DrainStatusProvider. A Warning event is emitted on the node when the drain reports a new error,
+// so that a stalled drain, e.g. blocked by a PodDisruptionBudget, is visible in the events of the node.
+func (m *ClusterUpgradeStateManagerImpl) reportDrainStatus(ctx context.Context, nodes []*corev1.Node) error {
+	provider, ok := m.DrainManager.(DrainStatusProvider)
+	if !ok {
+		return nil
+	}
+	annotationKey := m.keys.GetUpgradeDrainStatusAnnotationKey()
+	for _, node := range nodes {
+		status, ok := provider.GetDrainStatus(node.Name)
+		if !ok {
+			continue
+		}
+		value, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		previous := DrainStatus{}
+		if current, present := node.Annotations[annotationKey]; present {
+			if current == string(value) {
+				continue
+			}
+			// an invalid annotation is overwritten
+			_ = json.Unmarshal([]byte(current), &previous)
+		}
+
+		if status.LastError != "" && status.LastError != previous.LastError {
+			m.Log.V(consts.LogLevelInfo).Info("Node drain reported an error", "node", node.Name,
+				"podsRemaining", status.PodsRemaining, "error", status.LastError)
+			logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+				"Drain of the node is stalled with %d pods remaining after %s: %s", status.PodsRemaining,
+				status.Elapsed().Round(time.Second), status.LastError)
+		}
+		err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, string(value))
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to update node drain status annotation",
+				"node", node.Name, "annotation", annotationKey)
+			return err
+		}
+	}
+	return nil
+}
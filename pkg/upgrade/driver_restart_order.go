@@ -0,0 +1,78 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"slices"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// WithDriverRestartOrder provides an option to restart the driver pods of the nodes covered by several driver
+// DaemonSets, see NodeUpgradeState.AdditionalDrivers, in the order of the given DaemonSet names, e.g. the kernel
+// driver, then the device plugin, then the monitoring agent. The pod of a driver is restarted once the pods
+// of the drivers before it are up to date and ready, the drivers whose DaemonSet is not in the order are restarted
+// without waiting. Without an order, all the outdated driver pods of a node are restarted together.
+func (m *ClusterUpgradeStateManagerImpl) WithDriverRestartOrder(daemonSetNames ...string) ClusterUpgradeStateManager {
+	m.driverRestartOrder = daemonSetNames
+	return m
+}
+
+// getDriverRestartIndex returns the position of the driver in the restart order, -1 if it's not ordered
+func (m *ClusterUpgradeStateManagerImpl) getDriverRestartIndex(driver *NodeDriver) int {
+	if driver.DaemonSet == nil {
+		return -1
+	}
+	return slices.Index(m.driverRestartOrder, driver.DaemonSet.Name)
+}
+
+// getDriversToRestart returns the outdated drivers of the node to restart now: the unordered ones, and the first
+// ones in the restart order if the drivers before them are ready
+func (m *ClusterUpgradeStateManagerImpl) getDriversToRestart(nodeState *NodeUpgradeState,
+	outdatedDrivers []*NodeDriver) []*NodeDriver {
+	if len(m.driverRestartOrder) == 0 {
+		return outdatedDrivers
+	}
+	nextIndex := -1
+	for _, driver := range outdatedDrivers {
+		if index := m.getDriverRestartIndex(driver); index >= 0 && (nextIndex < 0 || index < nextIndex) {
+			nextIndex = index
+		}
+	}
+	// the drivers before the next one in the order are up to date, their new pods have to be ready
+	ready := true
+	drivers := append([]*NodeDriver{{Pod: nodeState.DriverPod, DaemonSet: nodeState.DriverDaemonSet,
+		Workload: nodeState.DriverWorkload}},
+		nodeState.AdditionalDrivers...)
+	for _, driver := range drivers {
+		if index := m.getDriverRestartIndex(driver); index >= 0 && index < nextIndex &&
+			!isPodRunningAndReady(driver.Pod) {
+			m.Log.V(consts.LogLevelInfo).Info("Waiting for the driver pod to be ready before restarting the next driver",
+				"node", nodeState.Node.Name, "pod", driver.Pod.Name, "next", m.driverRestartOrder[nextIndex])
+			ready = false
+		}
+	}
+
+	restartDrivers := make([]*NodeDriver, 0, len(outdatedDrivers))
+	for _, driver := range outdatedDrivers {
+		index := m.getDriverRestartIndex(driver)
+		if index < 0 || (index == nextIndex && ready) {
+			restartDrivers = append(restartDrivers, driver)
+		}
+	}
+	return restartDrivers
+}
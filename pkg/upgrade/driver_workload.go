@@ -0,0 +1,170 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// DriverWorkload is a driver which is not deployed by a DaemonSet, e.g. static pods, plain pods or a Deployment
+// per node. The nodes running its driver pods go through the same upgrade states as the nodes of the driver
+// DaemonSets, the detection of the outdated driver pods and their restart are delegated to the workload.
+type DriverWorkload interface {
+	// GetDriverPods returns the driver pods of the workload, at most one per node
+	GetDriverPods(ctx context.Context) ([]*corev1.Pod, error)
+	// IsUpgradeRequired returns true if the driver pod doesn't run the target driver of the workload
+	IsUpgradeRequired(ctx context.Context, pod *corev1.Pod) (bool, error)
+	// RestartDriverPod replaces the outdated driver pod by a pod running the target driver
+	RestartDriverPod(ctx context.Context, pod *corev1.Pod) error
+}
+
+// podDriverWorkload is a driver deployed as pods recreated by their controller once deleted
+type podDriverWorkload struct {
+	k8sClient     client.Client
+	namespace     string
+	selector      labels.Selector
+	versionLabel  string
+	targetVersion string
+}
+
+// NewPodDriverWorkload returns a DriverWorkload for the driver pods in the namespace matching the selector, e.g.
+// plain pods created by an operator or the pods of a Deployment per node. A driver pod is outdated if the value of
+// its versionLabel differs from targetVersion, and it is restarted by its deletion, its controller has to create
+// the pod of the target version on the node. The mirror pods of static pods can't be restarted by their deletion,
+// restarting them requires a DriverWorkload replacing their manifest on the node.
+func NewPodDriverWorkload(k8sClient client.Client, namespace string, selector labels.Selector,
+	versionLabel, targetVersion string) DriverWorkload {
+	return &podDriverWorkload{
+		k8sClient:     k8sClient,
+		namespace:     namespace,
+		selector:      selector,
+		versionLabel:  versionLabel,
+		targetVersion: targetVersion,
+	}
+}
+
+// GetDriverPods implements DriverWorkload
+func (w *podDriverWorkload) GetDriverPods(ctx context.Context) ([]*corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	err := w.k8sClient.List(ctx, podList,
+		client.InNamespace(w.namespace),
+		client.MatchingLabelsSelector{Selector: w.selector})
+	if err != nil {
+		return nil, fmt.Errorf("error getting driver pod list: %v", err)
+	}
+	pods := make([]*corev1.Pod, 0, len(podList.Items))
+	for i := range podList.Items {
+		pods = append(pods, &podList.Items[i])
+	}
+	return pods, nil
+}
+
+// IsUpgradeRequired implements DriverWorkload
+func (w *podDriverWorkload) IsUpgradeRequired(_ context.Context, pod *corev1.Pod) (bool, error) {
+	return pod.Labels[w.versionLabel] != w.targetVersion, nil
+}
+
+// RestartDriverPod implements DriverWorkload
+func (w *podDriverWorkload) RestartDriverPod(ctx context.Context, pod *corev1.Pod) error {
+	if isStaticPod(*pod) {
+		return fmt.Errorf("static driver pod %s can't be restarted by its deletion", pod.Name)
+	}
+	return client.IgnoreNotFound(w.k8sClient.Delete(ctx, pod))
+}
+
+// WithDriverWorkloads provides an option to upgrade the nodes running the driver pods of workloads which are not
+// DaemonSets, e.g. static pods, plain pods or a Deployment per node. Their nodes are added to the state built by
+// BuildState, unless the node already runs a driver pod of a driver DaemonSet.
+func (m *ClusterUpgradeStateManagerImpl) WithDriverWorkloads(workloads ...DriverWorkload) ClusterUpgradeStateManager {
+	m.driverWorkloads = workloads
+	return m
+}
+
+// addDriverWorkloadNodeStates adds the states of the nodes running the driver pods of the workloads set with
+// WithDriverWorkloads to the upgrade state
+func (m *ClusterUpgradeStateManagerImpl) addDriverWorkloadNodeStates(ctx context.Context,
+	upgradeState *ClusterUpgradeState) error {
+	if len(m.driverWorkloads) == 0 {
+		return nil
+	}
+	knownNodes := make(map[string]bool)
+	for _, nodeStates := range upgradeState.NodeStates {
+		for _, nodeState := range nodeStates {
+			knownNodes[nodeState.Node.Name] = true
+		}
+	}
+	for _, workload := range m.driverWorkloads {
+		pods, err := workload.GetDriverPods(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to get driver workload pods: %v", err)
+		}
+		for _, pod := range pods {
+			if pod.Spec.NodeName == "" {
+				m.Log.V(consts.LogLevelInfo).Info("Driver Pod has no NodeName, skipping", "pod", pod.Name)
+				continue
+			}
+			if knownNodes[pod.Spec.NodeName] {
+				m.Log.V(consts.LogLevelInfo).Info("Node already runs another driver pod, skipping",
+					"node", pod.Spec.NodeName, "pod", pod.Name)
+				continue
+			}
+			nodeState, err := m.buildNodeUpgradeState(ctx, pod.Spec.NodeName, pod, nil)
+			if err != nil {
+				return err
+			}
+			if !m.isNodeInUpgradeScope(nodeState.Node) {
+				err = m.compactOutOfScopeNode(ctx, nodeState.Node)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			nodeState.DriverWorkload = workload
+			knownNodes[nodeState.Node.Name] = true
+			nodeStateLabel := m.getNodeUpgradeState(nodeState.Node)
+			upgradeState.NodeStates[nodeStateLabel] = append(upgradeState.NodeStates[nodeStateLabel], nodeState)
+		}
+	}
+	return nil
+}
+
+// restartWorkloadDriverPods restarts the driver pods of the drivers deployed by a DriverWorkload and returns
+// the other driver pods, which are restarted by the PodManager
+func (m *ClusterUpgradeStateManagerImpl) restartWorkloadDriverPods(ctx context.Context,
+	drivers []*NodeDriver) ([]*corev1.Pod, error) {
+	pods := make([]*corev1.Pod, 0, len(drivers))
+	for _, driver := range drivers {
+		if driver.Workload == nil {
+			pods = append(pods, driver.Pod)
+			continue
+		}
+		m.Log.V(consts.LogLevelInfo).Info("Restarting driver workload pod", "pod", driver.Pod.Name)
+		err := driver.Workload.RestartDriverPod(ctx, driver.Pod)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to restart driver workload pod", "pod", driver.Pod.Name)
+			return nil, err
+		}
+	}
+	return pods, nil
+}
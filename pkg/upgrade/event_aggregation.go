@@ -0,0 +1,136 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// minEventPruneThreshold is the count of recorded events from which the expired ones are pruned
+const minEventPruneThreshold = 1024
+
+// eventKey identifies the repetitions of an event
+type eventKey struct {
+	object    string
+	eventtype string
+	reason    string
+	message   string
+}
+
+// eventAggregator is a record.EventRecorder which, once enabled, drops the events identical to an event
+// recorded on the same object less than interval ago
+type eventAggregator struct {
+	record.EventRecorder
+
+	mutex sync.Mutex
+	// interval is the period identical events are dropped for, events are not aggregated if it's 0
+	interval time.Duration
+	// recorded are the times the events were last recorded, the expired ones are kept until they are pruned
+	recorded map[eventKey]time.Time
+	// pruneThreshold is the count of recorded events from which the expired ones are pruned, twice the count
+	// of the events left by the last pruning, so that the pruning cost is amortized over the recorded events
+	pruneThreshold int
+}
+
+// newEventAggregator creates a disabled eventAggregator recording the events with recorder
+func newEventAggregator(recorder record.EventRecorder) *eventAggregator {
+	return &eventAggregator{EventRecorder: recorder, recorded: make(map[eventKey]time.Time),
+		pruneThreshold: minEventPruneThreshold}
+}
+
+// Event implements record.EventRecorder
+func (a *eventAggregator) Event(object runtime.Object, eventtype, reason, message string) {
+	if a.drop(object, eventtype, reason, message) {
+		return
+	}
+	a.EventRecorder.Event(object, eventtype, reason, message)
+}
+
+// Eventf implements record.EventRecorder
+func (a *eventAggregator) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if a.drop(object, eventtype, reason, fmt.Sprintf(messageFmt, args...)) {
+		return
+	}
+	a.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// drop returns true if an identical event was recorded less than interval ago, otherwise the event is
+// recorded as the last one
+func (a *eventAggregator) drop(object runtime.Object, eventtype, reason, message string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.interval == 0 {
+		return false
+	}
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	key := eventKey{
+		object:    fmt.Sprintf("%T %s/%s", object, accessor.GetNamespace(), accessor.GetName()),
+		eventtype: eventtype,
+		reason:    reason,
+		message:   message,
+	}
+	if recorded, ok := a.recorded[key]; ok && now.Sub(recorded) < a.interval {
+		return true
+	}
+	a.recorded[key] = now
+	if len(a.recorded) >= a.pruneThreshold {
+		a.prune(now)
+	}
+	return false
+}
+
+// prune removes the events recorded at least interval ago and raises the prune threshold to twice the count
+// of the remaining events
+func (a *eventAggregator) prune(now time.Time) {
+	for key, recorded := range a.recorded {
+		if now.Sub(recorded) >= a.interval {
+			delete(a.recorded, key)
+		}
+	}
+	a.pruneThreshold = max(2*len(a.recorded), minEventPruneThreshold)
+}
+
+// WithEventAggregation provides an option to drop the events identical to an event recorded on the same object,
+// e.g. a node, less than interval ago, so that the events repeated by every ApplyState pass, like a drain held
+// by a PodDisruptionBudget, don't flood the API server. It applies to the events of all the managers created
+// with the upgrade state manager. Zero disables the aggregation, it is disabled by default.
+func (m *ClusterUpgradeStateManagerImpl) WithEventAggregation(interval time.Duration) ClusterUpgradeStateManager {
+	if m.eventAggregator == nil {
+		m.Log.V(consts.LogLevelWarning).Info("Cannot aggregate events without an event recorder")
+		return m
+	}
+	if interval < 0 {
+		m.Log.V(consts.LogLevelWarning).Info("Ignoring negative event aggregation interval", "interval", interval)
+		interval = 0
+	}
+	m.eventAggregator.mutex.Lock()
+	defer m.eventAggregator.mutex.Unlock()
+	m.eventAggregator.interval = interval
+	return m
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// nodeEventKey groups the node events with the same reason and message
+type nodeEventKey struct {
+	reason  string
+	message string
+}
+
+// nodeEventSummarizer is a record.EventRecorder which, once enabled, collects the Normal events of the nodes
+// instead of recording them, and records them as a single event per reason and message on the summary object.
+// Warning events and events of other objects are always recorded immediately.
+type nodeEventSummarizer struct {
+	record.EventRecorder
+
+	mutex sync.Mutex
+	// object is the object the summarized events are recorded on, events are not summarized if it's nil
+	object runtime.Object
+	// maxNodeNames is the maximum count of node names listed in a summarized event
+	maxNodeNames int
+	// pending are the names of the nodes of the collected events, by reason and message
+	pending map[nodeEventKey]map[string]struct{}
+	// capacityEvents are the last upgrade capacity events recorded on the summary object, by node pool
+	capacityEvents map[string]string
+}
+
+// newNodeEventSummarizer creates a disabled nodeEventSummarizer recording the events with recorder
+func newNodeEventSummarizer(recorder record.EventRecorder) *nodeEventSummarizer {
+	return &nodeEventSummarizer{EventRecorder: recorder, pending: make(map[nodeEventKey]map[string]struct{}),
+		capacityEvents: make(map[string]string)}
+}
+
+// Event implements record.EventRecorder
+func (s *nodeEventSummarizer) Event(object runtime.Object, eventtype, reason, message string) {
+	if s.collect(object, eventtype, reason, message) {
+		return
+	}
+	s.EventRecorder.Event(object, eventtype, reason, message)
+}
+
+// Eventf implements record.EventRecorder
+func (s *nodeEventSummarizer) Eventf(object runtime.Object, eventtype, reason, messageFmt string,
+	args ...interface{}) {
+	if s.collect(object, eventtype, reason, fmt.Sprintf(messageFmt, args...)) {
+		return
+	}
+	s.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// collect stores the event for the next summary and returns true if the event has to be summarized
+func (s *nodeEventSummarizer) collect(object runtime.Object, eventtype, reason, message string) bool {
+	node, ok := object.(*corev1.Node)
+	if !ok || eventtype != corev1.EventTypeNormal {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.object == nil {
+		return false
+	}
+	key := nodeEventKey{reason: reason, message: message}
+	if s.pending[key] == nil {
+		s.pending[key] = make(map[string]struct{})
+	}
+	s.pending[key][node.Name] = struct{}{}
+	return true
+}
+
+// flush records the collected events of the phase on the summary object, one event per reason and message
+func (s *nodeEventSummarizer) flush(phase string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.object == nil || len(s.pending) == 0 {
+		return
+	}
+	if phase == UpgradeStateUnknown {
+		phase = "unknown"
+	}
+	keys := make([]nodeEventKey, 0, len(s.pending))
+	for key := range s.pending {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].reason != keys[j].reason {
+			return keys[i].reason < keys[j].reason
+		}
+		return keys[i].message < keys[j].message
+	})
+	for _, key := range keys {
+		nodeNames := make([]string, 0, len(s.pending[key]))
+		for nodeName := range s.pending[key] {
+			nodeNames = append(nodeNames, nodeName)
+		}
+		sort.Strings(nodeNames)
+		s.EventRecorder.Event(s.object, corev1.EventTypeNormal, key.reason,
+			fmt.Sprintf("%s: %s on %d nodes: %s", phase, key.message, len(nodeNames),
+				formatNodeNames(nodeNames, s.maxNodeNames)))
+	}
+	s.pending = make(map[nodeEventKey]map[string]struct{})
+}
+
+// formatNodeNames returns the comma separated node names, listing at most maxNodeNames of them
+func formatNodeNames(nodeNames []string, maxNodeNames int) string {
+	if len(nodeNames) <= maxNodeNames {
+		return strings.Join(nodeNames, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(nodeNames[:maxNodeNames], ", "), len(nodeNames)-maxNodeNames)
+}
+
+// WithSummarizedEvents provides an option to record a single event per upgrade state and message on the given
+// object, e.g. the custom resource of the operator, at the end of every upgrade state processed by ApplyState,
+// instead of a Normal event on every node. The events list at most maxNodeNames node names.
+// Warning events are still recorded on the nodes. The upgrade capacity computed by every pass is recorded on the object
+// as well, whenever it changes. Without summarized events there is no object to record it on, it is only available
+// with GetUpgradeCapacity and in the status ConfigMap, see WithStatusConfigMap.
+func (m *ClusterUpgradeStateManagerImpl) WithSummarizedEvents(object runtime.Object,
+	maxNodeNames int) ClusterUpgradeStateManager {
+	if m.eventSummarizer == nil || object == nil {
+		m.Log.V(consts.LogLevelWarning).Info("Cannot summarize events without an event recorder and an object")
+		return m
+	}
+	if maxNodeNames < 0 {
+		m.Log.V(consts.LogLevelWarning).Info("Ignoring negative max node names", "maxNodeNames", maxNodeNames)
+		maxNodeNames = 0
+	}
+	m.eventSummarizer.mutex.Lock()
+	defer m.eventSummarizer.mutex.Unlock()
+	m.eventSummarizer.object = object
+	m.eventSummarizer.maxNodeNames = maxNodeNames
+	return m
+}
+
+// flushSummarizedEvents records the node events collected while processing the phase upgrade state
+func (m *ClusterUpgradeStateManagerImpl) flushSummarizedEvents(phase string) {
+	if m.eventSummarizer != nil {
+		m.eventSummarizer.flush(phase)
+	}
+}
+
+// recordUpgradeCapacityEvent records the upgrade capacity of the pool computed by the pass on the summary object set
+// with WithSummarizedEvents, only when it differs from the one recorded by the previous pass. Nothing is recorded
+// if the events are not summarized.
+func (m *ClusterUpgradeStateManagerImpl) recordUpgradeCapacityEvent(pool, message string) {
+	s := m.eventSummarizer
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.object == nil || s.capacityEvents[pool] == message {
+		return
+	}
+	s.capacityEvents[pool] = message
+	if pool != "" {
+		message = fmt.Sprintf("%s: %s", pool, message)
+	}
+	s.EventRecorder.Event(s.object, corev1.EventTypeNormal, GetEventReason(), message)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	policyv1client "k8s.io/client-go/kubernetes/typed/policy/v1"
+	"k8s.io/client-go/tools/record"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// blockedEvictionClient wraps the client of the drain helper to report the evictions rejected with
+// a TooManyRequests error, e.g. due to a PodDisruptionBudget, which the drain helper retries. The evictions are
+// observed at the API level rather than in the drain helper output, whose format isn't part of its API.
+// Only the policy/v1 evictions are observed, the drain helper uses policy/v1beta1 on clusters older than 1.22 only.
+type blockedEvictionClient struct {
+	kubernetes.Interface
+	// onBlocked is called with the namespace and the name of the pod whose eviction was rejected
+	onBlocked func(namespace, name string)
+}
+
+// PolicyV1 implements kubernetes.Interface
+func (c *blockedEvictionClient) PolicyV1() policyv1client.PolicyV1Interface {
+	return &blockedEvictionPolicyV1{PolicyV1Interface: c.Interface.PolicyV1(), onBlocked: c.onBlocked}
+}
+
+// blockedEvictionPolicyV1 is the policy/v1 client of a blockedEvictionClient
+type blockedEvictionPolicyV1 struct {
+	policyv1client.PolicyV1Interface
+	onBlocked func(namespace, name string)
+}
+
+// Evictions implements policyv1client.PolicyV1Interface
+func (c *blockedEvictionPolicyV1) Evictions(namespace string) policyv1client.EvictionInterface {
+	return &blockedEvictions{EvictionInterface: c.PolicyV1Interface.Evictions(namespace), onBlocked: c.onBlocked}
+}
+
+// blockedEvictions is the eviction client of a blockedEvictionClient
+type blockedEvictions struct {
+	policyv1client.EvictionInterface
+	onBlocked func(namespace, name string)
+}
+
+// Evict implements policyv1client.EvictionInterface
+func (e *blockedEvictions) Evict(ctx context.Context, eviction *policyv1.Eviction) error {
+	err := e.EvictionInterface.Evict(ctx, eviction)
+	if k8serrors.IsTooManyRequests(err) {
+		e.onBlocked(eviction.Namespace, eviction.Name)
+	}
+	return err
+}
+
+// evictionFallback deletes the pods whose eviction has been blocked for longer than DeleteAfterSeconds,
+// see blockedEvictionClient. The drain helper then finds the pod deleted on its next eviction attempt and waits
+// for its termination like for an evicted pod.
+type evictionFallback struct {
+	ctx           context.Context
+	client        kubernetes.Interface
+	node          *corev1.Node
+	spec          *v1alpha1.EvictionFallbackSpec
+	log           logr.Logger
+	eventRecorder record.EventRecorder
+	mutex         sync.Mutex
+	// blockedSince is the time the eviction of a pod, by namespace/name, was first reported as blocked
+	blockedSince map[string]time.Time
+}
+
+// onEvictionBlocked records the blocked eviction of the pod and deletes the pod once it has been blocked
+// for longer than DeleteAfterSeconds
+func (f *evictionFallback) onEvictionBlocked(namespace, name string) {
+	key := namespace + "/" + name
+	f.mutex.Lock()
+	since, ok := f.blockedSince[key]
+	if !ok {
+		since = time.Now()
+		f.blockedSince[key] = since
+	}
+	f.mutex.Unlock()
+	blockedFor := time.Since(since)
+	if blockedFor < time.Duration(f.spec.DeleteAfterSeconds)*time.Second {
+		return
+	}
+
+	f.log.V(consts.LogLevelInfo).Info("Eviction of the pod is blocked, deleting the pod", "node", f.node.Name,
+		"pod", key, "blockedFor", blockedFor.Round(time.Second))
+	err := f.client.CoreV1().Pods(namespace).Delete(f.ctx, name,
+		meta_v1.DeleteOptions{GracePeriodSeconds: f.spec.GracePeriodSeconds})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		// the deletion is attempted again on the next blocked eviction
+		f.log.V(consts.LogLevelError).Error(err, "Failed to delete pod", "node", f.node.Name, "pod", key)
+		return
+	}
+	logEventf(f.eventRecorder, f.node, corev1.EventTypeWarning, GetEventReason(),
+		"Eviction of pod %s was blocked for %ds, deleted the pod without eviction", key, f.spec.DeleteAfterSeconds)
+	f.mutex.Lock()
+	delete(f.blockedSince, key)
+	f.mutex.Unlock()
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// GetNodeUpgradeRetryAttempts returns the count of the retries of the failed upgrade of the node
+func (k UpgradeKeys) GetNodeUpgradeRetryAttempts(node *corev1.Node) int {
+	attempts, err := strconv.Atoi(node.Annotations[k.GetUpgradeRetryAttemptsAnnotationKey()])
+	if err != nil || attempts < 0 {
+		return 0
+	}
+	return attempts
+}
+
+// GetNodeUpgradeRetryAttempts returns UpgradeKeys.GetNodeUpgradeRetryAttempts with the default key prefix
+func GetNodeUpgradeRetryAttempts(node *corev1.Node) int {
+	return UpgradeKeys{}.GetNodeUpgradeRetryAttempts(node)
+}
+
+// getRetryBackoffSeconds returns the backoff before the next retry of a failed node which was already retried
+// the given count of times. The backoff doubles with every attempt and is capped by maxBackoffSeconds.
+func getRetryBackoffSeconds(retry *v1alpha1.RetrySpec, attempts int) int64 {
+	backoff := int64(retry.BackoffSeconds)
+	for i := 0; i < attempts && backoff > 0 && backoff < math.MaxInt32; i++ {
+		backoff *= 2
+	}
+	if retry.MaxBackoffSeconds > 0 && backoff > int64(retry.MaxBackoffSeconds) {
+		return int64(retry.MaxBackoffSeconds)
+	}
+	return backoff
+}
+
+// processFailedNodesRetry moves the nodes still in the upgrade-failed state back to the upgrade-required state
+// once their backoff is over, until the attempts of the retry policy are exhausted. The attempts are counted
+// with an annotation, which is removed when the upgrade of the node is done.
+func (m *ClusterUpgradeStateManagerImpl) processFailedNodesRetry(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, retry *v1alpha1.RetrySpec) error {
+	if retry == nil || retry.MaxAttempts <= 0 {
+		return nil
+	}
+	m.Log.V(consts.LogLevelInfo).Info("ProcessFailedNodesRetry")
+
+	annotationKey := m.keys.GetUpgradeRetryStartTimeAnnotationKey()
+	currentTime := time.Now().Unix()
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateFailed] {
+		node := nodeState.Node
+		if m.getNodeUpgradeState(node) != UpgradeStateFailed {
+			// the node recovered in the current pass
+			continue
+		}
+		attempts := m.keys.GetNodeUpgradeRetryAttempts(node)
+		if attempts >= retry.MaxAttempts {
+			m.Log.V(consts.LogLevelDebug).Info("Node upgrade retry attempts exhausted", "node", node.Name,
+				"attempts", attempts)
+			continue
+		}
+		err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonRetryBackoff)
+		if err != nil {
+			return err
+		}
+		if _, present := node.Annotations[annotationKey]; !present {
+			// add the annotation to track start time
+			err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey,
+				strconv.FormatInt(currentTime, 10))
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Failed to add annotation to track upgrade retry backoff",
+					"node", node.Name, "annotation", annotationKey)
+				return err
+			}
+			continue
+		}
+		startTime, err := strconv.ParseInt(node.Annotations[annotationKey], 10, 64)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to convert start time to track upgrade retry backoff",
+				"node", node.Name)
+			return err
+		}
+		backoffSeconds := getRetryBackoffSeconds(retry, attempts)
+		if currentTime < startTime+backoffSeconds {
+			continue
+		}
+
+		m.Log.V(consts.LogLevelInfo).Info("Retrying failed node upgrade", "node", node.Name,
+			"attempt", attempts+1, "maxAttempts", retry.MaxAttempts)
+		logEventf(m.EventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+			"Retrying failed node upgrade, attempt %d of %d", attempts+1, retry.MaxAttempts)
+		err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node,
+			m.keys.GetUpgradeRetryAttemptsAnnotationKey(), strconv.Itoa(attempts+1))
+		if err != nil {
+			return err
+		}
+		for _, key := range []string{annotationKey, m.keys.GetUpgradeFailedNodeCordonAnnotationKey()} {
+			if _, present := node.Annotations[key]; !present {
+				continue
+			}
+			err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, key, nullString)
+			if err != nil {
+				return err
+			}
+		}
+		err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateUpgradeRequired)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(
+				err, "Failed to change node upgrade state", "node", node.Name, "state", UpgradeStateUpgradeRequired)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+const (
+	// FailedNodeCordoned is the value of the failed node cordon annotation of a failed node left cordoned
+	FailedNodeCordoned = "cordoned"
+	// FailedNodeUncordoned is the value of the failed node cordon annotation of a failed node uncordoned
+	FailedNodeUncordoned = "uncordoned"
+)
+
+// processFailedNodesCordon leaves the nodes still in the upgrade-failed state cordoned, or uncordons them if
+// uncordonFailedNodes is set, and records the choice on the node with an annotation and an event.
+// Nodes which were unschedulable at the beginning of the upgrade are left cordoned. Nodes left cordoned
+// by the policy but made schedulable by other means, e.g. manually, are not cordoned again.
+func (m *ClusterUpgradeStateManagerImpl) processFailedNodesCordon(
+	ctx context.Context, currentClusterState *ClusterUpgradeState, uncordonFailedNodes bool) error {
+	annotationKey := m.keys.GetUpgradeFailedNodeCordonAnnotationKey()
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateFailed] {
+		node := nodeState.Node
+		if m.getNodeUpgradeState(node) != UpgradeStateFailed {
+			// the node recovered in the current pass
+			continue
+		}
+		_, initiallyUnschedulable := node.Annotations[m.keys.GetUpgradeInitialStateAnnotationKey()]
+		choice := FailedNodeCordoned
+		if uncordonFailedNodes && !initiallyUnschedulable {
+			choice = FailedNodeUncordoned
+		}
+		if choice == FailedNodeCordoned && !isNodeUnschedulable(node) {
+			continue
+		}
+		if choice == FailedNodeUncordoned && isNodeUnschedulable(node) {
+			err := m.CordonManager.Uncordon(ctx, node)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Failed to uncordon failed node", "node", node.Name)
+				return err
+			}
+		}
+		if node.Annotations[annotationKey] == choice {
+			continue
+		}
+		m.Log.V(consts.LogLevelInfo).Info("Node upgrade failed", "node", node.Name, "cordon", choice)
+		if choice == FailedNodeUncordoned {
+			logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+				"Node upgrade failed, the node is uncordoned to restore its capacity")
+		} else {
+			logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+				"Node upgrade failed, the node is left cordoned")
+		}
+		err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, choice)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// DriverHealthProbe checks the health of the driver on a node in the upgrade-failed state whose driver pod
+// is in sync, e.g. with an exec or HTTP request against the driver pod or the node.
+// It returns nil if the driver is healthy and an error describing the failure otherwise.
+type DriverHealthProbe func(ctx context.Context, nodeState *NodeUpgradeState) error
+
+// WithDriverHealthProbe registers a probe which must pass, in addition to the driver pod being in sync,
+// before a node in the upgrade-failed state is returned to the uncordon path.
+// Probes are called in the order of registration.
+func (m *ClusterUpgradeStateManagerImpl) WithDriverHealthProbe(probe DriverHealthProbe) ClusterUpgradeStateManager {
+	m.driverHealthProbes = append(m.driverHealthProbes, probe)
+	return m
+}
+
+// isDriverHealthy returns true if all the registered driver health probes pass for the node.
+// A failed probe is logged and reported as an event and the upgrade state reason of the node.
+func (m *ClusterUpgradeStateManagerImpl) isDriverHealthy(ctx context.Context, nodeState *NodeUpgradeState) bool {
+	node := nodeState.Node
+	for _, probe := range m.driverHealthProbes {
+		err := probe(ctx, nodeState)
+		if err == nil {
+			continue
+		}
+		m.Log.V(consts.LogLevelWarning).Info("Driver health probe failed, node stays in failed state",
+			"node", node.Name, "error", err.Error())
+		logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+			"Driver health probe failed: %v", err)
+		if err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node,
+			UpgradeStateReasonHealthProbeFailed); err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to set upgrade state reason", "node", node.Name)
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+)
+
+// ApplyHelperWorkloadSpec applies the scheduling settings of the upgrade policy to the pod spec of a helper workload
+// run on a node being upgraded, e.g. a validation pod or a reboot helper created by the operator. The upgrade
+// library doesn't create such workloads itself, operators call it for the pods they create for the upgrade.
+//   - the priority class is set, if any, and the priority resolved from the previous class is cleared
+//   - the tolerations missing from the pod spec are added
+//   - the node selector is merged into the one of the pod spec
+//   - the resource requests and limits are set on all the containers and init containers, the other resources
+//     of the containers are kept
+func ApplyHelperWorkloadSpec(podSpec *corev1.PodSpec, spec *v1alpha1.HelperWorkloadSpec) {
+	if podSpec == nil || spec == nil {
+		return
+	}
+	if spec.PriorityClassName != "" {
+		podSpec.PriorityClassName = spec.PriorityClassName
+		podSpec.Priority = nil
+	}
+	for i := range spec.Tolerations {
+		if !hasToleration(podSpec.Tolerations, &spec.Tolerations[i]) {
+			podSpec.Tolerations = append(podSpec.Tolerations, *spec.Tolerations[i].DeepCopy())
+		}
+	}
+	if len(spec.NodeSelector) > 0 && podSpec.NodeSelector == nil {
+		podSpec.NodeSelector = make(map[string]string, len(spec.NodeSelector))
+	}
+	for key, value := range spec.NodeSelector {
+		podSpec.NodeSelector[key] = value
+	}
+	if spec.Resources != nil {
+		for i := range podSpec.InitContainers {
+			applyResourceRequirements(&podSpec.InitContainers[i].Resources, spec.Resources)
+		}
+		for i := range podSpec.Containers {
+			applyResourceRequirements(&podSpec.Containers[i].Resources, spec.Resources)
+		}
+	}
+}
+
+// hasToleration returns true if the tolerations contain the toleration
+func hasToleration(tolerations []corev1.Toleration, toleration *corev1.Toleration) bool {
+	for i := range tolerations {
+		if tolerations[i].MatchToleration(toleration) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyResourceRequirements sets the requests and limits of the resources in from on the container resources
+func applyResourceRequirements(resources, from *corev1.ResourceRequirements) {
+	if len(from.Requests) > 0 && resources.Requests == nil {
+		resources.Requests = make(corev1.ResourceList, len(from.Requests))
+	}
+	for name, quantity := range from.Requests {
+		resources.Requests[name] = quantity.DeepCopy()
+	}
+	if len(from.Limits) > 0 && resources.Limits == nil {
+		resources.Limits = make(corev1.ResourceList, len(from.Limits))
+	}
+	for name, quantity := range from.Limits {
+		resources.Limits[name] = quantity.DeepCopy()
+	}
+}
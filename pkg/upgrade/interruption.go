@@ -0,0 +1,76 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// errInterrupted is the error of a drain or a pod deletion whose context was cancelled or exceeded its deadline,
+// e.g. because the operator is shutting down
+var errInterrupted = errors.New("interrupted")
+
+// interruptionReportTimeout is the time the progress of an interrupted operation may take to be recorded
+const interruptionReportTimeout = 10 * time.Second
+
+// isInterrupted returns true if the context of an operation was cancelled or exceeded its deadline, other than by
+// CancelNodeDrain. The node of an interrupted operation is left in its current state, so that the operation is
+// resumed by the next pass, e.g. of the next leader.
+func isInterrupted(ctx context.Context) bool {
+	return ctx.Err() != nil && !isDrainAborted(ctx)
+}
+
+// withInterruptionReportTimeout returns a context which isn't cancelled with ctx, to record the progress
+// of an interrupted operation
+func withInterruptionReportTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), interruptionReportTimeout)
+}
+
+// reportInterruptedDrain records the progress of the interrupted drain of the node in the drain status annotation,
+// so that the next leader knows how far the drain went, and emits an event on the node
+func (m *DrainManagerImpl) reportInterruptedDrain(ctx context.Context, node *corev1.Node, err error) {
+	m.log.V(consts.LogLevelInfo).Info("Node drain interrupted, the node stays in its state", "node", node.Name,
+		"cause", context.Cause(ctx))
+	logEventf(m.eventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+		"Drain of the node was interrupted, it is resumed by the next pass: %v", err)
+	status, ok := m.drainStatuses.get(node.Name)
+	if !ok {
+		// the drain was interrupted before the eviction of the pods started
+		return
+	}
+	status.LastError = fmt.Sprintf("drain interrupted: %v", context.Cause(ctx))
+	value, err := json.Marshal(status)
+	if err != nil {
+		return
+	}
+	reportCtx, cancel := withInterruptionReportTimeout(ctx)
+	defer cancel()
+	annotationKey := m.keys.GetUpgradeDrainStatusAnnotationKey()
+	err = m.nodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(reportCtx, node, annotationKey, string(value))
+	if err != nil {
+		m.log.V(consts.LogLevelError).Error(err, "Failed to record the progress of the interrupted drain",
+			"node", node.Name, "annotation", annotationKey)
+	}
+}
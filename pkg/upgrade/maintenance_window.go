@@ -0,0 +1,209 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// maxMaintenanceWindowDuration is the maximum length of a maintenance window
+const maxMaintenanceWindowDuration = 31 * 24 * time.Hour
+
+// cronField is the set of values matching a single cron expression field
+type cronField map[int]bool
+
+// cronSchedule is a parsed five fields cron expression
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek cronField
+	// dayOfMonthAny and dayOfWeekAny are set if the day fields are "*"
+	dayOfMonthAny, dayOfWeekAny bool
+}
+
+// parseCronField parses a cron expression field with values between minValue and maxValue
+func parseCronField(field string, minValue, maxValue int) (cronField, error) {
+	values := make(cronField)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+		low, high := minValue, maxValue
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			low, err = strconv.Atoi(lowPart)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", lowPart)
+			}
+			high = low
+			if isRange {
+				high, err = strconv.Atoi(highPart)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value %q", highPart)
+				}
+			} else if hasStep {
+				high = maxValue
+			}
+		}
+		if low < minValue || high > maxValue || low > high {
+			return nil, fmt.Errorf("value out of range [%d-%d] in %q", minValue, maxValue, part)
+		}
+		for value := low; value <= high; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// parseCronSchedule parses a standard five fields cron expression
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression %q, got %d", expression, len(fields))
+	}
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	parsed := make([]cronField, len(fields))
+	for i, field := range fields {
+		values, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expression, err)
+		}
+		parsed[i] = values
+	}
+	// both 0 and 7 are Sunday
+	if parsed[4][7] {
+		parsed[4][0] = true
+	}
+	return &cronSchedule{
+		minute:        parsed[0],
+		hour:          parsed[1],
+		dayOfMonth:    parsed[2],
+		month:         parsed[3],
+		dayOfWeek:     parsed[4],
+		dayOfMonthAny: fields[2] == "*",
+		dayOfWeekAny:  fields[4] == "*",
+	}, nil
+}
+
+// matches returns true if the cron schedule fires at the minute of t
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	dayOfMonth := s.dayOfMonth[t.Day()]
+	dayOfWeek := s.dayOfWeek[int(t.Weekday())]
+	// as in cron, a day matches either of the day fields if both are restricted
+	if !s.dayOfMonthAny && !s.dayOfWeekAny {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
+
+// maintenanceWindow is a parsed v1alpha1.UpgradeScheduleSpec
+type maintenanceWindow struct {
+	schedule *cronSchedule
+	location *time.Location
+	duration time.Duration
+}
+
+// parseMaintenanceWindow parses and validates the upgrade schedule
+func parseMaintenanceWindow(spec *v1alpha1.UpgradeScheduleSpec) (*maintenanceWindow, error) {
+	schedule, err := parseCronSchedule(spec.Cron)
+	if err != nil {
+		return nil, err
+	}
+	location := time.UTC
+	if spec.TimeZone != "" {
+		location, err = time.LoadLocation(spec.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %v", spec.TimeZone, err)
+		}
+	}
+	duration := time.Duration(spec.DurationSecond) * time.Second
+	if duration < time.Minute || duration > maxMaintenanceWindowDuration {
+		return nil, fmt.Errorf("maintenance window duration must be between %v and %v, got %v",
+			time.Minute, maxMaintenanceWindowDuration, duration)
+	}
+	return &maintenanceWindow{schedule: schedule, location: location, duration: duration}, nil
+}
+
+// contains returns true if now is within a maintenance window, i.e. the schedule fired at most duration before now
+func (w *maintenanceWindow) contains(now time.Time) bool {
+	now = now.In(w.location)
+	start := now.Truncate(time.Minute)
+	for start.After(now.Add(-w.duration)) {
+		if w.schedule.matches(start) {
+			return true
+		}
+		start = start.Add(-time.Minute)
+	}
+	return false
+}
+
+// isInMaintenanceWindow returns true if node upgrades can start at the given time according to the upgrade policy
+func isInMaintenanceWindow(upgradePolicy *v1alpha1.DriverUpgradePolicySpec, now time.Time) (bool, error) {
+	if upgradePolicy.Schedule == nil {
+		return true, nil
+	}
+	window, err := parseMaintenanceWindow(upgradePolicy.Schedule)
+	if err != nil {
+		return false, err
+	}
+	return window.contains(now), nil
+}
+
+// waitForMaintenanceWindow keeps the UpgradeStateUpgradeRequired nodes in their state until a maintenance window
+// starts and sets the reason of their state
+func (m *ClusterUpgradeStateManagerImpl) waitForMaintenanceWindow(ctx context.Context,
+	currentClusterState *ClusterUpgradeState) error {
+	m.Log.V(consts.LogLevelInfo).Info("Outside of maintenance window, node upgrades are not started")
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateUpgradeRequired] {
+		err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, nodeState.Node,
+			UpgradeStateReasonInMaintenanceWindowWait)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(
+				err, "Failed to set node upgrade state reason", "node", nodeState.Node.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// nextStart returns the start of the next maintenance window after now, false if no window starts within limit
+func (w *maintenanceWindow) nextStart(now time.Time, limit time.Duration) (time.Time, bool) {
+	now = now.In(w.location)
+	start := now.Truncate(time.Minute).Add(time.Minute)
+	for !start.After(now.Add(limit)) {
+		if w.schedule.matches(start) {
+			return start, true
+		}
+		start = start.Add(time.Minute)
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// ManualInterventionPolicy defines how the upgrade state manager reacts to a node which was manually uncordoned
+// while the upgrade library expects it to be cordoned
+type ManualInterventionPolicy string
+
+const (
+	// ManualInterventionPolicyReassert makes the upgrade state manager cordon the node again. This is the default.
+	ManualInterventionPolicyReassert ManualInterventionPolicy = "Reassert"
+	// ManualInterventionPolicyAdopt makes the upgrade state manager keep the node schedulable for the rest of
+	// the upgrade. The node is not cordoned again and not drained.
+	ManualInterventionPolicyAdopt ManualInterventionPolicy = "Adopt"
+)
+
+// WithManualInterventionPolicy provides an option to change how nodes manually uncordoned in the middle
+// of the upgrade are handled
+func (m *ClusterUpgradeStateManagerImpl) WithManualInterventionPolicy(
+	policy ManualInterventionPolicy) ClusterUpgradeStateManager {
+	m.manualInterventionPolicy = policy
+	return m
+}
+
+// isNodeExpectedCordoned returns true if the upgrade library keeps nodes in the given upgrade state cordoned
+func isNodeExpectedCordoned(state string) bool {
+	switch state {
+	case UpgradeStateWaitForJobsRequired, UpgradeStatePodDeletionRequired, UpgradeStateDrainRequired,
+		UpgradeStateRebootRequired, UpgradeStatePodRestartRequired, UpgradeStateValidationRequired:
+		return true
+	}
+	return false
+}
+
+// isNodeManuallyUncordoned returns true if the node manual uncordon was adopted during the current upgrade
+func (k UpgradeKeys) isNodeManuallyUncordoned(node *corev1.Node) bool {
+	return node.Annotations[k.GetUpgradeManualUncordonAnnotationKey()] == trueString
+}
+
+// ProcessManualInterventions detects nodes which were manually uncordoned while the upgrade library expects them
+// to be cordoned, emits a warning event and either cordons them again or adopts the change,
+// according to the manual intervention policy
+func (m *ClusterUpgradeStateManagerImpl) ProcessManualInterventions(
+	ctx context.Context, currentClusterState *ClusterUpgradeState) error {
+	m.Log.V(consts.LogLevelInfo).Info("ProcessManualInterventions")
+
+	annotationKey := m.keys.GetUpgradeManualUncordonAnnotationKey()
+	for state, nodeStates := range currentClusterState.NodeStates {
+		for _, nodeState := range nodeStates {
+			node := nodeState.Node
+			if !isNodeExpectedCordoned(state) {
+				if m.keys.isNodeManuallyUncordoned(node) && state != UpgradeStateUncordonRequired &&
+					state != UpgradeStateFailed {
+					// the upgrade of the node is over
+					err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, nullString)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if isNodeUnschedulable(node) || m.keys.isNodeManuallyUncordoned(node) {
+				continue
+			}
+
+			if m.manualInterventionPolicy == ManualInterventionPolicyAdopt {
+				m.Log.V(consts.LogLevelWarning).Info("Node was manually uncordoned during the upgrade, adopting",
+					"node", node.Name, "state", state)
+				logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+					"Node was manually uncordoned in %s state, it will stay schedulable for the rest of the upgrade",
+					state)
+				err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, trueString)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			m.Log.V(consts.LogLevelWarning).Info("Node was manually uncordoned during the upgrade, cordoning again",
+				"node", node.Name, "state", state)
+			logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+				"Node was manually uncordoned in %s state, cordoning it again", state)
+			err := m.CordonManager.Cordon(ctx, node)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Node cordon failed", "node", node.Name)
+				return err
+			}
+		}
+	}
+	return nil
+}
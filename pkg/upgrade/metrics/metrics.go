@@ -0,0 +1,139 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics provides the Prometheus metrics of the driver upgrade state, updated by the upgrade state manager
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "driver_upgrade"
+	// unknownState is the state label value of the nodes without upgrade state
+	unknownState = "unknown"
+)
+
+// Metrics are the Prometheus metrics of the driver upgrade state
+type Metrics struct {
+	nodesInState  *prometheus.GaugeVec
+	transitions   *prometheus.CounterVec
+	failures      *prometheus.CounterVec
+	drainDuration *prometheus.HistogramVec
+	phaseDuration *prometheus.HistogramVec
+	phaseAPICalls *prometheus.CounterVec
+	workerPools   *workerPoolCollector
+}
+
+// New creates the metrics of the driver, the driver name is added as a label to the metrics
+func New(driverName string) *Metrics {
+	constLabels := prometheus.Labels{"driver": driverName}
+	return &Metrics{
+		nodesInState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "nodes_in_state",
+			Help:        "Count of nodes in each upgrade state",
+			ConstLabels: constLabels,
+		}, []string{"state"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "state_transitions_total",
+			Help:        "Count of node upgrade state changes observed between upgrade passes",
+			ConstLabels: constLabels,
+		}, []string{"from", "to"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "failures_total",
+			Help:        "Count of nodes moved to the upgrade-failed state, by the state they failed in",
+			ConstLabels: constLabels,
+		}, []string{"state"}),
+		drainDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "drain_duration_seconds",
+			Help:        "Duration of the node drains",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(10, 2, 10),
+		}, []string{"result"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "phase_duration_seconds",
+			Help:        "Wall-clock duration of the upgrade phases of the upgrade passes, by the state they process",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.005, 4, 8),
+		}, []string{"phase"}),
+		phaseAPICalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "phase_api_calls_total",
+			Help:        "Count of API server requests sent during the upgrade phases, by the state they process",
+			ConstLabels: constLabels,
+		}, []string{"phase"}),
+		workerPools: newWorkerPoolCollector(constLabels),
+	}
+}
+
+// Register registers the metrics with the registerer, e.g. the controller-runtime metrics.Registry
+func (m *Metrics) Register(registerer prometheus.Registerer) error {
+	for _, collector := range []prometheus.Collector{m.nodesInState, m.transitions, m.failures, m.drainDuration,
+		m.phaseDuration, m.phaseAPICalls, m.workerPools} {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stateLabel returns the value of the state label for the upgrade state
+func stateLabel(state string) string {
+	if state == "" {
+		return unknownState
+	}
+	return state
+}
+
+// SetNodesInState sets the count of nodes in each upgrade state, the states missing from counts are removed
+func (m *Metrics) SetNodesInState(counts map[string]int) {
+	m.nodesInState.Reset()
+	for state, count := range counts {
+		m.nodesInState.WithLabelValues(stateLabel(state)).Set(float64(count))
+	}
+}
+
+// RecordTransition counts a node upgrade state change
+func (m *Metrics) RecordTransition(from, to string) {
+	m.transitions.WithLabelValues(stateLabel(from), stateLabel(to)).Inc()
+}
+
+// RecordFailure counts a node which failed the upgrade in the given state
+func (m *Metrics) RecordFailure(state string) {
+	m.failures.WithLabelValues(stateLabel(state)).Inc()
+}
+
+// ObserveDrainDuration records the duration of a node drain
+func (m *Metrics) ObserveDrainDuration(duration time.Duration, succeeded bool) {
+	result := "success"
+	if !succeeded {
+		result = "failure"
+	}
+	m.drainDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// ObservePhase records the duration and the count of API server requests of an upgrade phase
+func (m *Metrics) ObservePhase(phase string, duration time.Duration, apiCalls int64) {
+	m.phaseDuration.WithLabelValues(stateLabel(phase)).Observe(duration.Seconds())
+	m.phaseAPICalls.WithLabelValues(stateLabel(phase)).Add(float64(apiCalls))
+}
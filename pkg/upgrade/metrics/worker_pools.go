@@ -0,0 +1,87 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// labelPool is the label of the worker pool metrics with the name of the pool
+const labelPool = "pool"
+
+// WorkerPoolStats is the state of a worker pool exported by the worker pool metrics
+type WorkerPoolStats struct {
+	// QueueDepth is the count of nodes scheduled for processing which were not picked up by a worker yet
+	QueueDepth int
+	// ActiveWorkers is the count of workers currently processing a node
+	ActiveWorkers int
+	// OldestItemAge is the time since the oldest node, queued or being processed, was scheduled
+	OldestItemAge time.Duration
+}
+
+// workerPoolCollector exports the stats of the worker pools as Prometheus metrics.
+// The stats are read on every scrape.
+type workerPoolCollector struct {
+	// stats returns the stats of the worker pools by pool name, no metric is exported if it is not set
+	stats         func() map[string]WorkerPoolStats
+	queueDepth    *prometheus.Desc
+	activeWorkers *prometheus.Desc
+	oldestItemAge *prometheus.Desc
+}
+
+// newWorkerPoolCollector creates a workerPoolCollector with the constant labels
+func newWorkerPoolCollector(constLabels prometheus.Labels) *workerPoolCollector {
+	return &workerPoolCollector{
+		queueDepth: prometheus.NewDesc(prometheus.BuildFQName(namespace, "worker_pool", "queue_depth"),
+			"Count of nodes scheduled for processing which were not picked up by a worker yet",
+			[]string{labelPool}, constLabels),
+		activeWorkers: prometheus.NewDesc(prometheus.BuildFQName(namespace, "worker_pool", "active_workers"),
+			"Count of workers currently processing a node",
+			[]string{labelPool}, constLabels),
+		oldestItemAge: prometheus.NewDesc(prometheus.BuildFQName(namespace, "worker_pool", "oldest_item_age_seconds"),
+			"Time since the oldest node, queued or being processed, was scheduled",
+			[]string{labelPool}, constLabels),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *workerPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queueDepth
+	ch <- c.activeWorkers
+	ch <- c.oldestItemAge
+}
+
+// Collect implements prometheus.Collector
+func (c *workerPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.stats == nil {
+		return
+	}
+	for pool, stats := range c.stats() {
+		ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(stats.QueueDepth), pool)
+		ch <- prometheus.MustNewConstMetric(c.activeWorkers, prometheus.GaugeValue, float64(stats.ActiveWorkers), pool)
+		ch <- prometheus.MustNewConstMetric(c.oldestItemAge, prometheus.GaugeValue,
+			stats.OldestItemAge.Seconds(), pool)
+	}
+}
+
+// SetWorkerPoolStats sets the function returning the stats of the worker pools by pool name, read on every scrape.
+// It must be set before the metrics are registered.
+func (m *Metrics) SetWorkerPoolStats(stats func() map[string]WorkerPoolStats) {
+	m.workerPools.stats = stats
+}
@@ -90,6 +90,27 @@ func (_m *PodManager) GetPodDeletionFilter() upgrade.PodDeletionFilter {
 	return r0
 }
 
+// RunNodeHookPod provides a mock function with given fields: ctx, node, hook, template
+func (_m *PodManager) RunNodeHookPod(ctx context.Context, node *corev1.Node, hook upgrade.NodeHook, template *corev1.PodTemplateSpec) (corev1.PodPhase, error) {
+	ret := _m.Called(ctx, node, hook, template)
+
+	var r0 corev1.PodPhase
+	if rf, ok := ret.Get(0).(func(context.Context, *corev1.Node, upgrade.NodeHook, *corev1.PodTemplateSpec) corev1.PodPhase); ok {
+		r0 = rf(ctx, node, hook, template)
+	} else {
+		r0 = ret.Get(0).(corev1.PodPhase)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *corev1.Node, upgrade.NodeHook, *corev1.PodTemplateSpec) error); ok {
+		r1 = rf(ctx, node, hook, template)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ScheduleCheckOnPodCompletion provides a mock function with given fields: ctx, config
 func (_m *PodManager) ScheduleCheckOnPodCompletion(ctx context.Context, config *upgrade.PodManagerConfig) error {
 	ret := _m.Called(ctx, config)
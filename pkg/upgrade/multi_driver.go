@@ -0,0 +1,117 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// NodeDriver is a driver pod of a node and the DaemonSet controlling it
+type NodeDriver struct {
+	Pod       *corev1.Pod
+	DaemonSet *appsv1.DaemonSet
+	// Workload is the workload deploying Pod if the driver is not deployed by a DaemonSet
+	Workload DriverWorkload
+}
+
+// addAdditionalDriver adds the driver pod of another driver DaemonSet covering the node to the node state.
+// If the DaemonSet already has a driver pod on the node, e.g. during a rolling update with maxSurge, the older
+// pod is kept.
+func addAdditionalDriver(nodeState *NodeUpgradeState, pod *corev1.Pod, ds *appsv1.DaemonSet) {
+	for _, driver := range nodeState.AdditionalDrivers {
+		if driver.DaemonSet.UID != ds.UID {
+			continue
+		}
+		if pod.CreationTimestamp.Before(&driver.Pod.CreationTimestamp) {
+			driver.Pod = pod
+		}
+		return
+	}
+	nodeState.AdditionalDrivers = append(nodeState.AdditionalDrivers, &NodeDriver{Pod: pod, DaemonSet: ds})
+}
+
+// sortDaemonSets returns the DaemonSets sorted by namespace and name, so that the primary driver of the nodes
+// covered by several driver DaemonSets is the same on every pass
+func sortDaemonSets(daemonSets map[types.UID]*appsv1.DaemonSet) []*appsv1.DaemonSet {
+	sorted := make([]*appsv1.DaemonSet, 0, len(daemonSets))
+	for _, ds := range daemonSets {
+		sorted = append(sorted, ds)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Namespace != sorted[j].Namespace {
+			return sorted[i].Namespace < sorted[j].Namespace
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// getOutdatedAdditionalDrivers returns the additional drivers of the node whose pod is not in sync
+// with their DaemonSet
+func (m *ClusterUpgradeStateManagerImpl) getOutdatedAdditionalDrivers(ctx context.Context,
+	nodeState *NodeUpgradeState) ([]*NodeDriver, error) {
+	var drivers []*NodeDriver
+	for _, driver := range nodeState.AdditionalDrivers {
+		synced, err := m.driverPodInSync(ctx, driver.Pod, driver.DaemonSet)
+		if err != nil {
+			return nil, err
+		}
+		if !synced {
+			m.Log.V(consts.LogLevelDebug).Info("Additional driver pod is outdated", "node", nodeState.Node.Name,
+				"pod", driver.Pod.Name, "daemonset", driver.DaemonSet.Name)
+			drivers = append(drivers, driver)
+		}
+	}
+	return drivers, nil
+}
+
+// areAdditionalDriversReady returns true if the pods of all the additional drivers of the node are in sync with
+// their DaemonSet, running and ready
+func (m *ClusterUpgradeStateManagerImpl) areAdditionalDriversReady(ctx context.Context,
+	nodeState *NodeUpgradeState) (bool, error) {
+	for _, driver := range nodeState.AdditionalDrivers {
+		synced, err := m.driverPodInSync(ctx, driver.Pod, driver.DaemonSet)
+		if err != nil {
+			return false, err
+		}
+		if !synced || !isPodRunningAndReady(driver.Pod) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isAnyDriverPodFailing returns true if a driver pod of the node, the primary or an additional one,
+// is failing with repeated restarts
+func (m *ClusterUpgradeStateManagerImpl) isAnyDriverPodFailing(nodeState *NodeUpgradeState) bool {
+	if m.isDriverPodFailing(nodeState.DriverPod) {
+		return true
+	}
+	for _, driver := range nodeState.AdditionalDrivers {
+		if m.isDriverPodFailing(driver.Pod) {
+			return true
+		}
+	}
+	return false
+}
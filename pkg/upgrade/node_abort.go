@@ -0,0 +1,153 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// errDrainAborted is the cause of the cancellation of an aborted drain
+var errDrainAborted = errors.New("drain aborted")
+
+// DrainCanceller is implemented by drain managers which can cancel the drain of a node
+type DrainCanceller interface {
+	// CancelNodeDrain cancels the drain of the node and waits until it stops or the context is cancelled.
+	// The node is left in its current upgrade state. It returns false if the node is not being drained.
+	CancelNodeDrain(ctx context.Context, nodeName string) bool
+}
+
+// nodeDrain is a scheduled drain of a node
+type nodeDrain struct {
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+// drainCancelTracker keeps the scheduled drains so that they can be cancelled
+type drainCancelTracker struct {
+	mutex  sync.Mutex
+	drains map[string]*nodeDrain
+}
+
+// newDrainCancelTracker creates an empty drainCancelTracker
+func newDrainCancelTracker() *drainCancelTracker {
+	return &drainCancelTracker{drains: make(map[string]*nodeDrain)}
+}
+
+// start tracks the drain of the node and returns the context of the drain, cancelled when the drain is aborted
+func (t *drainCancelTracker) start(ctx context.Context, nodeName string) context.Context {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	drainCtx, cancel := context.WithCancelCause(ctx)
+	t.drains[nodeName] = &nodeDrain{cancel: cancel, done: make(chan struct{})}
+	return drainCtx
+}
+
+// done stops tracking the drain of the node
+func (t *drainCancelTracker) done(nodeName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if drain, ok := t.drains[nodeName]; ok {
+		drain.cancel(nil)
+		close(drain.done)
+		delete(t.drains, nodeName)
+	}
+}
+
+// abort cancels the drain of the node and waits until it is done or the context is cancelled
+func (t *drainCancelTracker) abort(ctx context.Context, nodeName string) bool {
+	t.mutex.Lock()
+	drain, ok := t.drains[nodeName]
+	t.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	drain.cancel(errDrainAborted)
+	select {
+	case <-drain.done:
+	case <-ctx.Done():
+	}
+	return true
+}
+
+// isDrainAborted returns true if the context of a drain was cancelled by CancelNodeDrain
+func isDrainAborted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errDrainAborted)
+}
+
+// CancelNodeDrain cancels the drain of the node and waits until it stops or the context is cancelled.
+// The node is left in its current upgrade state. It returns false if the node is not being drained.
+func (m *DrainManagerImpl) CancelNodeDrain(ctx context.Context, nodeName string) bool {
+	return m.drainCancels.abort(ctx, nodeName)
+}
+
+// AbortNodeUpgrades aborts the upgrade of the given nodes: their pending drains are cancelled, the nodes cordoned
+// by the upgrade are uncordoned and all the labels, annotations and taints owned by the upgrade library
+// are removed, except the upgrade history, whose upgrade in progress is marked as aborted. The next ApplyState pass
+// moves the nodes to the upgrade-done state if their driver pod is up to date, or to the upgrade-required state
+// otherwise, from which their upgrade starts over unless it is held, e.g. by the skip label or by pausing
+// the upgrade policy.
+// The driver pods already deleted by the upgrade are not restored, they are recreated by their DaemonSet.
+func (m *ClusterUpgradeStateManagerImpl) AbortNodeUpgrades(ctx context.Context, nodeNames ...string) error {
+	m.Log.V(consts.LogLevelInfo).Info("Aborting node upgrades", "nodes", nodeNames)
+
+	canceller, _ := m.DrainManager.(DrainCanceller)
+	for _, nodeName := range nodeNames {
+		node := &corev1.Node{}
+		err := m.K8sClient.Get(ctx, types.NamespacedName{Name: nodeName}, node)
+		if err != nil {
+			return fmt.Errorf("error getting node %s: %v", nodeName, err)
+		}
+		if canceller != nil && canceller.CancelNodeDrain(ctx, nodeName) {
+			m.Log.V(consts.LogLevelInfo).Info("Cancelled the drain of the node", "node", nodeName)
+			// the drain may have cordoned the node meanwhile
+			err = m.K8sClient.Get(ctx, types.NamespacedName{Name: nodeName}, node)
+			if err != nil {
+				return fmt.Errorf("error getting node %s: %v", nodeName, err)
+			}
+		}
+		state := m.getNodeUpgradeState(node)
+		if m.isNodeCordonedByUpgrade(node) {
+			m.Log.V(consts.LogLevelInfo).Info("Uncordoning node cordoned by the aborted upgrade", "node", nodeName)
+			err = m.CordonManager.Uncordon(ctx, node)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Node uncordon failed", "node", nodeName)
+				return err
+			}
+		}
+		err = m.abortNodeUpgradeRecord(ctx, node)
+		if err != nil {
+			return err
+		}
+		err = m.removeLibraryOwnedKeys(ctx, node, true)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to remove node upgrade state", "node", nodeName)
+			return err
+		}
+		m.Log.V(consts.LogLevelInfo).Info("Aborted node upgrade", "node", nodeName, "state", state)
+		logEventf(m.EventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+			"Upgrade of the node aborted in the %s state", state)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// NodeLease is a lease of a manager on a node, kept as JSON in an annotation of the node and renewed periodically
+// by its holder, so that the other managers leave the node alone until the lease is released or expires
+type NodeLease struct {
+	// HolderIdentity is the identity of the manager holding the lease
+	HolderIdentity string `json:"holderIdentity"`
+	// AcquireTime is the time the lease was acquired
+	AcquireTime meta_v1.Time `json:"acquireTime"`
+	// RenewTime is the time the lease was last renewed
+	RenewTime meta_v1.Time `json:"renewTime"`
+	// LeaseDurationSeconds is the duration the lease is valid for after it is renewed
+	LeaseDurationSeconds int32 `json:"leaseDurationSeconds"`
+}
+
+// Expired returns true if the lease wasn't renewed for its duration, i.e. its holder stopped working on the node
+func (l NodeLease) Expired(now time.Time) bool {
+	return now.After(l.RenewTime.Add(time.Duration(l.LeaseDurationSeconds) * time.Second))
+}
+
+func (l *NodeLease) holderIdentity() string {
+	return l.HolderIdentity
+}
+
+// nodeLeaseHolder is implemented by the leases embedding NodeLease
+type nodeLeaseHolder interface {
+	holderIdentity() string
+}
+
+// getNodeLeaseAnnotation decodes the lease in the annotation of the node, false if the node has no annotation
+// or it isn't a valid lease
+func getNodeLeaseAnnotation(node *corev1.Node, key string, lease nodeLeaseHolder) bool {
+	value, ok := node.Annotations[key]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal([]byte(value), lease) == nil && lease.holderIdentity() != ""
+}
+
+// NodeUpgradeClaim is the claim of the operator upgrading a node, kept in the NodeUpgradeClaimAnnotationKey
+// annotation of the node from the cordon of the node to the end of its upgrade and renewed by the passes of
+// the operator, so that the other operators using the library on the same node skip it until the claim
+// is released or expires
+type NodeUpgradeClaim struct {
+	NodeLease
+	// Driver is the name of the driver upgraded by the holder, see SetDriverName
+	Driver string `json:"driver,omitempty"`
+}
+
+// GetNodeUpgradeClaim returns the upgrade claim of the node, false if the node has no valid claim annotation
+func GetNodeUpgradeClaim(node *corev1.Node) (NodeUpgradeClaim, bool) {
+	claim := NodeUpgradeClaim{}
+	if !getNodeLeaseAnnotation(node, NodeUpgradeClaimAnnotationKey, &claim) {
+		return NodeUpgradeClaim{}, false
+	}
+	return claim, true
+}
+
+// nodeClaimFreeStates are the upgrade states in which the node is not claimed, the upgrade of the node
+// has not started or is done. The claim is acquired before the node is cordoned.
+var nodeClaimFreeStates = map[string]bool{
+	UpgradeStateUnknown:         true,
+	UpgradeStateDone:            true,
+	UpgradeStateUpgradeRequired: true,
+	UpgradeStateBlockedBySkew:   true,
+}
+
+// nodeClaimConfig is the configuration of the node upgrade claims of the manager
+type nodeClaimConfig struct {
+	// holderIdentity is the identity of the manager in the claims
+	holderIdentity string
+	// duration is the duration of the claims, they are renewed every third of it
+	duration time.Duration
+
+	mutex sync.Mutex
+	// skippedNodes are the holders of the claims of the nodes skipped by the manager by node name,
+	// the event is recorded once per holder
+	skippedNodes map[string]string
+}
+
+// WithNodeClaims provides an option to share the nodes with other operators using the library, e.g. the operators
+// of different drivers installed on the same nodes: a node is claimed by the manager in the
+// NodeUpgradeClaimAnnotationKey annotation before it is cordoned, the claim is renewed every third of the lease
+// duration while the node is upgraded and released when the upgrade is done. The nodes claimed by another holder
+// whose claim hasn't expired are skipped by ApplyState and an event is recorded. holderIdentity should be stable
+// across the restarts of the operator, e.g. its name, and unique among the operators sharing the nodes. The lease
+// duration should be longer than the interval between the reconciles of the operator. An empty identity or a zero
+// duration disables the claims.
+func (m *ClusterUpgradeStateManagerImpl) WithNodeClaims(holderIdentity string,
+	leaseDuration time.Duration) ClusterUpgradeStateManager {
+	m.nodeClaims = nil
+	if holderIdentity == "" || leaseDuration <= 0 {
+		return m
+	}
+	m.nodeClaims = &nodeClaimConfig{
+		holderIdentity: holderIdentity,
+		duration:       leaseDuration,
+		skippedNodes:   make(map[string]string),
+	}
+	return m
+}
+
+// getNodeClaimHolder returns the holder of the upgrade claim of the node if it is claimed by another holder
+// and the claim hasn't expired
+func (m *ClusterUpgradeStateManagerImpl) getNodeClaimHolder(node *corev1.Node, now time.Time) (string, bool) {
+	claim, ok := GetNodeUpgradeClaim(node)
+	if !ok || claim.HolderIdentity == m.nodeClaims.holderIdentity || claim.Expired(now) {
+		return "", false
+	}
+	return claim.HolderIdentity, true
+}
+
+// applyNodeClaims acquires, renews or releases the upgrade claims of the nodes of currentState and returns
+// the state without the nodes claimed by other holders, which are skipped by the pass. A node is also skipped
+// if another holder claimed it concurrently.
+func (m *ClusterUpgradeStateManagerImpl) applyNodeClaims(ctx context.Context,
+	currentState *ClusterUpgradeState) (*ClusterUpgradeState, error) {
+	if m.nodeClaims == nil {
+		return currentState, nil
+	}
+	now := time.Now()
+	claimedState := NewClusterUpgradeState()
+	for state, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			node := nodeState.Node
+			if holder, ok := m.getNodeClaimHolder(node, now); ok {
+				m.skipClaimedNode(node, holder)
+				continue
+			}
+			err := m.updateNodeClaim(ctx, node, !nodeClaimFreeStates[state], now)
+			if apierrors.IsConflict(err) {
+				m.Log.V(consts.LogLevelInfo).Info("Node was changed while it was claimed, skipping it",
+					"node", node.Name)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to update the upgrade claim of node %s: %v", node.Name, err)
+			}
+			m.nodeClaims.mutex.Lock()
+			delete(m.nodeClaims.skippedNodes, node.Name)
+			m.nodeClaims.mutex.Unlock()
+			claimedState.NodeStates[state] = append(claimedState.NodeStates[state], nodeState)
+		}
+	}
+	return &claimedState, nil
+}
+
+// skipClaimedNode logs the skip of the node claimed by the holder, the event is recorded once per holder
+func (m *ClusterUpgradeStateManagerImpl) skipClaimedNode(node *corev1.Node, holder string) {
+	m.Log.V(consts.LogLevelInfo).Info("Node upgrade is claimed by another operator, skipping it",
+		"node", node.Name, "holder", holder)
+	m.nodeClaims.mutex.Lock()
+	defer m.nodeClaims.mutex.Unlock()
+	if m.nodeClaims.skippedNodes[node.Name] == holder {
+		return
+	}
+	m.nodeClaims.skippedNodes[node.Name] = holder
+	logEventf(m.EventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+		"Skipping the node, its upgrade is claimed by %s", holder)
+}
+
+// updateNodeClaim acquires or renews the claim of the manager on the node if claimed is true, or releases it
+// otherwise. The claim is written with an optimistic lock, so that only one of the managers claiming a node
+// concurrently gets it, the others get a conflict error. A claim of the manager is renewed when a third
+// of its duration elapsed.
+func (m *ClusterUpgradeStateManagerImpl) updateNodeClaim(ctx context.Context, node *corev1.Node, claimed bool,
+	now time.Time) error {
+	claim, hasClaim := GetNodeUpgradeClaim(node)
+	if hasClaim && claim.HolderIdentity != m.nodeClaims.holderIdentity {
+		// the claim of another holder expired, it is taken over or dropped
+		m.Log.V(consts.LogLevelInfo).Info("Upgrade claim of the node expired", "node", node.Name,
+			"holder", claim.HolderIdentity, "renewTime", claim.RenewTime)
+		hasClaim = false
+	}
+	_, hasAnnotation := node.Annotations[NodeUpgradeClaimAnnotationKey]
+	updated := node.DeepCopy()
+	switch {
+	case !claimed && !hasAnnotation:
+		return nil
+	case !claimed:
+		delete(updated.Annotations, NodeUpgradeClaimAnnotationKey)
+	case hasClaim && now.Sub(claim.RenewTime.Time) < m.nodeClaims.duration/3:
+		return nil
+	default:
+		if !hasClaim {
+			claim = NodeUpgradeClaim{
+				NodeLease: NodeLease{
+					HolderIdentity:       m.nodeClaims.holderIdentity,
+					AcquireTime:          meta_v1.NewTime(now),
+					LeaseDurationSeconds: int32(m.nodeClaims.duration.Seconds()),
+				},
+				Driver: DriverName,
+			}
+		}
+		claim.RenewTime = meta_v1.NewTime(now)
+		data, err := json.Marshal(claim)
+		if err != nil {
+			return err
+		}
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[NodeUpgradeClaimAnnotationKey] = string(data)
+	}
+	err := m.K8sClient.Patch(ctx, updated, client.MergeFromWithOptions(node, client.MergeFromWithOptimisticLock{}))
+	if err != nil {
+		return err
+	}
+	*node = *updated
+	return nil
+}
+
+// isNodeClaimedByManager returns true if the node has an upgrade claim of the manager
+func (m *ClusterUpgradeStateManagerImpl) isNodeClaimedByManager(node *corev1.Node) bool {
+	if m.nodeClaims == nil {
+		return false
+	}
+	claim, ok := GetNodeUpgradeClaim(node)
+	return ok && claim.HolderIdentity == m.nodeClaims.holderIdentity
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// defaultSkippedNodeConditions are the node conditions skipping the node if the node health selection of
+// the upgrade policy lists neither conditions nor taints
+var defaultSkippedNodeConditions = []corev1.NodeConditionType{corev1.NodeDiskPressure, corev1.NodeMemoryPressure}
+
+// getNodeHealthIssue returns the condition or the taint of the node which skips it according to the node health
+// selection, or an empty string if the node can be upgraded
+func getNodeHealthIssue(node *corev1.Node, selection *v1alpha1.NodeHealthSelectionSpec) string {
+	conditions := selection.Conditions
+	if len(conditions) == 0 && len(selection.TaintKeys) == 0 {
+		conditions = defaultSkippedNodeConditions
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue && slices.Contains(conditions, condition.Type) {
+			return fmt.Sprintf("condition %s", condition.Type)
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		if slices.Contains(selection.TaintKeys, taint.Key) {
+			return fmt.Sprintf("taint %s", taint.Key)
+		}
+	}
+	return ""
+}
+
+// skipUnhealthyNodes removes the nodes with a condition or a taint of the node health selection of the upgrade
+// policy from the upgrade-required state, if the selection is set. The removed nodes wait with the NodeUnhealthy
+// reason, an event is recorded on the nodes which were not waiting yet. Nodes marked for skipping upgrades are
+// kept.
+func (m *ClusterUpgradeStateManagerImpl) skipUnhealthyNodes(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, selection *v1alpha1.NodeHealthSelectionSpec) (
+	*ClusterUpgradeState, error) {
+	nodeStates := currentClusterState.NodeStates[UpgradeStateUpgradeRequired]
+	if selection == nil || len(nodeStates) == 0 {
+		return currentClusterState, nil
+	}
+
+	healthy := make([]*NodeUpgradeState, 0, len(nodeStates))
+	for _, nodeState := range nodeStates {
+		node := nodeState.Node
+		issue := getNodeHealthIssue(node, selection)
+		if issue == "" || m.skipNodeUpgrade(node) {
+			healthy = append(healthy, nodeState)
+			continue
+		}
+		m.Log.V(consts.LogLevelInfo).Info("Node is unhealthy, its upgrade is not started", "node", node.Name,
+			"issue", issue)
+		if m.keys.GetNodeUpgradeStateReason(node) != UpgradeStateReasonNodeUnhealthy {
+			logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+				"Node upgrade is not started, the node has the %s", issue)
+		}
+		err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonNodeUnhealthy)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to set node upgrade state reason", "node", node.Name)
+			return nil, err
+		}
+	}
+
+	healthyState := NewClusterUpgradeState()
+	for state, states := range currentClusterState.NodeStates {
+		healthyState.NodeStates[state] = states
+	}
+	healthyState.NodeStates[UpgradeStateUpgradeRequired] = healthy
+	return &healthyState, nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// NodeHook identifies the point of the node upgrade a node hook pod is run at
+type NodeHook string
+
+const (
+	// NodeHookPreUpgrade runs after the node is drained, before the driver pod is restarted
+	NodeHookPreUpgrade NodeHook = "pre-upgrade"
+	// NodeHookPostUpgrade runs after the restarted driver pod is ready, before the node is validated or uncordoned
+	NodeHookPostUpgrade NodeHook = "post-upgrade"
+)
+
+// WithNodeHookPods provides an option to run a pod from the given template on every node before the driver pod
+// restart and after the restarted driver pod is ready, e.g. to unload kernel modules or flush the device state.
+// The node advances only when the hook pod succeeds, it is moved to the upgrade-failed state if the pod fails.
+// Nil templates are not run.
+func (m *ClusterUpgradeStateManagerImpl) WithNodeHookPods(
+	preUpgrade, postUpgrade *corev1.PodTemplateSpec) ClusterUpgradeStateManager {
+	m.nodeHookPods = map[NodeHook]*corev1.PodTemplateSpec{
+		NodeHookPreUpgrade:  preUpgrade,
+		NodeHookPostUpgrade: postUpgrade,
+	}
+	return m
+}
+
+// getNodeHookPodName returns the name of the hook pod of the node
+func getNodeHookPodName(node *corev1.Node, hook NodeHook) string {
+	return fmt.Sprintf("%s-driver-upgrade-%s-%s", DriverName, hook, node.Name)
+}
+
+// RunNodeHookPod creates the hook pod from the template on the node if it doesn't exist yet and returns its phase.
+// The pod is bound to the node and pinned to it with PinPodSpecToNode, so that it runs on the cordoned node,
+// in the namespace of the template.
+// A completed hook pod is deleted once its phase is returned, so that the hook runs again on the next upgrade.
+func (m *PodManagerImpl) RunNodeHookPod(ctx context.Context, node *corev1.Node, hook NodeHook,
+	template *corev1.PodTemplateSpec) (corev1.PodPhase, error) {
+	if template.Namespace == "" {
+		return "", fmt.Errorf("namespace of the %s node hook pod template is not set", hook)
+	}
+	name := getNodeHookPodName(node, hook)
+	pods := m.k8sInterface.CoreV1().Pods(template.Namespace)
+	pod, err := pods.Get(ctx, name, meta_v1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		pod = &corev1.Pod{ObjectMeta: *template.ObjectMeta.DeepCopy(), Spec: *template.Spec.DeepCopy()}
+		pod.Name = name
+		pod.GenerateName = ""
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[m.keys.GetUpgradeNodeHookLabelKey()] = string(hook)
+		pod.Spec.NodeName = node.Name
+		m.keys.PinPodSpecToNode(&pod.Spec, node.Name)
+		if pod.Spec.RestartPolicy == "" || pod.Spec.RestartPolicy == corev1.RestartPolicyAlways {
+			pod.Spec.RestartPolicy = corev1.RestartPolicyNever
+		}
+		m.log.V(consts.LogLevelInfo).Info("Creating node hook pod", "node", node.Name, "hook", hook, "pod", name)
+		_, err = pods.Create(ctx, pod, meta_v1.CreateOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to create %s node hook pod on node %s: %v", hook, node.Name, err)
+		}
+		return corev1.PodPending, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s node hook pod on node %s: %v", hook, node.Name, err)
+	}
+
+	phase := pod.Status.Phase
+	if phase == "" {
+		// the phase is not reported yet
+		phase = corev1.PodPending
+	}
+	if phase == corev1.PodSucceeded || phase == corev1.PodFailed {
+		m.log.V(consts.LogLevelInfo).Info("Node hook pod completed", "node", node.Name, "hook", hook,
+			"pod", name, "phase", phase)
+		err = pods.Delete(ctx, name, meta_v1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to delete %s node hook pod on node %s: %v", hook, node.Name, err)
+		}
+	}
+	return phase, nil
+}
+
+// runNodeHook runs the hook pod of the node, if a template is set for the hook, and returns true once it succeeded.
+// The node is moved to the upgrade-failed state with the NodeHookFailed reason if the hook pod failed.
+func (m *ClusterUpgradeStateManagerImpl) runNodeHook(ctx context.Context, node *corev1.Node,
+	hook NodeHook) (bool, error) {
+	template := m.nodeHookPods[hook]
+	if template == nil {
+		return true, nil
+	}
+	phase, err := m.PodManager.RunNodeHookPod(ctx, node, hook, template)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to run node hook pod", "node", node.Name, "hook", hook)
+		return false, err
+	}
+	switch phase {
+	case corev1.PodSucceeded:
+		return true, nil
+	case corev1.PodFailed:
+	default:
+		m.Log.V(consts.LogLevelInfo).Info("Waiting for node hook pod to complete", "node", node.Name,
+			"hook", hook, "phase", phase)
+		return false, nil
+	}
+
+	m.Log.V(consts.LogLevelInfo).Info("Node hook pod failed", "node", node.Name, "hook", hook)
+	logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+		"The %s node hook pod failed, moving the node to %s state", hook, UpgradeStateFailed)
+	err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "node", node.Name, "state", UpgradeStateFailed)
+		return false, err
+	}
+	return false, m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonNodeHookFailed)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// NodeUpgradeImpact is the expected impact of the driver upgrade of a node, annotated on the nodes waiting
+// in the upgrade-required state so that external systems and humans can decide to skip or re-order the upgrade
+type NodeUpgradeImpact struct {
+	// RunningPods is the count of pods running or pending on the node
+	RunningPods int `json:"runningPods"`
+	// WorkloadPods is the count of running pods selected by the PodDeletionFilter, i.e. the pods using the driver,
+	// 0 if pod deletion is not enabled
+	WorkloadPods int `json:"workloadPods"`
+	// ExpectedPodRestarts is the count of pods expected to be restarted by the upgrade: the driver pod and the pods
+	// deleted by the pod deletion or, if pod deletion is not enabled, evicted by the drain
+	ExpectedPodRestarts int `json:"expectedPodRestarts"`
+}
+
+// WithUpgradeImpactAnnotation provides an option to annotate the nodes in the upgrade-required state with
+// the expected impact of their upgrade, see NodeUpgradeImpact. The pods of these nodes are listed on every
+// ApplyState pass to keep the annotation up to date.
+func (m *ClusterUpgradeStateManagerImpl) WithUpgradeImpactAnnotation(enabled bool) ClusterUpgradeStateManager {
+	m.upgradeImpactAnnotationEnabled = enabled
+	return m
+}
+
+// GetNodeUpgradeImpact returns the expected impact of the upgrade annotated on the node, or nil if the node
+// has no impact annotation
+func (k UpgradeKeys) GetNodeUpgradeImpact(node *corev1.Node) (*NodeUpgradeImpact, error) {
+	value, ok := node.Annotations[k.GetUpgradeImpactAnnotationKey()]
+	if !ok {
+		return nil, nil
+	}
+	impact := &NodeUpgradeImpact{}
+	if err := json.Unmarshal([]byte(value), impact); err != nil {
+		return nil, fmt.Errorf("invalid upgrade impact annotation of node %s: %v", node.Name, err)
+	}
+	return impact, nil
+}
+
+// GetNodeUpgradeImpact returns UpgradeKeys.GetNodeUpgradeImpact with the default key prefix
+func GetNodeUpgradeImpact(node *corev1.Node) (*NodeUpgradeImpact, error) {
+	return UpgradeKeys{}.GetNodeUpgradeImpact(node)
+}
+
+// annotateUpgradeImpact updates the upgrade impact annotation of the nodes in the upgrade-required state.
+// Failures are logged only, as the annotation is informational.
+func (m *ClusterUpgradeStateManagerImpl) annotateUpgradeImpact(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) {
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateUpgradeRequired] {
+		node := nodeState.Node
+		impact, err := m.getNodeUpgradeImpact(ctx, nodeState, upgradePolicy)
+		if err != nil {
+			m.Log.V(consts.LogLevelWarning).Info("Failed to compute node upgrade impact", "node", node.Name,
+				"error", err.Error())
+			continue
+		}
+		value, err := json.Marshal(impact)
+		if err != nil {
+			m.Log.V(consts.LogLevelWarning).Info("Failed to marshal node upgrade impact", "node", node.Name,
+				"error", err.Error())
+			continue
+		}
+		annotationKey := m.keys.GetUpgradeImpactAnnotationKey()
+		if node.Annotations[annotationKey] == string(value) {
+			continue
+		}
+		err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, string(value))
+		if err != nil {
+			m.Log.V(consts.LogLevelWarning).Info("Failed to annotate node upgrade impact", "node", node.Name,
+				"error", err.Error())
+		}
+	}
+}
+
+// getNodeUpgradeImpact computes the expected impact of the upgrade of the node from its pods
+func (m *ClusterUpgradeStateManagerImpl) getNodeUpgradeImpact(ctx context.Context, nodeState *NodeUpgradeState,
+	upgradePolicy *v1alpha1.DriverUpgradePolicySpec) (*NodeUpgradeImpact, error) {
+	node := nodeState.Node
+	pods, err := m.K8sInterface.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf(nodeNameFieldSelectorFmt, node.Name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods of node %s: %v", node.Name, err)
+	}
+	drainSelector := labels.Everything()
+	if isDrainEnabled(upgradePolicy) {
+		drainSelector, err = labels.Parse(upgradePolicy.DrainSpec.PodSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid drain pod selector: %v", err)
+		}
+	}
+
+	impact := &NodeUpgradeImpact{}
+	evictedPods := 0
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != node.Name || pod.Status.Phase == corev1.PodSucceeded ||
+			pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		impact.RunningPods++
+		if isStaticPod(pod) || isPodInProtectedNamespace(pod, m.protectedNamespaces) {
+			// static pods and pods in protected namespaces are never deleted nor evicted
+			continue
+		}
+		if m.IsPodDeletionEnabled() {
+			if m.PodManager.GetPodDeletionFilter()(pod) {
+				impact.WorkloadPods++
+			}
+		} else if isDrainEnabled(upgradePolicy) && !isDaemonSetPod(pod) && drainSelector.Matches(labels.Set(pod.Labels)) &&
+			!isPodAbovePriorityThreshold(pod, upgradePolicy.DrainSpec.PriorityThreshold) {
+			evictedPods++
+		}
+	}
+	impact.ExpectedPodRestarts = impact.WorkloadPods + evictedPods
+	if nodeState.DriverPod != nil {
+		impact.ExpectedPodRestarts++
+	}
+	return impact, nil
+}
+
+// isDaemonSetPod returns true if the pod is managed by a DaemonSet, such pods are not evicted by the drain
+func isDaemonSetPod(pod corev1.Pod) bool {
+	controllerRef := metav1.GetControllerOf(&pod)
+	return controllerRef != nil && controllerRef.Kind == "DaemonSet"
+}
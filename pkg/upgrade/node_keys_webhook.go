@@ -0,0 +1,143 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// NodeUpgradeKeysProtector implements admission.Handler, it rejects the node writes changing the labels,
+// annotations or the upgrade state taint owned by the upgrade library, unless they are made by one of the allowed
+// users, e.g. the service account of the operator. This prevents other tooling, e.g. a label sync or a GitOps
+// controller, from corrupting the upgrade state of the nodes. The annotations set by the cluster admin,
+// the upgrade request and the downgrade approval, are not protected.
+//
+// The handler is served by the webhook server of the controller-runtime manager of the operator:
+//
+//	mgr.GetWebhookServer().Register("/validate-upgrade-node-keys", &admission.Webhook{Handler: protector})
+//
+// The webhook server serves TLS with the tls.crt and tls.key files of its CertDir, by default
+// <temp-dir>/k8s-webhook-server/serving-certs, usually a mounted secret issued by cert-manager for the webhook
+// service. The caBundle of the ValidatingWebhookConfiguration must contain the CA of the certificate, cert-manager
+// injects it when the configuration has the cert-manager.io/inject-ca-from annotation. The configuration should
+// only match the UPDATE and CREATE operations on the "nodes" resource, not "nodes/status" which the kubelet updates
+// all the time, and use the Ignore failure policy so that the nodes can still be updated while the operator is down.
+type NodeUpgradeKeysProtector struct {
+	allowedUsers []string
+	keys         UpgradeKeys
+}
+
+// NewNodeUpgradeKeysProtector creates a NodeUpgradeKeysProtector which allows the service account of the operator
+// with the given namespace and name to change the library-owned keys
+func NewNodeUpgradeKeysProtector(serviceAccountNamespace, serviceAccountName string) *NodeUpgradeKeysProtector {
+	return &NodeUpgradeKeysProtector{
+		allowedUsers: []string{fmt.Sprintf("system:serviceaccount:%s:%s", serviceAccountNamespace, serviceAccountName)},
+	}
+}
+
+// WithAllowedUsers allows the users with the given names to change the library-owned keys as well,
+// e.g. a break-glass admin user
+func (p *NodeUpgradeKeysProtector) WithAllowedUsers(users ...string) *NodeUpgradeKeysProtector {
+	p.allowedUsers = append(p.allowedUsers, users...)
+	return p
+}
+
+// WithKeyPrefix protects the keys with the given prefix instead of DefaultKeyPrefix,
+// see ClusterUpgradeStateManager.WithKeyPrefix
+func (p *NodeUpgradeKeysProtector) WithKeyPrefix(prefix string) *NodeUpgradeKeysProtector {
+	p.keys = NewUpgradeKeys(prefix)
+	return p
+}
+
+// Handle implements admission.Handler
+func (p *NodeUpgradeKeysProtector) Handle(_ context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	if slices.Contains(p.allowedUsers, req.UserInfo.Username) {
+		return admission.Allowed("")
+	}
+
+	node := &corev1.Node{}
+	if err := json.Unmarshal(req.Object.Raw, node); err != nil {
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode node: %v", err))
+	}
+	oldNode := &corev1.Node{}
+	if len(req.OldObject.Raw) > 0 {
+		if err := json.Unmarshal(req.OldObject.Raw, oldNode); err != nil {
+			return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode old node: %v", err))
+		}
+	}
+
+	changedKeys := p.keys.getChangedUpgradeKeys(oldNode, node)
+	if len(changedKeys) == 0 {
+		return admission.Allowed("")
+	}
+	return admission.Denied(fmt.Sprintf("%s are owned by the %s driver upgrade and can only be changed by the operator",
+		strings.Join(changedKeys, ", "), DriverName))
+}
+
+// getChangedUpgradeKeys returns the library-owned keys whose value differs between the old and the new node,
+// except the annotations set by the cluster admin
+func (k UpgradeKeys) getChangedUpgradeKeys(oldNode, node *corev1.Node) []string {
+	changedKeys := make([]string, 0)
+	for _, key := range k.getLibraryOwnedLabelKeys() {
+		if isMapValueChanged(oldNode.Labels, node.Labels, key) {
+			changedKeys = append(changedKeys, "label "+key)
+		}
+	}
+	adminKeys := []string{k.GetUpgradeRequestedAnnotationKey(), k.GetUpgradeDowngradeApprovedAnnotationKey(),
+		k.GetUpgradeDrainApprovedAnnotationKey()}
+	for _, key := range k.getLibraryOwnedAnnotationKeys() {
+		if !slices.Contains(adminKeys, key) && isMapValueChanged(oldNode.Annotations, node.Annotations, key) {
+			changedKeys = append(changedKeys, "annotation "+key)
+		}
+	}
+	taintKey := k.GetUpgradeStateTaintKey()
+	if findTaint(oldNode.Spec.Taints, taintKey) != findTaint(node.Spec.Taints, taintKey) {
+		changedKeys = append(changedKeys, "taint "+taintKey)
+	}
+	return changedKeys
+}
+
+// isMapValueChanged returns true if the key was added, removed or changed between the old and the new map
+func isMapValueChanged(oldMap, newMap map[string]string, key string) bool {
+	oldValue, oldPresent := oldMap[key]
+	newValue, newPresent := newMap[key]
+	return oldPresent != newPresent || oldValue != newValue
+}
+
+// findTaint returns the taint with the key, or an empty taint if there is none
+func findTaint(taints []corev1.Taint, key string) corev1.Taint {
+	for _, taint := range taints {
+		if taint.Key == key {
+			return corev1.Taint{Key: taint.Key, Value: taint.Value, Effect: taint.Effect}
+		}
+	}
+	return corev1.Taint{}
+}
+
+var _ admission.Handler = &NodeUpgradeKeysProtector{}
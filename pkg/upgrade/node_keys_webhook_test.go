@@ -0,0 +1,98 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade_test
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
+)
+
+var _ = Describe("NodeUpgradeKeysProtector", func() {
+	var protector *upgrade.NodeUpgradeKeysProtector
+	var oldNode *corev1.Node
+
+	BeforeEach(func() {
+		protector = upgrade.NewNodeUpgradeKeysProtector("operator-ns", "operator")
+		oldNode = NewNode("node").WithUpgradeState(upgrade.UpgradeStateDrainRequired).Node
+	})
+
+	nodeUpdate := func(username string, oldNode, node *corev1.Node) admission.Request {
+		oldRaw, err := json.Marshal(oldNode)
+		Expect(err).NotTo(HaveOccurred())
+		raw, err := json.Marshal(node)
+		Expect(err).NotTo(HaveOccurred())
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			UserInfo:  authenticationv1.UserInfo{Username: username},
+			Object:    runtime.RawExtension{Raw: raw},
+			OldObject: runtime.RawExtension{Raw: oldRaw},
+		}}
+	}
+
+	It("should reject changes of the upgrade state by other users", func() {
+		node := oldNode.DeepCopy()
+		node.Labels[upgrade.GetUpgradeStateLabelKey()] = upgrade.UpgradeStateDone
+
+		response := protector.Handle(context.TODO(), nodeUpdate("kubernetes-admin", oldNode, node))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Message).To(ContainSubstring(upgrade.GetUpgradeStateLabelKey()))
+
+		delete(node.Labels, upgrade.GetUpgradeStateLabelKey())
+		Expect(protector.Handle(context.TODO(), nodeUpdate("kubernetes-admin", oldNode, node)).Allowed).To(BeFalse())
+	})
+
+	It("should allow changes of the upgrade state by the operator and the allowed users", func() {
+		node := oldNode.DeepCopy()
+		node.Labels[upgrade.GetUpgradeStateLabelKey()] = upgrade.UpgradeStateDone
+
+		request := nodeUpdate("system:serviceaccount:operator-ns:operator", oldNode, node)
+		Expect(protector.Handle(context.TODO(), request).Allowed).To(BeTrue())
+
+		protector.WithAllowedUsers("break-glass")
+		Expect(protector.Handle(context.TODO(), nodeUpdate("break-glass", oldNode, node)).Allowed).To(BeTrue())
+	})
+
+	It("should allow other changes and the annotations set by the cluster admin", func() {
+		node := oldNode.DeepCopy()
+		node.Labels["example.com/team"] = "ml"
+		node.Spec.Unschedulable = true
+		node.Annotations = map[string]string{upgrade.GetUpgradeDowngradeApprovedAnnotationKey(): "true"}
+
+		Expect(protector.Handle(context.TODO(), nodeUpdate("kubernetes-admin", oldNode, node)).Allowed).To(BeTrue())
+	})
+
+	It("should reject changes of the upgrade state taint by other users", func() {
+		node := oldNode.DeepCopy()
+		node.Spec.Taints = []corev1.Taint{{
+			Key:    upgrade.GetUpgradeStateTaintKey(),
+			Value:  upgrade.UpgradeStateDrainRequired,
+			Effect: corev1.TaintEffectNoSchedule,
+		}}
+
+		Expect(protector.Handle(context.TODO(), nodeUpdate("kubernetes-admin", oldNode, node)).Allowed).To(BeFalse())
+	})
+})
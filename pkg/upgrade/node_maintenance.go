@@ -0,0 +1,197 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// NodeMaintenanceConfig describes the NodeMaintenance custom resources requesting the maintenance of the upgraded
+// nodes from a maintenance operator, see WithNodeMaintenance
+type NodeMaintenanceConfig struct {
+	// GroupVersionKind is the kind of the NodeMaintenance resources
+	GroupVersionKind schema.GroupVersionKind
+	// Namespace is the namespace of the NodeMaintenance resources, empty if they are cluster-scoped
+	Namespace string
+	// Spec returns the spec of the NodeMaintenance of the node. upgradePolicy is nil when the cordon-required
+	// nodes are processed without upgrade policy, see ProcessCordonRequiredNodes.
+	Spec func(node *corev1.Node, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) map[string]interface{}
+	// IsReady returns true once the maintenance operator made the node ready for the maintenance,
+	// i.e. the node is cordoned and drained
+	IsReady func(maintenance *unstructured.Unstructured) bool
+}
+
+// NewMaintenanceOperatorConfig returns the NodeMaintenanceConfig of the NVIDIA maintenance operator: the
+// maintenance.nvidia.com/v1alpha1 NodeMaintenance resources are created in the namespace with the requestor ID,
+// the wait for completion and the drain of the upgrade policy, with the drain overrides of the node annotations.
+// The node is ready once the Ready condition of the NodeMaintenance is True.
+func (k UpgradeKeys) NewMaintenanceOperatorConfig(namespace, requestorID string) *NodeMaintenanceConfig {
+	return &NodeMaintenanceConfig{
+		GroupVersionKind: schema.GroupVersionKind{Group: "maintenance.nvidia.com", Version: "v1alpha1",
+			Kind: "NodeMaintenance"},
+		Namespace: namespace,
+		Spec: func(node *corev1.Node, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) map[string]interface{} {
+			spec := map[string]interface{}{
+				"requestorID": requestorID,
+				"nodeName":    node.Name,
+				"cordon":      true,
+			}
+			if upgradePolicy == nil {
+				return spec
+			}
+			if waitForCompletion := upgradePolicy.WaitForCompletion; waitForCompletion != nil {
+				spec["waitForPodCompletion"] = map[string]interface{}{
+					"podSelector":    waitForCompletion.PodSelector,
+					"timeoutSeconds": int64(waitForCompletion.TimeoutSecond),
+				}
+			}
+			if drain := upgradePolicy.DrainSpec; drain != nil && drain.Enable {
+				// the invalid drain annotations of the node are ignored
+				drain, _ = k.getNodeDrainSpec(node, drain)
+				spec["drainSpec"] = map[string]interface{}{
+					"force":          drain.Force,
+					"podSelector":    drain.PodSelector,
+					"timeoutSeconds": int64(drain.TimeoutSecond),
+					"deleteEmptyDir": drain.DeleteEmptyDir,
+				}
+			}
+			return spec
+		},
+		IsReady: func(maintenance *unstructured.Unstructured) bool {
+			conditions, _, _ := unstructured.NestedSlice(maintenance.Object, "status", "conditions")
+			for _, condition := range conditions {
+				condition, ok := condition.(map[string]interface{})
+				if ok && condition["type"] == "Ready" && condition["status"] == string(corev1.ConditionTrue) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// NewMaintenanceOperatorConfig returns UpgradeKeys.NewMaintenanceOperatorConfig with the default key prefix
+func NewMaintenanceOperatorConfig(namespace, requestorID string) *NodeMaintenanceConfig {
+	return UpgradeKeys{}.NewMaintenanceOperatorConfig(namespace, requestorID)
+}
+
+// NewMedik8sNodeMaintenanceConfig returns the NodeMaintenanceConfig of the kubevirt-style node maintenance operator
+// of medik8s: the cluster-scoped nodemaintenance.medik8s.io/v1beta1 NodeMaintenance resources are created with
+// the reason, the operator cordons and drains the node. The node is ready once the phase of the NodeMaintenance
+// is Succeeded.
+func NewMedik8sNodeMaintenanceConfig(reason string) *NodeMaintenanceConfig {
+	return &NodeMaintenanceConfig{
+		GroupVersionKind: schema.GroupVersionKind{Group: "nodemaintenance.medik8s.io", Version: "v1beta1",
+			Kind: "NodeMaintenance"},
+		Spec: func(node *corev1.Node, _ *v1alpha1.DriverUpgradePolicySpec) map[string]interface{} {
+			return map[string]interface{}{"nodeName": node.Name, "reason": reason}
+		},
+		IsReady: func(maintenance *unstructured.Unstructured) bool {
+			phase, _, _ := unstructured.NestedString(maintenance.Object, "status", "phase")
+			return phase == "Succeeded"
+		},
+	}
+}
+
+// WithNodeMaintenance provides an option to delegate the cordon and the drain of the upgraded nodes to
+// a maintenance operator: a NodeMaintenance resource is created for every node in the cordon-required state,
+// the node is moved to the pod-restart-required state once the resource is ready, skipping the wait for jobs,
+// pod deletion and drain states, and the resource is deleted to uncordon the node.
+func (m *ClusterUpgradeStateManagerImpl) WithNodeMaintenance(config *NodeMaintenanceConfig) ClusterUpgradeStateManager {
+	if config != nil && (config.Spec == nil || config.IsReady == nil) {
+		m.Log.V(consts.LogLevelWarning).Info("Cannot enable node maintenance as the config is incomplete")
+		return m
+	}
+	m.nodeMaintenance = config
+	return m
+}
+
+// getNodeMaintenanceName returns the name of the NodeMaintenance resource of the node
+func getNodeMaintenanceName(node *corev1.Node) string {
+	return fmt.Sprintf("%s-driver-upgrade-%s", DriverName, node.Name)
+}
+
+// newNodeMaintenance returns an empty NodeMaintenance resource of the node
+func (m *ClusterUpgradeStateManagerImpl) newNodeMaintenance(node *corev1.Node) *unstructured.Unstructured {
+	maintenance := &unstructured.Unstructured{}
+	maintenance.SetGroupVersionKind(m.nodeMaintenance.GroupVersionKind)
+	maintenance.SetNamespace(m.nodeMaintenance.Namespace)
+	maintenance.SetName(getNodeMaintenanceName(node))
+	return maintenance
+}
+
+// processNodeMaintenance requests the maintenance of a node in the cordon-required state and moves it to
+// the pod-restart-required state once the NodeMaintenance is ready
+func (m *ClusterUpgradeStateManagerImpl) processNodeMaintenance(ctx context.Context, node *corev1.Node,
+	upgradePolicy *v1alpha1.DriverUpgradePolicySpec) error {
+	maintenance := m.newNodeMaintenance(node)
+	err := m.K8sClient.Get(ctx, types.NamespacedName{Namespace: maintenance.GetNamespace(),
+		Name: maintenance.GetName()}, maintenance)
+	if k8serrors.IsNotFound(err) {
+		maintenance.Object["spec"] = m.nodeMaintenance.Spec(node, upgradePolicy)
+		err = m.K8sClient.Create(ctx, maintenance)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to create node maintenance", "node", node.Name)
+			return err
+		}
+		logEventf(m.EventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+			"Requested the maintenance of the node for the driver upgrade with %s %s",
+			m.nodeMaintenance.GroupVersionKind.Kind, maintenance.GetName())
+		m.applyResultRecorder.record(UpgradeActionNodeMaintenance, node)
+		return m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node,
+			UpgradeStateReasonWaitingForNodeMaintenance)
+	}
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to get node maintenance", "node", node.Name)
+		return err
+	}
+	if !m.nodeMaintenance.IsReady(maintenance) {
+		m.Log.V(consts.LogLevelInfo).Info("Waiting for the node maintenance", "node", node.Name,
+			"maintenance", maintenance.GetName())
+		return m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node,
+			UpgradeStateReasonWaitingForNodeMaintenance)
+	}
+	logEvent(m.EventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+		"The node is ready for the driver upgrade")
+	err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStatePodRestartRequired)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "state", UpgradeStatePodRestartRequired)
+	}
+	return err
+}
+
+// deleteNodeMaintenance deletes the NodeMaintenance resource of the node, so that the maintenance operator
+// uncordons the node
+func (m *ClusterUpgradeStateManagerImpl) deleteNodeMaintenance(ctx context.Context, node *corev1.Node) error {
+	err := m.K8sClient.Delete(ctx, m.newNodeMaintenance(node))
+	if err != nil && !k8serrors.IsNotFound(err) {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to delete node maintenance", "node", node.Name)
+		return err
+	}
+	return nil
+}
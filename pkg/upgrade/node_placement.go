@@ -0,0 +1,79 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// nodeNameField is the node field matched by the node affinity of the pods pinned to a node
+const nodeNameField = "metadata.name"
+
+// PinPodSpecToNode schedules the pods of the pod spec onto the node with the given name only, so that a helper
+// workload run for a node being upgraded, e.g. a validation pod or the pod of a pre or post upgrade hook Job,
+// runs on that node although it is cordoned:
+//   - a required node affinity term matching the name of the node is added to the node affinity of the spec,
+//     the other required terms are restricted to the node as well
+//   - the tolerations of the cordon taint and of the upgrade state taint, see GetUpgradeStateToleration,
+//     are added if missing
+//
+// The pods are still placed by the scheduler, unlike pods with a node name, so that the resources of the node
+// are accounted for.
+func (k UpgradeKeys) PinPodSpecToNode(podSpec *corev1.PodSpec, nodeName string) {
+	if podSpec == nil {
+		return
+	}
+	nodeRequirement := corev1.NodeSelectorRequirement{
+		Key:      nodeNameField,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{nodeName},
+	}
+	if podSpec.Affinity == nil {
+		podSpec.Affinity = &corev1.Affinity{}
+	}
+	if podSpec.Affinity.NodeAffinity == nil {
+		podSpec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	nodeAffinity := podSpec.Affinity.NodeAffinity
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	required := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	// the terms are ORed, the node must be required by every one of them
+	for i := range required.NodeSelectorTerms {
+		term := &required.NodeSelectorTerms[i]
+		term.MatchFields = append(term.MatchFields, nodeRequirement)
+	}
+
+	tolerations := []corev1.Toleration{
+		{Key: corev1.TaintNodeUnschedulable, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+		k.GetUpgradeStateToleration(),
+	}
+	for i := range tolerations {
+		if !hasToleration(podSpec.Tolerations, &tolerations[i]) {
+			podSpec.Tolerations = append(podSpec.Tolerations, tolerations[i])
+		}
+	}
+}
+
+// PinPodSpecToNode returns UpgradeKeys.PinPodSpecToNode with the default key prefix
+func PinPodSpecToNode(podSpec *corev1.PodSpec, nodeName string) {
+	UpgradeKeys{}.PinPodSpecToNode(podSpec, nodeName)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// defaultNodePool is the name of the node pool of the nodes not matching any NodePoolUpgradePolicy
+const defaultNodePool = "default"
+
+// NodePoolUpgradePolicy is the upgrade policy of a group of nodes selected by labels
+type NodePoolUpgradePolicy struct {
+	// Name is the name of the node pool, it must be unique
+	Name string
+	// NodeSelector is the label selector of the nodes of the pool
+	NodeSelector string
+	// Policy is the upgrade policy of the nodes of the pool
+	Policy *v1alpha1.DriverUpgradePolicySpec
+}
+
+// ApplyStateForNodePools processes the complete cluster upgrade state like ApplyState, with a separate upgrade policy
+// for every node pool. A node belongs to the first pool whose selector matches its labels, nodes not matching any
+// pool use defaultPolicy. The upgrade limits, e.g. MaxParallelUpgrades and MaxUnavailable, apply to every pool
+// separately. A failure in a pool doesn't prevent the processing of the other pools, the errors of all the pools
+// are returned.
+func (m *ClusterUpgradeStateManagerImpl) ApplyStateForNodePools(ctx context.Context,
+	currentState *ClusterUpgradeState, defaultPolicy *v1alpha1.DriverUpgradePolicySpec,
+	pools []NodePoolUpgradePolicy) error {
+	if currentState == nil {
+		return fmt.Errorf("currentState should not be empty")
+	}
+	selectors := make([]labels.Selector, 0, len(pools))
+	policies := map[string]*v1alpha1.DriverUpgradePolicySpec{defaultNodePool: defaultPolicy}
+	for _, pool := range pools {
+		if _, ok := policies[pool.Name]; ok || pool.Name == "" {
+			return fmt.Errorf("invalid or duplicate node pool name %q", pool.Name)
+		}
+		selector, err := labels.Parse(pool.NodeSelector)
+		if err != nil {
+			return fmt.Errorf("invalid node selector of node pool %s: %v", pool.Name, err)
+		}
+		selectors = append(selectors, selector)
+		policies[pool.Name] = pool.Policy
+	}
+
+	poolStates := make(map[string]*ClusterUpgradeState)
+	for state, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			poolName := defaultNodePool
+			for i, selector := range selectors {
+				if selector.Matches(labels.Set(nodeState.Node.Labels)) {
+					poolName = pools[i].Name
+					break
+				}
+			}
+			poolState, ok := poolStates[poolName]
+			if !ok {
+				newState := NewClusterUpgradeState()
+				poolState = &newState
+				poolStates[poolName] = poolState
+			}
+			poolState.NodeStates[state] = append(poolState.NodeStates[state], nodeState)
+		}
+	}
+
+	// The status, the metrics and the upgrade session are updated once for all the pools
+	m.upgradeCapacity.reset()
+	defer func() {
+		m.stateMetrics.update(currentState, m.stateStorage)
+		m.updateUpgradeSession(ctx, currentState, "")
+		if statusErr := m.updateStatusConfigMap(ctx, currentState); statusErr != nil {
+			m.Log.V(consts.LogLevelError).Error(statusErr, "Failed to update upgrade status ConfigMap")
+		}
+	}()
+	var errs []error
+	for _, poolName := range append([]string{defaultNodePool}, nodePoolNames(pools)...) {
+		poolState, ok := poolStates[poolName]
+		if !ok {
+			continue
+		}
+		if err := m.applyState(ctx, poolState, policies[poolName], poolName); err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to apply state of the node pool", "pool", poolName)
+			errs = append(errs, fmt.Errorf("node pool %s: %v", poolName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// nodePoolNames returns the names of the node pools
+func nodePoolNames(pools []NodePoolUpgradePolicy) []string {
+	names := make([]string, 0, len(pools))
+	for _, pool := range pools {
+		names = append(names, pool.Name)
+	}
+	return names
+}
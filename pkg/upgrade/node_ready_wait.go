@@ -0,0 +1,90 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// handleNodeReadyWait tracks how long the node is waiting to become Ready after the driver pod restart
+// and moves the node to the UpgradeStateFailed state once timeoutSeconds is exceeded. Zero timeout means infinite.
+func (m *ClusterUpgradeStateManagerImpl) handleNodeReadyWait(ctx context.Context, node *corev1.Node,
+	timeoutSeconds int) error {
+	err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonWaitingForNodeReady)
+	if err != nil {
+		return err
+	}
+
+	annotationKey := m.keys.GetNodeReadyWaitStartTimeAnnotationKey()
+	currentTime := time.Now().Unix()
+	if _, present := node.Annotations[annotationKey]; !present {
+		// add the annotation to track start time
+		err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey,
+			strconv.FormatInt(currentTime, 10))
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to add annotation to track node Ready wait",
+				"node", node.Name, "annotation", annotationKey)
+			return err
+		}
+		return nil
+	}
+	if timeoutSeconds == 0 {
+		return nil
+	}
+	startTime, err := strconv.ParseInt(node.Annotations[annotationKey], 10, 64)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to convert start time to track node Ready wait",
+			"node", node.Name)
+		return err
+	}
+	if currentTime <= startTime+int64(timeoutSeconds) {
+		return nil
+	}
+
+	// timeout exceeded, mark node in failed state
+	m.Log.V(consts.LogLevelInfo).Info("Timeout exceeded waiting for the node to become Ready", "node", node.Name,
+		"timeoutSeconds", timeoutSeconds)
+	logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+		"Node did not become Ready within %d seconds after the driver pod restart", timeoutSeconds)
+	err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "node", node.Name, "state", UpgradeStateFailed)
+		return err
+	}
+	return m.clearNodeReadyWait(ctx, node)
+}
+
+// clearNodeReadyWait removes the annotation used to track the start time of waiting on the node to become Ready
+func (m *ClusterUpgradeStateManagerImpl) clearNodeReadyWait(ctx context.Context, node *corev1.Node) error {
+	annotationKey := m.keys.GetNodeReadyWaitStartTimeAnnotationKey()
+	if _, present := node.Annotations[annotationKey]; !present {
+		return nil
+	}
+	err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, nullString)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to remove annotation used to track node Ready wait",
+			"node", node.Name, "annotation", annotationKey)
+	}
+	return err
+}
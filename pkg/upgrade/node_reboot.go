@@ -0,0 +1,308 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// RebootRequiredFunc decides whether a node in the pod-restart-required state must be rebooted before its driver
+// pods are restarted, e.g. because the new driver can't be loaded while the old one is in use
+type RebootRequiredFunc func(ctx context.Context, nodeState *NodeUpgradeState) (bool, error)
+
+// NodeRebooter reboots the nodes in the reboot-required state
+type NodeRebooter interface {
+	// Reboot requests the reboot of the node. It is called again if the request fails, and must not fail
+	// if the reboot was already requested.
+	Reboot(ctx context.Context, node *corev1.Node) error
+	// Cleanup removes the reboot resources of the node, if any, once the node is back or the reboot timed out
+	Cleanup(ctx context.Context, node *corev1.Node) error
+}
+
+// nodeReboot is the configuration of the reboot of the upgraded nodes
+type nodeReboot struct {
+	rebooter NodeRebooter
+	// rebootRequired decides which nodes are rebooted, all of them if nil
+	rebootRequired RebootRequiredFunc
+	// timeout is the time the node may take to come back Ready, zero means infinite
+	timeout time.Duration
+}
+
+// WithNodeReboot provides an option to reboot the upgraded nodes with the rebooter after the drain, before their
+// driver pods are restarted. The nodes for which rebootRequired returns true, all of them if it is nil, are moved
+// to the reboot-required state and stay there until they report a new boot ID and are Ready again. A node which
+// isn't back within timeout is moved to the upgrade-failed state, zero timeout means infinite.
+func (m *ClusterUpgradeStateManagerImpl) WithNodeReboot(rebooter NodeRebooter, rebootRequired RebootRequiredFunc,
+	timeout time.Duration) ClusterUpgradeStateManager {
+	if rebooter == nil {
+		m.Log.V(consts.LogLevelWarning).Info("Cannot enable node reboot as the rebooter is empty")
+		return m
+	}
+	if timeout < 0 {
+		m.Log.V(consts.LogLevelWarning).Info("Ignoring negative node reboot timeout", "timeout", timeout)
+		timeout = 0
+	}
+	m.nodeReboot = &nodeReboot{rebooter: rebooter, rebootRequired: rebootRequired, timeout: timeout}
+	m.propagateKeys()
+	return m
+}
+
+// jobNodeRebooter reboots the nodes by running a Job on them
+type jobNodeRebooter struct {
+	k8sInterface kubernetes.Interface
+	template     *batchv1.JobTemplateSpec
+	keys         UpgradeKeys
+}
+
+// NewJobNodeRebooter returns a NodeRebooter running a Job from the template on the node to reboot, e.g. a privileged
+// pod running systemctl reboot. The pods of the Job are pinned to the node with PinPodSpecToNode. The Job is created
+// in the namespace of the template, which must be set, and is deleted once the node is back.
+func NewJobNodeRebooter(k8sInterface kubernetes.Interface, template *batchv1.JobTemplateSpec) NodeRebooter {
+	return &jobNodeRebooter{k8sInterface: k8sInterface, template: template}
+}
+
+// rebootJobName is the name of the reboot Jobs, prefixed by the driver name and suffixed by the node name
+const rebootJobName = "reboot"
+
+func (r *jobNodeRebooter) setKeys(keys UpgradeKeys) {
+	r.keys = keys
+}
+
+// Reboot implements NodeRebooter
+func (r *jobNodeRebooter) Reboot(ctx context.Context, node *corev1.Node) error {
+	if r.template.Namespace == "" {
+		return fmt.Errorf("namespace of the reboot Job template is not set")
+	}
+	job := &batchv1.Job{ObjectMeta: *r.template.ObjectMeta.DeepCopy(), Spec: *r.template.Spec.DeepCopy()}
+	job.Name = getNodeJobName(rebootJobName, node)
+	job.GenerateName = ""
+	r.keys.PinPodSpecToNode(&job.Spec.Template.Spec, node.Name)
+	_, err := r.k8sInterface.BatchV1().Jobs(r.template.Namespace).Create(ctx, job, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create reboot Job on node %s: %v", node.Name, err)
+	}
+	return nil
+}
+
+// Cleanup implements NodeRebooter, the Job is deleted with its pods
+func (r *jobNodeRebooter) Cleanup(ctx context.Context, node *corev1.Node) error {
+	err := r.k8sInterface.BatchV1().Jobs(r.template.Namespace).Delete(ctx, getNodeJobName(rebootJobName, node),
+		metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationBackground)})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete reboot Job on node %s: %v", node.Name, err)
+	}
+	return nil
+}
+
+// annotationNodeRebooter reboots the nodes by setting an annotation consumed by a reboot daemon
+type annotationNodeRebooter struct {
+	k8sClient     client.Client
+	annotationKey string
+	value         string
+}
+
+// NewAnnotationNodeRebooter returns a NodeRebooter setting the annotationKey annotation to value on the node
+// to reboot, e.g. the annotation watched by a reboot daemon of the cluster. The annotation is removed once
+// the node is back, unless the reboot daemon removed it already.
+func NewAnnotationNodeRebooter(k8sClient client.Client, annotationKey, value string) NodeRebooter {
+	return &annotationNodeRebooter{k8sClient: k8sClient, annotationKey: annotationKey, value: value}
+}
+
+// Reboot implements NodeRebooter
+func (r *annotationNodeRebooter) Reboot(ctx context.Context, node *corev1.Node) error {
+	return r.patchAnnotation(ctx, node, r.value)
+}
+
+// Cleanup implements NodeRebooter
+func (r *annotationNodeRebooter) Cleanup(ctx context.Context, node *corev1.Node) error {
+	return r.patchAnnotation(ctx, node, nil)
+}
+
+// patchAnnotation sets the reboot annotation of the node to value, or removes it if value is nil
+func (r *annotationNodeRebooter) patchAnnotation(ctx context.Context, node *corev1.Node, value interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{r.annotationKey: value}},
+	})
+	if err != nil {
+		return err
+	}
+	err = r.k8sClient.Patch(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: node.Name}},
+		client.RawPatch(types.MergePatchType, patch))
+	if err != nil {
+		return fmt.Errorf("failed to patch reboot annotation of node %s: %v", node.Name, err)
+	}
+	return nil
+}
+
+// isNodeRebootRequired returns true if the node in the pod-restart-required state must be rebooted first, i.e. node
+// reboot is enabled, the node wasn't rebooted during the current upgrade and the reboot required function agrees
+func (m *ClusterUpgradeStateManagerImpl) isNodeRebootRequired(ctx context.Context,
+	nodeState *NodeUpgradeState) (bool, error) {
+	if m.nodeReboot == nil {
+		return false, nil
+	}
+	if _, rebooted := nodeState.Node.Annotations[m.keys.GetRebootBootIDAnnotationKey()]; rebooted {
+		return false, nil
+	}
+	if m.nodeReboot.rebootRequired == nil {
+		return true, nil
+	}
+	required, err := m.nodeReboot.rebootRequired(ctx, nodeState)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to check whether the node must be rebooted",
+			"node", nodeState.Node.Name)
+		return false, err
+	}
+	return required, nil
+}
+
+// ProcessRebootRequiredNodes processes UpgradeStateRebootRequired nodes: the reboot of the node is requested with
+// the rebooter, then the node is moved back to UpgradeStatePodRestartRequired state once it reports a new boot ID
+// and is Ready, or to UpgradeStateFailed state if it isn't back within the reboot timeout
+func (m *ClusterUpgradeStateManagerImpl) ProcessRebootRequiredNodes(ctx context.Context,
+	currentClusterState *ClusterUpgradeState) error {
+	m.Log.V(consts.LogLevelInfo).Info("ProcessRebootRequiredNodes")
+
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateRebootRequired] {
+		node := nodeState.Node
+		if m.nodeReboot == nil {
+			// node reboot was disabled meanwhile, restart the driver pods without reboot
+			m.Log.V(consts.LogLevelInfo).Info("Node reboot is disabled, skipping the reboot", "node", node.Name)
+			err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStatePodRestartRequired)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(
+					err, "Failed to change node upgrade state", "state", UpgradeStatePodRestartRequired)
+				return err
+			}
+			continue
+		}
+		bootID, requested := node.Annotations[m.keys.GetRebootBootIDAnnotationKey()]
+		if !requested {
+			err := m.requestNodeReboot(ctx, node)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if node.Status.NodeInfo.BootID != bootID && isNodeReady(node) {
+			err := m.completeNodeReboot(ctx, node, UpgradeStatePodRestartRequired)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err := m.handleNodeRebootWait(ctx, node)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requestNodeReboot requests the reboot of the node and records its boot ID, so that the end of the reboot
+// is detected when it changes
+func (m *ClusterUpgradeStateManagerImpl) requestNodeReboot(ctx context.Context, node *corev1.Node) error {
+	m.Log.V(consts.LogLevelInfo).Info("Rebooting node", "node", node.Name)
+	err := m.nodeReboot.rebooter.Reboot(ctx, node)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to request node reboot", "node", node.Name)
+		return err
+	}
+	logEventf(m.EventRecorder, node, corev1.EventTypeNormal, GetEventReason(), "Rebooting the node")
+	m.applyResultRecorder.record(UpgradeActionReboot, node)
+	err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, m.keys.GetRebootStartTimeAnnotationKey(),
+		strconv.FormatInt(time.Now().Unix(), 10))
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to add annotation to track node reboot", "node", node.Name)
+		return err
+	}
+	err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, m.keys.GetRebootBootIDAnnotationKey(),
+		node.Status.NodeInfo.BootID)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to add annotation to track node reboot", "node", node.Name)
+		return err
+	}
+	return m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonWaitingForReboot)
+}
+
+// handleNodeRebootWait moves the node to the UpgradeStateFailed state once the reboot timeout is exceeded
+func (m *ClusterUpgradeStateManagerImpl) handleNodeRebootWait(ctx context.Context, node *corev1.Node) error {
+	m.Log.V(consts.LogLevelInfo).Info("Waiting for the node to reboot", "node", node.Name)
+	if m.nodeReboot.timeout == 0 {
+		return nil
+	}
+	startTime, err := strconv.ParseInt(node.Annotations[m.keys.GetRebootStartTimeAnnotationKey()], 10, 64)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to convert start time to track node reboot",
+			"node", node.Name)
+		return err
+	}
+	if time.Now().Unix() <= startTime+int64(m.nodeReboot.timeout.Seconds()) {
+		return nil
+	}
+
+	// timeout exceeded, mark node in failed state
+	m.Log.V(consts.LogLevelInfo).Info("Timeout exceeded waiting for the node reboot", "node", node.Name,
+		"timeout", m.nodeReboot.timeout)
+	logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+		"Node did not come back Ready within %s after the reboot, moving it to %s state", m.nodeReboot.timeout,
+		UpgradeStateFailed)
+	err = m.completeNodeReboot(ctx, node, UpgradeStateFailed)
+	if err != nil {
+		return err
+	}
+	return m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonRebootTimeout)
+}
+
+// completeNodeReboot cleans up the reboot of the node and moves it to the new state. The boot ID annotation
+// is kept until the upgrade is done, so that the node is rebooted once per upgrade.
+func (m *ClusterUpgradeStateManagerImpl) completeNodeReboot(ctx context.Context, node *corev1.Node,
+	newState string) error {
+	err := m.nodeReboot.rebooter.Cleanup(ctx, node)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to clean up node reboot", "node", node.Name)
+		return err
+	}
+	err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, m.keys.GetRebootStartTimeAnnotationKey(),
+		nullString)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to remove annotation used to track node reboot",
+			"node", node.Name)
+		return err
+	}
+	err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, newState)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "node", node.Name, "state", newState)
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// runningPodsFieldSelector is the field selector of the pods in the Running phase
+const runningPodsFieldSelector = "status.phase=Running"
+
+// NodeSortPolicy orders the nodes of the upgrade-required state, the nodes are started in this order
+// as long as upgrade slots are available
+type NodeSortPolicy interface {
+	// Sort orders the node states in place
+	Sort(ctx context.Context, nodeStates []*NodeUpgradeState) error
+}
+
+// NodeLessFunc reports whether the node a is upgraded before the node b
+type NodeLessFunc func(a, b *NodeUpgradeState) bool
+
+// nodeComparatorSort is a NodeSortPolicy ordering the nodes with a NodeLessFunc
+type nodeComparatorSort struct {
+	less NodeLessFunc
+}
+
+// Sort implements NodeSortPolicy
+func (s *nodeComparatorSort) Sort(_ context.Context, nodeStates []*NodeUpgradeState) error {
+	slices.SortStableFunc(nodeStates, func(a, b *NodeUpgradeState) int {
+		switch {
+		case s.less(a, b):
+			return -1
+		case s.less(b, a):
+			return 1
+		default:
+			return 0
+		}
+	})
+	return nil
+}
+
+// NewNodeComparatorSort returns a NodeSortPolicy ordering the nodes with the user-supplied less function.
+// The sort is stable, the nodes equal for less keep their order.
+func NewNodeComparatorSort(less NodeLessFunc) NodeSortPolicy {
+	return &nodeComparatorSort{less: less}
+}
+
+// NewNodeAlphabeticalSort returns a NodeSortPolicy ordering the nodes by name
+func NewNodeAlphabeticalSort() NodeSortPolicy {
+	return NewNodeComparatorSort(func(a, b *NodeUpgradeState) bool {
+		return a.Node.Name < b.Node.Name
+	})
+}
+
+// nodeZoneSpreadSort is a NodeSortPolicy spreading the nodes over their zones
+type nodeZoneSpreadSort struct {
+	zoneLabel string
+}
+
+// Sort implements NodeSortPolicy
+func (s *nodeZoneSpreadSort) Sort(_ context.Context, nodeStates []*NodeUpgradeState) error {
+	zoneNodes := make(map[string][]*NodeUpgradeState)
+	for _, nodeState := range nodeStates {
+		zone := nodeState.Node.Labels[s.zoneLabel]
+		zoneNodes[zone] = append(zoneNodes[zone], nodeState)
+	}
+	zones := make([]string, 0, len(zoneNodes))
+	for zone, nodes := range zoneNodes {
+		slices.SortFunc(nodes, func(a, b *NodeUpgradeState) int {
+			return strings.Compare(a.Node.Name, b.Node.Name)
+		})
+		zones = append(zones, zone)
+	}
+	slices.Sort(zones)
+
+	// take the next node of every zone in turn
+	sorted := make([]*NodeUpgradeState, 0, len(nodeStates))
+	for i := 0; len(sorted) < len(nodeStates); i++ {
+		for _, zone := range zones {
+			if i < len(zoneNodes[zone]) {
+				sorted = append(sorted, zoneNodes[zone][i])
+			}
+		}
+	}
+	copy(nodeStates, sorted)
+	return nil
+}
+
+// NewNodeZoneSpreadSort returns a NodeSortPolicy spreading the nodes over the zones given by the zone label,
+// corev1.LabelTopologyZone if empty: the nodes are taken from every zone in turn, so that the nodes upgraded
+// in parallel are in different zones as far as possible. The zones and the nodes of a zone are ordered by name,
+// the nodes without the zone label form a zone of their own.
+func NewNodeZoneSpreadSort(zoneLabel string) NodeSortPolicy {
+	if zoneLabel == "" {
+		zoneLabel = corev1.LabelTopologyZone
+	}
+	return &nodeZoneSpreadSort{zoneLabel: zoneLabel}
+}
+
+// nodeLeastLoadedSort is a NodeSortPolicy ordering the nodes by their count of running pods
+type nodeLeastLoadedSort struct {
+	k8sInterface kubernetes.Interface
+}
+
+// Sort implements NodeSortPolicy
+func (s *nodeLeastLoadedSort) Sort(ctx context.Context, nodeStates []*NodeUpgradeState) error {
+	if len(nodeStates) == 0 {
+		return nil
+	}
+	pods, err := s.k8sInterface.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: runningPodsFieldSelector,
+	})
+	if err != nil {
+		return err
+	}
+	runningPods := make(map[string]int)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodRunning {
+			runningPods[pod.Spec.NodeName]++
+		}
+	}
+	slices.SortStableFunc(nodeStates, func(a, b *NodeUpgradeState) int {
+		if diff := runningPods[a.Node.Name] - runningPods[b.Node.Name]; diff != 0 {
+			return diff
+		}
+		return strings.Compare(a.Node.Name, b.Node.Name)
+	})
+	return nil
+}
+
+// NewNodeLeastLoadedSort returns a NodeSortPolicy ordering the nodes by their count of running pods, including
+// the DaemonSet pods, so that the nodes whose upgrade disrupts the fewest pods are upgraded first. The nodes with
+// the same count are ordered by name.
+func NewNodeLeastLoadedSort(k8sInterface kubernetes.Interface) NodeSortPolicy {
+	return &nodeLeastLoadedSort{k8sInterface: k8sInterface}
+}
+
+// sortUpgradeRequiredNodes returns the cluster state with the nodes of the upgrade-required state ordered by
+// the NodeSortPolicy set with WithNodeSortPolicy. The nodes keep the order of the cluster state if none is set.
+func (m *ClusterUpgradeStateManagerImpl) sortUpgradeRequiredNodes(ctx context.Context,
+	currentClusterState *ClusterUpgradeState) (*ClusterUpgradeState, error) {
+	nodeStates := currentClusterState.NodeStates[UpgradeStateUpgradeRequired]
+	if m.nodeSortPolicy == nil || len(nodeStates) < 2 {
+		return currentClusterState, nil
+	}
+	sortedNodes := slices.Clone(nodeStates)
+	if err := m.nodeSortPolicy.Sort(ctx, sortedNodes); err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to sort the nodes of the upgrade-required state")
+		return nil, err
+	}
+	sortedState := NewClusterUpgradeState()
+	for state, states := range currentClusterState.NodeStates {
+		sortedState.NodeStates[state] = states
+	}
+	sortedState.NodeStates[UpgradeStateUpgradeRequired] = sortedNodes
+	return &sortedState, nil
+}
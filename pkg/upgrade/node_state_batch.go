@@ -0,0 +1,170 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// NodeUpgradeStateBatchProvider is implemented by the NodeUpgradeStateProviders which change the upgrade state
+// of several nodes at once
+type NodeUpgradeStateBatchProvider interface {
+	// ChangeNodesUpgradeState changes the upgrade state of the nodes like ChangeNodeUpgradeState and returns
+	// the errors of the nodes which couldn't be changed
+	ChangeNodesUpgradeState(ctx context.Context, nodes []*corev1.Node, newNodeState string) error
+}
+
+// nodeWriteLimits are the limits of the node writes of a NodeUpgradeStateProvider, shared by the providers
+// of this package
+type nodeWriteLimits struct {
+	// concurrency is the count of nodes updated in parallel by ChangeNodesUpgradeState, see WithConcurrency
+	concurrency int
+	// rateLimiter limits the node writes, see WithRateLimit
+	rateLimiter flowcontrol.RateLimiter
+}
+
+// setConcurrency sets the count of nodes updated in parallel, at least 1
+func (l *nodeWriteLimits) setConcurrency(workers int) {
+	l.concurrency = max(workers, 1)
+}
+
+// setRateLimit limits the node writes to qps writes per second with bursts of up to burst writes,
+// zero qps removes the limit
+func (l *nodeWriteLimits) setRateLimit(qps float32, burst int) {
+	if qps <= 0 {
+		l.rateLimiter = nil
+		return
+	}
+	l.rateLimiter = flowcontrol.NewTokenBucketRateLimiter(qps, max(burst, 1))
+}
+
+// waitForRateLimit blocks until the rate limit allows a node write
+func (l *nodeWriteLimits) waitForRateLimit(ctx context.Context) error {
+	if l.rateLimiter == nil {
+		return nil
+	}
+	return l.rateLimiter.Wait(ctx)
+}
+
+// changeNodesUpgradeState changes the upgrade state of the nodes with change, by up to concurrency workers,
+// and returns the errors of the nodes which couldn't be changed
+func (l *nodeWriteLimits) changeNodesUpgradeState(ctx context.Context, nodes []*corev1.Node, newNodeState string,
+	change func(ctx context.Context, node *corev1.Node, newNodeState string) error) error {
+	workers := min(max(l.concurrency, 1), len(nodes))
+	errs := make([]error, len(nodes))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				errs[index] = change(ctx, nodes[index], newNodeState)
+			}
+		}()
+	}
+	for i := range nodes {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// WithConcurrency sets the count of nodes whose upgrade state is changed in parallel by ChangeNodesUpgradeState,
+// 1 by default
+func (p *NodeUpgradeStateProviderImpl) WithConcurrency(workers int) *NodeUpgradeStateProviderImpl {
+	p.limits.setConcurrency(workers)
+	return p
+}
+
+// WithRateLimit limits the node writes of the provider to qps writes per second, with bursts of up to burst
+// writes, to avoid the throttling of the API server. Zero qps removes the limit.
+func (p *NodeUpgradeStateProviderImpl) WithRateLimit(qps float32, burst int) *NodeUpgradeStateProviderImpl {
+	p.limits.setRateLimit(qps, burst)
+	return p
+}
+
+// ChangeNodesUpgradeState implements NodeUpgradeStateBatchProvider. The states of the nodes are changed
+// by up to WithConcurrency workers, each waiting for the operator cache to get updated like ChangeNodeUpgradeState.
+func (p *NodeUpgradeStateProviderImpl) ChangeNodesUpgradeState(ctx context.Context, nodes []*corev1.Node,
+	newNodeState string) error {
+	return p.limits.changeNodesUpgradeState(ctx, nodes, newNodeState, p.ChangeNodeUpgradeState)
+}
+
+// WithConcurrency sets the count of nodes whose upgrade state is changed in parallel by ChangeNodesUpgradeState,
+// 1 by default
+func (p *CachedNodeUpgradeStateProvider) WithConcurrency(workers int) *CachedNodeUpgradeStateProvider {
+	p.limits.setConcurrency(workers)
+	return p
+}
+
+// WithRateLimit limits the node writes of the provider to qps writes per second, with bursts of up to burst
+// writes, to avoid the throttling of the API server. Zero qps removes the limit.
+func (p *CachedNodeUpgradeStateProvider) WithRateLimit(qps float32, burst int) *CachedNodeUpgradeStateProvider {
+	p.limits.setRateLimit(qps, burst)
+	return p
+}
+
+// ChangeNodesUpgradeState implements NodeUpgradeStateBatchProvider. The states of the nodes are changed
+// by up to WithConcurrency workers.
+func (p *CachedNodeUpgradeStateProvider) ChangeNodesUpgradeState(ctx context.Context, nodes []*corev1.Node,
+	newNodeState string) error {
+	return p.limits.changeNodesUpgradeState(ctx, nodes, newNodeState, p.ChangeNodeUpgradeState)
+}
+
+// WithNodeStateUpdateLimits provides an option to change the upgrade state of the nodes moving to the same state
+// in parallel, with up to concurrency nodes at once, and to limit the node writes to qps writes per second with
+// bursts of up to burst writes. Zero qps removes the rate limit.
+func (m *ClusterUpgradeStateManagerImpl) WithNodeStateUpdateLimits(concurrency int, qps float32,
+	burst int) ClusterUpgradeStateManager {
+	switch provider := unwrapNodeUpgradeStateProvider(m.NodeUpgradeStateProvider).(type) {
+	case *NodeUpgradeStateProviderImpl:
+		provider.WithConcurrency(concurrency).WithRateLimit(qps, burst)
+	case *CachedNodeUpgradeStateProvider:
+		provider.WithConcurrency(concurrency).WithRateLimit(qps, burst)
+	default:
+		m.Log.V(consts.LogLevelWarning).Info(
+			"Cannot limit the node state updates, the node upgrade state provider is not implemented by the library")
+	}
+	return m
+}
+
+// changeNodesUpgradeState changes the upgrade state of the nodes at once if the NodeUpgradeStateProvider
+// implements NodeUpgradeStateBatchProvider, one node after the other otherwise. In both cases the state of every
+// node is changed and the errors of the nodes which couldn't be changed are returned.
+func (m *ClusterUpgradeStateManagerImpl) changeNodesUpgradeState(ctx context.Context, nodes []*corev1.Node,
+	newNodeState string) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	if provider, ok := m.NodeUpgradeStateProvider.(NodeUpgradeStateBatchProvider); ok {
+		return provider.ChangeNodesUpgradeState(ctx, nodes, newNodeState)
+	}
+	errs := make([]error, 0, len(nodes))
+	for _, node := range nodes {
+		errs = append(errs, m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, newNodeState))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// NodeUpgradeResult is the result of a driver upgrade of a node
+type NodeUpgradeResult string
+
+const (
+	// NodeUpgradeInProgress is the result of the upgrade in progress
+	NodeUpgradeInProgress NodeUpgradeResult = "InProgress"
+	// NodeUpgradeSucceeded is the result of the upgrade which moved the node to the upgrade-done state
+	NodeUpgradeSucceeded NodeUpgradeResult = "Succeeded"
+	// NodeUpgradeFailed is the result of the upgrade which moved the node to the upgrade-failed state
+	NodeUpgradeFailed NodeUpgradeResult = "Failed"
+	// NodeUpgradeAborted is the result of the upgrade aborted by AbortNodeUpgrades or by the removal of the node
+	// from the upgrade scope
+	NodeUpgradeAborted NodeUpgradeResult = "Aborted"
+)
+
+// NodeUpgradeRecord is a driver upgrade of a node, as recorded in the upgrade history annotation of the node
+type NodeUpgradeRecord struct {
+	// StartTime is the time the node left the upgrade-required state
+	StartTime metav1.Time `json:"startTime"`
+	// EndTime is the time the upgrade succeeded, failed or was aborted, nil while it is in progress
+	EndTime *metav1.Time `json:"endTime,omitempty"`
+	// FromVersion is the driver version of the node before the upgrade, see WithVersionCompatibilityChecker
+	// for how the version is found
+	FromVersion string `json:"fromVersion,omitempty"`
+	// ToVersion is the target driver version of the upgrade
+	ToVersion string `json:"toVersion,omitempty"`
+	// Result is the result of the upgrade
+	Result NodeUpgradeResult `json:"result"`
+	// FailedState is the upgrade state the node failed in, if the upgrade failed
+	FailedState string `json:"failedState,omitempty"`
+	// FailureReason is the upgrade state reason of the node when it failed, if any
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// nodeUpgradeIdleStates are the upgrade states of the nodes whose upgrade is not in progress
+var nodeUpgradeIdleStates = []string{
+	UpgradeStateUnknown,
+	UpgradeStateDone,
+	UpgradeStateUpgradeRequired,
+	UpgradeStateDaemonSetMissing,
+	UpgradeStateBlockedBySkew,
+	UpgradeStateFailed,
+}
+
+// WithNodeUpgradeHistory provides an option to record the last maxRecords driver upgrades of every node
+// in its upgrade history annotation, see GetNodeUpgradeHistory. The upgrade starts when the node leaves
+// the upgrade-required state and ends when the node reaches the upgrade-done or upgrade-failed state.
+// Zero maxRecords disables the history, the default.
+func (m *ClusterUpgradeStateManagerImpl) WithNodeUpgradeHistory(maxRecords int) ClusterUpgradeStateManager {
+	m.maxUpgradeHistoryRecords = max(maxRecords, 0)
+	return m
+}
+
+// GetNodeUpgradeHistory returns the driver upgrades of the node recorded in its upgrade history annotation,
+// oldest first, or nil if the node has no history, see WithNodeUpgradeHistory
+func (k UpgradeKeys) GetNodeUpgradeHistory(node *corev1.Node) ([]NodeUpgradeRecord, error) {
+	value, ok := node.Annotations[k.GetUpgradeHistoryAnnotationKey()]
+	if !ok {
+		return nil, nil
+	}
+	var history []NodeUpgradeRecord
+	if err := json.Unmarshal([]byte(value), &history); err != nil {
+		return nil, fmt.Errorf("invalid upgrade history annotation of node %s: %v", node.Name, err)
+	}
+	return history, nil
+}
+
+// GetNodeUpgradeHistory returns UpgradeKeys.GetNodeUpgradeHistory with the default key prefix
+func GetNodeUpgradeHistory(node *corev1.Node) ([]NodeUpgradeRecord, error) {
+	return UpgradeKeys{}.GetNodeUpgradeHistory(node)
+}
+
+// recordNodeUpgradeHistories updates the upgrade history of the nodes of currentState whose upgrade started
+// or ended in the pass. The nodes processed by the workers are updated on the next pass, once their state is known.
+// Failures are logged only, as the history is informational.
+func (m *ClusterUpgradeStateManagerImpl) recordNodeUpgradeHistories(ctx context.Context,
+	currentState *ClusterUpgradeState) {
+	now := metav1.NewTime(time.Now())
+	for state, nodeStates := range currentState.NodeStates {
+		if slices.Contains(asyncPhases, state) {
+			continue
+		}
+		for _, nodeState := range nodeStates {
+			err := m.recordNodeUpgradeHistory(ctx, nodeState, state, now)
+			if err != nil {
+				m.Log.V(consts.LogLevelWarning).Info("Failed to record node upgrade history",
+					"node", nodeState.Node.Name, "error", err.Error())
+			}
+		}
+	}
+}
+
+// recordNodeUpgradeHistory starts a record in the upgrade history of the node if its upgrade is in progress
+// and ends the record in progress if the node reached the upgrade-done or upgrade-failed state.
+// previousState is the upgrade state of the node at the beginning of the pass.
+func (m *ClusterUpgradeStateManagerImpl) recordNodeUpgradeHistory(ctx context.Context,
+	nodeState *NodeUpgradeState, previousState string, now metav1.Time) error {
+	node := nodeState.Node
+	history, err := m.keys.GetNodeUpgradeHistory(node)
+	if err != nil {
+		return err
+	}
+	var last *NodeUpgradeRecord
+	if len(history) > 0 {
+		last = &history[len(history)-1]
+	}
+	inProgress := last != nil && last.Result == NodeUpgradeInProgress
+
+	state := m.getNodeUpgradeState(node)
+	switch {
+	case !inProgress && !slices.Contains(nodeUpgradeIdleStates, state):
+		fromVersion, toVersion := m.getNodeDriverVersions(nodeState)
+		history = append(history, NodeUpgradeRecord{StartTime: now, FromVersion: fromVersion, ToVersion: toVersion,
+			Result: NodeUpgradeInProgress})
+	case inProgress && state == UpgradeStateDone:
+		last.EndTime = &now
+		last.Result = NodeUpgradeSucceeded
+	case inProgress && state == UpgradeStateFailed:
+		last.EndTime = &now
+		last.Result = NodeUpgradeFailed
+		last.FailedState = previousState
+		if previousState == UpgradeStateFailed {
+			// the node was failed by a worker, its previous state is only known from its timeline
+			last.FailedState = m.getNodeFailedState(node.Name)
+		}
+		last.FailureReason = m.keys.GetNodeUpgradeStateReason(node)
+	default:
+		return nil
+	}
+	if len(history) > m.maxUpgradeHistoryRecords {
+		history = history[len(history)-m.maxUpgradeHistoryRecords:]
+	}
+	return m.setNodeUpgradeHistory(ctx, node, history)
+}
+
+// abortNodeUpgradeRecord marks the upgrade in progress in the upgrade history of the node as aborted
+func (m *ClusterUpgradeStateManagerImpl) abortNodeUpgradeRecord(ctx context.Context, node *corev1.Node) error {
+	history, err := m.keys.GetNodeUpgradeHistory(node)
+	if err != nil {
+		m.Log.V(consts.LogLevelWarning).Info("Ignoring node upgrade history", "node", node.Name, "error", err.Error())
+		return nil
+	}
+	if len(history) == 0 || history[len(history)-1].Result != NodeUpgradeInProgress {
+		return nil
+	}
+	now := metav1.NewTime(time.Now())
+	history[len(history)-1].EndTime = &now
+	history[len(history)-1].Result = NodeUpgradeAborted
+	return m.setNodeUpgradeHistory(ctx, node, history)
+}
+
+// setNodeUpgradeHistory writes the upgrade history annotation of the node
+func (m *ClusterUpgradeStateManagerImpl) setNodeUpgradeHistory(ctx context.Context, node *corev1.Node,
+	history []NodeUpgradeRecord) error {
+	value, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upgrade history of node %s: %v", node.Name, err)
+	}
+	return m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, m.keys.GetUpgradeHistoryAnnotationKey(),
+		string(value))
+}
+
+// getNodeDriverVersions returns the current driver version of the node and the target version of its DaemonSet,
+// empty if unknown
+func (m *ClusterUpgradeStateManagerImpl) getNodeDriverVersions(nodeState *NodeUpgradeState) (string, string) {
+	policy := m.versionSkewPolicy
+	if policy == nil {
+		policy = &versionSkewPolicy{}
+	}
+	var fromVersion, toVersion string
+	if pod := nodeState.DriverPod; pod != nil {
+		fromVersion = policy.getDriverVersion(pod.Labels, pod.Spec.Containers)
+	}
+	if ds := nodeState.DriverDaemonSet; ds != nil {
+		toVersion = policy.getDriverVersion(ds.Spec.Template.Labels, ds.Spec.Template.Spec.Containers)
+	}
+	return fromVersion, toVersion
+}
+
+// getNodeFailedState returns the upgrade state the node last failed in according to its timeline,
+// empty if unknown
+func (m *ClusterUpgradeStateManagerImpl) getNodeFailedState(nodeName string) string {
+	timeline := m.GetNodeUpgradeTimeline(nodeName)
+	if timeline == nil {
+		return ""
+	}
+	for i := len(timeline.Entries) - 1; i >= 0; i-- {
+		if timeline.Entries[i].Type == NodeUpgradeTimelineEntryError {
+			return timeline.Entries[i].FromState
+		}
+	}
+	return ""
+}
@@ -23,6 +23,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
@@ -42,6 +43,14 @@ type NodeUpgradeStateProviderImpl struct {
 	Log           logr.Logger
 	nodeMutex     KeyedMutex
 	eventRecorder record.EventRecorder
+	// limits are the concurrency and the rate limit of the node writes, see WithConcurrency and WithRateLimit
+	limits nodeWriteLimits
+	// stateStorage stores the upgrade state of the nodes, see WithStateStorage
+	stateStorage NodeUpgradeStateStorage
+	// keys returns the keys of the node labels and annotations, see WithKeyPrefix
+	keys UpgradeKeys
+	// timelines records the state changes written by the provider, set by the upgrade state manager
+	timelines *nodeUpgradeTimelineStore
 }
 
 // NewNodeUpgradeStateProvider creates a NodeUpgradeStateProviderImpl
@@ -52,9 +61,26 @@ func NewNodeUpgradeStateProvider(k8sClient client.Client, log logr.Logger,
 		Log:           log,
 		nodeMutex:     KeyedMutex{},
 		eventRecorder: eventRecorder,
+		limits:        nodeWriteLimits{concurrency: 1},
+		stateStorage:  LabelStateStorage{},
 	}
 }
 
+// WithStateStorage sets the storage of the node upgrade states written by the provider, LabelStateStorage by default.
+// The upgrade state manager sets the storage of its provider, see WithNodeUpgradeStateStorage.
+func (p *NodeUpgradeStateProviderImpl) WithStateStorage(storage NodeUpgradeStateStorage) *NodeUpgradeStateProviderImpl {
+	p.stateStorage = bindStateStorage(storage, p.keys)
+	return p
+}
+
+// WithKeyPrefix sets the prefix of the node labels and annotations written by the provider, DefaultKeyPrefix by
+// default. The upgrade state manager sets the prefix of its provider, see ClusterUpgradeStateManager.WithKeyPrefix.
+func (p *NodeUpgradeStateProviderImpl) WithKeyPrefix(prefix string) *NodeUpgradeStateProviderImpl {
+	p.keys = NewUpgradeKeys(prefix)
+	p.stateStorage = bindStateStorage(p.stateStorage, p.keys)
+	return p
+}
+
 // GetNode returns a corev1.Node according to name
 func (p *NodeUpgradeStateProviderImpl) GetNode(ctx context.Context, nodeName string) (*corev1.Node, error) {
 	defer p.nodeMutex.Lock(nodeName)()
@@ -67,8 +93,9 @@ func (p *NodeUpgradeStateProviderImpl) GetNode(ctx context.Context, nodeName str
 	return &node, nil
 }
 
-// ChangeNodeUpgradeState patches a given corev1.Node object and updates its UpgradeStateLabel with a given value
-// The function then waits for the operator cache to get updated
+// ChangeNodeUpgradeState updates the upgrade state of a given corev1.Node object with a given value in the node
+// upgrade state storage, see WithStateStorage. The write is retried on conflict with the latest version
+// of the node. The function then waits for the operator cache to get updated
 func (p *NodeUpgradeStateProviderImpl) ChangeNodeUpgradeState(
 	ctx context.Context, node *corev1.Node, newNodeState string) error {
 	p.Log.V(consts.LogLevelInfo).Info("Updating node upgrade state",
@@ -77,9 +104,8 @@ func (p *NodeUpgradeStateProviderImpl) ChangeNodeUpgradeState(
 
 	defer p.nodeMutex.Lock(node.Name)()
 
-	patchString := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q: %q}}}`, GetUpgradeStateLabelKey(), newNodeState))
-	patch := client.RawPatch(types.StrategicMergePatchType, patchString)
-	err := p.K8sClient.Patch(ctx, node, patch)
+	err := setNodeUpgradeState(ctx, p.K8sClient, p.stateStorage, p.timelines, node, newNodeState,
+		p.limits.waitForRateLimit)
 	if err != nil {
 		p.Log.V(consts.LogLevelError).Error(err, "Failed to patch node state label on a node object",
 			"node", node,
@@ -107,7 +133,7 @@ func (p *NodeUpgradeStateProviderImpl) ChangeNodeUpgradeState(
 		if err != nil {
 			return false, err
 		}
-		nodeState := node.Labels[GetUpgradeStateLabelKey()]
+		nodeState := p.stateStorage.GetState(node)
 		if nodeState != newNodeState {
 			p.Log.V(consts.LogLevelDebug).Info("upgrade state label for node doesn't match the expected",
 				"node", node.Name, "expected", newNodeState, "actual", nodeState)
@@ -133,8 +159,49 @@ func (p *NodeUpgradeStateProviderImpl) ChangeNodeUpgradeState(
 	return err
 }
 
+// setNodeUpgradeState sets the upgrade state of the node in the node upgrade state storage. The storages writing
+// with an optimistic lock on the version of the node, e.g. TaintStateStorage, get a conflict error if the node
+// was updated concurrently, e.g. by the kubelet, their write is retried with the latest version of the node, so that
+// the callers don't need their own retry loop. The other storages of this package write with a patch which doesn't
+// conflict and are not retried, custom storages are retried as they may use an optimistic lock.
+// waitForWrite, if set, is called before every write. The state change is recorded in the timelines, if set.
+func setNodeUpgradeState(ctx context.Context, k8sClient client.Client, storage NodeUpgradeStateStorage,
+	timelines *nodeUpgradeTimelineStore, node *corev1.Node, state string,
+	waitForWrite func(ctx context.Context) error) error {
+	attempt := 0
+	fromState := ""
+	write := func() error {
+		if attempt > 0 {
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: node.Name}, node)
+			if err != nil {
+				return err
+			}
+		}
+		attempt++
+		if waitForWrite != nil {
+			if err := waitForWrite(ctx); err != nil {
+				return err
+			}
+		}
+		fromState = storage.GetState(node)
+		return storage.SetState(ctx, k8sClient, node, state)
+	}
+	var err error
+	if locking, ok := storage.(optimisticLockStateStorage); ok && !locking.optimisticLock() {
+		err = write()
+	} else {
+		err = retry.RetryOnConflict(retry.DefaultBackoff, write)
+	}
+	if err != nil {
+		return err
+	}
+	timelines.recordTransition(node.Name, fromState, state, time.Now())
+	return nil
+}
+
 // ChangeNodeUpgradeAnnotation patches a given corev1.Node object and updates an annotation with a given value
-// The function then waits for the operator cache to get updated
+// The function then waits for the operator cache to get updated. The merge patch has no resource version,
+// so it doesn't conflict with concurrent updates of the node and isn't retried.
 func (p *NodeUpgradeStateProviderImpl) ChangeNodeUpgradeAnnotation(
 	ctx context.Context, node *corev1.Node, key string, value string) error {
 	p.Log.V(consts.LogLevelInfo).Info("Updating node upgrade annotation",
@@ -149,7 +216,10 @@ func (p *NodeUpgradeStateProviderImpl) ChangeNodeUpgradeAnnotation(
 		patchString = []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q: null}}}`, key))
 	}
 	patch := client.RawPatch(types.MergePatchType, patchString)
-	err := p.K8sClient.Patch(ctx, node, patch)
+	err := p.limits.waitForRateLimit(ctx)
+	if err == nil {
+		err = p.K8sClient.Patch(ctx, node, patch)
+	}
 	if err != nil {
 		p.Log.V(consts.LogLevelError).Error(err, "Failed to patch node state annotation on a node object",
 			"node", node,
@@ -19,10 +19,17 @@ package upgrade_test
 import (
 	"context"
 	"fmt"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
 )
@@ -70,4 +77,235 @@ var _ = Describe("NodeUpgradeStateProvider tests", func() {
 		_, exist := node.Annotations[key]
 		Expect(exist).To(Equal(false))
 	})
+	It("NodeUpgradeStateProvider should report node label and annotation changes to the audit sink", func() {
+		sink := &recordingNodeWriteAuditSink{}
+		auditClient := upgrade.NewNodeWriteAuditClient(k8sClient, log, sink)
+		provider := upgrade.NewNodeUpgradeStateProvider(auditClient, log, eventRecorder)
+
+		key := upgrade.GetUpgradeInitialStateAnnotationKey()
+		Expect(provider.ChangeNodeUpgradeAnnotation(ctx, node, key, "true")).To(Succeed())
+		Expect(provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateUpgradeRequired)).To(Succeed())
+
+		Expect(sink.records).To(HaveLen(2))
+		Expect(sink.records[0].NodeName).To(Equal(node.Name))
+		Expect(sink.records[0].Labels).To(BeEmpty())
+		Expect(sink.records[0].Annotations).To(HaveLen(1))
+		Expect(sink.records[0].Annotations[0].String()).To(Equal(key + `: <none> -> "true"`))
+		Expect(sink.records[1].Labels).To(HaveLen(1))
+		Expect(sink.records[1].Labels[0].String()).To(
+			Equal(upgrade.GetUpgradeStateLabelKey() + `: <none> -> "upgrade-required"`))
+	})
+	It("NodeUpgradeStateProvider should store node upgrade state in the configured storage", func() {
+		storage := upgrade.AnnotationStateStorage{}
+		stateProvider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+		provider := stateProvider.(*upgrade.NodeUpgradeStateProviderImpl).WithStateStorage(storage)
+
+		// the node was upgraded with the state label before the storage was changed
+		node.Labels = map[string]string{upgrade.GetUpgradeStateLabelKey(): upgrade.UpgradeStateDone}
+		Expect(k8sClient.Update(ctx, node)).To(Succeed())
+		Expect(storage.GetState(node)).To(Equal(upgrade.UpgradeStateDone))
+
+		err := provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateUpgradeRequired)
+		Expect(err).To(Succeed())
+
+		node, err = provider.GetNode(ctx, node.Name)
+		Expect(err).To(Succeed())
+		Expect(node.Annotations[upgrade.GetUpgradeStateAnnotationKey()]).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+		Expect(node.Labels).NotTo(HaveKey(upgrade.GetUpgradeStateLabelKey()))
+		Expect(storage.GetState(node)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+
+		// the nodes with an upgrade state are listed by the upgrade-state-stored label
+		otherNode := createNode(fmt.Sprintf("node-2-%s", id))
+		nodeList := &corev1.NodeList{}
+		Expect(k8sClient.List(ctx, nodeList, storage.ListOptions()...)).To(Succeed())
+		Expect(nodeList.Items).To(ContainElement(HaveField("Name", node.Name)))
+		Expect(nodeList.Items).NotTo(ContainElement(HaveField("Name", otherNode.Name)))
+	})
+	It("NodeUpgradeStateProvider should change the upgrade state of several nodes in parallel "+
+		"within the rate limit", func() {
+		stateProvider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+		provider, ok := stateProvider.(*upgrade.NodeUpgradeStateProviderImpl)
+		Expect(ok).To(BeTrue())
+		provider.WithConcurrency(3).WithRateLimit(10, 1)
+		nodes := []*corev1.Node{node, createNode(fmt.Sprintf("node-2-%s", id)), createNode(fmt.Sprintf("node-3-%s", id))}
+
+		start := time.Now()
+		Expect(provider.ChangeNodesUpgradeState(ctx, nodes, upgrade.UpgradeStateCordonRequired)).To(Succeed())
+		// the first write is allowed by the burst, the next ones wait for the rate limit
+		Expect(time.Since(start)).To(BeNumerically(">=", 150*time.Millisecond))
+		for _, n := range nodes {
+			n, err := provider.GetNode(ctx, n.Name)
+			Expect(err).To(Succeed())
+			Expect(n.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateCordonRequired))
+		}
+	})
+	It("CachedNodeUpgradeStateProvider should change the upgrade state of several nodes within the limits "+
+		"of the manager", func() {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		provider := upgrade.NewCachedNodeUpgradeStateProvider(k8sClient, corev1listers.NewNodeLister(indexer), log,
+			eventRecorder)
+		stateManager, err := upgrade.NewClusterUpgradeStateManager(log, k8sConfig, eventRecorder)
+		Expect(err).NotTo(HaveOccurred())
+		stateManager.WithNodeUpgradeStateProvider(provider).WithNodeStateUpdateLimits(3, 10, 1)
+		batchProvider, ok := provider.(upgrade.NodeUpgradeStateBatchProvider)
+		Expect(ok).To(BeTrue())
+		nodes := []*corev1.Node{node, createNode(fmt.Sprintf("node-2-%s", id)), createNode(fmt.Sprintf("node-3-%s", id))}
+
+		start := time.Now()
+		Expect(batchProvider.ChangeNodesUpgradeState(ctx, nodes, upgrade.UpgradeStateCordonRequired)).To(Succeed())
+		// the first write is allowed by the burst, the next ones wait for the rate limit
+		Expect(time.Since(start)).To(BeNumerically(">=", 150*time.Millisecond))
+		for _, n := range nodes {
+			latestNode := &corev1.Node{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: n.Name}, latestNode)).To(Succeed())
+			Expect(latestNode.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateCordonRequired))
+		}
+	})
+	It("NodeUpgradeStateProvider should apply node upgrade state with server-side apply", func() {
+		storage := upgrade.ServerSideApplyStateStorage{FieldOwner: "upgrade-test"}
+		stateProvider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+		provider := stateProvider.(*upgrade.NodeUpgradeStateProviderImpl).WithStateStorage(storage)
+
+		reasonKey := upgrade.GetUpgradeStateReasonAnnotationKey()
+		Expect(provider.ChangeNodeUpgradeAnnotation(ctx, node, reasonKey, "WaitingForSlot")).To(Succeed())
+		Expect(provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateCordonRequired)).To(Succeed())
+
+		node, err := provider.GetNode(ctx, node.Name)
+		Expect(err).To(Succeed())
+		Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateCordonRequired))
+		Expect(node.Annotations).NotTo(HaveKey(reasonKey))
+		Expect(node.ManagedFields).To(ContainElement(SatisfyAll(
+			HaveField("Manager", "upgrade-test"), HaveField("Operation", metav1.ManagedFieldsOperationApply))))
+	})
+	It("CachedNodeUpgradeStateProvider should return the written node until the informer observes the write", func() {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		Expect(indexer.Add(node.DeepCopy())).To(Succeed())
+		provider := upgrade.NewCachedNodeUpgradeStateProvider(k8sClient, corev1listers.NewNodeLister(indexer), log,
+			eventRecorder)
+
+		Expect(provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateUpgradeRequired)).To(Succeed())
+		cachedNode, err := provider.GetNode(ctx, node.Name)
+		Expect(err).To(Succeed())
+		Expect(cachedNode.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+
+		// the informer observes a newer version of the node
+		latestNode := &corev1.Node{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: node.Name}, latestNode)).To(Succeed())
+		latestNode.Labels["example.com/pool"] = "gpu"
+		Expect(k8sClient.Update(ctx, latestNode)).To(Succeed())
+		Expect(indexer.Update(latestNode.DeepCopy())).To(Succeed())
+		cachedNode, err = provider.GetNode(ctx, node.Name)
+		Expect(err).To(Succeed())
+		Expect(cachedNode.Labels).To(HaveKeyWithValue("example.com/pool", "gpu"))
+		Expect(cachedNode.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+	})
+	It("CachedNodeUpgradeStateProvider should list the workload pods of the nodes with its pod lister", func() {
+		podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		workloadPod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "workload-" + id, Namespace: "default",
+				Labels: map[string]string{"app": "workload"}},
+			Spec: corev1.PodSpec{NodeName: node.Name},
+		}
+		otherNodePod := workloadPod.DeepCopy()
+		otherNodePod.Name = "other-node-workload-" + id
+		otherNodePod.Spec.NodeName = "other-node-" + id
+		Expect(podIndexer.Add(workloadPod)).To(Succeed())
+		Expect(podIndexer.Add(otherNodePod)).To(Succeed())
+		nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		provider := upgrade.NewCachedNodeUpgradeStateProvider(k8sClient, corev1listers.NewNodeLister(nodeIndexer), log,
+			eventRecorder).(*upgrade.CachedNodeUpgradeStateProvider).WithPodLister(corev1listers.NewPodLister(podIndexer))
+		stateManagerInterface, err := upgrade.NewClusterUpgradeStateManager(log, k8sConfig, eventRecorder)
+		Expect(err).NotTo(HaveOccurred())
+		stateManagerInterface.WithNodeUpgradeStateProvider(provider)
+		stateManager := stateManagerInterface.(*upgrade.ClusterUpgradeStateManagerImpl)
+		podManager := stateManager.PodManager.(*upgrade.PodManagerImpl)
+
+		// the pods are listed from the informer, they don't exist in the API server
+		podList, err := podManager.ListPods(ctx, "app=workload", node.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(podList.Items).To(ConsistOf(HaveField("Name", workloadPod.Name)))
+	})
+	It("NodeUpgradeStateProvider should retry the state change of a node updated concurrently", func() {
+		stateProvider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+		provider := stateProvider.(*upgrade.NodeUpgradeStateProviderImpl).WithStateStorage(upgrade.TaintStateStorage{})
+
+		// the node is updated, e.g. by another controller, after the provider got it
+		concurrentTaint := corev1.Taint{Key: "example.com/maintenance", Effect: corev1.TaintEffectNoSchedule}
+		updatedNode := node.DeepCopy()
+		updatedNode.Spec.Taints = []corev1.Taint{concurrentTaint}
+		Expect(k8sClient.Update(ctx, updatedNode)).To(Succeed())
+
+		Expect(provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateDrainRequired)).To(Succeed())
+		node, err := provider.GetNode(ctx, node.Name)
+		Expect(err).To(Succeed())
+		Expect(node.Spec.Taints).To(ConsistOf(concurrentTaint, corev1.Taint{
+			Key:    upgrade.GetUpgradeStateTaintKey(),
+			Value:  upgrade.UpgradeStateDrainRequired,
+			Effect: corev1.TaintEffectNoSchedule,
+		}))
+	})
+	It("NodeUpgradeStateProvider should retry the conflicting state write of a custom storage", func() {
+		storage := &conflictingStateStorage{NodeUpgradeStateStorage: upgrade.LabelStateStorage{}}
+		stateProvider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+		provider := stateProvider.(*upgrade.NodeUpgradeStateProviderImpl).WithStateStorage(storage)
+
+		Expect(provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateDrainRequired)).To(Succeed())
+		Expect(storage.writes).To(Equal(2))
+		node, err := provider.GetNode(ctx, node.Name)
+		Expect(err).To(Succeed())
+		Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateDrainRequired))
+	})
+	It("NodeUpgradeStateProvider should store node upgrade state in the state taint", func() {
+		storage := upgrade.TaintStateStorage{}
+		stateProvider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+		provider := stateProvider.(*upgrade.NodeUpgradeStateProviderImpl).WithStateStorage(storage)
+
+		otherTaint := corev1.Taint{Key: "example.com/dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}
+		node.Spec.Taints = []corev1.Taint{otherTaint}
+		Expect(k8sClient.Update(ctx, node)).To(Succeed())
+
+		err := provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateDrainRequired)
+		Expect(err).To(Succeed())
+		node, err = provider.GetNode(ctx, node.Name)
+		Expect(err).To(Succeed())
+		Expect(node.Spec.Taints).To(ConsistOf(otherTaint, corev1.Taint{
+			Key:    upgrade.GetUpgradeStateTaintKey(),
+			Value:  upgrade.UpgradeStateDrainRequired,
+			Effect: corev1.TaintEffectNoSchedule,
+		}))
+		Expect(storage.GetState(node)).To(Equal(upgrade.UpgradeStateDrainRequired))
+		Expect(node.Labels).To(HaveKeyWithValue(upgrade.GetUpgradeStateStoredLabelKey(), "true"))
+
+		// the states which don't restrict the scheduling are not stored in the taint
+		err = provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateDone)
+		Expect(err).To(Succeed())
+		node, err = provider.GetNode(ctx, node.Name)
+		Expect(err).To(Succeed())
+		Expect(node.Spec.Taints).To(ConsistOf(otherTaint))
+		Expect(storage.GetState(node)).To(Equal(upgrade.UpgradeStateDone))
+	})
 })
+
+type recordingNodeWriteAuditSink struct {
+	records []upgrade.NodeWriteRecord
+}
+
+func (s *recordingNodeWriteAuditSink) RecordNodeWrite(_ context.Context, record upgrade.NodeWriteRecord) {
+	s.records = append(s.records, record)
+}
+
+// conflictingStateStorage is a custom storage whose first write of the state returns a conflict error
+type conflictingStateStorage struct {
+	upgrade.NodeUpgradeStateStorage
+	writes int
+}
+
+func (s *conflictingStateStorage) SetState(ctx context.Context, k8sClient client.Client, node *corev1.Node,
+	state string) error {
+	s.writes++
+	if s.writes == 1 {
+		return apierrors.NewConflict(corev1.Resource("nodes"), node.Name, fmt.Errorf("node was modified"))
+	}
+	return s.NodeUpgradeStateStorage.SetState(ctx, k8sClient, node, state)
+}
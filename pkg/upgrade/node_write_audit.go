@@ -0,0 +1,166 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// NodeMetadataChange is the change of a single node label or annotation.
+// Before is nil if the key was added, After is nil if the key was removed.
+type NodeMetadataChange struct {
+	Key    string
+	Before *string
+	After  *string
+}
+
+// String returns the change in the `key: "before" -> "after"` form
+func (c NodeMetadataChange) String() string {
+	format := func(value *string) string {
+		if value == nil {
+			return "<none>"
+		}
+		return fmt.Sprintf("%q", *value)
+	}
+	return fmt.Sprintf("%s: %s -> %s", c.Key, format(c.Before), format(c.After))
+}
+
+// NodeWriteRecord describes the labels and annotations changed by a single node write
+type NodeWriteRecord struct {
+	// NodeName is the name of the written node
+	NodeName string
+	// Operation is the write operation, "patch" or "update"
+	Operation string
+	// Labels are the changed labels, sorted by key
+	Labels []NodeMetadataChange
+	// Annotations are the changed annotations, sorted by key
+	Annotations []NodeMetadataChange
+}
+
+// NodeWriteAuditSink receives the records of the node writes performed by the upgrade library
+type NodeWriteAuditSink interface {
+	RecordNodeWrite(ctx context.Context, record NodeWriteRecord)
+}
+
+// nodeWriteAuditClient is a client.Client which logs at Debug level the labels and annotations changed by every
+// node patch and update, and passes them to the audit sink if set.
+// The state before the write is the node object as known to the caller.
+type nodeWriteAuditClient struct {
+	client.Client
+	log  logr.Logger
+	sink NodeWriteAuditSink
+}
+
+// NewNodeWriteAuditClient returns a client.Client which logs at Debug level the labels and annotations changed
+// by every node write done with it and passes them to the sink, if it is not nil
+func NewNodeWriteAuditClient(c client.Client, log logr.Logger, sink NodeWriteAuditSink) client.Client {
+	return &nodeWriteAuditClient{Client: c, log: log, sink: sink}
+}
+
+// Patch implements client.Client
+func (c *nodeWriteAuditClient) Patch(ctx context.Context, obj client.Object, patch client.Patch,
+	opts ...client.PatchOption) error {
+	node, ok := obj.(*corev1.Node)
+	if !ok || !c.enabled() {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	before := node.DeepCopy()
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	if err == nil {
+		c.audit(ctx, "patch", before, node)
+	}
+	return err
+}
+
+// Update implements client.Client
+func (c *nodeWriteAuditClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	node, ok := obj.(*corev1.Node)
+	if !ok || !c.enabled() {
+		return c.Client.Update(ctx, obj, opts...)
+	}
+	before := node.DeepCopy()
+	err := c.Client.Update(ctx, obj, opts...)
+	if err == nil {
+		c.audit(ctx, "update", before, node)
+	}
+	return err
+}
+
+// enabled returns true if node writes are logged or recorded
+func (c *nodeWriteAuditClient) enabled() bool {
+	return c.sink != nil || c.log.V(consts.LogLevelDebug).Enabled()
+}
+
+// audit logs and records the labels and annotations changed by the node write
+func (c *nodeWriteAuditClient) audit(ctx context.Context, operation string, before, after *corev1.Node) {
+	record := NodeWriteRecord{
+		NodeName:    after.Name,
+		Operation:   operation,
+		Labels:      diffNodeMetadata(before.Labels, after.Labels),
+		Annotations: diffNodeMetadata(before.Annotations, after.Annotations),
+	}
+	if len(record.Labels) == 0 && len(record.Annotations) == 0 {
+		return
+	}
+	c.log.V(consts.LogLevelDebug).Info("Node labels and annotations changed", "node", record.NodeName,
+		"operation", operation, "labels", record.Labels, "annotations", record.Annotations)
+	if c.sink != nil {
+		c.sink.RecordNodeWrite(ctx, record)
+	}
+}
+
+// diffNodeMetadata returns the changes between two label or annotation maps, sorted by key
+func diffNodeMetadata(before, after map[string]string) []NodeMetadataChange {
+	var changes []NodeMetadataChange
+	for key, beforeValue := range before {
+		beforeValue := beforeValue
+		afterValue, ok := after[key]
+		switch {
+		case !ok:
+			changes = append(changes, NodeMetadataChange{Key: key, Before: &beforeValue})
+		case afterValue != beforeValue:
+			changes = append(changes, NodeMetadataChange{Key: key, Before: &beforeValue, After: &afterValue})
+		}
+	}
+	for key, afterValue := range after {
+		afterValue := afterValue
+		if _, ok := before[key]; !ok {
+			changes = append(changes, NodeMetadataChange{Key: key, After: &afterValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// WithNodeWriteAuditSink provides an option to pass the labels and annotations changed by every node write
+// of the upgrade library to the sink. The changes are also logged at Debug level regardless of the sink.
+func (m *ClusterUpgradeStateManagerImpl) WithNodeWriteAuditSink(sink NodeWriteAuditSink) ClusterUpgradeStateManager {
+	if m.nodeWriteAudit == nil {
+		m.Log.V(consts.LogLevelWarning).Info("Cannot set node write audit sink, the k8s client is not audited")
+		return m
+	}
+	m.nodeWriteAudit.sink = sink
+	return m
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// UpgradeEvent is a key event of the upgrade lifecycle sent to the Notifier
+type UpgradeEvent string
+
+const (
+	// UpgradeEventStarted is sent when an upgrade session starts, i.e. the first node needs an upgrade
+	// for a new generation of the driver DaemonSets
+	UpgradeEventStarted UpgradeEvent = "UpgradeStarted"
+	// UpgradeEventNodeFailed is sent when a node moves to the upgrade-failed state, once per node and session
+	UpgradeEventNodeFailed UpgradeEvent = "NodeUpgradeFailed"
+	// UpgradeEventCompleted is sent when an upgrade session completes, i.e. all the nodes are done,
+	// or when it is superseded by a newer generation of the driver DaemonSets
+	UpgradeEventCompleted UpgradeEvent = "UpgradeCompleted"
+)
+
+// notificationTimeout is the maximum duration of a notification, the pass is not blocked longer by the Notifier
+const notificationTimeout = 10 * time.Second
+
+// UpgradeNotification is an upgrade lifecycle event sent to the Notifier
+type UpgradeNotification struct {
+	// Event is the type of the event
+	Event UpgradeEvent
+	// Session is the upgrade session of the event, see UpgradeSession
+	Session UpgradeSession
+	// Node is the name of the failed node for UpgradeEventNodeFailed, empty otherwise
+	Node string
+}
+
+// Message returns a human-readable description of the notification
+func (n UpgradeNotification) Message() string {
+	scope := "the cluster"
+	if n.Session.Scope != "" {
+		scope = n.Session.Scope
+	}
+	switch n.Event {
+	case UpgradeEventStarted:
+		return fmt.Sprintf("Driver upgrade of %s to %s started", scope, n.Session.Generation)
+	case UpgradeEventNodeFailed:
+		return fmt.Sprintf("Driver upgrade of node %s failed during the upgrade of %s to %s",
+			n.Node, scope, n.Session.Generation)
+	case UpgradeEventCompleted:
+		status := "completed"
+		if n.Session.Superseded {
+			status = "superseded"
+		}
+		message := fmt.Sprintf("Driver upgrade of %s to %s %s after %s: %d nodes upgraded", scope,
+			n.Session.Generation, status, n.Session.Duration().Round(time.Second), len(n.Session.Nodes))
+		if len(n.Session.FailedNodes) > 0 {
+			message += fmt.Sprintf(", failed nodes: %s", strings.Join(n.Session.FailedNodes, ", "))
+		}
+		return message
+	}
+	return fmt.Sprintf("Driver upgrade event %s for %s", n.Event, scope)
+}
+
+// Notifier sends the key events of the upgrade lifecycle to an external sink, e.g. a chat channel or
+// an incident management system
+type Notifier interface {
+	// Notify sends the notification, it is called synchronously at the end of the ApplyState pass
+	Notify(ctx context.Context, notification UpgradeNotification) error
+}
+
+// NotifierFunc is an adapter to use a function as a Notifier
+type NotifierFunc func(ctx context.Context, notification UpgradeNotification) error
+
+// Notify calls f(ctx, notification)
+func (f NotifierFunc) Notify(ctx context.Context, notification UpgradeNotification) error {
+	return f(ctx, notification)
+}
+
+// webhookPayload is the JSON body posted by the webhook Notifier. The text field makes it compatible
+// with the Slack incoming webhooks.
+type webhookPayload struct {
+	Text        string       `json:"text"`
+	Event       UpgradeEvent `json:"event"`
+	Scope       string       `json:"scope,omitempty"`
+	Generation  string       `json:"generation"`
+	Node        string       `json:"node,omitempty"`
+	StartTime   time.Time    `json:"startTime"`
+	Nodes       []string     `json:"nodes,omitempty"`
+	FailedNodes []string     `json:"failedNodes,omitempty"`
+	Superseded  bool         `json:"superseded,omitempty"`
+}
+
+// NewWebhookNotifier returns a Notifier sending an HTTP POST request with a JSON description of the event to
+// webhookURL, e.g. a Slack incoming webhook, the message of the event is in the text field. The request fails if
+// the status of the response is not 2xx. http.DefaultClient is used if client is nil.
+// The path and query of webhookURL often hold a secret, the errors only mention its scheme and host.
+func NewWebhookNotifier(client *http.Client, webhookURL string) Notifier {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return NotifierFunc(func(ctx context.Context, notification UpgradeNotification) error {
+		body, err := json.Marshal(webhookPayload{
+			Text:        notification.Message(),
+			Event:       notification.Event,
+			Scope:       notification.Session.Scope,
+			Generation:  notification.Session.Generation,
+			Node:        notification.Node,
+			StartTime:   notification.Session.StartTime,
+			Nodes:       notification.Session.Nodes,
+			FailedNodes: notification.Session.FailedNodes,
+			Superseded:  notification.Session.Superseded,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode the notification: %v", err)
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("invalid notification request: %v", unwrapURLError(err))
+		}
+		request.Header.Set("Content-Type", "application/json")
+		response, err := client.Do(request)
+		if err != nil {
+			return fmt.Errorf("notification request to %s://%s failed: %v",
+				request.URL.Scheme, request.URL.Host, unwrapURLError(err))
+		}
+		defer response.Body.Close()
+		if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+			return fmt.Errorf("notification request to %s://%s returned %s",
+				request.URL.Scheme, request.URL.Host, response.Status)
+		}
+		return nil
+	})
+}
+
+// unwrapURLError returns the cause of a *url.Error, which otherwise holds the full URL of the request
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// WithNotifier provides an option to send the key events of the upgrade lifecycle to the notifiers: the start
+// of an upgrade session, the failure of a node and the completion of the session, see WithUpgradeSessionHooks
+// for the detection of the sessions. A failed notification is logged and doesn't fail the pass.
+func (m *ClusterUpgradeStateManagerImpl) WithNotifier(notifiers ...Notifier) ClusterUpgradeStateManager {
+	m.notifiers = append(m.notifiers, notifiers...)
+	if m.upgradeSessions == nil {
+		m.upgradeSessions = &upgradeSessionTracker{}
+	}
+	return m
+}
+
+// notify sends the notification to all the notifiers and logs their errors
+func (m *ClusterUpgradeStateManagerImpl) notify(ctx context.Context, notification UpgradeNotification) {
+	for _, notifier := range m.notifiers {
+		notifyCtx, cancel := context.WithTimeout(ctx, notificationTimeout)
+		err := notifier.Notify(notifyCtx, notification)
+		cancel()
+		if err != nil {
+			m.Log.V(consts.LogLevelWarning).Info("Failed to send the upgrade notification",
+				"event", notification.Event, "scope", notification.Session.Scope, "error", err)
+		}
+	}
+}
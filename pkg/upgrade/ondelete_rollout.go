@@ -0,0 +1,137 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// onDeleteRollout is the configuration of the rollout of the driver DaemonSets with the OnDelete update strategy
+type onDeleteRollout struct {
+	// pendingTimeout is the time a replacement driver pod may stay Pending before the node is moved to
+	// the upgrade-failed state, zero means infinite
+	pendingTimeout time.Duration
+}
+
+// WithOnDeleteRollout provides an option to coordinate the rollout of driver DaemonSets with the OnDelete update
+// strategy: the state manager deletes the outdated driver pod of a node in the pod-restart-required state and waits
+// for the DaemonSet controller to create its replacement. The outdated driver pods of DaemonSets with the
+// RollingUpdate strategy are left to the DaemonSet controller. A replacement pod which can't be scheduled is
+// tracked on the node it was created for, and the node is moved to the upgrade-failed state once its replacement
+// pod stayed Pending for longer than pendingTimeout, e.g. unschedulable or unable to pull its image.
+// Zero timeout means infinite.
+func (m *ClusterUpgradeStateManagerImpl) WithOnDeleteRollout(pendingTimeout time.Duration) ClusterUpgradeStateManager {
+	if pendingTimeout < 0 {
+		m.Log.V(consts.LogLevelWarning).Info("Ignoring negative pending driver pod timeout", "timeout", pendingTimeout)
+		pendingTimeout = 0
+	}
+	m.onDeleteRollout = &onDeleteRollout{pendingTimeout: pendingTimeout}
+	return m
+}
+
+// isDriverPodRestartedByStateManager returns false if the outdated driver pods of the DaemonSet are replaced
+// by the DaemonSet controller, according to WithOnDeleteRollout
+func (m *ClusterUpgradeStateManagerImpl) isDriverPodRestartedByStateManager(ds *appsv1.DaemonSet) bool {
+	return m.onDeleteRollout == nil || ds == nil || ds.Spec.UpdateStrategy.Type != appsv1.RollingUpdateDaemonSetStrategyType
+}
+
+// getUnscheduledDriverPodNode returns the node the DaemonSet controller created the unscheduled driver pod for,
+// from the node affinity of the pod, if WithOnDeleteRollout is enabled. It returns an empty string otherwise.
+func (m *ClusterUpgradeStateManagerImpl) getUnscheduledDriverPodNode(pod *corev1.Pod, ds *appsv1.DaemonSet) string {
+	if m.onDeleteRollout == nil || ds == nil || pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+		return ""
+	}
+	nodeSelector := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if nodeSelector == nil {
+		return ""
+	}
+	// the DaemonSet controller pins its pods with a single metadata.name field requirement
+	for _, term := range nodeSelector.NodeSelectorTerms {
+		for _, requirement := range term.MatchFields {
+			if requirement.Key == nodeNameField && requirement.Operator == corev1.NodeSelectorOpIn &&
+				len(requirement.Values) == 1 {
+				return requirement.Values[0]
+			}
+		}
+	}
+	return ""
+}
+
+// isDriverPodStuckPending returns true and the cause if the driver pod of the node stayed Pending for longer than
+// the pending timeout of WithOnDeleteRollout
+func (m *ClusterUpgradeStateManagerImpl) isDriverPodStuckPending(nodeState *NodeUpgradeState, now time.Time) (
+	bool, string) {
+	pod := nodeState.DriverPod
+	if m.onDeleteRollout == nil || m.onDeleteRollout.pendingTimeout == 0 || pod == nil ||
+		pod.Status.Phase != corev1.PodPending || !pod.DeletionTimestamp.IsZero() ||
+		now.Sub(pod.CreationTimestamp.Time) < m.onDeleteRollout.pendingTimeout {
+		return false, ""
+	}
+	return true, getPendingPodCause(pod)
+}
+
+// getPendingPodCause describes why the pod is Pending, from its scheduling condition or its waiting containers
+func getPendingPodCause(pod *corev1.Pod) string {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+			return fmt.Sprintf("%s: %s", condition.Reason, condition.Message)
+		}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...),
+		pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			return fmt.Sprintf("container %s is waiting: %s", status.Name, status.State.Waiting.Reason)
+		}
+	}
+	return "unknown"
+}
+
+// failStuckPendingDriverPod moves the node to the upgrade-failed state if its replacement driver pod is stuck
+// Pending, see WithOnDeleteRollout. It returns true if the node was moved.
+func (m *ClusterUpgradeStateManagerImpl) failStuckPendingDriverPod(ctx context.Context,
+	nodeState *NodeUpgradeState) (bool, error) {
+	stuck, cause := m.isDriverPodStuckPending(nodeState, time.Now())
+	if !stuck {
+		return false, nil
+	}
+	node := nodeState.Node
+	m.Log.V(consts.LogLevelWarning).Info("Replacement driver pod is stuck Pending, moving node to failed state",
+		"node", node.Name, "pod", nodeState.DriverPod.Name, "cause", cause)
+	logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+		"Replacement driver pod %s stayed Pending for more than %s: %s", nodeState.DriverPod.Name,
+		m.onDeleteRollout.pendingTimeout, cause)
+	err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "node", node.Name, "state", UpgradeStateFailed)
+		return false, err
+	}
+	err = m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonDriverPodPending)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to set node upgrade state reason", "node", node.Name)
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// OrphanedPodPolicy defines how the upgrade state manager handles the nodes running an orphaned driver pod,
+// i.e. a driver pod without an owner DaemonSet or whose DaemonSet was deleted
+type OrphanedPodPolicy string
+
+const (
+	// OrphanedPodPolicySkip leaves the node as is, it is upgraded only when the upgrade requested annotation
+	// is set on it. This is the default.
+	OrphanedPodPolicySkip OrphanedPodPolicy = "Skip"
+	// OrphanedPodPolicyDelete upgrades the node: it is cordoned and drained as the upgrade policy requires,
+	// then the orphaned driver pod is deleted
+	OrphanedPodPolicyDelete OrphanedPodPolicy = "Delete"
+	// OrphanedPodPolicyFail moves the node to the upgrade-failed state, until the orphaned driver pod is
+	// replaced by a pod of a driver DaemonSet
+	OrphanedPodPolicyFail OrphanedPodPolicy = "Fail"
+)
+
+// WithOrphanedPodPolicy provides an option to change how the nodes in the done or unknown state running
+// an orphaned driver pod are handled, see OrphanedPodPolicy
+func (m *ClusterUpgradeStateManagerImpl) WithOrphanedPodPolicy(policy OrphanedPodPolicy) ClusterUpgradeStateManager {
+	m.orphanedPodPolicy = policy
+	return m
+}
+
+// getDeletedDaemonSetPods returns the pods owned by a DaemonSet which doesn't exist anymore, e.g. deleted with
+// the orphan propagation policy before the garbage collector removed the owner references of its pods.
+// The pods owned by a DaemonSet which still exists, but doesn't match the driver selector, are ignored.
+func (m *ClusterUpgradeStateManagerImpl) getDeletedDaemonSetPods(ctx context.Context, pods []corev1.Pod,
+	daemonSets map[types.UID]*appsv1.DaemonSet) ([]corev1.Pod, error) {
+	podList := []corev1.Pod{}
+	for i := range pods {
+		pod := &pods[i]
+		if isOrphanedPod(pod) {
+			continue
+		}
+		owner := pod.OwnerReferences[0]
+		if _, ok := daemonSets[owner.UID]; ok || owner.Kind != "DaemonSet" {
+			continue
+		}
+		ds := &appsv1.DaemonSet{}
+		err := m.K8sClient.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name}, ds)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return nil, fmt.Errorf("error getting DaemonSet %s of pod %s: %v", owner.Name, pod.Name, err)
+		}
+		if err == nil && ds.UID == owner.UID {
+			continue
+		}
+		m.Log.V(consts.LogLevelInfo).Info("Driver DaemonSet of the pod was deleted", "pod", pod.Name,
+			"daemonset", owner.Name)
+		podList = append(podList, *pod)
+	}
+	return podList, nil
+}
+
+// failOrphanedPodNode moves a node running an orphaned driver pod to the upgrade-failed state,
+// see OrphanedPodPolicyFail
+func (m *ClusterUpgradeStateManagerImpl) failOrphanedPodNode(ctx context.Context, nodeState *NodeUpgradeState) error {
+	node := nodeState.Node
+	m.Log.V(consts.LogLevelInfo).Info("Node runs an orphaned driver pod, moving it to failed state",
+		"node", node.Name, "pod", nodeState.DriverPod.Name)
+	logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+		"The driver pod %s has no driver DaemonSet, moving the node to %s state", nodeState.DriverPod.Name,
+		UpgradeStateFailed)
+	err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "node", node.Name, "state", UpgradeStateFailed)
+		return err
+	}
+	return m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonOrphanedDriverPod)
+}
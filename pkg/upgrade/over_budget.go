@@ -0,0 +1,62 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// WithPauseWhenOverBudget provides an option to hold back the nodes about to be cordoned while more upgrades
+// are in progress than maxParallelUpgrades allows, e.g. after the limit was lowered. By default the upgrades
+// already started are completed and only the start of new upgrades is prevented.
+func (m *ClusterUpgradeStateManagerImpl) WithPauseWhenOverBudget(pause bool) ClusterUpgradeStateManager {
+	m.pauseWhenOverBudget = pause
+	return m
+}
+
+// holdOverBudgetNodes sets the OverBudget reason on the last overBudget nodes of the cordon-required state,
+// which are not cordoned yet, and returns the state without them. The other nodes are processed,
+// so that the upgrades within maxParallelUpgrades progress.
+func (m *ClusterUpgradeStateManagerImpl) holdOverBudgetNodes(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, overBudget int) (*ClusterUpgradeState, error) {
+	nodeStates := currentClusterState.NodeStates[UpgradeStateCordonRequired]
+	held := 0
+	withinBudget := make([]*NodeUpgradeState, 0, len(nodeStates))
+	for i := len(nodeStates) - 1; i >= 0; i-- {
+		node := nodeStates[i].Node
+		if held == overBudget || isNodeUnschedulable(node) {
+			withinBudget = append([]*NodeUpgradeState{nodeStates[i]}, withinBudget...)
+			continue
+		}
+		m.Log.V(consts.LogLevelInfo).Info("Upgrades over budget, holding node back", "node", node.Name)
+		err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonOverBudget)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to set node upgrade state reason", "node", node.Name)
+			return nil, err
+		}
+		held++
+	}
+
+	withinBudgetState := NewClusterUpgradeState()
+	for state, states := range currentClusterState.NodeStates {
+		withinBudgetState.NodeStates[state] = states
+	}
+	withinBudgetState.NodeStates[UpgradeStateCordonRequired] = withinBudget
+	return &withinBudgetState, nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// pdbBudget is the count of disruptions a PodDisruptionBudget still allows during the selection of the nodes
+type pdbBudget struct {
+	namespace string
+	selector  labels.Selector
+	remaining int32
+}
+
+// selectByPDBs orders the nodes of the upgrade-required state by the PodDisruptionBudgets of their pods, if the
+// upgrade policy enables the PDB aware selection and the drain: the nodes whose drain is allowed by the budgets
+// come first, in their original order, followed by the nodes whose drain would be blocked. The budgets are shared
+// by the nodes in order, as the selected nodes are drained in parallel. If DeferBlockedNodes is set, the blocked
+// nodes are not returned and wait with the WaitingForPDB reason. Cordoned nodes and nodes marked for skipping
+// upgrades keep their position.
+func (m *ClusterUpgradeStateManagerImpl) selectByPDBs(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) (
+	*ClusterUpgradeState, error) {
+	selection := upgradePolicy.PDBAwareSelection
+	nodeStates := currentClusterState.NodeStates[UpgradeStateUpgradeRequired]
+	if selection == nil || !isDrainEnabled(upgradePolicy) || len(nodeStates) == 0 {
+		return currentClusterState, nil
+	}
+	m.Log.V(consts.LogLevelInfo).Info("SelectByPDBs")
+
+	budgets, err := m.getPDBBudgets(ctx)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to list PodDisruptionBudgets")
+		return nil, err
+	}
+	drainSelector, err := labels.Parse(upgradePolicy.DrainSpec.PodSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid drain pod selector: %v", err)
+	}
+
+	allowed := make([]*NodeUpgradeState, 0, len(nodeStates))
+	blocked := make([]*NodeUpgradeState, 0)
+	for _, nodeState := range nodeStates {
+		node := nodeState.Node
+		if len(budgets) == 0 || m.skipNodeUpgrade(node) {
+			allowed = append(allowed, nodeState)
+			continue
+		}
+		disruptions, err := m.getNodePDBDisruptions(ctx, node, budgets, drainSelector,
+			upgradePolicy.DrainSpec.PriorityThreshold)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to get the pods of the node", "node", node.Name)
+			return nil, err
+		}
+		isBlocked := false
+		for pdb, count := range disruptions {
+			if count > budgets[pdb].remaining {
+				isBlocked = true
+				break
+			}
+		}
+		// cordoned nodes are started anyway, as they are already unavailable
+		if isBlocked && !m.isNodeUnschedulable(node) {
+			m.Log.V(consts.LogLevelInfo).Info("Node drain would be blocked by a PodDisruptionBudget",
+				"node", node.Name, "defer", selection.DeferBlockedNodes)
+			blocked = append(blocked, nodeState)
+			continue
+		}
+		for pdb, count := range disruptions {
+			budgets[pdb].remaining = max(budgets[pdb].remaining-count, 0)
+		}
+		allowed = append(allowed, nodeState)
+	}
+
+	if selection.DeferBlockedNodes {
+		for _, nodeState := range blocked {
+			err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, nodeState.Node,
+				UpgradeStateReasonWaitingForPDB)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Failed to set node upgrade state reason",
+					"node", nodeState.Node.Name)
+				return nil, err
+			}
+		}
+		blocked = nil
+	}
+	selectedState := NewClusterUpgradeState()
+	for state, states := range currentClusterState.NodeStates {
+		selectedState.NodeStates[state] = states
+	}
+	selectedState.NodeStates[UpgradeStateUpgradeRequired] = append(allowed, blocked...)
+	return &selectedState, nil
+}
+
+// getPDBBudgets returns the PodDisruptionBudgets of the cluster by namespace/name
+func (m *ClusterUpgradeStateManagerImpl) getPDBBudgets(ctx context.Context) (map[string]*pdbBudget, error) {
+	pdbs, err := m.K8sInterface.PolicyV1().PodDisruptionBudgets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	budgets := make(map[string]*pdbBudget, len(pdbs.Items))
+	for i := range pdbs.Items {
+		pdb := &pdbs.Items[i]
+		// a nil selector selects no pod, an empty selector all the pods of the namespace
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			m.Log.V(consts.LogLevelWarning).Info("Ignoring PodDisruptionBudget with an invalid selector",
+				"pdb", pdb.Namespace+"/"+pdb.Name, "error", err.Error())
+			continue
+		}
+		budgets[pdb.Namespace+"/"+pdb.Name] = &pdbBudget{
+			namespace: pdb.Namespace,
+			selector:  selector,
+			remaining: pdb.Status.DisruptionsAllowed,
+		}
+	}
+	return budgets, nil
+}
+
+// getNodePDBDisruptions returns the count of pods of the node evicted by the drain for every PodDisruptionBudget
+// selecting them
+func (m *ClusterUpgradeStateManagerImpl) getNodePDBDisruptions(ctx context.Context, node *corev1.Node,
+	budgets map[string]*pdbBudget, drainSelector labels.Selector, priorityThreshold *int32) (map[string]int32, error) {
+	pods, err := m.getNodeDrainedPods(ctx, node, drainSelector, priorityThreshold)
+	if err != nil {
+		return nil, err
+	}
+	disruptions := make(map[string]int32)
+	for _, pod := range pods {
+		for key, budget := range budgets {
+			if budget.namespace == pod.Namespace && budget.selector.Matches(labels.Set(pod.Labels)) {
+				disruptions[key]++
+			}
+		}
+	}
+	return disruptions, nil
+}
+
+// getNodeDrainedPods returns the pods of the node evicted by the drain with the given pod selector
+// and priority threshold
+func (m *ClusterUpgradeStateManagerImpl) getNodeDrainedPods(ctx context.Context, node *corev1.Node,
+	drainSelector labels.Selector, priorityThreshold *int32) ([]corev1.Pod, error) {
+	pods, err := m.K8sInterface.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf(nodeNameFieldSelectorFmt, node.Name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	drainedPods := make([]corev1.Pod, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != node.Name || pod.Status.Phase == corev1.PodSucceeded ||
+			pod.Status.Phase == corev1.PodFailed || isStaticPod(pod) || isDaemonSetPod(pod) ||
+			isPodInProtectedNamespace(pod, m.protectedNamespaces) || !drainSelector.Matches(labels.Set(pod.Labels)) ||
+			isPodAbovePriorityThreshold(pod, priorityThreshold) {
+			// these pods are not evicted by the drain
+			continue
+		}
+		drainedPods = append(drainedPods, pod)
+	}
+	return drainedPods, nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// APIErrorClass is the class of an error returned by the Kubernetes API server, it determines how the error
+// of an upgrade phase is handled
+type APIErrorClass string
+
+const (
+	// APIErrorClassNotFound is an object deleted during the pass, e.g. a node removed from the cluster.
+	// The rest of the phase is skipped, the pass continues with the next phase.
+	APIErrorClassNotFound APIErrorClass = "NotFound"
+	// APIErrorClassConflict is an object changed concurrently, e.g. a node updated by another controller.
+	// The rest of the phase is skipped, the nodes are processed again with their new version on the next pass.
+	APIErrorClassConflict APIErrorClass = "Conflict"
+	// APIErrorClassForbidden is a request rejected by the RBAC rules of the operator. The pass is stopped,
+	// as the following passes would fail the same way until the rules are fixed.
+	APIErrorClassForbidden APIErrorClass = "Forbidden"
+	// APIErrorClassTimeout is a request which timed out or was throttled by the API server. The pass continues
+	// with the next phase and returns the error at its end, so that the controller requeues the pass with backoff.
+	APIErrorClassTimeout APIErrorClass = "Timeout"
+	// APIErrorClassOther is any other error, the pass is stopped and the error is returned
+	APIErrorClassOther APIErrorClass = "Other"
+)
+
+// PhaseError is an error of an upgrade phase of an ApplyState pass
+type PhaseError struct {
+	// Phase is the upgrade state processed by the phase
+	Phase string
+	// Class is the class of the error
+	Class APIErrorClass
+	// Err is the error of the phase
+	Err error
+}
+
+// ClassifyAPIError returns the class of the error returned by the Kubernetes API server, APIErrorClassOther
+// for errors which are not API errors, including API errors wrapped without %w
+func ClassifyAPIError(err error) APIErrorClass {
+	switch {
+	case k8serrors.IsNotFound(err):
+		return APIErrorClassNotFound
+	case k8serrors.IsConflict(err):
+		return APIErrorClassConflict
+	case k8serrors.IsForbidden(err), k8serrors.IsUnauthorized(err):
+		return APIErrorClassForbidden
+	case k8serrors.IsTimeout(err), k8serrors.IsServerTimeout(err), k8serrors.IsTooManyRequests(err),
+		k8serrors.IsServiceUnavailable(err):
+		return APIErrorClassTimeout
+	default:
+		return APIErrorClassOther
+	}
+}
+
+// phaseErrors collects the errors of the phases of an ApplyState pass
+type phaseErrors struct {
+	errors []PhaseError
+}
+
+// handlePhaseErrors returns a process function which calls process and handles its error by class, see APIErrorClass.
+// It returns nil for the errors which don't stop the pass.
+func (m *ClusterUpgradeStateManagerImpl) handlePhaseErrors(errs *phaseErrors, phase string,
+	process func() error) func() error {
+	return func() error {
+		err := process()
+		if err == nil {
+			return nil
+		}
+		class := ClassifyAPIError(err)
+		errs.errors = append(errs.errors, PhaseError{Phase: phase, Class: class, Err: err})
+		switch class {
+		case APIErrorClassNotFound, APIErrorClassConflict:
+			m.Log.V(consts.LogLevelInfo).Info("Skipping the rest of the phase, the nodes are processed again "+
+				"on the next pass", "phase", phase, "class", class, "error", err.Error())
+			return nil
+		case APIErrorClassTimeout:
+			m.Log.V(consts.LogLevelWarning).Info("Phase failed with a transient error, continuing the pass",
+				"phase", phase, "error", err.Error())
+			return nil
+		case APIErrorClassForbidden:
+			return fmt.Errorf("%s phase is not permitted, check the RBAC rules of the operator: %v", phase, err)
+		default:
+			return err
+		}
+	}
+}
+
+// retryError returns the transient errors of the pass, nil if there is none
+func (e *phaseErrors) retryError() error {
+	var errs []error
+	for _, phaseErr := range e.errors {
+		if phaseErr.Class == APIErrorClassTimeout {
+			errs = append(errs, fmt.Errorf("%s phase: %v", phaseErr.Phase, phaseErr.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
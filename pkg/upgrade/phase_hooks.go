@@ -0,0 +1,83 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PhaseHook is called by ApplyState before or after the nodes in an upgrade state are processed.
+// phase is the name of the upgrade state, e.g. UpgradeStateDrainRequired, and nodes are the nodes
+// in that state, which the hook must not modify. An error returned by the hook aborts ApplyState.
+type PhaseHook func(ctx context.Context, phase string, nodes []*corev1.Node) error
+
+// WithBeforePhaseHook registers a hook called before the nodes of every upgrade state are processed.
+// Hooks are called in the order of registration.
+func (m *ClusterUpgradeStateManagerImpl) WithBeforePhaseHook(hook PhaseHook) ClusterUpgradeStateManager {
+	m.beforePhaseHooks = append(m.beforePhaseHooks, hook)
+	return m
+}
+
+// WithAfterPhaseHook registers a hook called after the nodes of every upgrade state were processed successfully.
+// Hooks are called in the order of registration.
+func (m *ClusterUpgradeStateManagerImpl) WithAfterPhaseHook(hook PhaseHook) ClusterUpgradeStateManager {
+	m.afterPhaseHooks = append(m.afterPhaseHooks, hook)
+	return m
+}
+
+// runPhase calls process to handle the nodes in the phase upgrade state of currentState,
+// surrounded by the registered phase hooks. Nodes held in the state by the state hooks are not processed.
+// The processed nodes are verified in strict mode, see WithStrictMode.
+// The node events summarized during the phase are recorded afterwards. The API errors of process are handled
+// by class and collected in passErrors, see APIErrorClass.
+func (m *ClusterUpgradeStateManagerImpl) runPhase(ctx context.Context, currentState *ClusterUpgradeState,
+	passErrors *phaseErrors, phase string, process func() error) error {
+	defer m.measurePhase(phase)()
+	defer m.flushSummarizedEvents(phase)
+	if m.strictMode {
+		process = m.withPostConditionCheck(ctx, currentState, phase, process)
+	}
+	process = m.handlePhaseErrors(passErrors, phase, process)
+	if m.stateHookHolds != nil {
+		process = m.withStateHooks(ctx, currentState, phase, process)
+	}
+	if len(m.beforePhaseHooks) == 0 && len(m.afterPhaseHooks) == 0 {
+		return process()
+	}
+	nodes := make([]*corev1.Node, 0, len(currentState.NodeStates[phase]))
+	for _, nodeState := range currentState.NodeStates[phase] {
+		nodes = append(nodes, nodeState.Node)
+	}
+
+	for _, hook := range m.beforePhaseHooks {
+		if err := hook(ctx, phase, nodes); err != nil {
+			return fmt.Errorf("before phase hook failed for phase %s: %v", phase, err)
+		}
+	}
+	if err := process(); err != nil {
+		return err
+	}
+	for _, hook := range m.afterPhaseHooks {
+		if err := hook(ctx, phase, nodes); err != nil {
+			return fmt.Errorf("after phase hook failed for phase %s: %v", phase, err)
+		}
+	}
+	return nil
+}
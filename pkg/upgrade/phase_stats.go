@@ -0,0 +1,77 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// PhaseStat is the wall-clock duration and the count of API requests of an upgrade phase of an ApplyState pass,
+// e.g. to find the phase which dominates the reconcile time in a large cluster
+type PhaseStat struct {
+	// Phase is the upgrade state processed by the phase
+	Phase string
+	// Duration is the wall-clock duration of the phase, including its phase and state hooks
+	Duration time.Duration
+	// APICalls is the count of requests sent to the API server during the phase, including the requests of
+	// the drains and pod restarts running in the background meanwhile. It is always 0 if the manager was not created
+	// with NewClusterUpgradeStateManager, as the requests are counted by its clients.
+	APICalls int64
+}
+
+// apiCallCounter counts the requests sent to the API server by the clients of the manager
+type apiCallCounter struct {
+	calls atomic.Int64
+}
+
+// count returns the count of requests sent so far, 0 if the counter is nil
+func (c *apiCallCounter) count() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.calls.Load()
+}
+
+// wrap returns a round tripper counting the requests sent with rt, it is a transport.WrapperFunc
+func (c *apiCallCounter) wrap(rt http.RoundTripper) http.RoundTripper {
+	return &countingRoundTripper{delegate: rt, counter: c}
+}
+
+// countingRoundTripper is an http.RoundTripper counting the requests in an apiCallCounter
+type countingRoundTripper struct {
+	delegate http.RoundTripper
+	counter  *apiCallCounter
+}
+
+// RoundTrip implements http.RoundTripper
+func (rt *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.counter.calls.Add(1)
+	return rt.delegate.RoundTrip(req)
+}
+
+// measurePhase starts the measure of the phase and returns the function recording it in the result of the pass
+// and in the upgrade state metrics, to be called when the phase is over
+func (m *ClusterUpgradeStateManagerImpl) measurePhase(phase string) func() {
+	start, calls := time.Now(), m.apiCalls.count()
+	return func() {
+		stat := PhaseStat{Phase: phase, Duration: time.Since(start), APICalls: m.apiCalls.count() - calls}
+		m.applyResultRecorder.recordPhaseStat(stat)
+		m.stateMetrics.observePhase(stat)
+	}
+}
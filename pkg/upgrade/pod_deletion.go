@@ -0,0 +1,123 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+)
+
+const (
+	// podEvictionRetryInterval is the interval of the retries of the evictions refused by a PodDisruptionBudget
+	podEvictionRetryInterval = 5 * time.Second
+	// podDeletionPollInterval is the interval of the checks of the deletion of the pods
+	podDeletionPollInterval = time.Second
+)
+
+// getPodDeleteOptions returns the DeleteOptions of the eviction or the deletion of the pods
+func getPodDeleteOptions(spec *v1alpha1.PodDeletionSpec) metav1.DeleteOptions {
+	deleteOptions := metav1.DeleteOptions{}
+	if spec.GracePeriodSeconds != nil {
+		gracePeriodSeconds := *spec.GracePeriodSeconds
+		deleteOptions.GracePeriodSeconds = &gracePeriodSeconds
+	}
+	if spec.PropagationPolicy != "" {
+		propagationPolicy := spec.PropagationPolicy
+		deleteOptions.PropagationPolicy = &propagationPolicy
+	}
+	if spec.DryRun {
+		deleteOptions.DryRun = []string{metav1.DryRunAll}
+	}
+	return deleteOptions
+}
+
+// deleteOrEvictPods evicts or deletes the pods according to the mode of the pod deletion spec, and waits for
+// the pods to be gone unless the pod deletion is a dry run. The evictions refused by a PodDisruptionBudget are
+// retried until the timeout of the pod deletion spec is exceeded.
+func deleteOrEvictPods(ctx context.Context, client kubernetes.Interface, pods []corev1.Pod,
+	spec *v1alpha1.PodDeletionSpec) error {
+	if spec.TimeoutSecond != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(spec.TimeoutSecond)*time.Second)
+		defer cancel()
+	}
+	deleteOptions := getPodDeleteOptions(spec)
+	for i := range pods {
+		err := deleteOrEvictPod(ctx, client, &pods[i], spec.Mode, deleteOptions)
+		if err != nil {
+			return err
+		}
+	}
+	if spec.DryRun {
+		return nil
+	}
+	err := wait.PollUntilContextCancel(ctx, podDeletionPollInterval, true, func(ctx context.Context) (bool, error) {
+		for i := range pods {
+			pod, err := client.CoreV1().Pods(pods[i].Namespace).Get(ctx, pods[i].Name, metav1.GetOptions{})
+			if k8serrors.IsNotFound(err) || (err == nil && pod.UID != pods[i].UID) {
+				continue
+			}
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to wait for the deletion of the pods: %v", err)
+	}
+	return nil
+}
+
+// deleteOrEvictPod evicts or deletes the pod, a pod which is already gone is ignored
+func deleteOrEvictPod(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod,
+	mode v1alpha1.PodDeletionMode, deleteOptions metav1.DeleteOptions) error {
+	if mode == v1alpha1.PodDeletionModeDelete {
+		err := client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, deleteOptions)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		return nil
+	}
+	eviction := &policyv1.Eviction{
+		ObjectMeta:    metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		DeleteOptions: &deleteOptions,
+	}
+	for {
+		err := client.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		switch {
+		case err == nil, k8serrors.IsNotFound(err):
+			return nil
+		case !k8serrors.IsTooManyRequests(err):
+			return fmt.Errorf("failed to evict pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		// the eviction is refused by a PodDisruptionBudget, retry later
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to evict pod %s/%s before the timeout: %v", pod.Namespace, pod.Name, err)
+		case <-time.After(podEvictionRetryInterval):
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -28,10 +29,13 @@ import (
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/kubectl/pkg/drain"
 
@@ -45,8 +49,15 @@ type PodManagerImpl struct {
 	nodeUpgradeStateProvider NodeUpgradeStateProvider
 	podDeletionFilter        PodDeletionFilter
 	nodesInProgress          *StringSet
+	workers                  *workerPoolTracker
 	log                      logr.Logger
 	eventRecorder            record.EventRecorder
+	// nodeClients, if set, creates clients which report the API server warnings received during the pod deletion
+	// as events of the node
+	nodeClients *nodeClientFactory
+	keys        UpgradeKeys
+	// podLister, if set, lists the pods instead of the API server, see CachedNodeUpgradeStateProvider.WithPodLister
+	podLister corev1listers.PodLister
 }
 
 // PodManager is an interface that allows to wait on certain pod statuses
@@ -57,6 +68,8 @@ type PodManager interface {
 	GetPodDeletionFilter() PodDeletionFilter
 	GetPodControllerRevisionHash(ctx context.Context, pod *corev1.Pod) (string, error)
 	GetDaemonsetControllerRevisionHash(ctx context.Context, daemonset *appsv1.DaemonSet) (string, error)
+	RunNodeHookPod(ctx context.Context, node *corev1.Node, hook NodeHook,
+		template *corev1.PodTemplateSpec) (corev1.PodPhase, error)
 }
 
 // PodManagerConfig represent the selector for pods and Node names to be considered for managing those pods
@@ -65,8 +78,26 @@ type PodManagerConfig struct {
 	DeletionSpec          *v1alpha1.PodDeletionSpec
 	WaitForCompletionSpec *v1alpha1.WaitForCompletionSpec
 	DrainEnabled          bool
+	FailedPodPolicy       FailedPodPolicy
+	// ProtectedNamespaces are the namespaces pods are never deleted from
+	ProtectedNamespaces []string
 }
 
+// FailedPodPolicy defines how pods in the Failed phase are handled during wait for completion, pod deletion
+// and drain. Pods in the Succeeded phase never block the upgrade and are not deleted.
+type FailedPodPolicy string
+
+const (
+	// FailedPodPolicyIgnore makes Failed pods neither block wait for completion nor get deleted. This is the default.
+	FailedPodPolicyIgnore FailedPodPolicy = "Ignore"
+	// FailedPodPolicyDelete makes Failed pods not block wait for completion, but get deleted with pod deletion
+	// and drain
+	FailedPodPolicyDelete FailedPodPolicy = "Delete"
+	// FailedPodPolicyWait makes Failed pods block wait for completion like running pods, e.g. to give
+	// their controller a chance to retry them, and get deleted with pod deletion and drain
+	FailedPodPolicyWait FailedPodPolicy = "Wait"
+)
+
 const (
 	// PodControllerRevisionHashLabelKey is the label key containing the controller-revision-hash
 	PodControllerRevisionHashLabelKey = "controller-revision-hash"
@@ -140,7 +171,8 @@ func (m *PodManagerImpl) SchedulePodEviction(ctx context.Context, config *PodMan
 	// The drain helper will carry out the actual deletion of pods on a node.
 	customDrainFilter := func(pod corev1.Pod) drain.PodDeleteStatus {
 		deleteFunc := m.podDeletionFilter(pod)
-		if !deleteFunc {
+		if !deleteFunc || isTerminalPodSkipped(pod, config.FailedPodPolicy) ||
+			isPodInProtectedNamespace(pod, config.ProtectedNamespaces) || isPodExcludedFromDeletion(pod, podDeletionSpec) {
 			return drain.MakePodDeleteStatusSkip()
 		}
 		return drain.MakePodDeleteStatusOkay()
@@ -153,19 +185,27 @@ func (m *PodManagerImpl) SchedulePodEviction(ctx context.Context, config *PodMan
 		ErrOut:              os.Stderr,
 		GracePeriodSeconds:  -1,
 		IgnoreAllDaemonSets: true,
-		DeleteEmptyDirData:  podDeletionSpec.DeleteEmptyDir,
-		Force:               podDeletionSpec.Force,
-		Timeout:             time.Duration(podDeletionSpec.TimeoutSecond) * time.Second,
-		AdditionalFilters:   []drain.PodFilter{customDrainFilter},
+		// pods using emptyDir are skipped by the custom filter, which runs after the local storage filter
+		DeleteEmptyDirData: podDeletionSpec.DeleteEmptyDir || podDeletionSpec.SkipLocalStorage,
+		Force:              podDeletionSpec.Force,
+		Timeout:            time.Duration(podDeletionSpec.TimeoutSecond) * time.Second,
+		AdditionalFilters:  []drain.PodFilter{customDrainFilter},
 	}
 
 	for _, node := range config.Nodes {
 		if !m.nodesInProgress.Has(node.Name) {
 			m.log.V(consts.LogLevelInfo).Info("Deleting pods on node", "node", node.Name)
 			m.nodesInProgress.Add(node.Name)
+			m.workers.enqueue(node.Name)
 
 			go func(node corev1.Node) {
 				defer m.nodesInProgress.Remove(node.Name)
+				m.workers.start(node.Name)
+				defer m.workers.done(node.Name)
+
+				// use a dedicated copy of the drain helper to attribute API warnings to the node
+				drainHelper := drainHelper
+				drainHelper.Client = m.nodeClients.clientFor(&node, drainHelper.Client)
 
 				m.log.V(consts.LogLevelInfo).Info("Identifying pods to delete", "node", node.Name)
 
@@ -179,9 +219,18 @@ func (m *PodManagerImpl) SchedulePodEviction(ctx context.Context, config *PodMan
 				// Get number of pods requiring deletion using the podDeletionFilter
 				numPodsToDelete := 0
 				for _, pod := range podList.Items {
-					if m.podDeletionFilter(pod) {
-						numPodsToDelete++
+					if !m.podDeletionFilter(pod) || isTerminalPodSkipped(pod, config.FailedPodPolicy) ||
+						isPodInProtectedNamespace(pod, config.ProtectedNamespaces) ||
+						isPodExcludedFromDeletion(pod, podDeletionSpec) {
+						continue
 					}
+					if isStaticPod(pod) {
+						// static pods are skipped by the drain helper, they are restarted by the kubelet only
+						m.log.V(consts.LogLevelInfo).Info("Skipping static pod, static pods can't be deleted",
+							"node", node.Name, "pod", pod.Namespace+"/"+pod.Name)
+						continue
+					}
+					numPodsToDelete++
 				}
 
 				if numPodsToDelete == 0 {
@@ -210,7 +259,7 @@ func (m *PodManagerImpl) SchedulePodEviction(ctx context.Context, config *PodMan
 				m.log.V(consts.LogLevelDebug).Info("Warnings when identifying pods to delete",
 					"warnings", podDeleteList.Warnings(), "node", node.Name)
 
-				err = drainHelper.DeleteOrEvictPods(podDeleteList.Pods())
+				err = deleteOrEvictPods(ctx, drainHelper.Client, podDeleteList.Pods(), podDeletionSpec)
 				if err != nil {
 					m.log.V(consts.LogLevelError).Error(err, "Failed to delete pods on the node", "node", node.Name)
 					logEventf(m.eventRecorder, &node, corev1.EventTypeWarning, GetEventReason(),
@@ -262,36 +311,33 @@ func (m *PodManagerImpl) ScheduleCheckOnPodCompletion(ctx context.Context, confi
 
 	for _, node := range config.Nodes {
 		m.log.V(consts.LogLevelInfo).Info("Schedule checks for pod completion", "node", node.Name)
-		// fetch the pods using the label selector provided
-		podList, err := m.ListPods(ctx, config.WaitForCompletionSpec.PodSelector, node.Name)
+		var running []string
+		var err error
+		if config.WaitForCompletionSpec.JobSelector != "" {
+			running, err = m.getRunningJobs(ctx, config.WaitForCompletionSpec.JobSelector, node.Name)
+		} else {
+			running, err = m.getRunningPods(ctx, config, node.Name)
+		}
 		if err != nil {
-			m.log.V(consts.LogLevelError).Error(err, "Failed to list pods",
-				"selector", config.WaitForCompletionSpec.PodSelector, "node", node.Name)
 			return err
 		}
-		if len(podList.Items) > 0 {
-			m.log.V(consts.LogLevelDebug).Error(err, "Found workload pods",
-				"selector", config.WaitForCompletionSpec.PodSelector, "node", node.Name, "pods", len(podList.Items))
-		}
 		// Increment the WaitGroup counter.
 		wg.Add(1)
 		go func(node corev1.Node) {
 			// Decrement the counter when the goroutine completes.
 			defer wg.Done()
-			running := false
-			for _, pod := range podList.Items {
-				running = m.IsPodRunningOrPending(pod)
-				if running {
-					break
-				}
-			}
-			// if workload pods are running, then check if timeout is specified and exceeded.
+			// if workloads are running, then check if timeout is specified and exceeded.
 			// if no timeout is specified, then ignore the state updates and wait for completions.
-			if running {
-				m.log.V(consts.LogLevelInfo).Info("Workload pods are still running on the node", "node", node.Name)
+			if len(running) > 0 {
+				m.log.V(consts.LogLevelInfo).Info("Workloads are still running on the node", "node", node.Name,
+					"workloads", len(running))
+				err := m.reportRunningWorkloads(ctx, &node, running)
+				if err != nil {
+					return
+				}
 				// check whether timeout is provided and is exceeded for job completions
 				if config.WaitForCompletionSpec.TimeoutSecond != 0 {
-					err = m.HandleTimeoutOnPodCompletions(ctx, &node, int64(config.WaitForCompletionSpec.TimeoutSecond))
+					err := m.handleTimeoutOnPodCompletions(ctx, &node, config.WaitForCompletionSpec)
 					if err != nil {
 						logEventf(m.eventRecorder, &node, corev1.EventTypeWarning, GetEventReason(),
 							"Failed to handle timeout for job completions, %s", err.Error())
@@ -300,9 +346,8 @@ func (m *PodManagerImpl) ScheduleCheckOnPodCompletion(ctx context.Context, confi
 				}
 				return
 			}
-			// remove annotation used for tracking start time
-			annotationKey := GetWaitForPodCompletionStartTimeAnnotationKey()
-			err = m.nodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, &node, annotationKey, "null")
+			// remove annotations used for tracking start time and running pods
+			err := m.removePodCompletionAnnotations(ctx, &node)
 			if err != nil {
 				logEventf(m.eventRecorder, &node, corev1.EventTypeWarning, GetEventReason(),
 					"Failed to remove annotation used to track job completions: %s", err.Error())
@@ -319,8 +364,89 @@ func (m *PodManagerImpl) ScheduleCheckOnPodCompletion(ctx context.Context, confi
 	return nil
 }
 
+// getRunningPods returns the namespaced names of the workload pods on the node, matching the pod selector of
+// the wait for completion spec, which are running or pending, or failed if the failed pod policy waits for them
+func (m *PodManagerImpl) getRunningPods(ctx context.Context, config *PodManagerConfig,
+	nodeName string) ([]string, error) {
+	// fetch the pods using the label selector provided
+	podList, err := m.ListPods(ctx, config.WaitForCompletionSpec.PodSelector, nodeName)
+	if err != nil {
+		m.log.V(consts.LogLevelError).Error(err, "Failed to list pods",
+			"selector", config.WaitForCompletionSpec.PodSelector, "node", nodeName)
+		return nil, err
+	}
+	if len(podList.Items) > 0 {
+		m.log.V(consts.LogLevelDebug).Info("Found workload pods",
+			"selector", config.WaitForCompletionSpec.PodSelector, "node", nodeName, "pods", len(podList.Items))
+	}
+	runningPods := make([]string, 0, len(podList.Items))
+	for _, pod := range podList.Items {
+		if m.IsPodRunningOrPending(pod) ||
+			(pod.Status.Phase == corev1.PodFailed && config.FailedPodPolicy == FailedPodPolicyWait) {
+			runningPods = append(runningPods, pod.Namespace+"/"+pod.Name)
+		}
+	}
+	return runningPods, nil
+}
+
+// getRunningJobs returns the namespaced names of the Jobs matching the job selector which have pods on the node
+// and didn't succeed yet. Jobs created by CronJobs are selected with the labels of the job template of the CronJob.
+func (m *PodManagerImpl) getRunningJobs(ctx context.Context, selector string, nodeName string) ([]string, error) {
+	podList, err := m.ListPods(ctx, "", nodeName)
+	if err != nil {
+		m.log.V(consts.LogLevelError).Error(err, "Failed to list pods", "node", nodeName)
+		return nil, err
+	}
+	// the Jobs owning pods on the node
+	nodeJobs := map[types.UID]bool{}
+	for i := range podList.Items {
+		owner := meta_v1.GetControllerOf(&podList.Items[i])
+		if owner != nil && owner.Kind == "Job" {
+			nodeJobs[owner.UID] = true
+		}
+	}
+	if len(nodeJobs) == 0 {
+		return nil, nil
+	}
+	jobList, err := m.k8sInterface.BatchV1().Jobs("").List(ctx, meta_v1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		m.log.V(consts.LogLevelError).Error(err, "Failed to list jobs", "selector", selector)
+		return nil, err
+	}
+	runningJobs := []string{}
+	for _, job := range jobList.Items {
+		if nodeJobs[job.UID] && !isJobSucceeded(&job) {
+			runningJobs = append(runningJobs, job.Namespace+"/"+job.Name)
+		}
+	}
+	m.log.V(consts.LogLevelDebug).Info("Found workload jobs", "selector", selector, "node", nodeName,
+		"jobs", len(runningJobs))
+	return runningJobs, nil
+}
+
+// isJobSucceeded returns true if the Job is complete
+func isJobSucceeded(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobComplete && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
 // ListPods returns the list of pods in all namespaces with the given selector
 func (m *PodManagerImpl) ListPods(ctx context.Context, selector string, nodeName string) (*corev1.PodList, error) {
+	if m.podLister != nil {
+		labelSelector, err := labels.Parse(selector)
+		if err != nil {
+			return nil, err
+		}
+		pods, err := listCachedPods(m.podLister, meta_v1.NamespaceAll, labelSelector, nodeName)
+		if err != nil {
+			return nil, err
+		}
+		return &corev1.PodList{Items: pods}, nil
+	}
 	listOptions := meta_v1.ListOptions{LabelSelector: selector,
 		FieldSelector: fmt.Sprintf(nodeNameFieldSelectorFmt, nodeName)}
 	podList, err := m.k8sInterface.CoreV1().Pods("").List(ctx, listOptions)
@@ -330,10 +456,63 @@ func (m *PodManagerImpl) ListPods(ctx context.Context, selector string, nodeName
 	return podList, nil
 }
 
+// maxReportedRunningWorkloads is the maximum count of running workload pods or Jobs listed in the events of a node
+const maxReportedRunningWorkloads = 5
+
+// reportRunningWorkloads records the count of the workload pods or Jobs still running on the node in a node annotation
+// and emits an event listing them whenever the count changes
+func (m *PodManagerImpl) reportRunningWorkloads(ctx context.Context, node *corev1.Node, running []string) error {
+	annotationKey := m.keys.GetWaitForPodCompletionRunningPodsAnnotationKey()
+	count := strconv.Itoa(len(running))
+	if node.Annotations[annotationKey] == count {
+		return nil
+	}
+	err := m.nodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, count)
+	if err != nil {
+		m.log.V(consts.LogLevelError).Error(err, "Failed to add annotation to track running pods",
+			"node", node.Name, "annotation", annotationKey)
+		return err
+	}
+	names := running
+	if len(names) > maxReportedRunningWorkloads {
+		names = append(names[:maxReportedRunningWorkloads:maxReportedRunningWorkloads],
+			fmt.Sprintf("and %d more", len(running)-maxReportedRunningWorkloads))
+	}
+	logEventf(m.eventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+		"Waiting for the completion of %d workloads: %s", len(running), strings.Join(names, ", "))
+	return nil
+}
+
+// removePodCompletionAnnotations removes the annotations used to track the start time and the running pods
+// of the wait for pod completions
+func (m *PodManagerImpl) removePodCompletionAnnotations(ctx context.Context, node *corev1.Node) error {
+	for _, annotationKey := range []string{m.keys.GetWaitForPodCompletionStartTimeAnnotationKey(),
+		m.keys.GetWaitForPodCompletionRunningPodsAnnotationKey()} {
+		if _, present := node.Annotations[annotationKey]; !present {
+			continue
+		}
+		err := m.nodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, nullString)
+		if err != nil {
+			m.log.V(consts.LogLevelError).Error(err, "Failed to remove annotation used to track job completions",
+				"node", node.Name, "annotation", annotationKey)
+			return err
+		}
+	}
+	return nil
+}
+
 // HandleTimeoutOnPodCompletions transitions node based on the timeout for job completions on the node
 func (m *PodManagerImpl) HandleTimeoutOnPodCompletions(ctx context.Context, node *corev1.Node,
 	timeoutSeconds int64) error {
-	annotationKey := GetWaitForPodCompletionStartTimeAnnotationKey()
+	return m.handleTimeoutOnPodCompletions(ctx, node, &v1alpha1.WaitForCompletionSpec{
+		TimeoutSecond: int(timeoutSeconds), TimeoutAction: v1alpha1.WaitForCompletionTimeoutActionProceed})
+}
+
+// handleTimeoutOnPodCompletions transitions node according to the timeout action of the wait for completion spec
+// once the timeout for job completions on the node is exceeded
+func (m *PodManagerImpl) handleTimeoutOnPodCompletions(ctx context.Context, node *corev1.Node,
+	spec *v1alpha1.WaitForCompletionSpec) error {
+	annotationKey := m.keys.GetWaitForPodCompletionStartTimeAnnotationKey()
 	currentTime := time.Now().Unix()
 	// check if annotation already exists for tracking start time
 	if _, present := node.Annotations[annotationKey]; !present {
@@ -354,20 +533,47 @@ func (m *PodManagerImpl) HandleTimeoutOnPodCompletions(ctx context.Context, node
 			"node", node.Name)
 		return err
 	}
-	if currentTime > startTime+timeoutSeconds {
-		// timeout exceeded, mark node for pod/job deletions
-		_ = m.nodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStatePodDeletionRequired)
+	if currentTime <= startTime+int64(spec.TimeoutSecond) {
+		return nil
+	}
+	switch spec.TimeoutAction {
+	case v1alpha1.WaitForCompletionTimeoutActionWait:
+		// keep waiting for the pods, warn once about the exceeded timeout
+		if m.keys.GetNodeUpgradeStateReason(node) == UpgradeStateReasonWaitForCompletionTimeout {
+			return nil
+		}
+		m.log.V(consts.LogLevelWarning).Info("Timeout exceeded for job completions, waiting for the pods",
+			"node", node.Name)
+		logEventf(m.eventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+			"Workload pods did not complete within %d seconds, waiting for their completion", spec.TimeoutSecond)
+		return m.keys.setNodeUpgradeStateReason(ctx, m.nodeUpgradeStateProvider, node,
+			UpgradeStateReasonWaitForCompletionTimeout)
+	case v1alpha1.WaitForCompletionTimeoutActionFail:
+		// timeout exceeded, mark node as failed
+		err = m.nodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
+		if err != nil {
+			m.log.V(consts.LogLevelError).Error(err, "Failed to change node upgrade state", "node", node.Name,
+				"state", UpgradeStateFailed)
+			return err
+		}
 		m.log.V(consts.LogLevelInfo).Info("Timeout exceeded for job completions, updated the node state",
-			"node", node.Name, "state", UpgradeStatePodDeletionRequired)
-		// remove annotation used for tracking start time
-		err = m.nodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, "null")
+			"node", node.Name, "state", UpgradeStateFailed)
+		logEventf(m.eventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+			"Workload pods did not complete within %d seconds, moving the node to %s state",
+			spec.TimeoutSecond, UpgradeStateFailed)
+		err = m.keys.setNodeUpgradeStateReason(ctx, m.nodeUpgradeStateProvider, node,
+			UpgradeStateReasonWaitForCompletionTimeout)
 		if err != nil {
-			m.log.V(consts.LogLevelError).Error(err, "Failed to remove annotation used to track job completions",
-				"node", node.Name, "annotation", annotationKey)
 			return err
 		}
+	default:
+		// timeout exceeded, mark node for pod/job deletions
+		_ = m.nodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStatePodDeletionRequired)
+		m.log.V(consts.LogLevelInfo).Info("Timeout exceeded for job completions, updated the node state",
+			"node", node.Name, "state", UpgradeStatePodDeletionRequired)
 	}
-	return nil
+	// remove annotations used for tracking start time and running pods
+	return m.removePodCompletionAnnotations(ctx, node)
 }
 
 // IsPodRunningOrPending returns true when the given pod is currently in Running or Pending state
@@ -393,7 +599,125 @@ func (m *PodManagerImpl) IsPodRunningOrPending(pod corev1.Pod) bool {
 	return false
 }
 
+// isTerminalPodSkipped returns true if the pod is in a terminal phase and should not be deleted
+// according to the failedPodPolicy
+func isTerminalPodSkipped(pod corev1.Pod, failedPodPolicy FailedPodPolicy) bool {
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return true
+	case corev1.PodFailed:
+		return failedPodPolicy != FailedPodPolicyDelete && failedPodPolicy != FailedPodPolicyWait
+	}
+	return false
+}
+
+// terminalPodFilter returns a drain.PodFilter which skips pods in a terminal phase
+// according to the failedPodPolicy
+func terminalPodFilter(failedPodPolicy FailedPodPolicy) drain.PodFilter {
+	return func(pod corev1.Pod) drain.PodDeleteStatus {
+		if isTerminalPodSkipped(pod, failedPodPolicy) {
+			return drain.MakePodDeleteStatusSkip()
+		}
+		return drain.MakePodDeleteStatusOkay()
+	}
+}
+
+// isPodInProtectedNamespace returns true if the pod belongs to one of the protected namespaces
+func isPodInProtectedNamespace(pod corev1.Pod, protectedNamespaces []string) bool {
+	for _, namespace := range protectedNamespaces {
+		if pod.Namespace == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// isPodExcludedFromDeletion returns true if the pod is filtered out by the namespaces, the owner kinds or the local
+// storage filters of the pod deletion spec
+func isPodExcludedFromDeletion(pod corev1.Pod, spec *v1alpha1.PodDeletionSpec) bool {
+	if len(spec.Namespaces) > 0 && !slices.Contains(spec.Namespaces, pod.Namespace) {
+		return true
+	}
+	if slices.Contains(spec.ExcludeNamespaces, pod.Namespace) {
+		return true
+	}
+	ownerKind := getPodOwnerKind(pod)
+	if len(spec.OwnerKinds) > 0 && !slices.Contains(spec.OwnerKinds, ownerKind) {
+		return true
+	}
+	if ownerKind != "" && slices.Contains(spec.ExcludeOwnerKinds, ownerKind) {
+		return true
+	}
+	return spec.SkipLocalStorage && hasEmptyDir(pod)
+}
+
+// getPodOwnerKind returns the kind of the controller of the pod, Deployment for the pods of the ReplicaSets
+// created by Deployments, i.e. pods with the pod-template-hash label, empty if the pod has no controller
+func getPodOwnerKind(pod corev1.Pod) string {
+	controllerRef := meta_v1.GetControllerOf(&pod)
+	if controllerRef == nil {
+		return ""
+	}
+	if controllerRef.Kind == "ReplicaSet" {
+		if _, ok := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; ok {
+			return "Deployment"
+		}
+	}
+	return controllerRef.Kind
+}
+
+// hasEmptyDir returns true if the pod uses an emptyDir volume
+func hasEmptyDir(pod corev1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// protectedNamespaceFilter returns a drain.PodFilter which skips pods in the protected namespaces
+func protectedNamespaceFilter(protectedNamespaces []string) drain.PodFilter {
+	return func(pod corev1.Pod) drain.PodDeleteStatus {
+		if isPodInProtectedNamespace(pod, protectedNamespaces) {
+			return drain.MakePodDeleteStatusSkip()
+		}
+		return drain.MakePodDeleteStatusOkay()
+	}
+}
+
+// isPodAbovePriorityThreshold returns true if the priority of the pod is at or above the threshold, pods without
+// priority have priority zero. It returns false if no threshold is set.
+func isPodAbovePriorityThreshold(pod corev1.Pod, priorityThreshold *int32) bool {
+	if priorityThreshold == nil {
+		return false
+	}
+	priority := int32(0)
+	if pod.Spec.Priority != nil {
+		priority = *pod.Spec.Priority
+	}
+	return priority >= *priorityThreshold
+}
+
+// priorityThresholdFilter returns a drain.PodFilter which skips pods at or above the priority threshold
+func priorityThresholdFilter(priorityThreshold *int32) drain.PodFilter {
+	return func(pod corev1.Pod) drain.PodDeleteStatus {
+		if isPodAbovePriorityThreshold(pod, priorityThreshold) {
+			return drain.MakePodDeleteStatusSkip()
+		}
+		return drain.MakePodDeleteStatusOkay()
+	}
+}
+
 func (m *PodManagerImpl) updateNodeToDrainOrFailed(ctx context.Context, node corev1.Node, drainEnabled bool) {
+	if isInterrupted(ctx) {
+		// the pods are deleted again by the next pass
+		m.log.V(consts.LogLevelInfo).Info("Pod deletion interrupted, the node stays in its state", "node", node.Name,
+			"cause", context.Cause(ctx))
+		logEventf(m.eventRecorder, &node, corev1.EventTypeWarning, GetEventReason(),
+			"Deletion of the workload pods was %v, it is resumed by the next pass", errInterrupted)
+		return
+	}
 	nextState := UpgradeStateFailed
 	if drainEnabled {
 		m.log.V(consts.LogLevelInfo).Info("Pod deletion failed but drain is enabled in spec. Will attempt a node drain",
@@ -405,6 +729,11 @@ func (m *PodManagerImpl) updateNodeToDrainOrFailed(ctx context.Context, node cor
 	_ = m.nodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, &node, nextState)
 }
 
+// GetWorkerPoolStats returns the state of the workers deleting workload pods on nodes
+func (m *PodManagerImpl) GetWorkerPoolStats() WorkerPoolStats {
+	return m.workers.stats()
+}
+
 // NewPodManager returns an instance of PodManager implementation
 func NewPodManager(
 	k8sInterface kubernetes.Interface,
@@ -418,6 +747,7 @@ func NewPodManager(
 		nodeUpgradeStateProvider: nodeUpgradeStateProvider,
 		podDeletionFilter:        podDeletionFilter,
 		nodesInProgress:          NewStringSet(),
+		workers:                  newWorkerPoolTracker(),
 		eventRecorder:            eventRecorder,
 	}
 
@@ -26,9 +26,13 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 
 	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
 	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
@@ -180,6 +184,40 @@ var _ = Describe("PodManager", func() {
 			// verify annotation is added to track the start time.
 			Expect(isWaitForCompletionAnnotationPresent(node)).To(Equal(false))
 		})
+		It("should ignore failed workload pods unless the failed pod policy is Wait", func() {
+			// initialize upgrade state of the node
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			err := provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateWaitForJobsRequired)
+			Expect(err).To(Succeed())
+
+			// create failed pod on testnode
+			labels := map[string]string{"app": "my-app"}
+			pod := NewPod("test-pod", namespace.Name, node.Name).WithLabels(labels).Create()
+			pod.Status.Phase = corev1.PodFailed
+			err = updatePodStatus(pod)
+			Expect(err).To(Succeed())
+
+			podManagerConfig.WaitForCompletionSpec.PodSelector = "app=my-app"
+			podManagerConfig.FailedPodPolicy = upgrade.FailedPodPolicyWait
+			manager := upgrade.NewPodManager(k8sInterface, provider, log, nil, eventRecorder)
+			err = manager.ScheduleCheckOnPodCompletion(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+
+			// verify upgrade state is unchanged while failed pod blocks the wait
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+
+			podManagerConfig.Nodes = []*corev1.Node{node}
+			podManagerConfig.FailedPodPolicy = ""
+			err = manager.ScheduleCheckOnPodCompletion(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+
+			// verify upgrade state is changed with failed pod ignored by default
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStatePodDeletionRequired))
+		})
 		It("should change the state of the node if workload pod is running and timeout is reached", func() {
 			// initialize upgrade state of the node
 			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
@@ -225,6 +263,107 @@ var _ = Describe("PodManager", func() {
 			// verify annotation is removed to track the start time.
 			Expect(isWaitForCompletionAnnotationPresent(node)).To(Equal(false))
 		})
+		It("should apply the timeout action and report the running pods once timeout is reached", func() {
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			err := provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateWaitForJobsRequired)
+			Expect(err).To(Succeed())
+
+			labels := map[string]string{"app": "my-app"}
+			_ = NewPod("test-pod-1", namespace.Name, node.Name).WithLabels(labels).Create()
+			_ = NewPod("test-pod-2", namespace.Name, node.Name).WithLabels(labels).Create()
+
+			podManagerConfig.WaitForCompletionSpec.PodSelector = "app=my-app"
+			podManagerConfig.WaitForCompletionSpec.TimeoutSecond = 30
+			podManagerConfig.WaitForCompletionSpec.TimeoutAction = v1alpha1.WaitForCompletionTimeoutActionWait
+			manager := upgrade.NewPodManager(k8sInterface, provider, log, nil, eventRecorder)
+			err = manager.ScheduleCheckOnPodCompletion(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+
+			// verify the running pods are reported on the node
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Annotations[upgrade.GetWaitForPodCompletionRunningPodsAnnotationKey()]).To(Equal("2"))
+			Expect(isWaitForCompletionAnnotationPresent(node)).To(Equal(true))
+
+			startTime := strconv.FormatInt(time.Now().Unix()-35, 10)
+			err = provider.ChangeNodeUpgradeAnnotation(ctx, node,
+				upgrade.GetWaitForPodCompletionStartTimeAnnotationKey(), startTime)
+			Expect(err).To(Succeed())
+
+			// verify the node keeps waiting for the pods with the Wait action
+			podManagerConfig.Nodes = []*corev1.Node{node}
+			err = manager.ScheduleCheckOnPodCompletion(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(node)).To(Equal(upgrade.UpgradeStateReasonWaitForCompletionTimeout))
+			Expect(isWaitForCompletionAnnotationPresent(node)).To(Equal(true))
+
+			// verify the node is moved to failed state with the Fail action
+			podManagerConfig.WaitForCompletionSpec.TimeoutAction = v1alpha1.WaitForCompletionTimeoutActionFail
+			podManagerConfig.Nodes = []*corev1.Node{node}
+			err = manager.ScheduleCheckOnPodCompletion(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(upgrade.GetNodeUpgradeStateReason(node)).To(Equal(upgrade.UpgradeStateReasonWaitForCompletionTimeout))
+			Expect(isWaitForCompletionAnnotationPresent(node)).To(Equal(false))
+			Expect(node.Annotations).NotTo(HaveKey(upgrade.GetWaitForPodCompletionRunningPodsAnnotationKey()))
+		})
+		It("should change the state of the node only after the selected jobs succeeded", func() {
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			err := provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateWaitForJobsRequired)
+			Expect(err).To(Succeed())
+
+			// create the selected job and a job which isn't selected, both with a pod on the node
+			createJob := func(name string, labels map[string]string) *batchv1.Job {
+				job := &batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace.Name, Labels: labels},
+					Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+						RestartPolicy: corev1.RestartPolicyNever,
+						Containers:    []corev1.Container{{Name: "test", Image: "test:latest"}},
+					}}},
+				}
+				job, err := k8sInterface.BatchV1().Jobs(namespace.Name).Create(ctx, job, metav1.CreateOptions{})
+				Expect(err).To(Succeed())
+				_ = NewPod(name+"-pod", namespace.Name, node.Name).
+					WithOwnerReference(metav1.OwnerReference{APIVersion: "batch/v1", Kind: "Job", Name: job.Name,
+						UID: job.UID, Controller: ptr.To(true)}).
+					Create()
+				return job
+			}
+			job := createJob("selected-job", map[string]string{"app": "my-job"})
+			_ = createJob("other-job", map[string]string{"app": "other-job"})
+
+			podManagerConfig.WaitForCompletionSpec.JobSelector = "app=my-job"
+			manager := upgrade.NewPodManager(k8sInterface, provider, log, nil, eventRecorder)
+			err = manager.ScheduleCheckOnPodCompletion(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+
+			// verify upgrade state is unchanged with the selected job running
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+			Expect(node.Annotations[upgrade.GetWaitForPodCompletionRunningPodsAnnotationKey()]).To(Equal("1"))
+
+			// complete the selected job
+			job.Status.StartTime = &metav1.Time{Time: time.Now()}
+			job.Status.CompletionTime = &metav1.Time{Time: time.Now()}
+			job.Status.Succeeded = 1
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+			_, err = k8sInterface.BatchV1().Jobs(namespace.Name).UpdateStatus(ctx, job, metav1.UpdateOptions{})
+			Expect(err).To(Succeed())
+
+			podManagerConfig.Nodes = []*corev1.Node{node}
+			err = manager.ScheduleCheckOnPodCompletion(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStatePodDeletionRequired))
+			Expect(node.Annotations).NotTo(HaveKey(upgrade.GetWaitForPodCompletionRunningPodsAnnotationKey()))
+		})
 	})
 
 	Describe("SchedulePodEviction", func() {
@@ -268,6 +407,175 @@ var _ = Describe("PodManager", func() {
 			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStatePodRestartRequired))
 		})
 
+		It("should skip standalone gpu pods in terminal phase without force", func() {
+			gpuPods = []*corev1.Pod{
+				NewPod(fmt.Sprintf("gpu-pod1-%s", id), namespace.Name, node.Name).WithResource("nvidia.com/gpu", "1").Create(),
+				NewPod(fmt.Sprintf("gpu-pod2-%s", id), namespace.Name, node.Name).WithResource("nvidia.com/gpu", "1").Create(),
+			}
+			gpuPods[0].Status.Phase = corev1.PodSucceeded
+			Expect(updatePodStatus(gpuPods[0])).To(Succeed())
+			gpuPods[1].Status.Phase = corev1.PodFailed
+			Expect(updatePodStatus(gpuPods[1])).To(Succeed())
+
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			err := provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStatePodDeletionRequired)
+			Expect(err).To(Succeed())
+
+			manager := upgrade.NewPodManager(k8sInterface, provider, log, gpuPodSpecFilter, eventRecorder)
+			err = manager.SchedulePodEviction(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+
+			// add a slight delay to let go routines to run to completion on pod eviction to update nodes states
+			time.Sleep(100 * time.Millisecond)
+
+			// check terminal pods were not deleted
+			podList, err := k8sInterface.CoreV1().Pods(namespace.Name).List(ctx, metav1.ListOptions{})
+			Expect(err).To(Succeed())
+			Expect(podList.Items).To(HaveLen(len(cpuPods) + len(gpuPods)))
+
+			// verify upgrade state is set to UpgradeStatePodRestartRequired
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+		})
+
+		It("should only delete the gpu pods selected by the filters of the pod deletion spec", func() {
+			excludedNamespace := createNamespace(fmt.Sprintf("excluded-namespace-%s", id))
+			ownerRef := func(kind string) metav1.OwnerReference {
+				return metav1.OwnerReference{APIVersion: "apps/v1", Kind: kind, Name: "owner", UID: "owner-uid",
+					Controller: ptr.To(true)}
+			}
+			deploymentLabels := map[string]string{"pod-template-hash": "1234"}
+			deletedPod := NewPod(fmt.Sprintf("gpu-pod1-%s", id), namespace.Name, node.Name).
+				WithResource("nvidia.com/gpu", "1").WithLabels(deploymentLabels).WithOwnerReference(ownerRef("ReplicaSet")).
+				Create()
+			skippedPods := []*corev1.Pod{
+				// standalone pod
+				NewPod(fmt.Sprintf("gpu-pod2-%s", id), namespace.Name, node.Name).WithResource("nvidia.com/gpu", "1").
+					Create(),
+				// pod using emptyDir
+				NewPod(fmt.Sprintf("gpu-pod3-%s", id), namespace.Name, node.Name).WithResource("nvidia.com/gpu", "1").
+					WithEmptyDir().WithOwnerReference(ownerRef("StatefulSet")).Create(),
+				// pod in excluded namespace
+				NewPod(fmt.Sprintf("gpu-pod4-%s", id), excludedNamespace.Name, node.Name).
+					WithResource("nvidia.com/gpu", "1").WithOwnerReference(ownerRef("StatefulSet")).Create(),
+			}
+
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			err := provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStatePodDeletionRequired)
+			Expect(err).To(Succeed())
+
+			podManagerConfig.DeletionSpec.OwnerKinds = []string{"Deployment", "StatefulSet"}
+			podManagerConfig.DeletionSpec.ExcludeNamespaces = []string{excludedNamespace.Name}
+			podManagerConfig.DeletionSpec.SkipLocalStorage = true
+			manager := upgrade.NewPodManager(k8sInterface, provider, log, gpuPodSpecFilter, eventRecorder)
+			err = manager.SchedulePodEviction(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+
+			// add a slight delay to let go routines to delete pods and run to completion
+			time.Sleep(100 * time.Millisecond)
+
+			// check only the selected pod was deleted
+			for _, pod := range skippedPods {
+				_, err = k8sInterface.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+				Expect(err).To(Succeed())
+			}
+			_, err = k8sInterface.CoreV1().Pods(deletedPod.Namespace).Get(ctx, deletedPod.Name, metav1.GetOptions{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+		})
+
+		It("should delete the gpu pods instead of evicting them with the Delete mode", func() {
+			labels := map[string]string{"app": "blocked"}
+			gpuPods = []*corev1.Pod{
+				NewPod(fmt.Sprintf("gpu-pod1-%s", id), namespace.Name, node.Name).WithResource("nvidia.com/gpu", "1").
+					WithLabels(labels).Create(),
+			}
+			// the eviction of the pod is blocked by a PodDisruptionBudget
+			createBlockingPDB(namespace.Name, labels)
+
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			err := provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStatePodDeletionRequired)
+			Expect(err).To(Succeed())
+
+			podManagerConfig.DeletionSpec.Force = true
+			podManagerConfig.DeletionSpec.Mode = v1alpha1.PodDeletionModeDelete
+			podManagerConfig.DeletionSpec.GracePeriodSeconds = ptr.To(int64(0))
+			podManagerConfig.DeletionSpec.PropagationPolicy = metav1.DeletePropagationBackground
+			manager := upgrade.NewPodManager(k8sInterface, provider, log, gpuPodSpecFilter, eventRecorder)
+			err = manager.SchedulePodEviction(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+
+			Eventually(func() string {
+				return getNodeUpgradeState(getNode(node.Name))
+			}).WithTimeout(5 * time.Second).Should(Equal(upgrade.UpgradeStatePodRestartRequired))
+			podList, err := k8sInterface.CoreV1().Pods(namespace.Name).List(ctx, metav1.ListOptions{})
+			Expect(err).To(Succeed())
+			Expect(podList.Items).To(HaveLen(len(cpuPods)))
+		})
+
+		It("should not delete gpu pods in protected namespaces", func() {
+			gpuPods = []*corev1.Pod{
+				NewPod(fmt.Sprintf("gpu-pod1-%s", id), namespace.Name, node.Name).WithResource("nvidia.com/gpu", "1").Create(),
+			}
+
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			err := provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStatePodDeletionRequired)
+			Expect(err).To(Succeed())
+
+			manager := upgrade.NewPodManager(k8sInterface, provider, log, gpuPodSpecFilter, eventRecorder)
+			podManagerConfig.DeletionSpec.Force = true
+			podManagerConfig.ProtectedNamespaces = []string{"kube-system", namespace.Name}
+			err = manager.SchedulePodEviction(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+
+			// add a slight delay to let go routines to run to completion on pod eviction to update nodes states
+			time.Sleep(100 * time.Millisecond)
+
+			// check pods in the protected namespace were not deleted
+			podList, err := k8sInterface.CoreV1().Pods(namespace.Name).List(ctx, metav1.ListOptions{})
+			Expect(err).To(Succeed())
+			Expect(podList.Items).To(HaveLen(len(cpuPods) + len(gpuPods)))
+
+			// verify upgrade state is set to UpgradeStatePodRestartRequired
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+		})
+
+		It("should skip static gpu pods", func() {
+			staticPod := NewPod(fmt.Sprintf("gpu-static-pod-%s", id), namespace.Name, node.Name).
+				WithResource("nvidia.com/gpu", "1").Pod
+			staticPod.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: "mirror"}
+			Expect(k8sClient.Create(ctx, staticPod)).To(Succeed())
+			createdObjects = append(createdObjects, staticPod)
+
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			err := provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStatePodDeletionRequired)
+			Expect(err).To(Succeed())
+
+			manager := upgrade.NewPodManager(k8sInterface, provider, log, gpuPodSpecFilter, eventRecorder)
+			podManagerConfig.DeletionSpec.Force = true
+			err = manager.SchedulePodEviction(ctx, &podManagerConfig)
+			Expect(err).To(Succeed())
+
+			// add a slight delay to let go routines to run to completion on pod eviction to update nodes states
+			time.Sleep(100 * time.Millisecond)
+
+			// check the static pod was not deleted
+			podList, err := k8sInterface.CoreV1().Pods(namespace.Name).List(ctx, metav1.ListOptions{})
+			Expect(err).To(Succeed())
+			Expect(podList.Items).To(HaveLen(len(cpuPods) + 1))
+
+			// verify upgrade state is set to UpgradeStatePodRestartRequired
+			node, err = provider.GetNode(ctx, node.Name)
+			Expect(err).To(Succeed())
+			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+		})
+
 		It("should fail to delete all standalone gpu pods without force,"+
 			" and node should be moved to UpgradeStateFailed when drain is disabled", func() {
 			gpuPods = []*corev1.Pod{
@@ -432,6 +740,72 @@ var _ = Describe("PodManager", func() {
 			Expect(node.Labels[upgrade.GetUpgradeStateLabelKey()]).To(Equal(upgrade.UpgradeStateDrainRequired))
 		})
 	})
+	Describe("NewResourcePodDeletionFilter", func() {
+		It("should select the pods consuming the extended resources", func() {
+			filter := upgrade.NewResourcePodDeletionFilter("rdma/hca", "nvidia.com/mig-*")
+			withLimits := func(resourceName string) corev1.Pod {
+				return *NewPod("pod", namespace.Name, node.Name).WithResource(resourceName, "1").Pod
+			}
+			Expect(filter(withLimits("rdma/hca"))).To(BeTrue())
+			Expect(filter(withLimits("nvidia.com/mig-1g.5gb"))).To(BeTrue())
+			Expect(filter(withLimits("nvidia.com/gpu"))).To(BeFalse())
+			Expect(filter(withLimits("rdma/hca_shared"))).To(BeFalse())
+
+			initContainerPod := withLimits("cpu")
+			initContainerPod.Spec.InitContainers = []corev1.Container{{Name: "init", Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{"rdma/hca": resource.MustParse("1")},
+			}}}
+			Expect(filter(initContainerPod)).To(BeTrue())
+		})
+	})
+
+	Describe("RunNodeHookPod", func() {
+		It("should run the node hook pod on the node until it completes", func() {
+			template := &corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Labels: map[string]string{"app": "unload"}},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyAlways,
+					Containers:    []corev1.Container{{Name: "unload", Image: "unload"}},
+				},
+			}
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			manager := upgrade.NewPodManager(k8sInterface, provider, log, nil, eventRecorder)
+
+			phase, err := manager.RunNodeHookPod(ctx, node, upgrade.NodeHookPreUpgrade, template)
+			Expect(err).To(Succeed())
+			Expect(phase).To(Equal(corev1.PodPending))
+			pods, err := k8sInterface.CoreV1().Pods(namespace.Name).List(ctx, metav1.ListOptions{})
+			Expect(err).To(Succeed())
+			Expect(pods.Items).To(HaveLen(1))
+			pod := &pods.Items[0]
+			Expect(pod.Spec.NodeName).To(Equal(node.Name))
+			Expect(pod.Spec.RestartPolicy).To(Equal(corev1.RestartPolicyNever))
+			Expect(pod.Labels).To(HaveKeyWithValue("app", "unload"))
+			Expect(pod.Labels).To(HaveKeyWithValue(upgrade.GetUpgradeNodeHookLabelKey(), "pre-upgrade"))
+
+			// the running hook pod is not created again
+			phase, err = manager.RunNodeHookPod(ctx, node, upgrade.NodeHookPreUpgrade, template)
+			Expect(err).To(Succeed())
+			Expect(phase).To(Equal(corev1.PodPending))
+
+			pod.Status.Phase = corev1.PodSucceeded
+			Expect(updatePodStatus(pod)).To(Succeed())
+			phase, err = manager.RunNodeHookPod(ctx, node, upgrade.NodeHookPreUpgrade, template)
+			Expect(err).To(Succeed())
+			Expect(phase).To(Equal(corev1.PodSucceeded))
+			Eventually(func() []corev1.Pod {
+				pods, err = k8sInterface.CoreV1().Pods(namespace.Name).List(ctx, metav1.ListOptions{})
+				Expect(err).To(Succeed())
+				return pods.Items
+			}).Should(BeEmpty())
+		})
+		It("should report an error if the namespace of the template is not set", func() {
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			manager := upgrade.NewPodManager(k8sInterface, provider, log, nil, eventRecorder)
+			_, err := manager.RunNodeHookPod(ctx, node, upgrade.NodeHookPostUpgrade, &corev1.PodTemplateSpec{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
 
 // Example pod spec filter which returns true if an NVIDIA GPU
@@ -0,0 +1,223 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+)
+
+const (
+	// minRequeueAfter is the shortest recommended requeue, used when the next phases can run right away
+	minRequeueAfter = 5 * time.Second
+	// maxRequeueAfter is the longest recommended requeue, so that a missed watch event delays the upgrade
+	// by this much at most
+	maxRequeueAfter = 10 * time.Minute
+	// expectedPodRestartDuration is the expected time for a restarted driver pod to become ready,
+	// or for a validation to complete
+	expectedPodRestartDuration = 30 * time.Second
+	// workloadPollRequeueAfter is the recommended requeue while waiting for the workload jobs of a node to complete
+	workloadPollRequeueAfter = time.Minute
+	// expectedRebootDuration is the expected time for a rebooted node to come back Ready
+	expectedRebootDuration = 2 * time.Minute
+	// clusterHealthPollRequeueAfter is the recommended requeue while the cluster health gate holds the upgrades
+	clusterHealthPollRequeueAfter = time.Minute
+)
+
+// RequeueReason is what an ApplyState pass waits for when it recommends a requeue
+type RequeueReason string
+
+const (
+	// RequeueReasonProgress is a node which changed state or waits for a phase which can run right away
+	RequeueReasonProgress RequeueReason = "Progress"
+	// RequeueReasonWaitForJobs is a node waiting for the completion of its workload jobs
+	RequeueReasonWaitForJobs RequeueReason = "WaitForJobs"
+	// RequeueReasonDrain is a node being drained, the requeue is at the drain timeout
+	RequeueReasonDrain RequeueReason = "Drain"
+	// RequeueReasonPodRestart is a node waiting for its restarted driver pod to be ready or for its validation
+	RequeueReasonPodRestart RequeueReason = "PodRestart"
+	// RequeueReasonReboot is a node waiting to come back Ready after its reboot
+	RequeueReasonReboot RequeueReason = "Reboot"
+	// RequeueReasonNodeMaintenance is a node waiting for its NodeMaintenance resource to be ready
+	RequeueReasonNodeMaintenance RequeueReason = "NodeMaintenance"
+	// RequeueReasonStateTimeout is a node reaching the timeout of its upgrade state, see NodeStateTimeoutSeconds
+	RequeueReasonStateTimeout RequeueReason = "StateTimeout"
+	// RequeueReasonRetryBackoff is a failed node reaching the end of its retry backoff
+	RequeueReasonRetryBackoff RequeueReason = "RetryBackoff"
+	// RequeueReasonMaintenanceWindow is the opening of the next maintenance window
+	RequeueReasonMaintenanceWindow RequeueReason = "MaintenanceWindow"
+	// RequeueReasonBlackoutPeriod is the end of the active blackout periods
+	RequeueReasonBlackoutPeriod RequeueReason = "BlackoutPeriod"
+	// RequeueReasonClusterHealth is the next check of the cluster health gate holding the upgrades
+	RequeueReasonClusterHealth RequeueReason = "ClusterHealth"
+	// RequeueReasonCanarySoak is the end of the soak period of a canary node
+	RequeueReasonCanarySoak RequeueReason = "CanarySoak"
+	// RequeueReasonScaleUp is a node whose drain waits for the scale-up of a single replica workload
+	RequeueReasonScaleUp RequeueReason = "ScaleUp"
+)
+
+// requeueHint is the earliest time an ApplyState pass waits for
+type requeueHint struct {
+	after  time.Duration
+	reason RequeueReason
+}
+
+// waitFor keeps the wait if it ends before the current one
+func (h *requeueHint) waitFor(after time.Duration, reason RequeueReason) {
+	if h.reason == "" || after < h.after {
+		h.after, h.reason = after, reason
+	}
+}
+
+// getRequeueHint returns the recommended delay before the next ApplyState pass and what the pass waits for,
+// from the upgrade states of the nodes at the end of the pass. The delay is between minRequeueAfter and
+// maxRequeueAfter, it is 0 with an empty reason if no node waits for anything, e.g. all the upgrades are done,
+// and the next pass is only needed when the cluster changes.
+func (m *ClusterUpgradeStateManagerImpl) getRequeueHint(currentState *ClusterUpgradeState,
+	upgradePolicy *v1alpha1.DriverUpgradePolicySpec, now time.Time) (time.Duration, RequeueReason) {
+	hint := &requeueHint{}
+	for passState, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			node := nodeState.Node
+			state := m.getNodeUpgradeState(node)
+			if state != passState && state != UpgradeStateDone && state != UpgradeStateFailed {
+				hint.waitFor(minRequeueAfter, RequeueReasonProgress)
+				continue
+			}
+			m.waitForNodeState(hint, node, state, upgradePolicy, now)
+			if timeout := upgradePolicy.NodeStateTimeoutSeconds[state]; timeout > 0 {
+				value := node.Annotations[m.keys.GetUpgradeStateStartTimeAnnotationKey()]
+				if startTime, ok := getStateStartTime(value, state); ok {
+					hint.waitFor(time.Unix(startTime+int64(timeout), 0).Sub(now), RequeueReasonStateTimeout)
+				}
+			}
+		}
+	}
+	if hint.reason == "" {
+		return 0, ""
+	}
+	return min(max(hint.after, minRequeueAfter), maxRequeueAfter), hint.reason
+}
+
+// waitForNodeState records what the node waits for in its upgrade state
+func (m *ClusterUpgradeStateManagerImpl) waitForNodeState(hint *requeueHint, node *corev1.Node, state string,
+	upgradePolicy *v1alpha1.DriverUpgradePolicySpec, now time.Time) {
+	switch state {
+	case UpgradeStateUpgradeRequired:
+		m.waitForUpgradeStart(hint, node, upgradePolicy, now)
+	case UpgradeStateCordonRequired, UpgradeStatePodDeletionRequired, UpgradeStateUncordonRequired:
+		if m.keys.GetNodeUpgradeStateReason(node) == UpgradeStateReasonWaitingForNodeMaintenance {
+			// the NodeMaintenance resources are not watched, they are polled
+			hint.waitFor(workloadPollRequeueAfter, RequeueReasonNodeMaintenance)
+			return
+		}
+		hint.waitFor(minRequeueAfter, RequeueReasonProgress)
+	case UpgradeStateWaitForJobsRequired:
+		after := workloadPollRequeueAfter
+		if spec := upgradePolicy.WaitForCompletion; spec != nil && spec.TimeoutSecond > 0 {
+			startTime, err := strconv.ParseInt(node.Annotations[m.keys.GetWaitForPodCompletionStartTimeAnnotationKey()], 10, 64)
+			if err == nil {
+				after = min(after, time.Unix(startTime+int64(spec.TimeoutSecond), 0).Sub(now))
+			}
+		}
+		hint.waitFor(after, RequeueReasonWaitForJobs)
+	case UpgradeStateDrainRequired:
+		if m.keys.GetNodeUpgradeStateReason(node) == UpgradeStateReasonWaitingForScaleUp {
+			// the workloads are not watched, they are polled
+			hint.waitFor(workloadPollRequeueAfter, RequeueReasonScaleUp)
+			return
+		}
+		// a drain without timeout is only requeued by maxRequeueAfter, the node changes state once drained
+		after := maxRequeueAfter
+		if spec := upgradePolicy.DrainSpec; spec != nil && spec.TimeoutSecond > 0 {
+			after = time.Duration(spec.TimeoutSecond) * time.Second
+			if provider, ok := m.DrainManager.(DrainStatusProvider); ok {
+				if status, draining := provider.GetDrainStatus(node.Name); draining {
+					after -= now.Sub(status.StartTime.Time)
+				}
+			}
+		}
+		hint.waitFor(after, RequeueReasonDrain)
+	case UpgradeStateRebootRequired:
+		hint.waitFor(expectedRebootDuration, RequeueReasonReboot)
+	case UpgradeStatePodRestartRequired, UpgradeStateValidationRequired:
+		hint.waitFor(expectedPodRestartDuration, RequeueReasonPodRestart)
+	case UpgradeStateDone:
+		if upgradePolicy.Canary == nil {
+			return
+		}
+		startTime, err := strconv.ParseInt(node.Annotations[m.keys.GetUpgradeCanarySoakStartTimeAnnotationKey()], 10, 64)
+		soakEnd := time.Unix(startTime+int64(upgradePolicy.Canary.SoakSeconds), 0)
+		if err == nil && soakEnd.After(now) {
+			hint.waitFor(soakEnd.Sub(now), RequeueReasonCanarySoak)
+		}
+	case UpgradeStateFailed:
+		retry := upgradePolicy.Retry
+		if retry == nil || m.keys.GetNodeUpgradeRetryAttempts(node) >= retry.MaxAttempts {
+			return
+		}
+		startTime, err := strconv.ParseInt(node.Annotations[m.keys.GetUpgradeRetryStartTimeAnnotationKey()], 10, 64)
+		if err != nil {
+			// the backoff starts on the next pass
+			hint.waitFor(minRequeueAfter, RequeueReasonRetryBackoff)
+			return
+		}
+		backoff := getRetryBackoffSeconds(retry, m.keys.GetNodeUpgradeRetryAttempts(node))
+		hint.waitFor(time.Unix(startTime+backoff, 0).Sub(now), RequeueReasonRetryBackoff)
+	}
+}
+
+// waitForUpgradeStart records what the node waits for to start its upgrade: the end of the blackout periods,
+// the next maintenance window or the next check of the cluster health. A node waiting for an upgrade slot waits
+// for the other nodes.
+func (m *ClusterUpgradeStateManagerImpl) waitForUpgradeStart(hint *requeueHint, node *corev1.Node,
+	upgradePolicy *v1alpha1.DriverUpgradePolicySpec, now time.Time) {
+	switch m.keys.GetNodeUpgradeStateReason(node) {
+	case UpgradeStateReasonInBlackoutPeriod:
+		// the upgrades start once all the active periods are over
+		var end time.Time
+		for i := range upgradePolicy.BlackoutPeriods {
+			period, err := parseBlackoutPeriod(&upgradePolicy.BlackoutPeriods[i])
+			if err == nil && period.contains(now) && period.end.After(end) {
+				end = period.end
+			}
+		}
+		if !end.IsZero() {
+			hint.waitFor(end.Sub(now), RequeueReasonBlackoutPeriod)
+		}
+	case UpgradeStateReasonClusterUnhealthy:
+		// the cluster health is not watched, it is polled
+		hint.waitFor(clusterHealthPollRequeueAfter, RequeueReasonClusterHealth)
+	case UpgradeStateReasonInMaintenanceWindowWait:
+		if upgradePolicy.Schedule == nil {
+			return
+		}
+		window, err := parseMaintenanceWindow(upgradePolicy.Schedule)
+		if err != nil {
+			return
+		}
+		if start, ok := window.nextStart(now, maxRequeueAfter); ok {
+			hint.waitFor(start.Sub(now), RequeueReasonMaintenanceWindow)
+			return
+		}
+		hint.waitFor(maxRequeueAfter, RequeueReasonMaintenanceWindow)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NewResourcePodDeletionFilter returns a PodDeletionFilter selecting the pods which consume any of the extended
+// resources advertised by the device plugins of the driver, e.g. nvidia.com/gpu or rdma/hca, whatever their labels.
+// A resource name ending with '*' matches the resources starting with the name, e.g. nvidia.com/mig-*.
+// The requests and the limits of the containers, init containers included, are checked.
+// The filter can be passed to WithPodDeletionEnabled or NewPodManager.
+func NewResourcePodDeletionFilter(resourceNames ...string) PodDeletionFilter {
+	return func(pod corev1.Pod) bool {
+		for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+			for i := range containers {
+				resources := containers[i].Resources
+				if hasResource(resources.Requests, resourceNames) || hasResource(resources.Limits, resourceNames) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// hasResource returns true if the resource list contains any of the resource names or name prefixes
+func hasResource(resourceList corev1.ResourceList, resourceNames []string) bool {
+	for resource := range resourceList {
+		for _, name := range resourceNames {
+			prefix, isPrefix := strings.CutSuffix(name, "*")
+			if (isPrefix && strings.HasPrefix(string(resource), prefix)) || string(resource) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -44,18 +44,19 @@ import (
 type SafeDriverLoadManagerImpl struct {
 	nodeUpgradeStateProvider NodeUpgradeStateProvider
 	log                      logr.Logger
+	keys                     UpgradeKeys
 }
 
 // IsWaitingForSafeDriverLoad checks if driver Pod on the node is waiting for a safe load.
 // The check is implemented by check that "safe driver loading annotation" is set on the Node object
 func (s *SafeDriverLoadManagerImpl) IsWaitingForSafeDriverLoad(_ context.Context, node *corev1.Node) (bool, error) {
-	return node.Annotations[GetUpgradeDriverWaitForSafeLoadAnnotationKey()] != "", nil
+	return node.Annotations[s.keys.GetUpgradeDriverWaitForSafeLoadAnnotationKey()] != "", nil
 }
 
 // UnblockLoading unblocks driver loading on the node by remove "safe driver loading annotation"
 // from the Node object
 func (s *SafeDriverLoadManagerImpl) UnblockLoading(ctx context.Context, node *corev1.Node) error {
-	annotationKey := GetUpgradeDriverWaitForSafeLoadAnnotationKey()
+	annotationKey := s.keys.GetUpgradeDriverWaitForSafeLoadAnnotationKey()
 	if node.Annotations[annotationKey] == "" {
 		return nil
 	}
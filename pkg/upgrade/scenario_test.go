@@ -0,0 +1,52 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade/upgradetest"
+)
+
+var _ = Describe("RunScenario", func() {
+	DescribeTable("should match the golden trace of the scenario",
+		func(name string) {
+			data, err := os.ReadFile(filepath.Join("testdata", "scenarios", name+".yaml"))
+			Expect(err).To(Succeed())
+			scenario, err := upgradetest.LoadScenario(data)
+			Expect(err).To(Succeed())
+
+			trace, err := upgradetest.RunScenario(context.Background(), scenario)
+			Expect(err).To(Succeed())
+
+			golden, err := os.ReadFile(filepath.Join("testdata", "scenarios", name+".golden"))
+			Expect(err).To(Succeed())
+			Expect(trace.String()).To(Equal(string(golden)))
+		},
+		Entry("rolling upgrade", "rolling-upgrade"),
+	)
+
+	It("should reject unknown fields of the scenario", func() {
+		_, err := upgradetest.LoadScenario([]byte("nodes:\n- name: node-a\n  generation: 1\n"))
+		Expect(err).To(HaveOccurred())
+	})
+})
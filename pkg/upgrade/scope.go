@@ -0,0 +1,69 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// WithUpgradeScopeSelector provides an option to limit the driver upgrades to the nodes matching the label selector.
+// Nodes which stop matching the selector, e.g. after a label removal or a node pool change, are removed
+// from the upgrade: their upgrade labels and annotations are cleaned up and they are excluded from the
+// ClusterUpgradeState. An empty selector matches all nodes.
+func (m *ClusterUpgradeStateManagerImpl) WithUpgradeScopeSelector(selector string) ClusterUpgradeStateManager {
+	scopeSelector, err := labels.Parse(selector)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Ignoring invalid upgrade scope selector", "selector", selector)
+		return m
+	}
+	m.upgradeScopeSelector = scopeSelector
+	return m
+}
+
+// isNodeInUpgradeScope returns true if the node matches the upgrade scope selector
+func (m *ClusterUpgradeStateManagerImpl) isNodeInUpgradeScope(node *corev1.Node) bool {
+	return m.upgradeScopeSelector == nil || m.upgradeScopeSelector.Matches(labels.Set(node.Labels))
+}
+
+// compactOutOfScopeNode removes the upgrade labels and annotations from the node which left the upgrade scope,
+// except its upgrade history.
+// A node left cordoned by the unfinished upgrade is uncordoned, as the upgrade library will never process it again.
+func (m *ClusterUpgradeStateManagerImpl) compactOutOfScopeNode(ctx context.Context, node *corev1.Node) error {
+	if state := m.getNodeUpgradeState(node); state != "" {
+		m.Log.V(consts.LogLevelInfo).Info("Node was removed from the upgrade scope, cleaning up its upgrade state",
+			"node", node.Name, "state", state)
+		logEvent(m.EventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+			"Node was removed from the upgrade scope, upgrade state cleaned up")
+	}
+	if m.isNodeCordonedByUpgrade(node) {
+		m.Log.V(consts.LogLevelInfo).Info("Uncordoning node left cordoned by the upgrade", "node", node.Name)
+		err := m.CordonManager.Uncordon(ctx, node)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Node uncordon failed", "node", node.Name)
+			return err
+		}
+	}
+	if err := m.abortNodeUpgradeRecord(ctx, node); err != nil {
+		return err
+	}
+	return m.removeLibraryOwnedKeys(ctx, node, true)
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// podWorkload is the Deployment or StatefulSet controlling a pod
+type podWorkload struct {
+	// name is the workload as "Kind namespace/name", empty if the pod is controlled by neither
+	name          string
+	readyReplicas int32
+}
+
+// isNodeDrainApproved returns true if the admin approved the drain of the node
+func (k UpgradeKeys) isNodeDrainApproved(node *corev1.Node) bool {
+	return node.Annotations[k.GetUpgradeDrainApprovedAnnotationKey()] == trueString
+}
+
+// isPodRunningAndReady returns true if the pod is running and all its containers are ready
+func isPodRunningAndReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || len(pod.Status.ContainerStatuses) == 0 {
+		return false
+	}
+	for i := range pod.Status.ContainerStatuses {
+		if !pod.Status.ContainerStatuses[i].Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// holdSingleReplicaDrains applies the single replica policy of the drain spec to the nodes of the drain-required
+// state whose drain would evict the only ready replica of a Deployment or a StatefulSet. With the Warn policy,
+// the workloads are reported with an event on the node and the node is drained. With the WaitForScaleUp and
+// RequireApproval policies, the returned cluster state doesn't contain the held nodes, they wait in
+// the drain-required state with the WaitingForScaleUp or DrainApprovalRequired reason. The nodes being drained
+// are not checked again, as their pods are being evicted.
+func (m *ClusterUpgradeStateManagerImpl) holdSingleReplicaDrains(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, drainSpec *v1alpha1.DrainSpec) (*ClusterUpgradeState, error) {
+	nodeStates := currentClusterState.NodeStates[UpgradeStateDrainRequired]
+	if drainSpec == nil || !drainSpec.Enable || drainSpec.SingleReplicaPolicy == "" || len(nodeStates) == 0 {
+		return currentClusterState, nil
+	}
+	m.Log.V(consts.LogLevelInfo).Info("HoldSingleReplicaDrains", "policy", drainSpec.SingleReplicaPolicy)
+
+	drainSelector, err := labels.Parse(drainSpec.PodSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid drain pod selector: %v", err)
+	}
+	statusProvider, _ := m.DrainManager.(DrainStatusProvider)
+	workloads := make(map[string]podWorkload)
+	toDrain := make([]*NodeUpgradeState, 0, len(nodeStates))
+	for _, nodeState := range nodeStates {
+		node := nodeState.Node
+		if statusProvider != nil {
+			if _, draining := statusProvider.GetDrainStatus(node.Name); draining {
+				toDrain = append(toDrain, nodeState)
+				continue
+			}
+		}
+		if m.keys.isNodeManuallyUncordoned(node) {
+			// the node is not drained
+			toDrain = append(toDrain, nodeState)
+			continue
+		}
+		singleReplicas, err := m.getNodeSingleReplicaWorkloads(ctx, node, drainSelector, drainSpec.PriorityThreshold,
+			workloads)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to get the single replica workloads of the node",
+				"node", node.Name)
+			return nil, err
+		}
+		if len(singleReplicas) == 0 {
+			toDrain = append(toDrain, nodeState)
+			continue
+		}
+
+		reason := ""
+		switch drainSpec.SingleReplicaPolicy {
+		case v1alpha1.SingleReplicaPolicyWaitForScaleUp:
+			reason = UpgradeStateReasonWaitingForScaleUp
+		case v1alpha1.SingleReplicaPolicyRequireApproval:
+			if !m.keys.isNodeDrainApproved(node) {
+				reason = UpgradeStateReasonDrainApprovalRequired
+			}
+		}
+		if reason == "" {
+			m.Log.V(consts.LogLevelWarning).Info("Node drain evicts the only ready replica of workloads",
+				"node", node.Name, "workloads", singleReplicas)
+			logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+				"Node drain evicts the only ready replica of %s", strings.Join(singleReplicas, ", "))
+			toDrain = append(toDrain, nodeState)
+			continue
+		}
+
+		m.Log.V(consts.LogLevelInfo).Info("Node drain would evict the only ready replica of workloads, holding it",
+			"node", node.Name, "workloads", singleReplicas, "reason", reason)
+		if m.keys.GetNodeUpgradeStateReason(node) != reason {
+			logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+				"Node drain held, it would evict the only ready replica of %s", strings.Join(singleReplicas, ", "))
+		}
+		err = m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, reason)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to set node upgrade state reason", "node", node.Name)
+			return nil, err
+		}
+	}
+
+	drainState := NewClusterUpgradeState()
+	for state, states := range currentClusterState.NodeStates {
+		drainState.NodeStates[state] = states
+	}
+	drainState.NodeStates[UpgradeStateDrainRequired] = toDrain
+	return &drainState, nil
+}
+
+// getNodeSingleReplicaWorkloads returns the sorted names of the Deployments and StatefulSets whose only ready
+// replica runs on the node and is evicted by the drain. The workloads are cached in the given map by the controller
+// of the pods.
+func (m *ClusterUpgradeStateManagerImpl) getNodeSingleReplicaWorkloads(ctx context.Context, node *corev1.Node,
+	drainSelector labels.Selector, priorityThreshold *int32, workloads map[string]podWorkload) ([]string, error) {
+	pods, err := m.getNodeDrainedPods(ctx, node, drainSelector, priorityThreshold)
+	if err != nil {
+		return nil, err
+	}
+	singleReplicas := make([]string, 0)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || !isPodRunningAndReady(pod) {
+			// the pod doesn't serve the workload anymore
+			continue
+		}
+		workload, err := m.getPodWorkload(ctx, pod, workloads)
+		if err != nil {
+			return nil, err
+		}
+		if workload.name != "" && workload.readyReplicas <= 1 && !slices.Contains(singleReplicas, workload.name) {
+			singleReplicas = append(singleReplicas, workload.name)
+		}
+	}
+	slices.Sort(singleReplicas)
+	return singleReplicas, nil
+}
+
+// getPodWorkload returns the Deployment or StatefulSet controlling the pod, the Deployment through the ReplicaSet
+// of the pod. The returned workload has an empty name if the pod is controlled by neither, or if the workload
+// was deleted.
+func (m *ClusterUpgradeStateManagerImpl) getPodWorkload(ctx context.Context, pod *corev1.Pod,
+	workloads map[string]podWorkload) (podWorkload, error) {
+	controllerRef := metav1.GetControllerOf(pod)
+	if controllerRef == nil {
+		return podWorkload{}, nil
+	}
+	key := controllerRef.Kind + " " + pod.Namespace + "/" + controllerRef.Name
+	if workload, ok := workloads[key]; ok {
+		return workload, nil
+	}
+	workload := podWorkload{}
+	switch controllerRef.Kind {
+	case "StatefulSet":
+		statefulSet, err := m.K8sInterface.AppsV1().StatefulSets(pod.Namespace).Get(ctx, controllerRef.Name,
+			metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return workload, err
+		}
+		if err == nil {
+			workload = podWorkload{name: key, readyReplicas: statefulSet.Status.ReadyReplicas}
+		}
+	case "ReplicaSet":
+		replicaSet, err := m.K8sInterface.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, controllerRef.Name,
+			metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return workload, err
+		}
+		var deploymentRef *metav1.OwnerReference
+		if err == nil {
+			deploymentRef = metav1.GetControllerOf(replicaSet)
+		}
+		if deploymentRef == nil || deploymentRef.Kind != "Deployment" {
+			break
+		}
+		deployment, err := m.K8sInterface.AppsV1().Deployments(pod.Namespace).Get(ctx, deploymentRef.Name,
+			metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return workload, err
+		}
+		if err == nil {
+			workload = podWorkload{
+				name:          "Deployment " + pod.Namespace + "/" + deployment.Name,
+				readyReplicas: deployment.Status.ReadyReplicas,
+			}
+		}
+	}
+	workloads[key] = workload
+	return workload, nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// StateHookStage defines when a StateHookFunc is called relative to the processing of a node in an upgrade state
+type StateHookStage string
+
+const (
+	// StateHookPre hooks are called for every node in the upgrade state before the node is processed.
+	// Their decision applies to the transition of the node out of the state.
+	StateHookPre StateHookStage = "Pre"
+	// StateHookPost hooks are called for every node which left the upgrade state during the processing.
+	// Their decision applies to the next transition of the node, out of the state it was moved to.
+	StateHookPost StateHookStage = "Post"
+)
+
+// StateHookDecision is the decision of a StateHookFunc about the transition of the node.
+// The zero value allows the transition.
+type StateHookDecision struct {
+	// Veto prevents the transition in the current ApplyState pass, the hook is called again on the next pass
+	Veto bool
+	// Delay prevents the transition until the duration elapses, the hooks are not called for the node until then
+	Delay time.Duration
+}
+
+// allowsTransition returns true if the decision allows the transition of the node
+func (d StateHookDecision) allowsTransition() bool {
+	return !d.Veto && d.Delay <= 0
+}
+
+// StateHookFunc is called by ApplyState for a node in an upgrade state. The hook must not modify the node state.
+// An error returned by the hook aborts ApplyState.
+type StateHookFunc func(ctx context.Context, nodeState *NodeUpgradeState) (StateHookDecision, error)
+
+// stateHook is a StateHookFunc registered for an upgrade state
+type stateHook struct {
+	state string
+	stage StateHookStage
+	hook  StateHookFunc
+}
+
+// stateHookHold keeps a node in an upgrade state because of a StateHookDecision
+type stateHookHold struct {
+	state string
+	// until is the end of the delay, the hold is released after a single pass if it is zero
+	until time.Time
+}
+
+// stateHookHolds keeps the nodes held in their upgrade state by the state hooks, by node name
+type stateHookHolds struct {
+	mutex sync.Mutex
+	holds map[string]stateHookHold
+}
+
+// WithStateHook registers a hook called for every node in the given upgrade state, before the node is processed
+// (StateHookPre) or after it left the state (StateHookPost). Hooks can veto or delay the transition of the node,
+// see StateHookDecision. Hooks of a state and stage are called in the order of registration.
+func (m *ClusterUpgradeStateManagerImpl) WithStateHook(state string, stage StateHookStage,
+	hook StateHookFunc) ClusterUpgradeStateManager {
+	if stage != StateHookPre && stage != StateHookPost {
+		m.Log.V(consts.LogLevelWarning).Info("Ignoring state hook with unknown stage", "state", state, "stage", stage)
+		return m
+	}
+	m.stateHooks = append(m.stateHooks, stateHook{state: state, stage: stage, hook: hook})
+	if m.stateHookHolds == nil {
+		m.stateHookHolds = &stateHookHolds{holds: make(map[string]stateHookHold)}
+	}
+	return m
+}
+
+// callStateHooks calls the hooks registered for the state and stage for the node until one of them
+// doesn't allow the transition and returns its decision
+func (m *ClusterUpgradeStateManagerImpl) callStateHooks(ctx context.Context, state string, stage StateHookStage,
+	nodeState *NodeUpgradeState) (StateHookDecision, error) {
+	for _, hook := range m.stateHooks {
+		if hook.state != state || hook.stage != stage {
+			continue
+		}
+		decision, err := hook.hook(ctx, nodeState)
+		if err != nil {
+			return decision, fmt.Errorf("%s state hook failed for state %s, node %s: %v",
+				stage, state, nodeState.Node.Name, err)
+		}
+		if !decision.allowsTransition() {
+			return decision, nil
+		}
+	}
+	return StateHookDecision{}, nil
+}
+
+// filterStateHookNodes returns the nodes of the state which are allowed to transition out of it by the state
+// hook holds and the pre hooks of the state
+func (m *ClusterUpgradeStateManagerImpl) filterStateHookNodes(ctx context.Context, state string,
+	nodeStates []*NodeUpgradeState) ([]*NodeUpgradeState, error) {
+	if m.stateHookHolds == nil {
+		return nodeStates, nil
+	}
+	m.stateHookHolds.mutex.Lock()
+	defer m.stateHookHolds.mutex.Unlock()
+
+	now := time.Now()
+	allowed := make([]*NodeUpgradeState, 0, len(nodeStates))
+	for _, nodeState := range nodeStates {
+		nodeName := nodeState.Node.Name
+		if hold, ok := m.stateHookHolds.holds[nodeName]; ok {
+			switch {
+			case hold.state != state:
+				delete(m.stateHookHolds.holds, nodeName)
+			case hold.until.IsZero():
+				delete(m.stateHookHolds.holds, nodeName)
+				m.Log.V(consts.LogLevelInfo).Info("Node transition vetoed by a state hook", "node", nodeName,
+					"state", state)
+				continue
+			case now.Before(hold.until):
+				m.Log.V(consts.LogLevelDebug).Info("Node transition delayed by a state hook", "node", nodeName,
+					"state", state, "until", hold.until)
+				continue
+			default:
+				delete(m.stateHookHolds.holds, nodeName)
+			}
+		}
+		decision, err := m.callStateHooks(ctx, state, StateHookPre, nodeState)
+		if err != nil {
+			return nil, err
+		}
+		if !decision.allowsTransition() {
+			m.holdNode(nodeName, state, decision, now, false)
+			continue
+		}
+		allowed = append(allowed, nodeState)
+	}
+	return allowed, nil
+}
+
+// withStateHooks wraps process of the phase upgrade state of currentState, so that only the nodes allowed
+// to transition by the state hooks are processed and the post hooks are called afterwards
+func (m *ClusterUpgradeStateManagerImpl) withStateHooks(ctx context.Context, currentState *ClusterUpgradeState,
+	phase string, process func() error) func() error {
+	return func() error {
+		nodeStates := currentState.NodeStates[phase]
+		allowed, err := m.filterStateHookNodes(ctx, phase, nodeStates)
+		if err != nil {
+			return err
+		}
+		currentState.NodeStates[phase] = allowed
+		defer func() { currentState.NodeStates[phase] = nodeStates }()
+		if err := process(); err != nil {
+			return err
+		}
+		return m.callPostStateHooks(ctx, phase, allowed)
+	}
+}
+
+// callPostStateHooks calls the post hooks of the state for the nodes which left the state
+func (m *ClusterUpgradeStateManagerImpl) callPostStateHooks(ctx context.Context, state string,
+	nodeStates []*NodeUpgradeState) error {
+	if m.stateHookHolds == nil {
+		return nil
+	}
+	m.stateHookHolds.mutex.Lock()
+	defer m.stateHookHolds.mutex.Unlock()
+
+	now := time.Now()
+	for _, nodeState := range nodeStates {
+		newState := m.getNodeUpgradeState(nodeState.Node)
+		if newState == state {
+			continue
+		}
+		decision, err := m.callStateHooks(ctx, state, StateHookPost, nodeState)
+		if err != nil {
+			return err
+		}
+		if !decision.allowsTransition() {
+			m.holdNode(nodeState.Node.Name, newState, decision, now, true)
+		}
+	}
+	return nil
+}
+
+// holdNode keeps the node in the state according to the decision, the caller must hold the mutex of the holds.
+// A vetoed node is held only if nextPass is set, i.e. the veto applies to the next pass, otherwise it is
+// only skipped in the current pass.
+func (m *ClusterUpgradeStateManagerImpl) holdNode(nodeName, state string, decision StateHookDecision,
+	now time.Time, nextPass bool) {
+	switch {
+	case decision.Delay > 0:
+		m.Log.V(consts.LogLevelInfo).Info("Node transition delayed by a state hook", "node", nodeName,
+			"state", state, "delay", decision.Delay)
+		m.stateHookHolds.holds[nodeName] = stateHookHold{state: state, until: now.Add(decision.Delay)}
+	case nextPass:
+		m.stateHookHolds.holds[nodeName] = stateHookHold{state: state}
+	default:
+		m.Log.V(consts.LogLevelInfo).Info("Node transition vetoed by a state hook", "node", nodeName,
+			"state", state)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade/metrics"
+)
+
+// stateMetrics updates the upgrade state metrics, if enabled with WithMetrics. It is shared by the state manager
+// and the drain manager, all its methods are no-ops until the metrics are set.
+type stateMetrics struct {
+	mutex   sync.Mutex
+	metrics *metrics.Metrics
+	// lastStates are the upgrade states of the nodes seen by the last update, by node name
+	lastStates map[string]string
+}
+
+// update sets the count of nodes in each upgrade state and counts the state changes since the last update.
+// The nodes are counted by their upgrade state in the storage, so that the transitions made by the current pass
+// are included.
+func (s *stateMetrics) update(currentState *ClusterUpgradeState, storage NodeUpgradeStateStorage) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.metrics == nil {
+		return
+	}
+
+	counts := make(map[string]int)
+	states := make(map[string]string)
+	for passState, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			state := storage.GetState(nodeState.Node)
+			counts[state]++
+			states[nodeState.Node.Name] = state
+			// changes made since the last update, e.g. by a drain, and by the current pass
+			if lastState, seen := s.lastStates[nodeState.Node.Name]; seen && lastState != passState {
+				s.recordTransition(lastState, passState)
+			}
+			if passState != state {
+				s.recordTransition(passState, state)
+			}
+		}
+	}
+	s.metrics.SetNodesInState(counts)
+	s.lastStates = states
+}
+
+// recordTransition counts the node upgrade state change, and the failure if the node moved to the failed state
+func (s *stateMetrics) recordTransition(from, to string) {
+	s.metrics.RecordTransition(from, to)
+	if to == UpgradeStateFailed {
+		s.metrics.RecordFailure(from)
+	}
+}
+
+// observeDrain records the duration of a node drain started at start
+func (s *stateMetrics) observeDrain(start time.Time, err error) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.metrics != nil {
+		s.metrics.ObserveDrainDuration(time.Since(start), err == nil)
+	}
+}
+
+// observePhase records the duration and the API requests of an upgrade phase
+func (s *stateMetrics) observePhase(stat PhaseStat) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.metrics != nil {
+		s.metrics.ObservePhase(stat.Phase, stat.Duration, stat.APICalls)
+	}
+}
+
+// WithMetrics provides an option to export the upgrade state metrics, see the metrics package, with the registerer,
+// e.g. the controller-runtime metrics.Registry. The metrics are updated on every ApplyState pass, the metrics of
+// the DrainManager and PodManager worker pools are read from GetWorkerPoolStats on every scrape.
+// SetDriverName should be called first, as the driver name is added as a label to the metrics.
+func (m *ClusterUpgradeStateManagerImpl) WithMetrics(registerer prometheus.Registerer) ClusterUpgradeStateManager {
+	if m.stateMetrics == nil {
+		m.Log.V(consts.LogLevelWarning).Info("Cannot enable upgrade state metrics, the manager has no metrics")
+		return m
+	}
+	upgradeMetrics := metrics.New(DriverName)
+	upgradeMetrics.SetWorkerPoolStats(func() map[string]metrics.WorkerPoolStats {
+		stats := make(map[string]metrics.WorkerPoolStats)
+		for pool, poolStats := range m.GetWorkerPoolStats() {
+			stats[pool] = metrics.WorkerPoolStats(poolStats)
+		}
+		return stats
+	})
+	if err := upgradeMetrics.Register(registerer); err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to register upgrade state metrics")
+		return m
+	}
+	m.stateMetrics.mutex.Lock()
+	defer m.stateMetrics.mutex.Unlock()
+	m.stateMetrics.metrics = upgradeMetrics
+	return m
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// GetNodeUpgradeStateReason returns the reason of the node's current upgrade state,
+// or an empty string if no reason is set
+func (k UpgradeKeys) GetNodeUpgradeStateReason(node *corev1.Node) string {
+	return node.Annotations[k.GetUpgradeStateReasonAnnotationKey()]
+}
+
+// GetNodeUpgradeStateReason returns UpgradeKeys.GetNodeUpgradeStateReason with the default key prefix
+func GetNodeUpgradeStateReason(node *corev1.Node) string {
+	return UpgradeKeys{}.GetNodeUpgradeStateReason(node)
+}
+
+// CountNodesByStateReason returns the count of nodes per upgrade state reason for every node
+// in the given state which has a reason set
+func (k UpgradeKeys) CountNodesByStateReason(currentState *ClusterUpgradeState) map[string]int {
+	counts := make(map[string]int)
+	for _, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			if reason := k.GetNodeUpgradeStateReason(nodeState.Node); reason != "" {
+				counts[reason]++
+			}
+		}
+	}
+	return counts
+}
+
+// CountNodesByStateReason returns UpgradeKeys.CountNodesByStateReason with the default key prefix
+func CountNodesByStateReason(currentState *ClusterUpgradeState) map[string]int {
+	return UpgradeKeys{}.CountNodesByStateReason(currentState)
+}
+
+// setNodeUpgradeStateReason sets the reason of the node's current upgrade state.
+// The node is patched only if the reason differs from the one already set.
+func (k UpgradeKeys) setNodeUpgradeStateReason(ctx context.Context, provider NodeUpgradeStateProvider,
+	node *corev1.Node, reason string) error {
+	if k.GetNodeUpgradeStateReason(node) == reason {
+		return nil
+	}
+	value := reason
+	if value == "" {
+		value = nullString
+	}
+	return provider.ChangeNodeUpgradeAnnotation(ctx, node, k.GetUpgradeStateReasonAnnotationKey(), value)
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NodeUpgradeStateStorage persists the upgrade state of the nodes. The NodeUpgradeStateProvider writes the state
+// with it and the upgrade state manager reads the state with it. Implementations may keep the state outside of
+// the node object, e.g. in a custom resource per node, as long as GetState reflects the states written by SetState.
+// The storage is set with WithNodeUpgradeStateStorage, LabelStateStorage is used by default. The storages of this
+// package use the keys of the key prefix of the manager they are set on, see WithKeyPrefix.
+type NodeUpgradeStateStorage interface {
+	// GetState returns the upgrade state of the node, or an empty string if the node has none
+	GetState(node *corev1.Node) string
+	// SetState persists the upgrade state of the node. The upgrade state reason annotation of the node, which
+	// describes the previous state, is removed as well. The node object is updated with the changes.
+	// The NodeUpgradeStateProvider calls it again with the latest version of the node if it returns a conflict error,
+	// e.g. if it writes with an optimistic lock on the version of the node.
+	SetState(ctx context.Context, k8sClient client.Client, node *corev1.Node, state string) error
+	// ListOptions returns the options restricting a node list to the nodes which may have an upgrade state
+	ListOptions() []client.ListOption
+}
+
+// WithNodeUpgradeStateStorage provides an option to store the upgrade state of the nodes in the given storage
+// instead of the state label. The storage is used by the manager, its NodeUpgradeStateProvider and its managers.
+func (m *ClusterUpgradeStateManagerImpl) WithNodeUpgradeStateStorage(
+	storage NodeUpgradeStateStorage) ClusterUpgradeStateManager {
+	m.stateStorage = bindStateStorage(storage, m.keys)
+	setProviderStateStorage(m.NodeUpgradeStateProvider, m.stateStorage)
+	return m
+}
+
+// GetNodeUpgradeStateStorage returns the storage of the node upgrade states of the manager,
+// see WithNodeUpgradeStateStorage
+func (m *ClusterUpgradeStateManagerImpl) GetNodeUpgradeStateStorage() NodeUpgradeStateStorage {
+	return m.stateStorage
+}
+
+// getNodeUpgradeState returns the upgrade state of the node in the storage of the manager
+func (m *ClusterUpgradeStateManagerImpl) getNodeUpgradeState(node *corev1.Node) string {
+	return m.stateStorage.GetState(node)
+}
+
+// setProviderStateStorage sets the storage of the node upgrade states of the provider, if it is implemented
+// by this package
+func setProviderStateStorage(provider NodeUpgradeStateProvider, storage NodeUpgradeStateStorage) {
+	switch p := unwrapNodeUpgradeStateProvider(provider).(type) {
+	case *NodeUpgradeStateProviderImpl:
+		p.WithStateStorage(storage)
+	case *CachedNodeUpgradeStateProvider:
+		p.WithStateStorage(storage)
+	}
+}
+
+// optimisticLockStateStorage is implemented by the storages of this package, optimisticLock returns true if the
+// storage writes the state with an optimistic lock on the version of the node, i.e. its writes may conflict
+type optimisticLockStateStorage interface {
+	optimisticLock() bool
+}
+
+// keyedStateStorage is implemented by the storages of this package, whose keys follow the key prefix
+type keyedStateStorage interface {
+	// withKeys returns a copy of the storage using the given keys
+	withKeys(keys UpgradeKeys) NodeUpgradeStateStorage
+}
+
+// bindStateStorage returns the storage with the given keys, if it is implemented by this package
+func bindStateStorage(storage NodeUpgradeStateStorage, keys UpgradeKeys) NodeUpgradeStateStorage {
+	if keyed, ok := storage.(keyedStateStorage); ok {
+		return keyed.withKeys(keys)
+	}
+	return storage
+}
+
+// LabelStateStorage stores the node upgrade state in the upgrade state label of the node. This is the default.
+type LabelStateStorage struct {
+	keys UpgradeKeys
+}
+
+func (s LabelStateStorage) withKeys(keys UpgradeKeys) NodeUpgradeStateStorage {
+	s.keys = keys
+	return s
+}
+
+func (s LabelStateStorage) optimisticLock() bool {
+	return false
+}
+
+// GetState implements NodeUpgradeStateStorage
+func (s LabelStateStorage) GetState(node *corev1.Node) string {
+	return node.Labels[s.keys.GetUpgradeStateLabelKey()]
+}
+
+// SetState implements NodeUpgradeStateStorage
+func (s LabelStateStorage) SetState(ctx context.Context, k8sClient client.Client, node *corev1.Node,
+	state string) error {
+	return s.keys.patchNodeUpgradeState(ctx, k8sClient, node,
+		map[string]interface{}{s.keys.GetUpgradeStateLabelKey(): state}, map[string]interface{}{})
+}
+
+// ListOptions implements NodeUpgradeStateStorage
+func (s LabelStateStorage) ListOptions() []client.ListOption {
+	return []client.ListOption{client.HasLabels{s.keys.GetUpgradeStateLabelKey()}}
+}
+
+// defaultStateFieldOwner is the field manager of the state label applied by ServerSideApplyStateStorage
+// if it has no FieldOwner
+const defaultStateFieldOwner = "k8s-operator-libs-upgrade"
+
+// ServerSideApplyStateStorage stores the node upgrade state in the upgrade state label of the node, like
+// LabelStateStorage, with server-side apply. The label is owned by the FieldOwner field manager, so that the state
+// is written without conflicting with the other writers of the node, whatever the version of the node object.
+type ServerSideApplyStateStorage struct {
+	// FieldOwner is the field manager applying the state label, k8s-operator-libs-upgrade if empty
+	FieldOwner string
+
+	keys UpgradeKeys
+}
+
+func (s ServerSideApplyStateStorage) withKeys(keys UpgradeKeys) NodeUpgradeStateStorage {
+	s.keys = keys
+	return s
+}
+
+func (s ServerSideApplyStateStorage) optimisticLock() bool {
+	return false
+}
+
+// GetState implements NodeUpgradeStateStorage
+func (s ServerSideApplyStateStorage) GetState(node *corev1.Node) string {
+	return node.Labels[s.keys.GetUpgradeStateLabelKey()]
+}
+
+// SetState implements NodeUpgradeStateStorage. The upgrade state reason annotation is not owned by the field
+// manager, so it is removed with a separate patch.
+func (s ServerSideApplyStateStorage) SetState(ctx context.Context, k8sClient client.Client, node *corev1.Node,
+	state string) error {
+	fieldOwner := s.FieldOwner
+	if fieldOwner == "" {
+		fieldOwner = defaultStateFieldOwner
+	}
+	_, hasReason := node.Annotations[s.keys.GetUpgradeStateReasonAnnotationKey()]
+	patch := []byte(fmt.Sprintf(`{"apiVersion":"v1","kind":"Node","metadata":{"name":%q,"labels":{%q: %q}}}`,
+		node.Name, s.keys.GetUpgradeStateLabelKey(), state))
+	err := k8sClient.Patch(ctx, node, client.RawPatch(types.ApplyPatchType, patch), client.FieldOwner(fieldOwner),
+		client.ForceOwnership)
+	if err != nil || !hasReason {
+		return err
+	}
+	patch = []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q: null}}}`, s.keys.GetUpgradeStateReasonAnnotationKey()))
+	return k8sClient.Patch(ctx, node, client.RawPatch(types.MergePatchType, patch))
+}
+
+// ListOptions implements NodeUpgradeStateStorage
+func (s ServerSideApplyStateStorage) ListOptions() []client.ListOption {
+	return []client.ListOption{client.HasLabels{s.keys.GetUpgradeStateLabelKey()}}
+}
+
+// AnnotationStateStorage stores the node upgrade state in the upgrade state annotation of the node, so that
+// the label selectors of the nodes are not affected by the upgrade and the state value isn't restricted
+// to a label value. The nodes are marked with the constant upgrade-state-stored label, so that they can still be
+// listed by label. The state label is read if the node has no state annotation, e.g. when migrating from
+// LabelStateStorage, and is removed when the state annotation is set.
+type AnnotationStateStorage struct {
+	keys UpgradeKeys
+}
+
+func (s AnnotationStateStorage) withKeys(keys UpgradeKeys) NodeUpgradeStateStorage {
+	s.keys = keys
+	return s
+}
+
+func (s AnnotationStateStorage) optimisticLock() bool {
+	return false
+}
+
+// GetState implements NodeUpgradeStateStorage
+func (s AnnotationStateStorage) GetState(node *corev1.Node) string {
+	if state, ok := node.Annotations[s.keys.GetUpgradeStateAnnotationKey()]; ok {
+		return state
+	}
+	return node.Labels[s.keys.GetUpgradeStateLabelKey()]
+}
+
+// SetState implements NodeUpgradeStateStorage
+func (s AnnotationStateStorage) SetState(ctx context.Context, k8sClient client.Client, node *corev1.Node,
+	state string) error {
+	labels := map[string]interface{}{s.keys.GetUpgradeStateStoredLabelKey(): trueString}
+	if _, ok := node.Labels[s.keys.GetUpgradeStateLabelKey()]; ok {
+		labels[s.keys.GetUpgradeStateLabelKey()] = nil
+	}
+	return s.keys.patchNodeUpgradeState(ctx, k8sClient, node, labels,
+		map[string]interface{}{s.keys.GetUpgradeStateAnnotationKey(): state})
+}
+
+// ListOptions implements NodeUpgradeStateStorage, the nodes are selected by the upgrade-state-stored label.
+// The manager lists the nodes migrated from LabelStateStorage, which have only the state label until their state
+// is written again, by the state label as well.
+func (s AnnotationStateStorage) ListOptions() []client.ListOption {
+	return []client.ListOption{client.HasLabels{s.keys.GetUpgradeStateStoredLabelKey()}}
+}
+
+func (s AnnotationStateStorage) legacyListOptions() []client.ListOption {
+	return LabelStateStorage{keys: s.keys}.ListOptions()
+}
+
+// migratingStateStorage is implemented by the storages which read the state label of the nodes migrated from
+// LabelStateStorage until their state is written again
+type migratingStateStorage interface {
+	// legacyListOptions returns the options restricting a node list to the nodes with the state label
+	legacyListOptions() []client.ListOption
+}
+
+// listStateStorageNodes lists the nodes which may have an upgrade state in the storage. The nodes of a storage
+// migrated from LabelStateStorage are listed by the state label as well, so that the nodes whose state wasn't
+// written since the migration are not missed.
+func listStateStorageNodes(ctx context.Context, k8sClient client.Client,
+	storage NodeUpgradeStateStorage) ([]corev1.Node, error) {
+	nodeList := &corev1.NodeList{}
+	if err := k8sClient.List(ctx, nodeList, storage.ListOptions()...); err != nil {
+		return nil, err
+	}
+	migrating, ok := storage.(migratingStateStorage)
+	if !ok {
+		return nodeList.Items, nil
+	}
+	legacyNodeList := &corev1.NodeList{}
+	if err := k8sClient.List(ctx, legacyNodeList, migrating.legacyListOptions()...); err != nil {
+		return nil, err
+	}
+	listedNodes := make(map[string]bool, len(nodeList.Items))
+	for i := range nodeList.Items {
+		listedNodes[nodeList.Items[i].Name] = true
+	}
+	nodes := nodeList.Items
+	for i := range legacyNodeList.Items {
+		if !listedNodes[legacyNodeList.Items[i].Name] {
+			nodes = append(nodes, legacyNodeList.Items[i])
+		}
+	}
+	return nodes, nil
+}
+
+// patchNodeUpgradeState sets the given labels and annotations of the node, a nil value removing the key, and removes
+// the upgrade state reason annotation, if any, with the same patch
+func (k UpgradeKeys) patchNodeUpgradeState(ctx context.Context, k8sClient client.Client, node *corev1.Node,
+	labels, annotations map[string]interface{}) error {
+	if _, ok := node.Annotations[k.GetUpgradeStateReasonAnnotationKey()]; ok {
+		// the reason describes the current state, so it is removed together with the state change
+		annotations[k.GetUpgradeStateReasonAnnotationKey()] = nil
+	}
+	metadata := map[string]interface{}{}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return err
+	}
+	return k8sClient.Patch(ctx, node, client.RawPatch(types.StrategicMergePatchType, patch))
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TaintStateStorage stores the node upgrade state in the value of the upgrade state taint of the node, so that
+// the scheduling of the node follows its upgrade state. The effect of the taint is given by Effect for every state.
+// States with no effect, e.g. upgrade-done, don't restrict the scheduling and are stored in the upgrade state
+// annotation instead, like AnnotationStateStorage does, and the nodes are marked with the upgrade-state-stored label
+// as well. The state label is read if the node has neither, e.g. when migrating from LabelStateStorage, and is
+// removed when the state is set.
+//
+// The driver pods and the helper pods, e.g. the validation pods, must tolerate the taint,
+// see GetUpgradeStateToleration.
+type TaintStateStorage struct {
+	// Effect returns the effect of the state taint in the given upgrade state, or an empty effect if the state
+	// is not stored in the taint. DefaultStateTaintEffect is used if not set.
+	Effect func(state string) corev1.TaintEffect
+
+	keys UpgradeKeys
+}
+
+func (s TaintStateStorage) withKeys(keys UpgradeKeys) NodeUpgradeStateStorage {
+	s.keys = keys
+	return s
+}
+
+func (s TaintStateStorage) optimisticLock() bool {
+	return true
+}
+
+// DefaultStateTaintEffect is the default effect of the upgrade state taint: no new pods are scheduled on the nodes
+// from the cordon-required to the validation-required state and in the upgrade-failed state, other states are not
+// stored in the taint. If the upgrade policy uncordons the failed nodes, use an Effect without upgrade-failed.
+func DefaultStateTaintEffect(state string) corev1.TaintEffect {
+	switch state {
+	case UpgradeStateCordonRequired, UpgradeStateWaitForJobsRequired, UpgradeStatePodDeletionRequired,
+		UpgradeStateDrainRequired, UpgradeStateRebootRequired, UpgradeStatePodRestartRequired,
+		UpgradeStateValidationRequired, UpgradeStateFailed:
+		return corev1.TaintEffectNoSchedule
+	}
+	return ""
+}
+
+// GetUpgradeStateToleration returns the toleration of the upgrade state taint of TaintStateStorage
+func (k UpgradeKeys) GetUpgradeStateToleration() corev1.Toleration {
+	return corev1.Toleration{Key: k.GetUpgradeStateTaintKey(), Operator: corev1.TolerationOpExists}
+}
+
+// GetUpgradeStateToleration returns UpgradeKeys.GetUpgradeStateToleration with the default key prefix
+func GetUpgradeStateToleration() corev1.Toleration {
+	return UpgradeKeys{}.GetUpgradeStateToleration()
+}
+
+// GetState implements NodeUpgradeStateStorage
+func (s TaintStateStorage) GetState(node *corev1.Node) string {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == s.keys.GetUpgradeStateTaintKey() {
+			return taint.Value
+		}
+	}
+	return AnnotationStateStorage{keys: s.keys}.GetState(node)
+}
+
+// SetState implements NodeUpgradeStateStorage. The taints are replaced with a patch guarded by the resource
+// version of the node, so that the taints set concurrently by other controllers are not lost.
+func (s TaintStateStorage) SetState(ctx context.Context, k8sClient client.Client, node *corev1.Node,
+	state string) error {
+	effect := DefaultStateTaintEffect(state)
+	if s.Effect != nil {
+		effect = s.Effect(state)
+	}
+	taintKey := s.keys.GetUpgradeStateTaintKey()
+
+	updated := node.DeepCopy()
+	taints := make([]corev1.Taint, 0, len(node.Spec.Taints)+1)
+	for _, taint := range node.Spec.Taints {
+		if taint.Key != taintKey {
+			taints = append(taints, taint)
+		}
+	}
+	if effect != "" {
+		taints = append(taints, corev1.Taint{Key: taintKey, Value: state, Effect: effect})
+		delete(updated.Annotations, s.keys.GetUpgradeStateAnnotationKey())
+	} else {
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[s.keys.GetUpgradeStateAnnotationKey()] = state
+	}
+	updated.Spec.Taints = taints
+	// the reason describes the current state, so it is removed together with the state change
+	delete(updated.Annotations, s.keys.GetUpgradeStateReasonAnnotationKey())
+	delete(updated.Labels, s.keys.GetUpgradeStateLabelKey())
+	if updated.Labels == nil {
+		updated.Labels = map[string]string{}
+	}
+	updated.Labels[s.keys.GetUpgradeStateStoredLabelKey()] = trueString
+
+	err := k8sClient.Patch(ctx, updated, client.MergeFromWithOptions(node, client.MergeFromWithOptimisticLock{}))
+	if err != nil {
+		return err
+	}
+	*node = *updated
+	return nil
+}
+
+// ListOptions implements NodeUpgradeStateStorage, the nodes are selected by the upgrade-state-stored label.
+// The nodes migrated from LabelStateStorage are listed by the state label as well, see AnnotationStateStorage.
+func (s TaintStateStorage) ListOptions() []client.ListOption {
+	return AnnotationStateStorage{keys: s.keys}.ListOptions()
+}
+
+func (s TaintStateStorage) legacyListOptions() []client.ListOption {
+	return AnnotationStateStorage{keys: s.keys}.legacyListOptions()
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// isStateTimeoutSupported returns true if a timeout can be configured for the upgrade state,
+// i.e. the state is part of an upgrade in progress
+func isStateTimeoutSupported(state string) bool {
+	switch state {
+	case UpgradeStateCordonRequired, UpgradeStateWaitForJobsRequired, UpgradeStatePodDeletionRequired,
+		UpgradeStateDrainRequired, UpgradeStateRebootRequired, UpgradeStatePodRestartRequired,
+		UpgradeStateValidationRequired, UpgradeStateUncordonRequired:
+		return true
+	}
+	return false
+}
+
+// validateNodeStateTimeouts returns an error if the upgrade policy has a timeout for an upgrade state
+// which can't be limited
+func validateNodeStateTimeouts(policy *v1alpha1.DriverUpgradePolicySpec) error {
+	for state := range policy.NodeStateTimeoutSeconds {
+		if !isStateTimeoutSupported(state) {
+			return fmt.Errorf("timeout is not supported for upgrade state %q", state)
+		}
+	}
+	return nil
+}
+
+// processNodeStateTimeouts moves the nodes which stayed in an upgrade state longer than the timeout of the state
+// to the UpgradeStateFailed state. The time a node entered its state is tracked with an annotation containing
+// the state and the time, so that the tracking restarts whenever the state changes.
+// The returned cluster state doesn't contain the failed nodes, they are processed on the next pass.
+func (m *ClusterUpgradeStateManagerImpl) processNodeStateTimeouts(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, timeouts map[string]int) (*ClusterUpgradeState, error) {
+	if len(timeouts) == 0 {
+		return currentClusterState, nil
+	}
+	m.Log.V(consts.LogLevelInfo).Info("ProcessNodeStateTimeouts")
+
+	annotationKey := m.keys.GetUpgradeStateStartTimeAnnotationKey()
+	now := time.Now().Unix()
+	remainingState := NewClusterUpgradeState()
+	for state, nodeStates := range currentClusterState.NodeStates {
+		timeoutSeconds := timeouts[state]
+		if timeoutSeconds <= 0 || !isStateTimeoutSupported(state) {
+			remainingState.NodeStates[state] = nodeStates
+			continue
+		}
+		for _, nodeState := range nodeStates {
+			node := nodeState.Node
+			startTime, ok := getStateStartTime(node.Annotations[annotationKey], state)
+			if !ok {
+				// add the annotation to track the time the node entered the state
+				value := fmt.Sprintf("%s/%d", state, now)
+				err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, value)
+				if err != nil {
+					m.Log.V(consts.LogLevelError).Error(err, "Failed to add annotation to track upgrade state time",
+						"node", node.Name, "annotation", annotationKey)
+					return nil, err
+				}
+				remainingState.NodeStates[state] = append(remainingState.NodeStates[state], nodeState)
+				continue
+			}
+			if now <= startTime+int64(timeoutSeconds) {
+				remainingState.NodeStates[state] = append(remainingState.NodeStates[state], nodeState)
+				continue
+			}
+
+			// timeout exceeded, mark node in failed state
+			m.Log.V(consts.LogLevelInfo).Info("Timeout exceeded in upgrade state", "node", node.Name,
+				"state", state, "timeoutSeconds", timeoutSeconds)
+			logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+				"Node stayed in %s state longer than %d seconds, moving it to %s state",
+				state, timeoutSeconds, UpgradeStateFailed)
+			err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(
+					err, "Failed to change node upgrade state", "node", node.Name, "state", UpgradeStateFailed)
+				return nil, err
+			}
+			err = m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonStateTimeout)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &remainingState, nil
+}
+
+// getStateStartTime parses the upgrade state start time annotation value and returns the start time
+// if the annotation tracks the given state
+func getStateStartTime(value, state string) (int64, bool) {
+	trackedState, startTime, found := strings.Cut(value, "/")
+	if !found || trackedState != state {
+		return 0, false
+	}
+	parsed, err := strconv.ParseInt(startTime, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return parsed, true
+}
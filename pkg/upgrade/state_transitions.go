@@ -0,0 +1,264 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// StateHandler processes the nodes in an upgrade state of currentState and moves them to the next states
+// with provider, so that the custom states registered with WithCustomState are entered
+type StateHandler func(ctx context.Context, currentState *ClusterUpgradeState, provider NodeUpgradeStateProvider) error
+
+// StateTransition is an entry of the transition table of ApplyState: the nodes in State are processed by Handler,
+// which moves them to one of NextStates
+type StateTransition struct {
+	// State is the upgrade state of the processed nodes
+	State string
+	// Handler processes the nodes in State
+	Handler StateHandler
+	// NextStates are the upgrade states the handler may move the nodes to. A node may also be moved
+	// to the upgrade-failed state, from any state. A handler moving a node to another state fails ApplyState.
+	NextStates []string
+}
+
+// builtInStateTransitions are the upgrade states of the library in the order ApplyState processes them,
+// with the states their nodes are moved to besides UpgradeStateFailed. The handlers of the states depend
+// on the upgrade policy of the pass and are set by ApplyState, which checks them against the next states.
+// The nodes of the daemonset-missing state of BuildState are the nodes of any state whose driver DaemonSet
+// disappeared, its handler parks them in the daemonset-missing state. The nodes whose upgrade is aborted,
+// see AbortNodeUpgrades, leave their state outside of the handlers.
+var builtInStateTransitions = []StateTransition{
+	{State: UpgradeStateUnknown, NextStates: []string{UpgradeStateUpgradeRequired, UpgradeStateDone}},
+	{State: UpgradeStateDone, NextStates: []string{UpgradeStateUpgradeRequired}},
+	{State: UpgradeStateDaemonSetMissing, NextStates: []string{UpgradeStateUnknown}},
+	{State: UpgradeStateBlockedBySkew, NextStates: []string{UpgradeStateUnknown}},
+	{State: UpgradeStateUpgradeRequired, NextStates: []string{UpgradeStateCordonRequired, UpgradeStateBlockedBySkew}},
+	// the nodes whose maintenance is delegated, see WithNodeMaintenance, are drained by the maintenance operator
+	{State: UpgradeStateCordonRequired,
+		NextStates: []string{UpgradeStateWaitForJobsRequired, UpgradeStatePodRestartRequired}},
+	{State: UpgradeStateWaitForJobsRequired,
+		NextStates: []string{UpgradeStatePodDeletionRequired, UpgradeStateDrainRequired}},
+	{State: UpgradeStatePodDeletionRequired,
+		NextStates: []string{UpgradeStateDrainRequired, UpgradeStatePodRestartRequired}},
+	{State: UpgradeStateDrainRequired, NextStates: []string{UpgradeStatePodRestartRequired}},
+	{State: UpgradeStateRebootRequired, NextStates: []string{UpgradeStatePodRestartRequired}},
+	{State: UpgradeStatePodRestartRequired, NextStates: []string{UpgradeStateRebootRequired,
+		UpgradeStateValidationRequired, UpgradeStateUncordonRequired, UpgradeStateDone}},
+	{State: UpgradeStateFailed,
+		NextStates: []string{UpgradeStateUpgradeRequired, UpgradeStateUncordonRequired, UpgradeStateDone}},
+	{State: UpgradeStateValidationRequired, NextStates: []string{UpgradeStateUncordonRequired, UpgradeStateDone}},
+	{State: UpgradeStateUncordonRequired, NextStates: []string{UpgradeStateDone}},
+}
+
+// customState is a StateTransition registered with WithCustomState, entered by the nodes moved from
+// the from state to the to state
+type customState struct {
+	StateTransition
+	from string
+	to   string
+}
+
+// WithCustomState provides an option to insert a custom upgrade state, e.g. "firmware-update-required", between
+// the from and to states of the transition table: the nodes moved from the from state to the to state are moved
+// to the custom state instead, the nodes in the custom state are processed by the handler of the transition right
+// after the nodes in the from state, and the handler moves them to one of its next states, usually the to state.
+// from may be a custom state registered before. A node moved by the handler to another state than its next states
+// and upgrade-failed fails ApplyState. The nodes in a custom state count as upgrades in progress.
+func (m *ClusterUpgradeStateManagerImpl) WithCustomState(from, to string,
+	transition StateTransition) ClusterUpgradeStateManager {
+	if err := m.validateCustomState(from, to, transition); err != nil {
+		m.Log.V(consts.LogLevelWarning).Info("Ignoring invalid custom upgrade state", "state", transition.State,
+			"from", from, "to", to, "reason", err.Error())
+		return m
+	}
+	m.customStates = append(m.customStates, customState{StateTransition: transition, from: from, to: to})
+	// (re)wrap the provider, so that the managers move the nodes to the custom state as well
+	return m.WithNodeUpgradeStateProvider(m.NodeUpgradeStateProvider)
+}
+
+// validateCustomState returns an error if the custom state can't be inserted between the from and to states
+func (m *ClusterUpgradeStateManagerImpl) validateCustomState(from, to string, transition StateTransition) error {
+	if transition.State == "" || transition.Handler == nil || len(transition.NextStates) == 0 {
+		return fmt.Errorf("the state, the handler and the next states are required")
+	}
+	if m.getStateTransition(transition.State) != nil {
+		return fmt.Errorf("state %s is already defined", transition.State)
+	}
+	fromTransition := m.getStateTransition(from)
+	if fromTransition == nil {
+		return fmt.Errorf("unknown from state %s", from)
+	}
+	if !slices.Contains(fromTransition.NextStates, to) {
+		return fmt.Errorf("%s is not a next state of %s", to, from)
+	}
+	if redirect := m.getCustomStateRedirect(from, to); redirect != to {
+		return fmt.Errorf("the transition from %s to %s is already redirected to %s", from, to, redirect)
+	}
+	return nil
+}
+
+// getStateTransition returns the built-in or custom transition of the state, nil if the state is unknown
+func (m *ClusterUpgradeStateManagerImpl) getStateTransition(state string) *StateTransition {
+	for i := range builtInStateTransitions {
+		if builtInStateTransitions[i].State == state {
+			return &builtInStateTransitions[i]
+		}
+	}
+	for i := range m.customStates {
+		if m.customStates[i].State == state {
+			return &m.customStates[i].StateTransition
+		}
+	}
+	return nil
+}
+
+// getCustomStateRedirect returns the state a node moved from the from state to the to state is moved to,
+// i.e. the custom state inserted between them if any, to otherwise
+func (m *ClusterUpgradeStateManagerImpl) getCustomStateRedirect(from, to string) string {
+	for _, custom := range m.customStates {
+		if custom.from == from && custom.to == to {
+			return custom.State
+		}
+	}
+	return to
+}
+
+// getStateTransitions returns the transition table of the pass: the built-in states with the given handlers,
+// each followed by the custom states inserted after it
+func (m *ClusterUpgradeStateManagerImpl) getStateTransitions(handlers map[string]StateHandler) []StateTransition {
+	transitions := make([]StateTransition, 0, len(builtInStateTransitions)+len(m.customStates))
+	var appendTransition func(transition StateTransition)
+	appendTransition = func(transition StateTransition) {
+		transitions = append(transitions, transition)
+		for _, custom := range m.customStates {
+			if custom.from == transition.State {
+				appendTransition(m.withNextStatesCheck(custom.StateTransition))
+			}
+		}
+	}
+	for _, transition := range builtInStateTransitions {
+		transition.Handler = handlers[transition.State]
+		appendTransition(m.withNextStatesCheck(transition))
+	}
+	return transitions
+}
+
+// withNextStatesCheck wraps the handler of the transition, so that the nodes moved by the handler to a state other
+// than the next states of the transition, the custom states they are redirected to and upgrade-failed fail the pass
+func (m *ClusterUpgradeStateManagerImpl) withNextStatesCheck(transition StateTransition) StateTransition {
+	handler := transition.Handler
+	allowedStates := []string{transition.State, UpgradeStateFailed}
+	for _, nextState := range transition.NextStates {
+		allowedStates = append(allowedStates, nextState, m.getCustomStateRedirect(transition.State, nextState))
+	}
+	transition.Handler = func(ctx context.Context, currentState *ClusterUpgradeState,
+		provider NodeUpgradeStateProvider) error {
+		nodeStates := currentState.NodeStates[transition.State]
+		previousStates := make([]string, len(nodeStates))
+		for i, nodeState := range nodeStates {
+			previousStates[i] = m.getNodeUpgradeState(nodeState.Node)
+		}
+		if err := handler(ctx, currentState, provider); err != nil {
+			return err
+		}
+		var invalid []string
+		for i, nodeState := range nodeStates {
+			state := m.getNodeUpgradeState(nodeState.Node)
+			if state != previousStates[i] && !slices.Contains(allowedStates, state) {
+				invalid = append(invalid, fmt.Sprintf("%s -> %s", nodeState.Node.Name, state))
+			}
+		}
+		if len(invalid) > 0 {
+			return fmt.Errorf("nodes moved out of the %s state to a state other than %s: %s",
+				transition.State, strings.Join(transition.NextStates, ", "), strings.Join(invalid, ", "))
+		}
+		return nil
+	}
+	return transition
+}
+
+// getCustomStates returns the names of the registered custom states
+func (m *ClusterUpgradeStateManagerImpl) getCustomStates() []string {
+	states := make([]string, 0, len(m.customStates))
+	for _, custom := range m.customStates {
+		states = append(states, custom.State)
+	}
+	return states
+}
+
+// customStateProvider is a NodeUpgradeStateProvider moving the nodes to the custom states registered with
+// WithCustomState, see ClusterUpgradeStateManagerImpl.getCustomStateRedirect
+type customStateProvider struct {
+	NodeUpgradeStateProvider
+	redirect func(from, to string) string
+	// getState returns the current upgrade state of the node
+	getState func(node *corev1.Node) string
+}
+
+// ChangeNodeUpgradeState moves the node to newNodeState or to the custom state inserted between the current state
+// of the node and newNodeState
+func (p *customStateProvider) ChangeNodeUpgradeState(ctx context.Context, node *corev1.Node,
+	newNodeState string) error {
+	return p.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node,
+		p.redirect(p.getState(node), newNodeState))
+}
+
+// ChangeNodesUpgradeState moves the nodes like ChangeNodeUpgradeState, the nodes moving to the same state at once
+// if the wrapped provider is a NodeUpgradeStateBatchProvider
+func (p *customStateProvider) ChangeNodesUpgradeState(ctx context.Context, nodes []*corev1.Node,
+	newNodeState string) error {
+	nodesByState := make(map[string][]*corev1.Node)
+	var states []string
+	for _, node := range nodes {
+		state := p.redirect(p.getState(node), newNodeState)
+		if _, ok := nodesByState[state]; !ok {
+			states = append(states, state)
+		}
+		nodesByState[state] = append(nodesByState[state], node)
+	}
+	batchProvider, isBatchProvider := p.NodeUpgradeStateProvider.(NodeUpgradeStateBatchProvider)
+	for _, state := range states {
+		if isBatchProvider {
+			if err := batchProvider.ChangeNodesUpgradeState(ctx, nodesByState[state], state); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, node := range nodesByState[state] {
+			if err := p.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, state); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unwrapNodeUpgradeStateProvider returns the provider wrapped by a customStateProvider, provider otherwise
+func unwrapNodeUpgradeStateProvider(provider NodeUpgradeStateProvider) NodeUpgradeStateProvider {
+	if wrapper, ok := provider.(*customStateProvider); ok {
+		return wrapper.NodeUpgradeStateProvider
+	}
+	return provider
+}
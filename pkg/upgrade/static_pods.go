@@ -0,0 +1,63 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// isStaticPod returns true if the pod is the mirror pod of a static pod, which is managed by the kubelet
+// and can't be evicted or deleted through the API server
+func isStaticPod(pod corev1.Pod) bool {
+	_, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]
+	return ok
+}
+
+// checkStaticPods reports the static pods of the node selected by the drain spec, which the drain leaves running.
+// An error is returned if the StaticPodPolicy of the drain spec is Fail and the node has such pods.
+func (m *DrainManagerImpl) checkStaticPods(ctx context.Context, client kubernetes.Interface, node *corev1.Node,
+	drainSpec *v1alpha1.DrainSpec) error {
+	pods, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		LabelSelector: drainSpec.PodSelector,
+		FieldSelector: fmt.Sprintf(nodeNameFieldSelectorFmt, node.Name),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods of node %s: %v", node.Name, err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != node.Name || !isStaticPod(*pod) {
+			continue
+		}
+		if drainSpec.StaticPodPolicy == v1alpha1.StaticPodPolicyFail {
+			return fmt.Errorf("static pod %s/%s can't be evicted", pod.Namespace, pod.Name)
+		}
+		m.log.V(consts.LogLevelInfo).Info("Skipping static pod, static pods can't be evicted", "node", node.Name,
+			"pod", pod.Namespace+"/"+pod.Name)
+		logEventf(m.eventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+			"Skipping static pod %s/%s during the drain, static pods can't be evicted", pod.Namespace, pod.Name)
+	}
+	return nil
+}
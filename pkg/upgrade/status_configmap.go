@@ -0,0 +1,217 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// UpgradeStatusConfigMapKey is the key of the status ConfigMap data containing the JSON encoded UpgradeStatus
+const UpgradeStatusConfigMapKey = "status.json"
+
+// UpgradeStatus is the rollup progress of the driver upgrade persisted in the status ConfigMap
+type UpgradeStatus struct {
+	// SessionID identifies the upgrade session, i.e. the rollout from the first node leaving the upgrade-done state
+	// until all the nodes are done again
+	SessionID string `json:"sessionID,omitempty"`
+	// StartTime is the time the upgrade session started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is the time the upgrade session completed, it is not set while the session is in progress
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// LastUpdateTime is the time of the last ApplyState pass
+	LastUpdateTime metav1.Time `json:"lastUpdateTime"`
+	// TotalNodes is the count of nodes managed for driver upgrades
+	TotalNodes int `json:"totalNodes"`
+	// NodesByState is the count of nodes in each upgrade state, the unknown state is reported as "unknown"
+	NodesByState map[string]int `json:"nodesByState"`
+	// FailedNodes are the names of the nodes in the upgrade-failed state
+	FailedNodes []string `json:"failedNodes,omitempty"`
+	// ActiveBlackoutPeriods are the names of the active blackout periods of the upgrade policy
+	ActiveBlackoutPeriods []string `json:"activeBlackoutPeriods,omitempty"`
+	// OverBudget is the count of upgrades in progress beyond maxParallelUpgrades
+	OverBudget int `json:"overBudget,omitempty"`
+	// Paused indicates that the upgrade is paused by the upgrade policy
+	Paused bool `json:"paused,omitempty"`
+	// ClusterUnhealthy describes how the health of the cluster is degraded, while the cluster health gate holds
+	// the node upgrades
+	ClusterUnhealthy string `json:"clusterUnhealthy,omitempty"`
+}
+
+// WithStatusConfigMap provides an option to persist the upgrade progress in the given ConfigMap on every ApplyState
+// pass, for consumers whose custom resources have no status section. The ConfigMap is created if it doesn't exist.
+// The upgrade capacity, see UpgradeCapacity, is published as annotations of the ConfigMap.
+func (m *ClusterUpgradeStateManagerImpl) WithStatusConfigMap(namespace, name string) ClusterUpgradeStateManager {
+	m.statusConfigMap = &types.NamespacedName{Namespace: namespace, Name: name}
+	return m
+}
+
+// nodeStateCounts counts the nodes of a ClusterUpgradeState by upgrade state, see countNodeUpgradeStates
+type nodeStateCounts struct {
+	// total is the count of nodes
+	total int
+	// upgraded is the count of nodes in the upgrade-done state
+	upgraded int
+	// upgradesInProgress is the count of nodes whose upgrade is in progress, i.e. not in an idle state
+	upgradesInProgress int
+	// byState is the count of nodes per upgrade state, the nodes without state are counted as "unknown"
+	byState map[string]int
+	// failedNodes are the names of the nodes in the upgrade-failed state, sorted
+	failedNodes []string
+	// inProgress is true if a node is neither upgrade-done nor without state, i.e. the rollout is not complete
+	inProgress bool
+}
+
+// countNodeUpgradeStates counts the nodes in currentState by their upgrade state in storage, so that
+// the transitions made by the current ApplyState pass are included
+func countNodeUpgradeStates(currentState *ClusterUpgradeState, storage NodeUpgradeStateStorage) nodeStateCounts {
+	counts := nodeStateCounts{byState: make(map[string]int)}
+	for _, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			state := storage.GetState(nodeState.Node)
+			if !slices.Contains(nodeUpgradeIdleStates, state) {
+				counts.upgradesInProgress++
+			}
+			switch state {
+			case UpgradeStateUnknown:
+				state = "unknown"
+			case UpgradeStateDone:
+				counts.upgraded++
+			case UpgradeStateFailed:
+				counts.failedNodes = append(counts.failedNodes, nodeState.Node.Name)
+				counts.inProgress = true
+			default:
+				counts.inProgress = true
+			}
+			counts.byState[state]++
+			counts.total++
+		}
+	}
+	sort.Strings(counts.failedNodes)
+	return counts
+}
+
+// updateUpgradeSession starts a new upgrade session, returning true, if the rollout is in progress and the session
+// given by its start and completion times is completed or not started yet. The session in progress is completed
+// once the rollout is not in progress anymore.
+func updateUpgradeSession(inProgress bool, startTime, completionTime **metav1.Time, now time.Time) bool {
+	switch {
+	case inProgress && (*startTime == nil || *completionTime != nil):
+		start := metav1.NewTime(now)
+		*startTime = &start
+		*completionTime = nil
+		return true
+	case !inProgress && *startTime != nil && *completionTime == nil:
+		completion := metav1.NewTime(now)
+		*completionTime = &completion
+	}
+	return false
+}
+
+// buildUpgradeStatus computes the upgrade status of the nodes in currentState from their upgrade state in storage,
+// so that the transitions made by the current ApplyState pass are included. The session of the previous status
+// is continued while it is in progress, a new session is started when the upgrade of a node starts after
+// a completed one.
+func buildUpgradeStatus(currentState *ClusterUpgradeState, storage NodeUpgradeStateStorage, previous *UpgradeStatus,
+	now time.Time) *UpgradeStatus {
+	counts := countNodeUpgradeStates(currentState, storage)
+	status := &UpgradeStatus{
+		SessionID:      previous.SessionID,
+		StartTime:      previous.StartTime,
+		CompletionTime: previous.CompletionTime,
+		LastUpdateTime: metav1.NewTime(now),
+		TotalNodes:     counts.total,
+		NodesByState:   counts.byState,
+		FailedNodes:    counts.failedNodes,
+	}
+	if updateUpgradeSession(counts.inProgress, &status.StartTime, &status.CompletionTime, now) {
+		status.SessionID = string(uuid.NewUUID())
+	}
+	return status
+}
+
+// updateStatusConfigMap persists the upgrade status of currentState in the status ConfigMap, if enabled
+func (m *ClusterUpgradeStateManagerImpl) updateStatusConfigMap(ctx context.Context,
+	currentState *ClusterUpgradeState) error {
+	if m.statusConfigMap == nil {
+		return nil
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err := m.K8sClient.Get(ctx, *m.statusConfigMap, configMap)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error getting status ConfigMap: %v", err)
+	}
+	exists := err == nil
+
+	previous := &UpgradeStatus{}
+	if data, ok := configMap.Data[UpgradeStatusConfigMapKey]; ok {
+		if err := json.Unmarshal([]byte(data), previous); err != nil {
+			m.Log.V(consts.LogLevelWarning).Info("Ignoring invalid upgrade status in the status ConfigMap",
+				"configMap", m.statusConfigMap.String(), "error", err.Error())
+			previous = &UpgradeStatus{}
+		}
+	}
+	capacity := m.upgradeCapacity.get()
+	status := buildUpgradeStatus(currentState, m.stateStorage, previous, time.Now())
+	status.ActiveBlackoutPeriods = capacity.ActiveBlackoutPeriods
+	status.OverBudget = capacity.OverBudget
+	status.Paused = capacity.Paused
+	status.ClusterUnhealthy = capacity.ClusterUnhealthy
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[UpgradeStatusConfigMapKey] = string(data)
+
+	// The upgrade capacity is published as annotations for external automation
+	if configMap.Annotations == nil {
+		configMap.Annotations = make(map[string]string)
+	}
+	configMap.Annotations[m.keys.GetUpgradeSlotsAvailableAnnotationKey()] = strconv.Itoa(capacity.SlotsAvailable)
+	configMap.Annotations[m.keys.GetUpgradeNextEligibleNodesAnnotationKey()] =
+		strings.Join(capacity.NextEligibleNodes, ",")
+	if !exists {
+		configMap.Namespace = m.statusConfigMap.Namespace
+		configMap.Name = m.statusConfigMap.Name
+		err = m.K8sClient.Create(ctx, configMap)
+	} else {
+		err = m.K8sClient.Update(ctx, configMap)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing status ConfigMap: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+)
+
+// maxConditionNodeNames is the maximum count of node names listed in the message of a condition
+const maxConditionNodeNames = 10
+
+// StatusWriter writes the progress of the driver upgrade to a v1alpha1.DriverUpgradeStatus embedded in the status
+// of the custom resource of the operator
+type StatusWriter struct {
+	k8sClient client.Client
+	// stateStorage is the storage of the node upgrade states, see WithStateStorage
+	stateStorage NodeUpgradeStateStorage
+}
+
+// NewStatusWriter creates a StatusWriter writing the status of the custom resources with the client
+func NewStatusWriter(k8sClient client.Client) *StatusWriter {
+	return &StatusWriter{k8sClient: k8sClient, stateStorage: LabelStateStorage{}}
+}
+
+// WithStateStorage sets the storage the upgrade state of the nodes is read from, LabelStateStorage by default.
+// It must be the storage of the upgrade state manager, see GetNodeUpgradeStateStorage.
+func (w *StatusWriter) WithStateStorage(storage NodeUpgradeStateStorage) *StatusWriter {
+	w.stateStorage = storage
+	return w
+}
+
+// WriteStatus updates status, which must point into the status of obj, from currentState once processed
+// by ApplyState, and writes the status of obj if it changed. A conflict is returned to the caller, which requeues
+// the reconcile as usual.
+func (w *StatusWriter) WriteStatus(ctx context.Context, obj client.Object, status *v1alpha1.DriverUpgradeStatus,
+	currentState *ClusterUpgradeState, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) error {
+	previous := status.DeepCopy()
+	SetDriverUpgradeStatus(status, currentState, w.stateStorage, upgradePolicy, obj.GetGeneration(), time.Now())
+	if equality.Semantic.DeepEqual(previous, status) {
+		return nil
+	}
+	if err := w.k8sClient.Status().Update(ctx, obj); err != nil {
+		return fmt.Errorf("error writing driver upgrade status of %s: %v", client.ObjectKeyFromObject(obj), err)
+	}
+	return nil
+}
+
+// SetDriverUpgradeStatus updates status from the upgrade state of the nodes in currentState, read from storage,
+// so that the transitions made by the last ApplyState pass are included. The conditions are stamped with generation,
+// the observed generation of the custom resource. A new rollout starts when the upgrade of a node starts after
+// all the nodes were done.
+func SetDriverUpgradeStatus(status *v1alpha1.DriverUpgradeStatus, currentState *ClusterUpgradeState,
+	storage NodeUpgradeStateStorage, upgradePolicy *v1alpha1.DriverUpgradePolicySpec, generation int64,
+	now time.Time) {
+	counts := countNodeUpgradeStates(currentState, storage)
+	status.TotalNodes = counts.total
+	status.UpgradedNodes = counts.upgraded
+	status.UpgradesInProgress = counts.upgradesInProgress
+	status.NodesByState = counts.byState
+	status.FailedNodes = len(counts.failedNodes)
+	status.RemainingNodes = counts.total - counts.upgraded
+	updateUpgradeSession(counts.inProgress, &status.StartTime, &status.CompletionTime, now)
+
+	progressing := metav1.Condition{Type: v1alpha1.DriverUpgradeConditionProgressing, Status: metav1.ConditionFalse,
+		Reason: "UpgradeComplete", Message: fmt.Sprintf("%d of %d nodes upgraded", status.UpgradedNodes,
+			status.TotalNodes), ObservedGeneration: generation}
+	if counts.inProgress {
+		progressing.Status = metav1.ConditionTrue
+		progressing.Reason = "UpgradeInProgress"
+	}
+	meta.SetStatusCondition(&status.Conditions, progressing)
+
+	degraded := metav1.Condition{Type: v1alpha1.DriverUpgradeConditionDegraded, Status: metav1.ConditionFalse,
+		Reason: "NoFailedNodes", ObservedGeneration: generation}
+	if len(counts.failedNodes) > 0 {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = "NodesFailed"
+		degraded.Message = fmt.Sprintf("upgrade failed on %d nodes: %s", len(counts.failedNodes),
+			formatNodeNames(counts.failedNodes, maxConditionNodeNames))
+	}
+	meta.SetStatusCondition(&status.Conditions, degraded)
+
+	paused := metav1.Condition{Type: v1alpha1.DriverUpgradeConditionPaused, Status: metav1.ConditionFalse,
+		Reason: "NotPaused", ObservedGeneration: generation}
+	if upgradePolicy != nil && upgradePolicy.Paused {
+		paused.Status = metav1.ConditionTrue
+		paused.Reason = "PausedByPolicy"
+		paused.Message = "the driver upgrade is paused by the upgrade policy"
+	}
+	meta.SetStatusCondition(&status.Conditions, paused)
+}
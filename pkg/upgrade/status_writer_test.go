@@ -0,0 +1,109 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
+)
+
+var _ = Describe("StatusWriter", func() {
+	newClusterState := func(nodeStates map[string][]string) *upgrade.ClusterUpgradeState {
+		clusterState := upgrade.NewClusterUpgradeState()
+		for state, nodeNames := range nodeStates {
+			for _, nodeName := range nodeNames {
+				node := nodeWithUpgradeState(state)
+				node.Name = nodeName
+				clusterState.NodeStates[state] = append(clusterState.NodeStates[state],
+					&upgrade.NodeUpgradeState{Node: node})
+			}
+		}
+		return &clusterState
+	}
+
+	It("should report the progress of the rollout", func() {
+		status := &v1alpha1.DriverUpgradeStatus{}
+		startTime := time.Now().Add(-time.Hour)
+		policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+
+		upgrade.SetDriverUpgradeStatus(status, newClusterState(map[string][]string{
+			upgrade.UpgradeStateDone:            {"node-1"},
+			upgrade.UpgradeStateUnknown:         {"node-2"},
+			upgrade.UpgradeStateUpgradeRequired: {"node-3"},
+			upgrade.UpgradeStateDrainRequired:   {"node-4"},
+			upgrade.UpgradeStateFailed:          {"node-6", "node-5"},
+		}), upgrade.LabelStateStorage{}, policy, 3, startTime)
+		Expect(status.TotalNodes).To(Equal(6))
+		Expect(status.NodesByState).To(Equal(map[string]int{"unknown": 1, upgrade.UpgradeStateDone: 1,
+			upgrade.UpgradeStateUpgradeRequired: 1, upgrade.UpgradeStateDrainRequired: 1, upgrade.UpgradeStateFailed: 2}))
+		Expect(status.UpgradedNodes).To(Equal(1))
+		Expect(status.UpgradesInProgress).To(Equal(1))
+		Expect(status.FailedNodes).To(Equal(2))
+		Expect(status.RemainingNodes).To(Equal(5))
+		Expect(status.StartTime.Time).To(BeTemporally("==", startTime))
+		Expect(status.CompletionTime).To(BeNil())
+		progressing := meta.FindStatusCondition(status.Conditions, v1alpha1.DriverUpgradeConditionProgressing)
+		Expect(progressing.Status).To(Equal(metav1.ConditionTrue))
+		Expect(progressing.ObservedGeneration).To(Equal(int64(3)))
+		Expect(progressing.Message).To(Equal("1 of 6 nodes upgraded"))
+		Expect(meta.IsStatusConditionTrue(status.Conditions, v1alpha1.DriverUpgradeConditionDegraded)).To(BeTrue())
+		Expect(meta.FindStatusCondition(status.Conditions, v1alpha1.DriverUpgradeConditionDegraded).Message).To(
+			Equal("upgrade failed on 2 nodes: node-5, node-6"))
+		Expect(meta.IsStatusConditionFalse(status.Conditions, v1alpha1.DriverUpgradeConditionPaused)).To(BeTrue())
+
+		completionTime := time.Now()
+		policy.Paused = true
+		upgrade.SetDriverUpgradeStatus(status, newClusterState(map[string][]string{
+			upgrade.UpgradeStateDone: {"node-1", "node-2", "node-3", "node-4", "node-5", "node-6"},
+		}), upgrade.LabelStateStorage{}, policy, 4, completionTime)
+		Expect(status.NodesByState).To(Equal(map[string]int{upgrade.UpgradeStateDone: 6}))
+		Expect(status.RemainingNodes).To(Equal(0))
+		Expect(status.StartTime.Time).To(BeTemporally("==", startTime))
+		Expect(status.CompletionTime.Time).To(BeTemporally("==", completionTime))
+		Expect(meta.IsStatusConditionFalse(status.Conditions, v1alpha1.DriverUpgradeConditionProgressing)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(status.Conditions, v1alpha1.DriverUpgradeConditionDegraded)).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(status.Conditions, v1alpha1.DriverUpgradeConditionPaused)).To(BeTrue())
+	})
+
+	It("should only write the status of the custom resource when it changed", func() {
+		ctx := context.TODO()
+		// the object doesn't exist, so that every write fails
+		obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("cr-%s", randSeq(5)),
+			Namespace: "default"}}
+		clusterState := newClusterState(map[string][]string{upgrade.UpgradeStateDone: {"node-1"}})
+		policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+		status := &v1alpha1.DriverUpgradeStatus{}
+		upgrade.SetDriverUpgradeStatus(status, clusterState, upgrade.LabelStateStorage{}, policy, 0, time.Now())
+
+		writer := upgrade.NewStatusWriter(k8sClient)
+		Expect(writer.WriteStatus(ctx, obj, status, clusterState, policy)).To(Succeed())
+
+		clusterState = newClusterState(map[string][]string{upgrade.UpgradeStateCordonRequired: {"node-1"}})
+		Expect(writer.WriteStatus(ctx, obj, status, clusterState, policy)).To(
+			MatchError(ContainSubstring("error writing driver upgrade status")))
+	})
+})
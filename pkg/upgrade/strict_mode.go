@@ -0,0 +1,163 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// NodePostConditionDiff is the difference between a node as left by an upgrade phase and the node read back
+// from the API server
+type NodePostConditionDiff struct {
+	// NodeName is the name of the node
+	NodeName string
+	// State is the expected (Before) and the actual (After) upgrade state of the node, nil if they match
+	State *NodeMetadataChange
+	// Annotations are the annotations owned by the upgrade library whose actual value (After) differs
+	// from the expected one (Before), sorted by key
+	Annotations []NodeMetadataChange
+	// Unschedulable is the expected cordon state of the node, nil if the actual one matches
+	Unschedulable *bool
+}
+
+// String returns the differences of the node in the `key: "expected" -> "actual"` form
+func (d NodePostConditionDiff) String() string {
+	diffs := make([]string, 0, len(d.Annotations)+2)
+	if d.State != nil {
+		diffs = append(diffs, d.State.String())
+	}
+	for _, change := range d.Annotations {
+		diffs = append(diffs, change.String())
+	}
+	if d.Unschedulable != nil {
+		diffs = append(diffs, fmt.Sprintf("unschedulable: %t -> %t", *d.Unschedulable, !*d.Unschedulable))
+	}
+	return fmt.Sprintf("node %s: %s", d.NodeName, strings.Join(diffs, ", "))
+}
+
+// PostConditionError is returned by ApplyState in strict mode when nodes read back from the API server after
+// a phase don't match the state the phase left them in, see WithStrictMode
+type PostConditionError struct {
+	// Phase is the upgrade state processed by the phase
+	Phase string
+	// Nodes are the differences of the nodes which don't match, sorted by node name
+	Nodes []NodePostConditionDiff
+}
+
+// Error implements error
+func (e *PostConditionError) Error() string {
+	nodes := make([]string, 0, len(e.Nodes))
+	for _, diff := range e.Nodes {
+		nodes = append(nodes, diff.String())
+	}
+	return fmt.Sprintf("post-conditions of the %s phase not met (expected -> actual): %s",
+		e.Phase, strings.Join(nodes, "; "))
+}
+
+// asyncPhases are the phases handing the nodes over to workers which change the upgrade state of the nodes
+// in the background
+var asyncPhases = []string{
+	UpgradeStateWaitForJobsRequired,
+	UpgradeStatePodDeletionRequired,
+	UpgradeStateDrainRequired,
+}
+
+// WithStrictMode provides an option to verify the post-conditions of every phase: once the nodes of the phase
+// are processed, they are read back from the API server, bypassing the cache, and their upgrade state,
+// the annotations owned by the upgrade library and their cordon state are compared with the state the phase
+// left them in. A difference, e.g. a write mutated by a webhook or a stale cache, fails ApplyState with
+// a PostConditionError. It costs a request per node and phase, and is meant for tests and troubleshooting.
+func (m *ClusterUpgradeStateManagerImpl) WithStrictMode(enabled bool) ClusterUpgradeStateManager {
+	m.strictMode = enabled
+	return m
+}
+
+// withPostConditionCheck wraps process of the phase upgrade state of currentState, so that the nodes of the phase
+// are verified once they are processed
+func (m *ClusterUpgradeStateManagerImpl) withPostConditionCheck(ctx context.Context,
+	currentState *ClusterUpgradeState, phase string, process func() error) func() error {
+	return func() error {
+		if err := process(); err != nil {
+			return err
+		}
+		return m.checkPostConditions(ctx, phase, currentState.NodeStates[phase])
+	}
+}
+
+// checkPostConditions reads back the nodes of the phase from the API server and returns a PostConditionError
+// if they differ from the nodes known to the manager. The deleted nodes are ignored, as are the nodes
+// of asyncPhases whose upgrade state was changed by a worker meanwhile.
+func (m *ClusterUpgradeStateManagerImpl) checkPostConditions(ctx context.Context, phase string,
+	nodeStates []*NodeUpgradeState) error {
+	var diffs []NodePostConditionDiff
+	for _, nodeState := range nodeStates {
+		expected := nodeState.Node
+		actual, err := m.K8sInterface.CoreV1().Nodes().Get(ctx, expected.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to verify the post-conditions of node %s: %v", expected.Name, err)
+		}
+		diff := NodePostConditionDiff{NodeName: expected.Name}
+		expectedState, actualState := m.getNodeUpgradeState(expected), m.getNodeUpgradeState(actual)
+		if expectedState != actualState {
+			if slices.Contains(asyncPhases, phase) {
+				m.Log.V(consts.LogLevelDebug).Info("Node upgrade state changed in the background, not verified",
+					"node", expected.Name, "phase", phase, "state", actualState)
+				continue
+			}
+			diff.State = &NodeMetadataChange{Key: "state", Before: &expectedState, After: &actualState}
+		}
+		expectedAnnotations, actualAnnotations := make(map[string]string), make(map[string]string)
+		for _, key := range m.keys.getLibraryOwnedAnnotationKeys() {
+			if key == m.keys.GetUpgradeDrainLeaseAnnotationKey() {
+				// the drain lease is renewed in the background
+				continue
+			}
+			if value, ok := expected.Annotations[key]; ok {
+				expectedAnnotations[key] = value
+			}
+			if value, ok := actual.Annotations[key]; ok {
+				actualAnnotations[key] = value
+			}
+		}
+		diff.Annotations = diffNodeMetadata(expectedAnnotations, actualAnnotations)
+		if expected.Spec.Unschedulable != actual.Spec.Unschedulable {
+			unschedulable := expected.Spec.Unschedulable
+			diff.Unschedulable = &unschedulable
+		}
+		if diff.State != nil || len(diff.Annotations) > 0 || diff.Unschedulable != nil {
+			diffs = append(diffs, diff)
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	slices.SortFunc(diffs, func(a, b NodePostConditionDiff) int { return strings.Compare(a.NodeName, b.NodeName) })
+	err := &PostConditionError{Phase: phase, Nodes: diffs}
+	m.Log.V(consts.LogLevelError).Error(err, "Node post-conditions not met", "phase", phase)
+	return err
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+const (
+	// defaultStuckFinalizerTimeoutSeconds is the default time a pod can stay terminating past its grace period
+	// before it is considered stuck
+	defaultStuckFinalizerTimeoutSeconds = 300
+	// maxStuckFinalizerCheckInterval is the maximum interval between the checks for stuck pods on a drained node
+	maxStuckFinalizerCheckInterval = 10 * time.Second
+)
+
+// getStuckFinalizerSpec returns the stuck finalizer handling of the drain spec, or the default one if not set
+func getStuckFinalizerSpec(drainSpec *v1alpha1.DrainSpec) *v1alpha1.StuckFinalizerSpec {
+	if drainSpec.StuckFinalizers != nil {
+		return drainSpec.StuckFinalizers
+	}
+	return &v1alpha1.StuckFinalizerSpec{
+		Action:        v1alpha1.StuckFinalizerActionWait,
+		TimeoutSecond: defaultStuckFinalizerTimeoutSeconds,
+	}
+}
+
+// stuckFinalizerWatcher detects the pods of a drained node which are stuck terminating because of finalizers
+// and handles them according to the StuckFinalizerSpec
+type stuckFinalizerWatcher struct {
+	manager *DrainManagerImpl
+	client  kubernetes.Interface
+	node    *corev1.Node
+	spec    *v1alpha1.StuckFinalizerSpec
+	// failDrain aborts the drain of the node
+	failDrain func()
+	// reported are the UIDs of the stuck pods already reported
+	reported sets.Set[types.UID]
+}
+
+// watchStuckFinalizers checks the pods of the node periodically until ctx is done
+func (m *DrainManagerImpl) watchStuckFinalizers(ctx context.Context, client kubernetes.Interface, node *corev1.Node,
+	spec *v1alpha1.StuckFinalizerSpec, failDrain func()) {
+	watcher := &stuckFinalizerWatcher{
+		manager:   m,
+		client:    client,
+		node:      node,
+		spec:      spec,
+		failDrain: failDrain,
+		reported:  sets.New[types.UID](),
+	}
+	interval := min(max(time.Duration(spec.TimeoutSecond)*time.Second, time.Second), maxStuckFinalizerCheckInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if watcher.check(ctx) {
+				return
+			}
+		}
+	}
+}
+
+// check handles the stuck pods of the node and returns true if the drain was failed
+func (w *stuckFinalizerWatcher) check(ctx context.Context) bool {
+	log := w.manager.log
+	pods, err := w.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf(nodeNameFieldSelectorFmt, w.node.Name),
+	})
+	if err != nil {
+		log.V(consts.LogLevelWarning).Info("Failed to list pods to check for stuck finalizers",
+			"node", w.node.Name, "error", err.Error())
+		return false
+	}
+	now := time.Now()
+	timeout := time.Duration(w.spec.TimeoutSecond) * time.Second
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != w.node.Name || pod.DeletionTimestamp == nil || len(pod.Finalizers) == 0 ||
+			now.Before(pod.DeletionTimestamp.Add(timeout)) {
+			continue
+		}
+		if w.spec.Action == v1alpha1.StuckFinalizerActionRemoveFinalizers && w.removeAllowedFinalizers(ctx, pod) {
+			continue
+		}
+		if !w.reported.Has(pod.UID) {
+			w.reported.Insert(pod.UID)
+			log.V(consts.LogLevelWarning).Info("Pod is stuck terminating because of finalizers", "node", w.node.Name,
+				"pod", pod.Namespace+"/"+pod.Name, "finalizers", pod.Finalizers)
+			logEventf(w.manager.eventRecorder, w.node, corev1.EventTypeWarning, GetEventReason(),
+				"Pod %s/%s is stuck terminating because of finalizers %v", pod.Namespace, pod.Name, pod.Finalizers)
+		}
+		if w.spec.Action == v1alpha1.StuckFinalizerActionFail {
+			log.V(consts.LogLevelInfo).Info("Failing the drain because of a stuck pod", "node", w.node.Name,
+				"pod", pod.Namespace+"/"+pod.Name)
+			w.failDrain()
+			return true
+		}
+	}
+	return false
+}
+
+// removeAllowedFinalizers removes the allowed finalizers from the pod and returns true if no finalizer is left
+func (w *stuckFinalizerWatcher) removeAllowedFinalizers(ctx context.Context, pod *corev1.Pod) bool {
+	allowed := sets.New(w.spec.AllowedFinalizers...)
+	remaining := make([]string, 0, len(pod.Finalizers))
+	for _, finalizer := range pod.Finalizers {
+		if !allowed.Has(finalizer) {
+			remaining = append(remaining, finalizer)
+		}
+	}
+	if len(remaining) == len(pod.Finalizers) {
+		return false
+	}
+	// the resource version guards against removing finalizers changed since the pod was listed
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": pod.ResourceVersion,
+			"finalizers":      remaining,
+		},
+	})
+	if err == nil {
+		_, err = w.client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch,
+			metav1.PatchOptions{})
+	}
+	if err != nil {
+		w.manager.log.V(consts.LogLevelWarning).Info("Failed to remove finalizers from stuck pod",
+			"node", w.node.Name, "pod", pod.Namespace+"/"+pod.Name, "error", err.Error())
+		return false
+	}
+	w.manager.log.V(consts.LogLevelInfo).Info("Removed finalizers from stuck pod", "node", w.node.Name,
+		"pod", pod.Namespace+"/"+pod.Name, "remaining", remaining)
+	logEventf(w.manager.eventRecorder, w.node, corev1.EventTypeWarning, GetEventReason(),
+		"Removed allowed finalizers from pod %s/%s stuck terminating", pod.Namespace, pod.Name)
+	return len(remaining) == 0
+}
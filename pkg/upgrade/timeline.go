@@ -0,0 +1,213 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"sync"
+	"time"
+)
+
+// maxNodeUpgradeTimelineEntries is the maximum count of entries kept in a node upgrade timeline,
+// older entries are dropped first
+const maxNodeUpgradeTimelineEntries = 100
+
+// NodeUpgradeTimelineEntryType is the type of NodeUpgradeTimelineEntry
+type NodeUpgradeTimelineEntryType string
+
+const (
+	// NodeUpgradeTimelineEntryTransition is recorded when the node moves to another upgrade state
+	NodeUpgradeTimelineEntryTransition NodeUpgradeTimelineEntryType = "Transition"
+	// NodeUpgradeTimelineEntryRetry is recorded when the node moves out of the upgrade-failed state
+	NodeUpgradeTimelineEntryRetry NodeUpgradeTimelineEntryType = "Retry"
+	// NodeUpgradeTimelineEntryError is recorded when the node moves to the upgrade-failed state
+	NodeUpgradeTimelineEntryError NodeUpgradeTimelineEntryType = "Error"
+)
+
+// NodeUpgradeTimelineEntry is a single event in the upgrade timeline of a node
+type NodeUpgradeTimelineEntry struct {
+	// Time is the time the state was written by the provider, or observed by the upgrade state manager if it
+	// was written by another writer
+	Time time.Time
+	// Type is the type of the event
+	Type NodeUpgradeTimelineEntryType
+	// FromState is the upgrade state of the node before the event
+	FromState string
+	// ToState is the upgrade state of the node after the event
+	ToState string
+	// Reason is the upgrade state reason of the node in the new state, if any
+	Reason string
+}
+
+// NodeUpgradeTimeline aggregates the upgrade history of a single node
+type NodeUpgradeTimeline struct {
+	// NodeName is the name of the node
+	NodeName string
+	// CurrentState is the last observed upgrade state of the node
+	CurrentState string
+	// Retries is the count of times the node moved out of the upgrade-failed state
+	Retries int
+	// Errors is the count of times the node moved to the upgrade-failed state
+	Errors int
+	// LastError is the upgrade state reason of the node in its last upgrade-failed state, e.g. DrainFailed,
+	// empty if the node never failed or failed without a reason
+	LastError string
+	// Entries are the recorded events, oldest first
+	Entries []NodeUpgradeTimelineEntry
+}
+
+// nodeUpgradeTimelineStore keeps the upgrade timelines of the nodes observed by the upgrade state manager
+type nodeUpgradeTimelineStore struct {
+	mutex     sync.Mutex
+	timelines map[string]*NodeUpgradeTimeline
+}
+
+// newNodeUpgradeTimelineStore creates an empty nodeUpgradeTimelineStore
+func newNodeUpgradeTimelineStore() *nodeUpgradeTimelineStore {
+	return &nodeUpgradeTimelineStore{timelines: make(map[string]*NodeUpgradeTimeline)}
+}
+
+// recordTransition adds the state change of the node written by the NodeUpgradeStateProvider to its timeline
+func (s *nodeUpgradeTimelineStore) recordTransition(nodeName, fromState, toState string, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	timeline, ok := s.timelines[nodeName]
+	if !ok {
+		timeline = &NodeUpgradeTimeline{NodeName: nodeName, CurrentState: fromState}
+		s.timelines[nodeName] = timeline
+	} else if timeline.CurrentState == toState {
+		return
+	}
+	timeline.addEntry(fromState, toState, "", now)
+}
+
+// observe records the upgrade state of the node seen by BuildState. An entry is added if the state has changed
+// since the previous record, e.g. if the state was written by another writer than the provider, otherwise
+// the reason is added to the last entry, as it is set after the state change.
+func (s *nodeUpgradeTimelineStore) observe(nodeName, state, reason string, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	timeline, ok := s.timelines[nodeName]
+	if !ok {
+		timeline = &NodeUpgradeTimeline{NodeName: nodeName}
+		s.timelines[nodeName] = timeline
+	} else if timeline.CurrentState == state {
+		last := len(timeline.Entries) - 1
+		if reason != "" && last >= 0 && timeline.Entries[last].ToState == state && timeline.Entries[last].Reason == "" {
+			timeline.Entries[last].Reason = reason
+			if state == UpgradeStateFailed {
+				timeline.LastError = reason
+			}
+		}
+		return
+	}
+	timeline.addEntry(timeline.CurrentState, state, reason, now)
+}
+
+// addEntry adds the state change of the node to the timeline
+func (t *NodeUpgradeTimeline) addEntry(fromState, toState, reason string, now time.Time) {
+	entry := NodeUpgradeTimelineEntry{
+		Time:      now,
+		Type:      NodeUpgradeTimelineEntryTransition,
+		FromState: fromState,
+		ToState:   toState,
+		Reason:    reason,
+	}
+	switch {
+	case toState == UpgradeStateFailed:
+		entry.Type = NodeUpgradeTimelineEntryError
+		t.Errors++
+		t.LastError = reason
+	case fromState == UpgradeStateFailed:
+		entry.Type = NodeUpgradeTimelineEntryRetry
+		t.Retries++
+	}
+	t.CurrentState = toState
+	t.Entries = append(t.Entries, entry)
+	if len(t.Entries) > maxNodeUpgradeTimelineEntries {
+		t.Entries = t.Entries[len(t.Entries)-maxNodeUpgradeTimelineEntries:]
+	}
+}
+
+// prune drops the timelines of the nodes which are not in the given set
+func (s *nodeUpgradeTimelineStore) prune(nodeNames map[string]bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for nodeName := range s.timelines {
+		if !nodeNames[nodeName] {
+			delete(s.timelines, nodeName)
+		}
+	}
+}
+
+// get returns a copy of the timeline of the node, or nil if the node was never observed
+func (s *nodeUpgradeTimelineStore) get(nodeName string) *NodeUpgradeTimeline {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	timeline, ok := s.timelines[nodeName]
+	if !ok {
+		return nil
+	}
+	timelineCopy := *timeline
+	timelineCopy.Entries = append([]NodeUpgradeTimelineEntry(nil), timeline.Entries...)
+	return &timelineCopy
+}
+
+// recordNodeUpgradeTimelines adds the upgrade states and reasons of the nodes in the given state to their
+// timelines and drops the timelines of the nodes which are not in the state anymore
+func (m *ClusterUpgradeStateManagerImpl) recordNodeUpgradeTimelines(currentState *ClusterUpgradeState) {
+	if m.timelines == nil {
+		return
+	}
+	now := time.Now()
+	nodeNames := make(map[string]bool)
+	for _, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			node := nodeState.Node
+			nodeNames[node.Name] = true
+			m.timelines.observe(node.Name, m.getNodeUpgradeState(node), m.keys.GetNodeUpgradeStateReason(node), now)
+		}
+	}
+	m.timelines.prune(nodeNames)
+}
+
+// setProviderTimelines sets the timeline store the provider records the state changes it writes in,
+// if the provider is implemented by this package
+func setProviderTimelines(provider NodeUpgradeStateProvider, timelines *nodeUpgradeTimelineStore) {
+	switch p := unwrapNodeUpgradeStateProvider(provider).(type) {
+	case *NodeUpgradeStateProviderImpl:
+		p.timelines = timelines
+	case *CachedNodeUpgradeStateProvider:
+		p.timelines = timelines
+	}
+}
+
+// GetNodeUpgradeTimeline returns the upgrade timeline of the node: the upgrade state transitions, retries
+// and errors written or observed by the manager since it was started. Returns nil if the node was never observed
+// or isn't in the state built by the last BuildState anymore.
+func (m *ClusterUpgradeStateManagerImpl) GetNodeUpgradeTimeline(nodeName string) *NodeUpgradeTimeline {
+	if m.timelines == nil {
+		return nil
+	}
+	return m.timelines.get(nodeName)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade_test
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
+)
+
+var _ = Describe("NodeUpgradeTimeline", func() {
+	var ctx context.Context
+	var id string
+	var stateManager *upgrade.ClusterUpgradeStateManagerImpl
+
+	BeforeEach(func() {
+		ctx = context.TODO()
+		id = randSeq(5)
+		stateManager, _ = upgrade.NewClusterUpgradeStateManagerWithClients(log, k8sClient, k8sInterface,
+			eventRecorder).(*upgrade.ClusterUpgradeStateManagerImpl)
+	})
+
+	It("should return nil for a node which was never observed", func() {
+		Expect(stateManager.GetNodeUpgradeTimeline(fmt.Sprintf("node-%s", id))).To(BeNil())
+	})
+
+	It("should record state transitions, errors and retries of a node", func() {
+		namespace := createNamespace(fmt.Sprintf("namespace-%s", id))
+		node := NewNode(fmt.Sprintf("node-%s", id)).
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			Create()
+		provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+
+		for _, state := range []string{upgrade.UpgradeStateFailed, upgrade.UpgradeStateUpgradeRequired} {
+			_, err := stateManager.BuildState(ctx, namespace.Name, map[string]string{"foo": "bar"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(provider.ChangeNodeUpgradeState(ctx, node, state)).To(Succeed())
+		}
+		// repeated observation of the same state is not recorded
+		for i := 0; i < 2; i++ {
+			_, err := stateManager.BuildState(ctx, namespace.Name, map[string]string{"foo": "bar"})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		timeline := stateManager.GetNodeUpgradeTimeline(node.Name)
+		Expect(timeline).NotTo(BeNil())
+		Expect(timeline.NodeName).To(Equal(node.Name))
+		Expect(timeline.CurrentState).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+		Expect(timeline.Errors).To(Equal(1))
+		Expect(timeline.Retries).To(Equal(1))
+		Expect(timeline.Entries).To(HaveLen(3))
+		Expect(timeline.Entries[0].Type).To(Equal(upgrade.NodeUpgradeTimelineEntryTransition))
+		Expect(timeline.Entries[0].ToState).To(Equal(upgrade.UpgradeStateDrainRequired))
+		Expect(timeline.Entries[1].Type).To(Equal(upgrade.NodeUpgradeTimelineEntryError))
+		Expect(timeline.Entries[1].FromState).To(Equal(upgrade.UpgradeStateDrainRequired))
+		Expect(timeline.Entries[2].Type).To(Equal(upgrade.NodeUpgradeTimelineEntryRetry))
+		Expect(timeline.Entries[2].ToState).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+	})
+
+	It("should record every state written by the provider of the manager within a pass", func() {
+		namespace := createNamespace(fmt.Sprintf("namespace-%s", id))
+		node := NewNode(fmt.Sprintf("node-%s", id)).
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			Create()
+		_, err := stateManager.BuildState(ctx, namespace.Name, map[string]string{"foo": "bar"})
+		Expect(err).NotTo(HaveOccurred())
+
+		provider := stateManager.NodeUpgradeStateProvider
+		Expect(provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateFailed)).To(Succeed())
+		Expect(provider.ChangeNodeUpgradeAnnotation(ctx, node, upgrade.GetUpgradeStateReasonAnnotationKey(),
+			upgrade.UpgradeStateReasonDrainBlockedByPDB)).To(Succeed())
+		_, err = stateManager.BuildState(ctx, namespace.Name, map[string]string{"foo": "bar"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateUpgradeRequired)).To(Succeed())
+		Expect(provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateCordonRequired)).To(Succeed())
+
+		timeline := stateManager.GetNodeUpgradeTimeline(node.Name)
+		Expect(timeline).NotTo(BeNil())
+		Expect(timeline.CurrentState).To(Equal(upgrade.UpgradeStateCordonRequired))
+		Expect(timeline.Errors).To(Equal(1))
+		Expect(timeline.LastError).To(Equal(upgrade.UpgradeStateReasonDrainBlockedByPDB))
+		Expect(timeline.Retries).To(Equal(1))
+		Expect(timeline.Entries).To(HaveLen(4))
+		Expect(timeline.Entries[1].Type).To(Equal(upgrade.NodeUpgradeTimelineEntryError))
+		Expect(timeline.Entries[1].Reason).To(Equal(upgrade.UpgradeStateReasonDrainBlockedByPDB))
+		Expect(timeline.Entries[2].Type).To(Equal(upgrade.NodeUpgradeTimelineEntryRetry))
+		Expect(timeline.Entries[3].FromState).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+		Expect(timeline.Entries[3].ToState).To(Equal(upgrade.UpgradeStateCordonRequired))
+	})
+
+	It("should drop the timeline of a node which is not in the state anymore", func() {
+		namespace := createNamespace(fmt.Sprintf("namespace-%s", id))
+		node := NewNode(fmt.Sprintf("node-%s", id)).
+			WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+			Create()
+		_, err := stateManager.BuildState(ctx, namespace.Name, map[string]string{"foo": "bar"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stateManager.GetNodeUpgradeTimeline(node.Name)).NotTo(BeNil())
+
+		deleteObj(node)
+		_, err = stateManager.BuildState(ctx, namespace.Name, map[string]string{"foo": "bar"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stateManager.GetNodeUpgradeTimeline(node.Name)).To(BeNil())
+	})
+})
@@ -0,0 +1,198 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// UncordonCheck checks a node in the uncordon-required state before it is uncordoned, e.g. with an HTTP request
+// against the driver on the node. It returns nil if the node can be uncordoned and an error describing
+// the failure otherwise.
+type UncordonCheck func(ctx context.Context, nodeState *NodeUpgradeState) error
+
+// uncordonGate is the configuration of the checks run before the nodes are uncordoned
+type uncordonGate struct {
+	// checks are the checks which must pass, in order
+	checks []UncordonCheck
+	// timeout is the time the checks may fail before the node is moved to the upgrade-failed state,
+	// zero means infinite
+	timeout time.Duration
+}
+
+// WithUncordonGate provides an option to check the nodes in the uncordon-required state before they are
+// uncordoned: the node must be Ready, its driver pods in sync and ready, and the given checks must pass,
+// in order. The node stays cordoned while a check fails, and is moved to the upgrade-failed state once
+// the checks failed for longer than timeout. Zero timeout means infinite.
+func (m *ClusterUpgradeStateManagerImpl) WithUncordonGate(timeout time.Duration,
+	checks ...UncordonCheck) ClusterUpgradeStateManager {
+	if timeout < 0 {
+		m.Log.V(consts.LogLevelWarning).Info("Ignoring negative uncordon gate timeout", "timeout", timeout)
+		timeout = 0
+	}
+	m.uncordonGate = &uncordonGate{
+		checks:  append([]UncordonCheck{nodeReadyUncordonCheck, m.driverPodsReadyUncordonCheck}, checks...),
+		timeout: timeout,
+	}
+	return m
+}
+
+// nodeReadyUncordonCheck passes if the Ready condition of the node is True
+func nodeReadyUncordonCheck(_ context.Context, nodeState *NodeUpgradeState) error {
+	if !isNodeReady(nodeState.Node) {
+		return fmt.Errorf("node is not Ready")
+	}
+	return nil
+}
+
+// driverPodsReadyUncordonCheck passes if the driver pods of the node are in sync with their DaemonSet and ready
+func (m *ClusterUpgradeStateManagerImpl) driverPodsReadyUncordonCheck(ctx context.Context,
+	nodeState *NodeUpgradeState) error {
+	if nodeState.DriverPod == nil {
+		return fmt.Errorf("node has no driver pod")
+	}
+	ready, err := m.isDriverPodInSync(ctx, nodeState)
+	if err != nil {
+		return fmt.Errorf("failed to check the driver pods: %v", err)
+	}
+	if !ready {
+		return fmt.Errorf("driver pods are not up to date and ready")
+	}
+	return nil
+}
+
+// NewHTTPUncordonCheck returns an UncordonCheck passing when a GET request to the URL returned by url for the node,
+// e.g. the health endpoint of the driver on the node, succeeds with a 2xx status. http.DefaultClient is used
+// if client is nil.
+func NewHTTPUncordonCheck(client *http.Client, url func(nodeState *NodeUpgradeState) string) UncordonCheck {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context, nodeState *NodeUpgradeState) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url(nodeState), http.NoBody)
+		if err != nil {
+			return fmt.Errorf("invalid uncordon check request: %v", err)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return fmt.Errorf("uncordon check request failed: %v", err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+			return fmt.Errorf("uncordon check request to %s returned %s", request.URL, response.Status)
+		}
+		return nil
+	}
+}
+
+// NewValidationUncordonCheck returns an UncordonCheck passing when the validation manager validated the node,
+// e.g. a ValidationManagerImpl running a validation pod from a template. The timeout of the validation manager
+// applies as well.
+func NewValidationUncordonCheck(validationManager ValidationManager) UncordonCheck {
+	return func(ctx context.Context, nodeState *NodeUpgradeState) error {
+		done, err := validationManager.Validate(ctx, nodeState.Node)
+		if err != nil {
+			return fmt.Errorf("validation failed: %v", err)
+		}
+		if !done {
+			return fmt.Errorf("validation is not complete")
+		}
+		return nil
+	}
+}
+
+// isUncordonAllowed runs the checks of the uncordon gate for a node in the uncordon-required state and returns
+// true if they pass. The time the checks started failing is tracked with an annotation, the node is moved to
+// the upgrade-failed state once the timeout of the gate is exceeded.
+func (m *ClusterUpgradeStateManagerImpl) isUncordonAllowed(ctx context.Context,
+	nodeState *NodeUpgradeState) (bool, error) {
+	if m.uncordonGate == nil {
+		return true, nil
+	}
+	node := nodeState.Node
+	annotationKey := m.keys.GetUncordonGateStartTimeAnnotationKey()
+	var checkErr error
+	for _, check := range m.uncordonGate.checks {
+		if checkErr = check(ctx, nodeState); checkErr != nil {
+			break
+		}
+	}
+	if checkErr == nil {
+		if _, present := node.Annotations[annotationKey]; !present {
+			return true, nil
+		}
+		return true, m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, nullString)
+	}
+
+	m.Log.V(consts.LogLevelInfo).Info("Uncordon check failed, node stays cordoned", "node", node.Name,
+		"error", checkErr.Error())
+	err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonUncordonCheckFailed)
+	if err != nil {
+		return false, err
+	}
+	currentTime := time.Now().Unix()
+	if _, present := node.Annotations[annotationKey]; !present {
+		logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+			"Uncordon check failed, the node stays cordoned: %v", checkErr)
+		// add the annotation to track start time
+		err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey,
+			strconv.FormatInt(currentTime, 10))
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to add annotation to track uncordon checks",
+				"node", node.Name, "annotation", annotationKey)
+		}
+		return false, err
+	}
+	if m.uncordonGate.timeout == 0 {
+		return false, nil
+	}
+	startTime, err := strconv.ParseInt(node.Annotations[annotationKey], 10, 64)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to convert start time to track uncordon checks",
+			"node", node.Name)
+		return false, err
+	}
+	if currentTime <= startTime+int64(m.uncordonGate.timeout.Seconds()) {
+		return false, nil
+	}
+
+	// timeout exceeded, mark node in failed state
+	m.Log.V(consts.LogLevelInfo).Info("Timeout exceeded waiting for the uncordon checks", "node", node.Name,
+		"timeout", m.uncordonGate.timeout)
+	logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+		"Uncordon checks did not pass within %s, moving the node to %s state: %v", m.uncordonGate.timeout,
+		UpgradeStateFailed, checkErr)
+	err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "node", node.Name, "state", UpgradeStateFailed)
+		return false, err
+	}
+	err = m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node, UpgradeStateReasonUncordonCheckFailed)
+	if err != nil {
+		return false, err
+	}
+	return false, m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, nullString)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// maxNextEligibleNodes is the maximum count of nodes listed in UpgradeCapacity.NextEligibleNodes
+const maxNextEligibleNodes = 20
+
+// UpgradeCapacity describes the node upgrades which can be started by the next ApplyState pass, so that external
+// automation, e.g. batch schedulers placing long jobs, can avoid the nodes which are about to be upgraded
+type UpgradeCapacity struct {
+	// SlotsAvailable is the count of node upgrades which can be started without exceeding the upgrade limits
+	SlotsAvailable int
+	// NextEligibleNodes are the names of the nodes waiting for upgrade, in the order they are upgraded.
+	// At most 20 nodes are listed.
+	NextEligibleNodes []string
+	// ActiveBlackoutPeriods are the names of the blackout periods of the upgrade policy which are active,
+	// no node upgrade can be started until they are over
+	ActiveBlackoutPeriods []string
+	// OverBudget is the count of upgrades in progress beyond maxParallelUpgrades, e.g. after the limit was lowered.
+	// No node upgrade can be started until it is back to zero.
+	OverBudget int
+	// Paused indicates that the upgrade is paused by the upgrade policy, no node upgrade can be started
+	Paused bool
+	// ClusterUnhealthy describes how the health of the cluster is degraded according to the cluster health gate,
+	// no node upgrade can be started until it is healthy again. It is empty if the cluster is healthy.
+	ClusterUnhealthy string
+}
+
+// upgradeCapacityStore keeps the upgrade capacity computed by the last ApplyState pass, by node pool
+type upgradeCapacityStore struct {
+	mutex      sync.Mutex
+	capacities map[string]UpgradeCapacity
+}
+
+// set stores the capacity of the node pool, the capacities of all the other pools are removed if reset is set
+func (s *upgradeCapacityStore) set(pool string, capacity UpgradeCapacity, reset bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if reset || s.capacities == nil {
+		s.capacities = make(map[string]UpgradeCapacity)
+	}
+	s.capacities[pool] = capacity
+}
+
+// reset removes the capacities of all the node pools
+func (s *upgradeCapacityStore) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.capacities = nil
+}
+
+// get returns the capacity of all the node pools
+func (s *upgradeCapacityStore) get() UpgradeCapacity {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	pools := make([]string, 0, len(s.capacities))
+	for pool := range s.capacities {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+	capacity := UpgradeCapacity{}
+	for _, pool := range pools {
+		capacity.SlotsAvailable += s.capacities[pool].SlotsAvailable
+		capacity.OverBudget += s.capacities[pool].OverBudget
+		capacity.Paused = capacity.Paused || s.capacities[pool].Paused
+		if capacity.ClusterUnhealthy == "" {
+			capacity.ClusterUnhealthy = s.capacities[pool].ClusterUnhealthy
+		}
+		capacity.NextEligibleNodes = append(capacity.NextEligibleNodes, s.capacities[pool].NextEligibleNodes...)
+		for _, name := range s.capacities[pool].ActiveBlackoutPeriods {
+			if !slices.Contains(capacity.ActiveBlackoutPeriods, name) {
+				capacity.ActiveBlackoutPeriods = append(capacity.ActiveBlackoutPeriods, name)
+			}
+		}
+	}
+	if len(capacity.NextEligibleNodes) > maxNextEligibleNodes {
+		capacity.NextEligibleNodes = capacity.NextEligibleNodes[:maxNextEligibleNodes]
+	}
+	return capacity
+}
+
+// computeUpgradeCapacity returns the upgrade capacity of the nodes in currentState after an ApplyState pass.
+// The nodes are grouped by their upgrade state labels, so that the transitions made by the pass are included.
+func (m *ClusterUpgradeStateManagerImpl) computeUpgradeCapacity(ctx context.Context,
+	currentState *ClusterUpgradeState, maxParallelUpgrades, maxUnavailable int) UpgradeCapacity {
+	labeledState := NewClusterUpgradeState()
+	for _, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			state := m.getNodeUpgradeState(nodeState.Node)
+			labeledState.NodeStates[state] = append(labeledState.NodeStates[state], nodeState)
+		}
+	}
+
+	slots := m.getUpgradeSlots(ctx, &labeledState, maxParallelUpgrades, maxUnavailable)
+	capacity := UpgradeCapacity{
+		SlotsAvailable: slots.Upgrades,
+		OverBudget:     slots.OverBudget,
+	}
+	// nodes which were upgrade-required already are listed first, in the order ApplyState processes them
+	for _, state := range []string{UpgradeStateUpgradeRequired, UpgradeStateDone, UpgradeStateUnknown} {
+		for _, nodeState := range currentState.NodeStates[state] {
+			if len(capacity.NextEligibleNodes) == maxNextEligibleNodes {
+				return capacity
+			}
+			node := nodeState.Node
+			if m.getNodeUpgradeState(node) == UpgradeStateUpgradeRequired && !m.skipNodeUpgrade(node) {
+				capacity.NextEligibleNodes = append(capacity.NextEligibleNodes, node.Name)
+			}
+		}
+	}
+	return capacity
+}
+
+// GetUpgradeCapacity returns the count of node upgrades which can be started and the nodes which are upgraded next,
+// as computed by the last ApplyState pass
+func (m *ClusterUpgradeStateManagerImpl) GetUpgradeCapacity() UpgradeCapacity {
+	return m.upgradeCapacity.get()
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import "math"
+
+// UpgradeSlotsInput are the node counts and the limits of the upgrade policy the upgrade slots are computed from
+type UpgradeSlotsInput struct {
+	// TotalNodes is the count of nodes managed for driver upgrades
+	TotalNodes int
+	// UpgradesInProgress is the count of nodes past the upgrade-required state which are not done
+	UpgradesInProgress int
+	// UpgradesInProgressWeight is the total upgrade weight of the nodes on which upgrade is in progress
+	UpgradesInProgressWeight int
+	// UpgradesPending is the count of nodes in the upgrade-required state
+	UpgradesPending int
+	// UnavailableNodes is the count of cordoned or not Ready nodes, including the nodes about to be cordoned
+	UnavailableNodes int
+	// MaxParallelUpgrades is the limit of parallel upgrades, 0 means no limit
+	MaxParallelUpgrades int
+	// MaxUnavailable is the limit of unavailable nodes, resolved from the upgrade policy against TotalNodes
+	MaxUnavailable int
+}
+
+// UpgradeSlots are the node upgrades which can be started
+type UpgradeSlots struct {
+	// Upgrades is the count of node upgrades which can be started
+	Upgrades int
+	// Weight is the upgrade weight which can be taken by the started node upgrades, math.MaxInt if not limited
+	Weight int
+	// OverBudget is the count of upgrades in progress beyond MaxParallelUpgrades, e.g. after the limit was lowered
+	OverBudget int
+	// ZoneUpgrades is the count of node upgrades which can be started per zone, nil if the zones are not limited.
+	// The zones missing from the map are not limited.
+	ZoneUpgrades map[string]int
+}
+
+// ComputeUpgradeSlots returns the node upgrades which can be started for the node counts and the limits.
+// When MaxParallelUpgrades is 0 all the pending nodes can be started, the count is still limited by MaxUnavailable.
+// When more upgrades are in progress than MaxParallelUpgrades allows, no upgrade can be started and the excess
+// is reported as OverBudget, the slots are never negative.
+// The function is pure, the cluster wide unavailable nodes budget, the maintenance windows and the blackout
+// periods are applied on top of it by the upgrade state manager.
+func ComputeUpgradeSlots(input UpgradeSlotsInput) UpgradeSlots {
+	var slots UpgradeSlots
+	if input.MaxParallelUpgrades == 0 {
+		// Only nodes in UpgradeStateUpgradeRequired can start upgrading, so all of them will move to drain stage
+		slots.Upgrades = input.UpgradesPending
+		slots.Weight = math.MaxInt
+	} else {
+		slots.Upgrades = input.MaxParallelUpgrades - input.UpgradesInProgress
+		slots.Weight = max(input.MaxParallelUpgrades-input.UpgradesInProgressWeight, 0)
+		slots.OverBudget = max(input.UpgradesInProgress-input.MaxParallelUpgrades, 0)
+	}
+
+	// always limit the upgrades to maxUnavailable
+	if slots.Upgrades > input.MaxUnavailable {
+		slots.Upgrades = input.MaxUnavailable
+	}
+	// apply additional limits when there are already unavailable nodes
+	if input.UnavailableNodes >= input.MaxUnavailable {
+		slots.Upgrades = 0
+	} else if input.MaxUnavailable < input.TotalNodes &&
+		input.UnavailableNodes+slots.Upgrades > input.MaxUnavailable {
+		slots.Upgrades = input.MaxUnavailable - input.UnavailableNodes
+	}
+	slots.Upgrades = max(slots.Upgrades, 0)
+	return slots
+}
+
+// UpgradeCandidate describes a node in the upgrade-required state for the node selection
+type UpgradeCandidate struct {
+	// Name is the name of the node
+	Name string
+	// Weight is the upgrade weight of the node, see GetNodeUpgradeWeight
+	Weight int
+	// Unschedulable is true if the node is already cordoned
+	Unschedulable bool
+	// SkipUpgrade is true if the node is marked for skipping upgrades
+	SkipUpgrade bool
+	// Zone is the availability zone of the node, see GetNodeZone
+	Zone string
+}
+
+// UpgradeDecision is the decision taken for an upgrade candidate
+type UpgradeDecision string
+
+const (
+	// UpgradeDecisionStart means that the upgrade of the node is started
+	UpgradeDecisionStart UpgradeDecision = "Start"
+	// UpgradeDecisionWaitForSlot means that the node waits for an upgrade slot
+	UpgradeDecisionWaitForSlot UpgradeDecision = "WaitForSlot"
+	// UpgradeDecisionWaitForZoneSlot means that the node waits for an upgrade slot of its zone
+	UpgradeDecisionWaitForZoneSlot UpgradeDecision = "WaitForZoneSlot"
+	// UpgradeDecisionSkip means that the node is marked for skipping upgrades
+	UpgradeDecisionSkip UpgradeDecision = "Skip"
+)
+
+// SelectNodesForUpgrade returns the decisions for the candidates, in the order of the candidates.
+// Candidates are started in order until the upgrade slots are used up, the weights being capped by
+// maxParallelUpgrades so that a node heavier than the whole budget can still be upgraded alone.
+// Candidates already cordoned are started even when no slot is left, as they are already unavailable.
+// The started candidates also take a slot of their zone, if the zones are limited by slots.ZoneUpgrades.
+func SelectNodesForUpgrade(candidates []UpgradeCandidate, slots UpgradeSlots,
+	maxParallelUpgrades int) []UpgradeDecision {
+	decisions := make([]UpgradeDecision, len(candidates))
+	zoneUpgrades := make(map[string]int, len(slots.ZoneUpgrades))
+	for zone, upgrades := range slots.ZoneUpgrades {
+		zoneUpgrades[zone] = upgrades
+	}
+	for i, candidate := range candidates {
+		if candidate.SkipUpgrade {
+			decisions[i] = UpgradeDecisionSkip
+			continue
+		}
+		weight := candidate.Weight
+		if maxParallelUpgrades > 0 && weight > maxParallelUpgrades {
+			weight = maxParallelUpgrades
+		}
+		if (slots.Upgrades <= 0 || weight > slots.Weight) && !candidate.Unschedulable {
+			decisions[i] = UpgradeDecisionWaitForSlot
+			continue
+		}
+		zoneSlots, zoneLimited := zoneUpgrades[candidate.Zone]
+		if zoneLimited && zoneSlots <= 0 && !candidate.Unschedulable {
+			decisions[i] = UpgradeDecisionWaitForZoneSlot
+			continue
+		}
+		decisions[i] = UpgradeDecisionStart
+		slots.Upgrades--
+		slots.Weight -= weight
+		if zoneLimited {
+			zoneUpgrades[candidate.Zone]--
+		}
+	}
+	return decisions
+}
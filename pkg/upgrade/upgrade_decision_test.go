@@ -0,0 +1,127 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade_test
+
+import (
+	"math"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
+)
+
+var _ = Describe("Upgrade decision", func() {
+	Describe("ComputeUpgradeSlots", func() {
+		It("should start all the pending nodes if max parallel upgrades is 0", func() {
+			slots := upgrade.ComputeUpgradeSlots(upgrade.UpgradeSlotsInput{
+				TotalNodes:         10,
+				UpgradesInProgress: 3,
+				UpgradesPending:    5,
+				MaxUnavailable:     10,
+			})
+			Expect(slots.Upgrades).To(Equal(5))
+			Expect(slots.Weight).To(Equal(math.MaxInt))
+		})
+		It("should leave the slots not taken by the upgrades in progress", func() {
+			slots := upgrade.ComputeUpgradeSlots(upgrade.UpgradeSlotsInput{
+				TotalNodes:               10,
+				UpgradesInProgress:       1,
+				UpgradesInProgressWeight: 2,
+				UpgradesPending:          9,
+				UnavailableNodes:         1,
+				MaxParallelUpgrades:      4,
+				MaxUnavailable:           10,
+			})
+			Expect(slots.Upgrades).To(Equal(3))
+			Expect(slots.Weight).To(Equal(2))
+		})
+		It("should report the upgrades in progress over max parallel upgrades", func() {
+			slots := upgrade.ComputeUpgradeSlots(upgrade.UpgradeSlotsInput{
+				TotalNodes:               10,
+				UpgradesInProgress:       5,
+				UpgradesInProgressWeight: 5,
+				UpgradesPending:          5,
+				MaxParallelUpgrades:      2,
+				MaxUnavailable:           10,
+			})
+			Expect(slots).To(Equal(upgrade.UpgradeSlots{Upgrades: 0, Weight: 0, OverBudget: 3}))
+		})
+		It("should limit the slots by max unavailable", func() {
+			input := upgrade.UpgradeSlotsInput{
+				TotalNodes:      10,
+				UpgradesPending: 10,
+				MaxUnavailable:  3,
+			}
+			Expect(upgrade.ComputeUpgradeSlots(input).Upgrades).To(Equal(3))
+
+			input.UnavailableNodes = 2
+			Expect(upgrade.ComputeUpgradeSlots(input).Upgrades).To(Equal(1))
+
+			input.UnavailableNodes = 3
+			Expect(upgrade.ComputeUpgradeSlots(input).Upgrades).To(Equal(0))
+		})
+	})
+
+	Describe("SelectNodesForUpgrade", func() {
+		It("should start the candidates in order until the slots are used up", func() {
+			candidates := []upgrade.UpgradeCandidate{
+				{Name: "node-1", Weight: 1},
+				{Name: "node-2", Weight: 1, SkipUpgrade: true},
+				{Name: "node-3", Weight: 2},
+				{Name: "node-4", Weight: 1},
+				{Name: "node-5", Weight: 1, Unschedulable: true},
+			}
+			decisions := upgrade.SelectNodesForUpgrade(candidates, upgrade.UpgradeSlots{Upgrades: 3, Weight: 3}, 3)
+			Expect(decisions).To(Equal([]upgrade.UpgradeDecision{
+				upgrade.UpgradeDecisionStart,
+				upgrade.UpgradeDecisionSkip,
+				upgrade.UpgradeDecisionStart,
+				// the weight is used up
+				upgrade.UpgradeDecisionWaitForSlot,
+				// already cordoned nodes progress without a slot
+				upgrade.UpgradeDecisionStart,
+			}))
+		})
+		It("should cap the weight of the candidates by max parallel upgrades", func() {
+			candidates := []upgrade.UpgradeCandidate{{Name: "large-node", Weight: 8}}
+			decisions := upgrade.SelectNodesForUpgrade(candidates, upgrade.UpgradeSlots{Upgrades: 2, Weight: 2}, 2)
+			Expect(decisions).To(Equal([]upgrade.UpgradeDecision{upgrade.UpgradeDecisionStart}))
+		})
+		It("should start the candidates of a zone until the slots of the zone are used up", func() {
+			candidates := []upgrade.UpgradeCandidate{
+				{Name: "node-a-1", Weight: 1, Zone: "zone-a"},
+				{Name: "node-a-2", Weight: 1, Zone: "zone-a"},
+				{Name: "node-b-1", Weight: 1, Zone: "zone-b"},
+				{Name: "node-a-3", Weight: 1, Zone: "zone-a", Unschedulable: true},
+				{Name: "node-c-1", Weight: 1, Zone: "zone-c"},
+			}
+			slots := upgrade.UpgradeSlots{Upgrades: 4, Weight: 4, ZoneUpgrades: map[string]int{"zone-a": 1, "zone-b": 0}}
+			decisions := upgrade.SelectNodesForUpgrade(candidates, slots, 4)
+			Expect(decisions).To(Equal([]upgrade.UpgradeDecision{
+				upgrade.UpgradeDecisionStart,
+				upgrade.UpgradeDecisionWaitForZoneSlot,
+				upgrade.UpgradeDecisionWaitForZoneSlot,
+				// already cordoned nodes progress without a slot of their zone
+				upgrade.UpgradeDecisionStart,
+				// the zones missing from the slots are not limited
+				upgrade.UpgradeDecisionStart,
+			}))
+			Expect(slots.ZoneUpgrades).To(Equal(map[string]int{"zone-a": 1, "zone-b": 0}))
+		})
+	})
+})
@@ -0,0 +1,307 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+// UpgradeKeys returns the keys of the node labels, annotations and taints written by the upgrade package with
+// a key prefix, so that several operators using the package on the same cluster don't collide, see WithKeyPrefix.
+// The zero value uses DefaultKeyPrefix, like the package-level functions of the same name.
+type UpgradeKeys struct {
+	prefix string
+}
+
+// NewUpgradeKeys returns the UpgradeKeys with the given prefix, e.g. "example.com" for the
+// "example.com/gpu-driver-upgrade-state" label. DefaultKeyPrefix is used if prefix is empty.
+func NewUpgradeKeys(prefix string) UpgradeKeys {
+	return UpgradeKeys{prefix: prefix}
+}
+
+// Prefix returns the prefix of the keys
+func (k UpgradeKeys) Prefix() string {
+	if k.prefix == "" {
+		return DefaultKeyPrefix
+	}
+	return k.prefix
+}
+
+// WithKeyPrefix provides an option to write the node labels, annotations and taints of the upgrade with the given
+// key prefix instead of DefaultKeyPrefix, e.g. "example.com" for the "example.com/gpu-driver-upgrade-state" label,
+// so that several operators upgrading drivers of the same name don't collide. The prefix is used by the manager,
+// its NodeUpgradeStateProvider, its managers, its validation and reboot Jobs and its state storage.
+// The node upgrade states written with another prefix are not read.
+func (m *ClusterUpgradeStateManagerImpl) WithKeyPrefix(prefix string) ClusterUpgradeStateManager {
+	m.keys = NewUpgradeKeys(prefix)
+	m.stateStorage = bindStateStorage(m.stateStorage, m.keys)
+	m.propagateKeys()
+	return m
+}
+
+// GetUpgradeKeys returns the keys of the node labels, annotations and taints written by the manager,
+// see WithKeyPrefix
+func (m *ClusterUpgradeStateManagerImpl) GetUpgradeKeys() UpgradeKeys {
+	return m.keys
+}
+
+// keysSetter is implemented by the helpers of this package which need the keys of the manager using them,
+// e.g. the JobValidator
+type keysSetter interface {
+	setKeys(keys UpgradeKeys)
+}
+
+// propagateKeys sets the keys and the state storage of the manager on its provider, managers and helpers,
+// if they are implemented by this package
+func (m *ClusterUpgradeStateManagerImpl) propagateKeys() {
+	switch p := unwrapNodeUpgradeStateProvider(m.NodeUpgradeStateProvider).(type) {
+	case *NodeUpgradeStateProviderImpl:
+		p.WithKeyPrefix(m.keys.prefix)
+	case *CachedNodeUpgradeStateProvider:
+		p.WithKeyPrefix(m.keys.prefix)
+	}
+	setProviderStateStorage(m.NodeUpgradeStateProvider, m.stateStorage)
+	if drainManager, ok := m.DrainManager.(*DrainManagerImpl); ok {
+		drainManager.keys = m.keys
+	}
+	if podManager, ok := m.PodManager.(*PodManagerImpl); ok {
+		podManager.keys = m.keys
+	}
+	if validationManager, ok := m.ValidationManager.(*ValidationManagerImpl); ok {
+		validationManager.keys = m.keys
+	}
+	if safeDriverLoadManager, ok := m.SafeDriverLoadManager.(*SafeDriverLoadManagerImpl); ok {
+		safeDriverLoadManager.keys = m.keys
+	}
+	for _, validator := range m.validators {
+		if setter, ok := validator.(keysSetter); ok {
+			setter.setKeys(m.keys)
+		}
+	}
+	if m.nodeReboot != nil {
+		if setter, ok := m.nodeReboot.rebooter.(keysSetter); ok {
+			setter.setKeys(m.keys)
+		}
+	}
+}
+
+// GetUpgradeStateLabelKey returns state label key used for upgrades
+func (k UpgradeKeys) GetUpgradeStateLabelKey() string {
+	return formatKey(k.Prefix(), UpgradeStateLabelKeyFmt)
+}
+
+// GetUpgradeStateAnnotationKey returns state annotation key used for upgrades by AnnotationStateStorage
+func (k UpgradeKeys) GetUpgradeStateAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeStateAnnotationKeyFmt)
+}
+
+// GetUpgradeStateTaintKey returns state taint key used for upgrades by TaintStateStorage
+func (k UpgradeKeys) GetUpgradeStateTaintKey() string {
+	return formatKey(k.Prefix(), UpgradeStateTaintKeyFmt)
+}
+
+// GetUpgradeStateStoredLabelKey returns the key of the label marking the nodes whose upgrade state is stored
+// by AnnotationStateStorage or TaintStateStorage
+func (k UpgradeKeys) GetUpgradeStateStoredLabelKey() string {
+	return formatKey(k.Prefix(), UpgradeStateStoredLabelKeyFmt)
+}
+
+// GetUpgradeImpactAnnotationKey returns the key for the annotation containing the expected impact of the node upgrade
+func (k UpgradeKeys) GetUpgradeImpactAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeImpactAnnotationKeyFmt)
+}
+
+// GetUpgradeHistoryAnnotationKey returns the key for the annotation containing the upgrade history of the node
+func (k UpgradeKeys) GetUpgradeHistoryAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeHistoryAnnotationKeyFmt)
+}
+
+// GetUpgradeSkipNodeLabelKey returns node label used to skip upgrades
+func (k UpgradeKeys) GetUpgradeSkipNodeLabelKey() string {
+	return formatKey(k.Prefix(), UpgradeSkipNodeLabelKeyFmt)
+}
+
+// GetUpgradeNodeWeightLabelKey returns node label used to set the upgrade weight of the node
+func (k UpgradeKeys) GetUpgradeNodeWeightLabelKey() string {
+	return formatKey(k.Prefix(), UpgradeNodeWeightLabelKeyFmt)
+}
+
+// GetUpgradeDriverWaitForSafeLoadAnnotationKey returns the key for annotation used to mark node as waiting for driver
+// safe load
+func (k UpgradeKeys) GetUpgradeDriverWaitForSafeLoadAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeWaitForSafeDriverLoadAnnotationKeyFmt)
+}
+
+// GetUpgradeRequestedAnnotationKey returns the key for annotation used to mark node as driver upgrade is requested
+// externally (orphaned pod)
+func (k UpgradeKeys) GetUpgradeRequestedAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeRequestedAnnotationKeyFmt)
+}
+
+// GetUpgradeInitialStateAnnotationKey returns the key for annotation used to track initial state of the node
+func (k UpgradeKeys) GetUpgradeInitialStateAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeInitialStateAnnotationKeyFmt)
+}
+
+// GetWaitForPodCompletionStartTimeAnnotationKey returns the key for annotation used to track start time for waiting on
+// pod/job completions
+func (k UpgradeKeys) GetWaitForPodCompletionStartTimeAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeWaitForPodCompletionStartTimeAnnotationKeyFmt)
+}
+
+// GetWaitForPodCompletionRunningPodsAnnotationKey returns the key for annotation containing the count of
+// the workload pods still running on the node while waiting on pod completions
+func (k UpgradeKeys) GetWaitForPodCompletionRunningPodsAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeWaitForPodCompletionRunningPodsAnnotationKeyFmt)
+}
+
+// GetValidationStartTimeAnnotationKey returns the key for annotation indicating start time for validation-required
+// state
+func (k UpgradeKeys) GetValidationStartTimeAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeValidationStartTimeAnnotationKeyFmt)
+}
+
+// GetNodeReadyWaitStartTimeAnnotationKey returns the key for annotation used to track start time for waiting on
+// the node to become Ready
+func (k UpgradeKeys) GetNodeReadyWaitStartTimeAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeNodeReadyWaitStartTimeAnnotationKeyFmt)
+}
+
+// GetUncordonGateStartTimeAnnotationKey returns the key for annotation used to track the time the uncordon checks
+// of the node started failing
+func (k UpgradeKeys) GetUncordonGateStartTimeAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeUncordonGateStartTimeAnnotationKeyFmt)
+}
+
+// GetValidatorsStartTimeAnnotationKey returns the key for annotation used to track start time for the validators
+// of the validation-required state
+func (k UpgradeKeys) GetValidatorsStartTimeAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeValidatorsStartTimeAnnotationKeyFmt)
+}
+
+// GetRebootBootIDAnnotationKey returns the key for annotation containing the boot ID of the node when its reboot
+// was requested
+func (k UpgradeKeys) GetRebootBootIDAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeRebootBootIDAnnotationKeyFmt)
+}
+
+// GetRebootStartTimeAnnotationKey returns the key for annotation used to track start time for waiting on
+// the node reboot
+func (k UpgradeKeys) GetRebootStartTimeAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeRebootStartTimeAnnotationKeyFmt)
+}
+
+// GetUpgradeStateStartTimeAnnotationKey returns the key for the annotation used to track the time the node
+// entered its upgrade state
+func (k UpgradeKeys) GetUpgradeStateStartTimeAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeStateStartTimeAnnotationKeyFmt)
+}
+
+// GetUpgradeRetryAttemptsAnnotationKey returns the key for the annotation used to count the retries of the failed
+// node upgrade
+func (k UpgradeKeys) GetUpgradeRetryAttemptsAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeRetryAttemptsAnnotationKeyFmt)
+}
+
+// GetUpgradeRetryStartTimeAnnotationKey returns the key for the annotation used to track the start time
+// of the backoff before the failed node upgrade is retried
+func (k UpgradeKeys) GetUpgradeRetryStartTimeAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeRetryStartTimeAnnotationKeyFmt)
+}
+
+// GetUpgradeDowngradeAnnotationKey returns the key for the annotation indicating that the upgrade of the node
+// is a driver downgrade
+func (k UpgradeKeys) GetUpgradeDowngradeAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeDowngradeAnnotationKeyFmt)
+}
+
+// GetUpgradeDowngradeApprovedAnnotationKey returns the key for the annotation used to approve the driver downgrade
+// of the node
+func (k UpgradeKeys) GetUpgradeDowngradeApprovedAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeDowngradeApprovedAnnotationKeyFmt)
+}
+
+// GetUpgradeDrainApprovedAnnotationKey returns the key for the annotation used to approve the drain of a node
+// evicting the only ready replica of a workload
+func (k UpgradeKeys) GetUpgradeDrainApprovedAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeDrainApprovedAnnotationKeyFmt)
+}
+
+// GetUpgradeDrainTimeoutAnnotationKey returns the key for the annotation overriding the drain timeout of a node
+func (k UpgradeKeys) GetUpgradeDrainTimeoutAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeDrainTimeoutAnnotationKeyFmt)
+}
+
+// GetUpgradeDrainForceAnnotationKey returns the key for the annotation overriding the force option of the drain
+// of a node
+func (k UpgradeKeys) GetUpgradeDrainForceAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeDrainForceAnnotationKeyFmt)
+}
+
+// GetUpgradeDrainDeleteEmptyDirAnnotationKey returns the key for the annotation overriding the deletion of the pods
+// using emptyDir volumes during the drain of a node
+func (k UpgradeKeys) GetUpgradeDrainDeleteEmptyDirAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeDrainDeleteEmptyDirAnnotationKeyFmt)
+}
+
+// GetUpgradeDrainLeaseAnnotationKey returns the key for the annotation containing the lease of the manager
+// draining the node
+func (k UpgradeKeys) GetUpgradeDrainLeaseAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeDrainLeaseAnnotationKeyFmt)
+}
+
+// GetUpgradeDrainStatusAnnotationKey returns the key for the annotation containing the progress of the node drain
+func (k UpgradeKeys) GetUpgradeDrainStatusAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeDrainStatusAnnotationKeyFmt)
+}
+
+// GetUpgradeCanarySoakStartTimeAnnotationKey returns the key for the annotation used to track the start time
+// of the soak period of a canary node
+func (k UpgradeKeys) GetUpgradeCanarySoakStartTimeAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeCanarySoakStartTimeAnnotationKeyFmt)
+}
+
+// GetUpgradeNodeHookLabelKey returns the key for the label set on the node hook pods
+func (k UpgradeKeys) GetUpgradeNodeHookLabelKey() string {
+	return formatKey(k.Prefix(), UpgradeNodeHookLabelKeyFmt)
+}
+
+// GetUpgradeManualUncordonAnnotationKey returns the key for annotation used to mark node as manually uncordoned
+// during the upgrade
+func (k UpgradeKeys) GetUpgradeManualUncordonAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeManualUncordonAnnotationKeyFmt)
+}
+
+// GetUpgradeFailedNodeCordonAnnotationKey returns the key for annotation used to record whether the failed node
+// was left cordoned or uncordoned
+func (k UpgradeKeys) GetUpgradeFailedNodeCordonAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeFailedNodeCordonAnnotationKeyFmt)
+}
+
+// GetUpgradeSlotsAvailableAnnotationKey returns the key for the status ConfigMap annotation used to publish the count
+// of node upgrades which can be started
+func (k UpgradeKeys) GetUpgradeSlotsAvailableAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeSlotsAvailableAnnotationKeyFmt)
+}
+
+// GetUpgradeNextEligibleNodesAnnotationKey returns the key for the status ConfigMap annotation used to publish
+// the names of the nodes which are upgraded next
+func (k UpgradeKeys) GetUpgradeNextEligibleNodesAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeNextEligibleNodesAnnotationKeyFmt)
+}
+
+// GetUpgradeStateReasonAnnotationKey returns the key for annotation used to track the reason of the node's current
+// upgrade state
+func (k UpgradeKeys) GetUpgradeStateReasonAnnotationKey() string {
+	return formatKey(k.Prefix(), UpgradeStateReasonAnnotationKeyFmt)
+}
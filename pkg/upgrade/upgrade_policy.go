@@ -0,0 +1,94 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"fmt"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// defaultPodDeletionTimeoutSeconds is the default of PodDeletionSpec.TimeoutSecond
+const defaultPodDeletionTimeoutSeconds = 300
+
+// isDrainEnabled returns true if node drain is enabled by the upgrade policy
+func isDrainEnabled(policy *v1alpha1.DriverUpgradePolicySpec) bool {
+	return policy.DrainSpec != nil && policy.DrainSpec.Enable
+}
+
+// getPodDeletionSpec returns the pod deletion spec of the upgrade policy, or the default one if the policy has none
+func getPodDeletionSpec(policy *v1alpha1.DriverUpgradePolicySpec) *v1alpha1.PodDeletionSpec {
+	if policy.PodDeletion != nil {
+		return policy.PodDeletion
+	}
+	return &v1alpha1.PodDeletionSpec{TimeoutSecond: defaultPodDeletionTimeoutSeconds}
+}
+
+// ValidateUpgradePolicy checks that the workload pods handling requested by the upgrade policy can be honored.
+// Pod deletion is enabled with WithPodDeletionEnabled, drain is enabled by the upgrade policy:
+//   - pod deletion and drain enabled: pods are deleted, the node is drained only if the pod deletion fails
+//   - only pod deletion enabled: pods are deleted, the node is moved to upgrade-failed if the pod deletion fails
+//   - only drain enabled: the node is drained, podDeletion of the upgrade policy is ignored
+//   - none enabled: the driver pod is restarted while the workload pods keep running
+//
+// If pod deletion is enabled and the upgrade policy has no podDeletion spec, the default one is used.
+// The policy is rejected if it requests pod deletion, but neither pod deletion nor drain is enabled,
+// as the workload pods would be left running during the driver restart.
+// The policy is also rejected if ValidateUpgradePolicySpec doesn't accept it.
+func (m *ClusterUpgradeStateManagerImpl) ValidateUpgradePolicy(policy *v1alpha1.DriverUpgradePolicySpec) error {
+	if policy == nil {
+		return nil
+	}
+	if err := ValidateUpgradePolicySpec(policy); err != nil {
+		return err
+	}
+	if policy.PodDeletion == nil || m.IsPodDeletionEnabled() {
+		return nil
+	}
+	if !isDrainEnabled(policy) {
+		return fmt.Errorf("upgrade policy requests pod deletion, but pod deletion is not enabled and drain is disabled")
+	}
+	m.Log.V(consts.LogLevelWarning).Info("Pod deletion is not enabled, podDeletion of the upgrade policy is ignored, " +
+		"nodes are drained instead")
+	return nil
+}
+
+// ValidateUpgradePolicySpec checks the upgrade policy independently of the upgrade state manager settings:
+// the fields checked by DriverUpgradePolicySpec.Validate, the maintenance window schedule, the blackout periods
+// and the upgrade states of the state timeouts.
+// It can be used by admission webhooks, see UpgradePolicyValidator.
+func ValidateUpgradePolicySpec(policy *v1alpha1.DriverUpgradePolicySpec) error {
+	if policy == nil {
+		return nil
+	}
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid upgrade policy: %v", err)
+	}
+	if policy.Schedule != nil {
+		if _, err := parseMaintenanceWindow(policy.Schedule); err != nil {
+			return fmt.Errorf("invalid upgrade schedule: %v", err)
+		}
+	}
+	if err := validateBlackoutPeriods(policy); err != nil {
+		return fmt.Errorf("invalid upgrade blackout period: %v", err)
+	}
+	if err := validateNodeStateTimeouts(policy); err != nil {
+		return fmt.Errorf("invalid upgrade state timeout: %v", err)
+	}
+	return nil
+}
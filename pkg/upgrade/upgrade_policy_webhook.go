@@ -0,0 +1,69 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+)
+
+// UpgradePolicyGetter returns the upgrade policy embedded in the custom resource of the operator,
+// or nil if the resource has none
+type UpgradePolicyGetter func(obj runtime.Object) (*v1alpha1.DriverUpgradePolicySpec, error)
+
+// UpgradePolicyValidator implements admission.CustomValidator, it rejects the custom resources of the operator
+// whose upgrade policy isn't accepted by ValidateUpgradePolicySpec. It can be registered with
+// admission.WithCustomValidator, or called from the validating webhook of the operator.
+type UpgradePolicyValidator struct {
+	getPolicy UpgradePolicyGetter
+}
+
+// NewUpgradePolicyValidator creates an UpgradePolicyValidator reading the upgrade policy with getPolicy
+func NewUpgradePolicyValidator(getPolicy UpgradePolicyGetter) *UpgradePolicyValidator {
+	return &UpgradePolicyValidator{getPolicy: getPolicy}
+}
+
+// ValidateCreate implements admission.CustomValidator
+func (v *UpgradePolicyValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(obj)
+}
+
+// ValidateUpdate implements admission.CustomValidator
+func (v *UpgradePolicyValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (
+	admission.Warnings, error) {
+	return nil, v.validate(newObj)
+}
+
+// ValidateDelete implements admission.CustomValidator, deletion is always allowed
+func (v *UpgradePolicyValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate returns an error if the upgrade policy of obj is invalid
+func (v *UpgradePolicyValidator) validate(obj runtime.Object) error {
+	policy, err := v.getPolicy(obj)
+	if err != nil {
+		return err
+	}
+	return ValidateUpgradePolicySpec(policy)
+}
+
+var _ admission.CustomValidator = &UpgradePolicyValidator{}
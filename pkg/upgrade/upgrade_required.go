@@ -0,0 +1,176 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// UpgradeRequiredChecker decides whether a driver pod is outdated, i.e. whether the node must be upgraded
+// to run the current driver of the DaemonSet
+type UpgradeRequiredChecker interface {
+	// IsUpgradeRequired returns true if the driver pod doesn't run the current driver of the DaemonSet
+	IsUpgradeRequired(ctx context.Context, pod *corev1.Pod, ds *appsv1.DaemonSet) (bool, error)
+}
+
+// UpgradeRequiredCheckerFunc is a function implementing UpgradeRequiredChecker
+type UpgradeRequiredCheckerFunc func(ctx context.Context, pod *corev1.Pod, ds *appsv1.DaemonSet) (bool, error)
+
+// IsUpgradeRequired implements UpgradeRequiredChecker
+func (f UpgradeRequiredCheckerFunc) IsUpgradeRequired(ctx context.Context, pod *corev1.Pod,
+	ds *appsv1.DaemonSet) (bool, error) {
+	return f(ctx, pod, ds)
+}
+
+// podTemplateGenerationLabelKey is the label the DaemonSet controller sets on the pods to the template generation
+// of the DaemonSet they were created from
+const podTemplateGenerationLabelKey = "pod-template-generation"
+
+// templateGenerationChecker compares the template generation of the driver pod with the one of its DaemonSet
+type templateGenerationChecker struct{}
+
+// NewTemplateGenerationUpgradeRequiredChecker returns an UpgradeRequiredChecker requiring an upgrade when
+// the pod-template-generation label of the driver pod differs from the template generation of the DaemonSet,
+// i.e. its deprecated.daemonset.template.generation annotation, or its generation if it has no such annotation
+func NewTemplateGenerationUpgradeRequiredChecker() UpgradeRequiredChecker {
+	return templateGenerationChecker{}
+}
+
+// IsUpgradeRequired implements UpgradeRequiredChecker
+func (templateGenerationChecker) IsUpgradeRequired(_ context.Context, pod *corev1.Pod,
+	ds *appsv1.DaemonSet) (bool, error) {
+	generation, ok := ds.Annotations[appsv1.DeprecatedTemplateGeneration]
+	if !ok {
+		generation = strconv.FormatInt(ds.Generation, 10)
+	}
+	return pod.Labels[podTemplateGenerationLabelKey] != generation, nil
+}
+
+// imageDigestChecker compares the images of the driver pod with the ones of the DaemonSet template
+type imageDigestChecker struct {
+	// containerName is the name of the container whose image is compared, all the containers if empty
+	containerName string
+}
+
+// NewImageDigestUpgradeRequiredChecker returns an UpgradeRequiredChecker requiring an upgrade when the image
+// of a container of the driver pod differs from the one of the DaemonSet template, or when the template image
+// is pinned to a digest and the container runs an image with another digest, e.g. with the OnDelete update
+// strategy. Only the container named containerName is compared if it's not empty.
+func NewImageDigestUpgradeRequiredChecker(containerName string) UpgradeRequiredChecker {
+	return imageDigestChecker{containerName: containerName}
+}
+
+// IsUpgradeRequired implements UpgradeRequiredChecker
+func (c imageDigestChecker) IsUpgradeRequired(_ context.Context, pod *corev1.Pod,
+	ds *appsv1.DaemonSet) (bool, error) {
+	for _, container := range ds.Spec.Template.Spec.Containers {
+		if c.containerName != "" && container.Name != c.containerName {
+			continue
+		}
+		podContainer := findContainer(pod.Spec.Containers, container.Name)
+		if podContainer == nil || podContainer.Image != container.Image {
+			return true, nil
+		}
+		digest := getImageDigest(container.Image)
+		if digest == "" {
+			continue
+		}
+		status := findContainerStatus(pod.Status.ContainerStatuses, container.Name)
+		if status == nil || getImageDigest(status.ImageID) != digest {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// findContainer returns the container with the given name, nil if there is none
+func findContainer(containers []corev1.Container, name string) *corev1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+// findContainerStatus returns the status of the container with the given name, nil if there is none
+func findContainerStatus(statuses []corev1.ContainerStatus, name string) *corev1.ContainerStatus {
+	for i := range statuses {
+		if statuses[i].Name == name {
+			return &statuses[i]
+		}
+	}
+	return nil
+}
+
+// getImageDigest returns the digest of an image reference or image ID, e.g. sha256:0123 for
+// docker-pullable://registry/driver@sha256:0123, empty if it has no digest
+func getImageDigest(image string) string {
+	index := strings.LastIndex(image, "@")
+	if index < 0 {
+		return ""
+	}
+	return image[index+1:]
+}
+
+// labelChecker compares a label of the driver pod with the one of the DaemonSet template
+type labelChecker struct {
+	labelKey string
+}
+
+// NewLabelUpgradeRequiredChecker returns an UpgradeRequiredChecker requiring an upgrade when the value of
+// the label labelKey of the driver pod, e.g. a driver version label, differs from the one of the DaemonSet
+// template. A pod without the label is outdated if the template has it.
+func NewLabelUpgradeRequiredChecker(labelKey string) UpgradeRequiredChecker {
+	return labelChecker{labelKey: labelKey}
+}
+
+// IsUpgradeRequired implements UpgradeRequiredChecker
+func (c labelChecker) IsUpgradeRequired(_ context.Context, pod *corev1.Pod, ds *appsv1.DaemonSet) (bool, error) {
+	return pod.Labels[c.labelKey] != ds.Spec.Template.Labels[c.labelKey], nil
+}
+
+// WithUpgradeRequiredChecker provides an option to replace the detection of the outdated driver pods,
+// by default a pod is outdated if its controller revision hash is not the current one of its DaemonSet.
+// The checker applies to the primary and the additional drivers of the nodes. nil restores the default detection.
+func (m *ClusterUpgradeStateManagerImpl) WithUpgradeRequiredChecker(
+	checker UpgradeRequiredChecker) ClusterUpgradeStateManager {
+	m.upgradeRequiredChecker = checker
+	return m
+}
+
+// isPodInSyncByChecker returns true if the driver pod is in sync with its DaemonSet according to
+// the checker set with WithUpgradeRequiredChecker
+func (m *ClusterUpgradeStateManagerImpl) isPodInSyncByChecker(ctx context.Context, pod *corev1.Pod,
+	ds *appsv1.DaemonSet) (bool, error) {
+	required, err := m.upgradeRequiredChecker.IsUpgradeRequired(ctx, pod, ds)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to check whether the driver pod is outdated",
+			"pod", pod.Name, "daemonset", ds.Name)
+		return false, err
+	}
+	m.Log.V(consts.LogLevelDebug).Info("Checked whether the driver pod is outdated", "pod", pod.Name,
+		"daemonset", ds.Name, "upgradeRequired", required)
+	return !required, nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
+)
+
+var _ = Describe("UpgradeRequiredChecker", func() {
+	const (
+		image        = "nvcr.io/nvidia/driver:550"
+		digest       = "sha256:0123"
+		pinnedImage  = "nvcr.io/nvidia/driver@" + digest
+		runningImage = "docker-pullable://nvcr.io/nvidia/driver@"
+	)
+	newDaemonSet := func(image string, annotations, labels map[string]string) *appsv1.DaemonSet {
+		return &appsv1.DaemonSet{
+			ObjectMeta: v1.ObjectMeta{Generation: 3, Annotations: annotations},
+			Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Labels: labels},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "driver", Image: image}}},
+			}},
+		}
+	}
+	newPod := func(image, imageID string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{Labels: labels},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "driver", Image: image}}},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "driver", Image: image, ImageID: imageID}}},
+		}
+	}
+
+	DescribeTable("should detect the outdated driver pods",
+		func(checker upgrade.UpgradeRequiredChecker, pod *corev1.Pod, ds *appsv1.DaemonSet, expected bool) {
+			required, err := checker.IsUpgradeRequired(context.Background(), pod, ds)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(required).To(Equal(expected))
+		},
+		Entry("template generation matching the generation",
+			upgrade.NewTemplateGenerationUpgradeRequiredChecker(),
+			newPod(image, "", map[string]string{"pod-template-generation": "3"}),
+			newDaemonSet(image, nil, nil), false),
+		Entry("template generation older than the generation",
+			upgrade.NewTemplateGenerationUpgradeRequiredChecker(),
+			newPod(image, "", map[string]string{"pod-template-generation": "2"}),
+			newDaemonSet(image, nil, nil), true),
+		Entry("template generation matching the template generation annotation",
+			upgrade.NewTemplateGenerationUpgradeRequiredChecker(),
+			newPod(image, "", map[string]string{"pod-template-generation": "2"}),
+			newDaemonSet(image, map[string]string{appsv1.DeprecatedTemplateGeneration: "2"}, nil), false),
+		Entry("same image",
+			upgrade.NewImageDigestUpgradeRequiredChecker(""),
+			newPod(image, runningImage+"sha256:4567", nil), newDaemonSet(image, nil, nil), false),
+		Entry("other image",
+			upgrade.NewImageDigestUpgradeRequiredChecker(""),
+			newPod(image, "", nil), newDaemonSet("nvcr.io/nvidia/driver:560", nil, nil), true),
+		Entry("pinned digest running",
+			upgrade.NewImageDigestUpgradeRequiredChecker("driver"),
+			newPod(pinnedImage, runningImage+digest, nil), newDaemonSet(pinnedImage, nil, nil), false),
+		Entry("pinned digest not running",
+			upgrade.NewImageDigestUpgradeRequiredChecker("driver"),
+			newPod(pinnedImage, runningImage+"sha256:4567", nil), newDaemonSet(pinnedImage, nil, nil), true),
+		Entry("other image of a container not compared",
+			upgrade.NewImageDigestUpgradeRequiredChecker("sidecar"),
+			newPod(image, "", nil), newDaemonSet("nvcr.io/nvidia/driver:560", nil, nil), false),
+		Entry("same driver version label",
+			upgrade.NewLabelUpgradeRequiredChecker("driver-version"),
+			newPod(image, "", map[string]string{"driver-version": "550"}),
+			newDaemonSet(image, nil, map[string]string{"driver-version": "550"}), false),
+		Entry("other driver version label",
+			upgrade.NewLabelUpgradeRequiredChecker("driver-version"),
+			newPod(image, "", map[string]string{"driver-version": "550"}),
+			newDaemonSet(image, nil, map[string]string{"driver-version": "560"}), true),
+		Entry("missing driver version label",
+			upgrade.NewLabelUpgradeRequiredChecker("driver-version"),
+			newPod(image, "", nil),
+			newDaemonSet(image, nil, map[string]string{"driver-version": "560"}), true),
+	)
+})
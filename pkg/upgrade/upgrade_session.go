@@ -0,0 +1,266 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// UpgradeSession is the upgrade of the nodes to a new generation of the driver DaemonSets. A session starts when
+// the first node needs an upgrade for the generation and completes when all the nodes are upgraded to it.
+type UpgradeSession struct {
+	// Scope is the driver DaemonSet of the session processed with ApplyStateForDaemonSets,
+	// e.g. "gpu-operator/nvidia-driver-daemonset", it is empty for the session of the complete cluster
+	Scope string
+	// Generation identifies the generations of the driver DaemonSets the nodes are upgraded to,
+	// e.g. "gpu-operator/nvidia-driver-daemonset:4"
+	Generation string
+	// StartTime is the time the session was detected
+	StartTime time.Time
+	// CompletionTime is the time the session completed, zero while it is in progress
+	CompletionTime time.Time
+	// Nodes are the names of the nodes upgraded during the session, sorted
+	Nodes []string
+	// FailedNodes are the names of the nodes which were in the upgrade-failed state during the session, sorted
+	FailedNodes []string
+	// Superseded is true if the session ended because the driver DaemonSets changed again before all the nodes
+	// were upgraded, a new session is started for the new generation
+	Superseded bool
+}
+
+// Duration returns the duration of the completed session, or the time since its start if it is in progress
+func (s UpgradeSession) Duration() time.Duration {
+	if s.CompletionTime.IsZero() {
+		return time.Since(s.StartTime)
+	}
+	return s.CompletionTime.Sub(s.StartTime)
+}
+
+// UpgradeSessionHook is called by ApplyState when an upgrade session starts or completes, e.g. to send
+// a notification or to record the session history. It is called synchronously and should return quickly.
+type UpgradeSessionHook func(ctx context.Context, session UpgradeSession)
+
+// WithUpgradeSessionHooks provides an option to detect the upgrade sessions and to call onStarted when
+// the first node needs an upgrade for a new generation of the driver DaemonSets, and onCompleted with the summary
+// of the session when all the nodes are done, or when the session is superseded by a newer generation.
+// The sessions are kept in memory: a session in progress when the operator restarts is detected again
+// with the restart time as its start time. Nil hooks are not called.
+func (m *ClusterUpgradeStateManagerImpl) WithUpgradeSessionHooks(
+	onStarted, onCompleted UpgradeSessionHook) ClusterUpgradeStateManager {
+	m.upgradeSessions = &upgradeSessionTracker{onStarted: onStarted, onCompleted: onCompleted}
+	return m
+}
+
+// GetUpgradeSession returns the upgrade session of the complete cluster in progress, false if there is none
+// or the sessions are not detected, see WithUpgradeSessionHooks
+func (m *ClusterUpgradeStateManagerImpl) GetUpgradeSession() (UpgradeSession, bool) {
+	if m.upgradeSessions == nil {
+		return UpgradeSession{}, false
+	}
+	return m.upgradeSessions.get("")
+}
+
+// GetUpgradeSessions returns all the upgrade sessions in progress sorted by scope, e.g. the sessions
+// of every driver DaemonSet processed with ApplyStateForDaemonSets
+func (m *ClusterUpgradeStateManagerImpl) GetUpgradeSessions() []UpgradeSession {
+	if m.upgradeSessions == nil {
+		return nil
+	}
+	return m.upgradeSessions.list()
+}
+
+// upgradeSessionTracker keeps the upgrade sessions in progress by scope
+type upgradeSessionTracker struct {
+	mutex       sync.Mutex
+	onStarted   UpgradeSessionHook
+	onCompleted UpgradeSessionHook
+	scopes      map[string]*upgradeSessionScope
+}
+
+// upgradeSessionScope is the upgrade session state of a scope
+type upgradeSessionScope struct {
+	// current is the session in progress, nil if there is none
+	current *UpgradeSession
+	// nodes and failedNodes are the names of the nodes upgraded and failed during the current session
+	nodes       map[string]bool
+	failedNodes map[string]bool
+	// lastGeneration is the generation of the last completed session, it doesn't start a new session
+	lastGeneration string
+}
+
+// get returns a copy of the session of the scope in progress
+func (t *upgradeSessionTracker) get(scope string) (UpgradeSession, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	sessionScope, ok := t.scopes[scope]
+	if !ok || sessionScope.current == nil {
+		return UpgradeSession{}, false
+	}
+	return sessionScope.summary(), true
+}
+
+// list returns a copy of the sessions in progress sorted by scope
+func (t *upgradeSessionTracker) list() []UpgradeSession {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	sessions := make([]UpgradeSession, 0, len(t.scopes))
+	for _, sessionScope := range t.scopes {
+		if sessionScope.current != nil {
+			sessions = append(sessions, sessionScope.summary())
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Scope < sessions[j].Scope })
+	return sessions
+}
+
+// summary returns a copy of the current session with its nodes
+func (s *upgradeSessionScope) summary() UpgradeSession {
+	session := *s.current
+	session.Nodes = sortedKeys(s.nodes)
+	session.FailedNodes = sortedKeys(s.failedNodes)
+	return session
+}
+
+// update starts or completes the session of the scope from the upgrade states of its nodes at the end of a pass
+// and returns the sessions which completed, the one which started and the nodes which failed for the first time
+// during the session in progress
+func (t *upgradeSessionTracker) update(scope, generation string, upgrading, failed []string) (
+	completed []UpgradeSession, started *UpgradeSession, newlyFailed []string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.scopes == nil {
+		t.scopes = make(map[string]*upgradeSessionScope)
+	}
+	s, ok := t.scopes[scope]
+	if !ok {
+		s = &upgradeSessionScope{}
+		t.scopes[scope] = s
+	}
+
+	now := time.Now()
+	if s.current != nil && generation != s.current.Generation {
+		s.current.Superseded = true
+		s.current.CompletionTime = now
+		completed = append(completed, s.summary())
+		s.current = nil
+	}
+	isNew := s.current == nil
+	if isNew {
+		if len(upgrading) == 0 || generation == s.lastGeneration {
+			return completed, nil, nil
+		}
+		s.current = &UpgradeSession{Scope: scope, Generation: generation, StartTime: now}
+		s.nodes = make(map[string]bool)
+		s.failedNodes = make(map[string]bool)
+	}
+	for _, name := range upgrading {
+		s.nodes[name] = true
+	}
+	for _, name := range failed {
+		if !s.failedNodes[name] {
+			newlyFailed = append(newlyFailed, name)
+		}
+		s.failedNodes[name] = true
+	}
+	if isNew {
+		session := s.summary()
+		started = &session
+	}
+	if len(upgrading) == 0 {
+		s.current.CompletionTime = now
+		completed = append(completed, s.summary())
+		s.lastGeneration = generation
+		s.current = nil
+	}
+	return completed, started, newlyFailed
+}
+
+// updateUpgradeSession detects the start and the completion of the upgrade session of the scope from the upgrade
+// states of the nodes at the end of a pass over the complete state of the scope, and calls the session hooks.
+// The scope is empty for the complete cluster. Nodes marked for skipping upgrades don't keep the session in progress.
+func (m *ClusterUpgradeStateManagerImpl) updateUpgradeSession(ctx context.Context, currentState *ClusterUpgradeState,
+	scope string) {
+	if m.upgradeSessions == nil {
+		return
+	}
+	daemonSets := make(map[string]bool)
+	upgrading := make([]string, 0)
+	failed := make([]string, 0)
+	for _, nodeStates := range currentState.NodeStates {
+		for _, nodeState := range nodeStates {
+			if ds := nodeState.DriverDaemonSet; ds != nil {
+				daemonSets[fmt.Sprintf("%s/%s:%d", ds.Namespace, ds.Name, ds.Generation)] = true
+			}
+			node := nodeState.Node
+			switch state := m.getNodeUpgradeState(node); state {
+			case UpgradeStateUnknown, UpgradeStateDone, UpgradeStateDaemonSetMissing, UpgradeStateBlockedBySkew:
+				continue
+			case UpgradeStateUpgradeRequired:
+				if m.skipNodeUpgrade(node) {
+					continue
+				}
+			case UpgradeStateFailed:
+				failed = append(failed, node.Name)
+			}
+			upgrading = append(upgrading, node.Name)
+		}
+	}
+	generation := strings.Join(sortedKeys(daemonSets), ",")
+
+	completed, started, newlyFailed := m.upgradeSessions.update(scope, generation, upgrading, failed)
+	for _, session := range completed {
+		m.Log.V(consts.LogLevelInfo).Info("Upgrade session completed", "scope", session.Scope,
+			"generation", session.Generation,
+			"nodes", len(session.Nodes), "failedNodes", len(session.FailedNodes), "superseded", session.Superseded,
+			"duration", session.Duration().Round(time.Second))
+		if m.upgradeSessions.onCompleted != nil {
+			m.upgradeSessions.onCompleted(ctx, session)
+		}
+		m.notify(ctx, UpgradeNotification{Event: UpgradeEventCompleted, Session: session})
+	}
+	if started != nil {
+		m.Log.V(consts.LogLevelInfo).Info("Upgrade session started", "scope", started.Scope,
+			"generation", started.Generation)
+		if m.upgradeSessions.onStarted != nil {
+			m.upgradeSessions.onStarted(ctx, *started)
+		}
+		m.notify(ctx, UpgradeNotification{Event: UpgradeEventStarted, Session: *started})
+	}
+	if len(newlyFailed) > 0 {
+		session, _ := m.upgradeSessions.get(scope)
+		for _, name := range newlyFailed {
+			m.notify(ctx, UpgradeNotification{Event: UpgradeEventNodeFailed, Session: session, Node: name})
+		}
+	}
+}
+
+// sortedKeys returns the keys of the map in ascending order
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -19,14 +19,21 @@ package upgrade
 import (
 	"context"
 	"fmt"
+	"math"
+	"strconv"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -41,11 +48,26 @@ type NodeUpgradeState struct {
 	Node            *corev1.Node
 	DriverPod       *corev1.Pod
 	DriverDaemonSet *appsv1.DaemonSet
+	// SurgeDriverPod is the newer driver pod created on the node by the DaemonSet controller during a rolling update
+	// with maxSurge, while DriverPod is still running. It is nil if no surge is in progress on the node.
+	SurgeDriverPod *corev1.Pod
+	// AdditionalDrivers are the driver pods of the other driver DaemonSets covering the node, e.g. a network
+	// driver next to the GPU driver. They are restarted in the same cordon and drain cycle as DriverPod.
+	AdditionalDrivers []*NodeDriver
+	// DriverWorkload is the workload deploying DriverPod if the driver is not deployed by a DaemonSet,
+	// see WithDriverWorkloads
+	DriverWorkload DriverWorkload
 }
 
-// IsOrphanedPod returns true if Pod is not associated to a DaemonSet
+// IsOrphanedPod returns true if Pod is not associated to a DaemonSet or to a DriverWorkload
 func (nus *NodeUpgradeState) IsOrphanedPod() bool {
-	return nus.DriverDaemonSet == nil
+	return nus.DriverDaemonSet == nil && nus.DriverWorkload == nil
+}
+
+// IsSurgeInProgress returns true if the DaemonSet controller is replacing the driver pod on the node
+// by a surge pod and the handoff is not complete yet
+func (nus *NodeUpgradeState) IsSurgeInProgress() bool {
+	return nus.SurgeDriverPod != nil
 }
 
 // ClusterUpgradeState contains a snapshot of the driver upgrade state in the cluster
@@ -68,12 +90,32 @@ type ClusterUpgradeStateManager interface {
 	// ApplyState receives a complete cluster upgrade state and, based on upgrade policy, processes each node's state.
 	// Based on the current state of the node, it is calculated if the node can be moved to the next state right now
 	// or whether any actions need to be scheduled for the node to move to the next state.
-	// The function is stateless and idempotent. If the error was returned before all nodes' states were processed,
-	// ApplyState would be called again and complete the processing - all the decisions are based on the input data.
+	// The node upgrade states are read from the input data only, so that ApplyState is idempotent: if an error was
+	// returned before all nodes' states were processed, ApplyState is called again and completes the processing.
+	// The manager keeps some state in memory between the passes, which starts over when the operator restarts:
+	// the adaptive parallelism limit, the node upgrade timelines, the skip events of the nodes claimed by another
+	// operator, the upgrade sessions and the last processed node of every state with WithMaxNodesPerPass.
 	ApplyState(ctx context.Context,
 		currentState *ClusterUpgradeState, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) (err error)
+	// ApplyStateWithResult processes the cluster upgrade state like ApplyState and returns the outcome of the pass:
+	// the node state transitions, the count of nodes per state, the nodes skipped and why, the scheduled actions
+	// and the recommended delay before the next pass
+	ApplyStateWithResult(ctx context.Context, currentState *ClusterUpgradeState,
+		upgradePolicy *v1alpha1.DriverUpgradePolicySpec) (*ApplyResult, error)
+	// ApplyStateForNodePools processes the complete cluster upgrade state like ApplyState, with a separate
+	// upgrade policy for the nodes of every node pool
+	ApplyStateForNodePools(ctx context.Context, currentState *ClusterUpgradeState,
+		defaultPolicy *v1alpha1.DriverUpgradePolicySpec, pools []NodePoolUpgradePolicy) error
+	// ApplyStateForDaemonSets processes the complete cluster upgrade state like ApplyState, with separate
+	// upgrade limits and upgrade sessions for the nodes of every driver DaemonSet
+	ApplyStateForDaemonSets(ctx context.Context, currentState *ClusterUpgradeState,
+		upgradePolicy *v1alpha1.DriverUpgradePolicySpec) error
 	// BuildState builds a point-in-time snapshot of the driver upgrade state in the cluster.
 	BuildState(ctx context.Context, namespace string, driverLabels map[string]string) (*ClusterUpgradeState, error)
+	// BuildStateForSelector builds a point-in-time snapshot of the driver upgrade state in the cluster
+	// for the driver DaemonSets and pods matching the label selector
+	BuildStateForSelector(ctx context.Context, namespace string, driverLabelSelector string) (*ClusterUpgradeState,
+		error)
 	// GetTotalManagedNodes returns the total count of nodes managed for driver upgrades
 	GetTotalManagedNodes(ctx context.Context, currentState *ClusterUpgradeState) int
 	// GetUpgradesInProgress returns count of nodes on which upgrade is in progress
@@ -87,16 +129,162 @@ type ClusterUpgradeStateManager interface {
 	GetUpgradesFailed(ctx context.Context, currentState *ClusterUpgradeState) int
 	// GetUpgradesPending returns count of nodes on which are marked for upgrades and upgrade is pending
 	GetUpgradesPending(ctx context.Context, currentState *ClusterUpgradeState) int
+	// WithPauseWhenOverBudget provides an option to hold back the nodes about to be cordoned while more upgrades
+	// are in progress than maxParallelUpgrades allows
+	WithPauseWhenOverBudget(pause bool) ClusterUpgradeStateManager
+	// WithUpgradeImpactAnnotation provides an option to annotate the nodes in the upgrade-required state with
+	// the expected impact of their upgrade
+	WithUpgradeImpactAnnotation(enabled bool) ClusterUpgradeStateManager
+	// WithEventAggregation provides an option to drop the events identical to an event recorded on the same object
+	// less than interval ago
+	WithEventAggregation(interval time.Duration) ClusterUpgradeStateManager
+	// WithStrictMode provides an option to read back the nodes from the API server after every phase and fail
+	// ApplyState with a PostConditionError if they differ from the state the phase left them in
+	WithStrictMode(enabled bool) ClusterUpgradeStateManager
+	// WithNodeHookPods provides an option to run a pod from the given template on every node before the driver pod
+	// restart and after the restarted driver pod is ready, e.g. to unload kernel modules. Nil templates are not run.
+	WithNodeHookPods(preUpgrade, postUpgrade *corev1.PodTemplateSpec) ClusterUpgradeStateManager
 	// WithPodDeletionEnabled provides an option to enable the optional 'pod-deletion'
 	// state and pass a custom PodDeletionFilter to use
 	WithPodDeletionEnabled(filter PodDeletionFilter) ClusterUpgradeStateManager
 	// WithValidationEnabled provides an option to enable the optional 'validation' state
 	// and pass a podSelector to specify which pods are performing the validation
 	WithValidationEnabled(podSelector string) ClusterUpgradeStateManager
+	// WithValidationPod provides an option to enable the optional 'validation' state and run a validation pod
+	// from the template on every node under validation
+	WithValidationPod(template *corev1.PodTemplateSpec) ClusterUpgradeStateManager
+	// WithFailedPodPolicy provides an option to change how workload pods in the Failed phase are handled
+	// during wait for completion, pod deletion and drain
+	WithFailedPodPolicy(policy FailedPodPolicy) ClusterUpgradeStateManager
+	// WithNodeSortPolicy provides an option to set the order the nodes of the upgrade-required state are started in
+	WithNodeSortPolicy(policy NodeSortPolicy) ClusterUpgradeStateManager
+	// WithMaxNodesPerPass provides an option to limit the count of nodes processed per upgrade state
+	// in a single ApplyState call, the following calls resume after the last processed node
+	WithMaxNodesPerPass(maxNodes int) ClusterUpgradeStateManager
+	// WithMaxDrainWorkers provides an option to limit the count of nodes drained concurrently
+	WithMaxDrainWorkers(workers int) ClusterUpgradeStateManager
+	// WithDriverRestartOrder provides an option to restart the driver pods of the nodes covered by several driver
+	// DaemonSets in the order of the given DaemonSet names, waiting for the readiness of every driver
+	WithDriverRestartOrder(daemonSetNames ...string) ClusterUpgradeStateManager
+	// WithOnDeleteRollout provides an option to delete the outdated driver pods of OnDelete DaemonSets, wait for
+	// their replacement and fail the nodes whose replacement driver pod stays Pending for longer than the timeout
+	WithOnDeleteRollout(pendingTimeout time.Duration) ClusterUpgradeStateManager
+	// WithDriverWorkloads provides an option to upgrade the nodes running the driver pods of workloads which are
+	// not DaemonSets, e.g. static pods, plain pods or a Deployment per node
+	WithDriverWorkloads(workloads ...DriverWorkload) ClusterUpgradeStateManager
+	// WithVersionCompatibilityChecker provides an option to block the upgrade of the nodes whose driver can't be
+	// upgraded from its current version to the target version, moving them to the blocked-by-skew state
+	WithVersionCompatibilityChecker(checker VersionCompatibilityChecker, versionLabel string) ClusterUpgradeStateManager
+	// WithNodeStateUpdateLimits provides an option to change the upgrade state of several nodes in parallel
+	// and to limit the rate of the node writes
+	WithNodeStateUpdateLimits(concurrency int, qps float32, burst int) ClusterUpgradeStateManager
+	// WithNodeUpgradeStateProvider provides an option to replace the NodeUpgradeStateProvider of the manager
+	// and of its managers, e.g. by a CachedNodeUpgradeStateProvider
+	WithNodeUpgradeStateProvider(provider NodeUpgradeStateProvider) ClusterUpgradeStateManager
+	// WithNodeUpgradeStateStorage provides an option to store the upgrade state of the nodes in the given storage,
+	// e.g. an AnnotationStateStorage, instead of the state label
+	WithNodeUpgradeStateStorage(storage NodeUpgradeStateStorage) ClusterUpgradeStateManager
+	// GetNodeUpgradeStateStorage returns the storage of the node upgrade states of the manager, e.g. to read
+	// the upgrade state of a node or to set the storage of a StatusWriter
+	GetNodeUpgradeStateStorage() NodeUpgradeStateStorage
+	// WithKeyPrefix provides an option to write the node labels, annotations and taints of the upgrade
+	// with the given key prefix instead of DefaultKeyPrefix, see UpgradeKeys
+	WithKeyPrefix(prefix string) ClusterUpgradeStateManager
+	// GetUpgradeKeys returns the keys of the node labels, annotations and taints written by the manager,
+	// e.g. to read the upgrade state reason of a node
+	GetUpgradeKeys() UpgradeKeys
+	// WithCustomState provides an option to insert a custom upgrade state processed by the handler of the transition
+	// between two states of the upgrade, e.g. a firmware update between the drain and the driver pod restart
+	WithCustomState(from, to string, transition StateTransition) ClusterUpgradeStateManager
+	// WithNodeUpgradeHistory provides an option to record the last driver upgrades of every node, with their
+	// versions, result and failure reason, in an annotation of the node, see GetNodeUpgradeHistory
+	WithNodeUpgradeHistory(maxRecords int) ClusterUpgradeStateManager
+	// WithNotifier provides an option to send the start of the upgrade sessions, the failed nodes and
+	// the completion of the sessions to notifiers, e.g. a webhook created with NewWebhookNotifier
+	WithNotifier(notifiers ...Notifier) ClusterUpgradeStateManager
+	// WithNodeClaims provides an option to claim the nodes before they are cordoned, so that other operators using
+	// the library on the same nodes skip them until their upgrade is done
+	WithNodeClaims(holderIdentity string, leaseDuration time.Duration) ClusterUpgradeStateManager
+	// WithUpgradeRequiredChecker provides an option to replace the detection of the outdated driver pods,
+	// based on the controller revision hash by default
+	WithUpgradeRequiredChecker(checker UpgradeRequiredChecker) ClusterUpgradeStateManager
+	// WithUncordonGate provides an option to check the nodes before they are uncordoned, moving them to
+	// the upgrade-failed state if the checks don't pass within the timeout
+	WithUncordonGate(timeout time.Duration, checks ...UncordonCheck) ClusterUpgradeStateManager
+	// WithValidators provides an option to enable the optional 'validation' state and validate the upgraded
+	// nodes with the validators, e.g. Jobs running on the node, see NewJobValidator
+	WithValidators(validators ...Validator) ClusterUpgradeStateManager
+	// WithNodeReboot provides an option to reboot the upgraded nodes after the drain, before the driver pod restart
+	WithNodeReboot(rebooter NodeRebooter, rebootRequired RebootRequiredFunc,
+		timeout time.Duration) ClusterUpgradeStateManager
+	// WithNodeMaintenance provides an option to delegate the cordon and the drain of the upgraded nodes to
+	// a maintenance operator with NodeMaintenance resources
+	WithNodeMaintenance(config *NodeMaintenanceConfig) ClusterUpgradeStateManager
+	// WithDrainLease provides an option to keep a lease of the drain manager on the nodes it drains, so that
+	// a manager started while a drain runs doesn't drain the node again until the lease expires
+	WithDrainLease(holderIdentity string, leaseDuration time.Duration) ClusterUpgradeStateManager
+	// WithDrainCompletionHandler provides an option to be notified as soon as the drain of a node finishes
+	WithDrainCompletionHandler(handler DrainCompletionHandler) ClusterUpgradeStateManager
+	// WithClusterHealthGate provides an option to hold the nodes in the upgrade-required state while the gate
+	// reports the cluster unhealthy, see NewNodeConditionHealthGate
+	WithClusterHealthGate(gate ClusterHealthGate) ClusterUpgradeStateManager
+	// WithProtectedNamespaces provides an option to set namespaces which workload pods are never deleted
+	// or evicted from during pod deletion and drain, regardless of the pod selectors of the upgrade policy
+	WithProtectedNamespaces(namespaces ...string) ClusterUpgradeStateManager
+	// WithManualInterventionPolicy provides an option to change how nodes manually uncordoned
+	// in the middle of the upgrade are handled
+	WithManualInterventionPolicy(policy ManualInterventionPolicy) ClusterUpgradeStateManager
+	// WithOrphanedPodPolicy provides an option to change how the nodes running a driver pod without
+	// a driver DaemonSet are handled
+	WithOrphanedPodPolicy(policy OrphanedPodPolicy) ClusterUpgradeStateManager
+	// WithUpgradeScopeSelector provides an option to limit the driver upgrades to the nodes matching
+	// the label selector, nodes removed from the scope have their upgrade state cleaned up
+	WithUpgradeScopeSelector(selector string) ClusterUpgradeStateManager
+	// WithStatusConfigMap provides an option to persist the upgrade progress in a ConfigMap on every ApplyState pass
+	WithStatusConfigMap(namespace, name string) ClusterUpgradeStateManager
+	// WithNodeWriteAuditSink provides an option to pass the labels and annotations changed by every node write
+	// to the sink
+	WithNodeWriteAuditSink(sink NodeWriteAuditSink) ClusterUpgradeStateManager
+	// WithMetrics provides an option to export the upgrade state metrics with the registerer
+	WithMetrics(registerer prometheus.Registerer) ClusterUpgradeStateManager
+	// WithDriverHealthProbe registers a probe which must pass before a failed node is returned to the uncordon path
+	WithDriverHealthProbe(probe DriverHealthProbe) ClusterUpgradeStateManager
+	// WithStateHook registers a hook called for every node in the upgrade state before it is processed
+	// or after it left the state, which can veto or delay the transition of the node
+	WithStateHook(state string, stage StateHookStage, hook StateHookFunc) ClusterUpgradeStateManager
+	// WithUpgradeSessionHooks provides an option to call hooks when an upgrade session for a new generation
+	// of the driver DaemonSets starts and when all the nodes are upgraded
+	WithUpgradeSessionHooks(onStarted, onCompleted UpgradeSessionHook) ClusterUpgradeStateManager
+	// WithSummarizedEvents provides an option to record a single event per upgrade state on the given object
+	// instead of a Normal event on every node
+	WithSummarizedEvents(object runtime.Object, maxNodeNames int) ClusterUpgradeStateManager
+	// WithBeforePhaseHook registers a hook called before the nodes of every upgrade state are processed
+	WithBeforePhaseHook(hook PhaseHook) ClusterUpgradeStateManager
+	// WithAfterPhaseHook registers a hook called after the nodes of every upgrade state were processed
+	WithAfterPhaseHook(hook PhaseHook) ClusterUpgradeStateManager
+	// ValidateUpgradePolicy checks that the pod deletion and drain settings of the upgrade policy can be honored
+	ValidateUpgradePolicy(policy *v1alpha1.DriverUpgradePolicySpec) error
 	// IsPodDeletionEnabled returns true if 'pod-deletion' state is enabled
 	IsPodDeletionEnabled() bool
 	// IsValidationEnabled returns true if 'validation' state is enabled
 	IsValidationEnabled() bool
+	// CleanupUpgradeState removes all the labels and annotations owned by the upgrade library from the cluster nodes
+	// and optionally uncordons nodes which were left cordoned by an unfinished upgrade
+	CleanupUpgradeState(ctx context.Context, uncordon bool) error
+	// CleanupOrphanedState removes the upgrade labels, annotations and validation resources of the nodes which
+	// no longer run the driver and uncordons the nodes whose upgrade was abandoned, e.g. when the driver DaemonSet
+	// was deleted in the middle of the upgrade
+	CleanupOrphanedState(ctx context.Context, namespace string, driverLabels map[string]string,
+		upgradePolicy *v1alpha1.DriverUpgradePolicySpec) error
+	// AbortNodeUpgrades aborts the upgrade of the given nodes: their drains are cancelled, they are uncordoned and
+	// their upgrade state is removed, so that the next pass moves them to the upgrade-done or upgrade-required state
+	AbortNodeUpgrades(ctx context.Context, nodeNames ...string) error
+	// GetUpgradeCapacity returns the count of node upgrades which can be started and the nodes which are upgraded
+	// next, as computed by the last ApplyState pass
+	GetUpgradeCapacity() UpgradeCapacity
+	// GetNodeUpgradeTimeline returns the upgrade state transitions, retries and errors of the node
+	// observed by the manager, or nil if the node was never observed
+	GetNodeUpgradeTimeline(nodeName string) *NodeUpgradeTimeline
 }
 
 // ClusterUpgradeStateManagerImpl serves as a state machine for the ClusterUpgradeState
@@ -114,9 +302,134 @@ type ClusterUpgradeStateManagerImpl struct {
 	ValidationManager        ValidationManager
 	SafeDriverLoadManager    SafeDriverLoadManager
 
+	// stateStorage stores the upgrade state of the nodes, see WithNodeUpgradeStateStorage
+	stateStorage NodeUpgradeStateStorage
+	// podLister, if set, lists the driver pods instead of the client, see CachedNodeUpgradeStateProvider.WithPodLister
+	podLister corev1listers.PodLister
+
 	// optional states
 	podDeletionStateEnabled bool
 	validationStateEnabled  bool
+
+	failedPodPolicy     FailedPodPolicy
+	nodeSortPolicy      NodeSortPolicy
+	protectedNamespaces []string
+
+	manualInterventionPolicy ManualInterventionPolicy
+	orphanedPodPolicy        OrphanedPodPolicy
+
+	pauseWhenOverBudget bool
+
+	upgradeImpactAnnotationEnabled bool
+
+	// maxUpgradeHistoryRecords is the count of upgrades kept in the node upgrade history, see WithNodeUpgradeHistory
+	maxUpgradeHistoryRecords int
+
+	// strictMode verifies the post-conditions of every phase, see WithStrictMode
+	strictMode bool
+
+	nodeHookPods map[NodeHook]*corev1.PodTemplateSpec
+
+	upgradeScopeSelector labels.Selector
+
+	statusConfigMap *types.NamespacedName
+
+	maxNodesPerPass int
+	checkpoints     *applyStateCheckpoints
+
+	adaptiveParallelism *adaptiveParallelism
+
+	beforePhaseHooks []PhaseHook
+	afterPhaseHooks  []PhaseHook
+
+	stateHooks     []stateHook
+	stateHookHolds *stateHookHolds
+
+	// upgradeSessions detects the upgrade sessions enabled with WithUpgradeSessionHooks
+	upgradeSessions *upgradeSessionTracker
+	// notifiers receive the upgrade lifecycle events, see WithNotifier
+	notifiers []Notifier
+	// nodeClaims is the configuration of the node upgrade claims enabled with WithNodeClaims
+	nodeClaims *nodeClaimConfig
+
+	driverHealthProbes []DriverHealthProbe
+
+	// driverRestartOrder are the names of the driver DaemonSets of a node in the order their pods are restarted,
+	// see WithDriverRestartOrder
+	driverRestartOrder []string
+
+	// onDeleteRollout coordinates the rollout of OnDelete driver DaemonSets, see WithOnDeleteRollout
+	onDeleteRollout *onDeleteRollout
+
+	// driverWorkloads are the drivers not deployed by a DaemonSet, see WithDriverWorkloads
+	driverWorkloads []DriverWorkload
+
+	// customStates are the upgrade states inserted in the transition table, see WithCustomState
+	customStates []customState
+
+	// versionSkewPolicy blocks the unsupported driver version upgrades, see WithVersionCompatibilityChecker
+	versionSkewPolicy *versionSkewPolicy
+
+	// upgradeRequiredChecker detects the outdated driver pods instead of the controller revision hash,
+	// see WithUpgradeRequiredChecker
+	upgradeRequiredChecker UpgradeRequiredChecker
+
+	// uncordonGate are the checks run before the nodes are uncordoned, see WithUncordonGate
+	uncordonGate *uncordonGate
+
+	// clusterHealthGate holds the node upgrades while the cluster is unhealthy, see WithClusterHealthGate
+	clusterHealthGate ClusterHealthGate
+
+	// validators validate the nodes in the validation-required state, see WithValidators
+	validators []Validator
+
+	// nodeReboot reboots the upgraded nodes before the driver pod restart, see WithNodeReboot
+	nodeReboot *nodeReboot
+
+	// nodeMaintenance delegates the cordon and the drain of the nodes to a maintenance operator,
+	// see WithNodeMaintenance
+	nodeMaintenance *NodeMaintenanceConfig
+
+	timelines *nodeUpgradeTimelineStore
+
+	// nodeClients creates clients which report the API server warnings as events of the node being processed
+	nodeClients *nodeClientFactory
+
+	// nodeWriteAudit logs the node labels and annotations changed by the library
+	nodeWriteAudit *nodeWriteAuditClient
+
+	// eventSummarizer summarizes the Normal node events per upgrade state if enabled
+	eventSummarizer *nodeEventSummarizer
+	// eventAggregator drops the repeated events if enabled
+	eventAggregator *eventAggregator
+
+	// upgradeCapacity keeps the upgrade capacity computed by the last ApplyState pass
+	upgradeCapacity upgradeCapacityStore
+	// stateMetrics updates the upgrade state metrics enabled with WithMetrics
+	stateMetrics *stateMetrics
+	// applyResultRecorder records the actions scheduled by an ApplyStateWithResult pass
+	applyResultRecorder applyResultRecorder
+	// apiCalls counts the requests sent to the API server by the clients of the manager
+	apiCalls *apiCallCounter
+	// keys returns the keys of the node labels, annotations and taints, see WithKeyPrefix
+	keys UpgradeKeys
+}
+
+// ComponentIdentity identifies the component performing the driver upgrades in the cluster audit logs
+// and in the managedFields of the objects changed by the library
+type ComponentIdentity struct {
+	// Name is the name of the operator, it is used as the field manager of the library writes
+	Name string
+	// Version is the version of the operator
+	Version string
+}
+
+// userAgent returns the user agent for the requests of the component
+func (i ComponentIdentity) userAgent() string {
+	if i.Version == "" {
+		return i.Name
+	}
+	return fmt.Sprintf("%s/%s", i.Name, i.Version)
 }
 
 // NewClusterUpgradeStateManager creates a new instance of ClusterUpgradeStateManagerImpl
@@ -124,30 +437,114 @@ func NewClusterUpgradeStateManager(
 	log logr.Logger,
 	k8sConfig *rest.Config,
 	eventRecorder record.EventRecorder) (ClusterUpgradeStateManager, error) {
+	return NewClusterUpgradeStateManagerWithIdentity(log, k8sConfig, eventRecorder, ComponentIdentity{})
+}
+
+// NewClusterUpgradeStateManagerWithIdentity creates a new instance of ClusterUpgradeStateManagerImpl which sends
+// all the API requests with the user agent of the given identity and uses the identity name as the field manager
+// of its writes. The user agent and the field manager of k8sConfig are kept if the identity name is empty.
+func NewClusterUpgradeStateManagerWithIdentity(
+	log logr.Logger,
+	k8sConfig *rest.Config,
+	eventRecorder record.EventRecorder,
+	identity ComponentIdentity) (ClusterUpgradeStateManager, error) {
+	k8sConfig = rest.CopyConfig(k8sConfig)
+	if identity.Name != "" {
+		k8sConfig.UserAgent = identity.userAgent()
+	}
+	if k8sConfig.WarningHandler == nil {
+		k8sConfig.WarningHandler = apiWarningLogger{log: log}
+	}
+	apiCalls := &apiCallCounter{}
+	k8sConfig.Wrap(apiCalls.wrap)
+
 	k8sClient, err := client.New(k8sConfig, client.Options{Scheme: scheme.Scheme})
 	if err != nil {
 		return nil, fmt.Errorf("error creating k8s client: %v", err)
 	}
+	if identity.Name != "" {
+		k8sClient = client.WithFieldOwner(k8sClient, identity.Name)
+	}
 
 	k8sInterface, err := kubernetes.NewForConfig(k8sConfig)
 	if err != nil {
 		return nil, fmt.Errorf("error creating k8s interface: %v", err)
 	}
 
+	nodeClients, err := newNodeClientFactory(k8sConfig, log, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating k8s interface factory: %v", err)
+	}
+
+	manager := newClusterUpgradeStateManager(log, k8sClient, k8sInterface, eventRecorder, nodeClients)
+	manager.apiCalls = apiCalls
+	return manager, nil
+}
+
+// NewClusterUpgradeStateManagerWithClients creates a new instance of ClusterUpgradeStateManagerImpl which uses
+// the given clients, e.g. fake clients to run the upgrade state machine in tests. As the clients are not created
+// by the manager, the API requests are not counted in the phase stats and the API server warnings are not recorded
+// as node events.
+func NewClusterUpgradeStateManagerWithClients(
+	log logr.Logger,
+	k8sClient client.Client,
+	k8sInterface kubernetes.Interface,
+	eventRecorder record.EventRecorder) ClusterUpgradeStateManager {
+	return newClusterUpgradeStateManager(log, k8sClient, k8sInterface, eventRecorder, nil)
+}
+
+// newClusterUpgradeStateManager creates a ClusterUpgradeStateManagerImpl and its managers with the given clients.
+// nodeClients, if set, creates the clients reporting the API server warnings as node events.
+func newClusterUpgradeStateManager(
+	log logr.Logger,
+	k8sClient client.Client,
+	k8sInterface kubernetes.Interface,
+	eventRecorder record.EventRecorder,
+	nodeClients *nodeClientFactory) *ClusterUpgradeStateManagerImpl {
+	nodeWriteAudit := &nodeWriteAuditClient{Client: k8sClient, log: log}
+	k8sClient = nodeWriteAudit
+
+	// Normal node events are recorded immediately unless WithSummarizedEvents is used,
+	// repeated events are only dropped if WithEventAggregation is used
+	var eventSummarizer *nodeEventSummarizer
+	var eventAggregator *eventAggregator
+	if eventRecorder != nil {
+		eventAggregator = newEventAggregator(eventRecorder)
+		eventSummarizer = newNodeEventSummarizer(eventAggregator)
+		eventRecorder = eventSummarizer
+	}
+	if nodeClients != nil {
+		nodeClients.eventRecorder = eventRecorder
+	}
+
+	upgradeStateMetrics := &stateMetrics{}
 	nodeUpgradeStateProvider := NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
-	manager := &ClusterUpgradeStateManagerImpl{
+	drainManager := NewDrainManager(k8sInterface, nodeUpgradeStateProvider, log, eventRecorder)
+	drainManager.nodeClients = nodeClients
+	drainManager.stateMetrics = upgradeStateMetrics
+	podManager := NewPodManager(k8sInterface, nodeUpgradeStateProvider, log, nil, eventRecorder)
+	podManager.nodeClients = nodeClients
+	timelines := newNodeUpgradeTimelineStore()
+	setProviderTimelines(nodeUpgradeStateProvider, timelines)
+	return &ClusterUpgradeStateManagerImpl{
 		Log:                      log,
 		K8sClient:                k8sClient,
 		K8sInterface:             k8sInterface,
 		EventRecorder:            eventRecorder,
-		DrainManager:             NewDrainManager(k8sInterface, nodeUpgradeStateProvider, log, eventRecorder),
-		PodManager:               NewPodManager(k8sInterface, nodeUpgradeStateProvider, log, nil, eventRecorder),
+		DrainManager:             drainManager,
+		PodManager:               podManager,
 		CordonManager:            NewCordonManager(k8sInterface, log),
 		NodeUpgradeStateProvider: nodeUpgradeStateProvider,
 		ValidationManager:        NewValidationManager(k8sInterface, log, eventRecorder, nodeUpgradeStateProvider, ""),
 		SafeDriverLoadManager:    NewSafeDriverLoadManager(nodeUpgradeStateProvider, log),
+		stateStorage:             LabelStateStorage{},
+		timelines:                timelines,
+		nodeClients:              nodeClients,
+		nodeWriteAudit:           nodeWriteAudit,
+		eventSummarizer:          eventSummarizer,
+		eventAggregator:          eventAggregator,
+		stateMetrics:             upgradeStateMetrics,
 	}
-	return manager, nil
 }
 
 // WithPodDeletionEnabled provides an option to enable the optional 'pod-deletion' state and pass a custom
@@ -157,8 +554,12 @@ func (m *ClusterUpgradeStateManagerImpl) WithPodDeletionEnabled(filter PodDeleti
 		m.Log.V(consts.LogLevelWarning).Info("Cannot enable PodDeletion state as PodDeletionFilter is nil")
 		return m
 	}
-	m.PodManager = NewPodManager(m.K8sInterface, m.NodeUpgradeStateProvider, m.Log, filter, m.EventRecorder)
+	podManager := NewPodManager(m.K8sInterface, m.NodeUpgradeStateProvider, m.Log, filter, m.EventRecorder)
+	podManager.nodeClients = m.nodeClients
+	podManager.podLister = m.podLister
+	m.PodManager = podManager
 	m.podDeletionStateEnabled = true
+	m.propagateKeys()
 	return m
 }
 
@@ -172,6 +573,45 @@ func (m *ClusterUpgradeStateManagerImpl) WithValidationEnabled(podSelector strin
 	m.ValidationManager = NewValidationManager(m.K8sInterface, m.Log, m.EventRecorder, m.NodeUpgradeStateProvider,
 		podSelector)
 	m.validationStateEnabled = true
+	m.propagateKeys()
+	return m
+}
+
+// WithValidationPod provides an option to enable the optional 'validation' state and run a validation pod from
+// the template on every node under validation. The pod is pinned to the node, see PinPodSpecToNode, the node
+// is validated once the pod is Ready. The pod is deleted when the validation succeeds or times out.
+func (m *ClusterUpgradeStateManagerImpl) WithValidationPod(
+	template *corev1.PodTemplateSpec) ClusterUpgradeStateManager {
+	if template == nil {
+		m.Log.V(consts.LogLevelWarning).Info("Cannot enable Validation state as the validation pod template is empty")
+		return m
+	}
+	m.ValidationManager = NewValidationManager(m.K8sInterface, m.Log, m.EventRecorder, m.NodeUpgradeStateProvider,
+		"").WithPodTemplate(template)
+	m.validationStateEnabled = true
+	m.propagateKeys()
+	return m
+}
+
+// WithFailedPodPolicy provides an option to change how workload pods in the Failed phase are handled during
+// wait for completion, pod deletion and drain. Failed pods are ignored by default.
+func (m *ClusterUpgradeStateManagerImpl) WithFailedPodPolicy(policy FailedPodPolicy) ClusterUpgradeStateManager {
+	m.failedPodPolicy = policy
+	return m
+}
+
+// WithNodeSortPolicy provides an option to set the order the nodes of the upgrade-required state are started in,
+// e.g. NewNodeZoneSpreadSort to spread the parallel upgrades over the zones. The nodes are started in the order
+// of the cluster state by default.
+func (m *ClusterUpgradeStateManagerImpl) WithNodeSortPolicy(policy NodeSortPolicy) ClusterUpgradeStateManager {
+	m.nodeSortPolicy = policy
+	return m
+}
+
+// WithProtectedNamespaces provides an option to set namespaces, e.g. kube-system, which workload pods are never
+// deleted or evicted from during pod deletion and drain, regardless of the pod selectors of the upgrade policy
+func (m *ClusterUpgradeStateManagerImpl) WithProtectedNamespaces(namespaces ...string) ClusterUpgradeStateManager {
+	m.protectedNamespaces = namespaces
 	return m
 }
 
@@ -213,11 +653,32 @@ func (m *ClusterUpgradeStateManagerImpl) GetCurrentUnavailableNodes(ctx context.
 // BuildState builds a point-in-time snapshot of the driver upgrade state in the cluster.
 func (m *ClusterUpgradeStateManagerImpl) BuildState(ctx context.Context, namespace string,
 	driverLabels map[string]string) (*ClusterUpgradeState, error) {
+	return m.buildState(ctx, namespace, labels.SelectorFromSet(driverLabels))
+}
+
+// BuildStateForSelector builds a point-in-time snapshot of the driver upgrade state in the cluster, like BuildState,
+// for the driver DaemonSets and pods matching the label selector, e.g. "app in (driver, driver-legacy)".
+func (m *ClusterUpgradeStateManagerImpl) BuildStateForSelector(ctx context.Context, namespace string,
+	driverLabelSelector string) (*ClusterUpgradeState, error) {
+	selector, err := labels.Parse(driverLabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid driver label selector %q: %v", driverLabelSelector, err)
+	}
+	if selector.Empty() {
+		return nil, fmt.Errorf("driver label selector should not be empty")
+	}
+	return m.buildState(ctx, namespace, selector)
+}
+
+// buildState builds a point-in-time snapshot of the driver upgrade state of the driver DaemonSets and pods
+// matching the selector
+func (m *ClusterUpgradeStateManagerImpl) buildState(ctx context.Context, namespace string,
+	selector labels.Selector) (*ClusterUpgradeState, error) {
 	m.Log.V(consts.LogLevelInfo).Info("Building state")
 
 	upgradeState := NewClusterUpgradeState()
 
-	daemonSets, err := m.getDriverDaemonSets(ctx, namespace, driverLabels)
+	daemonSets, err := m.getDriverDaemonSets(ctx, namespace, selector)
 	if err != nil {
 		m.Log.V(consts.LogLevelError).Error(err, "Failed to get driver DaemonSet list")
 		return nil, err
@@ -228,19 +689,23 @@ func (m *ClusterUpgradeStateManagerImpl) BuildState(ctx context.Context, namespa
 	// Get list of driver pods
 	podList := &corev1.PodList{}
 
-	err = m.K8sClient.List(ctx, podList,
-		client.InNamespace(namespace),
-		client.MatchingLabels(driverLabels),
-	)
+	if m.podLister != nil {
+		podList.Items, err = listCachedPods(m.podLister, namespace, selector, "")
+	} else {
+		err = m.K8sClient.List(ctx, podList,
+			client.InNamespace(namespace),
+			client.MatchingLabelsSelector{Selector: selector},
+		)
+	}
 
 	if err != nil {
 		return nil, err
 	}
 
 	filteredPodList := []corev1.Pod{}
-	for _, ds := range daemonSets {
+	for _, ds := range sortDaemonSets(daemonSets) {
 		dsPods := m.getPodsOwnedbyDs(ds, podList.Items)
-		if int(ds.Status.DesiredNumberScheduled) != len(dsPods) {
+		if int(ds.Status.DesiredNumberScheduled) != countPodNodes(dsPods) {
 			m.Log.V(consts.LogLevelInfo).Info("Driver DaemonSet has Unscheduled pods", "name", ds.Name)
 			return nil, fmt.Errorf("driver DaemonSet should not have Unscheduled pods")
 		}
@@ -249,8 +714,18 @@ func (m *ClusterUpgradeStateManagerImpl) BuildState(ctx context.Context, namespa
 
 	// Collect also orphaned driver pods
 	filteredPodList = append(filteredPodList, m.getOrphanedPods(podList.Items)...)
+	deletedDaemonSetPods, err := m.getDeletedDaemonSetPods(ctx, podList.Items, daemonSets)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to get driver pods of deleted DaemonSets")
+		return nil, err
+	}
+	filteredPodList = append(filteredPodList, deletedDaemonSetPods...)
 
-	upgradeStateLabel := GetUpgradeStateLabelKey()
+	// node states of DaemonSet pods by DaemonSet UID and node name, used to detect surge pods
+	dsNodeStates := make(map[string]*NodeUpgradeState)
+	// node states of DaemonSet pods by node name, used to merge the drivers of the nodes covered by several
+	// driver DaemonSets
+	nodeDriverStates := make(map[string]*NodeUpgradeState)
 
 	for i := range filteredPodList {
 		pod := &filteredPodList[i]
@@ -260,49 +735,185 @@ func (m *ClusterUpgradeStateManagerImpl) BuildState(ctx context.Context, namespa
 		} else {
 			ownerDaemonSet = daemonSets[pod.OwnerReferences[0].UID]
 		}
+		nodeName := pod.Spec.NodeName
 		// Check if pod is already scheduled to a Node
-		if pod.Spec.NodeName == "" && pod.Status.Phase == corev1.PodPending {
-			m.Log.V(consts.LogLevelInfo).Info("Driver Pod has no NodeName, skipping", "pod", pod.Name)
-			continue
+		if nodeName == "" && pod.Status.Phase == corev1.PodPending {
+			// the unscheduled replacement pods of an OnDelete rollout are kept on the node they were created for
+			nodeName = m.getUnscheduledDriverPodNode(pod, ownerDaemonSet)
+			if nodeName == "" {
+				m.Log.V(consts.LogLevelInfo).Info("Driver Pod has no NodeName, skipping", "pod", pod.Name)
+				continue
+			}
 		}
-		nodeState, err := m.buildNodeUpgradeState(ctx, pod, ownerDaemonSet)
+		dsNodeKey := ""
+		if ownerDaemonSet != nil {
+			dsNodeKey = fmt.Sprintf("%s/%s", ownerDaemonSet.UID, nodeName)
+			if nodeState, ok := dsNodeStates[dsNodeKey]; ok {
+				m.Log.V(consts.LogLevelInfo).Info("Driver DaemonSet surge is in progress on the node",
+					"node", nodeName, "pods", []string{nodeState.DriverPod.Name, pod.Name})
+				setSurgeDriverPod(nodeState, pod)
+				continue
+			}
+			if nodeState, ok := nodeDriverStates[nodeName]; ok {
+				// the driver of the first DaemonSet by name is the primary driver of the node
+				m.Log.V(consts.LogLevelInfo).Info("Node is covered by several driver DaemonSets",
+					"node", nodeName, "daemonset", ownerDaemonSet.Name, "pod", pod.Name)
+				addAdditionalDriver(nodeState, pod, ownerDaemonSet)
+				continue
+			}
+		}
+		nodeState, err := m.buildNodeUpgradeState(ctx, nodeName, pod, ownerDaemonSet)
 		if err != nil {
 			m.Log.V(consts.LogLevelError).Error(err, "Failed to build node upgrade state for pod", "pod", pod)
 			return nil, err
 		}
-		nodeStateLabel := nodeState.Node.Labels[upgradeStateLabel]
+		if !m.isNodeInUpgradeScope(nodeState.Node) {
+			err = m.compactOutOfScopeNode(ctx, nodeState.Node)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Failed to cleanup upgrade state of the node out of scope",
+					"node", nodeState.Node.Name)
+				return nil, err
+			}
+			continue
+		}
+		if dsNodeKey != "" {
+			dsNodeStates[dsNodeKey] = nodeState
+			nodeDriverStates[nodeState.Node.Name] = nodeState
+		}
+		nodeStateLabel := m.getNodeUpgradeState(nodeState.Node)
 		upgradeState.NodeStates[nodeStateLabel] = append(
 			upgradeState.NodeStates[nodeStateLabel], nodeState)
 	}
 
+	err = m.addDriverWorkloadNodeStates(ctx, &upgradeState)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to build node upgrade states of driver workloads")
+		return nil, err
+	}
+
+	missingNodeStates, err := m.getDaemonSetMissingNodeStates(ctx, &upgradeState, daemonSets)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to get nodes with missing driver DaemonSet")
+		return nil, err
+	}
+	if len(missingNodeStates) > 0 {
+		upgradeState.NodeStates[UpgradeStateDaemonSetMissing] = append(
+			upgradeState.NodeStates[UpgradeStateDaemonSetMissing], missingNodeStates...)
+	}
+
+	m.recordNodeUpgradeTimelines(&upgradeState)
 	return &upgradeState, nil
 }
 
+// setSurgeDriverPod adds a second driver pod of the same DaemonSet to the node state.
+// The older pod is kept as the DriverPod, the newer one is the SurgeDriverPod.
+func setSurgeDriverPod(nodeState *NodeUpgradeState, pod *corev1.Pod) {
+	if pod.CreationTimestamp.Before(&nodeState.DriverPod.CreationTimestamp) {
+		nodeState.SurgeDriverPod = nodeState.DriverPod
+		nodeState.DriverPod = pod
+		return
+	}
+	nodeState.SurgeDriverPod = pod
+}
+
+// countPodNodes returns the count of distinct nodes the pods are scheduled to.
+// Pods not scheduled to a node are counted separately.
+func countPodNodes(pods []corev1.Pod) int {
+	nodes := make(map[string]bool)
+	count := 0
+	for i := range pods {
+		nodeName := pods[i].Spec.NodeName
+		if nodeName == "" {
+			count++
+			continue
+		}
+		if !nodes[nodeName] {
+			nodes[nodeName] = true
+			count++
+		}
+	}
+	return count
+}
+
+// getDaemonSetMissingNodeStates returns the states of the nodes which are in the middle of the upgrade,
+// but have no driver pod and are not targeted by any of the driver DaemonSets anymore.
+// Returned node states have no DriverPod and DriverDaemonSet.
+func (m *ClusterUpgradeStateManagerImpl) getDaemonSetMissingNodeStates(ctx context.Context,
+	upgradeState *ClusterUpgradeState, daemonSets map[types.UID]*appsv1.DaemonSet) ([]*NodeUpgradeState, error) {
+	knownNodes := make(map[string]bool)
+	for _, nodeStates := range upgradeState.NodeStates {
+		for _, nodeState := range nodeStates {
+			knownNodes[nodeState.Node.Name] = true
+		}
+	}
+
+	nodes, err := listStateStorageNodes(ctx, m.K8sClient, m.stateStorage)
+	if err != nil {
+		return nil, fmt.Errorf("error getting node list: %v", err)
+	}
+
+	nodeStates := []*NodeUpgradeState{}
+	for i := range nodes {
+		node := &nodes[i]
+		if knownNodes[node.Name] {
+			continue
+		}
+		if !m.isNodeInUpgradeScope(node) {
+			err = m.compactOutOfScopeNode(ctx, node)
+			if err != nil {
+				return nil, fmt.Errorf("error cleaning up upgrade state of node %s: %v", node.Name, err)
+			}
+			continue
+		}
+		switch m.getNodeUpgradeState(node) {
+		case UpgradeStateUnknown, UpgradeStateDone:
+			continue
+		}
+		if isNodeTargetedByDaemonSets(node, daemonSets) {
+			// driver pod is probably being recreated by the DaemonSet controller
+			continue
+		}
+		m.Log.V(consts.LogLevelInfo).Info("Node is in the middle of the upgrade, but driver DaemonSet is missing",
+			"node", node.Name)
+		nodeStates = append(nodeStates, &NodeUpgradeState{Node: node})
+	}
+	return nodeStates, nil
+}
+
+// isNodeTargetedByDaemonSets returns true if the node matches the node selector of any of the given DaemonSets
+func isNodeTargetedByDaemonSets(node *corev1.Node, daemonSets map[types.UID]*appsv1.DaemonSet) bool {
+	for _, ds := range daemonSets {
+		if labels.SelectorFromSet(ds.Spec.Template.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+			return true
+		}
+	}
+	return false
+}
+
 // buildNodeUpgradeState creates a mapping between a node,
 // the driver POD running on them and the daemon set, controlling this pod
 func (m *ClusterUpgradeStateManagerImpl) buildNodeUpgradeState(
-	ctx context.Context, pod *corev1.Pod, ds *appsv1.DaemonSet) (*NodeUpgradeState, error) {
-	node, err := m.NodeUpgradeStateProvider.GetNode(ctx, pod.Spec.NodeName)
+	ctx context.Context, nodeName string, pod *corev1.Pod, ds *appsv1.DaemonSet) (*NodeUpgradeState, error) {
+	node, err := m.NodeUpgradeStateProvider.GetNode(ctx, nodeName)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get node %s: %v", pod.Spec.NodeName, err)
+		return nil, fmt.Errorf("unable to get node %s: %v", nodeName, err)
 	}
 
-	upgradeStateLabel := GetUpgradeStateLabelKey()
 	m.Log.V(consts.LogLevelInfo).Info("Node hosting a driver pod",
-		"node", node.Name, "state", node.Labels[upgradeStateLabel])
+		"node", node.Name, "state", m.getNodeUpgradeState(node))
 
 	return &NodeUpgradeState{Node: node, DriverPod: pod, DriverDaemonSet: ds}, nil
 }
 
-// getDriverDaemonSets retrieves DaemonSets with given labels and returns UID->DaemonSet map
+// getDriverDaemonSets retrieves DaemonSets matching the selector and returns UID->DaemonSet map
 func (m *ClusterUpgradeStateManagerImpl) getDriverDaemonSets(ctx context.Context, namespace string,
-	labels map[string]string) (map[types.UID]*appsv1.DaemonSet, error) {
+	selector labels.Selector) (map[types.UID]*appsv1.DaemonSet, error) {
 	// Get list of driver pods
 	daemonSetList := &appsv1.DaemonSetList{}
 
 	err := m.K8sClient.List(ctx, daemonSetList,
 		client.InNamespace(namespace),
-		client.MatchingLabels(labels))
+		client.MatchingLabelsSelector{Selector: selector})
 	if err != nil {
 		return nil, fmt.Errorf("error getting DaemonSet list: %v", err)
 	}
@@ -357,13 +968,28 @@ func isOrphanedPod(pod *corev1.Pod) bool {
 // ApplyState receives a complete cluster upgrade state and, based on upgrade policy, processes each node's state.
 // Based on the current state of the node, it is calculated if the node can be moved to the next state right now
 // or whether any actions need to be scheduled for the node to move to the next state.
-// The function is stateless and idempotent. If the error was returned before all nodes' states were processed,
-// ApplyState would be called again and complete the processing - all the decisions are based on the input data.
+// The node upgrade states are read from the input data only, so that ApplyState is idempotent: if an error was
+// returned before all nodes' states were processed, ApplyState is called again and completes the processing.
+// The manager keeps in memory between the passes, and loses when the operator restarts:
+//   - the current limit of the adaptive parallelism of the upgrade policy, see AdaptiveParallelism
+//   - the node upgrade timelines, see GetNodeUpgradeTimeline
+//   - the holders of the claims of the skipped nodes, so that the skip event is recorded once, see WithNodeClaims
+//   - the upgrade sessions, see WithUpgradeSessionHooks
+//   - the last processed node of every state, see WithMaxNodesPerPass
+func (m *ClusterUpgradeStateManagerImpl) ApplyState(ctx context.Context,
+	currentState *ClusterUpgradeState, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) error {
+	_, err := m.ApplyStateWithResult(ctx, currentState, upgradePolicy)
+	return err
+}
+
+// applyState processes the nodes of currentState with upgradePolicy. pool is the name of the node pool
+// the nodes belong to, or empty if currentState contains all the nodes of the cluster. The upgrade status
+// is persisted only for the complete cluster state.
 //
 //nolint:funlen
-func (m *ClusterUpgradeStateManagerImpl) ApplyState(ctx context.Context,
-	currentState *ClusterUpgradeState, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) (err error) {
-	m.Log.V(consts.LogLevelInfo).Info("State Manager, got state update")
+func (m *ClusterUpgradeStateManagerImpl) applyState(ctx context.Context, currentState *ClusterUpgradeState,
+	upgradePolicy *v1alpha1.DriverUpgradePolicySpec, pool string) (err error) {
+	m.Log.V(consts.LogLevelInfo).Info("State Manager, got state update", "pool", pool)
 
 	if currentState == nil {
 		return fmt.Errorf("currentState should not be empty")
@@ -374,6 +1000,19 @@ func (m *ClusterUpgradeStateManagerImpl) ApplyState(ctx context.Context,
 		return nil
 	}
 
+	err = m.ValidateUpgradePolicy(upgradePolicy)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Invalid upgrade policy")
+		return err
+	}
+
+	// The nodes claimed by other operators are left out of the pass and of the upgrade limits
+	currentState, err = m.applyNodeClaims(ctx, currentState)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to update the node upgrade claims")
+		return err
+	}
+
 	m.Log.V(consts.LogLevelInfo).Info("Node states:",
 		"Unknown", len(currentState.NodeStates[UpgradeStateUnknown]),
 		UpgradeStateDone, len(currentState.NodeStates[UpgradeStateDone]),
@@ -383,9 +1022,12 @@ func (m *ClusterUpgradeStateManagerImpl) ApplyState(ctx context.Context,
 		UpgradeStatePodDeletionRequired, len(currentState.NodeStates[UpgradeStatePodDeletionRequired]),
 		UpgradeStateFailed, len(currentState.NodeStates[UpgradeStateFailed]),
 		UpgradeStateDrainRequired, len(currentState.NodeStates[UpgradeStateDrainRequired]),
+		UpgradeStateRebootRequired, len(currentState.NodeStates[UpgradeStateRebootRequired]),
 		UpgradeStatePodRestartRequired, len(currentState.NodeStates[UpgradeStatePodRestartRequired]),
 		UpgradeStateValidationRequired, len(currentState.NodeStates[UpgradeStateValidationRequired]),
-		UpgradeStateUncordonRequired, len(currentState.NodeStates[UpgradeStateUncordonRequired]))
+		UpgradeStateUncordonRequired, len(currentState.NodeStates[UpgradeStateUncordonRequired]),
+		UpgradeStateDaemonSetMissing, len(currentState.NodeStates[UpgradeStateDaemonSetMissing]),
+		UpgradeStateBlockedBySkew, len(currentState.NodeStates[UpgradeStateBlockedBySkew]))
 
 	totalNodes := m.GetTotalManagedNodes(ctx, currentState)
 	upgradesInProgress := m.GetUpgradesInProgress(ctx, currentState)
@@ -400,87 +1042,255 @@ func (m *ClusterUpgradeStateManagerImpl) ApplyState(ctx context.Context,
 		}
 	}
 
-	upgradesAvailable := m.GetUpgradesAvailable(ctx, currentState, upgradePolicy.MaxParallelUpgrades, maxUnavailable)
+	maxParallelUpgrades := m.getMaxParallelUpgrades(ctx, currentState, upgradePolicy)
+	upgradeSlots := m.getUpgradeSlots(ctx, currentState, maxParallelUpgrades, maxUnavailable)
+	upgradesAvailable := upgradeSlots.Upgrades
+	if upgradeSlots.OverBudget > 0 {
+		m.Log.V(consts.LogLevelWarning).Info("More upgrades are in progress than max parallel upgrades allows, "+
+			"no node upgrade is started", "over budget", upgradeSlots.OverBudget,
+			"pause over budget upgrades", m.pauseWhenOverBudget)
+	}
+	// The cluster wide budget also counts the nodes unavailable for reasons unrelated to the upgrade
+	clusterUpgradesAvailable, err := m.getClusterUpgradesAvailable(ctx, upgradePolicy)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to compute the cluster unavailable nodes budget")
+		return err
+	}
+	if upgradesAvailable > clusterUpgradesAvailable {
+		m.Log.V(consts.LogLevelInfo).Info("Upgrade slots are limited by the cluster unavailable nodes budget",
+			"cluster upgrade slots available", clusterUpgradesAvailable)
+		upgradesAvailable = clusterUpgradesAvailable
+	}
+	weightAvailable := m.getUpgradeWeightAvailable(currentState, maxParallelUpgrades)
+	zoneUpgradesAvailable := getZoneUpgradeSlots(currentState, upgradePolicy.MaxParallelUpgradesPerZone)
 
 	m.Log.V(consts.LogLevelInfo).Info("Upgrades in progress",
 		"currently in progress", upgradesInProgress,
-		"max parallel upgrades", upgradePolicy.MaxParallelUpgrades,
+		"max parallel upgrades", maxParallelUpgrades,
 		"upgrade slots available", upgradesAvailable,
+		"over budget", upgradeSlots.OverBudget,
 		"currently unavailable nodes", currentUnavailableNodes,
 		"total number of nodes", totalNodes,
 		"maximum nodes that can be unavailable", maxUnavailable)
 
-	// Determine the object to log this event
-	// m.EventRecorder.Eventf(m.Namespace, v1.EventTypeNormal, GetEventReason(),
-	// "InProgress: %d, MaxParallelUpgrades: %d, UpgradeSlotsAvailable: %s", upgradesInProgress,
-	// upgradePolicy.MaxParallelUpgrades, upgradesAvailable)
+	m.recordUpgradeCapacityEvent(pool, fmt.Sprintf("InProgress: %d, MaxParallelUpgrades: %d, UpgradeSlotsAvailable: %d",
+		upgradesInProgress, maxParallelUpgrades, upgradesAvailable))
 
-	// First, check if unknown or ready nodes need to be upgraded
-	err = m.ProcessDoneOrUnknownNodes(ctx, currentState, UpgradeStateUnknown)
+	activeBlackoutPeriods, err := getActiveBlackoutPeriods(upgradePolicy, time.Now())
 	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(err, "Failed to process nodes", "state", UpgradeStateUnknown)
 		return err
 	}
-	err = m.ProcessDoneOrUnknownNodes(ctx, currentState, UpgradeStateDone)
-	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(err, "Failed to process nodes", "state", UpgradeStateDone)
-		return err
+	clusterHealthErr := m.checkClusterHealth(ctx)
+
+	// On large clusters only a part of the nodes may be processed, the rest is left to the following calls.
+	// The upgrade limits above are computed from the complete state.
+	// The status is persisted after the pass, even if it fails, and covers all the nodes
+	if pool == "" {
+		defer func(fullState *ClusterUpgradeState) {
+			m.stateMetrics.update(fullState, m.stateStorage)
+			m.updateUpgradeSession(ctx, fullState, "")
+			if statusErr := m.updateStatusConfigMap(ctx, fullState); statusErr != nil {
+				m.Log.V(consts.LogLevelError).Error(statusErr, "Failed to update upgrade status ConfigMap")
+			}
+		}(currentState)
 	}
-	// Start upgrade process for upgradesAvailable number of nodes
-	err = m.ProcessUpgradeRequiredNodes(ctx, currentState, upgradesAvailable)
-	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(
-			err, "Failed to process nodes", "state", UpgradeStateUpgradeRequired)
-		return err
+	defer func(fullState *ClusterUpgradeState) {
+		capacity := m.computeUpgradeCapacity(ctx, fullState, maxParallelUpgrades, maxUnavailable)
+		if slots, err := m.getClusterUpgradesAvailable(ctx, upgradePolicy); err == nil {
+			capacity.SlotsAvailable = min(capacity.SlotsAvailable, slots)
+		}
+		// no node upgrade is started during a blackout period
+		if len(activeBlackoutPeriods) > 0 {
+			capacity.SlotsAvailable = 0
+			capacity.ActiveBlackoutPeriods = activeBlackoutPeriods
+		}
+		// no node upgrade is started while the cluster is unhealthy
+		if clusterHealthErr != nil {
+			capacity.SlotsAvailable = 0
+			capacity.ClusterUnhealthy = clusterHealthErr.Error()
+		}
+		if upgradePolicy.Paused {
+			capacity.SlotsAvailable = 0
+			capacity.Paused = true
+		}
+		m.upgradeCapacity.set(pool, capacity, pool == "")
+		m.applyResultRecorder.setOverBudget(capacity.OverBudget)
+	}(currentState)
+	fullState := currentState
+	if m.maxUpgradeHistoryRecords > 0 {
+		defer m.recordNodeUpgradeHistories(ctx, fullState)
 	}
+	currentState, nextCheckpoints := m.getApplyStateWindow(currentState, pool)
 
-	err = m.ProcessCordonRequiredNodes(ctx, currentState)
-	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(err, "Failed to cordon nodes")
-		return err
-	}
+	// API errors of the phases are handled by class, the errors are reported in the result of the pass
+	passErrors := &phaseErrors{}
+	defer func() { m.applyResultRecorder.recordPhaseErrors(passErrors.errors) }()
 
-	err = m.ProcessWaitForJobsRequiredNodes(ctx, currentState, upgradePolicy.WaitForCompletion)
-	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(err, "Failed to waiting for required jobs to complete")
-		return err
+	// The status is still reported while paused, the drains in progress complete on their own
+	if upgradePolicy.Paused {
+		m.Log.V(consts.LogLevelInfo).Info("Driver upgrade is paused by the upgrade policy, skipping",
+			"upgrades in progress", upgradesInProgress)
+		return nil
 	}
 
-	drainEnabled := upgradePolicy.DrainSpec != nil && upgradePolicy.DrainSpec.Enable
-	err = m.ProcessPodDeletionRequiredNodes(ctx, currentState, upgradePolicy.PodDeletion, drainEnabled)
+	// Detect nodes uncordoned by an admin in the middle of the upgrade before processing them
+	err = m.ProcessManualInterventions(ctx, currentState)
 	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(err, "Failed to delete pods")
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to process manually uncordoned nodes")
 		return err
 	}
 
-	// Schedule nodes for drain
-	err = m.ProcessDrainNodes(ctx, currentState, upgradePolicy.DrainSpec)
+	// Fail the nodes stuck in an upgrade state before processing them
+	currentState, err = m.processNodeStateTimeouts(ctx, currentState, upgradePolicy.NodeStateTimeoutSeconds)
 	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(err, "Failed to schedule nodes drain")
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to process upgrade state timeouts")
 		return err
 	}
-	err = m.ProcessPodRestartNodes(ctx, currentState)
-	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(err, "Failed to schedule pods restart")
-		return err
-	}
-	err = m.ProcessUpgradeFailedNodes(ctx, currentState)
+
+	// Start upgrade process for upgradesAvailable number of nodes, if in a maintenance window,
+	// not in a blackout period and the cluster is healthy
+	inMaintenanceWindow, err := isInMaintenanceWindow(upgradePolicy, time.Now())
 	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(err, "Failed to process nodes in 'upgrade-failed' state")
 		return err
 	}
-	err = m.ProcessValidationRequiredNodes(ctx, currentState)
-	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(err, "Failed to validate driver upgrade")
-		return err
+	// The nodes of every state of the transition table are processed by its handler in the order of the table,
+	// see WithCustomState
+	handlers := map[string]StateHandler{
+		// First, check if unknown or ready nodes need to be upgraded
+		UpgradeStateUnknown: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			return m.ProcessDoneOrUnknownNodes(ctx, currentState, UpgradeStateUnknown)
+		},
+		UpgradeStateDone: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			if err := m.clearUpgradeDoneAnnotations(ctx, currentState); err != nil {
+				return err
+			}
+			return m.ProcessDoneOrUnknownNodes(ctx, currentState, UpgradeStateDone)
+		},
+		UpgradeStateDaemonSetMissing: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			return m.ProcessDaemonSetMissingNodes(ctx, currentState)
+		},
+		UpgradeStateBlockedBySkew: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			return m.processBlockedBySkewNodes(ctx, currentState)
+		},
+		UpgradeStateUpgradeRequired: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			if m.upgradeImpactAnnotationEnabled {
+				m.annotateUpgradeImpact(ctx, currentState, upgradePolicy)
+			}
+			compatibleState, err := m.blockVersionSkewNodes(ctx, currentState)
+			if err != nil {
+				return err
+			}
+			approvedState, err := m.processDowngrades(ctx, compatibleState, upgradePolicy.Downgrade)
+			if err != nil {
+				return err
+			}
+			if len(activeBlackoutPeriods) > 0 {
+				return m.waitForBlackoutPeriod(ctx, approvedState, activeBlackoutPeriods)
+			}
+			if !inMaintenanceWindow {
+				return m.waitForMaintenanceWindow(ctx, approvedState)
+			}
+			if clusterHealthErr != nil {
+				return m.waitForClusterHealth(ctx, approvedState, clusterHealthErr)
+			}
+			canaryState, err := m.processCanaryNodes(ctx, fullState, approvedState, upgradePolicy.Canary)
+			if err != nil {
+				return err
+			}
+			healthyState, err := m.skipUnhealthyNodes(ctx, canaryState, upgradePolicy.NodeHealthSelection)
+			if err != nil {
+				return err
+			}
+			sortedState, err := m.sortUpgradeRequiredNodes(ctx, healthyState)
+			if err != nil {
+				return err
+			}
+			selectedState, err := m.selectByPDBs(ctx, sortedState, upgradePolicy)
+			if err != nil {
+				return err
+			}
+			return m.processUpgradeRequiredNodes(ctx, selectedState, upgradesAvailable, weightAvailable,
+				zoneUpgradesAvailable, maxParallelUpgrades)
+		},
+		UpgradeStateCordonRequired: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			if m.pauseWhenOverBudget && upgradeSlots.OverBudget > 0 {
+				withinBudgetState, err := m.holdOverBudgetNodes(ctx, currentState, upgradeSlots.OverBudget)
+				if err != nil {
+					return err
+				}
+				return m.processCordonRequiredNodes(ctx, withinBudgetState, upgradePolicy)
+			}
+			return m.processCordonRequiredNodes(ctx, currentState, upgradePolicy)
+		},
+		UpgradeStateWaitForJobsRequired: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			return m.ProcessWaitForJobsRequiredNodes(ctx, currentState, upgradePolicy.WaitForCompletion)
+		},
+		UpgradeStatePodDeletionRequired: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			return m.ProcessPodDeletionRequiredNodes(ctx, currentState, getPodDeletionSpec(upgradePolicy),
+				isDrainEnabled(upgradePolicy))
+		},
+		// Schedule nodes for drain
+		UpgradeStateDrainRequired: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			drainState, err := m.skipDowngradeDrain(ctx, currentState, upgradePolicy.Downgrade)
+			if err != nil {
+				return err
+			}
+			drainState, err = m.holdSingleReplicaDrains(ctx, drainState, upgradePolicy.DrainSpec)
+			if err != nil {
+				return err
+			}
+			return m.ProcessDrainNodes(ctx, drainState, upgradePolicy.DrainSpec)
+		},
+		UpgradeStateRebootRequired: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			return m.ProcessRebootRequiredNodes(ctx, currentState)
+		},
+		UpgradeStatePodRestartRequired: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			return m.processPodRestartNodes(ctx, currentState, upgradePolicy.NodeReadyTimeoutSecond,
+				upgradePolicy.Validation)
+		},
+		UpgradeStateFailed: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			if err := m.ProcessUpgradeFailedNodes(ctx, currentState); err != nil {
+				return err
+			}
+			if err := m.processFailedNodesCordon(ctx, currentState, upgradePolicy.UncordonFailedNodes); err != nil {
+				return err
+			}
+			return m.processFailedNodesRetry(ctx, currentState, upgradePolicy.Retry)
+		},
+		UpgradeStateValidationRequired: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			return m.processValidationRequiredNodes(ctx, currentState, upgradePolicy.Validation)
+		},
+		UpgradeStateUncordonRequired: func(ctx context.Context, currentState *ClusterUpgradeState,
+			_ NodeUpgradeStateProvider) error {
+			return m.ProcessUncordonRequiredNodes(ctx, currentState)
+		},
 	}
-	err = m.ProcessUncordonRequiredNodes(ctx, currentState)
-	if err != nil {
-		m.Log.V(consts.LogLevelError).Error(err, "Failed to uncordon nodes")
-		return err
+	for _, transition := range m.getStateTransitions(handlers) {
+		err = m.runPhase(ctx, currentState, passErrors, transition.State, func() error {
+			return transition.Handler(ctx, currentState, m.NodeUpgradeStateProvider)
+		})
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to process nodes", "state", transition.State)
+			return err
+		}
 	}
+	m.commitApplyStateCheckpoints(nextCheckpoints, pool)
 	m.Log.V(consts.LogLevelInfo).Info("State Manager, finished processing")
-	return nil
+	// transient errors are returned once the pass is complete, so that the controller requeues it with backoff
+	return passErrors.retryError()
 }
 
 // ProcessDoneOrUnknownNodes iterates over UpgradeStateDone or UpgradeStateUnknown nodes and determines
@@ -489,12 +1299,19 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessDoneOrUnknownNodes(
 	ctx context.Context, currentClusterState *ClusterUpgradeState, nodeStateName string) error {
 	m.Log.V(consts.LogLevelInfo).Info("ProcessDoneOrUnknownNodes")
 
+	upgradeRequiredNodes := make([]*corev1.Node, 0, len(currentClusterState.NodeStates[nodeStateName]))
+	doneNodes := make([]*corev1.Node, 0, len(currentClusterState.NodeStates[nodeStateName]))
 	for _, nodeState := range currentClusterState.NodeStates[nodeStateName] {
 		isPodSynced, isOrphaned, err := m.podInSyncWithDS(ctx, nodeState)
 		if err != nil {
 			m.Log.V(consts.LogLevelError).Error(err, "Failed to get daemonset template/pod revision hash")
 			return err
 		}
+		outdatedDrivers, err := m.getOutdatedAdditionalDrivers(ctx, nodeState)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to get daemonset template/pod revision hash")
+			return err
+		}
 		isUpgradeRequested := m.isUpgradeRequested(nodeState.Node)
 		isWaitingForSafeDriverLoad, err := m.SafeDriverLoadManager.IsWaitingForSafeDriverLoad(ctx, nodeState.Node)
 		if err != nil {
@@ -506,11 +1323,21 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessDoneOrUnknownNodes(
 			m.Log.V(consts.LogLevelInfo).Info("Node is waiting for safe driver load, initialize upgrade",
 				"node", nodeState.Node.Name)
 		}
-		if (!isPodSynced && !isOrphaned) || isWaitingForSafeDriverLoad || isUpgradeRequested {
+		if isOrphaned && !isUpgradeRequested && m.orphanedPodPolicy == OrphanedPodPolicyFail {
+			err = m.failOrphanedPodNode(ctx, nodeState)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// orphaned pods are deleted on request only, unless the orphaned pod policy deletes them
+		isOrphanedPodDeleted := isOrphaned && m.orphanedPodPolicy == OrphanedPodPolicyDelete
+		if (!isPodSynced && (!isOrphaned || isOrphanedPodDeleted)) || len(outdatedDrivers) > 0 ||
+			isWaitingForSafeDriverLoad || isUpgradeRequested {
 			// If node requires upgrade and is Unschedulable, track this in an
 			// annotation and leave node in Unschedulable state when upgrade completes.
 			if isNodeUnschedulable(nodeState.Node) {
-				annotationKey := GetUpgradeInitialStateAnnotationKey()
+				annotationKey := m.keys.GetUpgradeInitialStateAnnotationKey()
 				annotationValue := trueString
 				m.Log.V(consts.LogLevelInfo).Info(
 					"Node is unschedulable, adding annotation to track initial state of the node",
@@ -521,35 +1348,96 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessDoneOrUnknownNodes(
 					return err
 				}
 			}
-			err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, nodeState.Node, UpgradeStateUpgradeRequired)
+			upgradeRequiredNodes = append(upgradeRequiredNodes, nodeState.Node)
+			continue
+		}
+
+		if nodeStateName == UpgradeStateUnknown {
+			doneNodes = append(doneNodes, nodeState.Node)
+			continue
+		}
+		m.Log.V(consts.LogLevelDebug).Info("Node in UpgradeDone state, upgrade not required",
+			"node", nodeState.Node.Name)
+	}
+
+	err := m.changeNodesUpgradeState(ctx, upgradeRequiredNodes, UpgradeStateUpgradeRequired)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "state", UpgradeStateUpgradeRequired)
+		return err
+	}
+	for _, node := range upgradeRequiredNodes {
+		m.Log.V(consts.LogLevelInfo).Info("Node requires upgrade, changed its state to UpgradeRequired",
+			"node", node.Name)
+	}
+	err = m.changeNodesUpgradeState(ctx, doneNodes, UpgradeStateDone)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "state", UpgradeStateDone)
+		return err
+	}
+	for _, node := range doneNodes {
+		m.Log.V(consts.LogLevelInfo).Info("Changed node state to UpgradeDone", "node", node.Name)
+	}
+	return nil
+}
+
+// ProcessDaemonSetMissingNodes processes UpgradeStateDaemonSetMissing nodes.
+// Nodes which lost their driver DaemonSet in the middle of the upgrade are moved to UpgradeStateDaemonSetMissing state
+// and uncordoned, unless they were unschedulable at the beginning of the upgrade.
+// Nodes which got a driver pod again are moved to UpgradeStateUnknown state to be re-evaluated.
+func (m *ClusterUpgradeStateManagerImpl) ProcessDaemonSetMissingNodes(
+	ctx context.Context, currentClusterState *ClusterUpgradeState) error {
+	m.Log.V(consts.LogLevelInfo).Info("ProcessDaemonSetMissingNodes")
+
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateDaemonSetMissing] {
+		node := nodeState.Node
+		if nodeState.DriverPod != nil {
+			m.Log.V(consts.LogLevelInfo).Info("Driver pod is back on the node, re-evaluating upgrade state",
+				"node", node.Name)
+			err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateUnknown)
 			if err != nil {
 				m.Log.V(consts.LogLevelError).Error(
-					err, "Failed to change node upgrade state", "state", UpgradeStateUpgradeRequired)
+					err, "Failed to change node upgrade state", "state", UpgradeStateUnknown)
 				return err
 			}
-			m.Log.V(consts.LogLevelInfo).Info("Node requires upgrade, changed its state to UpgradeRequired",
-				"node", nodeState.Node.Name)
+			continue
+		}
+		if m.getNodeUpgradeState(node) == UpgradeStateDaemonSetMissing {
 			continue
 		}
 
-		if nodeStateName == UpgradeStateUnknown {
-			err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, nodeState.Node, UpgradeStateDone)
+		m.Log.V(consts.LogLevelWarning).Info("Managed driver DaemonSet disappeared, aborting node upgrade",
+			"node", node.Name, "state", m.getNodeUpgradeState(node))
+		logEvent(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+			"Managed driver DaemonSet disappeared, aborting driver upgrade on the node")
+
+		annotationKey := m.keys.GetUpgradeInitialStateAnnotationKey()
+		if _, ok := node.Annotations[annotationKey]; ok {
+			err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, nullString)
 			if err != nil {
-				m.Log.V(consts.LogLevelError).Error(
-					err, "Failed to change node upgrade state", "state", UpgradeStateDone)
 				return err
 			}
-			m.Log.V(consts.LogLevelInfo).Info("Changed node state to UpgradeDone",
-				"node", nodeState.Node.Name)
-			continue
+		} else if isNodeUnschedulable(node) {
+			err := m.CordonManager.Uncordon(ctx, node)
+			if err != nil {
+				m.Log.V(consts.LogLevelWarning).Error(err, "Node uncordon failed", "node", node.Name)
+				return err
+			}
+		}
+
+		err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateDaemonSetMissing)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(
+				err, "Failed to change node upgrade state", "state", UpgradeStateDaemonSetMissing)
+			return err
 		}
-		m.Log.V(consts.LogLevelDebug).Info("Node in UpgradeDone state, upgrade not required",
-			"node", nodeState.Node.Name)
 	}
 	return nil
 }
 
 // podInSyncWithDS check if pod is in sync with DaemonSet, handling also Orphaned Pod
+// The pods of a DriverWorkload are in sync if the workload doesn't require their upgrade.
 // Returns:
 //
 //	bool: True if Pod is in sync with DaemonSet. (For Orphanded Pods, always false)
@@ -557,92 +1445,249 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessDoneOrUnknownNodes(
 //	error: In case of error retrivieng the Revision Hashes
 func (m *ClusterUpgradeStateManagerImpl) podInSyncWithDS(ctx context.Context,
 	nodeState *NodeUpgradeState) (bool, bool, error) {
+	if nodeState.DriverWorkload != nil {
+		upgradeRequired, err := nodeState.DriverWorkload.IsUpgradeRequired(ctx, nodeState.DriverPod)
+		return !upgradeRequired, false, err
+	}
 	if nodeState.IsOrphanedPod() {
 		return false, true, nil
 	}
-	podRevisionHash, err := m.PodManager.GetPodControllerRevisionHash(ctx, nodeState.DriverPod)
+	synced, err := m.driverPodInSync(ctx, nodeState.DriverPod, nodeState.DriverDaemonSet)
+	return synced, false, err
+}
+
+// driverPodInSync returns true if the controller revision hash of the pod is the current one of the DaemonSet,
+// or if the pod is up to date according to the checker set with WithUpgradeRequiredChecker
+func (m *ClusterUpgradeStateManagerImpl) driverPodInSync(ctx context.Context, pod *corev1.Pod,
+	ds *appsv1.DaemonSet) (bool, error) {
+	if m.upgradeRequiredChecker != nil {
+		return m.isPodInSyncByChecker(ctx, pod, ds)
+	}
+	podRevisionHash, err := m.PodManager.GetPodControllerRevisionHash(ctx, pod)
 	if err != nil {
 		m.Log.V(consts.LogLevelError).Error(
-			err, "Failed to get pod template revision hash", "pod", nodeState.DriverPod)
-		return false, false, err
+			err, "Failed to get pod template revision hash", "pod", pod)
+		return false, err
 	}
 	m.Log.V(consts.LogLevelDebug).Info("pod template revision hash", "hash", podRevisionHash)
-	daemonsetRevisionHash, err := m.PodManager.GetDaemonsetControllerRevisionHash(ctx, nodeState.DriverDaemonSet)
+	daemonsetRevisionHash, err := m.PodManager.GetDaemonsetControllerRevisionHash(ctx, ds)
 	if err != nil {
 		m.Log.V(consts.LogLevelError).Error(
-			err, "Failed to get daemonset template revision hash", "daemonset", nodeState.DriverDaemonSet)
-		return false, false, err
+			err, "Failed to get daemonset template revision hash", "daemonset", ds)
+		return false, err
 	}
 	m.Log.V(consts.LogLevelDebug).Info("daemonset template revision hash", "hash", daemonsetRevisionHash)
-	return podRevisionHash == daemonsetRevisionHash, false, nil
+	return podRevisionHash == daemonsetRevisionHash, nil
 }
 
 // isUpgradeRequested returns true if node is labeled to request an upgrade
 func (m *ClusterUpgradeStateManagerImpl) isUpgradeRequested(node *corev1.Node) bool {
-	return node.Annotations[GetUpgradeRequestedAnnotationKey()] == "true"
+	return node.Annotations[m.keys.GetUpgradeRequestedAnnotationKey()] == "true"
 }
 
 // ProcessUpgradeRequiredNodes processes UpgradeStateUpgradeRequired nodes and moves them to UpgradeStateCordonRequired
-// until the limit on max parallel upgrades is reached.
+// until the limit on max parallel upgrades is reached. The nodes are started in the order of the NodeSortPolicy,
+// see WithNodeSortPolicy.
 func (m *ClusterUpgradeStateManagerImpl) ProcessUpgradeRequiredNodes(
 	ctx context.Context, currentClusterState *ClusterUpgradeState, upgradesAvailable int) error {
+	sortedState, err := m.sortUpgradeRequiredNodes(ctx, currentClusterState)
+	if err != nil {
+		return err
+	}
+	return m.processUpgradeRequiredNodes(ctx, sortedState, upgradesAvailable, math.MaxInt, nil, 0)
+}
+
+// processUpgradeRequiredNodes moves UpgradeStateUpgradeRequired nodes to UpgradeStateCordonRequired
+// until upgradesAvailable nodes were moved or weightAvailable is used up by the upgrade weight of the moved nodes.
+// The nodes of a zone are moved until zoneUpgradesAvailable of the zone is used up, nil if the zones are not limited.
+// Node weights are capped by maxParallelUpgrades, 0 maxParallelUpgrades means that the weights are not capped.
+func (m *ClusterUpgradeStateManagerImpl) processUpgradeRequiredNodes(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, upgradesAvailable int, weightAvailable int,
+	zoneUpgradesAvailable map[string]int, maxParallelUpgrades int) error {
 	m.Log.V(consts.LogLevelInfo).Info("ProcessUpgradeRequiredNodes")
-	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateUpgradeRequired] {
+	nodeStates := currentClusterState.NodeStates[UpgradeStateUpgradeRequired]
+	candidates := make([]UpgradeCandidate, 0, len(nodeStates))
+	for _, nodeState := range nodeStates {
+		candidates = append(candidates, UpgradeCandidate{
+			Name:          nodeState.Node.Name,
+			Weight:        m.keys.GetNodeUpgradeWeight(nodeState.Node),
+			Unschedulable: m.isNodeUnschedulable(nodeState.Node),
+			SkipUpgrade:   m.skipNodeUpgrade(nodeState.Node),
+			Zone:          GetNodeZone(nodeState.Node),
+		})
+	}
+	slots := UpgradeSlots{Upgrades: upgradesAvailable, Weight: weightAvailable, ZoneUpgrades: zoneUpgradesAvailable}
+	decisions := SelectNodesForUpgrade(candidates, slots, maxParallelUpgrades)
+
+	cordonNodes := make([]*corev1.Node, 0, len(nodeStates))
+	for i, nodeState := range nodeStates {
 		if m.isUpgradeRequested(nodeState.Node) {
 			// Make sure to remove the upgrade-requested annotation
 			err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, nodeState.Node,
-				GetUpgradeRequestedAnnotationKey(), "null")
+				m.keys.GetUpgradeRequestedAnnotationKey(), "null")
 			if err != nil {
 				m.Log.V(consts.LogLevelError).Error(
 					err, "Failed to delete node upgrade-requested annotation")
 				return err
 			}
 		}
-		if m.skipNodeUpgrade(nodeState.Node) {
+
+		switch decisions[i] {
+		case UpgradeDecisionSkip:
 			m.Log.V(consts.LogLevelInfo).Info("Node is marked for skipping upgrades", "node", nodeState.Node.Name)
 			continue
+		case UpgradeDecisionWaitForSlot:
+			m.Log.V(consts.LogLevelDebug).Info("Node upgrade limit reached, pausing further upgrades",
+				"node", nodeState.Node.Name)
+			err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, nodeState.Node,
+				UpgradeStateReasonWaitingForSlot)
+			if err != nil {
+				// the reason is informational, the other nodes are still processed
+				m.Log.V(consts.LogLevelError).Error(
+					err, "Failed to set node upgrade state reason", "node", nodeState.Node.Name)
+			}
+			continue
+		case UpgradeDecisionWaitForZoneSlot:
+			m.Log.V(consts.LogLevelDebug).Info("Node upgrade limit of the zone reached, pausing further upgrades "+
+				"in the zone", "node", nodeState.Node.Name, "zone", GetNodeZone(nodeState.Node))
+			err := m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, nodeState.Node,
+				UpgradeStateReasonWaitingForZoneSlot)
+			if err != nil {
+				// the reason is informational, the other nodes are still processed
+				m.Log.V(consts.LogLevelError).Error(
+					err, "Failed to set node upgrade state reason", "node", nodeState.Node.Name)
+			}
+			continue
 		}
 
-		if upgradesAvailable <= 0 {
-			// when no new node upgrades are available, progess with manually cordoned nodes
-			if m.isNodeUnschedulable(nodeState.Node) {
-				m.Log.V(consts.LogLevelDebug).Info("Node is already cordoned, progressing for driver upgrade",
-					"node", nodeState.Node.Name)
-			} else {
-				m.Log.V(consts.LogLevelDebug).Info("Node upgrade limit reached, pausing further upgrades",
-					"node", nodeState.Node.Name)
+		cordonNodes = append(cordonNodes, nodeState.Node)
+	}
+
+	err := m.changeNodesUpgradeState(ctx, cordonNodes, UpgradeStateCordonRequired)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "state", UpgradeStateCordonRequired)
+		return err
+	}
+	for _, node := range cordonNodes {
+		m.Log.V(consts.LogLevelInfo).Info("Node waiting for cordon", "node", node.Name)
+	}
+	return nil
+}
+
+// clearUpgradeDoneAnnotations removes the annotations describing the last upgrade of the node, e.g. the retry
+// attempts, from the nodes whose upgrade is done, so that the next upgrade of the node starts afresh
+func (m *ClusterUpgradeStateManagerImpl) clearUpgradeDoneAnnotations(ctx context.Context,
+	currentClusterState *ClusterUpgradeState) error {
+	keys := []string{
+		m.keys.GetUpgradeRetryAttemptsAnnotationKey(),
+		m.keys.GetUpgradeRetryStartTimeAnnotationKey(),
+		m.keys.GetUpgradeDowngradeAnnotationKey(),
+		m.keys.GetUpgradeDowngradeApprovedAnnotationKey(),
+		m.keys.GetUpgradeDrainApprovedAnnotationKey(),
+		m.keys.GetUpgradeDrainStatusAnnotationKey(),
+		m.keys.GetUpgradeDrainLeaseAnnotationKey(),
+		m.keys.GetRebootBootIDAnnotationKey(),
+	}
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateDone] {
+		for _, key := range keys {
+			if _, present := nodeState.Node.Annotations[key]; !present {
 				continue
 			}
+			err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, nodeState.Node, key, nullString)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(err, "Failed to remove node upgrade annotation",
+					"node", nodeState.Node.Name, "annotation", key)
+				return err
+			}
 		}
+	}
+	return nil
+}
 
-		err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, nodeState.Node, UpgradeStateCordonRequired)
-		if err == nil {
-			upgradesAvailable--
-			m.Log.V(consts.LogLevelInfo).Info("Node waiting for cordon",
-				"node", nodeState.Node.Name)
-		} else {
-			m.Log.V(consts.LogLevelError).Error(
-				err, "Failed to change node upgrade state", "state", UpgradeStateCordonRequired)
-			return err
-		}
+// GetNodeUpgradeWeight returns the count of upgrade slots the node consumes when it is upgraded.
+// The weight is taken from the node weight label and defaults to 1 if the label is missing or invalid.
+func (k UpgradeKeys) GetNodeUpgradeWeight(node *corev1.Node) int {
+	weight, err := strconv.Atoi(node.Labels[k.GetUpgradeNodeWeightLabelKey()])
+	if err != nil || weight < 1 {
+		return 1
 	}
+	return weight
+}
 
-	return nil
+// GetNodeUpgradeWeight returns UpgradeKeys.GetNodeUpgradeWeight with the default key prefix
+func GetNodeUpgradeWeight(node *corev1.Node) int {
+	return UpgradeKeys{}.GetNodeUpgradeWeight(node)
+}
+
+// getNodeUpgradeWeight returns the upgrade weight of the node capped by maxParallelUpgrades,
+// so that a node heavier than the whole budget can still be upgraded alone
+func (m *ClusterUpgradeStateManagerImpl) getNodeUpgradeWeight(node *corev1.Node, maxParallelUpgrades int) int {
+	weight := m.keys.GetNodeUpgradeWeight(node)
+	if maxParallelUpgrades > 0 && weight > maxParallelUpgrades {
+		return maxParallelUpgrades
+	}
+	return weight
+}
+
+// getUpgradeWeightAvailable returns the upgrade weight which can be taken by new node upgrades
+// until maxParallelUpgrades is reached. 0 maxParallelUpgrades means that the weight is not limited.
+func (m *ClusterUpgradeStateManagerImpl) getUpgradeWeightAvailable(currentState *ClusterUpgradeState,
+	maxParallelUpgrades int) int {
+	return ComputeUpgradeSlots(UpgradeSlotsInput{
+		UpgradesInProgressWeight: m.getUpgradesInProgressWeight(currentState, maxParallelUpgrades),
+		MaxParallelUpgrades:      maxParallelUpgrades,
+	}).Weight
+}
+
+// getUpgradesInProgressWeight returns the total upgrade weight of the nodes on which upgrade is in progress
+func (m *ClusterUpgradeStateManagerImpl) getUpgradesInProgressWeight(currentState *ClusterUpgradeState,
+	maxParallelUpgrades int) int {
+	weight := 0
+	for state, nodeStates := range currentState.NodeStates {
+		switch state {
+		case UpgradeStateUnknown, UpgradeStateDone, UpgradeStateUpgradeRequired, UpgradeStateDaemonSetMissing,
+			UpgradeStateBlockedBySkew:
+			continue
+		}
+		for _, nodeState := range nodeStates {
+			weight += m.getNodeUpgradeWeight(nodeState.Node, maxParallelUpgrades)
+		}
+	}
+	return weight
 }
 
 // ProcessCordonRequiredNodes processes UpgradeStateCordonRequired nodes,
 // cordons them and moves them to UpgradeStateWaitForJobsRequired state
 func (m *ClusterUpgradeStateManagerImpl) ProcessCordonRequiredNodes(
 	ctx context.Context, currentClusterState *ClusterUpgradeState) error {
+	return m.processCordonRequiredNodes(ctx, currentClusterState, nil)
+}
+
+// processCordonRequiredNodes is ProcessCordonRequiredNodes with the upgrade policy, which the NodeMaintenance
+// resources are created from when the maintenance of the nodes is delegated, see WithNodeMaintenance
+func (m *ClusterUpgradeStateManagerImpl) processCordonRequiredNodes(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, upgradePolicy *v1alpha1.DriverUpgradePolicySpec) error {
 	m.Log.V(consts.LogLevelInfo).Info("ProcessCordonRequiredNodes")
 
 	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateCordonRequired] {
+		if m.nodeMaintenance != nil {
+			if err := m.processNodeMaintenance(ctx, nodeState.Node, upgradePolicy); err != nil {
+				return err
+			}
+			continue
+		}
 		err := m.CordonManager.Cordon(ctx, nodeState.Node)
 		if err != nil {
 			m.Log.V(consts.LogLevelWarning).Error(
 				err, "Node cordon failed", "node", nodeState.Node)
+			logEventf(m.EventRecorder, nodeState.Node, corev1.EventTypeWarning, GetEventReason(),
+				"Failed to cordon the node for the driver upgrade, %s", err.Error())
 			return err
 		}
+		logEvent(m.EventRecorder, nodeState.Node, corev1.EventTypeNormal, GetEventReason(),
+			"Cordoned the node for the driver upgrade")
+		m.applyResultRecorder.record(UpgradeActionCordon, nodeState.Node)
 		err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, nodeState.Node, UpgradeStateWaitForJobsRequired)
 		if err != nil {
 			m.Log.V(consts.LogLevelError).Error(
@@ -684,11 +1729,16 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessWaitForJobsRequiredNodes(
 		return nil
 	}
 
-	podManagerConfig := PodManagerConfig{WaitForCompletionSpec: waitForCompletionSpec, Nodes: nodes}
+	podManagerConfig := PodManagerConfig{
+		WaitForCompletionSpec: waitForCompletionSpec,
+		Nodes:                 nodes,
+		FailedPodPolicy:       m.failedPodPolicy,
+	}
 	err := m.PodManager.ScheduleCheckOnPodCompletion(ctx, &podManagerConfig)
 	if err != nil {
 		return err
 	}
+	m.applyResultRecorder.record(UpgradeActionWaitForJobs, nodes...)
 	return nil
 }
 
@@ -709,9 +1759,12 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessPodDeletionRequiredNodes(
 	}
 
 	podManagerConfig := PodManagerConfig{
-		DeletionSpec: podDeletionSpec,
-		DrainEnabled: drainEnabled,
-		Nodes:        make([]*corev1.Node, 0, len(currentClusterState.NodeStates[UpgradeStatePodDeletionRequired])),
+		DeletionSpec:        podDeletionSpec,
+		DrainEnabled:        drainEnabled,
+		FailedPodPolicy:     m.failedPodPolicy,
+		ProtectedNamespaces: m.protectedNamespaces,
+		Nodes: make([]*corev1.Node, 0,
+			len(currentClusterState.NodeStates[UpgradeStatePodDeletionRequired])),
 	}
 
 	for _, nodeState := range currentClusterState.NodeStates[UpgradeStatePodDeletionRequired] {
@@ -723,7 +1776,12 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessPodDeletionRequiredNodes(
 		return nil
 	}
 
-	return m.PodManager.SchedulePodEviction(ctx, &podManagerConfig)
+	err := m.PodManager.SchedulePodEviction(ctx, &podManagerConfig)
+	if err != nil {
+		return err
+	}
+	m.applyResultRecorder.record(UpgradeActionPodDeletion, podManagerConfig.Nodes...)
+	return nil
 }
 
 // ProcessDrainNodes schedules UpgradeStateDrainRequired nodes for drain.
@@ -747,37 +1805,129 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessDrainNodes(
 	}
 
 	drainConfig := DrainConfiguration{
-		Spec:  drainSpec,
-		Nodes: make([]*corev1.Node, 0, len(currentClusterState.NodeStates[UpgradeStateDrainRequired])),
+		Spec:                drainSpec,
+		FailedPodPolicy:     m.failedPodPolicy,
+		ProtectedNamespaces: m.protectedNamespaces,
+		Nodes:               make([]*corev1.Node, 0, len(currentClusterState.NodeStates[UpgradeStateDrainRequired])),
 	}
 	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateDrainRequired] {
+		if m.keys.isNodeManuallyUncordoned(nodeState.Node) {
+			// the node was manually uncordoned and the change was adopted, draining it would cordon it again
+			m.Log.V(consts.LogLevelInfo).Info("Node was manually uncordoned, skipping drain", "node", nodeState.Node.Name)
+			err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, nodeState.Node,
+				UpgradeStatePodRestartRequired)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(
+					err, "Failed to change node upgrade state", "state", UpgradeStatePodRestartRequired)
+				return err
+			}
+			continue
+		}
 		drainConfig.Nodes = append(drainConfig.Nodes, nodeState.Node)
 	}
 
+	// the drain goroutines of the nodes scheduled in previous passes own the node objects of those passes,
+	// the status is reported on the node objects of the current pass before the drain is scheduled
+	err := m.reportDrainStatus(ctx, drainConfig.Nodes)
+	if err != nil {
+		return err
+	}
+
 	m.Log.V(consts.LogLevelInfo).Info("Scheduling nodes drain", "drainConfig", drainConfig)
 
-	return m.DrainManager.ScheduleNodesDrain(ctx, &drainConfig)
+	err = m.DrainManager.ScheduleNodesDrain(ctx, &drainConfig)
+	if err != nil {
+		return err
+	}
+	m.applyResultRecorder.record(UpgradeActionDrain, drainConfig.Nodes...)
+	return nil
 }
 
 // ProcessPodRestartNodes processes UpgradeStatePodRestartRequirednodes and schedules driver pod restart for them.
 // If the pod has already been restarted and is in Ready state - moves the node to UpgradeStateUncordonRequired state.
 func (m *ClusterUpgradeStateManagerImpl) ProcessPodRestartNodes(
 	ctx context.Context, currentClusterState *ClusterUpgradeState) error {
+	return m.processPodRestartNodes(ctx, currentClusterState, 0, nil)
+}
+
+// processPodRestartNodes is ProcessPodRestartNodes with a timeout for the node to become Ready
+// after the driver pod restart. Zero timeout means infinite. The nodes are moved to the validation-required
+// state if validation is enabled, or if the validation spec of the upgrade policy has a Job template.
+func (m *ClusterUpgradeStateManagerImpl) processPodRestartNodes(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, nodeReadyTimeoutSeconds int,
+	validationSpec *v1alpha1.ValidationSpec) error {
 	m.Log.V(consts.LogLevelInfo).Info("ProcessPodRestartNodes")
 
 	pods := make([]*corev1.Pod, 0, len(currentClusterState.NodeStates[UpgradeStatePodRestartRequired]))
+	restartedPods := make([]*corev1.Pod, 0, len(currentClusterState.NodeStates[UpgradeStatePodRestartRequired]))
+	restartNodes := make([]*corev1.Node, 0, len(currentClusterState.NodeStates[UpgradeStatePodRestartRequired]))
 	for _, nodeState := range currentClusterState.NodeStates[UpgradeStatePodRestartRequired] {
+		if nodeState.IsSurgeInProgress() {
+			// the DaemonSet controller is about to replace the driver pod, wait for the surge handoff
+			m.Log.V(consts.LogLevelInfo).Info("Waiting for driver DaemonSet surge handoff on the node",
+				"node", nodeState.Node.Name, "pod", nodeState.DriverPod.Name, "surgePod", nodeState.SurgeDriverPod.Name)
+			continue
+		}
+		rebootRequired, err := m.isNodeRebootRequired(ctx, nodeState)
+		if err != nil {
+			return err
+		}
+		if rebootRequired {
+			m.Log.V(consts.LogLevelInfo).Info("Node must be rebooted before the driver pod restart",
+				"node", nodeState.Node.Name)
+			err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, nodeState.Node, UpgradeStateRebootRequired)
+			if err != nil {
+				m.Log.V(consts.LogLevelError).Error(
+					err, "Failed to change node upgrade state", "state", UpgradeStateRebootRequired)
+				return err
+			}
+			continue
+		}
 		isPodSynced, isOrphaned, err := m.podInSyncWithDS(ctx, nodeState)
 		if err != nil {
 			m.Log.V(consts.LogLevelError).Error(err, "Failed to get daemonset template/pod revision hash")
 			return err
 		}
+		outdatedDrivers, err := m.getOutdatedAdditionalDrivers(ctx, nodeState)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to get daemonset template/pod revision hash")
+			return err
+		}
 		if !isPodSynced || isOrphaned {
+			primaryDriver := &NodeDriver{Pod: nodeState.DriverPod, DaemonSet: nodeState.DriverDaemonSet,
+				Workload: nodeState.DriverWorkload}
+			outdatedDrivers = append([]*NodeDriver{primaryDriver}, outdatedDrivers...)
+		}
+		if len(outdatedDrivers) > 0 {
 			// Pods should only be scheduled for restart if they are not terminating or restarting already
 			// To determinate terminating state we need to check for deletion timestamp with will be filled
-			// one pod termination process started
-			if nodeState.DriverPod.ObjectMeta.DeletionTimestamp.IsZero() {
-				pods = append(pods, nodeState.DriverPod)
+			// one pod termination process started.
+			// The outdated driver pods of the node are restarted in the same cordon and drain cycle,
+			// in the order of WithDriverRestartOrder.
+			restartDrivers := make([]*NodeDriver, 0, len(outdatedDrivers))
+			for _, driver := range m.getDriversToRestart(nodeState, outdatedDrivers) {
+				if driver.Pod.ObjectMeta.DeletionTimestamp.IsZero() &&
+					m.isDriverPodRestartedByStateManager(driver.DaemonSet) {
+					restartDrivers = append(restartDrivers, driver)
+				}
+			}
+			if len(restartDrivers) > 0 {
+				hookDone, err := m.runNodeHook(ctx, nodeState.Node, NodeHookPreUpgrade)
+				if err != nil {
+					return err
+				}
+				if hookDone {
+					// the driver pods of the workloads are restarted by their workload
+					restartPods, err := m.restartWorkloadDriverPods(ctx, restartDrivers)
+					if err != nil {
+						return err
+					}
+					pods = append(pods, restartPods...)
+					for _, driver := range restartDrivers {
+						restartedPods = append(restartedPods, driver.Pod)
+					}
+					restartNodes = append(restartNodes, nodeState.Node)
+				}
 			}
 		} else {
 			err := m.SafeDriverLoadManager.UnblockLoading(ctx, nodeState.Node)
@@ -793,7 +1943,18 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessPodRestartNodes(
 				return err
 			}
 			if driverPodInSync {
-				if !m.IsValidationEnabled() {
+				err = m.clearNodeReadyWait(ctx, nodeState.Node)
+				if err != nil {
+					return err
+				}
+				hookDone, err := m.runNodeHook(ctx, nodeState.Node, NodeHookPostUpgrade)
+				if err != nil {
+					return err
+				}
+				if !hookDone {
+					continue
+				}
+				if !m.isValidationRequired(validationSpec) {
 					err = m.updateNodeToUncordonOrDoneState(ctx, nodeState.Node)
 					if err != nil {
 						return err
@@ -809,8 +1970,30 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessPodRestartNodes(
 					return err
 				}
 			} else {
-				// driver pod not in sync, move node to failed state if repeated container restarts
-				if !m.isDriverPodFailing(nodeState.DriverPod) {
+				// driver pod not in sync, wait for the node to rejoin the cluster, e.g. after a reboot
+				if !m.isNodeConditionReady(nodeState.Node) {
+					m.Log.V(consts.LogLevelInfo).Info("Waiting for the node to become Ready",
+						"node", nodeState.Node.Name)
+					err = m.handleNodeReadyWait(ctx, nodeState.Node, nodeReadyTimeoutSeconds)
+					if err != nil {
+						return err
+					}
+					continue
+				}
+				err = m.clearNodeReadyWait(ctx, nodeState.Node)
+				if err != nil {
+					return err
+				}
+				// move node to failed state if its replacement driver pod can't start
+				failed, err := m.failStuckPendingDriverPod(ctx, nodeState)
+				if err != nil {
+					return err
+				}
+				if failed {
+					continue
+				}
+				// move node to failed state if repeated container restarts
+				if !m.isAnyDriverPodFailing(nodeState) {
 					continue
 				}
 				m.Log.V(consts.LogLevelInfo).Info("Driver pod is failing on node with repeated restarts",
@@ -827,7 +2010,16 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessPodRestartNodes(
 	}
 
 	// Create pod restart manager to handle pod restarts
-	return m.PodManager.SchedulePodsRestart(ctx, pods)
+	err := m.PodManager.SchedulePodsRestart(ctx, pods)
+	if err != nil {
+		return err
+	}
+	for _, node := range restartNodes {
+		logEvent(m.EventRecorder, node, corev1.EventTypeNormal, GetEventReason(),
+			"Restarting the driver pod of the node")
+	}
+	m.applyResultRecorder.recordPods(UpgradeActionPodRestart, restartedPods)
+	return nil
 }
 
 // ProcessUpgradeFailedNodes processes UpgradeStateFailed nodes and checks whether the driver pod on the node
@@ -843,12 +2035,14 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessUpgradeFailedNodes(
 				err, "Failed to check if driver pod on the node is in sync", "nodeState", nodeState)
 			return err
 		}
-		if driverPodInSync {
+		// a failed node hook has to be run again, the node recovers only with a retry of its upgrade
+		if driverPodInSync && m.isDriverHealthy(ctx, nodeState) &&
+			m.keys.GetNodeUpgradeStateReason(nodeState.Node) != UpgradeStateReasonNodeHookFailed {
 			newUpgradeState := UpgradeStateUncordonRequired
 			// If node was Unschedulable at beginning of upgrade, skip the
 			// uncordon state so that node remains in the same state as
 			// when the upgrade started.
-			annotationKey := GetUpgradeInitialStateAnnotationKey()
+			annotationKey := m.keys.GetUpgradeInitialStateAnnotationKey()
 			if _, ok := nodeState.Node.Annotations[annotationKey]; ok {
 				m.Log.V(consts.LogLevelInfo).Info("Node was Unschedulable at beginning of upgrade, skipping uncordon",
 					"node", nodeState.Node.Name)
@@ -861,6 +2055,14 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessUpgradeFailedNodes(
 					err, "Failed to change node upgrade state", "state", newUpgradeState)
 				return err
 			}
+			failedNodeCordonKey := m.keys.GetUpgradeFailedNodeCordonAnnotationKey()
+			if _, ok := nodeState.Node.Annotations[failedNodeCordonKey]; ok {
+				err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(
+					ctx, nodeState.Node, failedNodeCordonKey, nullString)
+				if err != nil {
+					return err
+				}
+			}
 
 			if newUpgradeState == UpgradeStateDone {
 				m.Log.V(consts.LogLevelDebug).Info("Removing node upgrade annotation",
@@ -879,8 +2081,18 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessUpgradeFailedNodes(
 // ProcessValidationRequiredNodes processes UpgradeStateValidationRequired nodes
 func (m *ClusterUpgradeStateManagerImpl) ProcessValidationRequiredNodes(
 	ctx context.Context, currentClusterState *ClusterUpgradeState) error {
+	return m.processValidationRequiredNodes(ctx, currentClusterState, nil)
+}
+
+// processValidationRequiredNodes is ProcessValidationRequiredNodes with the validation spec of the upgrade policy.
+// The nodes are validated by the validation manager first, then by the validators registered with WithValidators
+// and the validation Job of the spec, if any.
+func (m *ClusterUpgradeStateManagerImpl) processValidationRequiredNodes(ctx context.Context,
+	currentClusterState *ClusterUpgradeState, validationSpec *v1alpha1.ValidationSpec) error {
 	m.Log.V(consts.LogLevelInfo).Info("ProcessValidationRequiredNodes")
 
+	validators := m.getValidators(validationSpec)
+
 	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateValidationRequired] {
 		node := nodeState.Node
 		// make sure that the driver Pod is not waiting for the safe load,
@@ -901,6 +2113,14 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessValidationRequiredNodes(
 			m.Log.V(consts.LogLevelInfo).Info("Validations not complete on the node", "node", node.Name)
 			continue
 		}
+		validationDone, err = m.runValidators(ctx, node, validators, validationSpec)
+		if err != nil {
+			return err
+		}
+		if !validationDone {
+			m.Log.V(consts.LogLevelInfo).Info("Validators not complete on the node", "node", node.Name)
+			continue
+		}
 
 		err = m.updateNodeToUncordonOrDoneState(ctx, node)
 		if err != nil {
@@ -911,18 +2131,35 @@ func (m *ClusterUpgradeStateManagerImpl) ProcessValidationRequiredNodes(
 }
 
 // ProcessUncordonRequiredNodes processes UpgradeStateUncordonRequired nodes,
-// uncordons them and moves them to UpgradeStateDone state
+// uncordons them and moves them to UpgradeStateDone state, once the checks of the uncordon gate pass,
+// see WithUncordonGate
 func (m *ClusterUpgradeStateManagerImpl) ProcessUncordonRequiredNodes(
 	ctx context.Context, currentClusterState *ClusterUpgradeState) error {
 	m.Log.V(consts.LogLevelInfo).Info("ProcessUncordonRequiredNodes")
 
 	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateUncordonRequired] {
-		err := m.CordonManager.Uncordon(ctx, nodeState.Node)
+		uncordonAllowed, err := m.isUncordonAllowed(ctx, nodeState)
+		if err != nil {
+			return err
+		}
+		if !uncordonAllowed {
+			continue
+		}
+		if m.nodeMaintenance != nil {
+			err = m.deleteNodeMaintenance(ctx, nodeState.Node)
+		} else {
+			err = m.CordonManager.Uncordon(ctx, nodeState.Node)
+		}
 		if err != nil {
 			m.Log.V(consts.LogLevelWarning).Error(
 				err, "Node uncordon failed", "node", nodeState.Node)
+			logEventf(m.EventRecorder, nodeState.Node, corev1.EventTypeWarning, GetEventReason(),
+				"Failed to uncordon the node after the driver upgrade, %s", err.Error())
 			return err
 		}
+		logEvent(m.EventRecorder, nodeState.Node, corev1.EventTypeNormal, GetEventReason(),
+			"Uncordoned the node after the driver upgrade")
+		m.applyResultRecorder.record(UpgradeActionUncordon, nodeState.Node)
 		err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, nodeState.Node, UpgradeStateDone)
 		if err != nil {
 			m.Log.V(consts.LogLevelError).Error(
@@ -943,6 +2180,10 @@ func (m *ClusterUpgradeStateManagerImpl) isDriverPodInSync(ctx context.Context,
 	if isOrphaned {
 		return false, nil
 	}
+	if nodeState.IsSurgeInProgress() {
+		// the driver pod is in the middle of the surge handoff
+		return false, nil
+	}
 	// If the pod generation matches the daemonset generation
 	if isPodSynced &&
 		// And the pod is running
@@ -956,8 +2197,8 @@ func (m *ClusterUpgradeStateManagerImpl) isDriverPodInSync(ctx context.Context,
 			}
 		}
 
-		// And each container is ready
-		return true, nil
+		// And each container is ready, as are the additional drivers of the node
+		return m.areAdditionalDriversReady(ctx, nodeState)
 	}
 
 	return false, nil
@@ -984,17 +2225,12 @@ func (m *ClusterUpgradeStateManagerImpl) isNodeUnschedulable(node *corev1.Node)
 
 // isNodeConditionReady returns true if the node condition is ready
 func (m *ClusterUpgradeStateManagerImpl) isNodeConditionReady(node *corev1.Node) bool {
-	for _, condition := range node.Status.Conditions {
-		if condition.Type == corev1.NodeReady && condition.Status != corev1.ConditionTrue {
-			return false
-		}
-	}
-	return true
+	return isNodeReady(node)
 }
 
 // skipNodeUpgrade returns true if node is labeled to skip driver upgrades
 func (m *ClusterUpgradeStateManagerImpl) skipNodeUpgrade(node *corev1.Node) bool {
-	return node.Labels[GetUpgradeSkipNodeLabelKey()] == trueString
+	return node.Labels[m.keys.GetUpgradeSkipNodeLabelKey()] == trueString
 }
 
 // updateNodeToUncordonOrDoneState skips moving the node to the UncordonRequired state if the node
@@ -1002,7 +2238,7 @@ func (m *ClusterUpgradeStateManagerImpl) skipNodeUpgrade(node *corev1.Node) bool
 // when the upgrade started. In addition, the annotation tracking this information is removed.
 func (m *ClusterUpgradeStateManagerImpl) updateNodeToUncordonOrDoneState(ctx context.Context, node *corev1.Node) error {
 	newUpgradeState := UpgradeStateUncordonRequired
-	annotationKey := GetUpgradeInitialStateAnnotationKey()
+	annotationKey := m.keys.GetUpgradeInitialStateAnnotationKey()
 	if _, ok := node.Annotations[annotationKey]; ok {
 		m.Log.V(consts.LogLevelInfo).Info("Node was Unschedulable at beginning of upgrade, skipping uncordon",
 			"node", node.Name)
@@ -1017,6 +2253,12 @@ func (m *ClusterUpgradeStateManagerImpl) updateNodeToUncordonOrDoneState(ctx con
 	}
 
 	if newUpgradeState == UpgradeStateDone {
+		if m.nodeMaintenance != nil {
+			err = m.deleteNodeMaintenance(ctx, node)
+			if err != nil {
+				return err
+			}
+		}
 		m.Log.V(consts.LogLevelDebug).Info("Removing node upgrade annotation",
 			"node", node.Name, "annotation", annotationKey)
 		err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, "null")
@@ -1045,9 +2287,14 @@ func (m *ClusterUpgradeStateManagerImpl) GetTotalManagedNodes(ctx context.Contex
 		len(currentState.NodeStates[UpgradeStatePodDeletionRequired]) +
 		len(currentState.NodeStates[UpgradeStateFailed]) +
 		len(currentState.NodeStates[UpgradeStateDrainRequired]) +
+		len(currentState.NodeStates[UpgradeStateRebootRequired]) +
 		len(currentState.NodeStates[UpgradeStatePodRestartRequired]) +
 		len(currentState.NodeStates[UpgradeStateUncordonRequired]) +
-		len(currentState.NodeStates[UpgradeStateValidationRequired])
+		len(currentState.NodeStates[UpgradeStateValidationRequired]) +
+		len(currentState.NodeStates[UpgradeStateBlockedBySkew])
+	for _, state := range m.getCustomStates() {
+		totalNodes += len(currentState.NodeStates[state])
+	}
 
 	return totalNodes
 }
@@ -1058,7 +2305,8 @@ func (m *ClusterUpgradeStateManagerImpl) GetUpgradesInProgress(ctx context.Conte
 	totalNodes := m.GetTotalManagedNodes(ctx, currentState)
 	return totalNodes - (len(currentState.NodeStates[UpgradeStateUnknown]) +
 		len(currentState.NodeStates[UpgradeStateDone]) +
-		len(currentState.NodeStates[UpgradeStateUpgradeRequired]))
+		len(currentState.NodeStates[UpgradeStateUpgradeRequired]) +
+		len(currentState.NodeStates[UpgradeStateBlockedBySkew]))
 }
 
 // GetUpgradesDone returns count of nodes on which upgrade is complete
@@ -1073,32 +2321,24 @@ func (m *ClusterUpgradeStateManagerImpl) GetUpgradesDone(ctx context.Context,
 // GetUpgradesAvailable returns count of nodes on which upgrade can be done
 func (m *ClusterUpgradeStateManagerImpl) GetUpgradesAvailable(ctx context.Context,
 	currentState *ClusterUpgradeState, maxParallelUpgrades int, maxUnavailable int) int {
-	upgradesInProgress := m.GetUpgradesInProgress(ctx, currentState)
-	totalNodes := m.GetTotalManagedNodes(ctx, currentState)
-
-	var upgradesAvailable int
-	if maxParallelUpgrades == 0 {
-		// Only nodes in UpgradeStateUpgradeRequired can start upgrading, so all of them will move to drain stage
-		upgradesAvailable = len(currentState.NodeStates[UpgradeStateUpgradeRequired])
-	} else {
-		upgradesAvailable = maxParallelUpgrades - upgradesInProgress
-	}
+	return m.getUpgradeSlots(ctx, currentState, maxParallelUpgrades, maxUnavailable).Upgrades
+}
 
-	// Apply the maxUnavailable constraint based on the number of nodes unavailable in the cluster
-	// Get nodes in cordoned/not-ready state and also include nodes that are about to be cordoned.
-	currentUnavailableNodes := m.GetCurrentUnavailableNodes(ctx, currentState) +
+// getUpgradeSlots returns the node upgrades which can be started and the count of upgrades in progress beyond
+// maxParallelUpgrades
+func (m *ClusterUpgradeStateManagerImpl) getUpgradeSlots(ctx context.Context,
+	currentState *ClusterUpgradeState, maxParallelUpgrades int, maxUnavailable int) UpgradeSlots {
+	// nodes in cordoned/not-ready state and the nodes that are about to be cordoned are unavailable
+	unavailableNodes := m.GetCurrentUnavailableNodes(ctx, currentState) +
 		len(currentState.NodeStates[UpgradeStateCordonRequired])
-	// always limit upgradesAvailalbe to maxUnavailable
-	if upgradesAvailable > maxUnavailable {
-		upgradesAvailable = maxUnavailable
-	}
-	// apply additional limits when there are already unavailable nodes
-	if currentUnavailableNodes >= maxUnavailable {
-		upgradesAvailable = 0
-	} else if maxUnavailable < totalNodes && currentUnavailableNodes+upgradesAvailable > maxUnavailable {
-		upgradesAvailable = maxUnavailable - currentUnavailableNodes
-	}
-	return upgradesAvailable
+	return ComputeUpgradeSlots(UpgradeSlotsInput{
+		TotalNodes:          m.GetTotalManagedNodes(ctx, currentState),
+		UpgradesInProgress:  m.GetUpgradesInProgress(ctx, currentState),
+		UpgradesPending:     len(currentState.NodeStates[UpgradeStateUpgradeRequired]),
+		UnavailableNodes:    unavailableNodes,
+		MaxParallelUpgrades: maxParallelUpgrades,
+		MaxUnavailable:      maxUnavailable,
+	})
 }
 
 // GetUpgradesFailed returns count of nodes on which upgrades have failed
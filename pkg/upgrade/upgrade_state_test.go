@@ -18,21 +18,41 @@ package upgrade_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/mock"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
 	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
 	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade/mocks"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade/upgradetest"
 )
 
 var _ = Describe("UpgradeStateManager tests", func() {
@@ -91,6 +111,179 @@ var _ = Describe("UpgradeStateManager tests", func() {
 			Expect(len(upgradeState.NodeStates)).To(Equal(1))
 		})
 
+		It("should read the node upgrade states from the storage of the manager", func() {
+			selector := map[string]string{"foo": "bar"}
+			node := createNode(fmt.Sprintf("node-%s", id))
+			ds := NewDaemonSet(fmt.Sprintf("ds-%s", id), namespace.Name, selector).
+				WithDesiredNumberScheduled(1).
+				WithLabels(selector).
+				Create()
+			_ = NewPod(fmt.Sprintf("pod-%s", id), namespace.Name, node.Name).
+				WithLabels(selector).
+				WithOwnerReference(v1.OwnerReference{
+					APIVersion: "apps/v1",
+					Kind:       "DaemonSet",
+					Name:       ds.Name,
+					UID:        ds.UID,
+				}).
+				Create()
+			annotationManager := upgrade.NewClusterUpgradeStateManagerWithClients(log, k8sClient, k8sInterface, nil).
+				WithNodeUpgradeStateStorage(upgrade.AnnotationStateStorage{})
+			labelManager := upgrade.NewClusterUpgradeStateManagerWithClients(log, k8sClient, k8sInterface, nil)
+
+			// the state is written by the provider of the manager in the storage of the manager
+			provider := annotationManager.(*upgrade.ClusterUpgradeStateManagerImpl).NodeUpgradeStateProvider
+			Expect(provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateUpgradeRequired)).To(Succeed())
+			Expect(node.Annotations).To(HaveKeyWithValue(upgrade.GetUpgradeStateAnnotationKey(),
+				upgrade.UpgradeStateUpgradeRequired))
+			Expect(node.Labels).NotTo(HaveKey(upgrade.GetUpgradeStateLabelKey()))
+
+			upgradeState, err := annotationManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateUpgradeRequired]).To(HaveLen(1))
+			upgradeState, err = labelManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateUnknown]).To(HaveLen(1))
+		})
+
+		It("should find the nodes which lost the driver DaemonSet by the state label after a storage migration",
+			func() {
+				selector := map[string]string{"foo": "bar"}
+				// the node was upgraded with the state label before the storage was changed
+				node := NewNode(fmt.Sprintf("node-%s", id)).WithUpgradeState(upgrade.UpgradeStateDrainRequired).Create()
+				annotationManager := upgrade.NewClusterUpgradeStateManagerWithClients(log, k8sClient, k8sInterface, nil).
+					WithNodeUpgradeStateStorage(upgrade.AnnotationStateStorage{})
+
+				upgradeState, err := annotationManager.BuildState(ctx, namespace.Name, selector)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(upgradeState.NodeStates[upgrade.UpgradeStateDaemonSetMissing]).To(
+					ContainElement(HaveField("Node.Name", node.Name)))
+			})
+
+		It("should keep the unscheduled replacement driver pods on their node with the OnDelete rollout", func() {
+			selector := map[string]string{"foo": "bar"}
+			node := createNode(fmt.Sprintf("node-%s", id))
+			ds := NewDaemonSet(fmt.Sprintf("ds-%s", id), namespace.Name, selector).
+				WithDesiredNumberScheduled(1).
+				WithLabels(selector).
+				Create()
+			pod := NewPod(fmt.Sprintf("pod-%s", id), namespace.Name, "").
+				WithLabels(selector).
+				WithOwnerReference(v1.OwnerReference{
+					APIVersion: "apps/v1",
+					Kind:       "DaemonSet",
+					Name:       ds.Name,
+					UID:        ds.UID,
+				}).
+				Pod
+			pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchFields: []corev1.NodeSelectorRequirement{
+						{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{node.Name}}}}},
+				}}}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			createdObjects = append(createdObjects, pod)
+			pod.Status.Phase = corev1.PodPending
+			Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+
+			upgradeState, err := stateManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates).To(BeEmpty())
+
+			stateManager.WithOnDeleteRollout(10 * time.Minute)
+			upgradeState, err = stateManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateUnknown]).To(HaveLen(1))
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateUnknown][0].Node.Name).To(Equal(node.Name))
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateUnknown][0].DriverPod.Name).To(Equal(pod.Name))
+		})
+
+		It("should build the state of the nodes running the driver pods of the driver workloads", func() {
+			selector := map[string]string{"foo": "bar"}
+			workloadSelector := map[string]string{"app": fmt.Sprintf("driver-%s", id)}
+			node := createNode(fmt.Sprintf("node-%s", id))
+			pod := NewPod(fmt.Sprintf("pod-%s", id), namespace.Name, node.Name).
+				WithLabels(map[string]string{"app": workloadSelector["app"], "driver-version": "1.0"}).
+				Create()
+
+			upgradeState, err := stateManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates).To(BeEmpty())
+
+			workload := upgrade.NewPodDriverWorkload(k8sClient, namespace.Name,
+				labels.SelectorFromSet(workloadSelector), "driver-version", "2.0")
+			stateManager.WithDriverWorkloads(workload)
+			upgradeState, err = stateManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateUnknown]).To(HaveLen(1))
+			nodeState := upgradeState.NodeStates[upgrade.UpgradeStateUnknown][0]
+			Expect(nodeState.Node.Name).To(Equal(node.Name))
+			Expect(nodeState.DriverPod.Name).To(Equal(pod.Name))
+			Expect(nodeState.DriverWorkload).To(Equal(workload))
+			Expect(nodeState.IsOrphanedPod()).To(BeFalse())
+			Expect(workload.IsUpgradeRequired(ctx, nodeState.DriverPod)).To(BeTrue())
+		})
+
+		It("should build the state of the daemonsets matching a label selector", func() {
+			for _, app := range []string{"driver", "other"} {
+				selector := map[string]string{"app": app}
+				node := createNode(fmt.Sprintf("node-%s-%s", app, id))
+				ds := NewDaemonSet(fmt.Sprintf("ds-%s-%s", app, id), namespace.Name, selector).
+					WithDesiredNumberScheduled(1).
+					WithLabels(selector).
+					Create()
+				_ = NewPod(fmt.Sprintf("pod-%s-%s", app, id), namespace.Name, node.Name).
+					WithLabels(selector).
+					WithOwnerReference(v1.OwnerReference{
+						APIVersion: "apps/v1",
+						Kind:       "DaemonSet",
+						Name:       ds.Name,
+						UID:        ds.UID,
+					}).
+					Create()
+			}
+
+			upgradeState, err := stateManager.BuildStateForSelector(ctx, namespace.Name, "app in (driver, driver-legacy)")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateUnknown]).To(HaveLen(1))
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateUnknown][0].DriverDaemonSet.Labels["app"]).To(Equal("driver"))
+
+			_, err = stateManager.BuildStateForSelector(ctx, namespace.Name, "app in (driver")
+			Expect(err).To(HaveOccurred())
+			_, err = stateManager.BuildStateForSelector(ctx, namespace.Name, "")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should merge the drivers of a node covered by several driver daemonsets", func() {
+			selector := map[string]string{"app": "driver"}
+			node := createNode(fmt.Sprintf("node-%s", id))
+			for _, name := range []string{"network-driver", "gpu-driver"} {
+				ds := NewDaemonSet(fmt.Sprintf("%s-%s", name, id), namespace.Name, selector).
+					WithDesiredNumberScheduled(1).
+					WithLabels(selector).
+					Create()
+				_ = NewPod(fmt.Sprintf("%s-pod-%s", name, id), namespace.Name, node.Name).
+					WithLabels(selector).
+					WithOwnerReference(v1.OwnerReference{
+						APIVersion: "apps/v1",
+						Kind:       "DaemonSet",
+						Name:       ds.Name,
+						UID:        ds.UID,
+					}).
+					Create()
+			}
+
+			upgradeState, err := stateManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateUnknown]).To(HaveLen(1))
+			nodeState := upgradeState.NodeStates[upgrade.UpgradeStateUnknown][0]
+			Expect(nodeState.DriverDaemonSet.Name).To(Equal(fmt.Sprintf("gpu-driver-%s", id)))
+			Expect(nodeState.DriverPod.Name).To(Equal(fmt.Sprintf("gpu-driver-pod-%s", id)))
+			Expect(nodeState.AdditionalDrivers).To(HaveLen(1))
+			Expect(nodeState.AdditionalDrivers[0].DaemonSet.Name).To(Equal(fmt.Sprintf("network-driver-%s", id)))
+			Expect(nodeState.AdditionalDrivers[0].Pod.Name).To(Equal(fmt.Sprintf("network-driver-pod-%s", id)))
+		})
+
 		It("should not process daemonset pods which have not been scheduled yet", func() {
 			selector := map[string]string{"foo": "bar"}
 			ds := NewDaemonSet(fmt.Sprintf("ds-%s", id), namespace.Name, selector).
@@ -127,6 +320,155 @@ var _ = Describe("UpgradeStateManager tests", func() {
 			Expect(len(upgradeState.NodeStates)).To(Equal(1))
 			Expect(upgradeState.NodeStates[""][0].IsOrphanedPod()).To(BeTrue())
 		})
+
+		It("should process the pods of a deleted DaemonSet as orphaned pods", func() {
+			selector := map[string]string{"foo": "bar"}
+			node := createNode(fmt.Sprintf("node-%s", id))
+			_ = NewPod(fmt.Sprintf("pod-%s", id), namespace.Name, node.Name).
+				WithLabels(selector).
+				WithOwnerReference(v1.OwnerReference{
+					APIVersion: "apps/v1",
+					Kind:       "DaemonSet",
+					Name:       fmt.Sprintf("deleted-ds-%s", id),
+					UID:        types.UID(fmt.Sprintf("deleted-ds-uid-%s", id)),
+				}).
+				Create()
+
+			upgradeState, err := stateManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates[""]).To(HaveLen(1))
+			Expect(upgradeState.NodeStates[""][0].IsOrphanedPod()).To(BeTrue())
+		})
+
+		It("should pair driver pods of the same DaemonSet on the same node during a surge", func() {
+			selector := map[string]string{"foo": "bar"}
+			node := createNode(fmt.Sprintf("node-%s", id))
+			ds := NewDaemonSet(fmt.Sprintf("ds-%s", id), namespace.Name, selector).
+				WithDesiredNumberScheduled(1).
+				WithLabels(map[string]string{"foo": "bar"}).
+				Create()
+			ownerRef := v1.OwnerReference{
+				APIVersion: "apps/v1",
+				Kind:       "DaemonSet",
+				Name:       ds.Name,
+				UID:        ds.UID,
+			}
+			_ = NewPod(fmt.Sprintf("pod-%s", id), namespace.Name, node.Name).
+				WithLabels(selector).
+				WithOwnerReference(ownerRef).
+				Create()
+			_ = NewPod(fmt.Sprintf("surge-pod-%s", id), namespace.Name, node.Name).
+				WithLabels(selector).
+				WithOwnerReference(ownerRef).
+				Create()
+
+			upgradeState, err := stateManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates[""]).To(HaveLen(1))
+			nodeState := upgradeState.NodeStates[""][0]
+			Expect(nodeState.DriverPod).NotTo(BeNil())
+			Expect(nodeState.IsSurgeInProgress()).To(BeTrue())
+			Expect(nodeState.SurgeDriverPod.Name).NotTo(Equal(nodeState.DriverPod.Name))
+		})
+
+		It("should detect nodes in the middle of the upgrade which lost the driver DaemonSet", func() {
+			selector := map[string]string{"foo": "bar"}
+			node := NewNode(fmt.Sprintf("node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+				Create()
+			_ = NewNode(fmt.Sprintf("done-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateDone).
+				Create()
+
+			upgradeState, err := stateManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(upgradeState.NodeStates)).To(Equal(1))
+			missingNodeStates := upgradeState.NodeStates[upgrade.UpgradeStateDaemonSetMissing]
+			Expect(missingNodeStates).To(HaveLen(1))
+			Expect(missingNodeStates[0].Node.Name).To(Equal(node.Name))
+			Expect(missingNodeStates[0].DriverPod).To(BeNil())
+		})
+
+		It("should cleanup upgrade state of the nodes removed from the upgrade scope and exclude them", func() {
+			selector := map[string]string{"foo": "bar"}
+			stateManager.WithUpgradeScopeSelector("pool=gpu")
+			inScopeNode := NewNode(fmt.Sprintf("node-%s", id)).
+				WithLabels(map[string]string{"pool": "gpu"}).
+				WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+				Create()
+			outOfScopeNode := NewNode(fmt.Sprintf("out-of-scope-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+				WithAnnotations(map[string]string{
+					upgrade.GetUpgradeStateReasonAnnotationKey(): upgrade.UpgradeStateReasonDrainBlockedByPDB,
+				}).
+				Unschedulable(true).
+				Create()
+			missingNode := NewNode(fmt.Sprintf("missing-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).
+				Create()
+			_ = NewPod(fmt.Sprintf("pod-%s", id), namespace.Name, inScopeNode.Name).
+				WithLabels(selector).
+				Create()
+			_ = NewPod(fmt.Sprintf("out-of-scope-pod-%s", id), namespace.Name, outOfScopeNode.Name).
+				WithLabels(selector).
+				Create()
+
+			upgradeState, err := stateManager.BuildState(ctx, namespace.Name, selector)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgradeState.NodeStates).To(HaveLen(1))
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateDrainRequired]).To(HaveLen(1))
+			Expect(upgradeState.NodeStates[upgrade.UpgradeStateDrainRequired][0].Node.Name).To(Equal(inScopeNode.Name))
+
+			for _, name := range []string{outOfScopeNode.Name, missingNode.Name} {
+				node := getNode(name)
+				Expect(node.Labels).NotTo(HaveKey(upgrade.GetUpgradeStateLabelKey()))
+				Expect(node.Annotations).NotTo(HaveKey(upgrade.GetUpgradeStateReasonAnnotationKey()))
+			}
+			cordonManager.AssertCalled(GinkgoT(), "Uncordon", mock.Anything, mock.Anything)
+		})
+
+		It("should cleanup upgrade state of the nodes no longer running the driver", func() {
+			selector := map[string]string{"foo": "bar"}
+			template := &batchv1.JobTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Namespace: namespace.Name},
+				Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{{Name: "smoke-test", Image: "smoke-test:latest"}},
+				}}},
+			}
+			stateManager.WithValidators(upgrade.NewJobValidator(k8sInterface, "smoke-test", template))
+			driverNode := NewNode(fmt.Sprintf("driver-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+				Create()
+			abandonedNode := NewNode(fmt.Sprintf("abandoned-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateValidationRequired).
+				Unschedulable(true).
+				Create()
+			doneNode := NewNode(fmt.Sprintf("done-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateDone).
+				Create()
+			_ = NewPod(fmt.Sprintf("pod-%s", id), namespace.Name, driverNode.Name).
+				WithLabels(selector).
+				Create()
+			job := &batchv1.Job{
+				ObjectMeta: v1.ObjectMeta{Namespace: namespace.Name,
+					Name: fmt.Sprintf("%s-driver-upgrade-smoke-test-%s", upgrade.DriverName, abandonedNode.Name)},
+				Spec: *template.Spec.DeepCopy(),
+			}
+			Expect(k8sClient.Create(ctx, job)).To(Succeed())
+
+			Expect(stateManager.CleanupOrphanedState(ctx, namespace.Name, selector, nil)).To(Succeed())
+			Expect(getNodeUpgradeState(getNode(driverNode.Name))).To(Equal(upgrade.UpgradeStateDrainRequired))
+			for _, name := range []string{abandonedNode.Name, doneNode.Name} {
+				Expect(getNode(name).Labels).NotTo(HaveKey(upgrade.GetUpgradeStateLabelKey()))
+			}
+			cordonManager.AssertCalled(GinkgoT(), "Uncordon", mock.Anything,
+				mock.MatchedBy(func(node *corev1.Node) bool { return node.Name == abandonedNode.Name }))
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, types.NamespacedName{Namespace: job.Namespace, Name: job.Name}, &batchv1.Job{})
+				return apierrors.IsNotFound(err)
+			}).WithTimeout(5 * time.Second).Should(BeTrue())
+		})
 	})
 
 	Describe("ApplyState", func() {
@@ -290,108 +632,763 @@ var _ = Describe("UpgradeStateManager tests", func() {
 			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(2))
 			Expect(stateCount[upgrade.UpgradeStateCordonRequired]).To(Equal(maxParallelUpgrades))
 		})
-		It("UpgradeStateManager should start additional upgrades if maxParallelUpgrades limit is not reached", func() {
-			const maxParallelUpgrades = 4
-
-			upgradeRequiredNodes := []*upgrade.NodeUpgradeState{
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
-			}
-			cordonRequiredNodes := []*upgrade.NodeUpgradeState{
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
-			}
+		It("UpgradeStateManager should start node upgrades only in maintenance windows", func() {
+			node := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
 			clusterState := upgrade.NewClusterUpgradeState()
-			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = upgradeRequiredNodes
-			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = cordonRequiredNodes
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
 
+			// a one minute window starting in two hours
+			windowStart := time.Now().UTC().Add(2 * time.Hour)
 			policy := &v1alpha1.DriverUpgradePolicySpec{
-				AutoUpgrade:         true,
-				MaxParallelUpgrades: maxParallelUpgrades,
-				DrainSpec: &v1alpha1.DrainSpec{
-					Enable: true,
+				AutoUpgrade: true,
+				Schedule: &v1alpha1.UpgradeScheduleSpec{
+					Cron:           fmt.Sprintf("%d %d * * *", windowStart.Minute(), windowStart.Hour()),
+					DurationSecond: 60,
 				},
 			}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(node)).To(Equal(upgrade.UpgradeStateReasonInMaintenanceWindowWait))
 
+			policy.Schedule = &v1alpha1.UpgradeScheduleSpec{Cron: "0 0 * * *", TimeZone: "Mars/Olympus", DurationSecond: 60}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).NotTo(Succeed())
+
+			policy.Schedule = &v1alpha1.UpgradeScheduleSpec{Cron: "*/30 * * * *", DurationSecond: 30 * 60}
 			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
-			stateCount := make(map[string]int)
-			for _, state := range append(upgradeRequiredNodes, cordonRequiredNodes...) {
-				state := getNodeUpgradeState(state.Node)
-				stateCount[state]++
-			}
-			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(1))
-			Expect(stateCount[upgrade.UpgradeStateCordonRequired] +
-				stateCount[upgrade.UpgradeStateWaitForJobsRequired]).To(Equal(4))
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateCordonRequired))
 		})
-		It("UpgradeStateManager should schedule upgrade all nodes if maxParallel upgrades is set to 0 and maxUnavailable is set to 100%", func() {
+		It("UpgradeStateManager should not start node upgrades in blackout periods", func() {
+			node := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
 			clusterState := upgrade.NewClusterUpgradeState()
-			nodeStates := []*upgrade.NodeUpgradeState{
-				{Node: NewNode("node1").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
-				{Node: NewNode("node2").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
-				{Node: NewNode("node3").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
-				{Node: NewNode("node4").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Unschedulable(true).Node},
-				{Node: NewNode("node5").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Unschedulable(true).Node},
-			}
-
-			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = nodeStates
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
 
+			today := time.Now().In(time.FixedZone("UTC+14", 14*60*60))
 			policy := &v1alpha1.DriverUpgradePolicySpec{
 				AutoUpgrade: true,
-				// Unlimited upgrades
-				MaxParallelUpgrades: 0,
-				// Unlimited unavailable
-				MaxUnavailable: &intstr.IntOrString{Type: intstr.String, StrVal: "100%"},
+				BlackoutPeriods: []v1alpha1.BlackoutPeriodSpec{{
+					Name:      "next-year",
+					StartDate: today.AddDate(1, 0, 0).Format("2006-01-02"),
+					EndDate:   today.AddDate(1, 0, 1).Format("2006-01-02"),
+				}, {
+					Name:      "freeze",
+					StartDate: today.AddDate(0, 0, -1).Format("2006-01-02"),
+					EndDate:   today.Format("2006-01-02"),
+					TimeZone:  "Pacific/Kiritimati",
+				}},
 			}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(node)).To(Equal(upgrade.UpgradeStateReasonInBlackoutPeriod))
+			Expect(stateManager.GetUpgradeCapacity().SlotsAvailable).To(Equal(0))
+			Expect(stateManager.GetUpgradeCapacity().ActiveBlackoutPeriods).To(Equal([]string{"freeze"}))
 
+			policy.BlackoutPeriods[1].EndDate = today.AddDate(0, 0, -2).Format("2006-01-02")
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).NotTo(Succeed())
+
+			policy.BlackoutPeriods = policy.BlackoutPeriods[:1]
 			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
-			stateCount := make(map[string]int)
-			for i := range nodeStates {
-				state := getNodeUpgradeState(clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired][i].Node)
-				stateCount[state]++
-			}
-			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(0))
-			Expect(stateCount[upgrade.UpgradeStateCordonRequired]).To(Equal(len(nodeStates)))
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(stateManager.GetUpgradeCapacity().ActiveBlackoutPeriods).To(BeEmpty())
 		})
-		It("UpgradeStateManager should schedule upgrade based on maxUnavailable constraint if maxParallel upgrades is set to 0 and maxUnavailable is set to 50%", func() {
+		It("UpgradeStateManager should block the upgrade of the nodes with an unsupported driver version skew", func() {
+			driverDaemonSet := func(image string) *appsv1.DaemonSet {
+				return &appsv1.DaemonSet{Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "driver", Image: image}}}}}}
+			}
+			driverPod := func(image string) *corev1.Pod {
+				return &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "driver", Image: image}}}}
+			}
+			blockedNode := NewNode(fmt.Sprintf("blocked-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node
+			allowedNode := NewNode(fmt.Sprintf("allowed-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node
+			unblockedNode := NewNode(fmt.Sprintf("unblocked-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateBlockedBySkew).Node
+			stillBlockedNode := NewNode(fmt.Sprintf("still-blocked-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateBlockedBySkew).Node
+
 			clusterState := upgrade.NewClusterUpgradeState()
-			nodeStates := []*upgrade.NodeUpgradeState{
-				{Node: NewNode("node1").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
-				{Node: NewNode("node2").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
-				{Node: NewNode("node3").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
-				{Node: NewNode("node4").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Unschedulable(true).Node},
-				{Node: NewNode("node5").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Unschedulable(true).Node},
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+				{Node: blockedNode, DriverPod: driverPod("nvcr.io/driver:1.2.0"),
+					DriverDaemonSet: driverDaemonSet("nvcr.io/driver:3.0.0")},
+				{Node: allowedNode, DriverPod: driverPod("nvcr.io/driver:2.5.0"),
+					DriverDaemonSet: driverDaemonSet("nvcr.io/driver:3.0.0")},
 			}
+			clusterState.NodeStates[upgrade.UpgradeStateBlockedBySkew] = []*upgrade.NodeUpgradeState{
+				{Node: unblockedNode, DriverPod: driverPod("nvcr.io/driver:1.2.0"),
+					DriverDaemonSet: driverDaemonSet("nvcr.io/driver:2.0.0")},
+				{Node: stillBlockedNode, DriverPod: driverPod("nvcr.io/driver:1.2.0"),
+					DriverDaemonSet: driverDaemonSet("nvcr.io/driver:3.0.0")},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+			recorder := record.NewFakeRecorder(10)
+			stateManager.EventRecorder = recorder
 
-			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = nodeStates
+			stateManager.WithVersionCompatibilityChecker(upgrade.NewMajorVersionSkewChecker(1), "")
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(blockedNode)).To(Equal(upgrade.UpgradeStateBlockedBySkew))
+			Expect(getNodeUpgradeState(allowedNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(unblockedNode)).To(Equal(upgrade.UpgradeStateUnknown))
+			Expect(getNodeUpgradeState(stillBlockedNode)).To(Equal(upgrade.UpgradeStateBlockedBySkew))
+			Expect(recorder.Events).To(Receive(SatisfyAll(
+				ContainSubstring("from driver version 1.2.0 to 3.0.0 is not supported"),
+				ContainSubstring("major version skew 2 exceeds the maximum of 1"))))
+			Expect(recorder.Events).To(BeEmpty())
+		})
 
-			policy := &v1alpha1.DriverUpgradePolicySpec{
-				AutoUpgrade: true,
-				// Unlimited upgrades
-				MaxParallelUpgrades: 0,
-				MaxUnavailable:      &intstr.IntOrString{Type: intstr.String, StrVal: "50%"},
-			}
+		It("UpgradeStateManager should process the nodes in a custom state inserted in the transition table", func() {
+			firmwareUpdateRequired := "firmware-update-required"
+			var updatedNodes []string
+			stateManager.WithCustomState(upgrade.UpgradeStateCordonRequired, upgrade.UpgradeStateWaitForJobsRequired,
+				upgrade.StateTransition{
+					State: firmwareUpdateRequired,
+					Handler: func(ctx context.Context, currentState *upgrade.ClusterUpgradeState,
+						provider upgrade.NodeUpgradeStateProvider) error {
+						for _, nodeState := range currentState.NodeStates[firmwareUpdateRequired] {
+							updatedNodes = append(updatedNodes, nodeState.Node.Name)
+							err := provider.ChangeNodeUpgradeState(ctx, nodeState.Node,
+								upgrade.UpgradeStateWaitForJobsRequired)
+							if err != nil {
+								return err
+							}
+						}
+						return nil
+					},
+					NextStates: []string{upgrade.UpgradeStateWaitForJobsRequired},
+				})
+			node := nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
 
+			// the node leaving the cordon-required state for the wait-for-jobs-required state enters the custom state
 			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
-			stateCount := make(map[string]int)
-			for i := range nodeStates {
-				state := getNodeUpgradeState(clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired][i].Node)
-				stateCount[state]++
+			Expect(getNodeUpgradeState(node)).To(Equal(firmwareUpdateRequired))
+			Expect(updatedNodes).To(BeEmpty())
+
+			clusterState = upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[firmwareUpdateRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			Expect(stateManager.GetUpgradesInProgress(ctx, &clusterState)).To(Equal(1))
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+			Expect(updatedNodes).To(Equal([]string{node.Name}))
+		})
+
+		It("UpgradeStateManager should fail when a custom state moves a node to a state not allowed", func() {
+			firmwareUpdateRequired := "firmware-update-required"
+			stateManager.WithCustomState(upgrade.UpgradeStateCordonRequired, upgrade.UpgradeStateWaitForJobsRequired,
+				upgrade.StateTransition{
+					State: firmwareUpdateRequired,
+					Handler: func(ctx context.Context, currentState *upgrade.ClusterUpgradeState,
+						provider upgrade.NodeUpgradeStateProvider) error {
+						for _, nodeState := range currentState.NodeStates[firmwareUpdateRequired] {
+							err := provider.ChangeNodeUpgradeState(ctx, nodeState.Node, upgrade.UpgradeStateDone)
+							if err != nil {
+								return err
+							}
+						}
+						return nil
+					},
+					NextStates: []string{upgrade.UpgradeStateWaitForJobsRequired},
+				})
+			node := nodeWithUpgradeState(firmwareUpdateRequired)
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[firmwareUpdateRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+
+			err := stateManager.ApplyState(ctx, &clusterState, policy)
+			Expect(err).To(MatchError(ContainSubstring("to a state other than " +
+				upgrade.UpgradeStateWaitForJobsRequired)))
+		})
+
+		It("UpgradeStateManager should fail when a built-in state moves a node to a state not allowed", func() {
+			// the drain-required state moves the node to the upgrade-done state instead of the pod-restart-required state
+			stateManager.NodeUpgradeStateProvider = &redirectingStateProvider{
+				NodeUpgradeStateProvider: stateManager.NodeUpgradeStateProvider,
+				from:                     upgrade.UpgradeStatePodRestartRequired,
+				to:                       upgrade.UpgradeStateDone,
 			}
-			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(2))
-			Expect(stateCount[upgrade.UpgradeStateCordonRequired]).To(Equal(3))
+			node := nodeWithUpgradeState(upgrade.UpgradeStateDrainRequired)
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateDrainRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+
+			err := stateManager.ApplyState(ctx, &clusterState, policy)
+			Expect(err).To(MatchError(ContainSubstring("nodes moved out of the " + upgrade.UpgradeStateDrainRequired +
+				" state to a state other than " + upgrade.UpgradeStatePodRestartRequired)))
 		})
-		It("UpgradeStateManager should schedule upgrade based on 50% maxUnavailable, with some unavailable nodes already upgraded", func() {
+
+		It("UpgradeStateManager should not start node upgrades while the cluster is unhealthy", func() {
+			node := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
 			clusterState := upgrade.NewClusterUpgradeState()
-			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
-			upToDatePod := &corev1.Pod{
-				Status:     corev1.PodStatus{Phase: "Running"},
-				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+
+			nodeList := &corev1.NodeList{}
+			Expect(k8sClient.List(ctx, nodeList)).To(Succeed())
+			notReadyNodes := 0
+			for i := range nodeList.Items {
+				for _, condition := range nodeList.Items[i].Status.Conditions {
+					if condition.Type == corev1.NodeReady && condition.Status != corev1.ConditionTrue {
+						notReadyNodes++
+					}
+				}
+			}
+			namespace := createNamespace(fmt.Sprintf("critical-%s", id))
+			stateManager.WithClusterHealthGate(upgrade.NewNodeConditionHealthGate(k8sClient, notReadyNodes,
+				namespace.Name))
 
-			upgradeRequiredNodes := []*upgrade.NodeUpgradeState{
-				{Node: NewNode("node1").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
-				{Node: NewNode("node2").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
-				{Node: NewNode("node3").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+			pendingPod := NewPod(fmt.Sprintf("pending-pod-%s", id), namespace.Name, "").Create()
+			pendingPod.Status.Phase = corev1.PodPending
+			pendingPod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodScheduled,
+				Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable}}
+			Expect(k8sClient.Status().Update(ctx, pendingPod)).To(Succeed())
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(node)).To(Equal(upgrade.UpgradeStateReasonClusterUnhealthy))
+			Expect(stateManager.GetUpgradeCapacity().SlotsAvailable).To(Equal(0))
+			Expect(stateManager.GetUpgradeCapacity().ClusterUnhealthy).To(ContainSubstring(pendingPod.Name))
+
+			pendingPod.Status.Conditions[0].Status = corev1.ConditionTrue
+			Expect(k8sClient.Status().Update(ctx, pendingPod)).To(Succeed())
+			notReadyNode := createNode(fmt.Sprintf("not-ready-node-%s", id))
+			notReadyNode.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}}
+			Expect(k8sClient.Status().Update(ctx, notReadyNode)).To(Succeed())
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(stateManager.GetUpgradeCapacity().ClusterUnhealthy).To(ContainSubstring("not Ready"))
+
+			notReadyNode.Status.Conditions[0].Status = corev1.ConditionTrue
+			Expect(k8sClient.Status().Update(ctx, notReadyNode)).To(Succeed())
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(stateManager.GetUpgradeCapacity().ClusterUnhealthy).To(BeEmpty())
+		})
+		It("UpgradeStateManager should not change the node upgrade states while paused", func() {
+			upgradeRequiredNode := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+			upgradeRequiredNode.Name = fmt.Sprintf("paused-required-node-%s", id)
+			cordonRequiredNode := nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)
+			cordonRequiredNode.Name = fmt.Sprintf("paused-cordon-node-%s", id)
+			newClusterState := func() *upgrade.ClusterUpgradeState {
+				clusterState := upgrade.NewClusterUpgradeState()
+				clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+					{Node: upgradeRequiredNode}}
+				clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = []*upgrade.NodeUpgradeState{
+					{Node: cordonRequiredNode}}
+				return &clusterState
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 0,
+				Paused:              true,
+			}
+			result, err := stateManager.ApplyStateWithResult(ctx, newClusterState(), policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Paused).To(BeTrue())
+			Expect(result.Transitions).To(BeEmpty())
+			Expect(result.RequeueAfter).To(BeZero())
+			Expect(getNodeUpgradeState(upgradeRequiredNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(getNodeUpgradeState(cordonRequiredNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(stateManager.GetUpgradeCapacity().Paused).To(BeTrue())
+			Expect(stateManager.GetUpgradeCapacity().SlotsAvailable).To(Equal(0))
+
+			policy.Paused = false
+			result, err = stateManager.ApplyStateWithResult(ctx, newClusterState(), policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Paused).To(BeFalse())
+			Expect(getNodeUpgradeState(upgradeRequiredNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(cordonRequiredNode)).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+			Expect(stateManager.GetUpgradeCapacity().Paused).To(BeFalse())
+		})
+		It("UpgradeStateManager should verify the node states after every phase in strict mode", func() {
+			node := NewNode(fmt.Sprintf("strict-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).
+				Create()
+			newClusterState := func() *upgrade.ClusterUpgradeState {
+				clusterState := upgrade.NewClusterUpgradeState()
+				clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+					{Node: getNode(node.Name)}}
+				return &clusterState
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 0,
+			}
+			stateManager.WithStrictMode(true)
+
+			// the mocked provider changes the node states in memory only
+			err := stateManager.ApplyState(ctx, newClusterState(), policy)
+			var postConditionErr *upgrade.PostConditionError
+			Expect(errors.As(err, &postConditionErr)).To(BeTrue())
+			Expect(postConditionErr.Phase).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(postConditionErr.Nodes).To(HaveLen(1))
+			Expect(postConditionErr.Nodes[0].NodeName).To(Equal(node.Name))
+			Expect(err).To(MatchError(ContainSubstring(
+				`state: "cordon-required" -> "upgrade-required"`)))
+
+			stateManager.NodeUpgradeStateProvider = upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			Expect(stateManager.ApplyState(ctx, newClusterState(), policy)).To(Succeed())
+			Expect(getNodeUpgradeState(getNode(node.Name))).To(Equal(upgrade.UpgradeStateCordonRequired))
+		})
+		It("UpgradeStateManager should apply the upgrade policy of every node pool", func() {
+			gpuNodeStates := []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+			}
+			for _, nodeState := range gpuNodeStates {
+				nodeState.Node.Labels["pool"] = "gpu"
+			}
+			otherNodeStates := []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+			}
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = append(gpuNodeStates, otherNodeStates...)
+
+			defaultPolicy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 2,
+			}
+			pools := []upgrade.NodePoolUpgradePolicy{{
+				Name:         "gpu",
+				NodeSelector: "pool=gpu",
+				Policy: &v1alpha1.DriverUpgradePolicySpec{
+					AutoUpgrade:         true,
+					MaxParallelUpgrades: 1,
+				},
+			}}
+
+			Expect(stateManager.ApplyStateForNodePools(ctx, &clusterState, defaultPolicy, pools)).To(Succeed())
+			countStates := func(nodeStates []*upgrade.NodeUpgradeState) map[string]int {
+				stateCount := make(map[string]int)
+				for _, nodeState := range nodeStates {
+					stateCount[getNodeUpgradeState(nodeState.Node)]++
+				}
+				return stateCount
+			}
+			Expect(countStates(gpuNodeStates)).To(Equal(map[string]int{
+				upgrade.UpgradeStateUpgradeRequired: 2,
+				upgrade.UpgradeStateCordonRequired:  1,
+			}))
+			Expect(countStates(otherNodeStates)).To(Equal(map[string]int{
+				upgrade.UpgradeStateCordonRequired: 2,
+			}))
+
+			pools = append(pools, upgrade.NodePoolUpgradePolicy{Name: "gpu"})
+			Expect(stateManager.ApplyStateForNodePools(ctx, &clusterState, defaultPolicy, pools)).NotTo(Succeed())
+		})
+		It("UpgradeStateManager should upgrade the nodes of every driver DaemonSet concurrently", func() {
+			var started []upgrade.UpgradeSession
+			stateManager.WithUpgradeSessionHooks(
+				func(_ context.Context, session upgrade.UpgradeSession) { started = append(started, session) }, nil)
+			clusterState := upgrade.NewClusterUpgradeState()
+			nodeStatesByDaemonSet := make(map[string][]*upgrade.NodeUpgradeState)
+			for _, dsName := range []string{"driver-a", "driver-b"} {
+				daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{Namespace: "ns", Name: dsName, Generation: 1}}
+				for i := 0; i < 2; i++ {
+					node := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+					node.Name = fmt.Sprintf("%s-node-%d", dsName, i)
+					nodeState := &upgrade.NodeUpgradeState{Node: node, DriverDaemonSet: daemonSet}
+					nodeStatesByDaemonSet[dsName] = append(nodeStatesByDaemonSet[dsName], nodeState)
+					clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = append(
+						clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired], nodeState)
+				}
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true, MaxParallelUpgrades: 1}
+
+			Expect(stateManager.ApplyStateForDaemonSets(ctx, &clusterState, policy)).To(Succeed())
+			for _, dsName := range []string{"driver-a", "driver-b"} {
+				cordonRequired := 0
+				for _, nodeState := range nodeStatesByDaemonSet[dsName] {
+					if getNodeUpgradeState(nodeState.Node) == upgrade.UpgradeStateCordonRequired {
+						cordonRequired++
+					}
+				}
+				Expect(cordonRequired).To(Equal(1), "daemonset %s", dsName)
+			}
+			Expect(started).To(HaveLen(2))
+			sessions := stateManager.GetUpgradeSessions()
+			Expect(sessions).To(HaveLen(2))
+			Expect(sessions[0].Scope).To(Equal("ns/driver-a"))
+			Expect(sessions[0].Nodes).To(ConsistOf("driver-a-node-0", "driver-a-node-1"))
+			Expect(sessions[1].Scope).To(Equal("ns/driver-b"))
+
+			// a node can't be upgraded by two driver DaemonSets
+			sharedNodeState := &upgrade.NodeUpgradeState{
+				Node:            nodeStatesByDaemonSet["driver-a"][0].Node,
+				DriverDaemonSet: nodeStatesByDaemonSet["driver-b"][0].DriverDaemonSet,
+			}
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = append(
+				clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired], sharedNodeState)
+			Expect(stateManager.ApplyStateForDaemonSets(ctx, &clusterState, policy)).To(
+				MatchError(ContainSubstring("driver-a-node-0 is covered by driver daemonsets")))
+		})
+		It("UpgradeStateManager should write the node keys with the key prefix of the manager", func() {
+			prefixedManager := upgrade.NewClusterUpgradeStateManagerWithClients(log, k8sClient, k8sInterface, nil).
+				WithKeyPrefix("example.com")
+			keys := prefixedManager.GetUpgradeKeys()
+			Expect(keys.GetUpgradeStateLabelKey()).To(Equal("example.com/gpu-driver-upgrade-state"))
+			Expect(keys.GetUpgradeSkipNodeLabelKey()).To(Equal("example.com/gpu-driver-upgrade.skip"))
+			// the keys of the other managers are not changed
+			Expect(upgrade.GetUpgradeStateLabelKey()).To(Equal("nvidia.com/gpu-driver-upgrade-state"))
+
+			node := createNode(fmt.Sprintf("prefixed-node-%s", randSeq(5)))
+			provider := prefixedManager.(*upgrade.ClusterUpgradeStateManagerImpl).NodeUpgradeStateProvider
+			Expect(provider.ChangeNodeUpgradeState(ctx, node, upgrade.UpgradeStateUpgradeRequired)).To(Succeed())
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+
+			Expect(prefixedManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(node.Labels).To(HaveKeyWithValue("example.com/gpu-driver-upgrade-state",
+				upgrade.UpgradeStateCordonRequired))
+			Expect(node.Labels).NotTo(HaveKey(upgrade.GetUpgradeStateLabelKey()))
+			Expect(prefixedManager.GetNodeUpgradeStateStorage().GetState(node)).To(
+				Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(upgrade.LabelStateStorage{}.GetState(node)).To(BeEmpty())
+		})
+		It("UpgradeStateManager should skip the nodes claimed by another operator", func() {
+			stateManager.WithNodeClaims("gpu-operator", time.Minute)
+			recorder := record.NewFakeRecorder(10)
+			stateManager.EventRecorder = recorder
+			otherClaim := func(renewTime time.Time) string {
+				data, err := json.Marshal(upgrade.NodeUpgradeClaim{NodeLease: upgrade.NodeLease{
+					HolderIdentity: "network-operator", RenewTime: v1.NewTime(renewTime), LeaseDurationSeconds: 60}})
+				Expect(err).NotTo(HaveOccurred())
+				return string(data)
+			}
+			node := NewNode(fmt.Sprintf("node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateCordonRequired).Create()
+			claimedNode := NewNode(fmt.Sprintf("claimed-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateCordonRequired).
+				WithAnnotations(map[string]string{upgrade.NodeUpgradeClaimAnnotationKey: otherClaim(time.Now())}).
+				Create()
+			pendingNode := NewNode(fmt.Sprintf("pending-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Create()
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+			pass := func(nodes ...*corev1.Node) {
+				clusterState := upgrade.NewClusterUpgradeState()
+				for _, n := range nodes {
+					state := getNodeUpgradeState(n)
+					clusterState.NodeStates[state] = append(clusterState.NodeStates[state],
+						&upgrade.NodeUpgradeState{Node: n, DriverPod: &corev1.Pod{}})
+				}
+				Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			}
+
+			pass(node, claimedNode, pendingNode)
+			claim, ok := upgrade.GetNodeUpgradeClaim(node)
+			Expect(ok).To(BeTrue())
+			Expect(claim.HolderIdentity).To(Equal("gpu-operator"))
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+			Expect(getNodeUpgradeState(claimedNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			cordonManager.AssertNotCalled(GinkgoT(), "Cordon", mock.Anything, claimedNode)
+			Expect(pendingNode.Annotations).NotTo(HaveKey(upgrade.NodeUpgradeClaimAnnotationKey))
+			Expect(recorder.Events).To(Receive(ContainSubstring("claimed by network-operator")))
+			Expect(recorder.Events).To(Receive(ContainSubstring("Cordoned the node")))
+			// the event is recorded once per holder
+			pass(claimedNode)
+			Expect(recorder.Events).To(BeEmpty())
+
+			// the claim is released when the upgrade of the node is done
+			node.Labels[upgrade.GetUpgradeStateLabelKey()] = upgrade.UpgradeStateDone
+			pass(node)
+			Expect(node.Annotations).NotTo(HaveKey(upgrade.NodeUpgradeClaimAnnotationKey))
+
+			// an expired claim is taken over
+			updated := claimedNode.DeepCopy()
+			updated.Annotations[upgrade.NodeUpgradeClaimAnnotationKey] = otherClaim(time.Now().Add(-time.Hour))
+			Expect(k8sClient.Update(ctx, updated)).To(Succeed())
+			updated.Labels[upgrade.GetUpgradeStateLabelKey()] = upgrade.UpgradeStateCordonRequired
+			pass(updated)
+			claim, ok = upgrade.GetNodeUpgradeClaim(updated)
+			Expect(ok).To(BeTrue())
+			Expect(claim.HolderIdentity).To(Equal("gpu-operator"))
+			Expect(getNodeUpgradeState(updated)).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+		})
+		It("UpgradeStateManager should park nodes which lost the driver DaemonSet", func() {
+			cordonedNode := nodeWithUpgradeState(upgrade.UpgradeStateDrainRequired)
+			cordonedNode.Spec.Unschedulable = true
+			parkedNode := nodeWithUpgradeState(upgrade.UpgradeStateDaemonSetMissing)
+			recoveredNode := nodeWithUpgradeState(upgrade.UpgradeStateDaemonSetMissing)
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateDaemonSetMissing] = []*upgrade.NodeUpgradeState{
+				{Node: cordonedNode},
+				{Node: parkedNode},
+				{Node: recoveredNode, DriverPod: &corev1.Pod{}},
+			}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(cordonedNode)).To(Equal(upgrade.UpgradeStateDaemonSetMissing))
+			Expect(getNodeUpgradeState(parkedNode)).To(Equal(upgrade.UpgradeStateDaemonSetMissing))
+			Expect(getNodeUpgradeState(recoveredNode)).To(Equal(upgrade.UpgradeStateUnknown))
+			cordonManager.AssertCalled(GinkgoT(), "Uncordon", mock.Anything, cordonedNode)
+		})
+		It("UpgradeStateManager should limit the total weight of nodes upgraded in parallel", func() {
+			nodeWithWeight := func(weight string) *corev1.Node {
+				node := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+				node.Labels[upgrade.GetUpgradeNodeWeightLabelKey()] = weight
+				return node
+			}
+			heavyNode := nodeWithWeight("3")
+			lightNode := nodeWithWeight("1")
+			waitingNode := nodeWithWeight("1")
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+				{Node: heavyNode}, {Node: lightNode}, {Node: waitingNode},
+			}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 4,
+			}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(heavyNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(lightNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(waitingNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+		})
+		It("UpgradeStateManager should upgrade a node heavier than maxParallelUpgrades when no upgrades are in progress",
+			func() {
+				heavyNode := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+				heavyNode.Labels[upgrade.GetUpgradeNodeWeightLabelKey()] = "8"
+
+				clusterState := upgrade.NewClusterUpgradeState()
+				clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+					{Node: heavyNode},
+				}
+
+				policy := &v1alpha1.DriverUpgradePolicySpec{
+					AutoUpgrade:         true,
+					MaxParallelUpgrades: 2,
+				}
+
+				Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+				Expect(getNodeUpgradeState(heavyNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			})
+		It("UpgradeStateManager should ramp up parallel upgrades after successful batches "+
+			"and ramp down on failures if adaptive parallelism is enabled", func() {
+			nodes := []*corev1.Node{}
+			for i := 0; i < 6; i++ {
+				node := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+				node.Name = fmt.Sprintf("node-%d", i)
+				nodes = append(nodes, node)
+			}
+			applyState := func() map[string]int {
+				clusterState := upgrade.NewClusterUpgradeState()
+				for _, node := range nodes {
+					state := getNodeUpgradeState(node)
+					clusterState.NodeStates[state] = append(clusterState.NodeStates[state],
+						&upgrade.NodeUpgradeState{Node: node})
+				}
+				policy := &v1alpha1.DriverUpgradePolicySpec{
+					AutoUpgrade:         true,
+					MaxParallelUpgrades: 4,
+					AdaptiveParallelism: true,
+				}
+				Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+				stateCount := make(map[string]int)
+				for _, node := range nodes {
+					stateCount[getNodeUpgradeState(node)]++
+				}
+				return stateCount
+			}
+			setState := func(state string, nodes ...*corev1.Node) {
+				for _, node := range nodes {
+					node.Labels[upgrade.GetUpgradeStateLabelKey()] = state
+				}
+			}
+
+			// the upgrade starts on a single node
+			Expect(applyState()[upgrade.UpgradeStateCordonRequired]).To(Equal(1))
+			setState(upgrade.UpgradeStateDrainRequired, nodes[0])
+			Expect(applyState()[upgrade.UpgradeStateCordonRequired]).To(Equal(0))
+
+			// the count of parallel upgrades is doubled when the batch completes
+			setState(upgrade.UpgradeStateDone, nodes[0])
+			Expect(applyState()[upgrade.UpgradeStateCordonRequired]).To(Equal(2))
+
+			// the count of parallel upgrades is halved when an upgrade fails
+			setState(upgrade.UpgradeStateFailed, nodes[1])
+			setState(upgrade.UpgradeStateDrainRequired, nodes[2])
+			Expect(applyState()[upgrade.UpgradeStateCordonRequired]).To(Equal(0))
+			setState(upgrade.UpgradeStateDone, nodes[1], nodes[2])
+			Expect(applyState()[upgrade.UpgradeStateCordonRequired]).To(Equal(1))
+		})
+
+		It("UpgradeStateManager should set WaitingForSlot reason on nodes waiting for an upgrade slot", func() {
+			clusterState := upgrade.NewClusterUpgradeState()
+			nodeStates := []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+			}
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = nodeStates
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 1,
+			}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			for _, nodeState := range nodeStates {
+				if getNodeUpgradeState(nodeState.Node) == upgrade.UpgradeStateUpgradeRequired {
+					Expect(upgrade.GetNodeUpgradeStateReason(nodeState.Node)).
+						To(Equal(upgrade.UpgradeStateReasonWaitingForSlot))
+				} else {
+					Expect(upgrade.GetNodeUpgradeStateReason(nodeState.Node)).To(BeEmpty())
+				}
+			}
+			Expect(upgrade.CountNodesByStateReason(&clusterState)).
+				To(Equal(map[string]int{upgrade.UpgradeStateReasonWaitingForSlot: 2}))
+		})
+		It("UpgradeStateManager should start the upgrades when the WaitingForSlot reason cannot be set", func() {
+			provider := mocks.NodeUpgradeStateProvider{}
+			provider.On("ChangeNodeUpgradeState", mock.Anything, mock.Anything, mock.Anything).
+				Return(func(ctx context.Context, node *corev1.Node, newNodeState string) error {
+					node.Labels[upgrade.GetUpgradeStateLabelKey()] = newNodeState
+					return nil
+				})
+			provider.On("ChangeNodeUpgradeAnnotation", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+				Return(func(ctx context.Context, node *corev1.Node, key string, value string) error {
+					if key == upgrade.GetUpgradeStateReasonAnnotationKey() {
+						return errors.New("reason write failed")
+					}
+					node.Annotations[key] = value
+					return nil
+				})
+			stateManager.NodeUpgradeStateProvider = &provider
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			nodeStates := []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+			}
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = nodeStates
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 1,
+			}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			cordonRequired := 0
+			for _, nodeState := range nodeStates {
+				if getNodeUpgradeState(nodeState.Node) == upgrade.UpgradeStateCordonRequired {
+					cordonRequired++
+				}
+			}
+			Expect(cordonRequired).To(Equal(1))
+		})
+		It("UpgradeStateManager should start additional upgrades if maxParallelUpgrades limit is not reached", func() {
+			const maxParallelUpgrades = 4
+
+			upgradeRequiredNodes := []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+			}
+			cordonRequiredNodes := []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+			}
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = upgradeRequiredNodes
+			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = cordonRequiredNodes
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: maxParallelUpgrades,
+				DrainSpec: &v1alpha1.DrainSpec{
+					Enable: true,
+				},
+			}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			stateCount := make(map[string]int)
+			for _, state := range append(upgradeRequiredNodes, cordonRequiredNodes...) {
+				state := getNodeUpgradeState(state.Node)
+				stateCount[state]++
+			}
+			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(1))
+			Expect(stateCount[upgrade.UpgradeStateCordonRequired] +
+				stateCount[upgrade.UpgradeStateWaitForJobsRequired]).To(Equal(4))
+		})
+		It("UpgradeStateManager should schedule upgrade all nodes if maxParallel upgrades is set to 0 and maxUnavailable is set to 100%", func() {
+			clusterState := upgrade.NewClusterUpgradeState()
+			nodeStates := []*upgrade.NodeUpgradeState{
+				{Node: NewNode("node1").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+				{Node: NewNode("node2").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+				{Node: NewNode("node3").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+				{Node: NewNode("node4").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Unschedulable(true).Node},
+				{Node: NewNode("node5").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Unschedulable(true).Node},
+			}
+
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = nodeStates
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+				// Unlimited upgrades
+				MaxParallelUpgrades: 0,
+				// Unlimited unavailable
+				MaxUnavailable: &intstr.IntOrString{Type: intstr.String, StrVal: "100%"},
+			}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			stateCount := make(map[string]int)
+			for i := range nodeStates {
+				state := getNodeUpgradeState(clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired][i].Node)
+				stateCount[state]++
+			}
+			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(0))
+			Expect(stateCount[upgrade.UpgradeStateCordonRequired]).To(Equal(len(nodeStates)))
+		})
+		It("UpgradeStateManager should schedule upgrade based on maxUnavailable constraint if maxParallel upgrades is set to 0 and maxUnavailable is set to 50%", func() {
+			clusterState := upgrade.NewClusterUpgradeState()
+			nodeStates := []*upgrade.NodeUpgradeState{
+				{Node: NewNode("node1").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+				{Node: NewNode("node2").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+				{Node: NewNode("node3").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+				{Node: NewNode("node4").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Unschedulable(true).Node},
+				{Node: NewNode("node5").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Unschedulable(true).Node},
+			}
+
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = nodeStates
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+				// Unlimited upgrades
+				MaxParallelUpgrades: 0,
+				MaxUnavailable:      &intstr.IntOrString{Type: intstr.String, StrVal: "50%"},
+			}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			stateCount := make(map[string]int)
+			for i := range nodeStates {
+				state := getNodeUpgradeState(clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired][i].Node)
+				stateCount[state]++
+			}
+			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(2))
+			Expect(stateCount[upgrade.UpgradeStateCordonRequired]).To(Equal(3))
+		})
+		It("UpgradeStateManager should schedule upgrade based on 50% maxUnavailable, with some unavailable nodes already upgraded", func() {
+			clusterState := upgrade.NewClusterUpgradeState()
+			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
+			upToDatePod := &corev1.Pod{
+				Status:     corev1.PodStatus{Phase: "Running"},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+
+			upgradeRequiredNodes := []*upgrade.NodeUpgradeState{
+				{Node: NewNode("node1").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+				{Node: NewNode("node2").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+				{Node: NewNode("node3").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
 			}
 			upgradeDoneNodes := []*upgrade.NodeUpgradeState{
 				{
@@ -485,63 +1482,281 @@ var _ = Describe("UpgradeStateManager tests", func() {
 			// only maxUnavailable nodes should progress to next state
 			Expect(stateCount[upgrade.UpgradeStateCordonRequired]).To(Equal(2))
 		})
-		It("UpgradeStateManager should start additional upgrades if maxParallelUpgrades and maxUnavailable limits are not reached", func() {
-			const maxParallelUpgrades = 4
-
-			upgradeRequiredNodes := []*upgrade.NodeUpgradeState{
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
-			}
-			cordonRequiredNodes := []*upgrade.NodeUpgradeState{
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+		It("UpgradeStateManager should hold back the nodes over the max parallel upgrades budget", func() {
+			nodes := []*corev1.Node{
+				NewNode("over-budget-node-1").WithUpgradeState(upgrade.UpgradeStateCordonRequired).Node,
+				NewNode("over-budget-node-2").WithUpgradeState(upgrade.UpgradeStateCordonRequired).Node,
+				NewNode("over-budget-node-3").WithUpgradeState(upgrade.UpgradeStateCordonRequired).Node,
 			}
 			clusterState := upgrade.NewClusterUpgradeState()
-			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = upgradeRequiredNodes
-			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = cordonRequiredNodes
-
+			for _, node := range nodes {
+				clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = append(
+					clusterState.NodeStates[upgrade.UpgradeStateCordonRequired], &upgrade.NodeUpgradeState{Node: node})
+			}
+			// the limit was lowered while three upgrades were starting
 			policy := &v1alpha1.DriverUpgradePolicySpec{
 				AutoUpgrade:         true,
-				MaxParallelUpgrades: maxParallelUpgrades,
-				MaxUnavailable:      &intstr.IntOrString{Type: intstr.Int, IntVal: 4},
-				DrainSpec: &v1alpha1.DrainSpec{
-					Enable: true,
-				},
+				MaxParallelUpgrades: 1,
 			}
+			stateManager.WithPauseWhenOverBudget(true)
 
-			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
-			stateCount := make(map[string]int)
-			for _, state := range append(upgradeRequiredNodes, cordonRequiredNodes...) {
-				state := getNodeUpgradeState(state.Node)
-				stateCount[state]++
+			result, err := stateManager.ApplyStateWithResult(ctx, &clusterState, policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getNodeUpgradeState(nodes[0])).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+			for _, node := range nodes[1:] {
+				Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateCordonRequired))
+				Expect(upgrade.GetNodeUpgradeStateReason(node)).To(Equal(upgrade.UpgradeStateReasonOverBudget))
 			}
-			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(1))
-			Expect(stateCount[upgrade.UpgradeStateCordonRequired] +
-				stateCount[upgrade.UpgradeStateWaitForJobsRequired]).To(Equal(4))
+			Expect(result.OverBudget).To(Equal(2))
+			Expect(stateManager.GetUpgradeCapacity().OverBudget).To(Equal(2))
+			Expect(stateManager.GetUpgradeCapacity().SlotsAvailable).To(BeZero())
 		})
-		It("UpgradeStateManager should start additional upgrades if maxParallelUpgrades and maxUnavailable limits are not reached", func() {
-			const maxParallelUpgrades = 4
+		It("UpgradeStateManager should return the outcome of the ApplyState pass", func() {
+			startedNode := NewNode("result-started-node").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node
+			waitingNode := NewNode("result-waiting-node").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node
+			skippedNode := NewNode("result-skipped-node").
+				WithLabels(map[string]string{upgrade.GetUpgradeSkipNodeLabelKey(): "true"}).
+				WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).
+				Node
+			drainNode := NewNode("result-drain-node").WithUpgradeState(upgrade.UpgradeStateDrainRequired).Node
+			uncordonNode := NewNode("result-uncordon-node").WithUpgradeState(upgrade.UpgradeStateUncordonRequired).Node
 
-			upgradeRequiredNodes := []*upgrade.NodeUpgradeState{
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+				{Node: startedNode}, {Node: waitingNode}, {Node: skippedNode},
 			}
-			cordonRequiredNodes := []*upgrade.NodeUpgradeState{
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
-				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+			clusterState.NodeStates[upgrade.UpgradeStateDrainRequired] = []*upgrade.NodeUpgradeState{{Node: drainNode}}
+			clusterState.NodeStates[upgrade.UpgradeStateUncordonRequired] = []*upgrade.NodeUpgradeState{{Node: uncordonNode}}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+				// the drain and uncordon nodes take two of the slots
+				MaxParallelUpgrades: 3,
+				DrainSpec:           &v1alpha1.DrainSpec{Enable: true},
 			}
-			clusterState := upgrade.NewClusterUpgradeState()
-			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = upgradeRequiredNodes
-			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = cordonRequiredNodes
 
+			result, err := stateManager.ApplyStateWithResult(ctx, &clusterState, policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Transitions).To(Equal([]upgrade.NodeStateTransition{
+				{Node: startedNode.Name, From: upgrade.UpgradeStateUpgradeRequired, To: upgrade.UpgradeStateCordonRequired},
+				{Node: uncordonNode.Name, From: upgrade.UpgradeStateUncordonRequired, To: upgrade.UpgradeStateDone},
+			}))
+			Expect(result.NodesInState).To(Equal(map[string]int{
+				upgrade.UpgradeStateUpgradeRequired: 2,
+				upgrade.UpgradeStateCordonRequired:  1,
+				upgrade.UpgradeStateDrainRequired:   1,
+				upgrade.UpgradeStateDone:            1,
+			}))
+			Expect(result.SkippedNodes).To(Equal([]upgrade.SkippedNode{
+				{Node: skippedNode.Name, State: upgrade.UpgradeStateUpgradeRequired,
+					Reason: upgrade.SkippedNodeReasonSkipLabel},
+				{Node: waitingNode.Name, State: upgrade.UpgradeStateUpgradeRequired,
+					Reason: upgrade.UpgradeStateReasonWaitingForSlot},
+			}))
+			Expect(result.ScheduledActions).To(Equal([]upgrade.ScheduledAction{
+				{Node: drainNode.Name, Action: upgrade.UpgradeActionDrain},
+				{Node: uncordonNode.Name, Action: upgrade.UpgradeActionUncordon},
+			}))
+		})
+		It("UpgradeStateManager should recommend the requeue from what the nodes wait for", func() {
+			drainNode := NewNode("requeue-drain-node").WithUpgradeState(upgrade.UpgradeStateDrainRequired).Node
+			newClusterState := func(nodes ...*corev1.Node) *upgrade.ClusterUpgradeState {
+				clusterState := upgrade.NewClusterUpgradeState()
+				for _, node := range nodes {
+					state := upgrade.LabelStateStorage{}.GetState(node)
+					clusterState.NodeStates[state] = append(clusterState.NodeStates[state],
+						&upgrade.NodeUpgradeState{Node: node})
+				}
+				return &clusterState
+			}
 			policy := &v1alpha1.DriverUpgradePolicySpec{
 				AutoUpgrade:         true,
-				MaxParallelUpgrades: maxParallelUpgrades,
-				MaxUnavailable:      &intstr.IntOrString{Type: intstr.Int, IntVal: 4},
-				DrainSpec: &v1alpha1.DrainSpec{
-					Enable: true,
+				MaxParallelUpgrades: 1,
+				DrainSpec:           &v1alpha1.DrainSpec{Enable: true, TimeoutSecond: 60},
+			}
+
+			// the drained node is checked again at the drain timeout
+			result, err := stateManager.ApplyStateWithResult(ctx, newClusterState(drainNode), policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(time.Minute))
+			Expect(result.RequeueReason).To(Equal(upgrade.RequeueReasonDrain))
+
+			// the nodes waiting for the end of a blackout period are checked again at most after 10 minutes
+			blackoutNode := NewNode("requeue-blackout-node").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node
+			today := time.Now().UTC()
+			policy.BlackoutPeriods = []v1alpha1.BlackoutPeriodSpec{{Name: "freeze",
+				StartDate: today.AddDate(0, 0, -1).Format(time.DateOnly),
+				EndDate:   today.AddDate(0, 0, 1).Format(time.DateOnly)}}
+			result, err = stateManager.ApplyStateWithResult(ctx, newClusterState(blackoutNode), policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(upgrade.GetNodeUpgradeStateReason(blackoutNode)).To(Equal(upgrade.UpgradeStateReasonInBlackoutPeriod))
+			Expect(result.RequeueAfter).To(Equal(10 * time.Minute))
+			Expect(result.RequeueReason).To(Equal(upgrade.RequeueReasonBlackoutPeriod))
+
+			// the nodes waiting for a maintenance window are checked again when the next window opens
+			policy.BlackoutPeriods = nil
+			policy.Schedule = &v1alpha1.UpgradeScheduleSpec{
+				Cron: fmt.Sprintf("%d * * * *", (time.Now().Minute()+3)%60), DurationSecond: 60}
+			result, err = stateManager.ApplyStateWithResult(ctx, newClusterState(blackoutNode), policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 2*time.Minute))
+			Expect(result.RequeueAfter).To(BeNumerically("<=", 3*time.Minute))
+			Expect(result.RequeueReason).To(Equal(upgrade.RequeueReasonMaintenanceWindow))
+
+			// the started node can progress right away
+			policy.Schedule = nil
+			result, err = stateManager.ApplyStateWithResult(ctx, newClusterState(blackoutNode), policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getNodeUpgradeState(blackoutNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(result.RequeueAfter).To(Equal(5 * time.Second))
+			Expect(result.RequeueReason).To(Equal(upgrade.RequeueReasonProgress))
+
+			// no requeue is needed once the upgrades are done
+			doneNode := NewNode("requeue-done-node").WithUpgradeState(upgrade.UpgradeStateDone).Node
+			result, err = stateManager.ApplyStateWithResult(ctx, newClusterState(doneNode), policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero())
+			Expect(result.RequeueReason).To(BeEmpty())
+		})
+		It("UpgradeStateManager should export the upgrade state metrics", func() {
+			registry := prometheus.NewRegistry()
+			stateManager.WithMetrics(registry)
+
+			nodeStates := []*upgrade.NodeUpgradeState{
+				{Node: NewNode("metrics-node-1").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+				{Node: NewNode("metrics-node-2").WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node},
+				{Node: NewNode("metrics-node-3").WithUpgradeState(upgrade.UpgradeStateDone).Node},
+			}
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = nodeStates[:2]
+			clusterState.NodeStates[upgrade.UpgradeStateDone] = nodeStates[2:]
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 1,
+			}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+
+			expected := `
+# HELP driver_upgrade_nodes_in_state Count of nodes in each upgrade state
+# TYPE driver_upgrade_nodes_in_state gauge
+driver_upgrade_nodes_in_state{driver="gpu",state="cordon-required"} 1
+driver_upgrade_nodes_in_state{driver="gpu",state="upgrade-done"} 1
+driver_upgrade_nodes_in_state{driver="gpu",state="upgrade-required"} 1
+# HELP driver_upgrade_state_transitions_total Count of node upgrade state changes observed between upgrade passes
+# TYPE driver_upgrade_state_transitions_total counter
+driver_upgrade_state_transitions_total{driver="gpu",from="upgrade-required",to="cordon-required"} 1
+`
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(expected),
+				"driver_upgrade_nodes_in_state", "driver_upgrade_state_transitions_total")).To(Succeed())
+		})
+		It("UpgradeStateManager should not start upgrades of skipped nodes in a mixed GPU cluster", func() {
+			cluster := upgradetest.MixedGPUCluster(6, 4)
+			gpuNodes := cluster.ManagedNodes()
+			for _, node := range gpuNodes {
+				upgradetest.WithUpgradeState(upgrade.UpgradeStateUpgradeRequired)(node)
+			}
+			upgradetest.SkipUpgrade()(gpuNodes[0])
+			upgradetest.SkipUpgrade()(gpuNodes[1])
+			clusterState := cluster.UpgradeState()
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 3,
+				MaxUnavailable:      &intstr.IntOrString{Type: intstr.String, StrVal: "100%"},
+			}
+			Expect(stateManager.ApplyState(ctx, clusterState, policy)).To(Succeed())
+			stateCount := make(map[string]int)
+			for _, node := range gpuNodes[2:] {
+				stateCount[getNodeUpgradeState(node)]++
+			}
+			Expect(stateCount[upgrade.UpgradeStateCordonRequired]).To(Equal(3))
+			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(1))
+			Expect(getNodeUpgradeState(gpuNodes[0])).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(getNodeUpgradeState(gpuNodes[1])).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+		})
+		It("UpgradeStateManager should not start more upgrades than the cluster unavailable nodes budget allows", func() {
+			// nodes cordoned for reasons unrelated to the upgrade take from the cluster wide budget
+			NewNode("cordoned-" + randSeq(5)).Unschedulable(true).Create()
+			NewNode("cordoned-" + randSeq(5)).Unschedulable(true).Create()
+			nodeStates := []*upgrade.NodeUpgradeState{
+				{Node: NewNode("managed-" + randSeq(5)).WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Create()},
+				{Node: NewNode("managed-" + randSeq(5)).WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Create()},
+				{Node: NewNode("managed-" + randSeq(5)).WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Create()},
+			}
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = nodeStates
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:           true,
+				MaxParallelUpgrades:   0,
+				MaxUnavailable:        &intstr.IntOrString{Type: intstr.String, StrVal: "100%"},
+				ClusterMaxUnavailable: &intstr.IntOrString{Type: intstr.Int, IntVal: 3},
+			}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			stateCount := make(map[string]int)
+			for i := range nodeStates {
+				stateCount[getNodeUpgradeState(nodeStates[i].Node)]++
+			}
+			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(2))
+			Expect(stateCount[upgrade.UpgradeStateCordonRequired]).To(Equal(1))
+		})
+		It("UpgradeStateManager should start additional upgrades if maxParallelUpgrades and maxUnavailable limits are not reached", func() {
+			const maxParallelUpgrades = 4
+
+			upgradeRequiredNodes := []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+			}
+			cordonRequiredNodes := []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+			}
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = upgradeRequiredNodes
+			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = cordonRequiredNodes
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: maxParallelUpgrades,
+				MaxUnavailable:      &intstr.IntOrString{Type: intstr.Int, IntVal: 4},
+				DrainSpec: &v1alpha1.DrainSpec{
+					Enable: true,
+				},
+			}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			stateCount := make(map[string]int)
+			for _, state := range append(upgradeRequiredNodes, cordonRequiredNodes...) {
+				state := getNodeUpgradeState(state.Node)
+				stateCount[state]++
+			}
+			Expect(stateCount[upgrade.UpgradeStateUpgradeRequired]).To(Equal(1))
+			Expect(stateCount[upgrade.UpgradeStateCordonRequired] +
+				stateCount[upgrade.UpgradeStateWaitForJobsRequired]).To(Equal(4))
+		})
+		It("UpgradeStateManager should start additional upgrades if maxParallelUpgrades and maxUnavailable limits are not reached", func() {
+			const maxParallelUpgrades = 4
+
+			upgradeRequiredNodes := []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)},
+			}
+			cordonRequiredNodes := []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)},
+			}
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = upgradeRequiredNodes
+			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = cordonRequiredNodes
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: maxParallelUpgrades,
+				MaxUnavailable:      &intstr.IntOrString{Type: intstr.Int, IntVal: 4},
+				DrainSpec: &v1alpha1.DrainSpec{
+					Enable: true,
 				},
 			}
 
@@ -636,6 +1851,102 @@ var _ = Describe("UpgradeStateManager tests", func() {
 				Expect(getNodeUpgradeState(state.Node)).To(Equal(upgrade.UpgradeStateDrainRequired))
 			}
 		})
+		It("UpgradeStateManager should use the default pod deletion spec if pod deletion is enabled "+
+			"and the policy has none", func() {
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodDeletionRequired] = []*upgrade.NodeUpgradeState{
+				{Node: nodeWithUpgradeState(upgrade.UpgradeStatePodDeletionRequired)},
+			}
+
+			var podManagerConfig *upgrade.PodManagerConfig
+			podManagerMock := mocks.PodManager{}
+			podManagerMock.
+				On("SchedulePodEviction", mock.Anything, mock.Anything).
+				Return(func(ctx context.Context, config *upgrade.PodManagerConfig) error {
+					podManagerConfig = config
+					return nil
+				}).
+				On("SchedulePodsRestart", mock.Anything, mock.Anything).
+				Return(nil)
+			stateManager.WithPodDeletionEnabled(func(pod corev1.Pod) bool { return true })
+			stateManager.PodManager = &podManagerMock
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(podManagerConfig).NotTo(BeNil())
+			Expect(podManagerConfig.DeletionSpec).To(Equal(&v1alpha1.PodDeletionSpec{TimeoutSecond: 300}))
+			Expect(podManagerConfig.DrainEnabled).To(BeFalse())
+		})
+		It("UpgradeStateManager should reject the policy requesting pod deletion "+
+			"if pod deletion is not enabled and drain is disabled", func() {
+			node := nodeWithUpgradeState(upgrade.UpgradeStatePodDeletionRequired)
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodDeletionRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+				PodDeletion: &v1alpha1.PodDeletionSpec{},
+			}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).NotTo(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStatePodDeletionRequired))
+
+			policy.DrainSpec = &v1alpha1.DrainSpec{Enable: true}
+			Expect(stateManager.ValidateUpgradePolicy(policy)).To(Succeed())
+			stateManager.WithPodDeletionEnabled(func(pod corev1.Pod) bool { return true })
+			policy.DrainSpec = nil
+			Expect(stateManager.ValidateUpgradePolicy(policy)).To(Succeed())
+		})
+		It("UpgradeStateManager should reject the policy with invalid selectors, timeouts or conflicting options",
+			func() {
+				policy := &v1alpha1.DriverUpgradePolicySpec{
+					AutoUpgrade: true,
+					DrainSpec: &v1alpha1.DrainSpec{
+						Enable:        true,
+						PodSelector:   "app in (",
+						TimeoutSecond: -1,
+						StuckFinalizers: &v1alpha1.StuckFinalizerSpec{
+							Action:            v1alpha1.StuckFinalizerActionWait,
+							AllowedFinalizers: []string{"example.com/protect"},
+						},
+					},
+				}
+				err := policy.Validate()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("drain.podSelector"))
+				Expect(err.Error()).To(ContainSubstring("drain.timeoutSeconds"))
+				Expect(err.Error()).To(ContainSubstring("drain.stuckFinalizers.allowedFinalizers"))
+				Expect(stateManager.ValidateUpgradePolicy(policy)).NotTo(Succeed())
+
+				policy.DrainSpec.PodSelector = "app=gpu"
+				policy.DrainSpec.TimeoutSecond = 300
+				policy.DrainSpec.StuckFinalizers.Action = v1alpha1.StuckFinalizerActionRemoveFinalizers
+				Expect(policy.Validate()).To(Succeed())
+
+				unavailable := intstr.FromString("ten%")
+				policy.MaxUnavailable = &unavailable
+				Expect(policy.Validate()).To(MatchError(ContainSubstring("maxUnavailable")))
+			})
+		It("UpgradePolicyValidator should reject the resources with an invalid upgrade policy", func() {
+			policies := map[string]*v1alpha1.DriverUpgradePolicySpec{
+				"valid":   {AutoUpgrade: true, PodDeletion: &v1alpha1.PodDeletionSpec{TimeoutSecond: 300}},
+				"invalid": {AutoUpgrade: true, PodDeletion: &v1alpha1.PodDeletionSpec{TimeoutSecond: -1}},
+			}
+			validator := upgrade.NewUpgradePolicyValidator(
+				func(obj runtime.Object) (*v1alpha1.DriverUpgradePolicySpec, error) {
+					return policies[obj.(*corev1.ConfigMap).Name], nil
+				})
+			valid := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "valid"}}
+			invalid := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "invalid"}}
+
+			_, err := validator.ValidateCreate(ctx, valid)
+			Expect(err).To(Succeed())
+			_, err = validator.ValidateCreate(ctx, invalid)
+			Expect(err).To(MatchError(ContainSubstring("podDeletion.timeoutSeconds")))
+			_, err = validator.ValidateUpdate(ctx, valid, invalid)
+			Expect(err).To(HaveOccurred())
+			_, err = validator.ValidateDelete(ctx, invalid)
+			Expect(err).To(Succeed())
+		})
 		It("UpgradeStateManager should skip drain if it's disabled by policy", func() {
 			clusterState := upgrade.NewClusterUpgradeState()
 			clusterState.NodeStates[upgrade.UpgradeStateDrainRequired] = []*upgrade.NodeUpgradeState{
@@ -729,6 +2040,54 @@ var _ = Describe("UpgradeStateManager tests", func() {
 
 			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).ToNot(Succeed())
 		})
+		It("UpgradeStateManager should report the drain status of the nodes being drained", func() {
+			drainingNode := nodeWithUpgradeState(upgrade.UpgradeStateDrainRequired)
+			drainingNode.Name = "draining-node"
+			drainingNode.Spec.Unschedulable = true
+			scheduledNode := nodeWithUpgradeState(upgrade.UpgradeStateDrainRequired)
+			scheduledNode.Name = "scheduled-node"
+			scheduledNode.Spec.Unschedulable = true
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateDrainRequired] = []*upgrade.NodeUpgradeState{
+				{Node: drainingNode}, {Node: scheduledNode},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+				DrainSpec:   &v1alpha1.DrainSpec{Enable: true},
+			}
+
+			status := upgrade.DrainStatus{
+				PodsRemaining: 1,
+				PodsEvicted:   2,
+				StartTime:     v1.Now(),
+				LastError:     "Cannot evict pod as it would violate the pod's disruption budget.",
+			}
+			drainManager := &drainStatusManager{statuses: map[string]upgrade.DrainStatus{drainingNode.Name: status}}
+			drainManager.On("ScheduleNodesDrain", mock.Anything, mock.Anything).Return(nil)
+			stateManager.DrainManager = drainManager
+			recorder := record.NewFakeRecorder(10)
+			stateManager.EventRecorder = recorder
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			reported := upgrade.DrainStatus{}
+			Expect(json.Unmarshal([]byte(drainingNode.Annotations[upgrade.GetUpgradeDrainStatusAnnotationKey()]),
+				&reported)).To(Succeed())
+			Expect(reported.PodsRemaining).To(Equal(1))
+			Expect(reported.PodsEvicted).To(Equal(2))
+			Expect(reported.LastError).To(Equal(status.LastError))
+			Expect(scheduledNode.Annotations).NotTo(HaveKey(upgrade.GetUpgradeDrainStatusAnnotationKey()))
+			Expect(recorder.Events).To(Receive(SatisfyAll(
+				ContainSubstring("Drain of the node is stalled with 1 pods remaining"),
+				ContainSubstring(status.LastError))))
+
+			// the event is not repeated while the error stays the same
+			status.PodsEvicted = 3
+			drainManager.statuses[drainingNode.Name] = status
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(drainingNode.Annotations[upgrade.GetUpgradeDrainStatusAnnotationKey()]).To(
+				ContainSubstring(`"podsEvicted":3`))
+			Expect(recorder.Events).To(BeEmpty())
+		})
 		It("UpgradeStateManager should not restart pod if it's up to date or already terminating", func() {
 			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
 			upToDatePod := &corev1.Pod{
@@ -790,6 +2149,292 @@ var _ = Describe("UpgradeStateManager tests", func() {
 
 			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
 		})
+		It("UpgradeStateManager should wait for the DaemonSet surge handoff instead of restarting the Pod", func() {
+			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
+			outdatedPod := &corev1.Pod{
+				Status:     corev1.PodStatus{Phase: "Running"},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-outdated"}}}
+			surgePod := &corev1.Pod{
+				Status:     corev1.PodStatus{Phase: "Running"},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			node := NewNode(fmt.Sprintf("node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).
+				Create()
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{
+				{Node: node, DriverPod: outdatedPod, DriverDaemonSet: daemonSet, SurgeDriverPod: surgePod},
+			}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+
+			podManagerMock := mocks.PodManager{}
+			podManagerMock.
+				On("SchedulePodsRestart", mock.Anything, mock.Anything).
+				Return(func(ctx context.Context, podsToDelete []*corev1.Pod) error {
+					Expect(podsToDelete).To(BeEmpty())
+					return nil
+				})
+			stateManager.PodManager = &podManagerMock
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+		})
+
+		It("UpgradeStateManager should restart the outdated driver pods of all the driver DaemonSets of a node "+
+			"in one upgrade cycle", func() {
+			gpuDaemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{Name: "gpu-driver"}}
+			networkDaemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{Name: "network-driver"}}
+			readyStatus := corev1.PodStatus{
+				Phase:             "Running",
+				ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+			}
+			gpuPod := &corev1.Pod{
+				Status:     readyStatus,
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			networkPod := &corev1.Pod{
+				Status:     readyStatus,
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-outdated"}}}
+			node := NewNode(fmt.Sprintf("node-%s", id)).WithUpgradeState(upgrade.UpgradeStateDone).Create()
+			nodeState := &upgrade.NodeUpgradeState{
+				Node:              node,
+				DriverPod:         gpuPod,
+				DriverDaemonSet:   gpuDaemonSet,
+				AdditionalDrivers: []*upgrade.NodeDriver{{Pod: networkPod, DaemonSet: networkDaemonSet}},
+			}
+
+			var restartedPods []*corev1.Pod
+			podManagerMock := mocks.PodManager{}
+			podManagerMock.
+				On("SchedulePodsRestart", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, podsToDelete []*corev1.Pod) error {
+					restartedPods = append(restartedPods, podsToDelete...)
+					return nil
+				}).
+				On("GetPodControllerRevisionHash", mock.Anything, mock.Anything).
+				Return(
+					func(ctx context.Context, pod *corev1.Pod) string {
+						return pod.Labels[upgrade.PodControllerRevisionHashLabelKey]
+					},
+					func(ctx context.Context, pod *corev1.Pod) error {
+						return nil
+					},
+				).
+				On("GetDaemonsetControllerRevisionHash", mock.Anything, mock.Anything, mock.Anything).
+				Return("test-hash-12345", nil)
+			stateManager.PodManager = &podManagerMock
+
+			// the outdated network driver requires the upgrade of the node, although the GPU driver is up to date
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateDone] = []*upgrade.NodeUpgradeState{nodeState}
+			Expect(stateManager.ProcessDoneOrUnknownNodes(ctx, &clusterState, upgrade.UpgradeStateDone)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+
+			Expect(stateManager.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node,
+				upgrade.UpgradeStatePodRestartRequired)).To(Succeed())
+			clusterState = upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{nodeState}
+			Expect(stateManager.ProcessPodRestartNodes(ctx, &clusterState)).To(Succeed())
+			Expect(restartedPods).To(ConsistOf(networkPod))
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+
+			// the node waits for the new network driver pod to be ready
+			restartedPods = nil
+			newNetworkPod := &corev1.Pod{
+				Status:     corev1.PodStatus{Phase: "Pending"},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			nodeState.AdditionalDrivers[0].Pod = newNetworkPod
+			Expect(stateManager.ProcessPodRestartNodes(ctx, &clusterState)).To(Succeed())
+			Expect(restartedPods).To(BeEmpty())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+
+			newNetworkPod.Status = readyStatus
+			Expect(stateManager.ProcessPodRestartNodes(ctx, &clusterState)).To(Succeed())
+			Expect(restartedPods).To(BeEmpty())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUncordonRequired))
+		})
+
+		It("UpgradeStateManager should restart the driver pods of a node in the driver restart order", func() {
+			readyStatus := corev1.PodStatus{
+				Phase:             "Running",
+				ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+			}
+			newDriver := func(name, hash string) *upgrade.NodeDriver {
+				return &upgrade.NodeDriver{
+					Pod: &corev1.Pod{
+						Status: readyStatus,
+						ObjectMeta: v1.ObjectMeta{Name: name + "-" + hash,
+							Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: hash}}},
+					DaemonSet: &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{Name: name}},
+				}
+			}
+			kernelDriver := newDriver("kernel-driver", "test-hash-outdated")
+			devicePlugin := newDriver("device-plugin", "test-hash-outdated")
+			monitoringAgent := newDriver("monitoring-agent", "test-hash-outdated")
+			node := NewNode(fmt.Sprintf("node-%s", id)).WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).Create()
+			nodeState := &upgrade.NodeUpgradeState{
+				Node:              node,
+				DriverPod:         kernelDriver.Pod,
+				DriverDaemonSet:   kernelDriver.DaemonSet,
+				AdditionalDrivers: []*upgrade.NodeDriver{monitoringAgent, devicePlugin},
+			}
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{nodeState}
+
+			var restartedPods []*corev1.Pod
+			podManagerMock := mocks.PodManager{}
+			podManagerMock.
+				On("SchedulePodsRestart", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, podsToDelete []*corev1.Pod) error {
+					restartedPods = podsToDelete
+					return nil
+				}).
+				On("GetPodControllerRevisionHash", mock.Anything, mock.Anything).
+				Return(
+					func(ctx context.Context, pod *corev1.Pod) string {
+						return pod.Labels[upgrade.PodControllerRevisionHashLabelKey]
+					},
+					func(ctx context.Context, pod *corev1.Pod) error {
+						return nil
+					},
+				).
+				On("GetDaemonsetControllerRevisionHash", mock.Anything, mock.Anything, mock.Anything).
+				Return("test-hash-12345", nil)
+			stateManager.PodManager = &podManagerMock
+			stateManager.WithDriverRestartOrder("kernel-driver", "device-plugin", "monitoring-agent")
+
+			Expect(stateManager.ProcessPodRestartNodes(ctx, &clusterState)).To(Succeed())
+			Expect(restartedPods).To(ConsistOf(kernelDriver.Pod))
+
+			// the device plugin waits for the new kernel driver pod to be ready
+			kernelDriver.Pod = newDriver("kernel-driver", "test-hash-12345").Pod
+			kernelDriver.Pod.Status = corev1.PodStatus{Phase: "Pending"}
+			nodeState.DriverPod = kernelDriver.Pod
+			Expect(stateManager.ProcessPodRestartNodes(ctx, &clusterState)).To(Succeed())
+			Expect(restartedPods).To(BeEmpty())
+
+			kernelDriver.Pod.Status = readyStatus
+			Expect(stateManager.ProcessPodRestartNodes(ctx, &clusterState)).To(Succeed())
+			Expect(restartedPods).To(ConsistOf(devicePlugin.Pod))
+
+			devicePlugin.Pod = newDriver("device-plugin", "test-hash-12345").Pod
+			Expect(stateManager.ProcessPodRestartNodes(ctx, &clusterState)).To(Succeed())
+			Expect(restartedPods).To(ConsistOf(monitoringAgent.Pod))
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+
+			monitoringAgent.Pod = newDriver("monitoring-agent", "test-hash-12345").Pod
+			Expect(stateManager.ProcessPodRestartNodes(ctx, &clusterState)).To(Succeed())
+			Expect(restartedPods).To(BeEmpty())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUncordonRequired))
+		})
+
+		It("UpgradeStateManager should detect the outdated driver pods with the upgrade required checker", func() {
+			daemonSet := &appsv1.DaemonSet{Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{"driver-version": "2.0"}}}}}
+			// the revision hash of the pod is the current one, as with the OnDelete update strategy
+			pod := &corev1.Pod{
+				Status: corev1.PodStatus{Phase: "Running", ContainerStatuses: []corev1.ContainerStatus{{Ready: true}}},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{
+					upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345", "driver-version": "1.0"}}}
+			node := NewNode(fmt.Sprintf("node-%s", id)).WithUpgradeState(upgrade.UpgradeStateDone).Create()
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateDone] = []*upgrade.NodeUpgradeState{
+				{Node: node, DriverPod: pod, DriverDaemonSet: daemonSet},
+			}
+
+			Expect(stateManager.ProcessDoneOrUnknownNodes(ctx, &clusterState, upgrade.UpgradeStateDone)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateDone))
+
+			stateManager.WithUpgradeRequiredChecker(upgrade.NewLabelUpgradeRequiredChecker("driver-version"))
+			Expect(stateManager.ProcessDoneOrUnknownNodes(ctx, &clusterState, upgrade.UpgradeStateDone)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+		})
+
+		It("UpgradeStateManager should run the node hook pods around the driver Pod restart", func() {
+			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
+			outdatedPod := &corev1.Pod{
+				Status:     corev1.PodStatus{Phase: "Running"},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-outdated"}}}
+			unloadedNodePod := outdatedPod.DeepCopy()
+			upToDatePod := &corev1.Pod{
+				Status:     corev1.PodStatus{Phase: "Running", ContainerStatuses: []corev1.ContainerStatus{{Ready: true}}},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			unloadingNode := NewNode(fmt.Sprintf("unloading-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).Create()
+			unloadedNode := NewNode(fmt.Sprintf("unloaded-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).Create()
+			postHookFailedNode := NewNode(fmt.Sprintf("post-hook-failed-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).Create()
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{
+				{Node: unloadingNode, DriverPod: outdatedPod, DriverDaemonSet: daemonSet},
+				{Node: unloadedNode, DriverPod: unloadedNodePod, DriverDaemonSet: daemonSet},
+				{Node: postHookFailedNode, DriverPod: upToDatePod, DriverDaemonSet: daemonSet},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+			preUpgrade := &corev1.PodTemplateSpec{ObjectMeta: v1.ObjectMeta{Namespace: "default"}}
+			postUpgrade := &corev1.PodTemplateSpec{ObjectMeta: v1.ObjectMeta{Namespace: "default"}}
+			hookPhases := map[string]corev1.PodPhase{
+				unloadingNode.Name:      corev1.PodRunning,
+				unloadedNode.Name:       corev1.PodSucceeded,
+				postHookFailedNode.Name: corev1.PodFailed,
+			}
+
+			var restartedPods []*corev1.Pod
+			podManagerMock := mocks.PodManager{}
+			podManagerMock.
+				On("RunNodeHookPod", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+				Return(
+					func(_ context.Context, node *corev1.Node, hook upgrade.NodeHook,
+						template *corev1.PodTemplateSpec) corev1.PodPhase {
+						if node.Name == postHookFailedNode.Name {
+							Expect(hook).To(Equal(upgrade.NodeHookPostUpgrade))
+							Expect(template).To(Equal(postUpgrade))
+						} else {
+							Expect(hook).To(Equal(upgrade.NodeHookPreUpgrade))
+							Expect(template).To(Equal(preUpgrade))
+						}
+						return hookPhases[node.Name]
+					},
+					nil).
+				On("SchedulePodsRestart", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, podsToDelete []*corev1.Pod) error {
+					restartedPods = podsToDelete
+					return nil
+				}).
+				On("GetPodControllerRevisionHash", mock.Anything, mock.Anything).
+				Return(
+					func(ctx context.Context, pod *corev1.Pod) string {
+						return pod.Labels[upgrade.PodControllerRevisionHashLabelKey]
+					},
+					func(ctx context.Context, pod *corev1.Pod) error {
+						return nil
+					},
+				).
+				On("GetDaemonsetControllerRevisionHash", mock.Anything, mock.Anything, mock.Anything).
+				Return("test-hash-12345", nil)
+			stateManager.PodManager = &podManagerMock
+			stateManager.WithNodeHookPods(preUpgrade, postUpgrade)
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(restartedPods).To(ConsistOf(unloadedNodePod))
+			Expect(getNodeUpgradeState(unloadingNode)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+			Expect(getNodeUpgradeState(postHookFailedNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(postHookFailedNode.Annotations[upgrade.GetUpgradeStateReasonAnnotationKey()]).To(
+				Equal(upgrade.UpgradeStateReasonNodeHookFailed))
+
+			// the node whose hook failed doesn't recover from the upgrade-failed state on its own
+			clusterState = upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateFailed] = []*upgrade.NodeUpgradeState{
+				{Node: postHookFailedNode, DriverPod: upToDatePod, DriverDaemonSet: daemonSet},
+			}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(postHookFailedNode)).To(Equal(upgrade.UpgradeStateFailed))
+		})
+
 		It("UpgradeStateManager should unblock loading of the driver instead of restarting the Pod when node "+
 			"is waiting for safe driver loading", func() {
 			safeLoadAnnotation := upgrade.GetUpgradeDriverWaitForSafeLoadAnnotationKey()
@@ -812,53 +2457,296 @@ var _ = Describe("UpgradeStateManager tests", func() {
 					DriverDaemonSet: daemonSet,
 				},
 			}
-
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			stateManager.NodeUpgradeStateProvider = provider
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: waitForSafeLoadNode.Name}, waitForSafeLoadNode)).
+				NotTo(HaveOccurred())
+			Expect(waitForSafeLoadNode.Annotations[safeLoadAnnotation]).To(BeEmpty())
+		})
+		It("UpgradeStateManager should move pod to UncordonRequired state "+
+			"if it's in PodRestart or UpgradeFailed, up to date and ready", func() {
+			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
+			pod := &corev1.Pod{
+				Status: corev1.PodStatus{
+					Phase:             "Running",
+					ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+				},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			podRestartNode := NewNode("pod-restart-node").WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).Create()
+			upgradeFailedNode := NewNode("upgrade-failed-node").WithUpgradeState(upgrade.UpgradeStateFailed).Create()
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{
+				{
+					Node:            podRestartNode,
+					DriverPod:       pod,
+					DriverDaemonSet: daemonSet,
+				},
+			}
+			clusterState.NodeStates[upgrade.UpgradeStateFailed] = []*upgrade.NodeUpgradeState{
+				{
+					Node:            upgradeFailedNode,
+					DriverPod:       pod,
+					DriverDaemonSet: daemonSet,
+				},
+			}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(podRestartNode)).To(Equal(upgrade.UpgradeStateUncordonRequired))
+			Expect(getNodeUpgradeState(upgradeFailedNode)).To(Equal(upgrade.UpgradeStateUncordonRequired))
+		})
+		It("UpgradeStateManager should keep UpgradeFailed node until the driver health probes pass", func() {
+			pod := &corev1.Pod{
+				Status: corev1.PodStatus{
+					Phase:             "Running",
+					ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+				},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			upgradeFailedNode := NewNode("upgrade-failed-probe-node").WithUpgradeState(upgrade.UpgradeStateFailed).Create()
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateFailed] = []*upgrade.NodeUpgradeState{
+				{
+					Node:            upgradeFailedNode,
+					DriverPod:       pod,
+					DriverDaemonSet: &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}},
+				},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+
+			probeErr := errors.New("driver is not loaded")
+			stateManager.NodeUpgradeStateProvider = upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			stateManager.WithDriverHealthProbe(func(_ context.Context, nodeState *upgrade.NodeUpgradeState) error {
+				Expect(nodeState.Node.Name).To(Equal(upgradeFailedNode.Name))
+				return probeErr
+			})
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(upgradeFailedNode)).To(Equal(upgrade.UpgradeStateFailed))
+			node := &corev1.Node{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: upgradeFailedNode.Name}, node)).To(Succeed())
+			Expect(upgrade.GetNodeUpgradeStateReason(node)).To(Equal(upgrade.UpgradeStateReasonHealthProbeFailed))
+
+			probeErr = nil
+			clusterState.NodeStates[upgrade.UpgradeStateFailed][0].Node = node
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUncordonRequired))
+		})
+		It("UpgradeStateManager should leave UpgradeFailed nodes cordoned by default", func() {
+			pod := &corev1.Pod{
+				Status:     corev1.PodStatus{Phase: "Pending"},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			node := NewNode("failed-node-cordoned").WithUpgradeState(upgrade.UpgradeStateFailed).Unschedulable(true).Node
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateFailed] = []*upgrade.NodeUpgradeState{
+				{Node: node, DriverPod: pod, DriverDaemonSet: &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+
+			cordonManagerMock := mocks.CordonManager{}
+			cordonManagerMock.
+				On("Uncordon", mock.Anything, mock.Anything).
+				Return(nil)
+			stateManager.CordonManager = &cordonManagerMock
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(node.Annotations[upgrade.GetUpgradeFailedNodeCordonAnnotationKey()]).
+				To(Equal(upgrade.FailedNodeCordoned))
+			cordonManagerMock.AssertNotCalled(GinkgoT(), "Uncordon", mock.Anything, mock.Anything)
+
+			// the record is removed once the node recovers
+			pod.Status = corev1.PodStatus{Phase: "Running", ContainerStatuses: []corev1.ContainerStatus{{Ready: true}}}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUncordonRequired))
+			Expect(node.Annotations).NotTo(HaveKey(upgrade.GetUpgradeFailedNodeCordonAnnotationKey()))
+		})
+		It("UpgradeStateManager should uncordon UpgradeFailed nodes if the policy requires it", func() {
+			pod := &corev1.Pod{
+				Status:     corev1.PodStatus{Phase: "Pending"},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
+			node := NewNode("failed-node-uncordoned").WithUpgradeState(upgrade.UpgradeStateFailed).Unschedulable(true).Node
+			initiallyUnschedulableNode := NewNode("failed-node-initially-unschedulable").
+				WithUpgradeState(upgrade.UpgradeStateFailed).
+				WithAnnotations(map[string]string{upgrade.GetUpgradeInitialStateAnnotationKey(): "true"}).
+				Unschedulable(true).
+				Node
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateFailed] = []*upgrade.NodeUpgradeState{
+				{Node: node, DriverPod: pod, DriverDaemonSet: daemonSet},
+				{Node: initiallyUnschedulableNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true, UncordonFailedNodes: true}
+
+			cordonManagerMock := mocks.CordonManager{}
+			cordonManagerMock.
+				On("Uncordon", mock.Anything, mock.Anything).
+				Return(func(_ context.Context, node *corev1.Node) error {
+					node.Spec.Unschedulable = false
+					return nil
+				})
+			stateManager.CordonManager = &cordonManagerMock
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			cordonManagerMock.AssertCalled(GinkgoT(), "Uncordon", mock.Anything, node)
+			cordonManagerMock.AssertNumberOfCalls(GinkgoT(), "Uncordon", 1)
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(node.Annotations[upgrade.GetUpgradeFailedNodeCordonAnnotationKey()]).
+				To(Equal(upgrade.FailedNodeUncordoned))
+			Expect(initiallyUnschedulableNode.Spec.Unschedulable).To(BeTrue())
+			Expect(initiallyUnschedulableNode.Annotations[upgrade.GetUpgradeFailedNodeCordonAnnotationKey()]).
+				To(Equal(upgrade.FailedNodeCordoned))
+		})
+		It("UpgradeStateManager should retry the upgrade of UpgradeFailed nodes after a backoff", func() {
+			pod := &corev1.Pod{
+				Status:     corev1.PodStatus{Phase: "Pending"},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
+			attemptsKey := upgrade.GetUpgradeRetryAttemptsAnnotationKey()
+			startTimeKey := upgrade.GetUpgradeRetryStartTimeAnnotationKey()
+			backoffStart := strconv.FormatInt(time.Now().Unix()-90, 10)
+			newFailedNode := NewNode("failed-node-new").WithUpgradeState(upgrade.UpgradeStateFailed).Node
+			retriedNode := NewNode("failed-node-retried").
+				WithUpgradeState(upgrade.UpgradeStateFailed).
+				WithAnnotations(map[string]string{startTimeKey: backoffStart}).
+				Node
+			// the backoff doubles after the first attempt
+			backoffNode := NewNode("failed-node-backoff").
+				WithUpgradeState(upgrade.UpgradeStateFailed).
+				WithAnnotations(map[string]string{attemptsKey: "1", startTimeKey: backoffStart}).
+				Node
+			exhaustedNode := NewNode("failed-node-exhausted").
+				WithUpgradeState(upgrade.UpgradeStateFailed).
+				WithAnnotations(map[string]string{attemptsKey: "2", startTimeKey: backoffStart}).
+				Node
+			doneNode := NewNode("done-node-retried").
+				WithUpgradeState(upgrade.UpgradeStateDone).
+				WithAnnotations(map[string]string{attemptsKey: "1"}).
+				Node
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateFailed] = []*upgrade.NodeUpgradeState{
+				{Node: newFailedNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+				{Node: retriedNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+				{Node: backoffNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+				{Node: exhaustedNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+			}
+			clusterState.NodeStates[upgrade.UpgradeStateDone] = []*upgrade.NodeUpgradeState{
+				{Node: doneNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+			}
 			policy := &v1alpha1.DriverUpgradePolicySpec{
 				AutoUpgrade: true,
+				Retry:       &v1alpha1.RetrySpec{MaxAttempts: 2, BackoffSeconds: 60},
 			}
-			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
-			stateManager.NodeUpgradeStateProvider = provider
 
 			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
-			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: waitForSafeLoadNode.Name}, waitForSafeLoadNode)).
-				NotTo(HaveOccurred())
-			Expect(waitForSafeLoadNode.Annotations[safeLoadAnnotation]).To(BeEmpty())
+			Expect(getNodeUpgradeState(newFailedNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(newFailedNode.Annotations).To(HaveKey(startTimeKey))
+			Expect(newFailedNode.Annotations[upgrade.GetUpgradeStateReasonAnnotationKey()]).To(
+				Equal(upgrade.UpgradeStateReasonRetryBackoff))
+			Expect(getNodeUpgradeState(retriedNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(upgrade.GetNodeUpgradeRetryAttempts(retriedNode)).To(Equal(1))
+			Expect(retriedNode.Annotations).NotTo(HaveKey(startTimeKey))
+			Expect(getNodeUpgradeState(backoffNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(upgrade.GetNodeUpgradeRetryAttempts(backoffNode)).To(Equal(1))
+			Expect(getNodeUpgradeState(exhaustedNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(exhaustedNode.Annotations).NotTo(HaveKey(upgrade.GetUpgradeStateReasonAnnotationKey()))
+			Expect(doneNode.Annotations).NotTo(HaveKey(attemptsKey))
+
+			policy.Retry.BackoffSeconds = -1
+			Expect(stateManager.ValidateUpgradePolicy(policy)).NotTo(Succeed())
 		})
-		It("UpgradeStateManager should move pod to UncordonRequired state "+
-			"if it's in PodRestart or UpgradeFailed, up to date and ready", func() {
-			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
-			pod := &corev1.Pod{
-				Status: corev1.PodStatus{
-					Phase:             "Running",
-					ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
-				},
-				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
-			podRestartNode := NewNode("pod-restart-node").WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).Create()
-			upgradeFailedNode := NewNode("upgrade-failed-node").WithUpgradeState(upgrade.UpgradeStateFailed).Create()
+		It("UpgradeStateManager should detect driver downgrades and handle them with the downgrade policy", func() {
+			namespace := createNamespace(fmt.Sprintf("namespace-%s", id))
+			dsLabels := map[string]string{"app": fmt.Sprintf("driver-%s", id)}
+			daemonSet := &appsv1.DaemonSet{
+				ObjectMeta: v1.ObjectMeta{Name: "driver", Namespace: namespace.Name},
+				Spec:       appsv1.DaemonSetSpec{Selector: &v1.LabelSelector{MatchLabels: dsLabels}},
+			}
+			// the daemonset was rolled back to the revision created before the one of the driver pods
+			for _, revision := range []appsv1.ControllerRevision{
+				{ObjectMeta: v1.ObjectMeta{Name: "driver-old", CreationTimestamp: v1.NewTime(time.Now().Add(-time.Hour))},
+					Revision: 3},
+				{ObjectMeta: v1.ObjectMeta{Name: "driver-new", CreationTimestamp: v1.Now()}, Revision: 2},
+			} {
+				revision.Namespace = namespace.Name
+				revision.Labels = dsLabels
+				_, err := k8sInterface.AppsV1().ControllerRevisions(namespace.Name).Create(ctx, &revision, v1.CreateOptions{})
+				Expect(err).To(Succeed())
+				time.Sleep(time.Second)
+			}
+			newPod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "new"}}}
+			unknownPod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "unknown"}}}
+
+			downgradeNode := NewNode(fmt.Sprintf("downgrade-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node
+			approvedNode := NewNode(fmt.Sprintf("approved-downgrade-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).
+				WithAnnotations(map[string]string{upgrade.GetUpgradeDowngradeApprovedAnnotationKey(): "true"}).
+				Node
+			upgradeNode := NewNode(fmt.Sprintf("upgrade-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).Node
+			downgradeDrainNode := NewNode(fmt.Sprintf("downgrade-drain-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+				WithAnnotations(map[string]string{upgrade.GetUpgradeDowngradeAnnotationKey(): "true"}).
+				Node
+			upgradeDrainNode := NewNode(fmt.Sprintf("upgrade-drain-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateDrainRequired).Node
 
 			clusterState := upgrade.NewClusterUpgradeState()
-			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{
-				{
-					Node:            podRestartNode,
-					DriverPod:       pod,
-					DriverDaemonSet: daemonSet,
-				},
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+				{Node: downgradeNode, DriverPod: newPod, DriverDaemonSet: daemonSet},
+				{Node: approvedNode, DriverPod: newPod, DriverDaemonSet: daemonSet},
+				{Node: upgradeNode, DriverPod: unknownPod, DriverDaemonSet: daemonSet},
 			}
-			clusterState.NodeStates[upgrade.UpgradeStateFailed] = []*upgrade.NodeUpgradeState{
-				{
-					Node:            upgradeFailedNode,
-					DriverPod:       pod,
-					DriverDaemonSet: daemonSet,
-				},
+			clusterState.NodeStates[upgrade.UpgradeStateDrainRequired] = []*upgrade.NodeUpgradeState{
+				{Node: downgradeDrainNode, DriverPod: newPod, DriverDaemonSet: daemonSet},
+				{Node: upgradeDrainNode, DriverPod: unknownPod, DriverDaemonSet: daemonSet},
 			}
-
 			policy := &v1alpha1.DriverUpgradePolicySpec{
 				AutoUpgrade: true,
+				DrainSpec:   &v1alpha1.DrainSpec{Enable: true},
+				Downgrade:   &v1alpha1.DowngradeSpec{SkipDrain: true, RequireApproval: true},
 			}
+			var drainedNodes []*corev1.Node
+			drainManagerMock := mocks.DrainManager{}
+			drainManagerMock.
+				On("ScheduleNodesDrain", mock.Anything, mock.Anything).
+				Return(func(ctx context.Context, config *upgrade.DrainConfiguration) error {
+					drainedNodes = config.Nodes
+					return nil
+				})
+			stateManager.DrainManager = &drainManagerMock
 
 			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
-			Expect(getNodeUpgradeState(podRestartNode)).To(Equal(upgrade.UpgradeStateUncordonRequired))
-			Expect(getNodeUpgradeState(upgradeFailedNode)).To(Equal(upgrade.UpgradeStateUncordonRequired))
+			Expect(upgrade.IsNodeDowngrade(downgradeNode)).To(BeTrue())
+			Expect(getNodeUpgradeState(downgradeNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(downgradeNode.Annotations[upgrade.GetUpgradeStateReasonAnnotationKey()]).To(
+				Equal(upgrade.UpgradeStateReasonDowngradeApprovalRequired))
+			Expect(upgrade.IsNodeDowngrade(approvedNode)).To(BeTrue())
+			Expect(getNodeUpgradeState(approvedNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(upgrade.IsNodeDowngrade(upgradeNode)).To(BeFalse())
+			Expect(getNodeUpgradeState(upgradeNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(downgradeDrainNode)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+			Expect(drainedNodes).To(ConsistOf(upgradeDrainNode))
 		})
 		It("UpgradeStateManager should move pod to UpgradeDone state "+
 			"if it's in PodRestart or UpgradeFailed, driver pod is up-to-date and ready, and node was initially Unschedulable", func() {
@@ -958,6 +2846,369 @@ var _ = Describe("UpgradeStateManager tests", func() {
 			Expect(getNodeUpgradeState(nodes[2])).To(Equal(upgrade.UpgradeStateFailed))
 			Expect(getNodeUpgradeState(nodes[3])).To(Equal(upgrade.UpgradeStateFailed))
 		})
+		It("UpgradeStateManager should wait for the node to become Ready after the driver pod restart "+
+			"and move it to UpgradeFailed state on timeout", func() {
+			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
+			pod := &corev1.Pod{
+				Status: corev1.PodStatus{
+					Phase:             "Running",
+					ContainerStatuses: []corev1.ContainerStatus{{Ready: false, RestartCount: 0}},
+				},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}},
+			}
+			notReady := []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}}
+
+			waitingNode := NewNode(fmt.Sprintf("waiting-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).
+				Node
+			waitingNode.Status.Conditions = notReady
+			timedOutNode := NewNode(fmt.Sprintf("timed-out-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).
+				WithAnnotations(map[string]string{
+					upgrade.GetNodeReadyWaitStartTimeAnnotationKey(): strconv.FormatInt(
+						time.Now().Add(-time.Hour).Unix(), 10),
+				}).
+				Node
+			timedOutNode.Status.Conditions = notReady
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{
+				{Node: waitingNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+				{Node: timedOutNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+			}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:            true,
+				NodeReadyTimeoutSecond: 600,
+			}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(waitingNode)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(waitingNode)).To(Equal(upgrade.UpgradeStateReasonWaitingForNodeReady))
+			Expect(waitingNode.Annotations).To(HaveKey(upgrade.GetNodeReadyWaitStartTimeAnnotationKey()))
+			Expect(getNodeUpgradeState(timedOutNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(timedOutNode.Annotations).NotTo(HaveKey(upgrade.GetNodeReadyWaitStartTimeAnnotationKey()))
+		})
+		It("UpgradeStateManager should only restart the driver pods of OnDelete DaemonSets and fail the nodes "+
+			"whose replacement driver pod is stuck Pending with the OnDelete rollout", func() {
+			onDeleteDaemonSet := &appsv1.DaemonSet{Spec: appsv1.DaemonSetSpec{
+				UpdateStrategy: appsv1.DaemonSetUpdateStrategy{Type: appsv1.OnDeleteDaemonSetStrategyType}}}
+			rollingUpdateDaemonSet := &appsv1.DaemonSet{Spec: appsv1.DaemonSetSpec{
+				UpdateStrategy: appsv1.DaemonSetUpdateStrategy{Type: appsv1.RollingUpdateDaemonSetStrategyType}}}
+			outdatedPod := func(nodeName string) *corev1.Pod {
+				return &corev1.Pod{
+					ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "old-hash"}},
+					Spec:       corev1.PodSpec{NodeName: nodeName},
+					Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+				}
+			}
+			onDeleteNode := NewNode(fmt.Sprintf("on-delete-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).Node
+			rollingUpdateNode := NewNode(fmt.Sprintf("rolling-update-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).Node
+			pendingNode := NewNode(fmt.Sprintf("pending-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).Node
+			pendingPod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{
+					Name:              "pending-driver-pod",
+					Labels:            map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"},
+					CreationTimestamp: v1.NewTime(time.Now().Add(-time.Hour)),
+				},
+				Status: corev1.PodStatus{
+					Phase: corev1.PodPending,
+					Conditions: []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionFalse,
+						Reason: corev1.PodReasonUnschedulable, Message: "Insufficient hugepages-2Mi"}},
+				},
+			}
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{
+				{Node: onDeleteNode, DriverPod: outdatedPod(onDeleteNode.Name), DriverDaemonSet: onDeleteDaemonSet},
+				{Node: rollingUpdateNode, DriverPod: outdatedPod(rollingUpdateNode.Name),
+					DriverDaemonSet: rollingUpdateDaemonSet},
+				{Node: pendingNode, DriverPod: pendingPod, DriverDaemonSet: onDeleteDaemonSet},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+
+			stateManager.WithOnDeleteRollout(10 * time.Minute)
+			result, err := stateManager.ApplyStateWithResult(ctx, &clusterState, policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.ScheduledActions).To(ConsistOf(
+				upgrade.ScheduledAction{Node: onDeleteNode.Name, Action: upgrade.UpgradeActionPodRestart}))
+			Expect(getNodeUpgradeState(rollingUpdateNode)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+			Expect(getNodeUpgradeState(pendingNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(upgrade.GetNodeUpgradeStateReason(pendingNode)).To(Equal(upgrade.UpgradeStateReasonDriverPodPending))
+		})
+		It("UpgradeStateManager should upgrade the nodes of the driver workloads and restart their driver pods "+
+			"with the workload", func() {
+			workload := &testDriverWorkload{targetVersion: "2.0"}
+			driverPod := func(nodeName, version string) *corev1.Pod {
+				return &corev1.Pod{
+					ObjectMeta: v1.ObjectMeta{Name: "driver-" + nodeName, Labels: map[string]string{"version": version}},
+					Spec:       corev1.PodSpec{NodeName: nodeName},
+					Status:     corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{{Ready: true}}},
+				}
+			}
+			upToDateNode := NewNode(fmt.Sprintf("up-to-date-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateDone).Node
+			outdatedNode := NewNode(fmt.Sprintf("outdated-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateDone).Node
+			restartNode := NewNode(fmt.Sprintf("restart-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).Node
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateDone] = []*upgrade.NodeUpgradeState{
+				{Node: upToDateNode, DriverPod: driverPod(upToDateNode.Name, "2.0"), DriverWorkload: workload},
+				{Node: outdatedNode, DriverPod: driverPod(outdatedNode.Name, "1.0"), DriverWorkload: workload},
+			}
+			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{
+				{Node: restartNode, DriverPod: driverPod(restartNode.Name, "1.0"), DriverWorkload: workload},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+
+			result, err := stateManager.ApplyStateWithResult(ctx, &clusterState, policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getNodeUpgradeState(upToDateNode)).To(Equal(upgrade.UpgradeStateDone))
+			Expect(getNodeUpgradeState(outdatedNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(workload.restartedPods).To(ConsistOf("driver-" + restartNode.Name))
+			Expect(result.ScheduledActions).To(ContainElement(
+				upgrade.ScheduledAction{Node: restartNode.Name, Action: upgrade.UpgradeActionPodRestart}))
+		})
+		It("UpgradeStateManager should uncordon the nodes once the uncordon checks pass "+
+			"and move them to UpgradeFailed state on timeout", func() {
+			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{}}
+			pod := &corev1.Pod{
+				Status: corev1.PodStatus{
+					Phase:             "Running",
+					ContainerStatuses: []corev1.ContainerStatus{{Ready: true}},
+				},
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}},
+			}
+			startedFailing := func() map[string]string {
+				return map[string]string{
+					upgrade.GetUncordonGateStartTimeAnnotationKey(): strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+				}
+			}
+			passingNode := NewNode(fmt.Sprintf("passing-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUncordonRequired).
+				WithAnnotations(startedFailing()).
+				Node
+			waitingNode := NewNode(fmt.Sprintf("waiting-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUncordonRequired).
+				Node
+			timedOutNode := NewNode(fmt.Sprintf("timed-out-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUncordonRequired).
+				WithAnnotations(startedFailing()).
+				Node
+			timedOutNode.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}}
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/"+passingNode.Name {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+			stateManager.WithUncordonGate(10*time.Minute, upgrade.NewHTTPUncordonCheck(server.Client(),
+				func(nodeState *upgrade.NodeUpgradeState) string {
+					return server.URL + "/" + nodeState.Node.Name
+				}))
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUncordonRequired] = []*upgrade.NodeUpgradeState{
+				{Node: passingNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+				{Node: waitingNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+				{Node: timedOutNode, DriverPod: pod, DriverDaemonSet: daemonSet},
+			}
+
+			Expect(stateManager.ProcessUncordonRequiredNodes(ctx, &clusterState)).To(Succeed())
+			Expect(getNodeUpgradeState(passingNode)).To(Equal(upgrade.UpgradeStateDone))
+			Expect(passingNode.Annotations).NotTo(HaveKey(upgrade.GetUncordonGateStartTimeAnnotationKey()))
+			Expect(getNodeUpgradeState(waitingNode)).To(Equal(upgrade.UpgradeStateUncordonRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(waitingNode)).To(Equal(upgrade.UpgradeStateReasonUncordonCheckFailed))
+			Expect(waitingNode.Annotations).To(HaveKey(upgrade.GetUncordonGateStartTimeAnnotationKey()))
+			Expect(getNodeUpgradeState(timedOutNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(upgrade.GetNodeUpgradeStateReason(timedOutNode)).To(Equal(upgrade.UpgradeStateReasonUncordonCheckFailed))
+			Expect(timedOutNode.Annotations).NotTo(HaveKey(upgrade.GetUncordonGateStartTimeAnnotationKey()))
+		})
+		It("UpgradeStateManager should validate the nodes with the validation Jobs "+
+			"and move them to UpgradeFailed state if a Job fails or times out", func() {
+			template := &batchv1.JobTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default"},
+				Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{{Name: "smoke-test", Image: "smoke-test:latest"}},
+				}}},
+			}
+			stateManager.WithValidators(upgrade.NewJobValidator(k8sInterface, "smoke-test", template))
+			succeededNode := NewNode(fmt.Sprintf("succeeded-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateValidationRequired).
+				Create()
+			failedNode := NewNode(fmt.Sprintf("failed-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateValidationRequired).
+				Create()
+			timedOutNode := NewNode(fmt.Sprintf("timed-out-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateValidationRequired).
+				WithAnnotations(map[string]string{
+					upgrade.GetValidatorsStartTimeAnnotationKey(): strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+				}).
+				Create()
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateValidationRequired] = []*upgrade.NodeUpgradeState{
+				{Node: succeededNode}, {Node: failedNode}, {Node: timedOutNode},
+			}
+			getJob := func(node *corev1.Node) (*batchv1.Job, error) {
+				return k8sInterface.BatchV1().Jobs("default").Get(ctx,
+					fmt.Sprintf("%s-driver-upgrade-smoke-test-%s", upgrade.DriverName, node.Name), v1.GetOptions{})
+			}
+
+			Expect(stateManager.ProcessValidationRequiredNodes(ctx, &clusterState)).To(Succeed())
+			Expect(getNodeUpgradeState(succeededNode)).To(Equal(upgrade.UpgradeStateValidationRequired))
+			Expect(getNodeUpgradeState(failedNode)).To(Equal(upgrade.UpgradeStateValidationRequired))
+			Expect(getNodeUpgradeState(timedOutNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(upgrade.GetNodeUpgradeStateReason(timedOutNode)).To(Equal(upgrade.UpgradeStateReasonValidationFailed))
+			Expect(timedOutNode.Annotations).NotTo(HaveKey(upgrade.GetValidatorsStartTimeAnnotationKey()))
+			_, err := getJob(timedOutNode)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+			for node, conditionType := range map[*corev1.Node]batchv1.JobConditionType{
+				succeededNode: batchv1.JobComplete,
+				failedNode:    batchv1.JobFailed,
+			} {
+				job, err := getJob(node)
+				Expect(err).NotTo(HaveOccurred())
+				required := job.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+				Expect(required.NodeSelectorTerms[0].MatchFields[0].Values).To(ConsistOf(node.Name))
+				job.Status.StartTime = &v1.Time{Time: time.Now()}
+				job.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue}}
+				if conditionType == batchv1.JobComplete {
+					job.Status.CompletionTime = &v1.Time{Time: time.Now()}
+					job.Status.Succeeded = 1
+				} else {
+					job.Status.Failed = 1
+				}
+				_, err = k8sInterface.BatchV1().Jobs("default").UpdateStatus(ctx, job, v1.UpdateOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			clusterState.NodeStates[upgrade.UpgradeStateValidationRequired] = []*upgrade.NodeUpgradeState{
+				{Node: succeededNode}, {Node: failedNode},
+			}
+			Expect(stateManager.ProcessValidationRequiredNodes(ctx, &clusterState)).To(Succeed())
+			Expect(getNodeUpgradeState(succeededNode)).To(Equal(upgrade.UpgradeStateUncordonRequired))
+			Expect(succeededNode.Annotations).NotTo(HaveKey(upgrade.GetValidatorsStartTimeAnnotationKey()))
+			Expect(getNodeUpgradeState(failedNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(upgrade.GetNodeUpgradeStateReason(failedNode)).To(Equal(upgrade.UpgradeStateReasonValidationFailed))
+			for _, node := range []*corev1.Node{succeededNode, failedNode} {
+				_, err = getJob(node)
+				Expect(apierrors.IsNotFound(err)).To(BeTrue())
+			}
+		})
+		It("UpgradeStateManager should reboot the nodes before the driver pod restart "+
+			"and move them to UpgradeFailed state if they are not back within the timeout", func() {
+			template := &batchv1.JobTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Namespace: "default"},
+				Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{{Name: "reboot", Image: "reboot:latest"}},
+				}}},
+			}
+			stateManager.WithNodeReboot(upgrade.NewJobNodeRebooter(k8sInterface, template),
+				func(_ context.Context, nodeState *upgrade.NodeUpgradeState) (bool, error) {
+					return true, nil
+				}, 10*time.Minute)
+			rebootNode := NewNode(fmt.Sprintf("reboot-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).
+				Node
+			rebootNode.Status.NodeInfo.BootID = "boot-1"
+			timedOutNode := NewNode(fmt.Sprintf("timed-out-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateRebootRequired).
+				WithAnnotations(map[string]string{
+					upgrade.GetRebootBootIDAnnotationKey():    "boot-1",
+					upgrade.GetRebootStartTimeAnnotationKey(): strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+				}).
+				Node
+			timedOutNode.Status.NodeInfo.BootID = "boot-1"
+			getJob := func() (*batchv1.Job, error) {
+				return k8sInterface.BatchV1().Jobs("default").Get(ctx,
+					fmt.Sprintf("%s-driver-upgrade-reboot-%s", upgrade.DriverName, rebootNode.Name), v1.GetOptions{})
+			}
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{
+				{Node: rebootNode},
+			}
+			Expect(stateManager.ProcessPodRestartNodes(ctx, &clusterState)).To(Succeed())
+			Expect(getNodeUpgradeState(rebootNode)).To(Equal(upgrade.UpgradeStateRebootRequired))
+
+			clusterState = upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateRebootRequired] = []*upgrade.NodeUpgradeState{
+				{Node: rebootNode}, {Node: timedOutNode},
+			}
+			Expect(stateManager.ProcessRebootRequiredNodes(ctx, &clusterState)).To(Succeed())
+			Expect(getNodeUpgradeState(rebootNode)).To(Equal(upgrade.UpgradeStateRebootRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(rebootNode)).To(Equal(upgrade.UpgradeStateReasonWaitingForReboot))
+			Expect(rebootNode.Annotations).To(HaveKeyWithValue(upgrade.GetRebootBootIDAnnotationKey(), "boot-1"))
+			job, err := getJob()
+			Expect(err).NotTo(HaveOccurred())
+			required := job.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+			Expect(required.NodeSelectorTerms[0].MatchFields[0].Values).To(ConsistOf(rebootNode.Name))
+			Expect(getNodeUpgradeState(timedOutNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(upgrade.GetNodeUpgradeStateReason(timedOutNode)).To(Equal(upgrade.UpgradeStateReasonRebootTimeout))
+			Expect(timedOutNode.Annotations).NotTo(HaveKey(upgrade.GetRebootStartTimeAnnotationKey()))
+
+			// the node rebooted, but is not Ready yet
+			clusterState.NodeStates[upgrade.UpgradeStateRebootRequired] = []*upgrade.NodeUpgradeState{{Node: rebootNode}}
+			rebootNode.Status.NodeInfo.BootID = "boot-2"
+			rebootNode.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}}
+			Expect(stateManager.ProcessRebootRequiredNodes(ctx, &clusterState)).To(Succeed())
+			Expect(getNodeUpgradeState(rebootNode)).To(Equal(upgrade.UpgradeStateRebootRequired))
+
+			rebootNode.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+			Expect(stateManager.ProcessRebootRequiredNodes(ctx, &clusterState)).To(Succeed())
+			Expect(getNodeUpgradeState(rebootNode)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+			Expect(rebootNode.Annotations).NotTo(HaveKey(upgrade.GetRebootStartTimeAnnotationKey()))
+			// the node is rebooted once per upgrade
+			Expect(rebootNode.Annotations).To(HaveKey(upgrade.GetRebootBootIDAnnotationKey()))
+			_, err = getJob()
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+		It("UpgradeStateManager should delegate the cordon and the drain of the nodes to the maintenance operator", func() {
+			config := upgrade.NewMaintenanceOperatorConfig("default", "driver-operator")
+			restMapper := meta.NewDefaultRESTMapper(nil)
+			restMapper.Add(config.GroupVersionKind, meta.RESTScopeNamespace)
+			stateManager.K8sClient = fakeclient.NewClientBuilder().WithRESTMapper(restMapper).Build()
+			stateManager.WithNodeMaintenance(config)
+			node := NewNode(fmt.Sprintf("node-%s", id)).WithUpgradeState(upgrade.UpgradeStateCordonRequired).Node
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			maintenance := &unstructured.Unstructured{}
+			maintenance.SetGroupVersionKind(config.GroupVersionKind)
+			maintenanceKey := types.NamespacedName{Namespace: "default",
+				Name: fmt.Sprintf("%s-driver-upgrade-%s", upgrade.DriverName, node.Name)}
+
+			Expect(stateManager.ProcessCordonRequiredNodes(ctx, &clusterState)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(node)).To(Equal(upgrade.UpgradeStateReasonWaitingForNodeMaintenance))
+			Expect(node.Spec.Unschedulable).To(BeFalse())
+			Expect(stateManager.K8sClient.Get(ctx, maintenanceKey, maintenance)).To(Succeed())
+			Expect(maintenance.Object["spec"]).To(HaveKeyWithValue("nodeName", node.Name))
+			Expect(maintenance.Object["spec"]).To(HaveKeyWithValue("requestorID", "driver-operator"))
+
+			// the maintenance operator cordoned and drained the node
+			Expect(unstructured.SetNestedSlice(maintenance.Object, []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+			}, "status", "conditions")).To(Succeed())
+			Expect(stateManager.K8sClient.Update(ctx, maintenance)).To(Succeed())
+			Expect(stateManager.ProcessCordonRequiredNodes(ctx, &clusterState)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+
+			clusterState = upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUncordonRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			Expect(stateManager.ProcessUncordonRequiredNodes(ctx, &clusterState)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateDone))
+			err := stateManager.K8sClient.Get(ctx, maintenanceKey, maintenance)
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
 		It("UpgradeStateManager should move pod to UpgradeValidationRequired state "+
 			"if it's in PodRestart, driver pod is up-to-date and ready, and validation is enabled", func() {
 			ctx := context.TODO()
@@ -970,30 +3221,460 @@ var _ = Describe("UpgradeStateManager tests", func() {
 				},
 				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}},
 			}
-			podRestartNode := NewNode(fmt.Sprintf("node1-%s", id)).
-				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).
-				Create()
+			podRestartNode := NewNode(fmt.Sprintf("node1-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStatePodRestartRequired).
+				Create()
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{
+				{
+					Node:            podRestartNode,
+					DriverPod:       pod,
+					DriverDaemonSet: daemonSet,
+				},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+
+			stateManager = stateManager.WithValidationEnabled("app=validator").(*upgrade.ClusterUpgradeStateManagerImpl)
+			Expect(stateManager.IsValidationEnabled()).To(Equal(true))
+			// do not mock NodeUpgradeStateProvider as it is used during ProcessUpgradeValidationRequiredNodes()
+			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			stateManager.NodeUpgradeStateProvider = provider
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(podRestartNode)).To(Equal(upgrade.UpgradeStateValidationRequired))
+		})
+		It("UpgradeStateManager should move nodes stuck in an upgrade state to UpgradeFailed", func() {
+			startTimeKey := upgrade.GetUpgradeStateStartTimeAnnotationKey()
+			expiredStart := fmt.Sprintf("%s/%d", upgrade.UpgradeStateWaitForJobsRequired, time.Now().Unix()-120)
+			stuckNode := NewNode(fmt.Sprintf("stuck-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateWaitForJobsRequired).
+				WithAnnotations(map[string]string{startTimeKey: expiredStart}).
+				Create()
+			// the start time of the previous state is not taken into account
+			previousStateStart := fmt.Sprintf("%s/%d", upgrade.UpgradeStateCordonRequired, time.Now().Unix()-120)
+			newNode := NewNode(fmt.Sprintf("new-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateWaitForJobsRequired).
+				WithAnnotations(map[string]string{startTimeKey: previousStateStart}).
+				Create()
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateWaitForJobsRequired] = []*upgrade.NodeUpgradeState{
+				{Node: stuckNode, DriverPod: &corev1.Pod{}, DriverDaemonSet: &appsv1.DaemonSet{}},
+				{Node: newNode, DriverPod: &corev1.Pod{}, DriverDaemonSet: &appsv1.DaemonSet{}},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:             true,
+				WaitForCompletion:       &v1alpha1.WaitForCompletionSpec{PodSelector: "app=never"},
+				NodeStateTimeoutSeconds: map[string]int{upgrade.UpgradeStateWaitForJobsRequired: 60},
+			}
+			podManagerMock := mocks.PodManager{}
+			podManagerMock.
+				On("ScheduleCheckOnPodCompletion", mock.Anything, mock.Anything).
+				Return(nil).
+				On("SchedulePodsRestart", mock.Anything, mock.Anything).
+				Return(nil)
+			stateManager.PodManager = &podManagerMock
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(stuckNode)).To(Equal(upgrade.UpgradeStateFailed))
+			Expect(stuckNode.Annotations[upgrade.GetUpgradeStateReasonAnnotationKey()]).To(
+				Equal(upgrade.UpgradeStateReasonStateTimeout))
+			Expect(getNodeUpgradeState(newNode)).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+			Expect(newNode.Annotations[startTimeKey]).To(HavePrefix(upgrade.UpgradeStateWaitForJobsRequired + "/"))
+
+			policy.NodeStateTimeoutSeconds = map[string]int{upgrade.UpgradeStateUpgradeRequired: 60}
+			Expect(stateManager.ValidateUpgradePolicy(policy)).NotTo(Succeed())
+		})
+		It("UpgradeStateManager should annotate UpgradeRequired nodes with the expected upgrade impact", func() {
+			node := NewNode(fmt.Sprintf("impact-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).
+				Create()
+			namespace := createNamespace(fmt.Sprintf("namespace-%s", id))
+			_ = NewPod(fmt.Sprintf("gpu-pod-%s", id), namespace.Name, node.Name).
+				WithResource("nvidia.com/gpu", "1").Create()
+			_ = NewPod(fmt.Sprintf("cpu-pod-%s", id), namespace.Name, node.Name).Create()
+			completedPod := NewPod(fmt.Sprintf("completed-gpu-pod-%s", id), namespace.Name, node.Name).
+				WithResource("nvidia.com/gpu", "1").Create()
+			completedPod.Status.Phase = corev1.PodSucceeded
+			Expect(updatePodStatus(completedPod)).To(Succeed())
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+				{Node: node, DriverPod: &corev1.Pod{}, DriverDaemonSet: &appsv1.DaemonSet{}},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(node.Annotations).NotTo(HaveKey(upgrade.GetUpgradeImpactAnnotationKey()))
+
+			stateManager.WithPodDeletionEnabled(gpuPodSpecFilter)
+			stateManager.WithUpgradeImpactAnnotation(true)
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			impact, err := upgrade.GetNodeUpgradeImpact(node)
+			Expect(err).To(Succeed())
+			Expect(impact).To(Equal(&upgrade.NodeUpgradeImpact{
+				RunningPods:         2,
+				WorkloadPods:        1,
+				ExpectedPodRestarts: 2,
+			}))
+		})
+		It("UpgradeStateManager should record the upgrade history of the nodes", func() {
+			historyKey := upgrade.GetUpgradeHistoryAnnotationKey()
+			inProgress := `[{"startTime":"2026-01-01T00:00:00Z","result":"InProgress"}]`
+			startedNode := NewNode(fmt.Sprintf("started-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUpgradeRequired).
+				WithAnnotations(map[string]string{historyKey: `[{"startTime":"2025-01-01T00:00:00Z","result":"Failed"},` +
+					`{"startTime":"2025-02-01T00:00:00Z","result":"Succeeded"}]`}).
+				Create()
+			doneNode := NewNode(fmt.Sprintf("done-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUncordonRequired).
+				WithAnnotations(map[string]string{historyKey: inProgress}).
+				Create()
+			failedNode := NewNode(fmt.Sprintf("failed-node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateUncordonRequired).
+				WithAnnotations(map[string]string{historyKey: inProgress,
+					upgrade.GetUpgradeStateStartTimeAnnotationKey(): fmt.Sprintf("%s/%d",
+						upgrade.UpgradeStateUncordonRequired, time.Now().Unix()-120)}).
+				Create()
+			driverPod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "driver", Image: "nvcr.io/driver:1.0.0"}}}}
+			driverDaemonSet := &appsv1.DaemonSet{Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "driver", Image: "nvcr.io/driver:2.0.0"}}}}}}
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+				{Node: startedNode, DriverPod: driverPod, DriverDaemonSet: driverDaemonSet},
+			}
+			clusterState.NodeStates[upgrade.UpgradeStateUncordonRequired] = []*upgrade.NodeUpgradeState{
+				{Node: doneNode, DriverPod: driverPod, DriverDaemonSet: driverDaemonSet},
+				{Node: failedNode, DriverPod: driverPod, DriverDaemonSet: driverDaemonSet},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:             true,
+				NodeStateTimeoutSeconds: map[string]int{upgrade.UpgradeStateUncordonRequired: 60},
+			}
+			stateManager.NodeUpgradeStateProvider = upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+			stateManager.WithNodeUpgradeHistory(2)
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(startedNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			history, err := upgrade.GetNodeUpgradeHistory(startedNode)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(2))
+			Expect(history[0].Result).To(Equal(upgrade.NodeUpgradeSucceeded))
+			Expect(history[1].Result).To(Equal(upgrade.NodeUpgradeInProgress))
+			Expect(history[1].StartTime.IsZero()).To(BeFalse())
+			Expect(history[1].EndTime).To(BeNil())
+			Expect(history[1].FromVersion).To(Equal("1.0.0"))
+			Expect(history[1].ToVersion).To(Equal("2.0.0"))
+
+			Expect(getNodeUpgradeState(doneNode)).To(Equal(upgrade.UpgradeStateDone))
+			history, err = upgrade.GetNodeUpgradeHistory(doneNode)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(1))
+			Expect(history[0].Result).To(Equal(upgrade.NodeUpgradeSucceeded))
+			Expect(history[0].EndTime).NotTo(BeNil())
+
+			Expect(getNodeUpgradeState(failedNode)).To(Equal(upgrade.UpgradeStateFailed))
+			history, err = upgrade.GetNodeUpgradeHistory(failedNode)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(history).To(HaveLen(1))
+			Expect(history[0].Result).To(Equal(upgrade.NodeUpgradeFailed))
+			Expect(history[0].FailedState).To(Equal(upgrade.UpgradeStateUncordonRequired))
+			Expect(history[0].FailureReason).To(Equal(upgrade.UpgradeStateReasonStateTimeout))
+		})
+		It("UpgradeStateManager should start the nodes whose drain is allowed by the PodDisruptionBudgets first", func() {
+			namespace := createNamespace(fmt.Sprintf("pdb-selection-%s", id))
+			blockedNode := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+			blockedNode.Name = fmt.Sprintf("pdb-blocked-node-%s", id)
+			allowedNode := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+			allowedNode.Name = fmt.Sprintf("pdb-allowed-node-%s", id)
+			_ = createPod("protected-pod", namespace.Name, map[string]string{"app": "protected"}, blockedNode.Name)
+			_ = createPod("unprotected-pod", namespace.Name, map[string]string{"app": "unprotected"}, allowedNode.Name)
+			// the budget allows no disruption
+			createBlockingPDB(namespace.Name, map[string]string{"app": "protected"})
+
+			newClusterState := func() *upgrade.ClusterUpgradeState {
+				clusterState := upgrade.NewClusterUpgradeState()
+				clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+					{Node: blockedNode}, {Node: allowedNode},
+				}
+				return &clusterState
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 1,
+				DrainSpec:           &v1alpha1.DrainSpec{Enable: true},
+				PDBAwareSelection:   &v1alpha1.PDBAwareSelectionSpec{},
+			}
+			Expect(stateManager.ApplyState(ctx, newClusterState(), policy)).To(Succeed())
+			Expect(getNodeUpgradeState(allowedNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(blockedNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(blockedNode)).To(Equal(upgrade.UpgradeStateReasonWaitingForSlot))
+
+			// the blocked node is deferred even if there is a free slot
+			allowedNode.Labels[upgrade.GetUpgradeStateLabelKey()] = upgrade.UpgradeStateUpgradeRequired
+			policy.MaxParallelUpgrades = 2
+			policy.PDBAwareSelection.DeferBlockedNodes = true
+			Expect(stateManager.ApplyState(ctx, newClusterState(), policy)).To(Succeed())
+			Expect(getNodeUpgradeState(allowedNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(blockedNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(blockedNode)).To(Equal(upgrade.UpgradeStateReasonWaitingForPDB))
+		})
+		It("UpgradeStateManager should not start the upgrade of nodes with the conditions or taints "+
+			"of the node health selection", func() {
+			pressuredNode := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+			pressuredNode.Name = fmt.Sprintf("pressured-node-%s", id)
+			pressuredNode.Status.Conditions = []corev1.NodeCondition{
+				{Type: corev1.NodeDiskPressure, Status: corev1.ConditionTrue}}
+			taintedNode := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+			taintedNode.Name = fmt.Sprintf("tainted-node-%s", id)
+			taintedNode.Spec.Taints = []corev1.Taint{{Key: "example.com/wedged", Effect: corev1.TaintEffectNoSchedule}}
+			healthyNode := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+			healthyNode.Name = fmt.Sprintf("healthy-node-%s", id)
+			healthyNode.Status.Conditions = []corev1.NodeCondition{
+				{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse}}
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+				{Node: pressuredNode}, {Node: taintedNode}, {Node: healthyNode},
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+				NodeHealthSelection: &v1alpha1.NodeHealthSelectionSpec{
+					Conditions: []corev1.NodeConditionType{corev1.NodeDiskPressure, corev1.NodeMemoryPressure},
+					TaintKeys:  []string{"example.com/wedged"},
+				},
+			}
+			result, err := stateManager.ApplyStateWithResult(ctx, &clusterState, policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getNodeUpgradeState(healthyNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(pressuredNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(getNodeUpgradeState(taintedNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(result.SkippedNodes).To(ConsistOf(
+				upgrade.SkippedNode{Node: pressuredNode.Name, State: upgrade.UpgradeStateUpgradeRequired,
+					Reason: upgrade.UpgradeStateReasonNodeUnhealthy},
+				upgrade.SkippedNode{Node: taintedNode.Name, State: upgrade.UpgradeStateUpgradeRequired,
+					Reason: upgrade.UpgradeStateReasonNodeUnhealthy},
+			))
+		})
+		It("UpgradeStateManager should hold the drain of the nodes evicting the only ready replica of a workload", func() {
+			namespace := createNamespace(fmt.Sprintf("single-replica-%s", id))
+			singleReplicaNode := nodeWithUpgradeState(upgrade.UpgradeStateDrainRequired)
+			singleReplicaNode.Name = fmt.Sprintf("single-replica-node-%s", id)
+			otherNode := nodeWithUpgradeState(upgrade.UpgradeStateDrainRequired)
+			otherNode.Name = fmt.Sprintf("other-node-%s", id)
+
+			podLabels := map[string]string{"app": "single-replica"}
+			podTemplate := corev1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{Labels: podLabels},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "test-container", Image: "test-image"}}},
+			}
+			deployment := &appsv1.Deployment{
+				ObjectMeta: v1.ObjectMeta{Name: "single-replica", Namespace: namespace.Name},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &v1.LabelSelector{MatchLabels: podLabels},
+					Template: podTemplate,
+				},
+			}
+			Expect(k8sClient.Create(ctx, deployment)).To(Succeed())
+			createdObjects = append(createdObjects, deployment)
+			deployment.Status.Replicas = 1
+			deployment.Status.ReadyReplicas = 1
+			Expect(k8sClient.Status().Update(ctx, deployment)).To(Succeed())
+			replicaSet := &appsv1.ReplicaSet{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      "single-replica-rs",
+					Namespace: namespace.Name,
+					OwnerReferences: []v1.OwnerReference{
+						*v1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment")),
+					},
+				},
+				Spec: appsv1.ReplicaSetSpec{
+					Selector: &v1.LabelSelector{MatchLabels: podLabels},
+					Template: podTemplate,
+				},
+			}
+			Expect(k8sClient.Create(ctx, replicaSet)).To(Succeed())
+			createdObjects = append(createdObjects, replicaSet)
+			pod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      "single-replica-pod",
+					Namespace: namespace.Name,
+					Labels:    podLabels,
+					OwnerReferences: []v1.OwnerReference{
+						*v1.NewControllerRef(replicaSet, appsv1.SchemeGroupVersion.WithKind("ReplicaSet")),
+					},
+				},
+				Spec: corev1.PodSpec{
+					NodeName:   singleReplicaNode.Name,
+					Containers: podTemplate.Spec.Containers,
+				},
+			}
+			Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+			createdObjects = append(createdObjects, pod)
+			pod.Status.Phase = corev1.PodRunning
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "test-container", Ready: true}}
+			_ = updatePodStatus(pod)
+
+			var drainedNodes []*corev1.Node
+			drainManagerMock := mocks.DrainManager{}
+			drainManagerMock.
+				On("ScheduleNodesDrain", mock.Anything, mock.Anything).
+				Return(func(ctx context.Context, config *upgrade.DrainConfiguration) error {
+					drainedNodes = config.Nodes
+					return nil
+				})
+			stateManager.DrainManager = &drainManagerMock
+			newClusterState := func() *upgrade.ClusterUpgradeState {
+				clusterState := upgrade.NewClusterUpgradeState()
+				clusterState.NodeStates[upgrade.UpgradeStateDrainRequired] = []*upgrade.NodeUpgradeState{
+					{Node: singleReplicaNode}, {Node: otherNode},
+				}
+				return &clusterState
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+				DrainSpec: &v1alpha1.DrainSpec{
+					Enable:              true,
+					SingleReplicaPolicy: v1alpha1.SingleReplicaPolicyRequireApproval,
+				},
+			}
+			Expect(stateManager.ApplyState(ctx, newClusterState(), policy)).To(Succeed())
+			Expect(drainedNodes).To(ConsistOf(otherNode))
+			Expect(upgrade.GetNodeUpgradeStateReason(singleReplicaNode)).To(
+				Equal(upgrade.UpgradeStateReasonDrainApprovalRequired))
+
+			singleReplicaNode.Annotations[upgrade.GetUpgradeDrainApprovedAnnotationKey()] = "true"
+			Expect(stateManager.ApplyState(ctx, newClusterState(), policy)).To(Succeed())
+			Expect(drainedNodes).To(ConsistOf(singleReplicaNode, otherNode))
+
+			// the approval doesn't apply to the scale-up policy
+			policy.DrainSpec.SingleReplicaPolicy = v1alpha1.SingleReplicaPolicyWaitForScaleUp
+			Expect(stateManager.ApplyState(ctx, newClusterState(), policy)).To(Succeed())
+			Expect(drainedNodes).To(ConsistOf(otherNode))
+			Expect(upgrade.GetNodeUpgradeStateReason(singleReplicaNode)).To(
+				Equal(upgrade.UpgradeStateReasonWaitingForScaleUp))
+
+			deployment.Status.Replicas = 2
+			deployment.Status.ReadyReplicas = 2
+			Expect(k8sClient.Status().Update(ctx, deployment)).To(Succeed())
+			Expect(stateManager.ApplyState(ctx, newClusterState(), policy)).To(Succeed())
+			Expect(drainedNodes).To(ConsistOf(singleReplicaNode, otherNode))
+		})
+		It("UpgradeStateManager should upgrade the canary nodes first and the other nodes after the soak period", func() {
+			canaryNode := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+			canaryNode.Name = fmt.Sprintf("canary-node-%s", id)
+			canaryNode.Labels["canary"] = "true"
+			otherNodes := []*corev1.Node{
+				nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired),
+				nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired),
+			}
+			otherNodes[0].Name = fmt.Sprintf("a-node-%s", id)
+			otherNodes[1].Name = fmt.Sprintf("b-node-%s", id)
+			upToDatePod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}},
+				Status: corev1.PodStatus{Phase: corev1.PodRunning,
+					ContainerStatuses: []corev1.ContainerStatus{{Ready: true}}},
+			}
+			newClusterState := func() *upgrade.ClusterUpgradeState {
+				clusterState := upgrade.NewClusterUpgradeState()
+				for _, node := range append([]*corev1.Node{canaryNode}, otherNodes...) {
+					state := upgrade.LabelStateStorage{}.GetState(node)
+					clusterState.NodeStates[state] = append(clusterState.NodeStates[state], &upgrade.NodeUpgradeState{
+						Node: node, DriverPod: upToDatePod, DriverDaemonSet: &appsv1.DaemonSet{}})
+				}
+				return &clusterState
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 0,
+				MaxUnavailable:      &intstr.IntOrString{Type: intstr.String, StrVal: "100%"},
+				Canary:              &v1alpha1.CanarySpec{NodeSelector: "canary=true", Count: 1, SoakSeconds: 3600},
+			}
+
+			Expect(stateManager.ApplyState(ctx, newClusterState(), policy)).To(Succeed())
+			Expect(getNodeUpgradeState(canaryNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			for _, node := range otherNodes {
+				Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+				Expect(upgrade.GetNodeUpgradeStateReason(node)).To(Equal(upgrade.UpgradeStateReasonWaitingForCanary))
+			}
 
+			// the soak period starts once the canary node is done with a ready driver pod
+			canaryNode.Labels[upgrade.GetUpgradeStateLabelKey()] = upgrade.UpgradeStateDone
+			result, err := stateManager.ApplyStateWithResult(ctx, newClusterState(), policy)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(canaryNode.Annotations).To(HaveKey(upgrade.GetUpgradeCanarySoakStartTimeAnnotationKey()))
+			for _, node := range otherNodes {
+				Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			}
+			Expect(result.RequeueReason).To(Equal(upgrade.RequeueReasonCanarySoak))
+
+			// the other nodes are upgraded once the soak period is over
+			canaryNode.Annotations[upgrade.GetUpgradeCanarySoakStartTimeAnnotationKey()] =
+				strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+			Expect(stateManager.ApplyState(ctx, newClusterState(), policy)).To(Succeed())
+			for _, node := range otherNodes {
+				Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			}
+		})
+		It("UpgradeStateManager should limit the parallel upgrades per zone", func() {
+			newZoneNode := func(name, zone, state string) *corev1.Node {
+				node := nodeWithUpgradeState(state)
+				node.Name = fmt.Sprintf("%s-%s", name, id)
+				node.Labels[corev1.LabelTopologyZone] = zone
+				return node
+			}
+			drainingNode := newZoneNode("zone-a-draining", "zone-a", upgrade.UpgradeStateDrainRequired)
+			zoneANode := newZoneNode("zone-a-pending", "zone-a", upgrade.UpgradeStateUpgradeRequired)
+			zoneBNodes := []*corev1.Node{
+				newZoneNode("zone-b-pending-1", "zone-b", upgrade.UpgradeStateUpgradeRequired),
+				newZoneNode("zone-b-pending-2", "zone-b", upgrade.UpgradeStateUpgradeRequired),
+			}
 			clusterState := upgrade.NewClusterUpgradeState()
-			clusterState.NodeStates[upgrade.UpgradeStatePodRestartRequired] = []*upgrade.NodeUpgradeState{
-				{
-					Node:            podRestartNode,
-					DriverPod:       pod,
-					DriverDaemonSet: daemonSet,
-				},
+			clusterState.NodeStates[upgrade.UpgradeStateDrainRequired] = []*upgrade.NodeUpgradeState{{Node: drainingNode}}
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+				{Node: zoneANode}, {Node: zoneBNodes[0]}, {Node: zoneBNodes[1]},
 			}
 			policy := &v1alpha1.DriverUpgradePolicySpec{
-				AutoUpgrade: true,
+				AutoUpgrade:                true,
+				MaxParallelUpgrades:        0,
+				MaxParallelUpgradesPerZone: 1,
+				DrainSpec:                  &v1alpha1.DrainSpec{Enable: true},
 			}
 
-			stateManager = stateManager.WithValidationEnabled("app=validator").(*upgrade.ClusterUpgradeStateManagerImpl)
-			Expect(stateManager.IsValidationEnabled()).To(Equal(true))
-			// do not mock NodeUpgradeStateProvider as it is used during ProcessUpgradeValidationRequiredNodes()
-			provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
-			stateManager.NodeUpgradeStateProvider = provider
-
 			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
-			Expect(getNodeUpgradeState(podRestartNode)).To(Equal(upgrade.UpgradeStateValidationRequired))
+			// the draining node takes the slot of zone-a
+			Expect(getNodeUpgradeState(zoneANode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(zoneANode)).To(Equal(upgrade.UpgradeStateReasonWaitingForZoneSlot))
+			Expect(getNodeUpgradeState(zoneBNodes[0])).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(zoneBNodes[1])).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			Expect(upgrade.GetNodeUpgradeStateReason(zoneBNodes[1])).To(
+				Equal(upgrade.UpgradeStateReasonWaitingForZoneSlot))
+		})
+		It("UpgradeStateManager should start the nodes in the order of the NodeSortPolicy", func() {
+			zoneNodes := map[string]*corev1.Node{}
+			for _, name := range []string{"zone-a-1", "zone-a-2", "zone-b-1"} {
+				node := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+				node.Name = fmt.Sprintf("%s-%s", name, id)
+				node.Labels[corev1.LabelTopologyZone] = name[:len("zone-a")]
+				zoneNodes[name] = node
+			}
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = []*upgrade.NodeUpgradeState{
+				{Node: zoneNodes["zone-a-2"]}, {Node: zoneNodes["zone-a-1"]}, {Node: zoneNodes["zone-b-1"]},
+			}
+
+			stateManager.WithNodeSortPolicy(upgrade.NewNodeZoneSpreadSort(""))
+			Expect(stateManager.ProcessUpgradeRequiredNodes(ctx, &clusterState, 2)).To(Succeed())
+			Expect(getNodeUpgradeState(zoneNodes["zone-a-1"])).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(zoneNodes["zone-b-1"])).To(Equal(upgrade.UpgradeStateCordonRequired))
+			Expect(getNodeUpgradeState(zoneNodes["zone-a-2"])).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+			// the cluster state keeps its order
+			Expect(clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired][0].Node).To(Equal(zoneNodes["zone-a-2"]))
 		})
 		It("UpgradeStateManager should move pod to UpgradeUncordonRequired state "+
 			"if it's in ValidationRequired and validation has completed", func() {
@@ -1095,6 +3776,474 @@ var _ = Describe("UpgradeStateManager tests", func() {
 			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
 			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateDone))
 		})
+		It("UpgradeStateManager should cordon again a node manually uncordoned in the middle of the upgrade", func() {
+			cordonedNode := NewNode("cordoned-node").
+				WithUpgradeState(upgrade.UpgradeStateWaitForJobsRequired).
+				Unschedulable(true).
+				Node
+			uncordonedNode := NewNode("uncordoned-node").
+				WithUpgradeState(upgrade.UpgradeStateWaitForJobsRequired).
+				Node
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateWaitForJobsRequired] = []*upgrade.NodeUpgradeState{
+				{Node: cordonedNode}, {Node: uncordonedNode},
+			}
+
+			cordonManagerMock := mocks.CordonManager{}
+			cordonManagerMock.
+				On("Cordon", mock.Anything, mock.Anything).
+				Return(nil)
+			stateManager.CordonManager = &cordonManagerMock
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true})).
+				To(Succeed())
+			cordonManagerMock.AssertCalled(GinkgoT(), "Cordon", mock.Anything, uncordonedNode)
+			cordonManagerMock.AssertNumberOfCalls(GinkgoT(), "Cordon", 1)
+			Expect(uncordonedNode.Annotations).
+				NotTo(HaveKey(upgrade.GetUpgradeManualUncordonAnnotationKey()))
+		})
+		It("UpgradeStateManager should adopt a manual uncordon and skip drain of the node "+
+			"if manual intervention policy is Adopt", func() {
+			node := nodeWithUpgradeState(upgrade.UpgradeStateDrainRequired)
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateDrainRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+				DrainSpec: &v1alpha1.DrainSpec{
+					Enable: true,
+				},
+			}
+
+			cordonManagerMock := mocks.CordonManager{}
+			stateManager.CordonManager = &cordonManagerMock
+			drainManagerMock := mocks.DrainManager{}
+			drainManagerMock.
+				On("ScheduleNodesDrain", mock.Anything, mock.Anything).
+				Return(func(ctx context.Context, config *upgrade.DrainConfiguration) error {
+					Expect(config.Nodes).To(BeEmpty())
+					return nil
+				})
+			stateManager.DrainManager = &drainManagerMock
+			stateManager.WithManualInterventionPolicy(upgrade.ManualInterventionPolicyAdopt)
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(node.Annotations[upgrade.GetUpgradeManualUncordonAnnotationKey()]).To(Equal("true"))
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStatePodRestartRequired))
+			cordonManagerMock.AssertNotCalled(GinkgoT(), "Cordon", mock.Anything, mock.Anything)
+		})
+		It("UpgradeStateManager should persist the upgrade progress in the status ConfigMap", func() {
+			node := NewNode(fmt.Sprintf("node-%s", id)).
+				WithUpgradeState(upgrade.UpgradeStateDrainRequired).
+				Unschedulable(true).
+				Node
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateDrainRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+
+			configMapName := types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("upgrade-status-%s", id)}
+			stateManager.WithStatusConfigMap(configMapName.Namespace, configMapName.Name)
+			getStatus := func() *upgrade.UpgradeStatus {
+				configMap := &corev1.ConfigMap{}
+				Expect(k8sClient.Get(ctx, configMapName, configMap)).To(Succeed())
+				status := &upgrade.UpgradeStatus{}
+				Expect(json.Unmarshal([]byte(configMap.Data[upgrade.UpgradeStatusConfigMapKey]), status)).To(Succeed())
+				return status
+			}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			status := getStatus()
+			Expect(status.SessionID).NotTo(BeEmpty())
+			Expect(status.StartTime).NotTo(BeNil())
+			Expect(status.CompletionTime).To(BeNil())
+			Expect(status.TotalNodes).To(Equal(1))
+			Expect(status.NodesByState).To(Equal(map[string]int{upgrade.UpgradeStatePodRestartRequired: 1}))
+
+			emptyState := upgrade.NewClusterUpgradeState()
+			Expect(stateManager.ApplyState(ctx, &emptyState, policy)).To(Succeed())
+			completedStatus := getStatus()
+			Expect(completedStatus.SessionID).To(Equal(status.SessionID))
+			Expect(completedStatus.CompletionTime).NotTo(BeNil())
+			Expect(completedStatus.TotalNodes).To(BeZero())
+		})
+		It("UpgradeStateManager should publish the upgrade capacity", func() {
+			clusterState := upgrade.NewClusterUpgradeState()
+			for _, name := range []string{"node-c", "node-a", "node-b"} {
+				node := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+				node.Name = name
+				clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired] = append(
+					clusterState.NodeStates[upgrade.UpgradeStateUpgradeRequired], &upgrade.NodeUpgradeState{Node: node})
+			}
+			configMapName := types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("upgrade-capacity-%s", id)}
+			stateManager.WithStatusConfigMap(configMapName.Namespace, configMapName.Name)
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade:         true,
+				MaxParallelUpgrades: 2,
+			}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(stateManager.GetUpgradeCapacity()).To(Equal(upgrade.UpgradeCapacity{
+				SlotsAvailable:    0,
+				NextEligibleNodes: []string{"node-b"},
+			}))
+
+			configMap := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, configMapName, configMap)).To(Succeed())
+			Expect(configMap.Annotations).To(HaveKeyWithValue(upgrade.GetUpgradeSlotsAvailableAnnotationKey(), "0"))
+			Expect(configMap.Annotations).To(HaveKeyWithValue(upgrade.GetUpgradeNextEligibleNodesAnnotationKey(), "node-b"))
+		})
+		It("UpgradeStateManager should resume processing after the last processed node "+
+			"if max nodes per pass is set", func() {
+			clusterState := upgrade.NewClusterUpgradeState()
+			for _, name := range []string{"node-c", "node-a", "node-b"} {
+				node := nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)
+				node.Name = name
+				clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = append(
+					clusterState.NodeStates[upgrade.UpgradeStateCordonRequired], &upgrade.NodeUpgradeState{Node: node})
+			}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+
+			cordonedNodes := []string{}
+			cordonManagerMock := mocks.CordonManager{}
+			cordonManagerMock.
+				On("Cordon", mock.Anything, mock.Anything).
+				Return(func(ctx context.Context, node *corev1.Node) error {
+					cordonedNodes = append(cordonedNodes, node.Name)
+					return nil
+				})
+			stateManager.CordonManager = &cordonManagerMock
+			stateManager.WithMaxNodesPerPass(2)
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(cordonedNodes).To(Equal([]string{"node-a", "node-b"}))
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(cordonedNodes).To(Equal([]string{"node-a", "node-b", "node-c", "node-a"}))
+		})
+
+		It("UpgradeStateManager should record a single summarized event per upgrade state", func() {
+			recorder := record.NewFakeRecorder(100)
+			manager, err := upgrade.NewClusterUpgradeStateManager(log, k8sConfig, recorder)
+			Expect(err).NotTo(HaveOccurred())
+			summaryStateManager, _ := manager.(*upgrade.ClusterUpgradeStateManagerImpl)
+			summaryStateManager.NodeUpgradeStateProvider = upgrade.NewNodeUpgradeStateProvider(
+				k8sClient, log, summaryStateManager.EventRecorder)
+			cordonManagerMock := mocks.CordonManager{}
+			cordonManagerMock.On("Cordon", mock.Anything, mock.Anything).Return(nil)
+			summaryStateManager.CordonManager = &cordonManagerMock
+			summaryStateManager.WithSummarizedEvents(&corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "policy"}}, 1)
+
+			clusterState := upgrade.NewClusterUpgradeState()
+			for _, name := range []string{"summary-node-b", "summary-node-a", "summary-node-c"} {
+				node := NewNode(name).WithUpgradeState(upgrade.UpgradeStateCordonRequired).Create()
+				clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = append(
+					clusterState.NodeStates[upgrade.UpgradeStateCordonRequired], &upgrade.NodeUpgradeState{Node: node})
+			}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+
+			Expect(summaryStateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(recorder.Events).To(HaveLen(3))
+			Expect(<-recorder.Events).To(Equal(fmt.Sprintf("Normal %s "+
+				"InProgress: 3, MaxParallelUpgrades: 0, UpgradeSlotsAvailable: 0", upgrade.GetEventReason())))
+			Expect(<-recorder.Events).To(Equal(fmt.Sprintf("Normal %s cordon-required: "+
+				"Cordoned the node for the driver upgrade on 3 nodes: summary-node-a and 2 more",
+				upgrade.GetEventReason())))
+			Expect(<-recorder.Events).To(Equal(fmt.Sprintf("Normal %s cordon-required: "+
+				"Successfully updated node state label to wait-for-jobs-required on 3 nodes: summary-node-a and 2 more",
+				upgrade.GetEventReason())))
+		})
+
+		It("UpgradeStateManager should drop the repeated events with event aggregation", func() {
+			recorder := record.NewFakeRecorder(100)
+			manager, err := upgrade.NewClusterUpgradeStateManager(log, k8sConfig, recorder)
+			Expect(err).NotTo(HaveOccurred())
+			aggregatingStateManager, _ := manager.(*upgrade.ClusterUpgradeStateManagerImpl)
+			cordonManagerMock := mocks.CordonManager{}
+			cordonManagerMock.On("Cordon", mock.Anything, mock.Anything).Return(errors.New("cordon failed"))
+			aggregatingStateManager.CordonManager = &cordonManagerMock
+			aggregatingStateManager.WithEventAggregation(time.Hour)
+
+			node := nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)
+			node.Name = fmt.Sprintf("aggregated-node-%s", id)
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+			failedCordonEvent := fmt.Sprintf("Warning %s Failed to cordon the node for the driver upgrade, cordon failed",
+				upgrade.GetEventReason())
+
+			Expect(aggregatingStateManager.ApplyState(ctx, &clusterState, policy)).NotTo(Succeed())
+			Expect(recorder.Events).To(Receive(Equal(failedCordonEvent)))
+			Expect(aggregatingStateManager.ApplyState(ctx, &clusterState, policy)).NotTo(Succeed())
+			Expect(recorder.Events).To(BeEmpty())
+
+			aggregatingStateManager.WithEventAggregation(0)
+			Expect(aggregatingStateManager.ApplyState(ctx, &clusterState, policy)).NotTo(Succeed())
+			Expect(recorder.Events).To(Receive(Equal(failedCordonEvent)))
+		})
+
+		It("UpgradeStateManager should record the repeated events again once the aggregation interval passed", func() {
+			recorder := record.NewFakeRecorder(100)
+			manager, err := upgrade.NewClusterUpgradeStateManager(log, k8sConfig, recorder)
+			Expect(err).NotTo(HaveOccurred())
+			aggregatingStateManager, _ := manager.(*upgrade.ClusterUpgradeStateManagerImpl)
+			cordonManagerMock := mocks.CordonManager{}
+			cordonManagerMock.On("Cordon", mock.Anything, mock.Anything).Return(errors.New("cordon failed"))
+			aggregatingStateManager.CordonManager = &cordonManagerMock
+			aggregatingStateManager.WithEventAggregation(100 * time.Millisecond)
+
+			node := nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)
+			node.Name = fmt.Sprintf("aggregated-node-%s", id)
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+			failedCordonEvent := fmt.Sprintf("Warning %s Failed to cordon the node for the driver upgrade, cordon failed",
+				upgrade.GetEventReason())
+
+			Expect(aggregatingStateManager.ApplyState(ctx, &clusterState, policy)).NotTo(Succeed())
+			Expect(recorder.Events).To(Receive(Equal(failedCordonEvent)))
+			Expect(aggregatingStateManager.ApplyState(ctx, &clusterState, policy)).NotTo(Succeed())
+			Expect(recorder.Events).To(BeEmpty())
+
+			// the expired event is kept until it is pruned, but it doesn't drop the event anymore
+			time.Sleep(150 * time.Millisecond)
+			Expect(aggregatingStateManager.ApplyState(ctx, &clusterState, policy)).NotTo(Succeed())
+			Expect(recorder.Events).To(Receive(Equal(failedCordonEvent)))
+		})
+
+		It("UpgradeStateManager should let state hooks veto and delay node transitions", func() {
+			node := nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+
+			cordonManagerMock := mocks.CordonManager{}
+			cordonManagerMock.On("Cordon", mock.Anything, mock.Anything).Return(nil)
+			stateManager.CordonManager = &cordonManagerMock
+
+			preHookCalls := 0
+			postHookCalls := 0
+			stateManager.
+				WithStateHook(upgrade.UpgradeStateCordonRequired, upgrade.StateHookPre,
+					func(_ context.Context, nodeState *upgrade.NodeUpgradeState) (upgrade.StateHookDecision, error) {
+						Expect(nodeState.Node).To(Equal(node))
+						preHookCalls++
+						return upgrade.StateHookDecision{Veto: preHookCalls == 1}, nil
+					}).
+				WithStateHook(upgrade.UpgradeStateCordonRequired, upgrade.StateHookPost,
+					func(_ context.Context, nodeState *upgrade.NodeUpgradeState) (upgrade.StateHookDecision, error) {
+						Expect(getNodeUpgradeState(nodeState.Node)).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+						postHookCalls++
+						return upgrade.StateHookDecision{Delay: time.Hour}, nil
+					})
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateCordonRequired))
+			cordonManagerMock.AssertNotCalled(GinkgoT(), "Cordon", mock.Anything, mock.Anything)
+			Expect(postHookCalls).To(Equal(0))
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+			Expect(preHookCalls).To(Equal(2))
+			Expect(postHookCalls).To(Equal(1))
+
+			// the post hook delays the transition out of the next state
+			clusterState = upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateWaitForJobsRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+		})
+
+		It("UpgradeStateManager should call phase hooks around processing of every state", func() {
+			node := nodeWithUpgradeState(upgrade.UpgradeStateUncordonRequired)
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUncordonRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+
+			calls := []string{}
+			stateManager.
+				WithBeforePhaseHook(func(ctx context.Context, phase string, nodes []*corev1.Node) error {
+					calls = append(calls, "before "+phase)
+					return nil
+				}).
+				WithAfterPhaseHook(func(ctx context.Context, phase string, nodes []*corev1.Node) error {
+					if phase == upgrade.UpgradeStateUncordonRequired {
+						Expect(nodes).To(Equal([]*corev1.Node{node}))
+						Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateDone))
+					}
+					calls = append(calls, "after "+phase)
+					return nil
+				})
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			Expect(calls).To(HaveLen(28))
+			Expect(calls[0]).To(Equal("before " + upgrade.UpgradeStateUnknown))
+			Expect(calls[26:]).To(Equal([]string{
+				"before " + upgrade.UpgradeStateUncordonRequired, "after " + upgrade.UpgradeStateUncordonRequired}))
+		})
+
+		It("UpgradeStateManager should not process a state if a before phase hook fails", func() {
+			node := nodeWithUpgradeState(upgrade.UpgradeStateUncordonRequired)
+			clusterState := upgrade.NewClusterUpgradeState()
+			clusterState.NodeStates[upgrade.UpgradeStateUncordonRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+
+			policy := &v1alpha1.DriverUpgradePolicySpec{
+				AutoUpgrade: true,
+			}
+
+			stateManager.WithBeforePhaseHook(func(ctx context.Context, phase string, nodes []*corev1.Node) error {
+				if phase == upgrade.UpgradeStateUncordonRequired {
+					return errors.New("external coordination failed")
+				}
+				return nil
+			})
+
+			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).NotTo(Succeed())
+			Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateUncordonRequired))
+		})
+
+		It("UpgradeStateManager should detect the start and the completion of upgrade sessions", func() {
+			var started, completed []upgrade.UpgradeSession
+			stateManager.WithUpgradeSessionHooks(
+				func(_ context.Context, session upgrade.UpgradeSession) { started = append(started, session) },
+				func(_ context.Context, session upgrade.UpgradeSession) { completed = append(completed, session) })
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{Namespace: "ns", Name: "driver", Generation: 2}}
+			upToDatePod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+				Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			node := nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)
+			node.Name = "session-node"
+			passWithNodeIn := func(state string) {
+				node.Labels[upgrade.GetUpgradeStateLabelKey()] = state
+				clusterState := upgrade.NewClusterUpgradeState()
+				clusterState.NodeStates[state] = []*upgrade.NodeUpgradeState{
+					{Node: node, DriverPod: upToDatePod, DriverDaemonSet: daemonSet},
+				}
+				Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			}
+
+			passWithNodeIn(upgrade.UpgradeStateDone)
+			Expect(started).To(BeEmpty())
+
+			passWithNodeIn(upgrade.UpgradeStateCordonRequired)
+			Expect(started).To(HaveLen(1))
+			Expect(started[0].Generation).To(Equal("ns/driver:2"))
+			Expect(started[0].Nodes).To(ConsistOf(node.Name))
+			session, inProgress := stateManager.GetUpgradeSession()
+			Expect(inProgress).To(BeTrue())
+			Expect(session.Generation).To(Equal("ns/driver:2"))
+
+			passWithNodeIn(upgrade.UpgradeStateUncordonRequired)
+			Expect(completed).To(HaveLen(1))
+			Expect(completed[0].Generation).To(Equal("ns/driver:2"))
+			Expect(completed[0].Nodes).To(ConsistOf(node.Name))
+			Expect(completed[0].Superseded).To(BeFalse())
+			Expect(completed[0].CompletionTime).NotTo(BeZero())
+			_, inProgress = stateManager.GetUpgradeSession()
+			Expect(inProgress).To(BeFalse())
+
+			// the completed generation doesn't start a new session
+			passWithNodeIn(upgrade.UpgradeStateCordonRequired)
+			Expect(started).To(HaveLen(1))
+
+			// a new generation supersedes the session in progress
+			daemonSet.Generation = 3
+			passWithNodeIn(upgrade.UpgradeStateCordonRequired)
+			Expect(started).To(HaveLen(2))
+			daemonSet.Generation = 4
+			passWithNodeIn(upgrade.UpgradeStateCordonRequired)
+			Expect(completed).To(HaveLen(2))
+			Expect(completed[1].Generation).To(Equal("ns/driver:3"))
+			Expect(completed[1].Superseded).To(BeTrue())
+			Expect(started).To(HaveLen(3))
+			Expect(started[2].Generation).To(Equal("ns/driver:4"))
+		})
+		It("UpgradeStateManager should send the upgrade lifecycle events to the notifier", func() {
+			var payloads []map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.Method).To(Equal(http.MethodPost))
+				payload := make(map[string]interface{})
+				Expect(json.NewDecoder(r.Body).Decode(&payload)).To(Succeed())
+				payloads = append(payloads, payload)
+			}))
+			defer server.Close()
+			stateManager.WithNotifier(upgrade.NewWebhookNotifier(server.Client(), server.URL))
+			policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true}
+			daemonSet := &appsv1.DaemonSet{ObjectMeta: v1.ObjectMeta{Namespace: "ns", Name: "driver", Generation: 2}}
+			upToDatePod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+				Labels: map[string]string{upgrade.PodControllerRevisionHashLabelKey: "test-hash-12345"}}}
+			node := nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)
+			node.Name = "notified-node"
+			passWithNodeIn := func(state string) {
+				node.Labels[upgrade.GetUpgradeStateLabelKey()] = state
+				clusterState := upgrade.NewClusterUpgradeState()
+				clusterState.NodeStates[state] = []*upgrade.NodeUpgradeState{
+					{Node: node, DriverPod: upToDatePod, DriverDaemonSet: daemonSet},
+				}
+				Expect(stateManager.ApplyState(ctx, &clusterState, policy)).To(Succeed())
+			}
+
+			passWithNodeIn(upgrade.UpgradeStateCordonRequired)
+			Expect(payloads).To(HaveLen(1))
+			Expect(payloads[0]).To(HaveKeyWithValue("event", string(upgrade.UpgradeEventStarted)))
+			Expect(payloads[0]).To(HaveKeyWithValue("generation", "ns/driver:2"))
+			Expect(payloads[0]["text"]).To(ContainSubstring("started"))
+
+			passWithNodeIn(upgrade.UpgradeStateFailed)
+			Expect(payloads).To(HaveLen(2))
+			Expect(payloads[1]).To(HaveKeyWithValue("event", string(upgrade.UpgradeEventNodeFailed)))
+			Expect(payloads[1]).To(HaveKeyWithValue("node", node.Name))
+			// a node is notified once per session
+			passWithNodeIn(upgrade.UpgradeStateFailed)
+			Expect(payloads).To(HaveLen(2))
+
+			passWithNodeIn(upgrade.UpgradeStateUncordonRequired)
+			Expect(payloads).To(HaveLen(3))
+			Expect(payloads[2]).To(HaveKeyWithValue("event", string(upgrade.UpgradeEventCompleted)))
+			Expect(payloads[2]).To(HaveKeyWithValue("failedNodes", ConsistOf(node.Name)))
+			Expect(payloads[2]["text"]).To(ContainSubstring("failed nodes: notified-node"))
+
+			// a failed notification doesn't fail the pass
+			stateManager.WithNotifier(upgrade.NotifierFunc(
+				func(_ context.Context, _ upgrade.UpgradeNotification) error { return errors.New("sink unavailable") }))
+			daemonSet.Generation = 3
+			passWithNodeIn(upgrade.UpgradeStateCordonRequired)
+			Expect(payloads).To(HaveLen(4))
+		})
+		It("Webhook notifier errors should not contain the secret path of the webhook URL", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			webhookURL := server.URL + "/services/T000/B000/webhook-secret"
+			notifier := upgrade.NewWebhookNotifier(server.Client(), webhookURL)
+
+			err := notifier.Notify(ctx, upgrade.UpgradeNotification{Event: upgrade.UpgradeEventStarted})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("500"))
+			Expect(err.Error()).NotTo(ContainSubstring("webhook-secret"))
+
+			// the error of the client holds the URL as well
+			server.Close()
+			err = notifier.Notify(ctx, upgrade.UpgradeNotification{Event: upgrade.UpgradeEventStarted})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).NotTo(ContainSubstring("webhook-secret"))
+		})
 		It("UpgradeStateManager should fail if cordonManager fails", func() {
 			node := nodeWithUpgradeState(upgrade.UpgradeStateUncordonRequired)
 
@@ -1120,6 +4269,57 @@ var _ = Describe("UpgradeStateManager tests", func() {
 			Expect(stateManager.ApplyState(ctx, &clusterState, policy)).ToNot(Succeed())
 			Expect(getNodeUpgradeState(node)).ToNot(Equal(upgrade.UpgradeStateDone))
 		})
+		It("UpgradeStateManager should handle the API errors of the phases by class", func() {
+			nodeGroupResource := schema.GroupResource{Resource: "nodes"}
+			cases := []struct {
+				err             error
+				class           upgrade.APIErrorClass
+				expectErr       bool
+				expectUncordon  bool
+				expectErrSubstr string
+			}{
+				{apierrors.NewNotFound(nodeGroupResource, "cordon-node"), upgrade.APIErrorClassNotFound, false, true, ""},
+				{apierrors.NewConflict(nodeGroupResource, "cordon-node", errors.New("modified")),
+					upgrade.APIErrorClassConflict, false, true, ""},
+				{apierrors.NewTooManyRequests("throttled", 1), upgrade.APIErrorClassTimeout, true, true, "throttled"},
+				{apierrors.NewForbidden(nodeGroupResource, "cordon-node", errors.New("denied")),
+					upgrade.APIErrorClassForbidden, true, false, "RBAC"},
+				{errors.New("unexpected"), upgrade.APIErrorClassOther, true, false, "unexpected"},
+			}
+			for _, c := range cases {
+				cordonNode := nodeWithUpgradeState(upgrade.UpgradeStateCordonRequired)
+				cordonNode.Name = "cordon-node"
+				uncordonNode := nodeWithUpgradeState(upgrade.UpgradeStateUncordonRequired)
+				uncordonNode.Name = "uncordon-node"
+				clusterState := upgrade.NewClusterUpgradeState()
+				clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = []*upgrade.NodeUpgradeState{{Node: cordonNode}}
+				clusterState.NodeStates[upgrade.UpgradeStateUncordonRequired] = []*upgrade.NodeUpgradeState{
+					{Node: uncordonNode}}
+
+				cordonErr := c.err
+				cordonManagerMock := mocks.CordonManager{}
+				cordonManagerMock.On("Cordon", mock.Anything, mock.Anything).Return(cordonErr)
+				cordonManagerMock.On("Uncordon", mock.Anything, mock.Anything).Return(nil)
+				stateManager.CordonManager = &cordonManagerMock
+
+				result, err := stateManager.ApplyStateWithResult(ctx, &clusterState,
+					&v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true})
+				if c.expectErr {
+					Expect(err).To(MatchError(ContainSubstring(c.expectErrSubstr)), string(c.class))
+				} else {
+					Expect(err).NotTo(HaveOccurred(), string(c.class))
+				}
+				Expect(result.PhaseErrors).To(Equal([]upgrade.PhaseError{
+					{Phase: upgrade.UpgradeStateCordonRequired, Class: c.class, Err: cordonErr}}), string(c.class))
+				Expect(getNodeUpgradeState(cordonNode)).To(Equal(upgrade.UpgradeStateCordonRequired))
+				if c.expectUncordon {
+					Expect(getNodeUpgradeState(uncordonNode)).To(Equal(upgrade.UpgradeStateDone), string(c.class))
+				} else {
+					Expect(getNodeUpgradeState(uncordonNode)).To(Equal(upgrade.UpgradeStateUncordonRequired),
+						string(c.class))
+				}
+			}
+		})
 	})
 	It("UpgradeStateManager should not move outdated node to UpgradeRequired states with orphaned pod", func() {
 		orphanedPod := &corev1.Pod{}
@@ -1163,6 +4363,51 @@ var _ = Describe("UpgradeStateManager tests", func() {
 		Expect(getNodeUpgradeState(UnknownToUpgradeRequiredNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
 		Expect(getNodeUpgradeState(DoneToUpgradeRequiredNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
 	})
+	It("UpgradeStateManager should handle the nodes with orphaned pod according to the orphaned pod policy", func() {
+		orphanedPod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "orphaned-pod"}}
+		deleteNode := nodeWithUpgradeState(upgrade.UpgradeStateDone)
+		failNode := nodeWithUpgradeState("")
+
+		clusterState := upgrade.NewClusterUpgradeState()
+		clusterState.NodeStates[upgrade.UpgradeStateDone] = []*upgrade.NodeUpgradeState{
+			{Node: deleteNode, DriverPod: orphanedPod, DriverDaemonSet: nil},
+		}
+		clusterState.NodeStates[""] = []*upgrade.NodeUpgradeState{
+			{Node: failNode, DriverPod: orphanedPod, DriverDaemonSet: nil},
+		}
+
+		stateManager.WithOrphanedPodPolicy(upgrade.OrphanedPodPolicyDelete)
+		Expect(stateManager.ProcessDoneOrUnknownNodes(ctx, &clusterState, upgrade.UpgradeStateDone)).To(Succeed())
+		Expect(getNodeUpgradeState(deleteNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+
+		stateManager.WithOrphanedPodPolicy(upgrade.OrphanedPodPolicyFail)
+		Expect(stateManager.ProcessDoneOrUnknownNodes(ctx, &clusterState, "")).To(Succeed())
+		Expect(getNodeUpgradeState(failNode)).To(Equal(upgrade.UpgradeStateFailed))
+		Expect(upgrade.GetNodeUpgradeStateReason(failNode)).To(Equal(upgrade.UpgradeStateReasonOrphanedDriverPod))
+	})
+	It("UpgradeStateManager should change the state of the other nodes when a node state change fails", func() {
+		orphanedPod := &corev1.Pod{}
+		failedNode := NewNode("failed-node").WithUpgradeState(upgrade.UpgradeStateDone).
+			WithAnnotations(map[string]string{upgrade.GetUpgradeRequestedAnnotationKey(): "true"}).Create()
+		changedNode := NewNode("changed-node").WithUpgradeState(upgrade.UpgradeStateDone).
+			WithAnnotations(map[string]string{upgrade.GetUpgradeRequestedAnnotationKey(): "true"}).Create()
+
+		clusterState := upgrade.NewClusterUpgradeState()
+		clusterState.NodeStates[upgrade.UpgradeStateDone] = []*upgrade.NodeUpgradeState{
+			{Node: failedNode, DriverPod: orphanedPod, DriverDaemonSet: nil},
+			{Node: changedNode, DriverPod: orphanedPod, DriverDaemonSet: nil},
+		}
+
+		// the provider doesn't change the states in batch, so the nodes are changed one after the other
+		stateManager.NodeUpgradeStateProvider = &failingStateProvider{
+			NodeUpgradeStateProvider: stateManager.NodeUpgradeStateProvider,
+			nodeName:                 failedNode.Name,
+		}
+		err := stateManager.ProcessDoneOrUnknownNodes(ctx, &clusterState, upgrade.UpgradeStateDone)
+		Expect(err).To(MatchError(ContainSubstring(failedNode.Name)))
+		Expect(getNodeUpgradeState(failedNode)).To(Equal(upgrade.UpgradeStateDone))
+		Expect(getNodeUpgradeState(changedNode)).To(Equal(upgrade.UpgradeStateUpgradeRequired))
+	})
 	It("UpgradeStateManager should move upgrade required node to CordonRequired states with orphaned pod and remove upgrade-requested annotation", func() {
 		orphanedPod := &corev1.Pod{}
 
@@ -1239,6 +4484,237 @@ var _ = Describe("UpgradeStateManager tests", func() {
 
 })
 
+var _ = Describe("NodeSortPolicy", func() {
+	var nodeStates []*upgrade.NodeUpgradeState
+
+	BeforeEach(func() {
+		nodeStates = nil
+		for _, name := range []string{"node-c", "node-a", "node-b"} {
+			node := nodeWithUpgradeState(upgrade.UpgradeStateUpgradeRequired)
+			node.Name = fmt.Sprintf("%s-%s", name, randSeq(5))
+			nodeStates = append(nodeStates, &upgrade.NodeUpgradeState{Node: node})
+		}
+	})
+
+	nodeNames := func() []string {
+		names := make([]string, 0, len(nodeStates))
+		for _, nodeState := range nodeStates {
+			names = append(names, nodeState.Node.Name[:len("node-a")])
+		}
+		return names
+	}
+
+	It("should order the nodes by name", func() {
+		Expect(upgrade.NewNodeAlphabeticalSort().Sort(context.Background(), nodeStates)).To(Succeed())
+		Expect(nodeNames()).To(Equal([]string{"node-a", "node-b", "node-c"}))
+	})
+	It("should order the nodes with the user-supplied comparator", func() {
+		nodeStates[1].Node.Labels["priority"] = "high"
+		sortPolicy := upgrade.NewNodeComparatorSort(func(a, b *upgrade.NodeUpgradeState) bool {
+			return a.Node.Labels["priority"] == "high" && b.Node.Labels["priority"] != "high"
+		})
+		Expect(sortPolicy.Sort(context.Background(), nodeStates)).To(Succeed())
+		Expect(nodeNames()).To(Equal([]string{"node-a", "node-c", "node-b"}))
+	})
+	It("should order the nodes by their count of running pods", func() {
+		namespace := createNamespace(fmt.Sprintf("node-sort-%s", randSeq(5)))
+		// node-c runs 2 pods, node-a 1 running and 1 completed pod, node-b none
+		for i, nodeState := range []*upgrade.NodeUpgradeState{nodeStates[0], nodeStates[0], nodeStates[1]} {
+			_ = NewPod(fmt.Sprintf("running-pod-%d", i), namespace.Name, nodeState.Node.Name).Create()
+		}
+		completedPod := createPod("completed-pod", namespace.Name, nil, nodeStates[1].Node.Name)
+		completedPod.Status.Phase = corev1.PodSucceeded
+		Expect(updatePodStatus(completedPod)).To(Succeed())
+
+		Expect(upgrade.NewNodeLeastLoadedSort(k8sInterface).Sort(context.Background(), nodeStates)).To(Succeed())
+		Expect(nodeNames()).To(Equal([]string{"node-b", "node-a", "node-c"}))
+	})
+})
+
+var _ = Describe("NewClusterUpgradeStateManagerWithIdentity", func() {
+	It("should send API requests with the user agent of the component", func() {
+		var mutex sync.Mutex
+		userAgents := map[string]bool{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			userAgents[r.UserAgent()] = true
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		identity := upgrade.ComponentIdentity{Name: "network-operator", Version: "v24.7.0"}
+		manager, err := upgrade.NewClusterUpgradeStateManagerWithIdentity(log, &rest.Config{Host: server.URL},
+			eventRecorder, identity)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(manager.CleanupUpgradeState(context.TODO(), false)).NotTo(Succeed())
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		Expect(userAgents).To(Equal(map[string]bool{"network-operator/v24.7.0": true}))
+	})
+})
+
+var _ = Describe("Kubernetes API server warnings", func() {
+	It("should be reported as events of the node being drained", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Warning", `299 - "policy/v1beta1 Eviction is deprecated"`)
+			if strings.HasSuffix(r.URL.Path, "/pods") {
+				_, _ = w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","items":[]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"kind":"Node","apiVersion":"v1","metadata":{"name":"warning-node"}}`))
+		}))
+		defer server.Close()
+
+		recorder := record.NewFakeRecorder(100)
+		manager, err := upgrade.NewClusterUpgradeStateManager(log, &rest.Config{Host: server.URL}, recorder)
+		Expect(err).NotTo(HaveOccurred())
+		stateManager := manager.(*upgrade.ClusterUpgradeStateManagerImpl)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		node := &corev1.Node{ObjectMeta: v1.ObjectMeta{Name: "warning-node"}}
+		drainConfig := &upgrade.DrainConfiguration{Spec: &v1alpha1.DrainSpec{Enable: true}, Nodes: []*corev1.Node{node}}
+		Expect(stateManager.DrainManager.ScheduleNodesDrain(ctx, drainConfig)).To(Succeed())
+
+		Eventually(recorder.Events).Should(Receive(SatisfyAll(
+			ContainSubstring("Kubernetes API server warning"),
+			ContainSubstring("policy/v1beta1 Eviction is deprecated"))))
+	})
+})
+
+var _ = Describe("Upgrade phase stats", func() {
+	It("should report the duration and the API requests of the phases", func() {
+		var mutex sync.Mutex
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests++
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"kind":"Node","apiVersion":"v1","metadata":{"name":"stats-node"}}`))
+		}))
+		defer server.Close()
+
+		manager, err := upgrade.NewClusterUpgradeStateManager(log, &rest.Config{Host: server.URL}, eventRecorder)
+		Expect(err).NotTo(HaveOccurred())
+		stateManager := manager.(*upgrade.ClusterUpgradeStateManagerImpl)
+		stateManager.NodeUpgradeStateProvider = &nodeUpgradeStateProvider
+		registry := prometheus.NewRegistry()
+		stateManager.WithMetrics(registry)
+
+		// the node is cordoned with the API, its state is changed by the mocked provider
+		node := NewNode(fmt.Sprintf("stats-node-%s", randSeq(5))).
+			WithUpgradeState(upgrade.UpgradeStateCordonRequired).Node
+		clusterState := upgrade.NewClusterUpgradeState()
+		clusterState.NodeStates[upgrade.UpgradeStateCordonRequired] = []*upgrade.NodeUpgradeState{{Node: node}}
+		policy := &v1alpha1.DriverUpgradePolicySpec{AutoUpgrade: true, MaxParallelUpgrades: 1}
+		result, err := stateManager.ApplyStateWithResult(context.Background(), &clusterState, policy)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getNodeUpgradeState(node)).To(Equal(upgrade.UpgradeStateWaitForJobsRequired))
+
+		phases := make([]string, 0, len(result.PhaseStats))
+		apiCalls := make(map[string]int64)
+		for _, stat := range result.PhaseStats {
+			phases = append(phases, stat.Phase)
+			apiCalls[stat.Phase] = stat.APICalls
+			Expect(stat.Duration).To(BeNumerically(">", 0))
+		}
+		Expect(phases).To(Equal([]string{
+			upgrade.UpgradeStateUnknown, upgrade.UpgradeStateDone, upgrade.UpgradeStateDaemonSetMissing,
+			upgrade.UpgradeStateBlockedBySkew, upgrade.UpgradeStateUpgradeRequired, upgrade.UpgradeStateCordonRequired,
+			upgrade.UpgradeStateWaitForJobsRequired, upgrade.UpgradeStatePodDeletionRequired,
+			upgrade.UpgradeStateDrainRequired, upgrade.UpgradeStateRebootRequired, upgrade.UpgradeStatePodRestartRequired,
+			upgrade.UpgradeStateFailed,
+			upgrade.UpgradeStateValidationRequired, upgrade.UpgradeStateUncordonRequired,
+		}))
+		mutex.Lock()
+		defer mutex.Unlock()
+		Expect(apiCalls[upgrade.UpgradeStateCordonRequired]).To(BeNumerically(">", 0))
+		Expect(apiCalls[upgrade.UpgradeStateCordonRequired]).To(BeNumerically("<=", result.APICalls))
+		Expect(result.APICalls).To(Equal(int64(requests)))
+
+		expected := `
+# HELP driver_upgrade_phase_api_calls_total Count of API server requests sent during the upgrade phases, by the state they process
+# TYPE driver_upgrade_phase_api_calls_total counter
+`
+		for _, phase := range phases {
+			label := phase
+			if label == upgrade.UpgradeStateUnknown {
+				label = "unknown"
+			}
+			expected += fmt.Sprintf("driver_upgrade_phase_api_calls_total{driver=\"gpu\",phase=%q} %d\n",
+				label, apiCalls[phase])
+		}
+		Expect(testutil.GatherAndCompare(registry, strings.NewReader(expected),
+			"driver_upgrade_phase_api_calls_total")).To(Succeed())
+		count, err := testutil.GatherAndCount(registry, "driver_upgrade_phase_duration_seconds")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count).To(Equal(len(phases)))
+	})
+})
+
+// drainStatusManager is a DrainManager mock reporting fixed drain statuses
+type drainStatusManager struct {
+	mocks.DrainManager
+	statuses map[string]upgrade.DrainStatus
+}
+
+func (m *drainStatusManager) GetDrainStatus(nodeName string) (upgrade.DrainStatus, bool) {
+	status, ok := m.statuses[nodeName]
+	return status, ok
+}
+
+// redirectingStateProvider is a NodeUpgradeStateProvider moving the nodes to the to state instead of the from state
+type redirectingStateProvider struct {
+	upgrade.NodeUpgradeStateProvider
+	from string
+	to   string
+}
+
+func (p *redirectingStateProvider) ChangeNodeUpgradeState(ctx context.Context, node *corev1.Node,
+	newNodeState string) error {
+	if newNodeState == p.from {
+		newNodeState = p.to
+	}
+	return p.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, newNodeState)
+}
+
+// failingStateProvider is a NodeUpgradeStateProvider failing to change the state of the node named nodeName
+type failingStateProvider struct {
+	upgrade.NodeUpgradeStateProvider
+	nodeName string
+}
+
+func (p *failingStateProvider) ChangeNodeUpgradeState(ctx context.Context, node *corev1.Node,
+	newNodeState string) error {
+	if node.Name == p.nodeName {
+		return fmt.Errorf("failed to change the state of node %s", node.Name)
+	}
+	return p.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, newNodeState)
+}
+
+// testDriverWorkload is a DriverWorkload comparing the version label of the driver pods with its target version
+type testDriverWorkload struct {
+	targetVersion string
+	restartedPods []string
+}
+
+func (w *testDriverWorkload) GetDriverPods(context.Context) ([]*corev1.Pod, error) {
+	return nil, nil
+}
+
+func (w *testDriverWorkload) IsUpgradeRequired(_ context.Context, pod *corev1.Pod) (bool, error) {
+	return pod.Labels["version"] != w.targetVersion, nil
+}
+
+func (w *testDriverWorkload) RestartDriverPod(_ context.Context, pod *corev1.Pod) error {
+	w.restartedPods = append(w.restartedPods, pod.Name)
+	return nil
+}
+
 func nodeWithUpgradeState(state string) *corev1.Node {
 	return &corev1.Node{
 		ObjectMeta: v1.ObjectMeta{
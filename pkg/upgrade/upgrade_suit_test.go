@@ -29,9 +29,11 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -379,6 +381,22 @@ func createNamespace(name string) *corev1.Namespace {
 	return namespace
 }
 
+// createBlockingPDB creates a PodDisruptionBudget which never allows the eviction of the pods with the selector labels
+func createBlockingPDB(namespace string, selector map[string]string) *policyv1.PodDisruptionBudget {
+	maxUnavailable := intstr.FromInt(0)
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "blocking-pdb", Namespace: namespace},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MaxUnavailable: &maxUnavailable,
+			Selector:       &metav1.LabelSelector{MatchLabels: selector},
+		},
+	}
+	err := k8sClient.Create(context.TODO(), pdb)
+	Expect(err).NotTo(HaveOccurred())
+	createdObjects = append(createdObjects, pdb)
+	return pdb
+}
+
 func createPod(name, namespace string, labels map[string]string, nodeName string) *corev1.Pod {
 	gracePeriodSeconds := int64(0)
 	pod := &corev1.Pod{
@@ -0,0 +1,401 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgradetest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
+)
+
+const (
+	// DefaultScenarioIterations is the count of ApplyState passes of a scenario which doesn't set it
+	DefaultScenarioIterations = 10
+	// scenarioWorkersTimeout is how long a scenario iteration waits for the drains and pod deletions to complete
+	scenarioWorkersTimeout = 30 * time.Second
+)
+
+// Scenario describes a cluster and the upgrade policy to run the upgrade state machine against, see RunScenario.
+// It is usually read from YAML with LoadScenario, e.g.
+//
+//	daemonSetGeneration: 2
+//	iterations: 8
+//	policy:
+//	  autoUpgrade: true
+//	  maxParallelUpgrades: 1
+//	  drain:
+//	    enable: true
+//	nodes:
+//	- name: node-a
+//	  driverGeneration: 1
+//	- name: node-b
+//	  state: upgrade-done
+type Scenario struct {
+	// Policy is the upgrade policy applied by every iteration
+	Policy v1alpha1.DriverUpgradePolicySpec `json:"policy"`
+	// DaemonSetGeneration is the generation of the driver DaemonSet, 1 if not set.
+	// The nodes whose driver pod has an older generation are upgraded.
+	DaemonSetGeneration int64 `json:"daemonSetGeneration,omitempty"`
+	// Iterations is the count of ApplyState passes, DefaultScenarioIterations if not set
+	Iterations int `json:"iterations,omitempty"`
+	// Nodes are the nodes of the cluster
+	Nodes []ScenarioNode `json:"nodes"`
+}
+
+// ScenarioNode is a node of a Scenario
+type ScenarioNode struct {
+	// Name is the name of the node
+	Name string `json:"name"`
+	// State is the initial upgrade state of the node, the node has no upgrade state if empty
+	State string `json:"state,omitempty"`
+	// DriverGeneration is the generation of the driver pod of the node, the generation of the DaemonSet if not set
+	DriverGeneration int64 `json:"driverGeneration,omitempty"`
+	// NoDriver is true for a node without the driver, e.g. a node without GPUs
+	NoDriver bool `json:"noDriver,omitempty"`
+	// Labels are additional labels of the node
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations are the annotations of the node
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Unschedulable is true for a cordoned node
+	Unschedulable bool `json:"unschedulable,omitempty"`
+}
+
+// ScenarioTransition is a change of the upgrade state of a node during a scenario iteration
+type ScenarioTransition struct {
+	// Iteration is the iteration which changed the state, starting at 1
+	Iteration int
+	// Node is the name of the node
+	Node string
+	// From and To are the upgrade states before and after the iteration, empty if the node has no upgrade state
+	From string
+	To   string
+}
+
+// ScenarioTrace is the outcome of a scenario run
+type ScenarioTrace struct {
+	// Transitions are the changes of the node upgrade states, sorted by iteration and node name
+	Transitions []ScenarioTransition
+	// FinalStates are the upgrade states of the nodes after the last iteration, by node name
+	FinalStates map[string]string
+}
+
+// String returns the trace in a stable text form, e.g. to be compared with a golden file: a line per transition,
+// followed by a line per node with its final state
+func (t *ScenarioTrace) String() string {
+	format := func(state string) string {
+		if state == "" {
+			return "<none>"
+		}
+		return state
+	}
+	var b strings.Builder
+	for _, transition := range t.Transitions {
+		fmt.Fprintf(&b, "%d %s: %s -> %s\n", transition.Iteration, transition.Node, format(transition.From),
+			format(transition.To))
+	}
+	nodeNames := make([]string, 0, len(t.FinalStates))
+	for nodeName := range t.FinalStates {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+	for _, nodeName := range nodeNames {
+		fmt.Fprintf(&b, "final %s: %s\n", nodeName, format(t.FinalStates[nodeName]))
+	}
+	return b.String()
+}
+
+// LoadScenario reads a scenario from YAML, unknown fields are rejected
+func LoadScenario(data []byte) (*Scenario, error) {
+	scenario := &Scenario{}
+	if err := yaml.UnmarshalStrict(data, scenario); err != nil {
+		return nil, fmt.Errorf("invalid scenario: %v", err)
+	}
+	return scenario, nil
+}
+
+// ManagerOption configures the upgrade state manager of a scenario run, e.g. to enable the pod deletion
+type ManagerOption func(manager upgrade.ClusterUpgradeStateManager)
+
+// RunScenario runs the upgrade state machine against fake clients holding the cluster of the scenario and returns
+// the changes of the node upgrade states. Every iteration builds the cluster state with BuildState and applies
+// the policy with ApplyState, then waits for the drains and pod deletions scheduled by the pass to complete.
+// Between the iterations, the deleted driver pods are recreated with the generation of the DaemonSet, running
+// and ready, as the DaemonSet controller would do. The run is deterministic for a given scenario as long as
+// the policy doesn't depend on time, e.g. on timeouts or maintenance windows.
+func RunScenario(ctx context.Context, scenario *Scenario, opts ...ManagerOption) (*ScenarioTrace, error) {
+	generation := scenario.DaemonSetGeneration
+	if generation == 0 {
+		generation = 1
+	}
+	iterations := scenario.Iterations
+	if iterations == 0 {
+		iterations = DefaultScenarioIterations
+	}
+
+	cluster := NewCluster()
+	driverGenerations := make(map[string]int64)
+	for i := range scenario.Nodes {
+		scenarioNode := &scenario.Nodes[i]
+		if _, ok := driverGenerations[scenarioNode.Name]; ok || scenarioNode.Name == "" {
+			return nil, fmt.Errorf("invalid scenario: missing or duplicate node name %q", scenarioNode.Name)
+		}
+		node := scenarioNode.node()
+		cluster.nodes = append(cluster.nodes, node)
+		driverGenerations[node.Name] = 0
+		if !scenarioNode.NoDriver {
+			cluster.drivers[node.Name] = true
+			driverGenerations[node.Name] = generation
+			if scenarioNode.DriverGeneration != 0 {
+				driverGenerations[node.Name] = scenarioNode.DriverGeneration
+			}
+		}
+	}
+	ds := cluster.DriverDaemonSet()
+	objects := []runtime.Object{ds, controllerRevision(ds, generation)}
+	for _, node := range cluster.nodes {
+		objects = append(objects, node.DeepCopy())
+		if cluster.drivers[node.Name] {
+			objects = append(objects, scenarioDriverPod(node, ds, driverGenerations[node.Name]))
+		}
+	}
+
+	k8sInterface := fake.NewSimpleClientset(objects...)
+	// the drain evicts the pods if the eviction API is discovered
+	k8sInterface.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{
+			{Name: "pods/eviction", Kind: "Eviction", Group: "policy", Version: "v1"}}},
+		{GroupVersion: "policy/v1", APIResources: []metav1.APIResource{{Name: "evictions", Kind: "Eviction"}}},
+	}
+	// the fake clientset ignores field selectors, the pods of a node are listed with one
+	k8sInterface.PrependReactor("list", "pods", podListFieldSelectorReactor(k8sInterface))
+	k8sClient := fakeclient.NewClientBuilder().
+		WithScheme(scheme.Scheme).
+		WithObjectTracker(k8sInterface.Tracker()).
+		Build()
+	manager := upgrade.NewClusterUpgradeStateManagerWithClients(logr.Discard(), k8sClient, k8sInterface, nil)
+	for _, opt := range opts {
+		opt(manager)
+	}
+
+	trace := &ScenarioTrace{}
+	states, err := getNodeStates(ctx, k8sInterface, manager.GetNodeUpgradeStateStorage())
+	if err != nil {
+		return nil, err
+	}
+	for iteration := 1; iteration <= iterations; iteration++ {
+		err = recreateDriverPods(ctx, k8sInterface, cluster, ds, generation)
+		if err != nil {
+			return nil, err
+		}
+		state, err := manager.BuildState(ctx, DriverNamespace, driverLabels())
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %v", iteration, err)
+		}
+		err = manager.ApplyState(ctx, state, &scenario.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %v", iteration, err)
+		}
+		err = waitForWorkers(ctx, manager)
+		if err != nil {
+			return nil, fmt.Errorf("iteration %d: %v", iteration, err)
+		}
+
+		newStates, err := getNodeStates(ctx, k8sInterface, manager.GetNodeUpgradeStateStorage())
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range cluster.nodes {
+			if states[node.Name] != newStates[node.Name] {
+				trace.Transitions = append(trace.Transitions, ScenarioTransition{
+					Iteration: iteration, Node: node.Name, From: states[node.Name], To: newStates[node.Name]})
+			}
+		}
+		states = newStates
+	}
+	sort.SliceStable(trace.Transitions, func(i, j int) bool {
+		a, b := trace.Transitions[i], trace.Transitions[j]
+		if a.Iteration != b.Iteration {
+			return a.Iteration < b.Iteration
+		}
+		return a.Node < b.Node
+	})
+	trace.FinalStates = states
+	return trace, nil
+}
+
+// node returns the node of the scenario
+func (n *ScenarioNode) node() *corev1.Node {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        n.Name,
+			Labels:      map[string]string{corev1.LabelHostname: n.Name},
+			Annotations: map[string]string{},
+		},
+		Spec: corev1.NodeSpec{Unschedulable: n.Unschedulable},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	for key, value := range n.Labels {
+		node.Labels[key] = value
+	}
+	for key, value := range n.Annotations {
+		node.Annotations[key] = value
+	}
+	if n.State != "" {
+		WithUpgradeState(n.State)(node)
+	}
+	return node
+}
+
+// revisionHash returns the controller revision hash of the driver pods of the generation
+func revisionHash(generation int64) string {
+	return fmt.Sprintf("generation-%d", generation)
+}
+
+// controllerRevision returns the controller revision of the generation of the DaemonSet
+func controllerRevision(ds *appsv1.DaemonSet, generation int64) *appsv1.ControllerRevision {
+	return &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", ds.Name, revisionHash(generation)),
+			Namespace: ds.Namespace,
+			Labels:    driverLabels(),
+		},
+		Revision: generation,
+	}
+}
+
+// scenarioDriverPod returns the running driver pod of the node with the given generation
+func scenarioDriverPod(node *corev1.Node, ds *appsv1.DaemonSet, generation int64) *corev1.Pod {
+	pod := driverPod(node, ds)
+	pod.Labels[upgrade.PodControllerRevisionHashLabelKey] = revisionHash(generation)
+	return pod
+}
+
+// recreateDriverPods creates the driver pods deleted by the previous iteration with the generation of the DaemonSet
+func recreateDriverPods(ctx context.Context, k8sInterface kubernetes.Interface, cluster *Cluster,
+	ds *appsv1.DaemonSet, generation int64) error {
+	pods, err := k8sInterface.CoreV1().Pods(DriverNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	nodesWithPod := make(map[string]bool)
+	for _, pod := range pods.Items {
+		nodesWithPod[pod.Spec.NodeName] = true
+	}
+	for _, node := range cluster.ManagedNodes() {
+		if nodesWithPod[node.Name] {
+			continue
+		}
+		pod := scenarioDriverPod(node, ds, generation)
+		if _, err := k8sInterface.CoreV1().Pods(DriverNamespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to recreate driver pod of node %s: %v", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// waitForWorkers waits until the drain and pod managers processed all the nodes scheduled by the pass
+func waitForWorkers(ctx context.Context, manager upgrade.ClusterUpgradeStateManager) error {
+	impl, ok := manager.(*upgrade.ClusterUpgradeStateManagerImpl)
+	if !ok {
+		return nil
+	}
+	var providers []upgrade.WorkerPoolStatsProvider
+	for _, workers := range []interface{}{impl.DrainManager, impl.PodManager} {
+		if provider, ok := workers.(upgrade.WorkerPoolStatsProvider); ok {
+			providers = append(providers, provider)
+		}
+	}
+	return wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, scenarioWorkersTimeout, true,
+		func(context.Context) (bool, error) {
+			for _, provider := range providers {
+				stats := provider.GetWorkerPoolStats()
+				if stats.QueueDepth > 0 || stats.ActiveWorkers > 0 {
+					return false, nil
+				}
+			}
+			return true, nil
+		})
+}
+
+// podListFieldSelectorReactor returns a reactor listing the pods which match the field selector of the request,
+// e.g. the pods of a node or the running pods
+func podListFieldSelectorReactor(k8sInterface *fake.Clientset) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		restrictions := action.(k8stesting.ListAction).GetListRestrictions()
+		if restrictions.Fields == nil || restrictions.Fields.Empty() {
+			return false, nil, nil
+		}
+		obj, err := k8sInterface.Tracker().List(corev1.SchemeGroupVersion.WithResource("pods"),
+			corev1.SchemeGroupVersion.WithKind("Pod"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		list := obj.(*corev1.PodList)
+		pods := &corev1.PodList{ListMeta: list.ListMeta}
+		for _, pod := range list.Items {
+			podFields := fields.Set{
+				"metadata.name":      pod.Name,
+				"metadata.namespace": pod.Namespace,
+				"spec.nodeName":      pod.Spec.NodeName,
+				"status.phase":       string(pod.Status.Phase),
+			}
+			if restrictions.Labels != nil && !restrictions.Labels.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			if restrictions.Fields.Matches(podFields) {
+				pods.Items = append(pods.Items, pod)
+			}
+		}
+		return true, pods, nil
+	}
+}
+
+// getNodeStates returns the upgrade states of the nodes by node name
+func getNodeStates(ctx context.Context, k8sInterface kubernetes.Interface,
+	storage upgrade.NodeUpgradeStateStorage) (map[string]string, error) {
+	nodes, err := k8sInterface.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]string, len(nodes.Items))
+	for i := range nodes.Items {
+		states[nodes.Items[i].Name] = storage.GetState(&nodes.Items[i])
+	}
+	return states, nil
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package upgradetest provides fixtures of common cluster topologies for the tests of the upgrade library
+// and of the operators using it.
+package upgradetest
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
+)
+
+const (
+	// DriverNamespace is the namespace of the driver DaemonSet and pods of the fixtures
+	DriverNamespace = "driver-namespace"
+	// DriverDaemonSetName is the name of the driver DaemonSet of the fixtures
+	DriverDaemonSetName = "driver"
+	// GPUResource is the node resource of the GPU nodes of the fixtures
+	GPUResource = corev1.ResourceName("nvidia.com/gpu")
+	// GPUPresentLabelKey is the label of the GPU nodes of the fixtures
+	GPUPresentLabelKey = "nvidia.com/gpu.present"
+	// ZoneCount is the count of zones of the ThreeZoneCluster fixture
+	ZoneCount = 3
+	// NodesPerZone is the count of nodes in each zone of the ThreeZoneCluster fixture
+	NodesPerZone = 100
+)
+
+// driverLabels returns the labels of the driver DaemonSet and pods of the fixtures
+func driverLabels() map[string]string {
+	return map[string]string{"app": DriverDaemonSetName}
+}
+
+// NodeOption customizes the nodes of a fixture
+type NodeOption func(node *corev1.Node)
+
+// InZone places the node in the topology zone
+func InZone(zone string) NodeOption {
+	return func(node *corev1.Node) {
+		node.Labels[corev1.LabelTopologyZone] = zone
+	}
+}
+
+// WithUpgradeState sets the upgrade state label of the node
+func WithUpgradeState(state string) NodeOption {
+	return func(node *corev1.Node) {
+		node.Labels[upgrade.GetUpgradeStateLabelKey()] = state
+	}
+}
+
+// WithLabel sets a label of the node
+func WithLabel(key, value string) NodeOption {
+	return func(node *corev1.Node) {
+		node.Labels[key] = value
+	}
+}
+
+// WithGPUs sets the GPU capacity of the node
+func WithGPUs(count int64) NodeOption {
+	return func(node *corev1.Node) {
+		quantity := *resource.NewQuantity(count, resource.DecimalSI)
+		node.Labels[GPUPresentLabelKey] = "true"
+		node.Status.Capacity = corev1.ResourceList{GPUResource: quantity}
+		node.Status.Allocatable = corev1.ResourceList{GPUResource: quantity}
+	}
+}
+
+// SkipUpgrade labels the node to skip driver upgrades
+func SkipUpgrade() NodeOption {
+	return WithLabel(upgrade.GetUpgradeSkipNodeLabelKey(), "true")
+}
+
+// Cordoned marks the node unschedulable
+func Cordoned() NodeOption {
+	return func(node *corev1.Node) {
+		node.Spec.Unschedulable = true
+	}
+}
+
+// NotReady sets the Ready condition of the node to false
+func NotReady() NodeOption {
+	return func(node *corev1.Node) {
+		for i := range node.Status.Conditions {
+			if node.Status.Conditions[i].Type == corev1.NodeReady {
+				node.Status.Conditions[i].Status = corev1.ConditionFalse
+			}
+		}
+	}
+}
+
+// Cluster is a fixture of a cluster topology. The nodes with the driver are managed for driver upgrades,
+// the other nodes are only part of the cluster, e.g. to count them in the cluster wide limits.
+type Cluster struct {
+	nodes   []*corev1.Node
+	drivers map[string]bool
+}
+
+// NewCluster creates an empty cluster fixture
+func NewCluster() *Cluster {
+	return &Cluster{drivers: make(map[string]bool)}
+}
+
+// AddNodes adds count Ready nodes with the driver, named <prefix>-<index>
+func (c *Cluster) AddNodes(prefix string, count int, opts ...NodeOption) *Cluster {
+	for _, node := range c.addNodes(prefix, count, opts) {
+		c.drivers[node.Name] = true
+	}
+	return c
+}
+
+// AddNodesWithoutDriver adds count Ready nodes without the driver, named <prefix>-<index>
+func (c *Cluster) AddNodesWithoutDriver(prefix string, count int, opts ...NodeOption) *Cluster {
+	c.addNodes(prefix, count, opts)
+	return c
+}
+
+func (c *Cluster) addNodes(prefix string, count int, opts []NodeOption) []*corev1.Node {
+	nodes := make([]*corev1.Node, 0, count)
+	for i := 0; i < count; i++ {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fmt.Sprintf("%s-%d", prefix, i),
+				Labels:      map[string]string{corev1.LabelHostname: fmt.Sprintf("%s-%d", prefix, i)},
+				Annotations: map[string]string{},
+			},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		for _, opt := range opts {
+			opt(node)
+		}
+		nodes = append(nodes, node)
+	}
+	c.nodes = append(c.nodes, nodes...)
+	return nodes
+}
+
+// Nodes returns all the nodes of the cluster
+func (c *Cluster) Nodes() []*corev1.Node {
+	return c.nodes
+}
+
+// ManagedNodes returns the nodes with the driver
+func (c *Cluster) ManagedNodes() []*corev1.Node {
+	nodes := make([]*corev1.Node, 0, len(c.drivers))
+	for _, node := range c.nodes {
+		if c.drivers[node.Name] {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// DriverDaemonSet returns the driver DaemonSet of the cluster
+func (c *Cluster) DriverDaemonSet() *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DriverDaemonSetName,
+			Namespace: DriverNamespace,
+			Labels:    driverLabels(),
+			UID:       types.UID(DriverNamespace + "-" + DriverDaemonSetName),
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: driverLabels()},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: driverLabels()},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: DriverDaemonSetName, Image: DriverDaemonSetName}},
+				},
+			},
+		},
+		Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: int32(len(c.drivers))},
+	}
+}
+
+// driverPod returns the running driver pod of the node
+func driverPod(node *corev1.Node, ds *appsv1.DaemonSet) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", ds.Name, node.Name),
+			Namespace: ds.Namespace,
+			Labels:    driverLabels(),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "DaemonSet",
+				Name:       ds.Name,
+				UID:        ds.UID,
+				Controller: ptr.To(true),
+			}},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   node.Name,
+			Containers: ds.Spec.Template.Spec.Containers,
+		},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{Name: DriverDaemonSetName, Ready: true}},
+		},
+	}
+}
+
+// UpgradeState returns the upgrade state of the nodes with the driver, grouped by their upgrade state label.
+// The node states refer to the nodes returned by Nodes, so the changes done by the tested code are visible to both.
+func (c *Cluster) UpgradeState() *upgrade.ClusterUpgradeState {
+	state := upgrade.NewClusterUpgradeState()
+	ds := c.DriverDaemonSet()
+	upgradeStateLabel := upgrade.GetUpgradeStateLabelKey()
+	for _, node := range c.ManagedNodes() {
+		nodeState := &upgrade.NodeUpgradeState{Node: node, DriverPod: driverPod(node, ds), DriverDaemonSet: ds}
+		nodeStateLabel := node.Labels[upgradeStateLabel]
+		state.NodeStates[nodeStateLabel] = append(state.NodeStates[nodeStateLabel], nodeState)
+	}
+	return &state
+}
+
+// Objects returns the nodes, the driver DaemonSet and the driver pods of the cluster, e.g. to create them
+// with a fake client. The objects are copies of the fixture.
+func (c *Cluster) Objects() []client.Object {
+	ds := c.DriverDaemonSet()
+	objects := []client.Object{ds}
+	for _, node := range c.nodes {
+		objects = append(objects, node.DeepCopy())
+		if c.drivers[node.Name] {
+			objects = append(objects, driverPod(node, ds))
+		}
+	}
+	return objects
+}
+
+// SingleNodeCluster returns a cluster with a single GPU node with the driver
+func SingleNodeCluster(opts ...NodeOption) *Cluster {
+	return NewCluster().AddNodes("node", 1, append([]NodeOption{WithGPUs(8)}, opts...)...)
+}
+
+// MultiZoneCluster returns a cluster with nodesPerZone GPU nodes with the driver in each of the zones,
+// named zone-<index>
+func MultiZoneCluster(zones, nodesPerZone int, opts ...NodeOption) *Cluster {
+	cluster := NewCluster()
+	for i := 0; i < zones; i++ {
+		zone := fmt.Sprintf("zone-%d", i)
+		cluster.AddNodes(zone+"-node", nodesPerZone, append([]NodeOption{WithGPUs(8), InZone(zone)}, opts...)...)
+	}
+	return cluster
+}
+
+// ThreeZoneCluster returns a cluster of 300 GPU nodes with the driver, spread evenly across 3 zones
+func ThreeZoneCluster(opts ...NodeOption) *Cluster {
+	return MultiZoneCluster(ZoneCount, NodesPerZone, opts...)
+}
+
+// MixedGPUCluster returns a cluster of gpuNodes GPU nodes with the driver and cpuNodes nodes without GPUs
+// and without the driver
+func MixedGPUCluster(gpuNodes, cpuNodes int, opts ...NodeOption) *Cluster {
+	return NewCluster().
+		AddNodes("gpu-node", gpuNodes, append([]NodeOption{WithGPUs(8)}, opts...)...).
+		AddNodesWithoutDriver("cpu-node", cpuNodes, opts...)
+}
@@ -84,53 +84,202 @@ func (m *KeyedMutex) Lock(key string) UnlockFunc {
 	return func() { mtx.Unlock() }
 }
 
-var (
-	// DriverName is the name of the driver to be managed by this package
-	DriverName string
-)
+// DriverName is the name of the driver to be managed by this package
+var DriverName string
 
 // SetDriverName sets the name of the driver managed by the upgrade package
 func SetDriverName(driver string) {
 	DriverName = driver
 }
 
-// GetUpgradeStateLabelKey returns state label key used for upgrades
+// formatKey returns the key of the format for the driver, with the given prefix instead of DefaultKeyPrefix
+func formatKey(prefix, keyFmt string) string {
+	return prefix + strings.TrimPrefix(fmt.Sprintf(keyFmt, DriverName), DefaultKeyPrefix)
+}
+
+// GetUpgradeStateLabelKey returns the key with the default key prefix, see UpgradeKeys
 func GetUpgradeStateLabelKey() string {
-	return fmt.Sprintf(UpgradeStateLabelKeyFmt, DriverName)
+	return UpgradeKeys{}.GetUpgradeStateLabelKey()
+}
+
+// GetUpgradeStateAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeStateAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeStateAnnotationKey()
+}
+
+// GetUpgradeStateTaintKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeStateTaintKey() string {
+	return UpgradeKeys{}.GetUpgradeStateTaintKey()
+}
+
+// GetUpgradeStateStoredLabelKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeStateStoredLabelKey() string {
+	return UpgradeKeys{}.GetUpgradeStateStoredLabelKey()
+}
+
+// GetUpgradeImpactAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeImpactAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeImpactAnnotationKey()
+}
+
+// GetUpgradeHistoryAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeHistoryAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeHistoryAnnotationKey()
 }
 
-// GetUpgradeSkipNodeLabelKey returns node label used to skip upgrades
+// GetUpgradeSkipNodeLabelKey returns the key with the default key prefix, see UpgradeKeys
 func GetUpgradeSkipNodeLabelKey() string {
-	return fmt.Sprintf(UpgradeSkipNodeLabelKeyFmt, DriverName)
+	return UpgradeKeys{}.GetUpgradeSkipNodeLabelKey()
+}
+
+// GetUpgradeNodeWeightLabelKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeNodeWeightLabelKey() string {
+	return UpgradeKeys{}.GetUpgradeNodeWeightLabelKey()
 }
 
-// GetUpgradeDriverWaitForSafeLoadAnnotationKey returns the key for annotation used to mark node as waiting for driver
-// safe load
+// GetUpgradeDriverWaitForSafeLoadAnnotationKey returns the key with the default key prefix, see UpgradeKeys
 func GetUpgradeDriverWaitForSafeLoadAnnotationKey() string {
-	return fmt.Sprintf(UpgradeWaitForSafeDriverLoadAnnotationKeyFmt, DriverName)
+	return UpgradeKeys{}.GetUpgradeDriverWaitForSafeLoadAnnotationKey()
 }
 
-// GetUpgradeRequestedAnnotationKey returns the key for annotation used to mark node as driver upgrade is requested
-// externally (orphaned pod)
+// GetUpgradeRequestedAnnotationKey returns the key with the default key prefix, see UpgradeKeys
 func GetUpgradeRequestedAnnotationKey() string {
-	return fmt.Sprintf(UpgradeRequestedAnnotationKeyFmt, DriverName)
+	return UpgradeKeys{}.GetUpgradeRequestedAnnotationKey()
 }
 
-// GetUpgradeInitialStateAnnotationKey returns the key for annotation used to track initial state of the node
+// GetUpgradeInitialStateAnnotationKey returns the key with the default key prefix, see UpgradeKeys
 func GetUpgradeInitialStateAnnotationKey() string {
-	return fmt.Sprintf(UpgradeInitialStateAnnotationKeyFmt, DriverName)
+	return UpgradeKeys{}.GetUpgradeInitialStateAnnotationKey()
 }
 
-// GetWaitForPodCompletionStartTimeAnnotationKey returns the key for annotation used to track start time for waiting on
-// pod/job completions
+// GetWaitForPodCompletionStartTimeAnnotationKey returns the key with the default key prefix, see UpgradeKeys
 func GetWaitForPodCompletionStartTimeAnnotationKey() string {
-	return fmt.Sprintf(UpgradeWaitForPodCompletionStartTimeAnnotationKeyFmt, DriverName)
+	return UpgradeKeys{}.GetWaitForPodCompletionStartTimeAnnotationKey()
+}
+
+// GetWaitForPodCompletionRunningPodsAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetWaitForPodCompletionRunningPodsAnnotationKey() string {
+	return UpgradeKeys{}.GetWaitForPodCompletionRunningPodsAnnotationKey()
 }
 
-// GetValidationStartTimeAnnotationKey returns the key for annotation indicating start time for validation-required
-// state
+// GetValidationStartTimeAnnotationKey returns the key with the default key prefix, see UpgradeKeys
 func GetValidationStartTimeAnnotationKey() string {
-	return fmt.Sprintf(UpgradeValidationStartTimeAnnotationKeyFmt, DriverName)
+	return UpgradeKeys{}.GetValidationStartTimeAnnotationKey()
+}
+
+// GetNodeReadyWaitStartTimeAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetNodeReadyWaitStartTimeAnnotationKey() string {
+	return UpgradeKeys{}.GetNodeReadyWaitStartTimeAnnotationKey()
+}
+
+// GetUncordonGateStartTimeAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUncordonGateStartTimeAnnotationKey() string {
+	return UpgradeKeys{}.GetUncordonGateStartTimeAnnotationKey()
+}
+
+// GetValidatorsStartTimeAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetValidatorsStartTimeAnnotationKey() string {
+	return UpgradeKeys{}.GetValidatorsStartTimeAnnotationKey()
+}
+
+// GetRebootBootIDAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetRebootBootIDAnnotationKey() string {
+	return UpgradeKeys{}.GetRebootBootIDAnnotationKey()
+}
+
+// GetRebootStartTimeAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetRebootStartTimeAnnotationKey() string {
+	return UpgradeKeys{}.GetRebootStartTimeAnnotationKey()
+}
+
+// GetUpgradeStateStartTimeAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeStateStartTimeAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeStateStartTimeAnnotationKey()
+}
+
+// GetUpgradeRetryAttemptsAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeRetryAttemptsAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeRetryAttemptsAnnotationKey()
+}
+
+// GetUpgradeRetryStartTimeAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeRetryStartTimeAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeRetryStartTimeAnnotationKey()
+}
+
+// GetUpgradeDowngradeAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeDowngradeAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeDowngradeAnnotationKey()
+}
+
+// GetUpgradeDowngradeApprovedAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeDowngradeApprovedAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeDowngradeApprovedAnnotationKey()
+}
+
+// GetUpgradeDrainApprovedAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeDrainApprovedAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeDrainApprovedAnnotationKey()
+}
+
+// GetUpgradeDrainTimeoutAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeDrainTimeoutAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeDrainTimeoutAnnotationKey()
+}
+
+// GetUpgradeDrainForceAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeDrainForceAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeDrainForceAnnotationKey()
+}
+
+// GetUpgradeDrainDeleteEmptyDirAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeDrainDeleteEmptyDirAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeDrainDeleteEmptyDirAnnotationKey()
+}
+
+// GetUpgradeDrainLeaseAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeDrainLeaseAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeDrainLeaseAnnotationKey()
+}
+
+// GetUpgradeDrainStatusAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeDrainStatusAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeDrainStatusAnnotationKey()
+}
+
+// GetUpgradeCanarySoakStartTimeAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeCanarySoakStartTimeAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeCanarySoakStartTimeAnnotationKey()
+}
+
+// GetUpgradeNodeHookLabelKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeNodeHookLabelKey() string {
+	return UpgradeKeys{}.GetUpgradeNodeHookLabelKey()
+}
+
+// GetUpgradeManualUncordonAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeManualUncordonAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeManualUncordonAnnotationKey()
+}
+
+// GetUpgradeFailedNodeCordonAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeFailedNodeCordonAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeFailedNodeCordonAnnotationKey()
+}
+
+// GetUpgradeSlotsAvailableAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeSlotsAvailableAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeSlotsAvailableAnnotationKey()
+}
+
+// GetUpgradeNextEligibleNodesAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeNextEligibleNodesAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeNextEligibleNodesAnnotationKey()
+}
+
+// GetUpgradeStateReasonAnnotationKey returns the key with the default key prefix, see UpgradeKeys
+func GetUpgradeStateReasonAnnotationKey() string {
+	return UpgradeKeys{}.GetUpgradeStateReasonAnnotationKey()
 }
 
 // GetEventReason returns the reason type based on the driver name
@@ -141,7 +290,7 @@ func GetEventReason() string {
 func logEventf(recorder record.EventRecorder, object runtime.Object, eventType string, reason string, messageFmt string,
 	args ...interface{}) {
 	if recorder != nil {
-		recorder.Eventf(object, eventType, reason, messageFmt, args, nil)
+		recorder.Eventf(object, eventType, reason, messageFmt, args...)
 	}
 }
 
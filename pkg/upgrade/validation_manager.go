@@ -21,6 +21,7 @@ import (
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
@@ -42,6 +43,9 @@ type ValidationManagerImpl struct {
 
 	// podSelector indicates the pod performing validation on the node after a driver upgrade
 	podSelector string
+	// podTemplate, if set, is the template of the validation pod run on the node by the validation manager
+	podTemplate *corev1.PodTemplateSpec
+	keys        UpgradeKeys
 }
 
 // ValidationManager is an interface for validating driver upgrades
@@ -67,8 +71,19 @@ func NewValidationManager(
 	return mgr
 }
 
+// WithPodTemplate provides an option to run the validation pod from the template on every node under validation,
+// instead of waiting for the validation pods identified via podSelector. The pod is pinned to the node with
+// PinPodSpecToNode and created in the namespace of the template.
+func (m *ValidationManagerImpl) WithPodTemplate(template *corev1.PodTemplateSpec) *ValidationManagerImpl {
+	m.podTemplate = template
+	return m
+}
+
 // Validate checks if the validation pod(s), identified via podSelector, is Ready
 func (m *ValidationManagerImpl) Validate(ctx context.Context, node *corev1.Node) (bool, error) {
+	if m.podTemplate != nil {
+		return m.validateWithPod(ctx, node)
+	}
 	if m.podSelector == "" {
 		return true, nil
 	}
@@ -104,7 +119,7 @@ func (m *ValidationManagerImpl) Validate(ctx context.Context, node *corev1.Node)
 			break
 		}
 		// remove annotation used for tracking state time
-		annotationKey := GetValidationStartTimeAnnotationKey()
+		annotationKey := m.keys.GetValidationStartTimeAnnotationKey()
 		err = m.nodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, "null")
 		if err != nil {
 			m.log.V(consts.LogLevelError).Error(err, "Failed to remove annotation used to track validation completion",
@@ -115,6 +130,79 @@ func (m *ValidationManagerImpl) Validate(ctx context.Context, node *corev1.Node)
 	return done, nil
 }
 
+// getValidationPodName returns the name of the validation pod run on the node
+func getValidationPodName(node *corev1.Node) string {
+	return fmt.Sprintf("%s-driver-upgrade-validation-%s", DriverName, node.Name)
+}
+
+// validateWithPod creates the validation pod from the template on the node if it doesn't exist yet and returns true
+// once it is Ready. The pod is deleted when the validation succeeds or times out, so that it runs again on the next
+// upgrade of the node.
+func (m *ValidationManagerImpl) validateWithPod(ctx context.Context, node *corev1.Node) (bool, error) {
+	if m.podTemplate.Namespace == "" {
+		return false, fmt.Errorf("namespace of the validation pod template is not set")
+	}
+	name := getValidationPodName(node)
+	pods := m.k8sInterface.CoreV1().Pods(m.podTemplate.Namespace)
+	pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		pod = &corev1.Pod{ObjectMeta: *m.podTemplate.ObjectMeta.DeepCopy(), Spec: *m.podTemplate.Spec.DeepCopy()}
+		pod.Name = name
+		pod.GenerateName = ""
+		m.keys.PinPodSpecToNode(&pod.Spec, node.Name)
+		m.log.V(consts.LogLevelInfo).Info("Creating validation pod", "node", node.Name, "pod", name)
+		_, err = pods.Create(ctx, pod, metav1.CreateOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to create validation pod on node %s: %v", node.Name, err)
+		}
+		return false, m.handleTimeout(ctx, node, int64(validationTimeoutSeconds))
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get validation pod on node %s: %v", node.Name, err)
+	}
+
+	if !m.isPodReady(*pod) {
+		err = m.handleTimeout(ctx, node, int64(validationTimeoutSeconds))
+		if err != nil {
+			return false, fmt.Errorf("unable to handle timeout for validation state: %v", err)
+		}
+		if _, waiting := node.Annotations[m.keys.GetValidationStartTimeAnnotationKey()]; !waiting {
+			// the validation timed out, the node moved to the upgrade-failed state
+			return false, m.deleteValidationPod(ctx, node, name)
+		}
+		return false, nil
+	}
+	if err = m.deleteValidationPod(ctx, node, name); err != nil {
+		return false, err
+	}
+	annotationKey := m.keys.GetValidationStartTimeAnnotationKey()
+	err = m.nodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, "null")
+	if err != nil {
+		m.log.V(consts.LogLevelError).Error(err, "Failed to remove annotation used to track validation completion",
+			"node", node.Name, "annotation", annotationKey)
+		return false, err
+	}
+	return true, nil
+}
+
+// cleanupValidationPod deletes the validation pod run on the node from the pod template, if any
+func (m *ValidationManagerImpl) cleanupValidationPod(ctx context.Context, node *corev1.Node) error {
+	if m.podTemplate == nil {
+		return nil
+	}
+	return m.deleteValidationPod(ctx, node, getValidationPodName(node))
+}
+
+// deleteValidationPod deletes the validation pod of the node, if it exists
+func (m *ValidationManagerImpl) deleteValidationPod(ctx context.Context, node *corev1.Node, name string) error {
+	m.log.V(consts.LogLevelInfo).Info("Deleting validation pod", "node", node.Name, "pod", name)
+	err := m.k8sInterface.CoreV1().Pods(m.podTemplate.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete validation pod on node %s: %v", node.Name, err)
+	}
+	return nil
+}
+
 func (m *ValidationManagerImpl) isPodReady(pod corev1.Pod) bool {
 	if pod.Status.Phase != corev1.PodRunning {
 		m.log.V(consts.LogLevelDebug).Info("Pod not Running", "pod", pod.Name, "podPhase", pod.Status.Phase)
@@ -137,7 +225,7 @@ func (m *ValidationManagerImpl) isPodReady(pod corev1.Pod) bool {
 
 // HandleTimeoutOnPodCompletions transitions node based on the timeout for job completions on the node
 func (m *ValidationManagerImpl) handleTimeout(ctx context.Context, node *corev1.Node, timeoutSeconds int64) error {
-	annotationKey := GetValidationStartTimeAnnotationKey()
+	annotationKey := m.keys.GetValidationStartTimeAnnotationKey()
 	currentTime := time.Now().Unix()
 	// check if annotation already exists for tracking start time
 	if _, present := node.Annotations[annotationKey]; !present {
@@ -25,7 +25,12 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
 	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
 )
 
@@ -169,4 +174,98 @@ var _ = Describe("ValidationManager", func() {
 		Expect(err).To(Succeed())
 		Expect(isValidationAnnotationPresent(node)).To(Equal(false))
 	})
+
+	It("Validate() should run the validation pod from the template on the node and delete it once it is Ready", func() {
+		provider := upgrade.NewNodeUpgradeStateProvider(k8sClient, log, eventRecorder)
+		template := &corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Labels: map[string]string{"app": "validator"}},
+			Spec:       NewPod("", namespace.Name, "").Spec,
+		}
+		validationManager := upgrade.NewValidationManager(k8sInterface, log, eventRecorder, provider, "").
+			WithPodTemplate(template)
+		validationDone, err := validationManager.Validate(ctx, node)
+		Expect(err).To(Succeed())
+		Expect(validationDone).To(Equal(false))
+		Expect(isValidationAnnotationPresent(node)).To(Equal(true))
+
+		pod := &corev1.Pod{}
+		podName := types.NamespacedName{Namespace: namespace.Name,
+			Name: fmt.Sprintf("%s-driver-upgrade-validation-%s", upgrade.DriverName, node.Name)}
+		Expect(k8sClient.Get(ctx, podName, pod)).To(Succeed())
+		createdObjects = append(createdObjects, pod)
+		Expect(pod.Labels).To(Equal(map[string]string{"app": "validator"}))
+		terms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+		Expect(terms).To(HaveLen(1))
+		Expect(terms[0].MatchFields).To(Equal([]corev1.NodeSelectorRequirement{
+			{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{node.Name}}}))
+		Expect(pod.Spec.Tolerations).To(ContainElement(upgrade.GetUpgradeStateToleration()))
+
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Ready: true}}
+		Expect(updatePodStatus(pod)).To(Succeed())
+		validationDone, err = validationManager.Validate(ctx, node)
+		Expect(err).To(Succeed())
+		Expect(validationDone).To(Equal(true))
+		Expect(isValidationAnnotationPresent(node)).To(Equal(false))
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, podName, &corev1.Pod{}))).To(BeTrue())
+	})
+})
+
+var _ = Describe("PinPodSpecToNode", func() {
+	It("should restrict the pod spec to the node and tolerate the cordon", func() {
+		pod := NewPod("hook", "default", "").Pod
+		zoneRequirement := corev1.NodeSelectorRequirement{
+			Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"zone-a"}}
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{zoneRequirement}},
+				{MatchExpressions: []corev1.NodeSelectorRequirement{zoneRequirement}},
+			}},
+		}}
+		upgrade.PinPodSpecToNode(&pod.Spec, "node-a")
+		upgrade.PinPodSpecToNode(&pod.Spec, "node-a")
+
+		nodeRequirement := corev1.NodeSelectorRequirement{
+			Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"node-a"}}
+		for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			Expect(term.MatchExpressions).To(Equal([]corev1.NodeSelectorRequirement{zoneRequirement}))
+			Expect(term.MatchFields).To(ContainElement(nodeRequirement))
+		}
+		Expect(pod.Spec.Tolerations).To(ConsistOf(
+			corev1.Toleration{Key: corev1.TaintNodeUnschedulable, Operator: corev1.TolerationOpExists,
+				Effect: corev1.TaintEffectNoSchedule},
+			upgrade.GetUpgradeStateToleration()))
+	})
+})
+
+var _ = Describe("ApplyHelperWorkloadSpec", func() {
+	It("should apply the scheduling settings of the upgrade policy to the helper pod spec", func() {
+		existingToleration := corev1.Toleration{Key: "existing", Operator: corev1.TolerationOpExists}
+		pod := NewPod("validation", "default", "node").WithResource("cpu", "50m").Pod
+		pod.Spec.Tolerations = []corev1.Toleration{existingToleration}
+		pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "init"}}
+
+		upgrade.ApplyHelperWorkloadSpec(&pod.Spec, &v1alpha1.HelperWorkloadSpec{
+			PriorityClassName: "system-node-critical",
+			Tolerations: []corev1.Toleration{existingToleration, {
+				Key:      "node.kubernetes.io/memory-pressure",
+				Operator: corev1.TolerationOpExists,
+				Effect:   corev1.TaintEffectNoSchedule,
+			}},
+			NodeSelector: map[string]string{"nvidia.com/gpu.present": "true"},
+			Resources: &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+			},
+		})
+
+		Expect(pod.Spec.PriorityClassName).To(Equal("system-node-critical"))
+		Expect(pod.Spec.Tolerations).To(HaveLen(2))
+		Expect(pod.Spec.Tolerations[1].Key).To(Equal("node.kubernetes.io/memory-pressure"))
+		Expect(pod.Spec.NodeSelector).To(Equal(map[string]string{"nvidia.com/gpu.present": "true"}))
+		for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+			Expect(container.Resources.Requests.Memory().String()).To(Equal("64Mi"))
+		}
+		// the resources not set by the policy are kept
+		Expect(pod.Spec.Containers[0].Resources.Limits.Cpu().String()).To(Equal("50m"))
+	})
 })
@@ -0,0 +1,285 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+
+	v1alpha1 "github.com/NVIDIA/k8s-operator-libs/api/upgrade/v1alpha1"
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// ValidationPhase is the phase of the validation of a node by a Validator
+type ValidationPhase string
+
+const (
+	// ValidationPhasePending means the validation of the node is still running
+	ValidationPhasePending ValidationPhase = "Pending"
+	// ValidationPhaseSucceeded means the upgraded driver of the node is valid
+	ValidationPhaseSucceeded ValidationPhase = "Succeeded"
+	// ValidationPhaseFailed means the upgraded driver of the node is not valid
+	ValidationPhaseFailed ValidationPhase = "Failed"
+)
+
+// ValidationResult is the result of the validation of a node by a Validator
+type ValidationResult struct {
+	Phase ValidationPhase
+	// Message describes the failure of the validation
+	Message string
+}
+
+// Validator validates the upgraded driver of a node in the validation-required state, e.g. by running a GPU
+// smoke test or an SR-IOV VF check as a Job on the node. Validate is called on every pass until the validation
+// of the node succeeds, fails or times out, Cleanup is then called so that the validation runs again on the next
+// upgrade of the node.
+type Validator interface {
+	// Name returns the name of the validator, used in the events and the names of the validation resources
+	Name() string
+	// Validate starts the validation of the node, if it's not started yet, and returns its result
+	Validate(ctx context.Context, node *corev1.Node) (ValidationResult, error)
+	// Cleanup removes the validation resources of the node, if any
+	Cleanup(ctx context.Context, node *corev1.Node) error
+}
+
+// JobValidator is a Validator running a Job from a template on every node under validation
+type JobValidator struct {
+	k8sInterface kubernetes.Interface
+	name         string
+	template     *batchv1.JobTemplateSpec
+	keys         UpgradeKeys
+}
+
+// NewJobValidator returns a Validator running a Job from the template on every node under validation.
+// The pods of the Job are pinned to the node with PinPodSpecToNode, the node is valid once the Job is complete
+// and invalid if the Job failed. The Job is created in the namespace of the template, which must be set.
+func NewJobValidator(k8sInterface kubernetes.Interface, name string, template *batchv1.JobTemplateSpec) *JobValidator {
+	return &JobValidator{k8sInterface: k8sInterface, name: name, template: template}
+}
+
+func (v *JobValidator) setKeys(keys UpgradeKeys) {
+	v.keys = keys
+}
+
+// Name implements Validator
+func (v *JobValidator) Name() string {
+	return v.name
+}
+
+// Validate implements Validator
+func (v *JobValidator) Validate(ctx context.Context, node *corev1.Node) (ValidationResult, error) {
+	if v.template.Namespace == "" {
+		return ValidationResult{}, fmt.Errorf("namespace of the validation Job template is not set")
+	}
+	name := getNodeJobName(v.name, node)
+	jobs := v.k8sInterface.BatchV1().Jobs(v.template.Namespace)
+	job, err := jobs.Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		job = &batchv1.Job{ObjectMeta: *v.template.ObjectMeta.DeepCopy(), Spec: *v.template.Spec.DeepCopy()}
+		job.Name = name
+		job.GenerateName = ""
+		v.keys.PinPodSpecToNode(&job.Spec.Template.Spec, node.Name)
+		_, err = jobs.Create(ctx, job, metav1.CreateOptions{})
+		if err != nil {
+			return ValidationResult{}, fmt.Errorf("failed to create validation Job on node %s: %v", node.Name, err)
+		}
+		return ValidationResult{Phase: ValidationPhasePending}, nil
+	}
+	if err != nil {
+		return ValidationResult{}, fmt.Errorf("failed to get validation Job on node %s: %v", node.Name, err)
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return ValidationResult{Phase: ValidationPhaseSucceeded}, nil
+		case batchv1.JobFailed:
+			return ValidationResult{Phase: ValidationPhaseFailed,
+				Message: fmt.Sprintf("validation Job %s failed: %s", name, condition.Message)}, nil
+		}
+	}
+	return ValidationResult{Phase: ValidationPhasePending}, nil
+}
+
+// Cleanup implements Validator, the Job is deleted with its pods
+func (v *JobValidator) Cleanup(ctx context.Context, node *corev1.Node) error {
+	name := getNodeJobName(v.name, node)
+	err := v.k8sInterface.BatchV1().Jobs(v.template.Namespace).Delete(ctx, name,
+		metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationBackground)})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete validation Job on node %s: %v", node.Name, err)
+	}
+	return nil
+}
+
+// maxJobNameLength is the maximum length of a Job name, which is also the value of the job-name label of its pods
+const maxJobNameLength = 63
+
+// getNodeJobName returns the name of a Job run on the node, e.g. by a validator. Names longer than a label
+// value allows are shortened with a hash of the full name.
+func getNodeJobName(jobName string, node *corev1.Node) string {
+	name := fmt.Sprintf("%s-driver-upgrade-%s-%s", DriverName, jobName, node.Name)
+	if len(name) <= maxJobNameLength {
+		return name
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	suffix := fmt.Sprintf("-%08x", hash.Sum32())
+	return name[:maxJobNameLength-len(suffix)] + suffix
+}
+
+// policyJobValidatorName is the name of the validator running the validation Job of the upgrade policy
+const policyJobValidatorName = "validation"
+
+// WithValidators provides an option to enable the optional 'validation' state and validate the upgraded nodes
+// with the validators, in addition to the validation pods, if any. The validators must all succeed within
+// the timeout of the validation spec of the upgrade policy, 600 seconds by default.
+func (m *ClusterUpgradeStateManagerImpl) WithValidators(validators ...Validator) ClusterUpgradeStateManager {
+	m.validators = append(m.validators, validators...)
+	m.validationStateEnabled = true
+	m.propagateKeys()
+	return m
+}
+
+// isValidationRequired returns true if the upgraded nodes are moved to the validation-required state,
+// i.e. validation is enabled on the manager or by the validation Job of the upgrade policy
+func (m *ClusterUpgradeStateManagerImpl) isValidationRequired(spec *v1alpha1.ValidationSpec) bool {
+	return m.IsValidationEnabled() || (spec != nil && spec.JobTemplate != nil)
+}
+
+// getValidators returns the validators of the manager and the validator of the validation Job of the upgrade
+// policy, if any
+func (m *ClusterUpgradeStateManagerImpl) getValidators(spec *v1alpha1.ValidationSpec) []Validator {
+	if spec == nil || spec.JobTemplate == nil {
+		return m.validators
+	}
+	validators := make([]Validator, 0, len(m.validators)+1)
+	validators = append(validators, m.validators...)
+	policyValidator := NewJobValidator(m.K8sInterface, policyJobValidatorName, spec.JobTemplate)
+	policyValidator.keys = m.keys
+	return append(validators, policyValidator)
+}
+
+// runValidators runs the validators on the node and returns true once they all succeeded, or once they failed
+// if the failure policy ignores the failures. A node whose validation failed or timed out is moved to
+// the upgrade-failed state otherwise.
+func (m *ClusterUpgradeStateManagerImpl) runValidators(ctx context.Context, node *corev1.Node,
+	validators []Validator, spec *v1alpha1.ValidationSpec) (bool, error) {
+	if len(validators) == 0 {
+		return true, nil
+	}
+	annotationKey := m.keys.GetValidatorsStartTimeAnnotationKey()
+	currentTime := time.Now().Unix()
+	if _, present := node.Annotations[annotationKey]; !present {
+		// add the annotation to track start time
+		err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey,
+			strconv.FormatInt(currentTime, 10))
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to add annotation to track validators",
+				"node", node.Name, "annotation", annotationKey)
+			return false, err
+		}
+	}
+
+	done := true
+	failure := ""
+	for _, validator := range validators {
+		result, err := validator.Validate(ctx, node)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to validate driver upgrade", "node", node.Name,
+				"validator", validator.Name())
+			return false, err
+		}
+		if result.Phase == ValidationPhaseFailed {
+			failure = fmt.Sprintf("validator %s failed: %s", validator.Name(), result.Message)
+			break
+		}
+		if result.Phase != ValidationPhaseSucceeded {
+			m.Log.V(consts.LogLevelDebug).Info("Validation is pending", "node", node.Name,
+				"validator", validator.Name())
+			done = false
+		}
+	}
+	if failure == "" && !done {
+		startTime, err := strconv.ParseInt(node.Annotations[annotationKey], 10, 64)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(err, "Failed to convert start time to track validators",
+				"node", node.Name)
+			return false, err
+		}
+		timeoutSeconds := getValidatorsTimeoutSeconds(spec)
+		if currentTime <= startTime+int64(timeoutSeconds) {
+			return false, nil
+		}
+		failure = fmt.Sprintf("validation did not complete within %d seconds", timeoutSeconds)
+	}
+
+	// the validation is over, it runs again on the next upgrade of the node
+	for _, validator := range validators {
+		if err := validator.Cleanup(ctx, node); err != nil {
+			return false, err
+		}
+	}
+	err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeAnnotation(ctx, node, annotationKey, nullString)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(err, "Failed to remove annotation used to track validators",
+			"node", node.Name, "annotation", annotationKey)
+		return false, err
+	}
+	if failure == "" {
+		return true, nil
+	}
+	if spec != nil && spec.FailurePolicy == v1alpha1.ValidationFailurePolicyIgnore {
+		m.Log.V(consts.LogLevelWarning).Info("Validation failed, ignored by the failure policy", "node", node.Name,
+			"failure", failure)
+		logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+			"Validation of the driver upgrade failed, ignored by the failure policy: %s", failure)
+		return true, nil
+	}
+	m.Log.V(consts.LogLevelInfo).Info("Validation failed, moving the node to failed state", "node", node.Name,
+		"failure", failure)
+	logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+		"Validation of the driver upgrade failed, moving the node to %s state: %s", UpgradeStateFailed, failure)
+	err = m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateFailed)
+	if err != nil {
+		m.Log.V(consts.LogLevelError).Error(
+			err, "Failed to change node upgrade state", "node", node.Name, "state", UpgradeStateFailed)
+		return false, err
+	}
+	return false, m.keys.setNodeUpgradeStateReason(ctx, m.NodeUpgradeStateProvider, node,
+		UpgradeStateReasonValidationFailed)
+}
+
+// getValidatorsTimeoutSeconds returns the time the validators may take on a node
+func getValidatorsTimeoutSeconds(spec *v1alpha1.ValidationSpec) int {
+	if spec == nil || spec.TimeoutSecond == 0 {
+		return validationTimeoutSeconds
+	}
+	return spec.TimeoutSecond
+}
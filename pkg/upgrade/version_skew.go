@@ -0,0 +1,208 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+// VersionCompatibilityChecker decides whether the driver of a node can be upgraded from its current version
+// to the target version of its driver DaemonSet
+type VersionCompatibilityChecker interface {
+	// CheckVersionCompatibility returns an error explaining why the upgrade of the node driver from currentVersion
+	// to targetVersion is not supported, nil if the upgrade can proceed
+	CheckVersionCompatibility(ctx context.Context, node *corev1.Node, currentVersion, targetVersion string) error
+}
+
+// VersionCompatibilityCheckerFunc is a function implementing VersionCompatibilityChecker
+type VersionCompatibilityCheckerFunc func(ctx context.Context, node *corev1.Node,
+	currentVersion, targetVersion string) error
+
+// CheckVersionCompatibility implements VersionCompatibilityChecker
+func (f VersionCompatibilityCheckerFunc) CheckVersionCompatibility(ctx context.Context, node *corev1.Node,
+	currentVersion, targetVersion string) error {
+	return f(ctx, node, currentVersion, targetVersion)
+}
+
+// majorVersionSkewChecker blocks the upgrades changing the major version by more than maxSkew
+type majorVersionSkewChecker struct {
+	maxSkew int
+}
+
+// NewMajorVersionSkewChecker returns a VersionCompatibilityChecker blocking the upgrades and downgrades changing
+// the major version of the driver, i.e. the number before the first dot with an optional "v" prefix, by more than
+// maxSkew, e.g. from 1.2.0 to 3.0.0 with a maxSkew of 1. Versions without a major number are not blocked.
+func NewMajorVersionSkewChecker(maxSkew int) VersionCompatibilityChecker {
+	return majorVersionSkewChecker{maxSkew: maxSkew}
+}
+
+// CheckVersionCompatibility implements VersionCompatibilityChecker
+func (c majorVersionSkewChecker) CheckVersionCompatibility(_ context.Context, _ *corev1.Node,
+	currentVersion, targetVersion string) error {
+	currentMajor, err := getMajorVersion(currentVersion)
+	if err != nil {
+		return nil
+	}
+	targetMajor, err := getMajorVersion(targetVersion)
+	if err != nil {
+		return nil
+	}
+	if skew := targetMajor - currentMajor; skew > c.maxSkew || -skew > c.maxSkew {
+		return fmt.Errorf("major version skew %d exceeds the maximum of %d", skew, c.maxSkew)
+	}
+	return nil
+}
+
+// getMajorVersion returns the number before the first dot or dash of the version, without the "v" prefix
+func getMajorVersion(version string) (int, error) {
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	major, _, _ = strings.Cut(major, "-")
+	return strconv.Atoi(major)
+}
+
+// versionSkewPolicy is the configuration of the version skew checks set with WithVersionCompatibilityChecker
+type versionSkewPolicy struct {
+	checker VersionCompatibilityChecker
+	// versionLabel is the label of the driver pods containing the driver version, the image tag of their first
+	// container is the version if it's empty
+	versionLabel string
+}
+
+// WithVersionCompatibilityChecker provides an option to block the upgrade of the nodes whose driver can't be
+// upgraded from its current version to the target version of the driver DaemonSet, e.g. a major version jump.
+// The versions are the values of the versionLabel of the driver pod and of the DaemonSet pod template, or the image
+// tags of their first container if versionLabel is empty. A node in upgrade-required state whose upgrade is not
+// supported by the checker is moved to the blocked-by-skew state with a Warning event, and moved back to
+// be re-evaluated once the checker doesn't block it anymore, e.g. after a fix of the target version.
+func (m *ClusterUpgradeStateManagerImpl) WithVersionCompatibilityChecker(checker VersionCompatibilityChecker,
+	versionLabel string) ClusterUpgradeStateManager {
+	m.versionSkewPolicy = &versionSkewPolicy{checker: checker, versionLabel: versionLabel}
+	return m
+}
+
+// getDriverVersion returns the driver version from the labels or the image tag of the first container
+func (p *versionSkewPolicy) getDriverVersion(podLabels map[string]string, containers []corev1.Container) string {
+	if p.versionLabel != "" {
+		return podLabels[p.versionLabel]
+	}
+	if len(containers) == 0 {
+		return ""
+	}
+	return getImageTag(containers[0].Image)
+}
+
+// getImageTag returns the tag of the image reference, an empty string if it has none
+func getImageTag(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	index := strings.LastIndex(image, ":")
+	if index < 0 || index < strings.LastIndex(image, "/") {
+		return ""
+	}
+	return image[index+1:]
+}
+
+// getVersionSkewIssue returns why the upgrade of the node driver to the target version of its DaemonSet is blocked
+// by the VersionCompatibilityChecker, or an empty string if it is not blocked. The upgrades of the driver pods
+// without a DaemonSet or without a version are not checked.
+func (m *ClusterUpgradeStateManagerImpl) getVersionSkewIssue(ctx context.Context, nodeState *NodeUpgradeState) string {
+	if m.versionSkewPolicy == nil || nodeState.DriverPod == nil || nodeState.DriverDaemonSet == nil {
+		return ""
+	}
+	currentVersion := m.versionSkewPolicy.getDriverVersion(nodeState.DriverPod.Labels,
+		nodeState.DriverPod.Spec.Containers)
+	template := nodeState.DriverDaemonSet.Spec.Template
+	targetVersion := m.versionSkewPolicy.getDriverVersion(template.Labels, template.Spec.Containers)
+	if currentVersion == "" || targetVersion == "" || currentVersion == targetVersion {
+		return ""
+	}
+	err := m.versionSkewPolicy.checker.CheckVersionCompatibility(ctx, nodeState.Node, currentVersion, targetVersion)
+	if err == nil {
+		return ""
+	}
+	return fmt.Sprintf("the upgrade from driver version %s to %s is not supported: %v", currentVersion,
+		targetVersion, err)
+}
+
+// blockVersionSkewNodes moves the nodes in the upgrade-required state whose upgrade is not supported by
+// the VersionCompatibilityChecker to the blocked-by-skew state and removes them from the upgrade-required state.
+// Nodes marked for skipping upgrades are kept.
+func (m *ClusterUpgradeStateManagerImpl) blockVersionSkewNodes(ctx context.Context,
+	currentClusterState *ClusterUpgradeState) (*ClusterUpgradeState, error) {
+	nodeStates := currentClusterState.NodeStates[UpgradeStateUpgradeRequired]
+	if m.versionSkewPolicy == nil || len(nodeStates) == 0 {
+		return currentClusterState, nil
+	}
+
+	compatible := make([]*NodeUpgradeState, 0, len(nodeStates))
+	for _, nodeState := range nodeStates {
+		node := nodeState.Node
+		issue := m.getVersionSkewIssue(ctx, nodeState)
+		if issue == "" || m.skipNodeUpgrade(node) {
+			compatible = append(compatible, nodeState)
+			continue
+		}
+		m.Log.V(consts.LogLevelInfo).Info("Node upgrade is blocked by the version skew", "node", node.Name,
+			"issue", issue)
+		err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateBlockedBySkew)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(
+				err, "Failed to change node upgrade state", "node", node.Name, "state", UpgradeStateBlockedBySkew)
+			return nil, err
+		}
+		logEventf(m.EventRecorder, node, corev1.EventTypeWarning, GetEventReason(),
+			"Node upgrade is blocked, %s", issue)
+	}
+
+	compatibleState := NewClusterUpgradeState()
+	for state, states := range currentClusterState.NodeStates {
+		compatibleState.NodeStates[state] = states
+	}
+	compatibleState.NodeStates[UpgradeStateUpgradeRequired] = compatible
+	return &compatibleState, nil
+}
+
+// processBlockedBySkewNodes moves the nodes in the blocked-by-skew state whose upgrade is not blocked by
+// the VersionCompatibilityChecker anymore to the unknown state, to re-evaluate their upgrade
+func (m *ClusterUpgradeStateManagerImpl) processBlockedBySkewNodes(ctx context.Context,
+	currentClusterState *ClusterUpgradeState) error {
+	m.Log.V(consts.LogLevelInfo).Info("ProcessBlockedBySkewNodes")
+
+	for _, nodeState := range currentClusterState.NodeStates[UpgradeStateBlockedBySkew] {
+		node := nodeState.Node
+		if issue := m.getVersionSkewIssue(ctx, nodeState); issue != "" {
+			m.Log.V(consts.LogLevelDebug).Info("Node upgrade is still blocked by the version skew", "node", node.Name,
+				"issue", issue)
+			continue
+		}
+		m.Log.V(consts.LogLevelInfo).Info("Node upgrade is not blocked by the version skew anymore, "+
+			"re-evaluating upgrade state", "node", node.Name)
+		err := m.NodeUpgradeStateProvider.ChangeNodeUpgradeState(ctx, node, UpgradeStateUnknown)
+		if err != nil {
+			m.Log.V(consts.LogLevelError).Error(
+				err, "Failed to change node upgrade state", "node", node.Name, "state", UpgradeStateUnknown)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/consts"
+)
+
+const (
+	// WorkerPoolDrain is the name of the DrainManager worker pool which drains nodes
+	WorkerPoolDrain = "drain"
+	// WorkerPoolPodEviction is the name of the PodManager worker pool which deletes workload pods on nodes
+	WorkerPoolPodEviction = "pod-eviction"
+)
+
+// WorkerPoolStats describes the state of the background workers of a manager
+type WorkerPoolStats struct {
+	// QueueDepth is the count of nodes scheduled for processing which were not picked up by a worker yet
+	QueueDepth int
+	// ActiveWorkers is the count of workers currently processing a node
+	ActiveWorkers int
+	// OldestItemAge is the time since the oldest node, queued or being processed, was scheduled.
+	// It is 0 if the pool is idle.
+	OldestItemAge time.Duration
+}
+
+// WorkerPoolStatsProvider is implemented by managers which process nodes in background workers
+type WorkerPoolStatsProvider interface {
+	GetWorkerPoolStats() WorkerPoolStats
+}
+
+// workerPoolTracker keeps track of the nodes scheduled to and processed by background workers
+type workerPoolTracker struct {
+	mutex sync.Mutex
+	// queued and active map node names to the time they were scheduled
+	queued map[string]time.Time
+	active map[string]time.Time
+}
+
+// newWorkerPoolTracker creates an empty workerPoolTracker
+func newWorkerPoolTracker() *workerPoolTracker {
+	return &workerPoolTracker{
+		queued: make(map[string]time.Time),
+		active: make(map[string]time.Time),
+	}
+}
+
+// enqueue records that the node was scheduled for processing
+func (t *workerPoolTracker) enqueue(nodeName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.queued[nodeName] = time.Now()
+}
+
+// start records that a worker picked up the node
+func (t *workerPoolTracker) start(nodeName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	scheduled, ok := t.queued[nodeName]
+	if !ok {
+		scheduled = time.Now()
+	}
+	delete(t.queued, nodeName)
+	t.active[nodeName] = scheduled
+}
+
+// done records that the worker finished processing the node
+func (t *workerPoolTracker) done(nodeName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.queued, nodeName)
+	delete(t.active, nodeName)
+}
+
+// stats returns the current WorkerPoolStats
+func (t *workerPoolTracker) stats() WorkerPoolStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	stats := WorkerPoolStats{QueueDepth: len(t.queued), ActiveWorkers: len(t.active)}
+	now := time.Now()
+	for _, items := range []map[string]time.Time{t.queued, t.active} {
+		for _, scheduled := range items {
+			if age := now.Sub(scheduled); age > stats.OldestItemAge {
+				stats.OldestItemAge = age
+			}
+		}
+	}
+	return stats
+}
+
+// GetWorkerPoolStats returns the state of the background workers of the DrainManager and the PodManager
+// by worker pool name. Managers which don't implement WorkerPoolStatsProvider, e.g. mocks, are not included.
+func (m *ClusterUpgradeStateManagerImpl) GetWorkerPoolStats() map[string]WorkerPoolStats {
+	stats := make(map[string]WorkerPoolStats)
+	if provider, ok := m.DrainManager.(WorkerPoolStatsProvider); ok {
+		stats[WorkerPoolDrain] = provider.GetWorkerPoolStats()
+	}
+	if provider, ok := m.PodManager.(WorkerPoolStatsProvider); ok {
+		stats[WorkerPoolPodEviction] = provider.GetWorkerPoolStats()
+	}
+	return stats
+}
+
+// WithMaxDrainWorkers provides an option to limit the count of nodes drained concurrently by the DrainManager,
+// the other nodes scheduled for drain wait in the queue of the drain worker pool. Zero removes the limit.
+func (m *ClusterUpgradeStateManagerImpl) WithMaxDrainWorkers(workers int) ClusterUpgradeStateManager {
+	drainManager, ok := m.DrainManager.(*DrainManagerImpl)
+	if !ok {
+		m.Log.V(consts.LogLevelWarning).Info("Cannot limit the drain workers, the drain manager is not a DrainManagerImpl")
+		return m
+	}
+	drainManager.WithMaxWorkers(workers)
+	return m
+}
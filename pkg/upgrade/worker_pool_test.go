@@ -0,0 +1,61 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/NVIDIA/k8s-operator-libs/pkg/upgrade"
+)
+
+var _ = Describe("Worker pool stats", func() {
+	var stateManager *upgrade.ClusterUpgradeStateManagerImpl
+
+	BeforeEach(func() {
+		stateManagerInterface, err := upgrade.NewClusterUpgradeStateManager(log, k8sConfig, eventRecorder)
+		Expect(err).NotTo(HaveOccurred())
+		stateManager, _ = stateManagerInterface.(*upgrade.ClusterUpgradeStateManagerImpl)
+	})
+
+	It("should report idle drain and pod eviction worker pools", func() {
+		stats := stateManager.GetWorkerPoolStats()
+		Expect(stats).To(HaveLen(2))
+		Expect(stats).To(HaveKeyWithValue(upgrade.WorkerPoolDrain, upgrade.WorkerPoolStats{}))
+		Expect(stats).To(HaveKeyWithValue(upgrade.WorkerPoolPodEviction, upgrade.WorkerPoolStats{}))
+	})
+
+	It("should skip worker pools of managers which don't report stats", func() {
+		stateManager.DrainManager = &drainManager
+		stats := stateManager.GetWorkerPoolStats()
+		Expect(stats).To(HaveLen(1))
+		Expect(stats).To(HaveKey(upgrade.WorkerPoolPodEviction))
+	})
+
+	It("should export worker pool stats as metrics", func() {
+		registry := prometheus.NewRegistry()
+		stateManager.WithMetrics(registry)
+		count, err := testutil.GatherAndCount(registry,
+			"driver_upgrade_worker_pool_queue_depth",
+			"driver_upgrade_worker_pool_active_workers",
+			"driver_upgrade_worker_pool_oldest_item_age_seconds")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count).To(Equal(6))
+	})
+})
@@ -0,0 +1,58 @@
+/*
+Copyright 2024 NVIDIA CORPORATION & AFFILIATES
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// GetNodeZone returns the availability zone of the node from its topology.kubernetes.io/zone label,
+// empty if the label is missing
+func GetNodeZone(node *corev1.Node) string {
+	return node.Labels[corev1.LabelTopologyZone]
+}
+
+// getZoneUpgradeSlots returns the count of node upgrades which can be started in every zone of the cluster state
+// until maxPerZone upgrades are in progress in the zone, nil if maxPerZone is 0, i.e. the zones are not limited.
+// The failed nodes count as upgrades in progress, like for maxParallelUpgrades.
+func getZoneUpgradeSlots(currentState *ClusterUpgradeState, maxPerZone int) map[string]int {
+	if maxPerZone <= 0 {
+		return nil
+	}
+	zoneUpgrades := make(map[string]int)
+	for state, nodeStates := range currentState.NodeStates {
+		inProgress := true
+		switch state {
+		case UpgradeStateUnknown, UpgradeStateDone, UpgradeStateUpgradeRequired, UpgradeStateDaemonSetMissing,
+			UpgradeStateBlockedBySkew:
+			inProgress = false
+		}
+		for _, nodeState := range nodeStates {
+			zone := GetNodeZone(nodeState.Node)
+			if _, ok := zoneUpgrades[zone]; !ok {
+				zoneUpgrades[zone] = maxPerZone
+			}
+			if inProgress {
+				zoneUpgrades[zone]--
+			}
+		}
+	}
+	for zone, upgrades := range zoneUpgrades {
+		zoneUpgrades[zone] = max(upgrades, 0)
+	}
+	return zoneUpgrades
+}